@@ -0,0 +1,105 @@
+package apierror
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Body is the standard error envelope returned by every endpoint.
+type Body struct {
+	Error Detail `json:"error"`
+}
+
+type Detail struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Send writes the error envelope with the given status.
+func Send(c *fiber.Ctx, status int, code, message string) error {
+	return SendDetails(c, status, code, message, nil)
+}
+
+// SendDetails is Send with an extra machine-readable details payload.
+func SendDetails(c *fiber.Ctx, status int, code, message string, details interface{}) error {
+	return c.Status(status).JSON(Body{Error: Detail{
+		Code:    code,
+		Message: message,
+		Details: details,
+	}})
+}
+
+// ShowDetails puts the underlying error in Internal responses instead of
+// the generic message. Only for local development.
+var ShowDetails bool
+
+// Internal logs err with the request id and answers 500 without revealing
+// it, unless ShowDetails is set.
+func Internal(c *fiber.Ctx, action string, err error) error {
+	log.Printf("Error %s (request %s): %v", action, c.GetRespHeader(fiber.HeaderXRequestID), err)
+	message := "an internal error occurred"
+	if ShowDetails {
+		message = action + ": " + err.Error()
+	}
+	return Send(c, fiber.StatusInternalServerError, "INTERNAL_ERROR", message)
+}
+
+// ErrorHandler is the app's fiber error handler. Unknown routes and
+// methods get the envelope with the request id; errors a handler returns
+// unanswered become an Internal response; fiber's other client errors keep
+// its default handling.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var ferr *fiber.Error
+	if !errors.As(err, &ferr) || ferr.Code >= fiber.StatusInternalServerError {
+		return Internal(c, "handling request", err)
+	}
+	requestID := fiber.Map{"requestId": c.GetRespHeader(fiber.HeaderXRequestID)}
+	switch ferr.Code {
+	case fiber.StatusNotFound:
+		return SendDetails(c, fiber.StatusNotFound, "ROUTE_NOT_FOUND", "no route for "+c.Method()+" "+c.Path(), requestID)
+	case fiber.StatusMethodNotAllowed:
+		allowed := allowedMethods(c.App(), c.Path())
+		c.Set(fiber.HeaderAllow, strings.Join(allowed, ", "))
+		return SendDetails(c, fiber.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED",
+			c.Method()+" is not allowed on "+c.Path()+"; use one of "+strings.Join(allowed, ", "), requestID)
+	}
+	return fiber.DefaultErrorHandler(c, err)
+}
+
+// allowedMethods lists the methods with a route matching path.
+func allowedMethods(app *fiber.App, path string) []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, r := range app.GetRoutes(true) {
+		if !seen[r.Method] && routeMatches(r.Path, path) {
+			seen[r.Method] = true
+			methods = append(methods, r.Method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// routeMatches reports whether path fits the route pattern, where ":name"
+// matches one segment and "*" the rest of the path.
+func routeMatches(pattern, path string) bool {
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	for i, seg := range want {
+		switch {
+		case seg == "*":
+			return true
+		case i >= len(got):
+			return false
+		case strings.HasPrefix(seg, ":"):
+		case seg != got[i]:
+			return false
+		}
+	}
+	return len(want) == len(got)
+}
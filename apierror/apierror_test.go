@@ -0,0 +1,130 @@
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestInternal(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	secret := errors.New("dial tcp db-7.internal:27017: connection refused")
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Get("/answered", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderXRequestID, "req-1")
+		return Internal(c, "reading products", secret)
+	})
+	app.Get("/returned", func(c *fiber.Ctx) error { return secret })
+
+	tests := []struct {
+		target  string
+		details bool
+		message string
+	}{
+		{"/answered", false, "an internal error occurred"},
+		{"/returned", false, "an internal error occurred"},
+		{"/answered", true, "reading products: " + secret.Error()},
+		{"/returned", true, "handling request: " + secret.Error()},
+	}
+	for _, tt := range tests {
+		ShowDetails = tt.details
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, tt.target, nil), -1)
+		ShowDetails = false
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		var body Body
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Fatalf("GET %s: %v: %s", tt.target, err, raw)
+		}
+		if resp.StatusCode != fiber.StatusInternalServerError || body.Error.Code != "INTERNAL_ERROR" || body.Error.Message != tt.message {
+			t.Errorf("GET %s with details %t = %d %s, want 500 %q", tt.target, tt.details, resp.StatusCode, raw, tt.message)
+		}
+	}
+	if !strings.Contains(logged.String(), "Error reading products (request req-1): "+secret.Error()) {
+		t.Errorf("the error was not logged with its request id:\n%s", logged.String())
+	}
+}
+
+func TestErrorHandlerRoutes(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderXRequestID, "req-1")
+		return c.Next()
+	})
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) }
+	app.Get("/products", ok)
+	app.Post("/products", ok)
+	app.Get("/products/:id", ok)
+	app.Delete("/products/:id", ok)
+	app.Get("/static/*", ok)
+
+	tests := []struct {
+		method, target string
+		status         int
+		code, message  string
+		allow          string
+	}{
+		{fiber.MethodGet, "/productz", fiber.StatusNotFound, "ROUTE_NOT_FOUND", "no route for GET /productz", ""},
+		{fiber.MethodGet, "/products/MT-001/extra", fiber.StatusNotFound, "ROUTE_NOT_FOUND", "no route for GET /products/MT-001/extra", ""},
+		{fiber.MethodPut, "/products", fiber.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "PUT is not allowed on /products; use one of GET, HEAD, POST", "GET, HEAD, POST"},
+		{fiber.MethodPatch, "/products/MT-001", fiber.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "PATCH is not allowed on /products/MT-001; use one of DELETE, GET, HEAD", "DELETE, GET, HEAD"},
+		{fiber.MethodPost, "/static/css/app.css", fiber.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "POST is not allowed on /static/css/app.css; use one of GET, HEAD", "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest(tt.method, tt.target, nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ct := resp.Header.Get(fiber.HeaderContentType); ct != fiber.MIMEApplicationJSON {
+				t.Errorf("Content-Type = %q, want %q", ct, fiber.MIMEApplicationJSON)
+			}
+			if allow := resp.Header.Get(fiber.HeaderAllow); allow != tt.allow {
+				t.Errorf("Allow = %q, want %q", allow, tt.allow)
+			}
+			raw, _ := io.ReadAll(resp.Body)
+			var body Body
+			if err := json.Unmarshal(raw, &body); err != nil {
+				t.Fatalf("%v: %s", err, raw)
+			}
+			details, _ := body.Error.Details.(map[string]interface{})
+			if resp.StatusCode != tt.status || body.Error.Code != tt.code || body.Error.Message != tt.message || details["requestId"] != "req-1" {
+				t.Errorf("%d %s, want %d %s %q with the request id", resp.StatusCode, raw, tt.status, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/products", "/products", true},
+		{"/products", "/products/", true},
+		{"/products", "/product", false},
+		{"/products/:id", "/products/MT-001", true},
+		{"/products/:id", "/products", false},
+		{"/products/:id", "/products/MT-001/brokers", false},
+		{"/static/*", "/static/a/b/c", true},
+		{"/", "/", true},
+	}
+	for _, tt := range tests {
+		if got := routeMatches(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("routeMatches(%q, %q) = %t, want %t", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
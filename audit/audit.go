@@ -0,0 +1,160 @@
+// Package audit records who changed which product, and how.
+package audit
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	ActionCreate = "product.create"
+	ActionUpdate = "product.update"
+	// ActionMove is a product moved into another group by a group merge.
+	ActionMove = "product.move"
+	// ActionScheduledStatus is a scheduled status change the scheduler
+	// applied.
+	ActionScheduledStatus = "product.status.scheduled"
+	// ActionScheduledStatusRejected is a scheduled status change the
+	// scheduler dropped because the product could no longer take it.
+	ActionScheduledStatusRejected = "product.status.scheduled.rejected"
+	// ActionInsurerRename is a finished propagation of an insurer's new
+	// name into the products embedding it.
+	ActionInsurerRename = "insurer.rename"
+	// ActionInsurerUpdate is a change to an insurer's master data entry.
+	ActionInsurerUpdate = "insurer.update"
+	// ActionGroupImport is a product group written by a bundle import.
+	ActionGroupImport = "group.import"
+)
+
+type Entry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Actor     string             `bson:"actor" json:"actor"`
+	Action    string             `bson:"action" json:"action"`
+	ProductID string             `bson:"productId" json:"productId"`
+	GroupKey  string             `bson:"groupKey" json:"groupKey"`
+	// Target names what an action not about a single product applied to,
+	// e.g. "insurer:TIP".
+	Target    string    `bson:"target,omitempty" json:"target,omitempty"`
+	Changes   []Change  `bson:"changes" json:"changes"`
+	RequestID string    `bson:"requestId,omitempty" json:"requestId,omitempty"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// Change is one field that differs between two versions of a product.
+// Nested documents are flattened into dotted field paths.
+type Change struct {
+	Field string      `bson:"field" json:"field"`
+	Old   interface{} `bson:"old" json:"old"`
+	New   interface{} `bson:"new" json:"new"`
+}
+
+// Diff returns the fields of after whose value differs from before. Only
+// keys present in after are compared, so fields a write leaves untouched
+// are never reported as removed. A nil before reports every field.
+func Diff(before, after bson.M) []Change {
+	changes := []Change{}
+	diff("", before, after, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func diff(prefix string, before, after bson.M, out *[]Change) {
+	for k, newVal := range after {
+		field := k
+		if prefix != "" {
+			field = prefix + "." + k
+		}
+		oldVal, had := before[k]
+		oldDoc, oldIsDoc := oldVal.(bson.M)
+		newDoc, newIsDoc := newVal.(bson.M)
+		if oldIsDoc && newIsDoc {
+			diff(field, oldDoc, newDoc, out)
+			continue
+		}
+		if !had {
+			oldVal = nil
+		}
+		if !reflect.DeepEqual(normalize(oldVal), normalize(newVal)) {
+			*out = append(*out, Change{Field: field, Old: oldVal, New: newVal})
+		}
+	}
+}
+
+// normalize makes values read back from Mongo comparable with values built
+// in Go: arrays decode as bson.A and documents as bson.M either way, but an
+// empty array may be nil on one side.
+func normalize(v interface{}) interface{} {
+	if a, ok := v.(bson.A); ok && len(a) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Record inserts entry, stamping its timestamp unless the caller did. Pass
+// the context of an ongoing transaction to make the entry part of the write
+// it describes.
+func Record(ctx context.Context, coll *mongo.Collection, entry Entry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	_, err := coll.InsertOne(ctx, entry)
+	return err
+}
+
+// Apply replays changes onto snapshot, the product as of the previous
+// entry, setting each dotted field path to its new value. Documents read
+// back from the audit log may be bson.D, so they are turned into bson.M
+// on the way in.
+func Apply(snapshot bson.M, changes []Change) {
+	for _, ch := range changes {
+		doc, path := snapshot, strings.Split(ch.Field, ".")
+		for _, key := range path[:len(path)-1] {
+			next, ok := toM(doc[key])
+			if !ok {
+				next = bson.M{}
+			}
+			doc[key] = next
+			doc = next
+		}
+		v := ch.New
+		if m, ok := toM(v); ok {
+			v = m
+		}
+		doc[path[len(path)-1]] = v
+	}
+}
+
+// Revert undoes changes on snapshot, the product as of their entry,
+// setting each field back to its old value, last change first. Applied to
+// the current product entry by entry, newest first, it walks the
+// product's history backwards.
+func Revert(snapshot bson.M, changes []Change) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		Apply(snapshot, []Change{{Field: changes[i].Field, New: changes[i].Old}})
+	}
+}
+
+func toM(v interface{}) (bson.M, bool) {
+	switch v := v.(type) {
+	case bson.M:
+		return v, true
+	case bson.D:
+		m := make(bson.M, len(v))
+		for _, e := range v {
+			if inner, ok := toM(e.Value); ok {
+				m[e.Key] = inner
+			} else {
+				m[e.Key] = e.Value
+			}
+		}
+		return m, true
+	}
+	return nil, false
+}
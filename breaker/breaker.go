@@ -0,0 +1,174 @@
+// Package breaker implements a consecutive-failure circuit breaker.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/metrics"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+var (
+	stateGauge  = metrics.NewGauge("circuit_breaker_state")
+	transitions = metrics.NewCounter("circuit_breaker_transitions")
+	rejected    = metrics.NewCounter("circuit_breaker_rejected")
+)
+
+// ErrOpen is returned by Do without calling fn while the breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+type Config struct {
+	// FailureThreshold consecutive failures open the breaker.
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before letting a probe
+	// through.
+	Cooldown time.Duration
+	// SuccessThreshold consecutive successful probes close it again.
+	SuccessThreshold int
+}
+
+type Breaker struct {
+	name string
+	cfg  Config
+	// IsFailure decides which errors count against the breaker. Errors it
+	// rejects, such as "not found", say nothing about backend health. ctx is
+	// the one the operation ran with, so a deadline can be told apart by who
+	// set it.
+	IsFailure func(ctx context.Context, err error) bool
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	probing   bool
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func New(name string, cfg Config) *Breaker {
+	b := &Breaker{
+		name:      name,
+		cfg:       cfg,
+		IsFailure: func(_ context.Context, err error) bool { return err != nil },
+		now:       time.Now,
+	}
+	stateGauge.Set(int64(Closed), name)
+	return b
+}
+
+// Do runs fn, an operation using ctx, unless the breaker is open. While
+// half-open only one probe runs at a time; concurrent callers are rejected
+// as if it were open.
+func (b *Breaker) Do(ctx context.Context, fn func() error) error {
+	if !b.allow() {
+		rejected.Inc(b.name)
+		return ErrOpen
+	}
+	err := fn()
+	b.record(ctx, err)
+	return err
+}
+
+// RetryAfter is how long until the breaker next lets a request through.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	if d := b.cfg.Cooldown - b.now().Sub(b.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cfg.Cooldown {
+			return false
+		}
+		b.transition(HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := b.IsFailure(ctx, err)
+
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.trip()
+		}
+	case HalfOpen:
+		b.probing = false
+		if failed {
+			b.trip()
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.SuccessThreshold {
+			b.failures = 0
+			b.transition(Closed)
+		}
+	}
+}
+
+func (b *Breaker) trip() {
+	b.openedAt = b.now()
+	b.successes = 0
+	b.transition(Open)
+}
+
+func (b *Breaker) transition(to State) {
+	if b.state == to {
+		return
+	}
+	log.Printf("Circuit breaker %s: %s -> %s", b.name, b.state, to)
+	transitions.Inc(b.name, to.String())
+	stateGauge.Set(int64(to), b.name)
+	b.state = to
+}
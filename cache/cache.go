@@ -0,0 +1,12 @@
+package cache
+
+import "context"
+
+// Cache stores rendered response bodies. Keys live in namespaces (one per
+// tenant) that Invalidate drops as a whole. Implementations treat backend
+// failures as misses: a broken cache must never fail a request.
+type Cache interface {
+	Get(ctx context.Context, namespace, key string) ([]byte, bool)
+	Set(ctx context.Context, namespace, key string, value []byte)
+	Invalidate(ctx context.Context, namespace string)
+}
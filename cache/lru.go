@@ -0,0 +1,105 @@
+// Package cache holds rendered responses for hot read paths.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/metrics"
+)
+
+var (
+	hits      = metrics.NewCounter("cache_hits")
+	misses    = metrics.NewCounter("cache_misses")
+	evictions = metrics.NewCounter("cache_evictions")
+)
+
+// LRU is a size-bounded, TTL-expiring in-memory cache. Keys live in
+// namespaces whose version Invalidate bumps, so a write drops every cached
+// page for its namespace at once without scanning.
+type LRU struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu       sync.Mutex
+	order    *list.List // front is most recently used
+	items    map[string]*list.Element
+	versions map[string]uint64
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:     size,
+		ttl:      ttl,
+		now:      time.Now,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+		versions: map[string]uint64{},
+	}
+}
+
+func (c *LRU) fullKey(namespace, key string) string {
+	return namespace + "|" + strconv.FormatUint(c.versions[namespace], 10) + "|" + key
+}
+
+// Get returns the value cached under key in namespace.
+func (c *LRU) Get(_ context.Context, namespace, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[c.fullKey(namespace, key)]
+	if !ok {
+		misses.Inc(namespace)
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.now().After(e.expires) {
+		c.remove(el)
+		misses.Inc(namespace)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	hits.Inc(namespace)
+	return e.value, true
+}
+
+// Set caches value under key in namespace, evicting the least recently used
+// entry when full.
+func (c *LRU) Set(_ context.Context, namespace, key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := c.fullKey(namespace, key)
+	if el, ok := c.items[k]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, c.now().Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.order.PushFront(&entry{key: k, value: value, expires: c.now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		evictions.Inc(namespace)
+	}
+}
+
+// Invalidate makes every entry in namespace unreachable. Stale entries age
+// out through the LRU order.
+func (c *LRU) Invalidate(_ context.Context, namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[namespace]++
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
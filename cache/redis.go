@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const opTimeout = 200 * time.Millisecond
+
+// Redis is a Cache shared by every replica. Like LRU it versions namespaces:
+// the current version of each namespace lives in Redis, is mirrored in
+// memory, and Invalidate bumps it and announces the new version on a
+// channel so other replicas stop serving the old one immediately.
+type Redis struct {
+	client  *redis.Client
+	prefix  string
+	ttl     time.Duration
+	channel string
+
+	mu       sync.RWMutex
+	versions map[string]int64
+}
+
+// NewRedis starts listening for invalidations from other replicas. The
+// subscription reconnects on its own for as long as ctx lives.
+func NewRedis(ctx context.Context, client *redis.Client, prefix string, ttl time.Duration) *Redis {
+	r := &Redis{
+		client:   client,
+		prefix:   prefix,
+		ttl:      ttl,
+		channel:  prefix + "invalidate",
+		versions: map[string]int64{},
+	}
+	go r.listen(ctx)
+	return r
+}
+
+func (r *Redis) versionKey(namespace string) string {
+	return r.prefix + "version:" + namespace
+}
+
+// version returns the namespace version, reading it from Redis the first
+// time a namespace is seen.
+func (r *Redis) version(ctx context.Context, namespace string) (int64, error) {
+	r.mu.RLock()
+	v, ok := r.versions[namespace]
+	r.mu.RUnlock()
+	if ok {
+		return v, nil
+	}
+	v, err := r.client.Get(ctx, r.versionKey(namespace)).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	r.setVersion(namespace, v)
+	return v, nil
+}
+
+// setVersion only moves versions forward, so a delayed message cannot
+// resurrect pages that were already invalidated.
+func (r *Redis) setVersion(namespace string, v int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v >= r.versions[namespace] {
+		r.versions[namespace] = v
+	}
+}
+
+func (r *Redis) fullKey(ctx context.Context, namespace, key string) (string, error) {
+	v, err := r.version(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	return r.prefix + namespace + ":" + strconv.FormatInt(v, 10) + ":" + key, nil
+}
+
+func (r *Redis) Get(ctx context.Context, namespace, key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	k, err := r.fullKey(ctx, namespace, key)
+	if err != nil {
+		misses.Inc(namespace)
+		return nil, false
+	}
+	value, err := r.client.Get(ctx, k).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Redis cache get failed, treating as miss: %v", err)
+		}
+		misses.Inc(namespace)
+		return nil, false
+	}
+	hits.Inc(namespace)
+	return value, true
+}
+
+func (r *Redis) Set(ctx context.Context, namespace, key string, value []byte) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	k, err := r.fullKey(ctx, namespace, key)
+	if err == nil {
+		err = r.client.Set(ctx, k, value, r.ttl).Err()
+	}
+	if err != nil {
+		log.Printf("Redis cache set failed: %v", err)
+	}
+}
+
+func (r *Redis) Invalidate(ctx context.Context, namespace string) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	v, err := r.client.Incr(ctx, r.versionKey(namespace)).Result()
+	if err != nil {
+		// Entries still expire after the TTL.
+		log.Printf("Redis cache invalidation failed: %v", err)
+		return
+	}
+	r.setVersion(namespace, v)
+	if err := r.client.Publish(ctx, r.channel, namespace+"="+strconv.FormatInt(v, 10)).Err(); err != nil {
+		log.Printf("Redis cache invalidation publish failed: %v", err)
+	}
+}
+
+func (r *Redis) listen(ctx context.Context) {
+	sub := r.client.Subscribe(ctx, r.channel)
+	defer sub.Close()
+	for msg := range sub.Channel() {
+		namespace, raw, ok := strings.Cut(msg.Payload, "=")
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		r.setVersion(namespace, v)
+	}
+}
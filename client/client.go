@@ -0,0 +1,241 @@
+// Package client is a Go client for the product API. Its types mirror the
+// server's JSON and are versioned with it.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the product API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	http       *http.Client
+	token      string
+	tenant     string
+	maxRetries int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithToken sends token as a bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTenant selects the catalogue through X-Tenant.
+func WithTenant(tenant string) Option {
+	return func(c *Client) { c.tenant = tenant }
+}
+
+// WithRetries sets how often a request answered with 429 or 503 is
+// retried, and the first wait between attempts, which doubles each time.
+// Retry-After takes precedence when the server sends it.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.backoff = n, backoff }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		http:       http.DefaultClient,
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is an error envelope returned by the API.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details json.RawMessage
+	// RequestID identifies the request in the server's logs.
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("product api: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the API.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// ListProducts returns one page of products.
+func (c *Client) ListProducts(ctx context.Context, opts ListOptions) (Page, error) {
+	q := url.Values{}
+	if opts.Param != "" {
+		q.Set("param", opts.Param)
+	}
+	if len(opts.SearchFields) > 0 {
+		q.Set("searchFields", strings.Join(opts.SearchFields, ","))
+	}
+	if len(opts.Status) > 0 {
+		q.Set("status", strings.Join(opts.Status, ","))
+	}
+	if opts.MissingInsurer {
+		q.Set("missing", "insurer")
+	}
+	if opts.Code != "" {
+		q.Set("code", opts.Code)
+	}
+	if opts.Group != "" {
+		q.Set("group", opts.Group)
+	}
+	if opts.MinBrokers != nil {
+		q.Set("minBrokers", strconv.Itoa(*opts.MinBrokers))
+	}
+	if opts.MaxBrokers != nil {
+		q.Set("maxBrokers", strconv.Itoa(*opts.MaxBrokers))
+	}
+	if opts.Page > 0 {
+		q.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Collation != "" {
+		q.Set("collation", opts.Collation)
+	}
+	if opts.Strict {
+		q.Set("strict", "true")
+	}
+	var page Page
+	err := c.do(ctx, http.MethodGet, "/products/?"+q.Encode(), nil, nil, &page)
+	return page, err
+}
+
+// GetProduct returns the product with the given id.
+func (c *Client) GetProduct(ctx context.Context, id string) (Product, error) {
+	var p Product
+	err := c.do(ctx, http.MethodGet, "/products/"+url.PathEscape(id), nil, nil, &p)
+	return p, err
+}
+
+// GetProducts returns the products with the given ids, at most 100, in one
+// call.
+func (c *Client) GetProducts(ctx context.Context, ids []string) (Lookup, error) {
+	q := url.Values{}
+	q.Set("ids", strings.Join(ids, ","))
+	var l Lookup
+	err := c.do(ctx, http.MethodGet, "/products/?"+q.Encode(), nil, nil, &l)
+	return l, err
+}
+
+// CreateProduct creates a product. A non-empty idempotencyKey makes the
+// call safe to repeat: the server replays the first response.
+func (c *Client) CreateProduct(ctx context.Context, in ProductInput, idempotencyKey string) (Product, error) {
+	header := http.Header{}
+	if idempotencyKey != "" {
+		header.Set("Idempotency-Key", idempotencyKey)
+	}
+	var p Product
+	err := c.do(ctx, http.MethodPost, "/products/", header, in, &p)
+	return p, err
+}
+
+// UpdateProduct replaces the product with the given id.
+func (c *Client) UpdateProduct(ctx context.Context, id string, in ProductInput) (Product, error) {
+	var p Product
+	err := c.do(ctx, http.MethodPut, "/products/"+url.PathEscape(id), nil, in, &p)
+	return p, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	// Writes without an idempotency key must not be repeated.
+	retryable := method == http.MethodGet || header.Get("Idempotency-Key") != ""
+
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.tenant != "" {
+			req.Header.Set("X-Tenant", c.tenant)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		if retryable && attempt < c.maxRetries &&
+			(resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+			delay := wait
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+				delay = time.Duration(s) * time.Second
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			wait *= 2
+			continue
+		}
+		return decode(resp, out)
+	}
+}
+
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{Status: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+		var envelope struct {
+			Error struct {
+				Code    string          `json:"code"`
+				Message string          `json:"message"`
+				Details json.RawMessage `json:"details"`
+			} `json:"error"`
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(raw, &envelope) == nil && envelope.Error.Code != "" {
+			apiErr.Code, apiErr.Message, apiErr.Details = envelope.Error.Code, envelope.Error.Message, envelope.Error.Details
+		} else {
+			apiErr.Code, apiErr.Message = http.StatusText(resp.StatusCode), strings.TrimSpace(string(raw))
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,292 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/client"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/MaMaTidarat/poc-app/routes"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// These tests run the client against the app itself, served with app.Test
+// instead of a listener.
+
+const (
+	viewerKey = "client-viewer-key"
+	editorKey = "client-editor-key"
+)
+
+// appTransport sends requests to app, authenticated with key as a gateway
+// in front of the API would.
+type appTransport struct {
+	app      *fiber.App
+	key      string
+	requests []*http.Request
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-API-Key", t.key)
+	t.requests = append(t.requests, req)
+	return t.app.Test(req, -1)
+}
+
+// newClient returns a client of an app serving repo as the "retail"
+// tenant, and the transport recording its requests; before runs ahead of
+// every route.
+func newClient(t *testing.T, repo *mocks.ProductRepository, key string, opts []client.Option, before ...fiber.Handler) (*client.Client, *appTransport) {
+	t.Helper()
+	database.RegisterTenants("retail", &database.Tenant{Name: "retail", Repo: repo})
+	cfg := config.Config{
+		HTTP:       config.HTTPConfig{QueryTimeout: 5 * time.Second, MaxQueryTimeout: 10 * time.Second, MaxBodyBytes: 64 * 1024},
+		Pagination: config.PaginationConfig{DefaultLimit: 20, MaxLimit: 100},
+		Search:     config.SearchConfig{MaxLength: 100},
+		Mongo:      config.MongoConfig{MaxTime: 5 * time.Second, Collation: "simple"},
+	}
+	maintenance := middleware.NewMaintenance(middleware.MaintenanceState{})
+	h := handlers.New(cfg, handlers.Deps{
+		Repos:       func(string) database.ProductRepository { return repo },
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Maintenance: maintenance,
+	})
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.ErrorHandler, DisableStartupMessage: true})
+	app.Use(middleware.RequestID())
+	for _, handler := range before {
+		app.Use(handler)
+	}
+	auth := middleware.Auth(middleware.AuthConfig{APIKeys: []middleware.APIKey{
+		{Name: "viewer", Key: viewerKey, Roles: []string{middleware.RoleViewer}},
+		{Name: "editor", Key: editorKey, Roles: []string{middleware.RoleEditor}},
+	}})
+	routes.SetupRoutes(app, cfg, h, auth, maintenance)
+
+	transport := &appTransport{app: app, key: key}
+	opts = append([]client.Option{client.WithHTTPClient(&http.Client{Transport: transport})}, opts...)
+	return client.New("http://api.test", opts...), transport
+}
+
+func motorGroup() bson.M {
+	return bson.M{
+		"key":         "MOTOR-1",
+		"name":        "ประกันรถยนต์ชั้น 1",
+		"productType": bson.M{"key": "MOTOR", "name": "Motor"},
+		"productList": bson.A{
+			bson.M{
+				"id":            "MT-001",
+				"productCode":   "MOTOR-1-VIR-0001",
+				"productName":   "Motor Plus",
+				"insurer":       bson.M{"_id": "INS-VIR", "insurerCode": "VIR", "insurerName": "Viriyah"},
+				"brokers":       bson.A{bson.M{"key": "BROKER-ONLINE", "channelName": "Online"}},
+				"productStatus": "ACTIVE",
+			},
+		},
+	}
+}
+
+var motorPlus = client.Product{
+	ID:           "MT-001",
+	Code:         "MOTOR-1-VIR-0001",
+	ProductName:  "Motor Plus",
+	ProductGroup: client.ProductGroup{Name: "ประกันรถยนต์ชั้น 1", Key: "MOTOR-1"},
+	ProductType:  client.ProductType{Name: "Motor", Key: "MOTOR"},
+	Insurer:      client.Insurer{ID: "INS-VIR", InsurerCode: "VIR", InsurerName: "Viriyah"},
+	Brokers:      []client.Broker{{Key: "BROKER-ONLINE", ChannelName: "Online"}},
+	Status:       "ACTIVE",
+}
+
+func TestListProducts(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{motorGroup()}, Total: 1}
+	c, transport := newClient(t, repo, viewerKey, nil)
+	one := 1
+	page, err := c.ListProducts(context.Background(), client.ListOptions{
+		Param:      "motor",
+		Status:     []string{"ACTIVE", "DRAFT"},
+		Group:      "MOTOR-*",
+		MinBrokers: &one,
+		Page:       1,
+		Limit:      10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.TotalCount != 1 || len(page.Data) != 1 || !reflect.DeepEqual(page.Data[0], motorPlus) {
+		t.Errorf("page = %+v, want MT-001", page)
+	}
+
+	want := map[string]string{"param": "motor", "status": "ACTIVE,DRAFT", "group": "MOTOR-*", "minBrokers": "1", "page": "1", "limit": "10"}
+	got := map[string]string{}
+	for k, v := range transport.requests[0].URL.Query() {
+		got[k] = v[0]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("query = %v, want %v", got, want)
+	}
+}
+
+func TestGetProduct(t *testing.T) {
+	c, _ := newClient(t, &mocks.ProductRepository{FindOneDoc: motorGroup()}, viewerKey, nil)
+	p, err := c.GetProduct(context.Background(), "MT-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, motorPlus) {
+		t.Errorf("product = %+v, want %+v", p, motorPlus)
+	}
+}
+
+func TestGetProducts(t *testing.T) {
+	// The lookup's aggregation returns products already flattened.
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{"id": "MT-001", "productName": "Motor Plus"}}}
+	c, _ := newClient(t, repo, viewerKey, nil)
+	l, err := c.GetProducts(context.Background(), []string{"MT-404", "MT-001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Data) != 1 || l.Data[0].ID != "MT-001" || !reflect.DeepEqual(l.NotFound, []string{"MT-404"}) {
+		t.Errorf("lookup = %+v, want MT-001 found and MT-404 not", l)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		opts   []client.Option
+		call   func(*client.Client) error
+		status int
+		code   string
+	}{
+		{"not found", viewerKey, nil, func(c *client.Client) error {
+			_, err := c.GetProduct(context.Background(), "MT-404")
+			return err
+		}, http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+		{"unknown tenant", viewerKey, []client.Option{client.WithTenant("wholesale")}, func(c *client.Client) error {
+			_, err := c.ListProducts(context.Background(), client.ListOptions{})
+			return err
+		}, http.StatusBadRequest, "INVALID_TENANT"},
+		{"forbidden write", viewerKey, nil, func(c *client.Client) error {
+			_, err := c.CreateProduct(context.Background(), client.ProductInput{}, "")
+			return err
+		}, http.StatusForbidden, "FORBIDDEN"},
+		{"invalid product", editorKey, nil, func(c *client.Client) error {
+			_, err := c.UpdateProduct(context.Background(), "MT-001", client.ProductInput{Status: "ACTIVE"})
+			return err
+		}, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newClient(t, &mocks.ProductRepository{}, tt.key, tt.opts)
+			err := tt.call(c)
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want a *client.Error", err)
+			}
+			if apiErr.Status != tt.status || apiErr.Code != tt.code || apiErr.Message == "" {
+				t.Errorf("error = %+v, want %d %s", apiErr, tt.status, tt.code)
+			}
+			if apiErr.RequestID == "" {
+				t.Errorf("error has no request id")
+			}
+			if client.IsNotFound(err) != (tt.status == http.StatusNotFound) {
+				t.Errorf("IsNotFound(%v) = %t", err, !(tt.status == http.StatusNotFound))
+			}
+		})
+	}
+
+	// A validation failure carries every violation in Details.
+	c, _ := newClient(t, &mocks.ProductRepository{}, editorKey, nil)
+	_, err := c.UpdateProduct(context.Background(), "MT-001", client.ProductInput{})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || len(apiErr.Details) == 0 {
+		t.Errorf("err = %v, want the violations in Details", err)
+	}
+}
+
+// unavailable answers the first n requests with status, then lets the
+// rest through; calls counts every request.
+func unavailable(n int32, status int, calls *int32) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if atomic.AddInt32(calls, 1) <= n {
+			return apierror.Send(c, status, "UNAVAILABLE", "try again")
+		}
+		return c.Next()
+	}
+}
+
+func TestRetries(t *testing.T) {
+	retries := []client.Option{client.WithRetries(2, time.Millisecond)}
+	list := func(c *client.Client) error {
+		_, err := c.ListProducts(context.Background(), client.ListOptions{})
+		return err
+	}
+	tests := []struct {
+		name      string
+		failures  int32
+		status    int
+		call      func(*client.Client) error
+		wantCalls int32
+		wantErr   int
+	}{
+		{"503 then success", 2, http.StatusServiceUnavailable, list, 3, 0},
+		{"429 then success", 1, http.StatusTooManyRequests, list, 2, 0},
+		{"retries exhausted", 5, http.StatusServiceUnavailable, list, 3, http.StatusServiceUnavailable},
+		{"other errors are not retried", 5, http.StatusBadGateway, list, 1, http.StatusBadGateway},
+		{"writes without a key are not retried", 5, http.StatusServiceUnavailable, func(c *client.Client) error {
+			_, err := c.CreateProduct(context.Background(), client.ProductInput{}, "")
+			return err
+		}, 1, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			repo := &mocks.ProductRepository{FindDocs: []interface{}{motorGroup()}, Total: 1}
+			c, _ := newClient(t, repo, editorKey, retries, unavailable(tt.failures, tt.status, &calls))
+			err := tt.call(c)
+			if calls != tt.wantCalls {
+				t.Errorf("%d requests, want %d", calls, tt.wantCalls)
+			}
+			var apiErr *client.Error
+			switch {
+			case tt.wantErr == 0 && err != nil:
+				t.Errorf("err = %v, want success", err)
+			case tt.wantErr != 0 && (!errors.As(err, &apiErr) || apiErr.Status != tt.wantErr):
+				t.Errorf("err = %v, want %d", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestRetryHonoursContext cancels the call while it waits out a
+// Retry-After.
+func TestRetryHonoursContext(t *testing.T) {
+	throttled := func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderRetryAfter, "60")
+		return apierror.Send(c, fiber.StatusTooManyRequests, "RATE_LIMITED", "slow down")
+	}
+	c, _ := newClient(t, &mocks.ProductRepository{}, viewerKey, nil, throttled)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.ListProducts(ctx, client.ListOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %s, want it to stop waiting with the context", elapsed)
+	}
+}
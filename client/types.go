@@ -0,0 +1,117 @@
+package client
+
+import "time"
+
+// Product is a product as returned by the API.
+type Product struct {
+	ID           string       `json:"id"`
+	Code         string       `json:"code,omitempty"`
+	ProductName  string       `json:"productName"`
+	ProductGroup ProductGroup `json:"productGroup"`
+	ProductType  ProductType  `json:"productType"`
+	Insurer      Insurer      `json:"insurer"`
+	Brokers      []Broker     `json:"brokers"`
+	Status       string       `json:"status"`
+	CreatedAt    *time.Time   `json:"createdAt,omitempty"`
+	UpdatedAt    *time.Time   `json:"updatedAt,omitempty"`
+	CreatedBy    string       `json:"createdBy,omitempty"`
+	UpdatedBy    string       `json:"updatedBy,omitempty"`
+}
+
+type ProductGroup struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type ProductType struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type Insurer struct {
+	ID          string `json:"_id"`
+	InsurerCode string `json:"insurerCode"`
+	InsurerName string `json:"insurerName"`
+}
+
+type Broker struct {
+	Key         string `json:"key"`
+	ChannelName string `json:"channelName"`
+}
+
+// Warning reports a stored entry the listing could not map.
+type Warning struct {
+	GroupKey string `json:"groupKey"`
+	Index    int    `json:"index"`
+	Reason   string `json:"reason"`
+}
+
+// Page is one page of a product listing.
+type Page struct {
+	// TotalCount is estimated when TotalCountExact is false.
+	TotalCount      int64     `json:"totalCount"`
+	TotalCountExact bool      `json:"totalCountExact"`
+	Data            []Product `json:"data"`
+	Warnings        []Warning `json:"warnings,omitempty"`
+	// LastModified is the latest update of the listing's products.
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	// Suggestions are spelling suggestions for a search that found nothing.
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Lookup is the answer to GetProducts.
+type Lookup struct {
+	// Data is in the order the ids were asked for.
+	Data     []Product `json:"data"`
+	NotFound []string  `json:"notFound"`
+}
+
+// ListOptions select a page of products. Zero values are left to the
+// server's defaults.
+type ListOptions struct {
+	// Param is the free-text search.
+	Param string
+	// SearchFields narrows Param to these fields: productType,
+	// productGroup, productName, insurerCode or brokers.
+	SearchFields []string
+	Status       []string
+	// MissingInsurer keeps only products without an insurer code.
+	MissingInsurer bool
+	// Code looks a product up by its generated code.
+	Code string
+	// Group keeps only the products of the group with this key.
+	Group string
+	// MinBrokers and MaxBrokers, when set, bound the number of brokers.
+	MinBrokers *int
+	MaxBrokers *int
+	Page       int
+	Limit      int
+	// Collation is th, en or simple.
+	Collation string
+	// Strict fails the listing instead of skipping malformed entries.
+	Strict bool
+}
+
+// ProductInput is the body of CreateProduct and UpdateProduct.
+type ProductInput struct {
+	ProductName  string            `json:"productName"`
+	ProductGroup ProductGroupInput `json:"productGroup"`
+	Insurer      InsurerInput      `json:"insurer"`
+	Brokers      []BrokerInput     `json:"brokers"`
+	Status       string            `json:"status"`
+}
+
+type ProductGroupInput struct {
+	Key string `json:"key"`
+}
+
+type InsurerInput struct {
+	ID          string `json:"_id,omitempty"`
+	InsurerCode string `json:"insurerCode"`
+	InsurerName string `json:"insurerName,omitempty"`
+}
+
+type BrokerInput struct {
+	Key         string `json:"key"`
+	ChannelName string `json:"channelName,omitempty"`
+}
@@ -0,0 +1,152 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The subcommands that read the catalogue run against the integration-test
+// database named by MONGO_TEST_URI:
+//
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test -tags integration ./cmd/admin
+
+// seed points the configuration at a fresh database holding the fixtures,
+// dropped when the test ends.
+func seed(t *testing.T) []interface{} {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	name := fmt.Sprintf("admin_test_%d", time.Now().UnixNano())
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	t.Setenv("MONGO_URI", uri)
+	t.Setenv("TENANTS", "it="+name)
+	t.Setenv("TENANT_DEFAULT", "it")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.ConnectDB(cfg.Mongo); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := fixtures.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tenant := database.DefaultTenant()
+	t.Cleanup(func() { tenant.Products.Database().Drop(ctx) })
+	if _, err := tenant.Products.InsertMany(ctx, groups); err != nil {
+		t.Fatal(err)
+	}
+	return groups
+}
+
+// runJSON runs command and decodes its report into v.
+func runJSON(t *testing.T, command string, v interface{}) {
+	t.Helper()
+	var out bytes.Buffer
+	if err := run(command, nil, "", &out); err != nil {
+		t.Fatalf("%s: %v", command, err)
+	}
+	if err := json.Unmarshal(out.Bytes(), v); err != nil {
+		t.Fatalf("%s: %v: %s", command, err, out.Bytes())
+	}
+}
+
+func TestEnsureIndexesIntegration(t *testing.T) {
+	seed(t)
+	var first, second database.IndexReport
+	runJSON(t, "ensure-indexes", &first)
+	runJSON(t, "ensure-indexes", &second)
+	if len(first.Created) == 0 {
+		t.Errorf("the first run created no indexes: %+v", first)
+	}
+	if len(second.Created) != 0 || len(second.Present) < len(first.Created) {
+		t.Errorf("the second run = %+v, want every index present and none created", second)
+	}
+}
+
+func TestStatsIntegration(t *testing.T) {
+	want := map[string]float64{}
+	for _, g := range seed(t) {
+		items, _ := g.(bson.M)["productList"].(bson.A)
+		for _, item := range items {
+			if p, ok := item.(bson.M); ok {
+				status, _ := p["productStatus"].(string)
+				want[status]++
+			}
+		}
+	}
+	var buckets []struct {
+		ID    string  `json:"_id"`
+		Count float64 `json:"count"`
+	}
+	runJSON(t, "stats", &buckets)
+	got := map[string]float64{}
+	for _, b := range buckets {
+		got[b.ID] = b.Count
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %v, want %v", got, want)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].Count > buckets[i-1].Count {
+			t.Errorf("stats are not largest first: %+v", buckets)
+		}
+	}
+}
+
+func TestVerifyIntegration(t *testing.T) {
+	seed(t)
+	ctx := context.Background()
+	tenant := database.DefaultTenant()
+	// Break the catalogue in each way verify reports.
+	_, err := tenant.Products.InsertMany(ctx, []interface{}{
+		bson.M{"key": "BROKEN-1", "productList": bson.A{"not a document"}},
+		bson.M{"key": "BROKEN-2", "productList": bson.A{bson.M{"productName": "no id", "insurer": bson.M{"insurerCode": "AIA"}}}},
+		bson.M{"key": "BROKEN-3", "productList": bson.A{bson.M{"id": "DUP-1"}, bson.M{"id": "DUP-1", "insurer": bson.M{"insurerCode": "AIA"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var broken, clean database.VerifyReport
+	runJSON(t, "verify", &broken)
+	if _, err := tenant.Products.DeleteMany(ctx, bson.M{"key": bson.M{"$regex": "^BROKEN-"}}); err != nil {
+		t.Fatal(err)
+	}
+	runJSON(t, "verify", &clean)
+
+	if d := broken.Malformed - clean.Malformed; d != 1 {
+		t.Errorf("malformed rose by %d, want 1", d)
+	}
+	if d := broken.MissingID - clean.MissingID; d != 1 {
+		t.Errorf("missingId rose by %d, want 1", d)
+	}
+	if d := broken.MissingInsurer - clean.MissingInsurer; d != 1 {
+		t.Errorf("missingInsurer rose by %d, want 1", d)
+	}
+	dup := false
+	for _, id := range broken.DuplicateIDs {
+		dup = dup || id == "DUP-1"
+	}
+	if !dup {
+		t.Errorf("duplicateIds = %q, want DUP-1 among them", broken.DuplicateIDs)
+	}
+}
@@ -0,0 +1,151 @@
+// Command admin runs operational tasks against a tenant's catalogue:
+//
+//	go run ./cmd/admin [-tenant name] ensure-indexes
+//	go run ./cmd/admin [-tenant name] stats
+//	go run ./cmd/admin [-tenant name] verify
+//	go run ./cmd/admin [-tenant name] cache-flush -url https://host -token TOKEN
+//
+// It reads the same configuration as the server. cache-flush goes through
+// the server's admin endpoint, since only the server holds its caches.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const usage = `usage: admin [-tenant name] <command> [flags]
+
+commands:
+  ensure-indexes  create missing indexes
+  stats           count products per status
+  verify          report malformed entries, missing ids and insurers, duplicate ids
+  cache-flush     drop the server's cached listings (-url, -token)
+`
+
+func main() {
+	tenantName := flag.String("tenant", "", "tenant to operate on (default: the default tenant)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	err := run(flag.Arg(0), flag.Args()[1:], *tenantName, os.Stdout)
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var errUsage = errors.New("unknown command")
+
+// run runs command for the named tenant, writing its report to out as
+// indented JSON.
+func run(command string, args []string, tenantName string, out io.Writer) error {
+	switch command {
+	case "cache-flush":
+		return cacheFlush(args, tenantName)
+	case "ensure-indexes", "stats", "verify":
+	default:
+		return errUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := database.ConnectDB(cfg.Mongo); err != nil {
+		return err
+	}
+	t := database.DefaultTenant()
+	if tenantName != "" {
+		t, _ = database.LookupTenant(tenantName)
+	}
+	if t == nil {
+		return fmt.Errorf("unknown tenant %q; configured: %v", tenantName, database.TenantNames())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	var report interface{}
+	switch command {
+	case "ensure-indexes":
+		created, err := database.EnsureIndexes(ctx, t.Products)
+		if err != nil {
+			return fmt.Errorf("ensuring indexes: %w", err)
+		}
+		if cfg.Mongo.FlatSync {
+			if _, err := database.EnsureFlatIndexes(ctx, t.Flat); err != nil {
+				return fmt.Errorf("ensuring flat indexes: %w", err)
+			}
+		}
+		report = created
+	case "stats":
+		cursor, err := t.List.Aggregate(ctx, database.StatsPipeline(bson.M{}, "$productList.productStatus", ""))
+		if err != nil {
+			return fmt.Errorf("counting products: %w", err)
+		}
+		var buckets []bson.M
+		if err := cursor.All(ctx, &buckets); err != nil {
+			return fmt.Errorf("counting products: %w", err)
+		}
+		report = buckets
+	case "verify":
+		problems, err := database.Verify(ctx, t.List)
+		if err != nil {
+			return fmt.Errorf("verifying products: %w", err)
+		}
+		report = problems
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func cacheFlush(args []string, tenant string) error {
+	fs := flag.NewFlagSet("cache-flush", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:3000", "server base URL")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "bearer token with the admin role (default $ADMIN_TOKEN)")
+	fs.Parse(args)
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(*url, "/")+"/admin/cache/flush", nil)
+	if err != nil {
+		return err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	if tenant != "" {
+		req.Header.Set("X-Tenant", tenant)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("cache flush failed: %s", resp.Status)
+	}
+	log.Println("Cache flushed")
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	// An unknown command is turned away before the configuration is read,
+	// so this needs no database.
+	if err := run("reindex", nil, "", io.Discard); !errors.Is(err, errUsage) {
+		t.Errorf("run(reindex) = %v, want errUsage", err)
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	var got *http.Request
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	if err := run("cache-flush", []string{"-url", srv.URL + "/", "-token", "secret"}, "th", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/admin/cache/flush" {
+		t.Errorf("request = %s %s, want POST /admin/cache/flush", got.Method, got.URL.Path)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if tenant := got.Header.Get("X-Tenant"); tenant != "th" {
+		t.Errorf("X-Tenant = %q, want th", tenant)
+	}
+
+	// The token defaults to $ADMIN_TOKEN, and no tenant means the server's
+	// default.
+	t.Setenv("ADMIN_TOKEN", "from-env")
+	if err := run("cache-flush", []string{"-url", srv.URL}, "", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if auth, tenant := got.Header.Get("Authorization"), got.Header.Get("X-Tenant"); auth != "Bearer from-env" || tenant != "" {
+		t.Errorf("Authorization = %q, X-Tenant = %q, want the env token and no tenant", auth, tenant)
+	}
+
+	status = http.StatusForbidden
+	err := run("cache-flush", []string{"-url", srv.URL}, "", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("a refused flush returned %v", err)
+	}
+}
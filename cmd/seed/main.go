@@ -0,0 +1,73 @@
+// Command seed loads the fixture product groups into a tenant's collection
+// for local development:
+//
+//	go run ./cmd/seed [-tenant name] [-drop]
+//
+// It reads the same configuration as the server.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func main() {
+	tenantName := flag.String("tenant", "", "tenant to seed (default: the default tenant)")
+	drop := flag.Bool("drop", false, "drop the tenant's product collection first")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := database.ConnectDB(cfg.Mongo); err != nil {
+		log.Fatal(err)
+	}
+
+	t := database.DefaultTenant()
+	if *tenantName != "" {
+		t, _ = database.LookupTenant(*tenantName)
+	}
+	if t == nil {
+		log.Fatalf("unknown tenant %q; configured: %v", *tenantName, database.TenantNames())
+	}
+
+	groups, err := fixtures.Groups()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if *drop {
+		if err := t.Products.Drop(ctx); err != nil {
+			log.Fatalf("Error dropping %s: %v", t.Products.Name(), err)
+		}
+		if err := t.Flat.Drop(ctx); err != nil {
+			log.Fatalf("Error dropping %s: %v", t.Flat.Name(), err)
+		}
+	}
+	if _, err := database.EnsureIndexes(ctx, t.Products); err != nil {
+		log.Fatalf("Error ensuring indexes: %v", err)
+	}
+	if _, err := t.Products.InsertMany(ctx, groups); err != nil {
+		log.Fatalf("Error inserting fixtures: %v", err)
+	}
+	if err := database.RefreshSearchFields(ctx, t.Products, bson.M{}); err != nil {
+		log.Fatalf("Error computing search fields: %v", err)
+	}
+	if cfg.Mongo.FlatSync {
+		if err := database.BackfillFlat(ctx, t); err != nil {
+			log.Fatalf("Error backfilling %s: %v", t.Flat.Name(), err)
+		}
+	}
+	log.Printf("Seeded %d groups (fixtures %s) into tenant %s", len(groups), fixtures.Version, t.Name)
+}
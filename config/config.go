@@ -0,0 +1,581 @@
+// Package config loads the service configuration from environment
+// variables, optionally seeded from a .env file for local development.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Port string
+	// DebugAddr, when set, serves pprof on a separate listener. It must not
+	// be reachable from outside the cluster.
+	DebugAddr string
+	// DebugErrors puts internal error details in 500 responses. It is for
+	// local development only: the details name hosts and collections.
+	DebugErrors bool
+
+	Mongo      MongoConfig
+	HTTP       HTTPConfig
+	Pagination PaginationConfig
+	Search     SearchConfig
+	MasterData MasterDataConfig
+	Scheduler  SchedulerConfig
+	Breaker    BreakerConfig
+	SlowQuery  SlowQueryConfig
+	Cache      CacheConfig
+	Auth       AuthConfig
+	CORS       CORSConfig
+	Log        LogConfig
+	TLS        TLSConfig
+
+	// Maintenance is the maintenance scope at startup: off, writes or all.
+	Maintenance        string
+	MaintenanceMessage string
+}
+
+// TLSConfig enables HTTPS when CertFile is set; plain HTTP otherwise.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mTLS for the admin and write routes.
+	ClientCAFile string
+}
+
+type LogConfig struct {
+	// Format is "json" or "text".
+	Format string
+	// AccessLogSampling uses the middleware.ParseSamplingRules format.
+	AccessLogSampling string
+}
+
+type MongoConfig struct {
+	URI            string
+	Database       string
+	Collection     string
+	ConnectTimeout time.Duration
+	// StartupDeadline bounds how long startup keeps retrying the initial
+	// connection before giving up.
+	StartupDeadline time.Duration
+	RetryInitial    time.Duration
+	RetryMax        time.Duration
+
+	// Tenants are the product catalogues served by this deployment. Without
+	// TENANTS there is a single tenant named "default" using Database and
+	// Collection.
+	Tenants       []TenantConfig
+	DefaultTenant string
+
+	// EnsureIndexes creates missing indexes at startup.
+	EnsureIndexes bool
+	// MigrateOnStartup applies pending data migrations at startup.
+	MigrateOnStartup bool
+
+	// IdempotencyTTL is how long Idempotency-Key responses are kept.
+	IdempotencyTTL time.Duration
+
+	// ListReadPreference and ListReadConcern apply to listing and search
+	// queries only; writes and read-your-own-write paths stay on the primary.
+	ListReadPreference string
+	ListReadConcern    string
+	// ListAggregation flattens listing results in an aggregation pipeline;
+	// false falls back to fetching whole groups and flattening in Go.
+	ListAggregation bool
+	// IndexHints forces an index for listing filters of a given shape: the
+	// filter's top-level fields, sorted and joined by "+", e.g.
+	// "$or+productList.productStatus". See database.FilterShape.
+	IndexHints map[string]string
+	// Collation is the default locale listings are sorted by, one of
+	// database.Collations, or "simple" for binary order.
+	Collation string
+	// ExportBatchSize is the cursor batch size of export queries, which
+	// read far more documents than a listing page.
+	ExportBatchSize int
+	// FlatSync keeps the products_flat collection in sync, rebuilding it
+	// fully every FlatResyncInterval. ListSource "flat" serves listings
+	// from it and requires FlatSync; "groups" reads the group documents.
+	FlatSync           bool
+	FlatResyncInterval time.Duration
+	ListSource         string
+
+	// MaxTime is the server-side time limit of listing and lookup queries,
+	// set slightly below QUERY_TIMEOUT so Mongo gives up before the client
+	// does. ExportMaxTime is the same for exports.
+	MaxTime       time.Duration
+	ExportMaxTime time.Duration
+
+	// AnalyticsAllowDiskUse lets the analytics aggregations spill to disk.
+	// Unfiltered analytics are refused once the collection holds more than
+	// AnalyticsMaxUnfiltered documents.
+	AnalyticsAllowDiskUse  bool
+	AnalyticsMaxUnfiltered int
+
+	// Pool tuning; zero keeps the driver default.
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	MaxConnIdleTime        time.Duration
+	DialTimeout            time.Duration
+	ServerSelectionTimeout time.Duration
+}
+
+type TenantConfig struct {
+	Name       string
+	Database   string
+	Collection string
+}
+
+type HTTPConfig struct {
+	QueryTimeout    time.Duration
+	MaxQueryTimeout time.Duration
+	// ExportTimeout bounds a whole export download.
+	ExportTimeout time.Duration
+	// CountCacheTTL is how long an unfiltered product count is reused.
+	CountCacheTTL time.Duration
+	ShutdownGrace time.Duration
+	MaxBodyBytes  int
+}
+
+type BreakerConfig struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+	SuccessThreshold int
+}
+
+// CacheConfig selects the response cache. Backend is "memory", "redis" or
+// "none"; a zero TTL also disables caching.
+type CacheConfig struct {
+	Backend string
+	TTL     time.Duration
+	// Size bounds the in-memory backend.
+	Size int
+
+	RedisAddr     string
+	RedisPassword string
+	RedisTLS      bool
+	RedisPrefix   string
+}
+
+type SlowQueryConfig struct {
+	Threshold time.Duration
+	// Explain logs the winning plan of each slow query. It costs an extra
+	// round trip per slow query, so it is meant for debugging.
+	Explain bool
+}
+
+type PaginationConfig struct {
+	DefaultLimit int
+	MaxLimit     int
+	// StreamMinLimit is the page size from which listings are streamed
+	// from the cursor instead of buffered and cached. Zero disables it.
+	StreamMinLimit int
+}
+
+type SearchConfig struct {
+	// MaxLength is the longest search term accepted, in characters.
+	MaxLength int
+	// SuggestionsRefresh is how long a tenant's spelling candidates are
+	// kept before they are reloaded; 0 turns suggestions off.
+	SuggestionsRefresh time.Duration
+}
+
+// MasterDataConfig controls checking product writes against the master
+// data collections.
+type MasterDataConfig struct {
+	// EnforceBrokers rejects product writes naming a broker that is not in
+	// the brokers collection. Turn it on once the collection is loaded.
+	EnforceBrokers bool
+	// EnforceInsurers is EnforceBrokers for the insurers collection.
+	EnforceInsurers bool
+	// EnforceProductTypes is EnforceBrokers for the product_types
+	// collection, checked on group writes.
+	EnforceProductTypes bool
+}
+
+// SchedulerConfig controls the worker applying scheduled status changes.
+type SchedulerConfig struct {
+	// Interval is how often due changes are looked for; 0 turns the worker
+	// off in this replica.
+	Interval time.Duration
+}
+
+type AuthConfig struct {
+	// APIKeys uses the "name:key[:role|role]" comma-separated format.
+	APIKeys       string
+	JWTJWKSURL    string
+	JWTAudience   string
+	JWTIssuer     string
+	JWTRolesClaim string
+	JWTLeeway     time.Duration
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+}
+
+// Error lists every problem found while loading the configuration.
+type Error []string
+
+func (e Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// Load reads the configuration from the environment. If the file named by
+// ENV_FILE (default ".env") exists, its KEY=VALUE lines are applied first
+// without overriding variables that are already set.
+func Load() (Config, error) {
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = ".env"
+	}
+	if err := loadEnvFile(envFile); err != nil && !os.IsNotExist(err) {
+		return Config{}, fmt.Errorf("reading %s: %w", envFile, err)
+	}
+
+	var l loader
+	cfg := Config{
+		Port:        l.string("PORT", "3000"),
+		DebugAddr:   l.string("DEBUG_ADDR", ""),
+		DebugErrors: l.bool("DEBUG_ERRORS", false),
+		Mongo: MongoConfig{
+			URI:             l.string("MONGO_URI", ""),
+			Database:        l.string("MONGO_DATABASE", "GI"),
+			Collection:      l.string("MONGO_COLLECTION", "productV4"),
+			ConnectTimeout:  l.duration("MONGO_CONNECT_TIMEOUT", 10*time.Second),
+			StartupDeadline: l.duration("MONGO_STARTUP_DEADLINE", time.Minute),
+			RetryInitial:    l.duration("MONGO_RETRY_INITIAL", 500*time.Millisecond),
+			RetryMax:        l.duration("MONGO_RETRY_MAX", 10*time.Second),
+
+			EnsureIndexes:      l.bool("MONGO_ENSURE_INDEXES", true),
+			MigrateOnStartup:   l.bool("MIGRATE_ON_STARTUP", false),
+			IdempotencyTTL:     l.duration("IDEMPOTENCY_TTL", 24*time.Hour),
+			ListReadPreference: l.string("MONGO_LIST_READ_PREFERENCE", "primary"),
+			ListReadConcern:    l.string("MONGO_LIST_READ_CONCERN", ""),
+			ListAggregation:    l.bool("MONGO_LIST_AGGREGATION", true),
+			Collation:          l.string("MONGO_COLLATION", "th"),
+			IndexHints:         l.hints("MONGO_INDEX_HINTS"),
+			MaxTime:            l.duration("MONGO_MAX_TIME", 9*time.Second),
+			ExportMaxTime:      l.duration("MONGO_EXPORT_MAX_TIME", 4*time.Minute+50*time.Second),
+
+			AnalyticsAllowDiskUse:  l.bool("MONGO_ANALYTICS_ALLOW_DISK_USE", true),
+			AnalyticsMaxUnfiltered: l.int("MONGO_ANALYTICS_MAX_UNFILTERED", 100000),
+			ExportBatchSize:        l.int("MONGO_EXPORT_BATCH_SIZE", 500),
+			FlatSync:               l.bool("MONGO_FLAT_SYNC", false),
+			FlatResyncInterval:     l.duration("MONGO_FLAT_RESYNC_INTERVAL", 10*time.Minute),
+			ListSource:             l.string("MONGO_LIST_SOURCE", "groups"),
+
+			MaxPoolSize:            l.uint("MONGO_MAX_POOL_SIZE"),
+			MinPoolSize:            l.uint("MONGO_MIN_POOL_SIZE"),
+			MaxConnIdleTime:        l.duration("MONGO_MAX_CONN_IDLE_TIME", 0),
+			DialTimeout:            l.duration("MONGO_DIAL_TIMEOUT", 0),
+			ServerSelectionTimeout: l.duration("MONGO_SERVER_SELECTION_TIMEOUT", 0),
+		},
+		HTTP: HTTPConfig{
+			QueryTimeout:    l.duration("QUERY_TIMEOUT", 10*time.Second),
+			MaxQueryTimeout: l.duration("QUERY_TIMEOUT_MAX", 30*time.Second),
+			ExportTimeout:   l.duration("EXPORT_TIMEOUT", 5*time.Minute),
+			CountCacheTTL:   l.duration("COUNT_CACHE_TTL", 30*time.Second),
+			ShutdownGrace:   l.duration("SHUTDOWN_GRACE_PERIOD", 30*time.Second),
+			MaxBodyBytes:    l.int("MAX_BODY_BYTES", 64*1024),
+		},
+		Pagination: PaginationConfig{
+			DefaultLimit: l.int("PAGE_LIMIT_DEFAULT", 10),
+			MaxLimit:     l.int("PAGE_LIMIT_MAX", 100),
+
+			StreamMinLimit: l.int("PAGE_STREAM_MIN_LIMIT", 200),
+		},
+		Search: SearchConfig{
+			MaxLength:          l.int("SEARCH_MAX_LENGTH", 256),
+			SuggestionsRefresh: l.duration("SEARCH_SUGGESTIONS_REFRESH", 10*time.Minute),
+		},
+		MasterData: MasterDataConfig{
+			EnforceBrokers:      l.bool("MASTER_DATA_ENFORCE_BROKERS", false),
+			EnforceInsurers:     l.bool("MASTER_DATA_ENFORCE_INSURERS", false),
+			EnforceProductTypes: l.bool("MASTER_DATA_ENFORCE_PRODUCT_TYPES", false),
+		},
+		Scheduler: SchedulerConfig{
+			Interval: l.duration("SCHEDULER_INTERVAL", time.Minute),
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: l.int("BREAKER_FAILURE_THRESHOLD", 5),
+			Cooldown:         l.duration("BREAKER_COOLDOWN", 30*time.Second),
+			SuccessThreshold: l.int("BREAKER_SUCCESS_THRESHOLD", 1),
+		},
+		SlowQuery: SlowQueryConfig{
+			Threshold: l.duration("SLOW_QUERY_THRESHOLD", 500*time.Millisecond),
+			Explain:   l.bool("SLOW_QUERY_EXPLAIN", false),
+		},
+		Cache: CacheConfig{
+			Backend:       l.string("CACHE_BACKEND", "memory"),
+			TTL:           l.duration("CACHE_TTL", 5*time.Second),
+			Size:          l.int("CACHE_SIZE", 1000),
+			RedisAddr:     l.string("REDIS_ADDR", ""),
+			RedisPassword: l.string("REDIS_PASSWORD", ""),
+			RedisTLS:      l.bool("REDIS_TLS", false),
+			RedisPrefix:   l.string("REDIS_KEY_PREFIX", "poc-app:"),
+		},
+		Auth: AuthConfig{
+			APIKeys:       l.string("API_KEYS", ""),
+			JWTJWKSURL:    l.string("JWT_JWKS_URL", ""),
+			JWTAudience:   l.string("JWT_AUDIENCE", ""),
+			JWTIssuer:     l.string("JWT_ISSUER", ""),
+			JWTRolesClaim: l.string("JWT_ROLES_CLAIM", "roles"),
+			JWTLeeway:     l.duration("JWT_LEEWAY", 0),
+		},
+		Maintenance:        l.string("MAINTENANCE_MODE", "off"),
+		MaintenanceMessage: l.string("MAINTENANCE_MESSAGE", ""),
+		Log: LogConfig{
+			Format:            l.string("LOG_FORMAT", "json"),
+			AccessLogSampling: l.string("ACCESS_LOG_SAMPLING", "/healthz=0,/readyz=0,2xx=0.1,*=1"),
+		},
+		TLS: TLSConfig{
+			CertFile:     l.string("TLS_CERT_FILE", ""),
+			KeyFile:      l.string("TLS_KEY_FILE", ""),
+			ClientCAFile: l.string("TLS_CLIENT_CA_FILE", ""),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   l.list("CORS_ALLOWED_ORIGINS"),
+			AllowedMethods:   l.list("CORS_ALLOWED_METHODS"),
+			AllowedHeaders:   l.list("CORS_ALLOWED_HEADERS"),
+			AllowCredentials: l.bool("CORS_ALLOW_CREDENTIALS", false),
+		},
+	}
+
+	cfg.Mongo.Tenants = l.tenants("TENANTS", cfg.Mongo.Database, cfg.Mongo.Collection)
+	cfg.Mongo.DefaultTenant = l.string("TENANT_DEFAULT", cfg.Mongo.Tenants[0].Name)
+
+	errs := append(l.errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+var (
+	readPreferences = []string{"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"}
+	readConcerns    = []string{"local", "available", "majority", "linearizable", "snapshot"}
+)
+
+func (c Config) validate() Error {
+	var errs Error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Mongo.URI != "", "MONGO_URI is required")
+	check(c.Mongo.Database != "", "MONGO_DATABASE must not be empty")
+	check(c.Mongo.Collection != "", "MONGO_COLLECTION must not be empty")
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Sprintf("PORT must be between 1 and 65535, got %q", c.Port))
+	}
+	if c.Mongo.DefaultTenant != "" {
+		found := false
+		for _, t := range c.Mongo.Tenants {
+			found = found || t.Name == c.Mongo.DefaultTenant
+		}
+		check(found, "TENANT_DEFAULT %q is not one of TENANTS", c.Mongo.DefaultTenant)
+	}
+	check(c.Mongo.ConnectTimeout > 0, "MONGO_CONNECT_TIMEOUT must be positive")
+	check(c.Mongo.StartupDeadline > 0, "MONGO_STARTUP_DEADLINE must be positive")
+	check(c.Mongo.RetryInitial > 0, "MONGO_RETRY_INITIAL must be positive")
+	check(c.Mongo.RetryMax >= c.Mongo.RetryInitial, "MONGO_RETRY_MAX must not be less than MONGO_RETRY_INITIAL")
+	check(c.Mongo.MaxPoolSize == 0 || c.Mongo.MinPoolSize <= c.Mongo.MaxPoolSize, "MONGO_MIN_POOL_SIZE must not exceed MONGO_MAX_POOL_SIZE")
+	check(contains(readPreferences, c.Mongo.ListReadPreference), "MONGO_LIST_READ_PREFERENCE must be one of %s, got %q", strings.Join(readPreferences, ", "), c.Mongo.ListReadPreference)
+	check(c.Mongo.ListReadConcern == "" || contains(readConcerns, c.Mongo.ListReadConcern), "MONGO_LIST_READ_CONCERN must be one of %s, got %q", strings.Join(readConcerns, ", "), c.Mongo.ListReadConcern)
+	check(contains([]string{"th", "en", "simple"}, c.Mongo.Collation), "MONGO_COLLATION must be th, en or simple, got %q", c.Mongo.Collation)
+	check(c.Mongo.ListSource == "groups" || c.Mongo.ListSource == "flat", "MONGO_LIST_SOURCE must be groups or flat, got %q", c.Mongo.ListSource)
+	check(c.Mongo.ListSource != "flat" || c.Mongo.FlatSync, "MONGO_LIST_SOURCE=flat requires MONGO_FLAT_SYNC")
+	check(!c.Mongo.FlatSync || c.Mongo.FlatResyncInterval > 0, "MONGO_FLAT_RESYNC_INTERVAL must be positive")
+	check(c.Mongo.MaxTime > 0, "MONGO_MAX_TIME must be positive")
+	check(c.Mongo.ExportMaxTime > 0, "MONGO_EXPORT_MAX_TIME must be positive")
+	check(c.Mongo.AnalyticsMaxUnfiltered >= 0, "MONGO_ANALYTICS_MAX_UNFILTERED must not be negative")
+	check(c.Mongo.ExportBatchSize >= 1, "MONGO_EXPORT_BATCH_SIZE must be at least 1")
+	check(c.Mongo.IdempotencyTTL >= time.Second, "IDEMPOTENCY_TTL must be at least 1s")
+	check(c.HTTP.QueryTimeout > 0, "QUERY_TIMEOUT must be positive")
+	check(c.HTTP.MaxQueryTimeout >= c.HTTP.QueryTimeout, "QUERY_TIMEOUT_MAX must not be less than QUERY_TIMEOUT")
+	check(c.Mongo.MaxTime < c.HTTP.QueryTimeout, "MONGO_MAX_TIME must be less than QUERY_TIMEOUT")
+	check(c.HTTP.CountCacheTTL >= 0, "COUNT_CACHE_TTL must not be negative")
+	check(c.HTTP.ExportTimeout > 0, "EXPORT_TIMEOUT must be positive")
+	check(c.HTTP.ShutdownGrace >= 0, "SHUTDOWN_GRACE_PERIOD must not be negative")
+	check(c.HTTP.MaxBodyBytes > 0, "MAX_BODY_BYTES must be positive")
+	check(c.Scheduler.Interval >= 0, "SCHEDULER_INTERVAL must not be negative")
+	check(c.Breaker.FailureThreshold >= 1, "BREAKER_FAILURE_THRESHOLD must be at least 1")
+	check(c.Breaker.Cooldown > 0, "BREAKER_COOLDOWN must be positive")
+	check(c.Breaker.SuccessThreshold >= 1, "BREAKER_SUCCESS_THRESHOLD must be at least 1")
+	check(c.SlowQuery.Threshold > 0, "SLOW_QUERY_THRESHOLD must be positive")
+	check(c.Cache.TTL >= 0, "CACHE_TTL must not be negative")
+	check(contains([]string{"memory", "redis", "none"}, c.Cache.Backend), "CACHE_BACKEND must be memory, redis or none, got %q", c.Cache.Backend)
+	check(c.Cache.Backend != "memory" || c.Cache.TTL == 0 || c.Cache.Size >= 1, "CACHE_SIZE must be at least 1 when caching is enabled")
+	check(c.Cache.Backend != "redis" || c.Cache.RedisAddr != "", "REDIS_ADDR is required when CACHE_BACKEND is redis")
+	check(c.Pagination.MaxLimit >= 1 && c.Pagination.MaxLimit <= 1000, "PAGE_LIMIT_MAX must be between 1 and 1000, got %d", c.Pagination.MaxLimit)
+	check(c.Pagination.DefaultLimit >= 1 && c.Pagination.DefaultLimit <= c.Pagination.MaxLimit,
+		"PAGE_LIMIT_DEFAULT must be between 1 and PAGE_LIMIT_MAX (%d), got %d", c.Pagination.MaxLimit, c.Pagination.DefaultLimit)
+	check(c.Pagination.StreamMinLimit >= 0, "PAGE_STREAM_MIN_LIMIT must not be negative")
+	check(c.Search.SuggestionsRefresh >= 0, "SEARCH_SUGGESTIONS_REFRESH must not be negative")
+	check(c.Search.MaxLength >= 1, "SEARCH_MAX_LENGTH must be at least 1, got %d", c.Search.MaxLength)
+	check(contains([]string{"off", "writes", "all"}, c.Maintenance), "MAINTENANCE_MODE must be off, writes or all, got %q", c.Maintenance)
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	check(c.Auth.JWTJWKSURL == "" || c.Auth.JWTAudience != "", "JWT_AUDIENCE is required when JWT_JWKS_URL is set")
+	check(c.Log.Format == "json" || c.Log.Format == "text", "LOG_FORMAT must be json or text, got %q", c.Log.Format)
+	check(!(c.CORS.AllowCredentials && contains(c.CORS.AllowedOrigins, "*")), "CORS_ALLOW_CREDENTIALS cannot be combined with the wildcard origin")
+	return errs
+}
+
+// loader reads typed environment values, remembering every parse failure so
+// they can be reported together.
+type loader struct {
+	errs Error
+}
+
+func (l *loader) string(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
+
+func (l *loader) int(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return def
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("%s must be an integer, got %q", key, v))
+		return def
+	}
+	return n
+}
+
+func (l *loader) uint(key string) uint64 {
+	n := l.int(key, 0)
+	if n < 0 {
+		l.errs = append(l.errs, fmt.Sprintf("%s must not be negative, got %d", key, n))
+		return 0
+	}
+	return uint64(n)
+}
+
+func (l *loader) duration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return def
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("%s must be a duration such as 10s, got %q", key, v))
+		return def
+	}
+	return d
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("%s must be true or false, got %q", key, v))
+		return def
+	}
+	return b
+}
+
+func (l *loader) list(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// hints reads "shape=index" entries.
+func (l *loader) hints(key string) map[string]string {
+	out := map[string]string{}
+	for _, entry := range l.list(key) {
+		shape, index, ok := strings.Cut(entry, "=")
+		if !ok || shape == "" || index == "" {
+			l.errs = append(l.errs, fmt.Sprintf("%s entry %q must be shape=index", key, entry))
+			continue
+		}
+		out[shape] = index
+	}
+	return out
+}
+
+// tenants reads "name=database/collection" entries; the collection may be
+// omitted to use defCollection. Without the variable a single "default"
+// tenant is returned.
+func (l *loader) tenants(key, defDatabase, defCollection string) []TenantConfig {
+	entries := l.list(key)
+	if len(entries) == 0 {
+		return []TenantConfig{{Name: "default", Database: defDatabase, Collection: defCollection}}
+	}
+	var out []TenantConfig
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		name, target, ok := strings.Cut(entry, "=")
+		db, coll, _ := strings.Cut(target, "/")
+		if coll == "" {
+			coll = defCollection
+		}
+		if !ok || name == "" || db == "" || seen[name] {
+			l.errs = append(l.errs, fmt.Sprintf("%s entry %q must be a unique name=database[/collection]", key, entry))
+			continue
+		}
+		seen[name] = true
+		out = append(out, TenantConfig{Name: name, Database: db, Collection: coll})
+	}
+	if len(out) == 0 {
+		return []TenantConfig{{Name: "default", Database: defDatabase, Collection: defCollection}}
+	}
+	return out
+}
+
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return scanner.Err()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv replaces the whole environment with env for the test, with ENV_FILE
+// pointing at a file that does not exist unless env names one.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, map[string]string{"MONGO_URI": "mongodb://localhost:27017"})
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"Port", cfg.Port, "3000"},
+		{"Mongo.Database", cfg.Mongo.Database, "GI"},
+		{"Mongo.Collection", cfg.Mongo.Collection, "productV4"},
+		{"Mongo.Tenants", cfg.Mongo.Tenants, []TenantConfig{{Name: "default", Database: "GI", Collection: "productV4"}}},
+		{"Mongo.DefaultTenant", cfg.Mongo.DefaultTenant, "default"},
+		{"Mongo.ListReadPreference", cfg.Mongo.ListReadPreference, "primary"},
+		{"Mongo.Collation", cfg.Mongo.Collation, "th"},
+		{"Mongo.IndexHints", cfg.Mongo.IndexHints, map[string]string{}},
+		{"Mongo.MaxTime", cfg.Mongo.MaxTime, 9 * time.Second},
+		{"HTTP.QueryTimeout", cfg.HTTP.QueryTimeout, 10 * time.Second},
+		{"HTTP.MaxQueryTimeout", cfg.HTTP.MaxQueryTimeout, 30 * time.Second},
+		{"Pagination", cfg.Pagination, PaginationConfig{DefaultLimit: 10, MaxLimit: 100, StreamMinLimit: 200}},
+		{"Cache.Backend", cfg.Cache.Backend, "memory"},
+		{"Search.MaxLength", cfg.Search.MaxLength, 256},
+		{"Breaker", cfg.Breaker, BreakerConfig{FailureThreshold: 5, Cooldown: 30 * time.Second, SuccessThreshold: 1}},
+		{"Maintenance", cfg.Maintenance, "off"},
+		{"Log.Format", cfg.Log.Format, "json"},
+		{"CORS.AllowedOrigins", cfg.CORS.AllowedOrigins, []string(nil)},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %#v, want %#v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(envFile, []byte(`# local development
+MONGO_URI="mongodb://from-file:27017"
+export PAGE_LIMIT_MAX=500
+PORT=9999
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, map[string]string{
+		"ENV_FILE":             envFile,
+		"PORT":                 " 8080 ",
+		"QUERY_TIMEOUT":        "2s",
+		"MONGO_MAX_TIME":       "1500ms",
+		"PAGE_LIMIT_DEFAULT":   "25",
+		"CACHE_BACKEND":        "none",
+		"TENANTS":              "th=GI_TH,sg=GI_SG/products",
+		"TENANT_DEFAULT":       "sg",
+		"MONGO_INDEX_HINTS":    "$or+productList.productStatus=status_1",
+		"CORS_ALLOWED_ORIGINS": "https://a.example.com, https://b.example.com",
+	})
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		// The environment wins over the file, which fills in the rest.
+		{"Port", cfg.Port, "8080"},
+		{"Mongo.URI", cfg.Mongo.URI, "mongodb://from-file:27017"},
+		{"Pagination.MaxLimit", cfg.Pagination.MaxLimit, 500},
+		{"Pagination.DefaultLimit", cfg.Pagination.DefaultLimit, 25},
+		{"HTTP.QueryTimeout", cfg.HTTP.QueryTimeout, 2 * time.Second},
+		{"Cache.Backend", cfg.Cache.Backend, "none"},
+		{"Mongo.Tenants", cfg.Mongo.Tenants, []TenantConfig{
+			{Name: "th", Database: "GI_TH", Collection: "productV4"},
+			{Name: "sg", Database: "GI_SG", Collection: "products"},
+		}},
+		{"Mongo.DefaultTenant", cfg.Mongo.DefaultTenant, "sg"},
+		{"Mongo.IndexHints", cfg.Mongo.IndexHints, map[string]string{"$or+productList.productStatus": "status_1"}},
+		{"CORS.AllowedOrigins", cfg.CORS.AllowedOrigins, []string{"https://a.example.com", "https://b.example.com"}},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %#v, want %#v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"missing Mongo URI", map[string]string{"MONGO_URI": ""}, []string{"MONGO_URI is required"}},
+		{"port out of range", map[string]string{"PORT": "70000"}, []string{`PORT must be between 1 and 65535, got "70000"`}},
+		{"not an integer", map[string]string{"PAGE_LIMIT_MAX": "lots"}, []string{`PAGE_LIMIT_MAX must be an integer, got "lots"`}},
+		{"not a duration", map[string]string{"QUERY_TIMEOUT": "10"}, []string{`QUERY_TIMEOUT must be a duration such as 10s, got "10"`}},
+		{"not a bool", map[string]string{"MONGO_FLAT_SYNC": "yes please"}, []string{`MONGO_FLAT_SYNC must be true or false, got "yes please"`}},
+		{"negative pool size", map[string]string{"MONGO_MAX_POOL_SIZE": "-1"}, []string{"MONGO_MAX_POOL_SIZE must not be negative, got -1"}},
+		{"search length below one", map[string]string{"SEARCH_MAX_LENGTH": "0"}, []string{"SEARCH_MAX_LENGTH must be at least 1, got 0"}},
+		{"default above maximum", map[string]string{"PAGE_LIMIT_MAX": "50", "PAGE_LIMIT_DEFAULT": "80"}, []string{"PAGE_LIMIT_DEFAULT must be between 1 and PAGE_LIMIT_MAX (50), got 80"}},
+		{"timeout above its maximum", map[string]string{"QUERY_TIMEOUT": "1m"}, []string{"QUERY_TIMEOUT_MAX must not be less than QUERY_TIMEOUT"}},
+		{"server limit not below the timeout", map[string]string{"QUERY_TIMEOUT": "5s"}, []string{"MONGO_MAX_TIME must be less than QUERY_TIMEOUT"}},
+		{"server limit equal to the timeout", map[string]string{"MONGO_MAX_TIME": "10s"}, []string{"MONGO_MAX_TIME must be less than QUERY_TIMEOUT"}},
+		{"unknown tenant", map[string]string{"TENANTS": "th=GI_TH", "TENANT_DEFAULT": "sg"}, []string{`TENANT_DEFAULT "sg" is not one of TENANTS`}},
+		{"malformed tenant", map[string]string{"TENANTS": "th=GI_TH,th=GI_OTHER,nameless"}, []string{
+			`TENANTS entry "th=GI_OTHER" must be a unique name=database[/collection]`,
+			`TENANTS entry "nameless" must be a unique name=database[/collection]`,
+		}},
+		{"flat source without sync", map[string]string{"MONGO_LIST_SOURCE": "flat"}, []string{"MONGO_LIST_SOURCE=flat requires MONGO_FLAT_SYNC"}},
+		{"redis without address", map[string]string{"CACHE_BACKEND": "redis"}, []string{"REDIS_ADDR is required when CACHE_BACKEND is redis"}},
+		{"half a TLS pair", map[string]string{"TLS_CERT_FILE": "cert.pem"}, []string{"TLS_CERT_FILE and TLS_KEY_FILE must be set together"}},
+		{"JWKS without audience", map[string]string{"JWT_JWKS_URL": "https://sso.example.com/jwks.json"}, []string{"JWT_AUDIENCE is required when JWT_JWKS_URL is set"}},
+		{"credentials with wildcard", map[string]string{"CORS_ALLOWED_ORIGINS": "*", "CORS_ALLOW_CREDENTIALS": "true"}, []string{"CORS_ALLOW_CREDENTIALS cannot be combined with the wildcard origin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"MONGO_URI": "mongodb://localhost:27017"}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			setEnv(t, env)
+			_, err := Load()
+			var errs Error
+			if !errors.As(err, &errs) {
+				t.Fatalf("Load() = %v, want a config.Error", err)
+			}
+			if !reflect.DeepEqual([]string(errs), tt.want) {
+				t.Errorf("errors = %q, want %q", errs, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadListsEveryProblem checks that Load reports all problems at once
+// rather than stopping at the first.
+func TestLoadListsEveryProblem(t *testing.T) {
+	setEnv(t, map[string]string{
+		"PORT":             "http",
+		"BREAKER_COOLDOWN": "soon",
+		"LOG_FORMAT":       "xml",
+	})
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load() succeeded")
+	}
+	for _, want := range []string{
+		`BREAKER_COOLDOWN must be a duration such as 10s, got "soon"`,
+		"MONGO_URI is required",
+		`PORT must be between 1 and 65535, got "http"`,
+		`LOG_FORMAT must be json or text, got "xml"`,
+	} {
+		if !strings.Contains(err.Error(), "\n  - "+want) {
+			t.Errorf("error does not list %q:\n%s", want, err)
+		}
+	}
+}
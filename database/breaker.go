@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/breaker"
+	"github.com/MaMaTidarat/poc-app/config"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Breaker guards request-path Mongo operations so a degraded database fails
+// requests fast instead of tying each one up for the full query timeout.
+var Breaker = newBreaker(config.BreakerConfig{FailureThreshold: 5, Cooldown: 30 * time.Second, SuccessThreshold: 1})
+
+func newBreaker(cfg config.BreakerConfig) *breaker.Breaker {
+	b := breaker.New("mongo", breaker.Config{
+		FailureThreshold: cfg.FailureThreshold,
+		Cooldown:         cfg.Cooldown,
+		SuccessThreshold: cfg.SuccessThreshold,
+	})
+	b.IsFailure = isBackendFailure
+	return b
+}
+
+// ConfigureBreaker replaces Breaker with one using cfg's thresholds.
+func ConfigureBreaker(cfg config.BreakerConfig) {
+	Breaker = newBreaker(cfg)
+}
+
+type callerDeadlineKey struct{}
+
+// WithCallerDeadline marks ctx as bounded by a deadline the caller asked
+// for rather than the server's own query timeout.
+func WithCallerDeadline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, callerDeadlineKey{}, true)
+}
+
+func hasCallerDeadline(ctx context.Context) bool {
+	marked, _ := ctx.Value(callerDeadlineKey{}).(bool)
+	return marked
+}
+
+// isBackendFailure reports whether err, returned by an operation run with
+// ctx, says something about the health of the database rather than about
+// the request.
+func isBackendFailure(ctx context.Context, err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, mongo.ErrNoDocuments),
+		errors.Is(err, context.Canceled),
+		mongo.IsDuplicateKeyError(err):
+		return false
+	}
+	// Running out of a budget the caller chose, possibly one already
+	// passed, is the caller's problem. Hitting the server's own query
+	// timeout or maxTimeMS is what a hung database looks like.
+	if errors.Is(err, context.DeadlineExceeded) || IsMaxTimeExpired(err) {
+		return !hasCallerDeadline(ctx)
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil {
+		return false
+	}
+	return true
+}
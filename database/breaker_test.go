@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/breaker"
+	"github.com/MaMaTidarat/poc-app/config"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var maxTimeExpired = mongo.CommandError{Code: codeMaxTimeMSExpired, Message: "operation exceeded time limit"}
+
+func TestIsBackendFailure(t *testing.T) {
+	server := context.Background()
+	caller := WithCallerDeadline(context.Background())
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"success", server, nil, false},
+		{"not found", server, mongo.ErrNoDocuments, false},
+		{"client went away", server, context.Canceled, false},
+		{"duplicate key", server, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, false},
+		{"write error", server, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121}}}, false},
+		{"write concern error", server, mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}, true},
+		{"server query timeout", server, context.DeadlineExceeded, true},
+		{"wrapped server query timeout", server, fmt.Errorf("find: %w", context.DeadlineExceeded), true},
+		{"server maxTimeMS", server, maxTimeExpired, true},
+		{"caller deadline", caller, context.DeadlineExceeded, false},
+		{"caller maxTimeMS", caller, maxTimeExpired, false},
+		{"caller deadline, other error", caller, errors.New("connection refused"), true},
+		{"other error", server, errors.New("connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBackendFailure(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("isBackendFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerOpensOnServerTimeouts(t *testing.T) {
+	cfg := config.BreakerConfig{FailureThreshold: 3, Cooldown: time.Minute, SuccessThreshold: 1}
+	timeout := func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 0)
+		defer cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	maxTime := func(context.Context) error { return maxTimeExpired }
+
+	for _, fail := range []struct {
+		name string
+		op   func(context.Context) error
+	}{{"deadline", timeout}, {"maxTimeMS", maxTime}} {
+		t.Run(fail.name, func(t *testing.T) {
+			b := newBreaker(cfg)
+			ctx := WithCallerDeadline(context.Background())
+			for i := 0; i < 2*cfg.FailureThreshold; i++ {
+				b.Do(ctx, func() error { return fail.op(ctx) })
+			}
+			if b.State() != breaker.Closed {
+				t.Fatalf("state after caller deadlines = %v, want closed", b.State())
+			}
+
+			ctx = context.Background()
+			for i := 0; i < cfg.FailureThreshold; i++ {
+				b.Do(ctx, func() error { return fail.op(ctx) })
+			}
+			if b.State() != breaker.Open {
+				t.Fatalf("state after %d server timeouts = %v, want open", cfg.FailureThreshold, b.State())
+			}
+			if err := b.Do(ctx, func() error { return nil }); !errors.Is(err, breaker.ErrOpen) {
+				t.Errorf("Do with the breaker open = %v, want ErrOpen", err)
+			}
+		})
+	}
+}
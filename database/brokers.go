@@ -0,0 +1,46 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BrokersCollection holds the broker channel master data, one document per
+// broker with the broker key as _id.
+const BrokersCollection = "brokers"
+
+// BrokerUsagePipeline counts the products using each embedded broker key
+// and channel name pair, for comparing embedded brokers with the master
+// data.
+func BrokerUsagePipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$unwind", Value: "$productList"}},
+		{{Key: "$unwind", Value: "$productList.brokers"}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"key":         asString("$productList.brokers.key"),
+				"channelName": asString("$productList.brokers.channelName"),
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.key", Value: 1}, {Key: "_id.channelName", Value: 1}}}},
+	}
+}
+
+// BrokerCountExpr is a $expr condition on the number of brokers of a
+// product, between min and max inclusive; a negative bound is open. A
+// missing or non-array brokers field counts as zero.
+func BrokerCountExpr(min, max int) bson.M {
+	return ProductExpr(func(field func(string) string) bson.M {
+		brokers := field("brokers")
+		n := bson.M{"$size": bson.M{"$cond": bson.A{bson.M{"$isArray": brokers}, brokers, bson.A{}}}}
+		conds := bson.A{}
+		if min >= 0 {
+			conds = append(conds, bson.M{"$gte": bson.A{n, min}})
+		}
+		if max >= 0 {
+			conds = append(conds, bson.M{"$lte": bson.A{n, max}})
+		}
+		return bson.M{"$and": conds}
+	})
+}
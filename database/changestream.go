@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const resumeTokenCollection = "resume_tokens"
+
+// Error codes returned when change streams cannot be used at all, or when
+// the stored resume token has fallen off the oplog.
+const (
+	codeChangeStreamUnsupported = 40573
+	codeChangeStreamHistoryLost = 286
+)
+
+// Change is one write to a products collection. A zero GroupID means the
+// changes could not be tracked and everything must be considered changed.
+type Change struct {
+	GroupID     interface{}
+	ClusterTime time.Time
+}
+
+// WatchProducts calls onChange for every insert, update, replace or delete on
+// the tenant's products collection, including writes made by other systems.
+// It resumes from the last token it saw, persisted next to the collection,
+// and keeps reconnecting until ctx is cancelled. On a standalone server,
+// which has no change streams, it logs a warning and returns.
+func WatchProducts(ctx context.Context, t *Tenant, onChange func(Change)) {
+	tokens := t.Products.Database().Collection(resumeTokenCollection)
+	tokenID := t.Products.Name()
+	backoff := time.Second
+
+	for ctx.Err() == nil {
+		err := watch(ctx, t, tokens, tokenID, onChange)
+		var cmdErr mongo.CommandError
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.As(err, &cmdErr) && cmdErr.Code == codeChangeStreamUnsupported:
+			log.Printf("Change streams are not supported for tenant %s; cache entries expire by TTL only", t.Name)
+			return
+		case errors.As(err, &cmdErr) && cmdErr.Code == codeChangeStreamHistoryLost:
+			log.Printf("Resume token for tenant %s is no longer in the oplog; restarting the change stream", t.Name)
+			if _, err := tokens.DeleteOne(ctx, bson.M{"_id": tokenID}); err != nil {
+				log.Printf("Error deleting resume token: %v", err)
+			}
+			// Changes in the gap cannot be replayed; drop everything.
+			onChange(Change{ClusterTime: time.Now()})
+			continue
+		}
+		log.Printf("Change stream for tenant %s stopped: %v; reconnecting in %s", t.Name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func watch(ctx context.Context, t *Tenant, tokens *mongo.Collection, tokenID string, onChange func(Change)) error {
+	opts := options.ChangeStream()
+	var saved struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := tokens.FindOne(ctx, bson.M{"_id": tokenID}).Decode(&saved)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if saved.Token != nil {
+		opts.SetResumeAfter(saved.Token)
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+	}}}}
+	stream, err := t.Products.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event struct {
+			DocumentKey struct {
+				ID interface{} `bson:"_id"`
+			} `bson:"documentKey"`
+			ClusterTime primitive.Timestamp `bson:"clusterTime"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		onChange(Change{
+			GroupID:     event.DocumentKey.ID,
+			ClusterTime: time.Unix(int64(event.ClusterTime.T), 0),
+		})
+		_, err := tokens.UpdateOne(ctx,
+			bson.M{"_id": tokenID},
+			bson.M{"$set": bson.M{"token": stream.ResumeToken(), "updatedAt": time.Now().UTC()}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			log.Printf("Error saving resume token for tenant %s: %v", t.Name, err)
+		}
+	}
+	return stream.Err()
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Collations are the locales listings can be sorted by. Strength 2 ignores
+// case but not diacritics or Thai tone marks.
+var Collations = map[string]*options.Collation{
+	"th": {Locale: "th", Strength: 2},
+	"en": {Locale: "en", Strength: 2},
+}
+
+// Collator compares strings the way Mongo sorts them under collation, for
+// results ordered in Go. It returns nil for the simple collation, which is
+// binary order. A Collator is not safe for concurrent use.
+func Collator(collation *options.Collation) *collate.Collator {
+	if collation == nil {
+		return nil
+	}
+	var opts []collate.Option
+	switch collation.Strength {
+	case 1:
+		opts = append(opts, collate.IgnoreCase, collate.IgnoreDiacritics)
+	case 2:
+		opts = append(opts, collate.IgnoreCase)
+	}
+	return collate.New(language.Make(collation.Locale), opts...)
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mixedNames are product names in Thai and English, with case variants and
+// Thai names starting with a leading vowel, which binary order sorts by the
+// vowel rather than the consonant.
+var mixedNames = []string{
+	"zebra Care", "ไก่ชน", "Apple Shield", "เกมส์ประกัน", "ขนมประกัน",
+	"apple Care", "โรงพยาบาล", "Banana Plan", "ประกันสุขภาพ เหมาจ่าย", "กรุงเทพ",
+}
+
+func TestCollator(t *testing.T) {
+	collated := []string{
+		"apple Care", "Apple Shield", "Banana Plan", "zebra Care",
+		"กรุงเทพ", "เกมส์ประกัน", "ไก่ชน", "ขนมประกัน", "ประกันสุขภาพ เหมาจ่าย", "โรงพยาบาล",
+	}
+	for _, locale := range []string{"th", "en"} {
+		t.Run(locale, func(t *testing.T) {
+			c := Collator(Collations[locale])
+			got := append([]string(nil), mixedNames...)
+			sort.SliceStable(got, func(i, j int) bool { return c.CompareString(got[i], got[j]) < 0 })
+			if !reflect.DeepEqual(got, collated) {
+				t.Errorf("order = %q, want %q", got, collated)
+			}
+			if c.CompareString("HEALTH plus", "Health Plus") != 0 {
+				t.Error("strength 2 told case variants apart")
+			}
+			if c.CompareString("cafe", "café") == 0 || c.CompareString("ไก่", "ไก้") == 0 {
+				t.Error("strength 2 ignored diacritics or tone marks")
+			}
+		})
+	}
+
+	if Collator(nil) != nil {
+		t.Error("the simple collation got a collator")
+	}
+	if c := Collator(&options.Collation{Locale: "en", Strength: 1}); c.CompareString("Cafe", "café") != 0 {
+		t.Error("strength 1 told diacritics apart")
+	}
+}
+
+// TestCollatedIndexes checks that every listing collation has an index on
+// the sort key with that same collation, which a collated sort needs to use
+// it.
+func TestCollatedIndexes(t *testing.T) {
+	for locale, collation := range Collations {
+		found := false
+		for _, model := range productIndexes {
+			if model.Options.Name != nil && *model.Options.Name == "productName_"+locale {
+				found = reflect.DeepEqual(model.Options.Collation, collation) &&
+					reflect.DeepEqual(model.Keys, bson.D{{Key: "productList.productName", Value: 1}})
+			}
+		}
+		if !found {
+			t.Errorf("no productList.productName index with the %s collation", locale)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CountersCollection holds one {_id, seq} document per sequence.
+const CountersCollection = "counters"
+
+func (r *MongoProductRepository) NextSequence(ctx context.Context, name string) (int64, error) {
+	// The upsert and $inc happen in one document write, so two callers can
+	// never be handed the same value. Concurrent upserts of a new name may
+	// race on the _id index; the loser retries against the existing counter.
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	filter := bson.M{"_id": name}
+	next := func() error {
+		start := time.Now()
+		res := r.counters.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"seq": int64(1)}}, opts)
+		return r.observeSingle(ctx, r.counters, QueryInfo{Operation: "findOneAndUpdate", Filter: filter}, start, res).Decode(&counter)
+	}
+	err := next()
+	if mongo.IsDuplicateKeyError(err) {
+		err = next()
+	}
+	return counter.Seq, err
+}
+
+// ProductSequence names the counter for products of an insurer within a
+// group.
+func ProductSequence(groupKey, insurerCode string) string {
+	return "product:" + groupKey + ":" + insurerCode
+}
+
+// ProductCode formats a product code: the group key, the insurer code and
+// the sequence, e.g. MOTOR-1-VIR-0042. Sequences past 9999 simply grow.
+func ProductCode(groupKey, insurerCode string, seq int64) string {
+	return fmt.Sprintf("%s-%s-%04d", strings.ToUpper(groupKey), strings.ToUpper(insurerCode), seq)
+}
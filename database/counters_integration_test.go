@@ -0,0 +1,127 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// These run against the integration-test database named by
+// MONGO_TEST_URI:
+//
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test -tags integration ./database
+
+// testRepository is a repository over a fresh database, dropped when the
+// test ends.
+func testRepository(t testing.TB) *MongoProductRepository {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("counters_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		client.Database(name).Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	repo, err := NewMongoProductRepository(client, "it", name, "productV4", config.MongoConfig{ListReadPreference: "primary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// TestNextSequenceConcurrent hammers counters that do not exist yet, so
+// the first upserts race, and checks that every caller got its own value
+// and none was skipped.
+func TestNextSequenceConcurrent(t *testing.T) {
+	repo := testRepository(t)
+	const workers, each = 32, 50
+	names := []string{ProductSequence("MTR", "AIA"), ProductSequence("MTR", "BKI")}
+
+	var mu sync.Mutex
+	seen := map[string]map[int64]bool{}
+	for _, name := range names {
+		seen[name] = map[int64]bool{}
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < each; i++ {
+				name := names[(w+i)%len(names)]
+				seq, err := repo.NextSequence(context.Background(), name)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[name][seq] {
+					t.Errorf("%s handed out %d twice", name, seq)
+				}
+				seen[name][seq] = true
+				mu.Unlock()
+			}
+		}(w)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, name := range names {
+		const want = workers * each / 2
+		if n := len(seen[name]); n != want {
+			t.Errorf("%s handed out %d values, want %d", name, n, want)
+		}
+		for seq := int64(1); seq <= want; seq++ {
+			if !seen[name][seq] {
+				t.Errorf("%s skipped %d", name, seq)
+				break
+			}
+		}
+	}
+}
+
+func TestProductCodeUnique(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	if _, err := EnsureIndexes(ctx, repo.products); err != nil {
+		t.Fatal(err)
+	}
+	group := func(key string, products ...bson.M) bson.M {
+		list := bson.A{}
+		for _, p := range products {
+			list = append(list, p)
+		}
+		return bson.M{"key": key, "productList": list}
+	}
+	// Products from before codes existed may all go without one.
+	if _, err := repo.products.InsertMany(ctx, []interface{}{
+		group("OLD-1", bson.M{"id": "A"}),
+		group("OLD-2", bson.M{"id": "B"}),
+		group("MTR", bson.M{"id": "C", "productCode": "MTR-AIA-0001"}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := repo.products.InsertOne(ctx, group("OTHER", bson.M{"id": "D", "productCode": "MTR-AIA-0001"}))
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("a second group reusing a code = %v, want a duplicate key error", err)
+	}
+}
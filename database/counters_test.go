@@ -0,0 +1,30 @@
+package database
+
+import "testing"
+
+func TestProductCode(t *testing.T) {
+	tests := []struct {
+		group, insurer string
+		seq            int64
+		want           string
+	}{
+		{"MTR", "AIA", 42, "MTR-AIA-0042"},
+		{"motor-1", "vir", 1, "MOTOR-1-VIR-0001"},
+		{"MTR", "AIA", 9999, "MTR-AIA-9999"},
+		{"MTR", "AIA", 10000, "MTR-AIA-10000"},
+	}
+	for _, tt := range tests {
+		if got := ProductCode(tt.group, tt.insurer, tt.seq); got != tt.want {
+			t.Errorf("ProductCode(%q, %q, %d) = %q, want %q", tt.group, tt.insurer, tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestProductSequence(t *testing.T) {
+	if a, b := ProductSequence("MTR", "AIA"), ProductSequence("MTR", "BKI"); a == b {
+		t.Errorf("two insurers in one group share the counter %q", a)
+	}
+	if a, b := ProductSequence("MTR", "AIA"), ProductSequence("HEALTH", "AIA"); a == b {
+		t.Errorf("one insurer in two groups shares the counter %q", a)
+	}
+}
@@ -0,0 +1,304 @@
+package database
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GroupDocument is a product group as stored in the products collection.
+// Decoding is deliberately lenient: data from old imports has missing or
+// mistyped fields, and one bad product must not fail a whole query.
+//
+// Fields ending in Lower are lowercase shadows of key-like fields, kept so
+// prefix searches can use an index; see RefreshSearchFields.
+type GroupDocument struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty"`
+	Key         LooseString          `bson:"key"`
+	KeyLower    LooseString          `bson:"keyLower"`
+	Name        LooseString          `bson:"name"`
+	ProductType *ProductTypeDocument `bson:"productType,omitempty"`
+	ProductList ProductList          `bson:"productList"`
+}
+
+type ProductTypeDocument struct {
+	Key      LooseString `bson:"key"`
+	KeyLower LooseString `bson:"keyLower"`
+	Name     LooseString `bson:"name"`
+}
+
+// ProductDocument is one entry of a group's productList. Malformed is set
+// when the entry is not a document at all.
+//
+// Older products have no id and are identified by an ObjectID _id instead;
+// see ProductID.
+type ProductDocument struct {
+	ID          LooseString      `bson:"id"`
+	OID         LooseString      `bson:"_id"`
+	Code        LooseString      `bson:"productCode"`
+	ProductName LooseString      `bson:"productName"`
+	Insurer     *InsurerDocument `bson:"insurer,omitempty"`
+	Brokers     []BrokerDocument `bson:"brokers,omitempty"`
+	Status      LooseString      `bson:"productStatus"`
+	CreatedAt   LooseTime        `bson:"createdAt"`
+	UpdatedAt   LooseTime        `bson:"updatedAt"`
+	CreatedBy   LooseString      `bson:"createdBy"`
+	UpdatedBy   LooseString      `bson:"updatedBy"`
+
+	Malformed bool `bson:"-"`
+}
+
+type InsurerDocument struct {
+	ID          LooseString `bson:"_id"`
+	InsurerCode LooseString `bson:"insurerCode"`
+	InsurerName LooseString `bson:"insurerName"`
+
+	InsurerCodeLower LooseString `bson:"insurerCodeLower"`
+}
+
+// BrokerDocument is one broker channel of a product. Malformed is set when
+// the entry is not a document.
+type BrokerDocument struct {
+	Key         LooseString `bson:"key"`
+	KeyLower    LooseString `bson:"keyLower"`
+	ChannelName LooseString `bson:"channelName"`
+
+	Malformed bool `bson:"-"`
+}
+
+// GroupProjection fetches exactly the fields GroupDocument decodes. It is
+// derived from the struct tags, so adding a field to the documents adds it
+// to the projection.
+var GroupProjection = Projection(GroupDocument{})
+
+// Projection returns an inclusion projection of every bson-tagged leaf field
+// of v, recursing into nested structs, pointers and slices with dotted
+// paths.
+func Projection(v interface{}) bson.M {
+	out := bson.M{}
+	projectFields(reflect.TypeOf(v), "", out)
+	return out
+}
+
+func projectFields(t reflect.Type, prefix string, out bson.M) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(primitive.ObjectID{}) {
+			projectFields(ft, path+".", out)
+			continue
+		}
+		out[path] = 1
+	}
+}
+
+// ProductID is the product's id, falling back to its hex _id.
+func (d ProductDocument) ProductID() string {
+	if d.ID != "" {
+		return d.ID.String()
+	}
+	return d.OID.String()
+}
+
+// Item returns the productList entry with the given id, in either form.
+func (g GroupDocument) Item(id string) (ProductDocument, bool) {
+	for _, item := range g.ProductList {
+		if !item.Malformed && item.ProductID() == id {
+			return item, true
+		}
+	}
+	return ProductDocument{}, false
+}
+
+// ItemFilter matches a group containing the product with the given id, in
+// either form. It uses $elemMatch so a positional productList.$ projection
+// or update addresses that product.
+func ItemFilter(id string) bson.M {
+	return bson.M{"productList": bson.M{"$elemMatch": ItemMatch(id, "")}}
+}
+
+// ItemsFilter matches the groups containing any of the products with the
+// given ids, in either form, with one $in per form.
+func ItemsFilter(ids []string) bson.M {
+	oids := bson.A{}
+	for _, id := range ids {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			oids = append(oids, oid)
+		}
+	}
+	filter := bson.M{"productList.id": bson.M{"$in": ids}}
+	if len(oids) > 0 {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"productList._id": bson.M{"$in": oids}}}}
+	}
+	return filter
+}
+
+// ItemMatch is the condition on a single productList entry behind
+// ItemFilter, with its paths prefixed by prefix, e.g. "p." for an array
+// filter.
+func ItemMatch(id, prefix string) bson.M {
+	match := bson.M{prefix + "id": id}
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		match = bson.M{"$or": bson.A{bson.M{prefix + "id": id}, bson.M{prefix + "_id": oid}}}
+	}
+	return match
+}
+
+// ProductList decodes a productList array, treating anything that is not an
+// array as empty.
+type ProductList []ProductDocument
+
+func (l *ProductList) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	*l = nil
+	if t != bsontype.Array {
+		return nil
+	}
+	values, err := bson.Raw(data).Values()
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		var d ProductDocument
+		if err := d.UnmarshalBSONValue(v.Type, v.Value); err != nil {
+			return err
+		}
+		*l = append(*l, d)
+	}
+	return nil
+}
+
+func (d *ProductDocument) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t != bsontype.EmbeddedDocument {
+		*d = ProductDocument{Malformed: true}
+		return nil
+	}
+	type plain ProductDocument
+	return bson.Unmarshal(data, (*plain)(d))
+}
+
+func (d *BrokerDocument) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t != bsontype.EmbeddedDocument {
+		*d = BrokerDocument{Malformed: true}
+		return nil
+	}
+	type plain BrokerDocument
+	return bson.Unmarshal(data, (*plain)(d))
+}
+
+func (d *InsurerDocument) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	*d = InsurerDocument{}
+	if t != bsontype.EmbeddedDocument {
+		return nil
+	}
+	type plain InsurerDocument
+	return bson.Unmarshal(data, (*plain)(d))
+}
+
+func (d *ProductTypeDocument) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	*d = ProductTypeDocument{}
+	if t != bsontype.EmbeddedDocument {
+		return nil
+	}
+	type plain ProductTypeDocument
+	return bson.Unmarshal(data, (*plain)(d))
+}
+
+// LooseString decodes a string field. ObjectIDs, numbers and booleans are
+// stringified; missing, null and any other type yield "" rather than an
+// error.
+type LooseString string
+
+func (s *LooseString) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	*s = ""
+	rv := bson.RawValue{Type: t, Value: data}
+	if err := rv.Validate(); err != nil {
+		return nil
+	}
+	var v interface{}
+	switch t {
+	case bsontype.String:
+		v = rv.StringValue()
+	case bsontype.ObjectID:
+		v = rv.ObjectID()
+	case bsontype.Int32:
+		v = rv.Int32()
+	case bsontype.Int64:
+		v = rv.Int64()
+	case bsontype.Double:
+		v = rv.Double()
+	case bsontype.Boolean:
+		v = rv.Boolean()
+	case bsontype.Decimal128:
+		v = rv.Decimal128()
+	}
+	str, _ := Stringify(v)
+	*s = LooseString(str)
+	return nil
+}
+
+// Stringify renders the scalar types LooseString accepts. ok is false for
+// nil and any other type.
+func Stringify(v interface{}) (s string, ok bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case primitive.ObjectID:
+		return t.Hex(), true
+	case int32:
+		return strconv.FormatInt(int64(t), 10), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case int:
+		return strconv.Itoa(t), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case primitive.Decimal128:
+		return t.String(), true
+	}
+	return "", false
+}
+
+func (s LooseString) String() string {
+	return string(s)
+}
+
+// LooseTime decodes a date field; any other type yields the zero time.
+type LooseTime struct {
+	time.Time
+}
+
+func (lt *LooseTime) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	*lt = LooseTime{}
+	rv := bson.RawValue{Type: t, Value: data}
+	if t == bsontype.DateTime && rv.Validate() == nil {
+		lt.Time = rv.Time().UTC()
+	}
+	return nil
+}
+
+// Ptr is the time, or nil when it is unset.
+func (lt LooseTime) Ptr() *time.Time {
+	if lt.IsZero() {
+		return nil
+	}
+	t := lt.Time
+	return &t
+}
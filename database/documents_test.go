@@ -0,0 +1,132 @@
+package database
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStringify(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	dec, _ := primitive.ParseDecimal128("1234.50")
+	tests := []struct {
+		in   interface{}
+		want string
+		ok   bool
+	}{
+		{"MT-001", "MT-001", true},
+		{"", "", true},
+		{oid, "65a0000000000000000000aa", true},
+		{int32(42), "42", true},
+		{int64(-9007199254740993), "-9007199254740993", true},
+		{7, "7", true},
+		{1.5, "1.5", true},
+		{1e21, "1000000000000000000000", true},
+		{true, "true", true},
+		{dec, "1234.50", true},
+		{nil, "", false},
+		{bson.M{"key": "x"}, "", false},
+		{bson.A{"x"}, "", false},
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := Stringify(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("Stringify(%#v) = %q, %t, want %q, %t", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLooseString(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"string", "ชั้น 1", "ชั้น 1"},
+		{"ObjectID", oid, "65a0000000000000000000aa"},
+		{"int32", int32(42), "42"},
+		{"int64", int64(math.MaxInt64), "9223372036854775807"},
+		{"double", 0.25, "0.25"},
+		{"bool", false, "false"},
+		{"null", nil, ""},
+		{"document", bson.M{"key": "x"}, ""},
+		{"array", bson.A{"x"}, ""},
+		{"date", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(bson.M{"v": tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := struct {
+				V LooseString `bson:"v"`
+			}{V: "stale"}
+			if err := bson.Unmarshal(raw, &out); err != nil {
+				t.Fatalf("decoding %#v: %v", tt.in, err)
+			}
+			if out.V != LooseString(tt.want) {
+				t.Errorf("decoded %#v as %q, want %q", tt.in, out.V, tt.want)
+			}
+		})
+	}
+}
+
+// bothStyles is a group whose products are identified by a string id and by
+// an ObjectID _id only.
+func bothStyles(t *testing.T) (GroupDocument, primitive.ObjectID) {
+	t.Helper()
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	raw, err := bson.Marshal(bson.M{"key": "MOTOR-1", "productList": bson.A{
+		bson.M{"id": "MT-001", "_id": primitive.NewObjectID(), "productName": "by id"},
+		bson.M{"_id": oid, "productName": "by _id"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var group GroupDocument
+	if err := bson.Unmarshal(raw, &group); err != nil {
+		t.Fatal(err)
+	}
+	return group, oid
+}
+
+func TestGroupDocumentItem(t *testing.T) {
+	group, oid := bothStyles(t)
+	for id, name := range map[string]string{"MT-001": "by id", oid.Hex(): "by _id"} {
+		item, ok := group.Item(id)
+		if !ok || item.ProductName != LooseString(name) || item.ProductID() != id {
+			t.Errorf("Item(%s) = %+v, %t, want the product %q", id, item, ok, name)
+		}
+	}
+	// A product with a string id is not also found by its _id.
+	if _, ok := group.Item(group.ProductList[0].OID.String()); ok {
+		t.Error("a product with an id was found by its _id")
+	}
+	if _, ok := group.Item("MT-999"); ok {
+		t.Error("found a product that is not there")
+	}
+}
+
+func TestItemMatch(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	if got, want := ItemMatch("MT-001", "p."), (bson.M{"p.id": "MT-001"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ItemMatch(MT-001) = %v, want %v", got, want)
+	}
+	want := bson.M{"$or": bson.A{bson.M{"id": oid.Hex()}, bson.M{"_id": oid}}}
+	if got := ItemMatch(oid.Hex(), ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("ItemMatch(hex) = %v, want %v", got, want)
+	}
+	wantMany := bson.M{"$or": bson.A{
+		bson.M{"productList.id": bson.M{"$in": []string{"MT-001", oid.Hex()}}},
+		bson.M{"productList._id": bson.M{"$in": bson.A{oid}}},
+	}}
+	if got := ItemsFilter([]string{"MT-001", oid.Hex()}); !reflect.DeepEqual(got, wantMany) {
+		t.Errorf("ItemsFilter = %v, want %v", got, wantMany)
+	}
+}
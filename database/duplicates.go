@@ -0,0 +1,32 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DuplicatesPipeline groups products by group key, insurer and NameKey and
+// keeps the groups with more than one product, largest first. Products
+// without a productNameKey, which migration 0005 backfills, are left out.
+func DuplicatesPipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$unwind", Value: "$productList"}},
+		{{Key: "$match", Value: bson.M{"productList.productNameKey": bson.M{"$gt": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"groupKey":    "$key",
+				"insurerCode": "$productList.insurer.insurerCodeLower",
+				"nameKey":     "$productList.productNameKey",
+			},
+			"count": bson.M{"$sum": 1},
+			"products": bson.M{"$push": bson.M{
+				"id":          bson.M{"$ifNull": bson.A{"$productList.id", bson.M{"$toString": "$productList._id"}}},
+				"code":        asString("$productList.productCode"),
+				"productName": asString("$productList.productName"),
+				"insurerCode": asString("$productList.insurer.insurerCode"),
+			}},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+}
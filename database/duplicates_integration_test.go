@@ -0,0 +1,66 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestDuplicatesPipeline lists the products of two groups that share
+// insurer and name key: only names equal once folded, within one group and
+// insurer, form a set.
+func TestDuplicatesPipeline(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	product := func(id, name, insurer string) bson.M {
+		return bson.M{"id": id, "productName": name, "productNameKey": NameKey(name),
+			"insurer": bson.M{"insurerCode": insurer, "insurerCodeLower": insurer}}
+	}
+	_, err := repo.products.InsertMany(ctx, []interface{}{
+		bson.M{"key": "HEALTH-PLUS", "productList": bson.A{
+			product("HP-001", "Health Plus", "AXA"),
+			product("HP-002", "health  PLUS", "AXA"),
+			product("HP-003", "Health Plus", "TIP"),
+			product("HP-004", "Health Plus Family", "AXA"),
+			bson.M{"id": "HP-005", "productName": "Health Plus"},
+		}},
+		// The same name in another group is not a duplicate.
+		bson.M{"key": "MOTOR-1", "productList": bson.A{product("MT-001", "Health Plus", "AXA")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := repo.products.Aggregate(ctx, DuplicatesPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sets []struct {
+		Key struct {
+			GroupKey, InsurerCode, NameKey string
+		} `bson:"_id"`
+		Count    int
+		Products []struct{ ID string }
+	}
+	if err := cursor.All(ctx, &sets); err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("sets = %+v, want one", sets)
+	}
+	set := sets[0]
+	if set.Key.GroupKey != "HEALTH-PLUS" || set.Key.InsurerCode != "AXA" || set.Key.NameKey != "health plus" || set.Count != 2 {
+		t.Errorf("set = %+v", set)
+	}
+	var ids []string
+	for _, p := range set.Products {
+		ids = append(ids, p.ID)
+	}
+	if want := []string{"HP-001", "HP-002"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("products = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,18 @@
+package database
+
+import "testing"
+
+func TestNameKey(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"Health Plus", "health plus"},
+		{"  HEALTH\tplus \n", "health plus"},
+		{"Straße  Cover", "strasse cover"},
+		{"ประกัน  สุขภาพ", "ประกัน สุขภาพ"},
+		// A decomposed accent compares equal to the composed one.
+		{"CAFE\u0301", "caf\u00e9"},
+	} {
+		if got := NameKey(tt.in); got != tt.want {
+			t.Errorf("NameKey(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BenchmarkExportBatchSize reads a seeded collection of 200 groups of 25
+// products the way the export does, at several cursor batch sizes, and
+// reports products exported per second:
+//
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test -tags integration -run '^$' -bench ExportBatchSize ./database
+//
+// Small batches pay a round trip every few products, so throughput should
+// rise with the batch size until the round trips stop mattering. Run this
+// against a server as far away as production's before changing the
+// MONGO_EXPORT_BATCH_SIZE default of 500: the curve depends on the network.
+func BenchmarkExportBatchSize(b *testing.B) {
+	const groups, perGroup = 200, 25
+	repo := testRepository(b)
+	ctx := context.Background()
+	docs := make([]interface{}, groups)
+	for g := range docs {
+		items := make(bson.A, perGroup)
+		for i := range items {
+			items[i] = bson.M{
+				"id":            fmt.Sprintf("P-%03d-%02d", g, i),
+				"productName":   fmt.Sprintf("ประกันภัย %d-%d", g, i),
+				"productStatus": "ACTIVE",
+				"insurer":       bson.M{"_id": "INS-TIP", "insurerCode": "TIP", "insurerName": "ทิพยประกันภัย"},
+				"brokers":       bson.A{bson.M{"key": "BROKER-ONLINE", "channelName": "Online"}},
+			}
+		}
+		docs[g] = bson.M{
+			"key":         fmt.Sprintf("GROUP-%03d", g),
+			"name":        fmt.Sprintf("Group %d", g),
+			"productType": bson.M{"key": "MOTOR", "name": "Motor"},
+			"productList": items,
+		}
+	}
+	if _, err := repo.products.InsertMany(ctx, docs); err != nil {
+		b.Fatal(err)
+	}
+	pipeline := append(FlattenStages(bson.M{}),
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "key", Value: 1}, {Key: "productList.id", Value: 1}}}},
+		bson.D{{Key: "$project", Value: ProductProjection}},
+	)
+
+	for _, size := range []int32{10, 100, 500, 1000, 5000} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			exported := 0
+			for i := 0; i < b.N; i++ {
+				cursor, err := repo.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(size))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for cursor.Next(ctx) {
+					var p bson.M
+					if err := cursor.Decode(&p); err != nil {
+						b.Fatal(err)
+					}
+					exported++
+				}
+				if err := cursor.Err(); err != nil {
+					b.Fatal(err)
+				}
+				cursor.Close(ctx)
+			}
+			if exported != b.N*groups*perGroup {
+				b.Fatalf("exported %d products, want %d", exported, b.N*groups*perGroup)
+			}
+			b.ReportMetric(float64(exported)/b.Elapsed().Seconds(), "products/s")
+		})
+	}
+}
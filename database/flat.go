@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	flatSyncLag    = metrics.NewGauge("products_flat_sync_lag_ms")
+	flatSyncErrors = metrics.NewCounter("products_flat_sync_errors")
+)
+
+// flatIndexes mirror productIndexes on the flattened paths.
+var flatIndexes = []mongo.IndexModel{
+	index("productName_id_1", bson.D{{Key: "productName", Value: 1}, {Key: "id", Value: 1}}),
+	collatedIndex("productName_id_th", bson.D{{Key: "productName", Value: 1}, {Key: "id", Value: 1}}, "th"),
+	collatedIndex("productName_id_en", bson.D{{Key: "productName", Value: 1}, {Key: "id", Value: 1}}, "en"),
+	index("groupKey_1", bson.D{{Key: "productGroup.key", Value: 1}}),
+	index("insurerCode_1", bson.D{{Key: "insurer.insurerCode", Value: 1}}),
+	index("brokerKey_1", bson.D{{Key: "brokers.key", Value: 1}}),
+	index("status_1", bson.D{{Key: "status", Value: 1}}),
+	uniqueCodeIndex("code_1", "code"),
+	index("searchGroupKey_1", bson.D{{Key: "search.groupKey", Value: 1}}),
+	index("searchTypeKey_1", bson.D{{Key: "search.typeKey", Value: 1}}),
+	index("searchInsurerCode_1", bson.D{{Key: "search.insurerCode", Value: 1}}),
+	index("searchBrokerKeys_1", bson.D{{Key: "search.brokerKeys", Value: 1}}),
+	index("groupId_1", bson.D{{Key: "groupId", Value: 1}, {Key: "syncedAt", Value: 1}}),
+}
+
+// flatPaths maps group document paths to their products_flat counterparts.
+var flatPaths = map[string]string{
+	"key":                             "productGroup.key",
+	"name":                            "productGroup.name",
+	"productType.key":                 "productType.key",
+	"productType.name":                "productType.name",
+	"productList.id":                  "id",
+	"productList.productName":         "productName",
+	"productList.insurer.insurerCode": "insurer.insurerCode",
+	"productList.brokers.key":         "brokers.key",
+	"productList.productStatus":       "status",
+	"productList.productCode":         "code",
+
+	"keyLower":                             "search.groupKey",
+	"productType.keyLower":                 "search.typeKey",
+	"productList.insurer.insurerCodeLower": "search.insurerCode",
+	"productList.brokers.keyLower":         "search.brokerKeys",
+}
+
+// FlatFilter rewrites a filter on group documents into the same filter on
+// the flat collection. Operators pass through; unknown paths are kept as
+// they are.
+func FlatFilter(filter interface{}) interface{} {
+	switch f := filter.(type) {
+	case bson.M:
+		out := bson.M{}
+		for k, v := range f {
+			if p, ok := flatPaths[k]; ok {
+				k = p
+			}
+			out[k] = FlatFilter(v)
+		}
+		return out
+	case []bson.M:
+		out := make([]bson.M, len(f))
+		for i, v := range f {
+			out[i] = FlatFilter(v).(bson.M)
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(f))
+		for i, v := range f {
+			out[i] = FlatFilter(v)
+		}
+		return out
+	}
+	return filter
+}
+
+// ProductExpr is a $expr condition that holds when a product satisfies
+// cond. cond builds it from field, which returns the expression of a
+// field of the product given its path within a productList entry. It
+// holds for a group document with such a product, for an unwound
+// productList entry and for a products_flat document alike, so unlike a
+// query on productList paths it can say what a single product lacks and
+// still serve as a listing filter on all three.
+func ProductExpr(cond func(field func(path string) string) bson.M) bson.M {
+	in := func(prefix string) func(string) string {
+		return func(path string) string { return prefix + path }
+	}
+	flat := func(path string) string {
+		if p, ok := flatPaths["productList."+path]; ok {
+			return "$" + p
+		}
+		return "$" + path
+	}
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{
+				"case": bson.M{"$isArray": "$productList"},
+				"then": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{"input": "$productList", "as": "p", "in": cond(in("$$p."))}}}},
+			},
+			bson.M{
+				"case": bson.M{"$eq": bson.A{bson.M{"$type": "$productList"}, "object"}},
+				"then": cond(in("$productList.")),
+			},
+		},
+		"default": cond(flat),
+	}}
+}
+
+// FlatSort is the flat collection's equivalent of the listing sort.
+var FlatSort = bson.D{{Key: "productName", Value: 1}, {Key: "id", Value: 1}}
+
+// FlatProjection hides the sync bookkeeping from readers.
+var FlatProjection = bson.M{"_id": 0, "groupId": 0, "syncedAt": 0, "search": 0}
+
+// SyncFlatGroup rebuilds the flat documents of one group. Products removed
+// from the group, or the whole group when it was deleted, disappear.
+func SyncFlatGroup(ctx context.Context, t *Tenant, groupID interface{}) error {
+	err := syncFlat(ctx, t, bson.M{"_id": groupID}, bson.M{"groupId": groupID})
+	if err != nil {
+		flatSyncErrors.Inc(t.Name)
+	}
+	return err
+}
+
+// BackfillFlat rebuilds the whole flat collection of a tenant.
+func BackfillFlat(ctx context.Context, t *Tenant) error {
+	start := time.Now()
+	err := syncFlat(ctx, t, nil, bson.M{})
+	if err != nil {
+		flatSyncErrors.Inc(t.Name)
+		return err
+	}
+	log.Printf("Backfilled products_flat for tenant %s in %s", t.Name, time.Since(start))
+	return nil
+}
+
+// syncFlat merges the flattened products of the groups matching filter into
+// the flat collection, then deletes the stale documents in scope: those the
+// merge did not touch.
+func syncFlat(ctx context.Context, t *Tenant, filter, scope bson.M) error {
+	syncedAt := time.Now().UTC()
+	projection := bson.M{
+		"_id":      bson.M{"g": "$_id", "p": ProductProjection["id"]},
+		"groupId":  "$_id",
+		"syncedAt": bson.M{"$literal": syncedAt},
+		// The lowercase shadows of the group document, see FlatFilter.
+		"search": bson.M{
+			"groupKey":    "$keyLower",
+			"typeKey":     "$productType.keyLower",
+			"insurerCode": "$productList.insurer.insurerCodeLower",
+			"brokerKeys":  "$productList.brokers.keyLower",
+		},
+	}
+	for k, v := range ProductProjection {
+		if k != "_id" {
+			projection[k] = v
+		}
+	}
+	pipeline := append(FlattenStages(filter),
+		bson.D{{Key: "$project", Value: projection}},
+		bson.D{{Key: "$merge", Value: bson.M{
+			"into":           t.Flat.Name(),
+			"on":             "_id",
+			"whenMatched":    "replace",
+			"whenNotMatched": "insert",
+		}}},
+	)
+	cursor, err := t.Products.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	cursor.Close(ctx)
+
+	scope["syncedAt"] = bson.M{"$lt": syncedAt}
+	_, err = t.Flat.DeleteMany(ctx, scope)
+	return err
+}
+
+// ObserveFlatLag records how far products_flat trails a change to the
+// source collection that happened at changedAt.
+func ObserveFlatLag(t *Tenant, changedAt time.Time) {
+	flatSyncLag.Set(time.Since(changedAt).Milliseconds(), t.Name)
+}
+
+// RunFlatResync backfills the tenant's flat collection now and then every
+// interval until ctx is cancelled. Incremental syncs can miss changes (a
+// failed sync, a change stream gap, a standalone server without change
+// streams); the interval bounds how long such a miss survives.
+func RunFlatResync(ctx context.Context, t *Tenant, interval time.Duration) {
+	for {
+		start := time.Now()
+		if err := BackfillFlat(ctx, t); err != nil && ctx.Err() == nil {
+			log.Printf("Error backfilling products_flat for tenant %s: %v", t.Name, err)
+		} else if err == nil {
+			ObserveFlatLag(t, start)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FlattenStages unwinds productList and leaves one document per matching
+// item. The filter runs twice: before $unwind it narrows groups using the
+// productList indexes, after it the same paths address a single item.
+// Entries that are not documents cannot be flattened and are dropped.
+func FlattenStages(filter bson.M) mongo.Pipeline {
+	return flattenStages(filter, false)
+}
+
+// FlattenStagesKeepingMalformed is FlattenStages for callers that report
+// malformed entries: they are kept, and every document carries the entry's
+// position in productList as itemIndex. See ListingProjection.
+func FlattenStagesKeepingMalformed(filter bson.M) mongo.Pipeline {
+	return flattenStages(filter, true)
+}
+
+func flattenStages(filter bson.M, keepMalformed bool) mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: GroupProjection}})
+	if keepMalformed {
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: bson.M{
+			"path":              "$productList",
+			"includeArrayIndex": "itemIndex",
+		}}})
+	} else {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$unwind", Value: "$productList"}},
+			bson.D{{Key: "$match", Value: bson.M{"productList": bson.M{"$type": "object"}}}},
+		)
+	}
+	if len(filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+	}
+	return pipeline
+}
+
+// ProductProjection produces the handlers.Product JSON shape. Every string goes
+// through asString so that missing or mistyped fields decode as "" the way
+// the typed documents do.
+var ProductProjection = bson.M{
+	"_id": 0,
+	"id": bson.M{"$cond": bson.A{
+		bson.M{"$ne": bson.A{asString("$productList.id"), ""}},
+		asString("$productList.id"),
+		asString("$productList._id"),
+	}},
+	"productName": asString("$productList.productName"),
+	"productGroup": bson.M{
+		"name": asString("$name"),
+		"key":  asString("$key"),
+	},
+	"productType": bson.M{
+		"name": asString("$productType.name"),
+		"key":  asString("$productType.key"),
+	},
+	"insurer": bson.M{
+		"_id":         asString("$productList.insurer._id"),
+		"insurerCode": asString("$productList.insurer.insurerCode"),
+		"insurerName": asString("$productList.insurer.insurerName"),
+	},
+	"brokers": bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{
+			"input": bson.M{"$cond": bson.A{bson.M{"$isArray": "$productList.brokers"}, "$productList.brokers", bson.A{}}},
+			"cond":  bson.M{"$eq": bson.A{bson.M{"$type": "$$this"}, "object"}},
+		}},
+		"as": "b",
+		"in": bson.M{
+			"key":         asString("$$b.key"),
+			"channelName": asString("$$b.channelName"),
+		},
+	}},
+	"code":      asString("$productList.productCode"),
+	"status":    asString("$productList.productStatus"),
+	"createdAt": asDate("$productList.createdAt"),
+	"updatedAt": asDate("$productList.updatedAt"),
+	"createdBy": asString("$productList.createdBy"),
+	"updatedBy": asString("$productList.updatedBy"),
+}
+
+// ListingProjection is ProductProjection plus, for documents from
+// FlattenStagesKeepingMalformed, whether the entry was malformed and its
+// itemIndex.
+var ListingProjection = func() bson.M {
+	out := bson.M{
+		"malformed": bson.M{"$ne": bson.A{bson.M{"$type": "$productList"}, "object"}},
+		"itemIndex": "$itemIndex",
+	}
+	for k, v := range ProductProjection {
+		out[k] = v
+	}
+	return out
+}()
+
+// asDate is the field when it is a date and null otherwise, matching
+// LooseTime.
+func asDate(path string) bson.M {
+	return bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$type": path}, "date"}}, path, nil}}
+}
+
+// stringifiedTypes are the BSON types asString renders, matching
+// LooseString.
+var stringifiedTypes = bson.A{"string", "objectId", "int", "long", "double", "decimal", "bool"}
+
+// asString renders the field as a string the way LooseString does, and ""
+// for missing, null and other types.
+func asString(path string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$in": bson.A{bson.M{"$type": path}, stringifiedTypes}},
+		bson.M{"$toString": path},
+		"",
+	}}
+}
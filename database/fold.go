@@ -0,0 +1,32 @@
+package database
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize puts s in Unicode NFC, so composed and decomposed spellings of
+// the same text compare equal.
+func Normalize(s string) string {
+	return norm.NFC.String(s)
+}
+
+// Decomposed is s in NFD, the other form stored text may arrive in.
+func Decomposed(s string) string {
+	return norm.NFD.String(s)
+}
+
+// Fold normalizes s and case-folds it with full Unicode rules, unlike
+// strings.ToLower or Mongo's $toLower which only fold ASCII reliably.
+// Thai has no case, so Thai text is only normalized.
+func Fold(s string) string {
+	return cases.Fold().String(norm.NFC.String(s))
+}
+
+// NameKey is the form product names are compared in for uniqueness: folded,
+// with white space trimmed and runs of it collapsed to one space.
+func NameKey(s string) string {
+	return strings.Join(strings.Fields(Fold(s)), " ")
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GroupSummaryPipeline lists groups ordered by key with their product
+// count instead of their products.
+func GroupSummaryPipeline(skip, limit int64) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "key", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: skip}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{
+			"_id":  0,
+			"key":  asString("$key"),
+			"name": asString("$name"),
+			"productType": bson.M{
+				"key":  asString("$productType.key"),
+				"name": asString("$productType.name"),
+			},
+			"productCount": bson.M{"$size": bson.M{"$cond": bson.A{bson.M{"$isArray": "$productList"}, "$productList", bson.A{}}}},
+		}}},
+	}
+}
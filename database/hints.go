@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var hintedQueries = metrics.NewCounter("mongo_hinted_queries")
+
+// ObserveHint counts a listing query as hinted or unhinted.
+func ObserveHint(tenant string, hinted bool) {
+	if hinted {
+		hintedQueries.Inc("hinted", tenant)
+		return
+	}
+	hintedQueries.Inc("unhinted", tenant)
+}
+
+// FilterShape names the shape of a filter for index hint configuration:
+// its top-level fields, sorted and joined by "+". An empty filter has the
+// shape "".
+func FilterShape(filter bson.M) string {
+	keys := make([]string, 0, len(filter))
+	for k := range filter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "+")
+}
+
+// indexNamesTTL is how long a collection's index list is trusted. Hints
+// are validated against it on every query, so it has to be cheap.
+const indexNamesTTL = time.Minute
+
+var indexNames = struct {
+	sync.Mutex
+	byColl map[string]indexNamesEntry
+}{byColl: map[string]indexNamesEntry{}}
+
+type indexNamesEntry struct {
+	names   map[string]bool
+	fetched time.Time
+}
+
+// HasIndex reports whether coll has an index with the given name.
+func HasIndex(ctx context.Context, coll *mongo.Collection, name string) (bool, error) {
+	key := coll.Database().Name() + "." + coll.Name()
+	indexNames.Lock()
+	entry, ok := indexNames.byColl[key]
+	indexNames.Unlock()
+	if ok && time.Since(entry.fetched) < indexNamesTTL {
+		return entry.names[name], nil
+	}
+
+	names := map[string]bool{}
+	var specs []struct {
+		Name string `bson:"name"`
+	}
+	cursor, err := coll.Indexes().List(ctx)
+	if err == nil {
+		err = cursor.All(ctx, &specs)
+	}
+	if err != nil && !isNamespaceNotFound(err) {
+		return false, err
+	}
+	for _, spec := range specs {
+		names[spec.Name] = true
+	}
+	indexNames.Lock()
+	indexNames.byColl[key] = indexNamesEntry{names: names, fetched: time.Now()}
+	indexNames.Unlock()
+	return names[name], nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// productIndexes are the indexes the listing and search paths rely on.
+// Names are explicit so EnsureIndexes can tell which already exist.
+var productIndexes = []mongo.IndexModel{
+	index("productName_1", bson.D{{Key: "productList.productName", Value: 1}}),
+	index("key_1", bson.D{{Key: "key", Value: 1}}),
+	index("insurerCode_1", bson.D{{Key: "productList.insurer.insurerCode", Value: 1}}),
+	index("brokerKey_1", bson.D{{Key: "productList.brokers.key", Value: 1}}),
+	index("productStatus_1", bson.D{{Key: "productList.productStatus", Value: 1}}),
+	uniqueCodeIndex("productCode_1", "productList.productCode"),
+	index("key_insurerCodeLower_nameKey_1", bson.D{
+		{Key: "key", Value: 1},
+		{Key: "productList.insurer.insurerCodeLower", Value: 1},
+		{Key: "productList.productNameKey", Value: 1},
+	}),
+	index("keyLower_1", bson.D{{Key: "keyLower", Value: 1}}),
+	index("productTypeKeyLower_1", bson.D{{Key: "productType.keyLower", Value: 1}}),
+	index("insurerCodeLower_1", bson.D{{Key: "productList.insurer.insurerCodeLower", Value: 1}}),
+	index("brokerKeyLower_1", bson.D{{Key: "productList.brokers.keyLower", Value: 1}}),
+	collatedIndex("productName_th", bson.D{{Key: "productList.productName", Value: 1}}, "th"),
+	collatedIndex("productName_en", bson.D{{Key: "productList.productName", Value: 1}}, "en"),
+}
+
+// collatedIndex is index with one of the listing collations, which is what
+// lets a collated sort use it.
+func collatedIndex(name string, keys bson.D, locale string) mongo.IndexModel {
+	model := index(name, keys)
+	model.Options.SetCollation(Collations[locale])
+	return model
+}
+
+// uniqueCodeIndex is a unique index on a product code path. Products from
+// before codes existed have none, or an empty one in the flat collection,
+// and are left out. On group documents the index is multikey, which keeps
+// codes unique across groups but not within one; the counters take care of
+// that.
+func uniqueCodeIndex(name, path string) mongo.IndexModel {
+	model := index(name, bson.D{{Key: path, Value: 1}})
+	model.Options.SetUnique(true).SetPartialFilterExpression(bson.M{path: bson.M{"$gt": ""}})
+	return model
+}
+
+func index(name string, keys bson.D) mongo.IndexModel {
+	// Background only matters for servers older than 4.2, which otherwise
+	// lock the collection for the duration of the build.
+	return mongo.IndexModel{Keys: keys, Options: options.Index().SetName(name).SetBackground(true)}
+}
+
+// IndexReport lists the outcome of EnsureIndexes by index name.
+type IndexReport struct {
+	Created []string `json:"created"`
+	Present []string `json:"present"`
+}
+
+// EnsureIndexes creates any missing product indexes. It is idempotent and
+// safe to run while the service is serving traffic.
+func EnsureIndexes(ctx context.Context, coll *mongo.Collection) (IndexReport, error) {
+	return ensureIndexes(ctx, coll, productIndexes)
+}
+
+// EnsureFlatIndexes is EnsureIndexes for a products_flat collection.
+func EnsureFlatIndexes(ctx context.Context, coll *mongo.Collection) (IndexReport, error) {
+	return ensureIndexes(ctx, coll, flatIndexes)
+}
+
+func ensureIndexes(ctx context.Context, coll *mongo.Collection, models []mongo.IndexModel) (IndexReport, error) {
+	report := IndexReport{Created: []string{}, Present: []string{}}
+
+	existing := map[string]bool{}
+	var specs []bson.M
+	cursor, err := coll.Indexes().List(ctx)
+	if err == nil {
+		err = cursor.All(ctx, &specs)
+	}
+	// A collection that does not exist yet simply has no indexes.
+	if err != nil && !isNamespaceNotFound(err) {
+		return report, err
+	}
+	for _, spec := range specs {
+		if name, ok := spec["name"].(string); ok {
+			existing[name] = true
+		}
+	}
+
+	var missing []mongo.IndexModel
+	for _, model := range models {
+		name := *model.Options.Name
+		if existing[name] {
+			report.Present = append(report.Present, name)
+			continue
+		}
+		missing = append(missing, model)
+	}
+	if len(missing) > 0 {
+		created, err := coll.Indexes().CreateMany(ctx, missing)
+		if err != nil {
+			return report, err
+		}
+		report.Created = append(report.Created, created...)
+	}
+
+	log.Printf("Indexes ensured on %s: created %v, already present %v", coll.Name(), report.Created, report.Present)
+	return report, nil
+}
+
+func isNamespaceNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 26
+}
+
+// ensureTTLIndex makes documents in coll expire ttl after their field time.
+func ensureTTLIndex(ctx context.Context, coll *mongo.Collection, field string, ttl time.Duration) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName(field + "_ttl").SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	return err
+}
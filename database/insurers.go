@@ -0,0 +1,22 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// InsurersCollection holds the insurer master data, one document per
+// insurer with the insurer code as _id.
+const InsurersCollection = "insurers"
+
+// InsurerProductsPipeline counts the products of the insurer with the given
+// code, as a single {count} document or none when there are no products.
+func InsurerProductsPipeline(code string) mongo.Pipeline {
+	match := bson.M{"productList.insurer.insurerCode": code}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$productList"}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$count", Value: "count"}},
+	}
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The issue types of the integrity report.
+const (
+	IssueUnknownInsurer = "unknown_insurer"
+	IssueUnknownBroker  = "unknown_broker"
+	IssueDuplicateID    = "duplicate_id"
+	IssueMissingFields  = "missing_fields"
+	IssueInvalidStatus  = "invalid_status"
+)
+
+// IntegrityIssues lists the issue types in report order.
+var IntegrityIssues = []string{IssueUnknownInsurer, IssueUnknownBroker, IssueDuplicateID, IssueMissingFields, IssueInvalidStatus}
+
+// IntegrityRow is one offending product, or for duplicate_id one duplicated
+// id within a group. Value is the offending value: the unknown code or
+// key, the number of copies, the names of the missing fields, or the
+// status.
+type IntegrityRow struct {
+	GroupID   interface{} `bson:"groupId" json:"groupId"`
+	GroupKey  string      `bson:"groupKey" json:"groupKey"`
+	ProductID string      `bson:"productId" json:"productId"`
+	Value     interface{} `bson:"value" json:"value"`
+}
+
+// productIDExpr is ProductDocument.ProductID as an expression on an
+// unwound productList.
+var productIDExpr = bson.M{"$cond": bson.A{
+	bson.M{"$ne": bson.A{asString("$productList.id"), ""}},
+	asString("$productList.id"),
+	asString("$productList._id"),
+}}
+
+func emptyString(path string) bson.M {
+	return bson.M{"$eq": bson.A{asString(path), ""}}
+}
+
+// IntegrityStages returns the stages yielding the IntegrityRows of issue,
+// and false for an unknown issue. statuses are the valid product statuses.
+// Entries that are not documents are skipped; Verify counts them.
+func IntegrityStages(issue string, statuses []string) (mongo.Pipeline, bool) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$productList"}},
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$eq": bson.A{bson.M{"$type": "$productList"}, "object"}}}}},
+	}
+	row := func(value interface{}) bson.D {
+		return bson.D{{Key: "$project", Value: bson.M{
+			"_id":       0,
+			"groupId":   "$_id",
+			"groupKey":  asString("$key"),
+			"productId": productIDExpr,
+			"value":     value,
+		}}}
+	}
+	unknown := func(local, from string) mongo.Pipeline {
+		return mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{local: bson.M{"$nin": bson.A{nil, ""}}}}},
+			{{Key: "$lookup", Value: bson.M{"from": from, "localField": local, "foreignField": "_id", "as": "master"}}},
+			{{Key: "$match", Value: bson.M{"master": bson.M{"$size": 0}}}},
+			row(asString("$" + local)),
+		}
+	}
+
+	switch issue {
+	case IssueUnknownInsurer:
+		return append(pipeline, unknown("productList.insurer.insurerCode", InsurersCollection)...), true
+	case IssueUnknownBroker:
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: "$productList.brokers"}})
+		return append(pipeline, unknown("productList.brokers.key", BrokersCollection)...), true
+	case IssueDuplicateID:
+		return append(pipeline,
+			bson.D{{Key: "$group", Value: bson.M{
+				"_id": bson.M{"g": "$_id", "id": productIDExpr},
+				"key": bson.M{"$first": "$key"},
+				"n":   bson.M{"$sum": 1},
+			}}},
+			bson.D{{Key: "$match", Value: bson.M{"n": bson.M{"$gt": 1}, "_id.id": bson.M{"$ne": ""}}}},
+			bson.D{{Key: "$project", Value: bson.M{
+				"_id":       0,
+				"groupId":   "$_id.g",
+				"groupKey":  asString("$key"),
+				"productId": "$_id.id",
+				"value":     "$n",
+			}}},
+		), true
+	case IssueMissingFields:
+		required := bson.A{
+			bson.M{"name": "id", "missing": bson.M{"$and": bson.A{emptyString("$productList.id"), emptyString("$productList._id")}}},
+			bson.M{"name": "productName", "missing": emptyString("$productList.productName")},
+			bson.M{"name": "insurer.insurerCode", "missing": emptyString("$productList.insurer.insurerCode")},
+			bson.M{"name": "productStatus", "missing": emptyString("$productList.productStatus")},
+		}
+		return append(pipeline,
+			bson.D{{Key: "$set", Value: bson.M{"missing": bson.M{"$map": bson.M{
+				"input": bson.M{"$filter": bson.M{"input": required, "cond": "$$this.missing"}},
+				"in":    "$$this.name",
+			}}}}},
+			bson.D{{Key: "$match", Value: bson.M{"missing.0": bson.M{"$exists": true}}}},
+			row("$missing"),
+		), true
+	case IssueInvalidStatus:
+		return append(pipeline,
+			bson.D{{Key: "$match", Value: bson.M{"productList.productStatus": bson.M{"$nin": append(bson.A{nil, ""}, toA(statuses)...)}}}},
+			row(asString("$productList.productStatus")),
+		), true
+	}
+	return nil, false
+}
+
+func toA(values []string) bson.A {
+	out := make(bson.A, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// FixItemField sets the productList field at path from one value to
+// another in every product holding it, returning the number of groups
+// changed.
+func FixItemField(ctx context.Context, coll *mongo.Collection, path string, from, to interface{}) (int64, error) {
+	res, err := coll.UpdateMany(ctx,
+		bson.M{"productList." + path: from},
+		bson.M{"$set": bson.M{"productList.$[p]." + path: to}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"p." + path: from}}}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
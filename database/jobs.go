@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobsCollection records background jobs and their progress.
+const JobsCollection = "jobs"
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	// JobIncomplete is a job that ran to the end but whose verification
+	// still found work left, typically because of concurrent writes.
+	JobIncomplete = "incomplete"
+)
+
+// JobInsurerRename is the kind of the jobs PropagateInsurerName runs.
+const JobInsurerRename = "insurer.rename"
+
+type Job struct {
+	ID     primitive.ObjectID `bson:"_id" json:"id"`
+	Kind   string             `bson:"kind" json:"kind"`
+	Target string             `bson:"target" json:"target"`
+	State  string             `bson:"state" json:"state"`
+	// Params are the kind's arguments; an insurer rename has "from" and
+	// "to", the old and new name.
+	Params map[string]string `bson:"params" json:"params"`
+	// Batches and Groups count the batches run and group documents updated.
+	Batches int64 `bson:"batches" json:"batches"`
+	Groups  int64 `bson:"groups" json:"groups"`
+	// Stale is what the closing verification found left to do.
+	Stale      int64      `bson:"stale" json:"stale"`
+	Error      string     `bson:"error,omitempty" json:"error,omitempty"`
+	Actor      string     `bson:"actor" json:"actor"`
+	StartedAt  time.Time  `bson:"startedAt" json:"startedAt"`
+	UpdatedAt  time.Time  `bson:"updatedAt" json:"updatedAt"`
+	FinishedAt *time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+// SaveJob writes the job's current state.
+func SaveJob(ctx context.Context, t *Tenant, job *Job) error {
+	job.UpdatedAt = Timestamp(time.Now())
+	_, err := t.Jobs.ReplaceOne(ctx, bson.M{"_id": job.ID}, job, options.Replace().SetUpsert(true))
+	return err
+}
+
+// staleInsurer matches the productList entries of the insurer whose name is
+// not name.
+func staleInsurer(prefix, code, name string) bson.M {
+	return bson.M{prefix + "insurer.insurerCode": code, prefix + "insurer.insurerName": bson.M{"$ne": name}}
+}
+
+// PropagateInsurerName copies the insurer's master name into every product
+// embedding the insurer, batchSize groups at a time, saving job's progress
+// after each batch. It only ever selects groups still holding a stale
+// name, so it can be rerun to resume a job that stopped. Each group is
+// updated atomically; readers see a group either before or after.
+//
+// The name is reread from the master data for every batch, so when the
+// insurer is renamed again meanwhile, concurrent jobs converge on the
+// latest name.
+func PropagateInsurerName(ctx context.Context, t *Tenant, job *Job, code string, batchSize int, syncFlat bool) error {
+	for {
+		var insurer struct {
+			Name string `bson:"insurerName"`
+		}
+		if err := t.Insurers.FindOne(ctx, bson.M{"_id": code}).Decode(&insurer); err != nil {
+			return err
+		}
+		stale := bson.M{"productList": bson.M{"$elemMatch": staleInsurer("", code, insurer.Name)}}
+
+		cursor, err := t.Products.Find(ctx, stale,
+			options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"_id": 1}).SetLimit(int64(batchSize)))
+		if err != nil {
+			return err
+		}
+		var groups []struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			break
+		}
+		ids := make(bson.A, len(groups))
+		for i, g := range groups {
+			ids[i] = g.ID
+		}
+		res, err := t.Products.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{"productList.$[p].insurer.insurerName": insurer.Name}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{staleInsurer("p.", code, insurer.Name)}}),
+		)
+		if err != nil {
+			return err
+		}
+		if syncFlat {
+			for _, id := range ids {
+				if err := SyncFlatGroup(ctx, t, id); err != nil {
+					return err
+				}
+			}
+		}
+		job.Batches++
+		job.Groups += res.ModifiedCount
+		if err := SaveJob(ctx, t, job); err != nil {
+			return err
+		}
+	}
+	return verifyInsurerName(ctx, t, job, code)
+}
+
+// verifyInsurerName counts the groups still holding a stale name into
+// job.Stale.
+func verifyInsurerName(ctx context.Context, t *Tenant, job *Job, code string) error {
+	var insurer struct {
+		Name string `bson:"insurerName"`
+	}
+	if err := t.Insurers.FindOne(ctx, bson.M{"_id": code}).Decode(&insurer); err != nil {
+		return err
+	}
+	n, err := t.Products.CountDocuments(ctx, bson.M{"productList": bson.M{"$elemMatch": staleInsurer("", code, insurer.Name)}})
+	job.Stale = n
+	return err
+}
+
+// FindJob returns the job with the given id, or ok false.
+func FindJob(ctx context.Context, t *Tenant, id primitive.ObjectID) (job Job, ok bool, err error) {
+	err = t.Jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return job, false, nil
+	}
+	return job, err == nil, err
+}
@@ -0,0 +1,16 @@
+package database
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const codeMaxTimeMSExpired = 50
+
+// IsMaxTimeExpired reports whether the server aborted an operation for
+// exceeding its maxTimeMS.
+func IsMaxTimeExpired(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorCode(codeMaxTimeMSExpired)
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	poolCheckoutWait   = metrics.NewTimer("mongo_pool_checkout_wait")
+	poolCheckoutFailed = metrics.NewCounter("mongo_pool_checkout_failed")
+	poolInUse          = metrics.NewGauge("mongo_pool_in_use")
+	poolOpen           = metrics.NewGauge("mongo_pool_open")
+)
+
+// poolMonitor feeds driver connection pool events into metrics, labelled by
+// server address.
+func poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.GetSucceeded:
+				poolCheckoutWait.Observe(e.Duration, e.Address)
+				poolInUse.Add(1, e.Address)
+			case event.GetFailed:
+				poolCheckoutFailed.Inc(e.Address, e.Reason)
+			case event.ConnectionReturned:
+				poolInUse.Add(-1, e.Address)
+			case event.ConnectionCreated:
+				poolOpen.Add(1, e.Address)
+			case event.ConnectionClosed:
+				poolOpen.Add(-1, e.Address)
+			}
+		},
+	}
+}
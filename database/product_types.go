@@ -0,0 +1,26 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ProductTypesCollection holds the product type master data, one document
+// per type with the type key as _id.
+const ProductTypesCollection = "product_types"
+
+// ProductTypeUsagePipeline groups the group documents by their embedded
+// productType key and name, with the ids of the groups in each.
+func ProductTypeUsagePipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"key":  asString("$productType.key"),
+				"name": asString("$productType.name"),
+			},
+			"groups":   bson.M{"$sum": 1},
+			"groupIds": bson.M{"$push": "$_id"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.key", Value: 1}, {Key: "_id.name", Value: 1}}}},
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// QualityRule is a data quality rule: Offending is an aggregation
+// expression on an unwound productList that is true for a product the
+// rule flags.
+type QualityRule struct {
+	Name        string
+	Description string
+	Offending   bson.M
+}
+
+var (
+	qualityMu    sync.RWMutex
+	qualityRules = map[string]QualityRule{}
+)
+
+// RegisterQualityRule adds a rule to the data quality report, replacing
+// any rule of the same name.
+func RegisterQualityRule(rule QualityRule) {
+	qualityMu.Lock()
+	defer qualityMu.Unlock()
+	qualityRules[rule.Name] = rule
+}
+
+// QualityRules returns the registered rules by name.
+func QualityRules() []QualityRule {
+	qualityMu.RLock()
+	defer qualityMu.RUnlock()
+	rules := make([]QualityRule, 0, len(qualityRules))
+	for _, r := range qualityRules {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// QualityRuleNamed returns the registered rule with the name.
+func QualityRuleNamed(name string) (QualityRule, bool) {
+	qualityMu.RLock()
+	defer qualityMu.RUnlock()
+	r, ok := qualityRules[name]
+	return r, ok
+}
+
+func init() {
+	RegisterQualityRule(QualityRule{
+		Name:        "missing_brokers",
+		Description: "the product is sold through no broker channel",
+		Offending: bson.M{"$eq": bson.A{
+			bson.M{"$size": bson.M{"$cond": bson.A{bson.M{"$isArray": "$productList.brokers"}, "$productList.brokers", bson.A{}}}},
+			0,
+		}},
+	})
+	RegisterQualityRule(QualityRule{
+		Name:        "missing_code",
+		Description: "the product has no generated product code",
+		Offending:   emptyString("$productList.productCode"),
+	})
+	RegisterQualityRule(QualityRule{
+		Name:        "missing_insurer_name",
+		Description: "the product's insurer has no name",
+		Offending:   emptyString("$productList.insurer.insurerName"),
+	})
+	RegisterQualityRule(QualityRule{
+		Name:        "missing_product_type",
+		Description: "the product's group has no product type",
+		Offending:   emptyString("$productType.key"),
+	})
+}
+
+// QualityRow is a product a rule flags.
+type QualityRow struct {
+	GroupKey    string `bson:"groupKey" json:"groupKey"`
+	ProductID   string `bson:"productId" json:"productId"`
+	ProductName string `bson:"productName" json:"productName"`
+}
+
+// QualityReportPipeline counts the products each rule flags and, per
+// group, the products no rule flags. Its single document has "rules",
+// counts by rule name, and "groups", with groupKey, products and
+// complete.
+func QualityReportPipeline(rules []QualityRule) mongo.Pipeline {
+	flags, counts := bson.M{}, bson.M{"_id": nil}
+	anyFlag := bson.A{}
+	for _, r := range rules {
+		flags[r.Name] = r.Offending
+		counts[r.Name] = bson.M{"$sum": bson.M{"$cond": bson.A{"$" + r.Name, 1, 0}}}
+		anyFlag = append(anyFlag, "$"+r.Name)
+	}
+	if len(anyFlag) == 0 {
+		anyFlag = bson.A{false}
+	}
+	flags["groupKey"] = asString("$key")
+	return append(FlattenStages(nil),
+		bson.D{{Key: "$project", Value: flags}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"rules": bson.A{bson.M{"$group": counts}, bson.M{"$project": bson.M{"_id": 0}}},
+			"groups": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":      "$groupKey",
+					"products": bson.M{"$sum": 1},
+					"complete": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$or": anyFlag}, 0, 1}}},
+				}},
+				bson.M{"$project": bson.M{"_id": 0, "groupKey": "$_id", "products": 1, "complete": 1}},
+				bson.M{"$sort": bson.M{"groupKey": 1}},
+			},
+		}}},
+	)
+}
+
+// QualityRuleStages yields the QualityRows of the products rule flags.
+func QualityRuleStages(rule QualityRule) mongo.Pipeline {
+	return append(FlattenStages(nil),
+		bson.D{{Key: "$match", Value: bson.M{"$expr": rule.Offending}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"groupKey":    asString("$key"),
+			"productId":   productIDExpr,
+			"productName": asString("$productList.productName"),
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "groupKey", Value: 1}, {Key: "productId", Value: 1}}}},
+	)
+}
@@ -0,0 +1,71 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestQualityRules runs every rule against qualityGroups and checks the
+// products it flags, its count in the report and the groups' totals.
+func TestQualityRules(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	if _, err := repo.products.InsertMany(ctx, qualityGroups()); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := QualityRules()
+	cursor, err := repo.products.Aggregate(ctx, QualityReportPipeline(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []struct {
+		Rules  []map[string]int64 `bson:"rules"`
+		Groups []struct {
+			GroupKey string `bson:"groupKey"`
+			Products int64  `bson:"products"`
+			Complete int64  `bson:"complete"`
+		} `bson:"groups"`
+	}
+	if err := cursor.All(ctx, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || len(report[0].Rules) != 1 {
+		t.Fatalf("report = %+v, want one document of counts", report)
+	}
+
+	for _, rule := range rules {
+		t.Run(rule.Name, func(t *testing.T) {
+			cursor, err := repo.products.Aggregate(ctx, QualityRuleStages(rule))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows []QualityRow
+			if err := cursor.All(ctx, &rows); err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, r := range rows {
+				ids = append(ids, r.ProductID)
+			}
+			if want := qualityCases[rule.Name]; !reflect.DeepEqual(ids, want) {
+				t.Errorf("flags %v, want %v", ids, want)
+			}
+			if got := report[0].Rules[0][rule.Name]; got != int64(len(ids)) {
+				t.Errorf("report counts %d, want %d", got, len(ids))
+			}
+		})
+	}
+
+	type totals struct{ products, complete int64 }
+	got := map[string]totals{}
+	for _, g := range report[0].Groups {
+		got[g.GroupKey] = totals{g.Products, g.Complete}
+	}
+	if want := map[string]totals{"G-A": {3, 1}, "G-B": {1, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// qualityGroups are groups in which every data quality rule flags some
+// products; A-1 is the only complete one.
+func qualityGroups() []interface{} {
+	complete := func(id string) bson.M {
+		return bson.M{
+			"id":          id,
+			"productName": "Product " + id,
+			"productCode": "CODE-" + id,
+			"insurer":     bson.M{"insurerCode": "TIP", "insurerName": "ทิพยประกันภัย"},
+			"brokers":     bson.A{bson.M{"key": "BROKER-ONLINE"}},
+		}
+	}
+	a2, a3, b1 := complete("A-2"), complete("A-3"), complete("B-1")
+	a2["brokers"] = bson.A{}
+	delete(a3, "brokers")
+	a3["productCode"] = ""
+	a3["insurer"] = bson.M{"insurerCode": "TIP", "insurerName": ""}
+	return []interface{}{
+		bson.M{"key": "G-A", "productType": bson.M{"key": "HEALTH"}, "productList": bson.A{complete("A-1"), a2, a3}},
+		bson.M{"key": "G-B", "productList": bson.A{b1}},
+	}
+}
+
+// qualityCases are the products of qualityGroups each rule flags. A new
+// rule comes with its case here.
+var qualityCases = map[string][]string{
+	"missing_brokers":      {"A-2", "A-3"},
+	"missing_code":         {"A-3"},
+	"missing_insurer_name": {"A-3"},
+	"missing_product_type": {"B-1"},
+}
+
+func TestQualityRulesTested(t *testing.T) {
+	for _, rule := range QualityRules() {
+		if _, ok := qualityCases[rule.Name]; !ok {
+			t.Errorf("rule %s has no case in qualityCases", rule.Name)
+		}
+		if rule.Description == "" {
+			t.Errorf("rule %s has no description", rule.Name)
+		}
+		if _, err := bson.Marshal(rule.Offending); err != nil {
+			t.Errorf("rule %s: %v", rule.Name, err)
+		}
+	}
+	for name := range qualityCases {
+		if _, ok := QualityRuleNamed(name); !ok {
+			t.Errorf("qualityCases has %s, which is no rule", name)
+		}
+	}
+}
+
+func TestQualityReportPipeline(t *testing.T) {
+	rules := QualityRules()
+	p := QualityReportPipeline(rules)
+	project := p[len(p)-2][0].Value.(bson.M)
+	for _, r := range rules {
+		if _, ok := project[r.Name]; !ok {
+			t.Errorf("$project has no flag for %s", r.Name)
+		}
+	}
+	// Without rules every product is complete.
+	p = QualityReportPipeline(nil)
+	groups := p[len(p)-1][0].Value.(bson.M)["groups"].(bson.A)
+	complete := groups[0].(bson.M)["$group"].(bson.M)["complete"]
+	want := bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$or": bson.A{false}}, 0, 1}}}
+	if b, w := mustExtJSON(t, complete), mustExtJSON(t, want); b != w {
+		t.Errorf("complete = %s, want %s", b, w)
+	}
+}
+
+func mustExtJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := bson.MarshalExtJSON(bson.M{"v": v}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
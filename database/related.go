@@ -0,0 +1,50 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RelatedPipeline finds up to limit products with the given status that
+// share the insurer code or the group's product type key, other than the
+// product excluded. Products sharing both rank first, then those sharing
+// the insurer, then the type; names break ties. Each result carries
+// sameInsurer and sameType.
+func RelatedPipeline(insurerCode, typeKey, exclude, status string, limit int) mongo.Pipeline {
+	related := bson.A{}
+	if insurerCode != "" {
+		related = append(related, bson.M{"productList.insurer.insurerCode": insurerCode})
+	}
+	if typeKey != "" {
+		related = append(related, bson.M{"productType.key": typeKey})
+	}
+	filter := bson.M{"$or": related, "productList.productStatus": status}
+
+	projection := bson.M{
+		"sameInsurer": 1,
+		"sameType":    1,
+	}
+	for k, v := range ProductProjection {
+		projection[k] = v
+	}
+	return append(FlattenStages(filter),
+		bson.D{{Key: "$match", Value: bson.M{"$nor": bson.A{ItemMatch(exclude, "productList.")}}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"sameInsurer": bson.M{"$and": bson.A{insurerCode != "", bson.M{"$eq": bson.A{"$productList.insurer.insurerCode", insurerCode}}}},
+			"sameType":    bson.M{"$and": bson.A{typeKey != "", bson.M{"$eq": bson.A{"$productType.key", typeKey}}}},
+		}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"relevance": bson.M{"$add": bson.A{
+				bson.M{"$cond": bson.A{"$sameInsurer", 2, 0}},
+				bson.M{"$cond": bson.A{"$sameType", 1, 0}},
+			}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{
+			{Key: "relevance", Value: -1},
+			{Key: "productList.productName", Value: 1},
+			{Key: "productList.id", Value: 1},
+		}}},
+		bson.D{{Key: "$limit", Value: limit}},
+		bson.D{{Key: "$project", Value: projection}},
+	)
+}
@@ -0,0 +1,110 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestRelatedPipelineRanking runs RelatedPipeline for every fixture
+// product and checks the ranking against the rule it documents.
+func TestRelatedPipelineRanking(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	groups, err := fixtures.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.products.InsertMany(ctx, groups); err != nil {
+		t.Fatal(err)
+	}
+
+	type product struct{ id, name, insurer, typeKey, status string }
+	var all []product
+	for _, g := range groups {
+		group := g.(bson.M)
+		typeKey := ""
+		if pt, ok := group["productType"].(bson.M); ok {
+			typeKey, _ = pt["key"].(string)
+		}
+		items, _ := group["productList"].(bson.A)
+		for _, item := range items {
+			p, ok := item.(bson.M)
+			if !ok {
+				continue
+			}
+			insurer := ""
+			if ins, ok := p["insurer"].(bson.M); ok {
+				insurer, _ = ins["insurerCode"].(string)
+			}
+			id, _ := p["id"].(string)
+			name, _ := p["productName"].(string)
+			status, _ := p["productStatus"].(string)
+			all = append(all, product{id, name, insurer, typeKey, status})
+		}
+	}
+
+	for _, of := range all {
+		if of.insurer == "" && of.typeKey == "" {
+			continue
+		}
+		type ranked struct {
+			product
+			relevance int
+		}
+		var want []ranked
+		for _, p := range all {
+			sameInsurer := of.insurer != "" && p.insurer == of.insurer
+			sameType := of.typeKey != "" && p.typeKey == of.typeKey
+			if p.id == of.id || p.status != "ACTIVE" || !sameInsurer && !sameType {
+				continue
+			}
+			r := ranked{product: p}
+			if sameInsurer {
+				r.relevance += 2
+			}
+			if sameType {
+				r.relevance++
+			}
+			want = append(want, r)
+		}
+		sort.SliceStable(want, func(i, j int) bool {
+			a, b := want[i], want[j]
+			if a.relevance != b.relevance {
+				return a.relevance > b.relevance
+			}
+			if a.name != b.name {
+				return a.name < b.name
+			}
+			return a.id < b.id
+		})
+		wantIDs := []string{}
+		for _, r := range want {
+			wantIDs = append(wantIDs, r.id)
+		}
+
+		cursor, err := repo.Aggregate(ctx, RelatedPipeline(of.insurer, of.typeKey, of.id, "ACTIVE", 50))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []struct {
+			ID string `bson:"id"`
+		}
+		if err := cursor.All(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		gotIDs := []string{}
+		for _, r := range got {
+			gotIDs = append(gotIDs, r.ID)
+		}
+		if !reflect.DeepEqual(gotIDs, wantIDs) {
+			t.Errorf("related to %s = %v, want %v", of.id, gotIDs, wantIDs)
+		}
+	}
+}
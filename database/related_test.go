@@ -0,0 +1,54 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRelatedPipeline(t *testing.T) {
+	stage := func(p []bson.D, key string) interface{} {
+		for _, s := range p {
+			if s[0].Key == key {
+				return s[0].Value
+			}
+		}
+		t.Fatalf("no %s stage in %v", key, p)
+		return nil
+	}
+
+	both := RelatedPipeline("VIR", "MOTOR", "MT-001", "ACTIVE", 6)
+	want := bson.M{
+		"$or":                       bson.A{bson.M{"productList.insurer.insurerCode": "VIR"}, bson.M{"productType.key": "MOTOR"}},
+		"productList.productStatus": "ACTIVE",
+	}
+	if got := both[0][0].Value; !reflect.DeepEqual(got, want) {
+		t.Errorf("first $match = %v, want %v", got, want)
+	}
+	// Same insurer outweighs same type, so sharing both ranks first, then
+	// the insurer alone, then the type alone.
+	wantSort := bson.D{{Key: "relevance", Value: -1}, {Key: "productList.productName", Value: 1}, {Key: "productList.id", Value: 1}}
+	if got := stage(both, "$sort"); !reflect.DeepEqual(got, wantSort) {
+		t.Errorf("$sort = %v, want %v", got, wantSort)
+	}
+	if got := stage(both, "$limit"); got != 6 {
+		t.Errorf("$limit = %v, want 6", got)
+	}
+	if got := both[len(both)-1][0].Key; got != "$project" {
+		t.Errorf("last stage = %s, want $project", got)
+	}
+
+	// Without a type only the insurer relates, and sameType is never set.
+	insurerOnly := RelatedPipeline("VIR", "", "MT-001", "ACTIVE", 6)
+	if got := insurerOnly[0][0].Value.(bson.M)["$or"]; !reflect.DeepEqual(got, bson.A{bson.M{"productList.insurer.insurerCode": "VIR"}}) {
+		t.Errorf("$or without a type = %v", got)
+	}
+	for _, s := range insurerOnly {
+		if fields, ok := s[0].Value.(bson.M); ok && s[0].Key == "$addFields" && fields["sameType"] != nil {
+			if and := fields["sameType"].(bson.M)["$and"].(bson.A); and[0] != false {
+				t.Errorf("sameType without a type = %v, want it false", and)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductRepository is the storage of one tenant's product group documents.
+// Reads go to the listing replica set members where configured; writes and
+// read-your-writes lookups go to the primary.
+type ProductRepository interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	Count(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	EstimatedCount(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (int64, error)
+	// FindOne reads from the primary, so it sees the caller's own writes.
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	// PushProduct appends item to the productList of the group matching
+	// filter, setting item's createdAt and updatedAt first. The result is
+	// the group as it was before.
+	PushProduct(ctx context.Context, filter bson.M, item bson.M) *mongo.SingleResult
+	// SetProduct sets fields on the product with the given id in the group
+	// matching filter, setting fields' updatedAt first. The result is the
+	// group as it was before.
+	SetProduct(ctx context.Context, filter bson.M, id string, fields bson.M) *mongo.SingleResult
+	// CreateGroup inserts group unless a group with its key exists, and
+	// reports whether it did.
+	CreateGroup(ctx context.Context, group bson.M) (created bool, err error)
+	// DeleteGroup deletes the group matching filter. The result is the
+	// deleted group.
+	DeleteGroup(ctx context.Context, filter bson.M) *mongo.SingleResult
+	// NextSequence atomically increments the named counter and returns its
+	// new value, starting from 1.
+	NextSequence(ctx context.Context, name string) (int64, error)
+}
+
+// MongoProductRepository is the ProductRepository backed by a collection.
+type MongoProductRepository struct {
+	products *mongo.Collection
+	list     *mongo.Collection
+	counters *mongo.Collection
+	// tenant labels the operations reported by ObserveQuery.
+	tenant string
+	// now stamps product timestamps.
+	now func() time.Time
+}
+
+// NewMongoProductRepository returns the named tenant's repository for the
+// named collection, reading listings with cfg's listing read preference.
+func NewMongoProductRepository(client *mongo.Client, tenant, database, collection string, cfg config.MongoConfig) (*MongoProductRepository, error) {
+	products := client.Database(database).Collection(collection)
+	list, err := listCollection(products, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &MongoProductRepository{
+		products: products,
+		list:     list,
+		counters: client.Database(database).Collection(CountersCollection),
+		tenant:   tenant,
+		now:      time.Now,
+	}, nil
+}
+
+// Find reports the documents of the first batch as returned to
+// ObserveQuery; listings ask for a batch of the page size.
+func (r *MongoProductRepository) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	start := time.Now()
+	cursor, err := r.list.Find(ctx, filter, opts...)
+	info := QueryInfo{Operation: "find", Filter: filter}
+	if o := options.MergeFindOptions(opts...); o != nil {
+		info.Sort = o.Sort
+		if o.Skip != nil {
+			info.Skip = *o.Skip
+		}
+		if o.Limit != nil {
+			info.Limit = *o.Limit
+		}
+	}
+	r.observe(ctx, r.list, info, start, batchLength(cursor), err)
+	return cursor, err
+}
+
+func (r *MongoProductRepository) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	start := time.Now()
+	cursor, err := r.list.Aggregate(ctx, pipeline, opts...)
+	r.observe(ctx, r.list, QueryInfo{Operation: "aggregate", Filter: pipeline}, start, batchLength(cursor), err)
+	return cursor, err
+}
+
+func (r *MongoProductRepository) Count(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	start := time.Now()
+	n, err := r.list.CountDocuments(ctx, filter, opts...)
+	r.observe(ctx, r.list, QueryInfo{Operation: "countDocuments", Filter: filter}, start, 1, err)
+	return n, err
+}
+
+func (r *MongoProductRepository) EstimatedCount(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	start := time.Now()
+	n, err := r.list.EstimatedDocumentCount(ctx, opts...)
+	r.observe(ctx, r.list, QueryInfo{Operation: "estimatedDocumentCount"}, start, 1, err)
+	return n, err
+}
+
+func (r *MongoProductRepository) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	start := time.Now()
+	return r.observeSingle(ctx, r.products, QueryInfo{Operation: "findOne", Filter: filter}, start, r.products.FindOne(ctx, filter, opts...))
+}
+
+func (r *MongoProductRepository) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	start := time.Now()
+	return r.observeSingle(ctx, r.products, QueryInfo{Operation: "findOneAndUpdate", Filter: filter}, start, r.products.FindOneAndUpdate(ctx, filter, update, opts...))
+}
+
+// Repository returns the named tenant's repository, for handlers.New.
+func Repository(tenant string) ProductRepository {
+	t, ok := tenants[tenant]
+	if !ok {
+		return nil
+	}
+	return t.Repo
+}
+
+func (r *MongoProductRepository) PushProduct(ctx context.Context, filter bson.M, item bson.M) *mongo.SingleResult {
+	now := Timestamp(r.now())
+	item["createdAt"], item["updatedAt"] = now, now
+	start := time.Now()
+	return r.observeSingle(ctx, r.products, QueryInfo{Operation: "findOneAndUpdate", Filter: filter}, start,
+		r.products.FindOneAndUpdate(ctx, filter, bson.M{"$push": bson.M{"productList": item}}))
+}
+
+func (r *MongoProductRepository) SetProduct(ctx context.Context, filter bson.M, id string, fields bson.M) *mongo.SingleResult {
+	fields["updatedAt"] = Timestamp(r.now())
+	set := bson.M{}
+	for k, v := range fields {
+		set["productList.$[p]."+k] = v
+	}
+	// The product is addressed with an array filter rather than the
+	// positional $, which is undefined when filter has conditions of its own
+	// on productList.
+	filter = bson.M{"$and": bson.A{filter, ItemFilter(id)}}
+	start := time.Now()
+	return r.observeSingle(ctx, r.products, QueryInfo{Operation: "findOneAndUpdate", Filter: filter}, start,
+		r.products.FindOneAndUpdate(ctx, filter, bson.M{"$set": set},
+			options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{ItemMatch(id, "p.")}}),
+		))
+}
+
+func (r *MongoProductRepository) CreateGroup(ctx context.Context, group bson.M) (bool, error) {
+	// key is not a unique index, as historical data has duplicates, so the
+	// upsert is what keeps a key from being created twice; two concurrent
+	// creates of one key can still both insert.
+	filter := bson.M{"key": group["key"]}
+	start := time.Now()
+	res, err := r.products.UpdateOne(ctx, filter, bson.M{"$setOnInsert": group}, options.Update().SetUpsert(true))
+	upserted := 0
+	if err == nil {
+		upserted = int(res.UpsertedCount)
+	}
+	r.observe(ctx, r.products, QueryInfo{Operation: "updateOne", Filter: filter}, start, upserted, err)
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+func (r *MongoProductRepository) DeleteGroup(ctx context.Context, filter bson.M) *mongo.SingleResult {
+	start := time.Now()
+	return r.observeSingle(ctx, r.products, QueryInfo{Operation: "findOneAndDelete", Filter: filter}, start, r.products.FindOneAndDelete(ctx, filter))
+}
+
+// observe reports an operation on coll that began at start, see
+// ObserveQuery.
+func (r *MongoProductRepository) observe(ctx context.Context, coll *mongo.Collection, info QueryInfo, start time.Time, returned int, err error) {
+	info.Tenant = r.tenant
+	ObserveQuery(ctx, coll, info, time.Since(start), returned, err)
+}
+
+// observeSingle observes an operation answering with res and returns res.
+func (r *MongoProductRepository) observeSingle(ctx context.Context, coll *mongo.Collection, info QueryInfo, start time.Time, res *mongo.SingleResult) *mongo.SingleResult {
+	err := res.Err()
+	returned := 0
+	if err == nil {
+		returned = 1
+	}
+	r.observe(ctx, coll, info, start, returned, err)
+	return res
+}
+
+// batchLength is the number of documents cursor holds without another
+// round trip.
+func batchLength(cursor *mongo.Cursor) int {
+	if cursor == nil {
+		return 0
+	}
+	return cursor.RemainingBatchLength()
+}
+
+// Timestamp is t as Mongo stores it: UTC, in whole milliseconds, so the
+// value a write returns equals the one read back later.
+func Timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
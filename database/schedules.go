@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ScheduledStatusChange is an entry of a product's scheduledStatusChanges:
+// the status the product takes at At.
+type ScheduledStatusChange struct {
+	ID        string    `bson:"id" json:"id"`
+	Status    string    `bson:"status" json:"status"`
+	At        time.Time `bson:"at" json:"at"`
+	CreatedBy string    `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// PendingStatusChange is a scheduled change with the product it is for.
+type PendingStatusChange struct {
+	GroupID               interface{} `bson:"groupId" json:"-"`
+	GroupKey              string      `bson:"groupKey" json:"groupKey"`
+	ProductID             string      `bson:"productId" json:"productId"`
+	ProductName           string      `bson:"productName" json:"productName"`
+	ScheduledStatusChange `bson:"change"`
+}
+
+// PendingStatusChangesPipeline lists the scheduled status changes due by
+// before, or all of them when before is zero, soonest first.
+func PendingStatusChangesPipeline(before time.Time) mongo.Pipeline {
+	// After the unwinds scheduledStatusChanges is a single entry, which
+	// has no element 0; every entry has an at.
+	groups := bson.M{"productList.scheduledStatusChanges.0": bson.M{"$exists": true}}
+	entries := bson.M{"productList.scheduledStatusChanges.at": bson.M{"$exists": true}}
+	if !before.IsZero() {
+		groups = bson.M{"productList.scheduledStatusChanges.at": bson.M{"$lte": before}}
+		entries = groups
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: groups}},
+		{{Key: "$unwind", Value: "$productList"}},
+		{{Key: "$unwind", Value: "$productList.scheduledStatusChanges"}},
+		{{Key: "$match", Value: entries}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"groupId":     "$_id",
+			"groupKey":    asString("$key"),
+			"productId":   productIDExpr,
+			"productName": asString("$productList.productName"),
+			"change":      "$productList.scheduledStatusChanges",
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "change.at", Value: 1}, {Key: "productId", Value: 1}}}},
+	}
+}
+
+// ClaimStatusChange applies a scheduled change and removes it from the
+// product in one update, which matches only while the entry is still
+// there and the product's status is not one of blocked, the statuses that
+// may not change to the scheduled one. Of several replicas finding the
+// same due change, exactly one claims it; the others get
+// mongo.ErrNoDocuments. The result is the group as it was before.
+func ClaimStatusChange(ctx context.Context, coll *mongo.Collection, change PendingStatusChange, blocked []string, actor string, now time.Time) *mongo.SingleResult {
+	if blocked == nil {
+		blocked = []string{}
+	}
+	return coll.FindOneAndUpdate(ctx,
+		statusChangeFilter(change, bson.M{"productStatus": bson.M{"$nin": blocked}}),
+		bson.M{
+			"$set": bson.M{
+				"productList.$[p].productStatus": change.Status,
+				"productList.$[p].updatedAt":     Timestamp(now),
+				"productList.$[p].updatedBy":     actor,
+			},
+			"$pull": bson.M{"productList.$[p].scheduledStatusChanges": bson.M{"id": change.ID}},
+		},
+		statusChangeOptions(change),
+	)
+}
+
+// RejectStatusChange removes a scheduled change without applying it, for
+// one whose claim found the product in a blocked status. Like the claim,
+// it matches only while the entry is still there. The result is the group
+// as it was before.
+func RejectStatusChange(ctx context.Context, coll *mongo.Collection, change PendingStatusChange) *mongo.SingleResult {
+	return coll.FindOneAndUpdate(ctx,
+		statusChangeFilter(change),
+		bson.M{"$pull": bson.M{"productList.$[p].scheduledStatusChanges": bson.M{"id": change.ID}}},
+		statusChangeOptions(change),
+	)
+}
+
+// statusChangeFilter matches change's group while its product still has
+// the entry and meets conditions.
+func statusChangeFilter(change PendingStatusChange, conditions ...bson.M) bson.M {
+	entry := bson.A{ItemMatch(change.ProductID, ""), bson.M{"scheduledStatusChanges.id": change.ID}}
+	for _, c := range conditions {
+		entry = append(entry, c)
+	}
+	return bson.M{"_id": change.GroupID, "productList": bson.M{"$elemMatch": bson.M{"$and": entry}}}
+}
+
+func statusChangeOptions(change PendingStatusChange) *options.FindOneAndUpdateOptions {
+	return options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{ItemMatch(change.ProductID, "p.")}})
+}
@@ -0,0 +1,197 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var scheduleNow = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// scheduledGroup stores a group whose products have the given statuses,
+// each product with the scheduled changes of the same index.
+func scheduledGroup(t *testing.T, repo *MongoProductRepository, key string, statuses []string, changes [][]ScheduledStatusChange) primitive.ObjectID {
+	t.Helper()
+	id := primitive.NewObjectID()
+	items := bson.A{}
+	for i, status := range statuses {
+		items = append(items, bson.M{
+			"id":                     key + "-" + string(rune('1'+i)),
+			"productName":            key + " " + status,
+			"productStatus":          status,
+			"scheduledStatusChanges": changes[i],
+		})
+	}
+	if _, err := repo.products.InsertOne(context.Background(), bson.M{"_id": id, "key": key, "productList": items}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func pending(t *testing.T, repo *MongoProductRepository, before time.Time) []PendingStatusChange {
+	t.Helper()
+	ctx := context.Background()
+	cursor, err := repo.products.Aggregate(ctx, PendingStatusChangesPipeline(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []PendingStatusChange
+	if err := cursor.All(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestPendingStatusChangesPipeline(t *testing.T) {
+	repo := testRepository(t)
+	at := func(h int) time.Time { return scheduleNow.Add(time.Duration(h) * time.Hour) }
+	scheduledGroup(t, repo, "A", []string{"DRAFT", "ACTIVE", "ACTIVE"}, [][]ScheduledStatusChange{
+		{{ID: "a1", Status: "ACTIVE", At: at(-1)}, {ID: "a2", Status: "RETIRED", At: at(5)}},
+		{{ID: "a3", Status: "INACTIVE", At: at(-3)}},
+		nil,
+	})
+	scheduledGroup(t, repo, "B", []string{"INACTIVE"}, [][]ScheduledStatusChange{
+		{{ID: "b1", Status: "ACTIVE", At: at(-2)}},
+	})
+	scheduledGroup(t, repo, "C", []string{"ACTIVE"}, [][]ScheduledStatusChange{nil})
+
+	ids := func(changes []PendingStatusChange) (out []string) {
+		for _, ch := range changes {
+			out = append(out, ch.ProductID+":"+ch.ID)
+		}
+		return out
+	}
+	if got, want := ids(pending(t, repo, scheduleNow)), []string{"A-2:a3", "B-1:b1", "A-1:a1"}; !equalStrings(got, want) {
+		t.Errorf("due = %v, want %v soonest first", got, want)
+	}
+	if got, want := ids(pending(t, repo, time.Time{})), []string{"A-2:a3", "B-1:b1", "A-1:a1", "A-1:a2"}; !equalStrings(got, want) {
+		t.Errorf("all pending = %v, want %v", got, want)
+	}
+	due := pending(t, repo, scheduleNow)
+	if due[0].GroupKey != "A" || due[0].Status != "INACTIVE" || due[0].ProductName != "A ACTIVE" || due[0].GroupID == nil {
+		t.Errorf("first due = %+v", due[0])
+	}
+}
+
+func TestClaimStatusChange(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	scheduledGroup(t, repo, "A", []string{"DRAFT", "RETIRED"}, [][]ScheduledStatusChange{
+		{{ID: "a1", Status: "ACTIVE", At: scheduleNow.Add(-time.Hour)}, {ID: "a2", Status: "RETIRED", At: scheduleNow.Add(time.Hour)}},
+		{{ID: "a3", Status: "ACTIVE", At: scheduleNow.Add(-time.Hour)}},
+	})
+	due := pending(t, repo, scheduleNow)
+	if len(due) != 2 {
+		t.Fatalf("%d due, want 2", len(due))
+	}
+
+	var before GroupDocument
+	if err := ClaimStatusChange(ctx, repo.products, due[0], []string{"RETIRED"}, "scheduler", scheduleNow).Decode(&before); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := before.Item("A-1"); item.Status != "DRAFT" {
+		t.Errorf("result status = %q, want the group as it was", item.Status)
+	}
+	var after GroupDocument
+	if err := repo.products.FindOne(ctx, bson.M{"key": "A"}).Decode(&after); err != nil {
+		t.Fatal(err)
+	}
+	item, _ := after.Item("A-1")
+	if item.Status != "ACTIVE" || item.UpdatedBy != "scheduler" || !item.UpdatedAt.Time.Equal(scheduleNow) {
+		t.Errorf("after the claim = %+v", item)
+	}
+	if err := ClaimStatusChange(ctx, repo.products, due[0], nil, "scheduler", scheduleNow).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("claiming it again = %v, want ErrNoDocuments", err)
+	}
+	if left := pending(t, repo, time.Time{}); len(left) != 2 || left[1].ID != "a2" {
+		t.Errorf("pending after the claim = %+v, want a3 and a2", left)
+	}
+
+	// A RETIRED product may not become ACTIVE: the claim matches nothing
+	// and the entry stays for RejectStatusChange.
+	if err := ClaimStatusChange(ctx, repo.products, due[1], []string{"RETIRED"}, "scheduler", scheduleNow).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("claiming a blocked change = %v, want ErrNoDocuments", err)
+	}
+	if err := RejectStatusChange(ctx, repo.products, due[1]).Err(); err != nil {
+		t.Fatalf("rejecting it = %v", err)
+	}
+	if err := RejectStatusChange(ctx, repo.products, due[1]).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("rejecting it again = %v, want ErrNoDocuments", err)
+	}
+	if err := repo.products.FindOne(ctx, bson.M{"key": "A"}).Decode(&after); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := after.Item("A-2"); item.Status != "RETIRED" {
+		t.Errorf("rejected product status = %q, want RETIRED", item.Status)
+	}
+}
+
+// TestClaimStatusChangeConcurrent has many replicas claim the same due
+// changes at once: each change is claimed exactly once.
+func TestClaimStatusChangeConcurrent(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	var changes [][]ScheduledStatusChange
+	var statuses []string
+	for i := 0; i < 5; i++ {
+		statuses = append(statuses, "DRAFT")
+		changes = append(changes, []ScheduledStatusChange{{ID: string(rune('a' + i)), Status: "ACTIVE", At: scheduleNow.Add(-time.Minute)}})
+	}
+	scheduledGroup(t, repo, "A", statuses, changes)
+	due := pending(t, repo, scheduleNow)
+
+	const replicas = 16
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		claims = map[string]int{}
+	)
+	for r := 0; r < replicas; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, change := range due {
+				err := ClaimStatusChange(ctx, repo.products, change, []string{"RETIRED"}, "scheduler", scheduleNow).Err()
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				claims[change.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, change := range due {
+		if claims[change.ID] != 1 {
+			t.Errorf("change %s claimed %d times, want once", change.ID, claims[change.ID])
+		}
+	}
+	if left := pending(t, repo, time.Time{}); len(left) != 0 {
+		t.Errorf("%d changes left pending", len(left))
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
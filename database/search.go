@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RefreshSearchFields recomputes the lowercase shadow fields of the groups
+// matching filter. The write handlers maintain them for the products they
+// write; this covers documents written any other way.
+func RefreshSearchFields(ctx context.Context, coll *mongo.Collection, filter bson.M) error {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	_, err := coll.UpdateMany(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"keyLower":    lower("$key"),
+		"productType": ifObject("$productType", bson.M{"keyLower": lower("$productType.key")}),
+		"productList": bson.M{"$cond": bson.A{
+			bson.M{"$isArray": "$productList"},
+			bson.M{"$map": bson.M{
+				"input": "$productList",
+				"as":    "p",
+				"in": ifObject("$$p", bson.M{
+					"insurer": ifObject("$$p.insurer", bson.M{"insurerCodeLower": lower("$$p.insurer.insurerCode")}),
+					"brokers": bson.M{"$cond": bson.A{
+						bson.M{"$isArray": "$$p.brokers"},
+						bson.M{"$map": bson.M{
+							"input": "$$p.brokers",
+							"as":    "b",
+							"in":    ifObject("$$b", bson.M{"keyLower": lower("$$b.key")}),
+						}},
+						"$$p.brokers",
+					}},
+				}),
+			}},
+			"$productList",
+		}},
+	}}}})
+	return err
+}
+
+// lower is $toLower for strings; anything else lowercases to "".
+func lower(path string) bson.M {
+	return bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$type": path}, "string"}}, bson.M{"$toLower": path}, ""}}
+}
+
+// ifObject merges fields into the document at path, leaving values that
+// are not documents untouched.
+func ifObject(path string, fields bson.M) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": path}, "object"}},
+		bson.M{"$mergeObjects": bson.A{path, fields}},
+		path,
+	}}
+}
+
+// SpellingTermsPipeline collects the distinct product and insurer names,
+// the candidates of search spelling suggestions, into one document's
+// names array.
+func SpellingTermsPipeline() mongo.Pipeline {
+	return append(FlattenStages(nil),
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"products": bson.M{"$addToSet": asString("$productList.productName")},
+			"insurers": bson.M{"$addToSet": asString("$productList.insurer.insurerName")},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "names": bson.M{"$setUnion": bson.A{"$products", "$insurers"}}}}},
+	)
+}
+
+// SearchPattern is a regex for the field at Path in a productList entry,
+// with $regex options.
+type SearchPattern struct {
+	Path    string
+	Regex   string
+	Options string
+}
+
+// SearchExclusionExpr is a $expr condition that holds for a product none
+// of whose fields matches its pattern; for an array field, no element may
+// match. Conditions on the group's own fields are left to the query,
+// where they can be matched directly.
+func SearchExclusionExpr(patterns []SearchPattern) bson.M {
+	return ProductExpr(func(field func(string) string) bson.M {
+		or := bson.A{}
+		for _, p := range patterns {
+			value := field(p.Path)
+			or = append(or, bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+				"input": bson.M{"$cond": bson.A{bson.M{"$isArray": value}, value, bson.A{value}}},
+				"as":    "v",
+				"in":    regexMatch("$$v", p.Regex, p.Options),
+			}}}})
+		}
+		return bson.M{"$not": bson.A{bson.M{"$or": or}}}
+	})
+}
+
+// regexMatch is $regexMatch on path read as a string, so values of other
+// types do not fail the query.
+func regexMatch(path, regex, options string) bson.M {
+	return bson.M{"$regexMatch": bson.M{"input": asString(path), "regex": regex, "options": options}}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	slowQueries   = metrics.NewCounter("mongo_slow_queries")
+	failedQueries = metrics.NewCounter("mongo_failed_queries")
+)
+
+var slowQueryCfg = config.SlowQueryConfig{Threshold: 500 * time.Millisecond}
+
+// ConfigureSlowQueries sets the threshold and explain behaviour used by
+// ObserveQuery.
+func ConfigureSlowQueries(cfg config.SlowQueryConfig) {
+	slowQueryCfg = cfg
+}
+
+// QueryInfo describes a Mongo operation for slow query reporting.
+type QueryInfo struct {
+	// Operation is the driver call, e.g. "find" or "findOneAndUpdate".
+	Operation string
+	Tenant    string
+	Filter    interface{}
+	Sort      interface{}
+	Skip      int64
+	Limit     int64
+}
+
+type endpointKey struct{}
+
+// WithEndpoint labels the operations run with ctx with the route that
+// serves them.
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+func endpointOf(ctx context.Context) string {
+	endpoint, _ := ctx.Value(endpointKey{}).(string)
+	return endpoint
+}
+
+// ObserveQuery logs a warning and counts the operation when it took longer
+// than the configured threshold or failed; a lookup matching nothing is not
+// a failure. Filter values are redacted — only the shape of the query is
+// logged, never what the caller searched for.
+func ObserveQuery(ctx context.Context, coll *mongo.Collection, info QueryInfo, took time.Duration, returned int, err error) {
+	failed := err != nil && !errors.Is(err, mongo.ErrNoDocuments)
+	slow := took >= slowQueryCfg.Threshold
+	if !failed && !slow {
+		return
+	}
+	endpoint := endpointOf(ctx)
+	attrs := []any{
+		"endpoint", endpoint,
+		"tenant", info.Tenant,
+		"operation", info.Operation,
+		"collection", coll.Name(),
+		"filter", fmt.Sprint(Redact(info.Filter)),
+		"sort", fmt.Sprint(info.Sort),
+		"skip", info.Skip,
+		"limit", info.Limit,
+		"durationMs", took.Milliseconds(),
+		"returned", returned,
+	}
+	if failed {
+		failedQueries.Inc(endpoint, info.Tenant, info.Operation)
+		slog.Warn("query failed", append(attrs, "error", err)...)
+	}
+	if !slow {
+		return
+	}
+	slowQueries.Inc(endpoint, info.Tenant)
+	slog.Warn("slow query", attrs...)
+	if slowQueryCfg.Explain && info.Operation == "find" {
+		go explain(coll, endpoint, info)
+	}
+}
+
+// explain logs the query planner's winning plan for a slow find. It runs
+// detached from the request with its own timeout.
+func explain(coll *mongo.Collection, endpoint string, info QueryInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	plan, err := WinningPlan(ctx, coll, info)
+	if err != nil {
+		slog.Warn("explain failed", "endpoint", endpoint, "error", err)
+		return
+	}
+	slog.Warn("slow query plan", "endpoint", endpoint, "winningPlan", fmt.Sprint(plan))
+}
+
+// WinningPlan returns the query planner's winning plan for the find info
+// describes, without running it.
+func WinningPlan(ctx context.Context, coll *mongo.Collection, info QueryInfo) (bson.M, error) {
+	find := bson.D{
+		{Key: "find", Value: coll.Name()},
+		{Key: "filter", Value: info.Filter},
+		{Key: "skip", Value: info.Skip},
+		{Key: "limit", Value: info.Limit},
+	}
+	if info.Sort != nil {
+		find = append(find, bson.E{Key: "sort", Value: info.Sort})
+	}
+	var result struct {
+		QueryPlanner struct {
+			WinningPlan bson.M `bson:"winningPlan"`
+		} `bson:"queryPlanner"`
+	}
+	err := coll.Database().RunCommand(ctx, bson.D{
+		{Key: "explain", Value: find},
+		{Key: "verbosity", Value: "queryPlanner"},
+	}).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	if result.QueryPlanner.WinningPlan == nil {
+		return nil, errors.New("explain returned no winning plan")
+	}
+	return result.QueryPlanner.WinningPlan, nil
+}
+
+// Redact replaces every scalar in a filter with "?", keeping operators and
+// field names so the query shape stays readable.
+func Redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		out := bson.M{}
+		for k, val := range t {
+			if k == "$options" {
+				out[k] = val
+				continue
+			}
+			out[k] = Redact(val)
+		}
+		return out
+	case bson.D:
+		out := bson.D{}
+		for _, e := range t {
+			out = append(out, bson.E{Key: e.Key, Value: Redact(e.Value)})
+		}
+		return out
+	case []bson.M:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = Redact(val)
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(t))
+		for i, val := range t {
+			out[i] = Redact(val)
+		}
+		return out
+	case []interface{}:
+		return Redact(bson.A(t))
+	}
+	return "?"
+}
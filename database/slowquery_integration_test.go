@@ -0,0 +1,105 @@
+//go:build integration
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// syncBuffer is a log destination safe for the detached explain.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWinningPlan(t *testing.T) {
+	repo := testRepository(t)
+	ctx := context.Background()
+	if _, err := EnsureIndexes(ctx, repo.products); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := WinningPlan(ctx, repo.products, QueryInfo{Filter: bson.M{"keyLower": "health-plus"}, Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.ToUpper(stringify(plan)); !strings.Contains(s, "IXSCAN") || !strings.Contains(s, "KEYLOWER_1") {
+		t.Errorf("winning plan %v does not scan keyLower_1", plan)
+	}
+}
+
+// TestSlowQueryExplainGated checks that a slow find's plan is logged only
+// with SLOW_QUERY_EXPLAIN on, and never for other operations.
+func TestSlowQueryExplainGated(t *testing.T) {
+	repo := testRepository(t)
+	saved, savedLogger := slowQueryCfg, slog.Default()
+	t.Cleanup(func() {
+		ConfigureSlowQueries(saved)
+		slog.SetDefault(savedLogger)
+	})
+
+	tests := []struct {
+		name      string
+		explain   bool
+		operation string
+		want      bool
+	}{
+		{"explain off", false, "find", false},
+		{"explain on", true, "find", true},
+		{"not a find", true, "countDocuments", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs syncBuffer
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			ConfigureSlowQueries(config.SlowQueryConfig{Threshold: time.Millisecond, Explain: tt.explain})
+
+			info := QueryInfo{Operation: tt.operation, Filter: bson.M{"key": "HEALTH-PLUS"}, Limit: 20}
+			ObserveQuery(context.Background(), repo.products, info, time.Second, 0, nil)
+			if !strings.Contains(logs.String(), "slow query") {
+				t.Fatalf("logs = %q, want the slow query", logs.String())
+			}
+
+			if tt.want {
+				for start := time.Now(); !strings.Contains(logs.String(), "slow query plan"); time.Sleep(10 * time.Millisecond) {
+					if time.Since(start) > 10*time.Second {
+						t.Fatalf("logs = %q, want the plan", logs.String())
+					}
+				}
+				if !strings.Contains(logs.String(), "winningPlan=") {
+					t.Errorf("logs = %q, want the winning plan", logs.String())
+				}
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+			if strings.Contains(logs.String(), "slow query plan") {
+				t.Errorf("logs = %q, want no plan", logs.String())
+			}
+		})
+	}
+}
+
+func stringify(v interface{}) string {
+	b, _ := bson.MarshalExtJSON(bson.M{"plan": v}, false, false)
+	return string(b)
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StatsPipeline counts the products matching filter by the value of the
+// expression path on the flattened documents, largest count first. When
+// unwind names an array path, it is unwound first so each product counts
+// once per element.
+func StatsPipeline(filter bson.M, path, unwind string) mongo.Pipeline {
+	pipeline := FlattenStages(filter)
+	if unwind != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: unwind}})
+	}
+	return append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{"_id": path, "count": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	)
+}
+
+// VerifyReport lists integrity problems in a product collection.
+type VerifyReport struct {
+	// Malformed counts productList entries that are not documents.
+	Malformed int64 `json:"malformed" bson:"malformed"`
+	// MissingID counts products with neither an id nor an _id.
+	MissingID int64 `json:"missingId" bson:"missingId"`
+	// MissingInsurer counts products without an insurer code.
+	MissingInsurer int64 `json:"missingInsurer" bson:"missingInsurer"`
+	// DuplicateIDs are product ids held by more than one product, at most
+	// verifyMaxDuplicates of them.
+	DuplicateIDs []string `json:"duplicateIds" bson:"duplicateIds"`
+}
+
+const verifyMaxDuplicates = 100
+
+// Verify scans coll for the problems listed in VerifyReport. It reads the
+// whole collection.
+func Verify(ctx context.Context, coll *mongo.Collection) (VerifyReport, error) {
+	item := func(cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	isObject := bson.M{"$eq": bson.A{bson.M{"$type": "$productList"}, "object"}}
+	pipeline := append(FlattenStagesKeepingMalformed(nil),
+		bson.D{{Key: "$facet", Value: bson.M{
+			"counts": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":       nil,
+					"malformed": item(bson.M{"$not": bson.A{isObject}}),
+					"missingId": item(bson.M{"$and": bson.A{
+						isObject,
+						bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$productList.id", ""}}, ""}},
+						bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$productList._id", ""}}, ""}},
+					}}),
+					"missingInsurer": item(bson.M{"$and": bson.A{
+						isObject,
+						bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$productList.insurer.insurerCode", ""}}, ""}},
+					}}),
+				}},
+			},
+			"duplicates": bson.A{
+				bson.M{"$match": bson.M{"productList.id": bson.M{"$nin": bson.A{nil, ""}}}},
+				bson.M{"$group": bson.M{"_id": asString("$productList.id"), "n": bson.M{"$sum": 1}}},
+				bson.M{"$match": bson.M{"n": bson.M{"$gt": 1}}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+				bson.M{"$limit": verifyMaxDuplicates},
+			},
+		}}},
+	)
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []struct {
+		Counts     []VerifyReport `bson:"counts"`
+		Duplicates []struct {
+			ID string `bson:"_id"`
+		} `bson:"duplicates"`
+	}
+	if err := cursor.All(ctx, &out); err != nil {
+		return VerifyReport{}, err
+	}
+	report := VerifyReport{DuplicateIDs: []string{}}
+	if len(out) == 0 {
+		return report, nil
+	}
+	if len(out[0].Counts) > 0 {
+		report = out[0].Counts[0]
+		report.DuplicateIDs = []string{}
+	}
+	for _, d := range out[0].Duplicates {
+		report.DuplicateIDs = append(report.DuplicateIDs, d.ID)
+	}
+	return report, nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"sort"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tenant is one business unit's product catalogue and the collections that
+// accompany it.
+type Tenant struct {
+	Name string
+	// Repo holds the product groups. Handlers reach the groups through it;
+	// Products and List remain for the admin and migration code that still
+	// needs the collections themselves.
+	Repo     ProductRepository
+	Products *mongo.Collection
+	// List is Products with the listing read preference and concern.
+	List  *mongo.Collection
+	Audit *mongo.Collection
+	// Flat is the products_flat collection: one document per product, see
+	// SyncFlatGroup. FlatList is Flat with the listing read preference.
+	Flat     *mongo.Collection
+	FlatList *mongo.Collection
+	// Brokers is the broker channel master data, keyed by broker key.
+	Brokers *mongo.Collection
+	// Insurers is the insurer master data, keyed by insurer code.
+	Insurers *mongo.Collection
+	// ProductTypes is the product type master data, keyed by type key.
+	ProductTypes *mongo.Collection
+	Jobs         *mongo.Collection
+}
+
+var (
+	tenants       = map[string]*Tenant{}
+	defaultTenant string
+)
+
+func setupTenants(client *mongo.Client, cfg config.MongoConfig) error {
+	tenants = map[string]*Tenant{}
+	for _, tc := range cfg.Tenants {
+		db := client.Database(tc.Database)
+		repo, err := NewMongoProductRepository(client, tc.Name, tc.Database, tc.Collection, cfg)
+		if err != nil {
+			return err
+		}
+		flat := db.Collection(tc.Collection + "_flat")
+		flatList, err := listCollection(flat, cfg)
+		if err != nil {
+			return err
+		}
+		tenants[tc.Name] = &Tenant{
+			Name:         tc.Name,
+			Repo:         repo,
+			Products:     repo.products,
+			List:         repo.list,
+			Audit:        db.Collection("audit"),
+			Flat:         flat,
+			FlatList:     flatList,
+			Brokers:      db.Collection(BrokersCollection),
+			Insurers:     db.Collection(InsurersCollection),
+			ProductTypes: db.Collection(ProductTypesCollection),
+			Jobs:         db.Collection(JobsCollection),
+		}
+	}
+	defaultTenant = cfg.DefaultTenant
+	return nil
+}
+
+// RegisterTenants makes list the configured tenants, the one named
+// defaultName being the default. It serves tenants that were not set up
+// from a Mongo client, as in handler tests.
+func RegisterTenants(defaultName string, list ...*Tenant) {
+	tenants = map[string]*Tenant{}
+	for _, t := range list {
+		tenants[t.Name] = t
+	}
+	defaultTenant = defaultName
+}
+
+// LookupTenant returns the tenant with the given name.
+func LookupTenant(name string) (*Tenant, bool) {
+	t, ok := tenants[name]
+	return t, ok
+}
+
+// DefaultTenant returns the tenant used when a request names none, or nil
+// when every request must name one.
+func DefaultTenant() *Tenant {
+	return tenants[defaultTenant]
+}
+
+// Tenants returns every configured tenant ordered by name.
+func Tenants() []*Tenant {
+	out := make([]*Tenant, 0, len(tenants))
+	for _, t := range tenants {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// TenantNames lists the configured tenant names, ordered.
+func TenantNames() []string {
+	var names []string
+	for _, t := range Tenants() {
+		names = append(names, t.Name)
+	}
+	return names
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transactionsSupported is set at connect time; standalone servers cannot
+// run multi-document transactions.
+var transactionsSupported bool
+
+func detectTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("Could not determine deployment type, transactions disabled: %v", err)
+		return false
+	}
+	_, replicaSet := hello["setName"]
+	return replicaSet || hello["msg"] == "isdbgrid"
+}
+
+// WithTransaction runs fn inside a transaction when the deployment supports
+// them, and directly otherwise. fn must do all its work through the context
+// it receives and may be retried on transient transaction errors.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !transactionsSupported {
+		return fn(ctx)
+	}
+	session, err := Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,34 @@
+// Package fixtures holds the sample product group documents used for local
+// development. Version is bumped whenever the documents change.
+package fixtures
+
+import (
+	_ "embed"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Version identifies the fixture set.
+const Version = "v1"
+
+//go:embed groups.json
+var groupsJSON []byte
+
+// Groups returns the fixture group documents, decoded from extended JSON.
+// They cover several product types, insurers and brokers, every status,
+// Thai and English names, a product without an insurer and one without
+// brokers.
+func Groups() ([]interface{}, error) {
+	var doc struct {
+		Groups []bson.M `bson:"groups"`
+	}
+	if err := bson.UnmarshalExtJSON(groupsJSON, false, &doc); err != nil {
+		return nil, fmt.Errorf("decoding fixtures %s: %w", Version, err)
+	}
+	groups := make([]interface{}, len(doc.Groups))
+	for i, g := range doc.Groups {
+		groups[i] = g
+	}
+	return groups, nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/migrations"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// indexBuildTimeout is generous because index builds on a large collection
+// far outlast a normal query.
+const indexBuildTimeout = 5 * time.Minute
+
+// EnsureIndexes re-runs index creation on demand.
+func (h *Handler) EnsureIndexes(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), indexBuildTimeout)
+	defer cancel()
+
+	report, err := database.EnsureIndexes(ctx, tenant(c).Products)
+	if err != nil {
+		return apierror.Internal(c, "ensuring indexes", err)
+	}
+	return c.JSON(report)
+}
+
+// migrationTimeout bounds a manual migration run.
+const migrationTimeout = 30 * time.Minute
+
+// ListMigrations reports every registered migration and whether it ran.
+func (h *Handler) ListMigrations(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	statuses, err := migrations.List(ctx, tenant(c).Products.Database())
+	if err != nil {
+		return apierror.Internal(c, "listing migrations", err)
+	}
+	return c.JSON(fiber.Map{"data": statuses})
+}
+
+// RunMigrations applies pending migrations.
+func (h *Handler) RunMigrations(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), migrationTimeout)
+	defer cancel()
+
+	applied, err := migrations.Run(ctx, tenant(c).Products.Database(), tenant(c).Products)
+	if errors.Is(err, migrations.ErrLocked) {
+		return apierror.Send(c, fiber.StatusConflict, "MIGRATIONS_RUNNING", err.Error())
+	}
+	if err != nil {
+		return apierror.Internal(c, "running migrations", err)
+	}
+	return c.JSON(fiber.Map{"applied": applied})
+}
+
+// GetMaintenance reports the maintenance switch.
+func (h *Handler) GetMaintenance(c *fiber.Ctx) error {
+	return c.JSON(h.maintenance.State())
+}
+
+// SetMaintenance replaces the maintenance switch state.
+func (h *Handler) SetMaintenance(c *fiber.Ctx) error {
+	var state middleware.MaintenanceState
+	if err := validation.DecodeJSON(c.Body(), &state); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if err := state.Validate(); err != nil {
+		return apierror.Send(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED", err.Error())
+	}
+	h.maintenance.Set(state)
+	h.logger.Info("maintenance mode set", "scope", state.Scope)
+	return c.JSON(state)
+}
+
+// FlushCache drops the tenant's cached listing pages and counts.
+func (h *Handler) FlushCache(c *fiber.Ctx) error {
+	h.invalidateCache(c)
+	h.logger.Info("cache flushed", "tenant", tenant(c).Name)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditedChange is what a write operation reports for its audit entry.
+type auditedChange struct {
+	productID string
+	groupKey  string
+	before    bson.M
+	after     bson.M
+}
+
+// audited runs op and appends its audit entry in the same transaction (when
+// the deployment supports transactions), so a product never changes without
+// a record of it.
+func (h *Handler) audited(c *fiber.Ctx, ctx context.Context, action string, op func(ctx context.Context) (*auditedChange, error)) error {
+	actor := "anonymous"
+	if p := middleware.PrincipalFrom(c); p != nil {
+		actor = p.Subject
+	}
+	requestID := middleware.RequestIDFrom(c)
+	t := tenant(c)
+
+	return database.Breaker.Do(ctx, func() error {
+		return database.WithTransaction(ctx, func(ctx context.Context) error {
+			change, err := op(ctx)
+			if err != nil {
+				return err
+			}
+			return audit.Record(ctx, t.Audit, audit.Entry{
+				Actor:     actor,
+				Action:    action,
+				ProductID: change.productID,
+				GroupKey:  change.groupKey,
+				Changes:   audit.Diff(change.before, change.after),
+				RequestID: requestID,
+				Timestamp: h.now().UTC(),
+			})
+		})
+	})
+}
+
+// GetAudit lists audit entries, newest first, filtered by productId, actor
+// and an RFC3339 from/to timestamp range.
+func (h *Handler) GetAudit(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if id := query(c, "productId"); id != "" {
+		filter["productId"] = id
+	}
+	if actor := query(c, "actor"); actor != "" {
+		filter["actor"] = actor
+	}
+	timestamp := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		v := query(c, param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "INVALID_PARAMETER", param+" must be an RFC3339 timestamp")
+		}
+		timestamp[op] = t
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetSkip(paging.Skip()).
+		SetLimit(int64(paging.Limit))
+	cursor, err := tenant(c).Audit.Find(ctx, filter, opts)
+	if err != nil {
+		return apierror.Internal(c, "finding audit entries", err)
+	}
+	entries := []audit.Entry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return apierror.Internal(c, "decoding audit entries", err)
+	}
+	setPageLinks(c, paging, -1, len(entries))
+	return c.JSON(fiber.Map{"data": entries})
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BrokerChannel is an entry of the broker channel master data.
+type BrokerChannel struct {
+	Key         string    `json:"key" bson:"_id"`
+	ChannelName string    `json:"channelName" bson:"channelName"`
+	Active      bool      `json:"active" bson:"active"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+	UpdatedBy   string    `json:"updatedBy,omitempty" bson:"updatedBy"`
+}
+
+// BrokerChannelInput is the body of the broker create and update
+// endpoints. Key is only read on create; Active defaults to true.
+type BrokerChannelInput struct {
+	Key         string `json:"key" validate:"pattern=brokerKey"`
+	ChannelName string `json:"channelName" validate:"required,max=200"`
+	Active      *bool  `json:"active"`
+}
+
+// parseBrokerInput is parseProductInput for BrokerChannelInput.
+func parseBrokerInput(c *fiber.Ctx) (*BrokerChannelInput, error) {
+	var in BrokerChannelInput
+	if err := validation.DecodeJSON(c.Body(), &in); err != nil {
+		return nil, apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if err := validation.Struct(&in); err != nil {
+		var verrs validation.Errors
+		errors.As(err, &verrs)
+		return nil, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED",
+			"request body failed validation", verrs)
+	}
+	return &in, nil
+}
+
+func (in *BrokerChannelInput) active() bool {
+	return in.Active == nil || *in.Active
+}
+
+// loadBrokers reads the whole broker master data of the request's tenant,
+// by key. It is small enough to read per write.
+func (h *Handler) loadBrokers(c *fiber.Ctx, ctx context.Context, filter bson.M) ([]BrokerChannel, error) {
+	brokers := []BrokerChannel{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := tenant(c).Brokers.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &brokers)
+	})
+	return brokers, err
+}
+
+// GetBrokers lists the broker channels ordered by key, optionally only the
+// ?active=true or false ones.
+func (h *Handler) GetBrokers(c *fiber.Ctx) error {
+	filter := bson.M{}
+	switch query(c, "active") {
+	case "":
+	case "true":
+		filter["active"] = true
+	case "false":
+		filter["active"] = false
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_PARAMETER", "active must be true or false")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+	brokers, err := h.loadBrokers(c, ctx, filter)
+	if err != nil {
+		return queryError(c, "listing brokers", err)
+	}
+	return c.JSON(fiber.Map{"totalCount": len(brokers), "data": brokers})
+}
+
+func (h *Handler) GetBroker(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var broker BrokerChannel
+	err := database.Breaker.Do(ctx, func() error {
+		return tenant(c).Brokers.FindOne(ctx, bson.M{"_id": c.Params("key")}).Decode(&broker)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return brokerNotFound(c)
+	}
+	if err != nil {
+		return queryError(c, "finding broker", err)
+	}
+	return c.JSON(broker)
+}
+
+func (h *Handler) CreateBroker(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	in, err := parseBrokerInput(c)
+	if in == nil {
+		return err
+	}
+	if in.Key == "" {
+		return apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED", "request body failed validation",
+			validation.Errors{{Field: "key", Rule: "required", Message: "is required"}})
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	broker := BrokerChannel{
+		Key:         in.Key,
+		ChannelName: database.Normalize(in.ChannelName),
+		Active:      in.active(),
+		UpdatedAt:   database.Timestamp(h.now()),
+		UpdatedBy:   actor,
+	}
+	err = database.Breaker.Do(ctx, func() error {
+		_, err := tenant(c).Brokers.InsertOne(ctx, broker)
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return apierror.Send(c, fiber.StatusConflict, "BROKER_EXISTS", "broker "+in.Key+" already exists")
+	}
+	if err != nil {
+		return queryError(c, "creating broker", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(broker)
+}
+
+// UpdateBroker replaces the channel name and active flag of a broker. The
+// products embedding it are left as they are; the consistency report lists
+// the ones whose channel name no longer matches.
+func (h *Handler) UpdateBroker(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	in, err := parseBrokerInput(c)
+	if in == nil {
+		return err
+	}
+	key := c.Params("key")
+	if in.Key != "" && in.Key != key {
+		return apierror.Send(c, fiber.StatusBadRequest, "KEY_MISMATCH", "the body's key must match the path or be omitted")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var broker BrokerChannel
+	err = database.Breaker.Do(ctx, func() error {
+		return tenant(c).Brokers.FindOneAndUpdate(ctx,
+			bson.M{"_id": key},
+			bson.M{"$set": bson.M{
+				"channelName": database.Normalize(in.ChannelName),
+				"active":      in.active(),
+				"updatedAt":   database.Timestamp(h.now()),
+				"updatedBy":   actor,
+			}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&broker)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return brokerNotFound(c)
+	}
+	if err != nil {
+		return queryError(c, "updating broker", err)
+	}
+	return c.JSON(broker)
+}
+
+// DeleteBroker removes a broker from the master data. Products still using
+// it then show up as unknown in the consistency report; deactivating is the
+// gentler option.
+func (h *Handler) DeleteBroker(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var res *mongo.DeleteResult
+	err := database.Breaker.Do(ctx, func() error {
+		var err error
+		res, err = tenant(c).Brokers.DeleteOne(ctx, bson.M{"_id": c.Params("key")})
+		return err
+	})
+	if err != nil {
+		return queryError(c, "deleting broker", err)
+	}
+	if res.DeletedCount == 0 {
+		return brokerNotFound(c)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func brokerNotFound(c *fiber.Ctx) error {
+	return apierror.Send(c, fiber.StatusNotFound, "BROKER_NOT_FOUND", "broker "+c.Params("key")+" does not exist")
+}
+
+type brokerProblem struct {
+	Key         string   `json:"key"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// checkBrokers holds a product write to the broker master data when
+// MasterData.EnforceBrokers is set: every broker must exist, and be active
+// unless ?force=true. Empty channel names are filled in from the master. It
+// writes the 422 itself when ok is false.
+func (h *Handler) checkBrokers(c *fiber.Ctx, ctx context.Context, in *ProductInput) (ok bool, err error) {
+	if !h.cfg.MasterData.EnforceBrokers || len(in.Brokers) == 0 {
+		return true, nil
+	}
+	brokers, err := h.loadBrokers(c, ctx, bson.M{})
+	if err != nil {
+		return false, queryError(c, "loading brokers", err)
+	}
+	master := make(map[string]BrokerChannel, len(brokers))
+	keys := make([]string, 0, len(brokers))
+	for _, b := range brokers {
+		master[b.Key] = b
+		keys = append(keys, b.Key)
+	}
+
+	var unknown, inactive []brokerProblem
+	for i, b := range in.Brokers {
+		m, found := master[b.Key]
+		switch {
+		case !found:
+			unknown = append(unknown, brokerProblem{Key: b.Key, Suggestions: suggest(b.Key, keys)})
+		case !m.Active:
+			inactive = append(inactive, brokerProblem{Key: b.Key})
+		case b.ChannelName == "":
+			in.Brokers[i].ChannelName = m.ChannelName
+		}
+	}
+	if len(unknown) > 0 {
+		return false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "UNKNOWN_BROKER",
+			"brokers must be registered in the broker master data", unknown)
+	}
+	if len(inactive) > 0 && query(c, "force") != "true" {
+		return false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "INACTIVE_BROKER",
+			"brokers are inactive; retry with force=true to use them anyway", inactive)
+	}
+	return true, nil
+}
+
+type brokerUsage struct {
+	Key struct {
+		Key         string `bson:"key"`
+		ChannelName string `bson:"channelName"`
+	} `bson:"_id"`
+	Count int64 `bson:"count"`
+}
+
+type brokerInconsistency struct {
+	Key               string   `json:"key"`
+	ChannelName       string   `json:"channelName"`
+	MasterChannelName string   `json:"masterChannelName,omitempty"`
+	Products          int64    `json:"products"`
+	Suggestions       []string `json:"suggestions,omitempty"`
+}
+
+// GetBrokerConsistency compares the brokers embedded in products with the
+// master data. It reports keys missing from the master, with suggestions;
+// channel names that differ from the master's; and inactive brokers still
+// in use. Products counts the products using each key and name pair.
+func (h *Handler) GetBrokerConsistency(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	brokers, err := h.loadBrokers(c, ctx, bson.M{})
+	if err != nil {
+		return queryError(c, "loading brokers", err)
+	}
+	usage := []brokerUsage{}
+	err = database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, database.BrokerUsagePipeline(),
+			options.Aggregate().SetAllowDiskUse(h.cfg.Mongo.AnalyticsAllowDiskUse).SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &usage)
+	})
+	if err != nil {
+		return queryError(c, "aggregating broker usage", err)
+	}
+
+	master := make(map[string]BrokerChannel, len(brokers))
+	keys := make([]string, 0, len(brokers))
+	for _, b := range brokers {
+		master[b.Key] = b
+		keys = append(keys, b.Key)
+	}
+	unknown, mismatched, inactive := []brokerInconsistency{}, []brokerInconsistency{}, []brokerInconsistency{}
+	for _, u := range usage {
+		entry := brokerInconsistency{Key: u.Key.Key, ChannelName: u.Key.ChannelName, Products: u.Count}
+		m, found := master[u.Key.Key]
+		if !found {
+			entry.Suggestions = suggest(u.Key.Key, keys)
+			unknown = append(unknown, entry)
+			continue
+		}
+		entry.MasterChannelName = m.ChannelName
+		if !m.Active {
+			inactive = append(inactive, entry)
+		}
+		if u.Key.ChannelName != m.ChannelName {
+			mismatched = append(mismatched, entry)
+		}
+	}
+	return c.JSON(fiber.Map{"unknown": unknown, "channelMismatch": mismatched, "inactive": inactive})
+}
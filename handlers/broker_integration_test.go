@@ -0,0 +1,181 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+// brokerMaster registers the fixtures' online, branch and agent brokers,
+// the branch under another channel name and the agent inactive.
+func brokerMaster(t *testing.T, app *fiber.App) {
+	t.Helper()
+	for _, body := range []string{
+		`{"key": "BROKER-ONLINE", "channelName": "Online"}`,
+		`{"key": "BROKER-BRANCH", "channelName": "Branch"}`,
+		`{"key": "BROKER-AGENT", "channelName": "Agent", "active": false}`,
+	} {
+		if resp, out := call(t, app, fiber.MethodPost, "/brokers", body); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", body, resp.StatusCode, out)
+		}
+	}
+}
+
+func TestIntegrationBrokers(t *testing.T) {
+	app := integrationApp(t, nil)
+	brokerMaster(t, app)
+
+	if resp, out := call(t, app, fiber.MethodPost, "/brokers", `{"key": "BROKER-ONLINE", "channelName": "Web"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second create: %d %s, want 409", resp.StatusCode, out)
+	}
+
+	keys := func(target string) []string {
+		t.Helper()
+		resp, out := call(t, app, fiber.MethodGet, target, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %d %s", target, resp.StatusCode, out)
+		}
+		var page struct{ Data []handlers.BrokerChannel }
+		if err := json.Unmarshal(out, &page); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, b := range page.Data {
+			keys = append(keys, b.Key)
+		}
+		return keys
+	}
+	if got, want := keys("/brokers"), []string{"BROKER-AGENT", "BROKER-BRANCH", "BROKER-ONLINE"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("brokers = %v, want %v", got, want)
+	}
+	if got, want := keys("/brokers?active=false"), []string{"BROKER-AGENT"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("inactive brokers = %v, want %v", got, want)
+	}
+
+	resp, out := call(t, app, fiber.MethodPut, "/brokers/BROKER-BRANCH", `{"channelName": "สาขา"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update: %d %s", resp.StatusCode, out)
+	}
+	var updated handlers.BrokerChannel
+	if err := json.Unmarshal(out, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.ChannelName != "สาขา" || !updated.Active || updated.UpdatedBy != "integration" {
+		t.Errorf("updated = %+v, want the new name, active, by integration", updated)
+	}
+	if resp, out := call(t, app, fiber.MethodGet, "/brokers/BROKER-BRANCH", ""); resp.StatusCode != http.StatusOK || !strings.Contains(string(out), "สาขา") {
+		t.Errorf("lookup after update: %d %s", resp.StatusCode, out)
+	}
+
+	for _, missing := range []struct{ method, body string }{
+		{fiber.MethodGet, ""},
+		{fiber.MethodPut, `{"channelName": "Gone"}`},
+		{fiber.MethodDelete, ""},
+	} {
+		if resp, out := call(t, app, missing.method, "/brokers/BROKER-GONE", missing.body); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s of a missing broker: %d %s, want 404", missing.method, resp.StatusCode, out)
+		}
+	}
+	if resp, out := call(t, app, fiber.MethodDelete, "/brokers/BROKER-BRANCH", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete: %d %s", resp.StatusCode, out)
+	}
+	if got, want := keys("/brokers"), []string{"BROKER-AGENT", "BROKER-ONLINE"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("brokers after the delete = %v, want %v", got, want)
+	}
+}
+
+// TestIntegrationBrokerEnforcement writes products naming unknown,
+// inactive and registered brokers with the master data enforced.
+func TestIntegrationBrokerEnforcement(t *testing.T) {
+	app := integrationApp(t, map[string]string{"MASTER_DATA_ENFORCE_BROKERS": "true"})
+	brokerMaster(t, app)
+	product := func(name, broker string) string {
+		return `{"productName": "` + name + `", "productGroup": {"key": "HEALTH-PLUS"},
+			"insurer": {"insurerCode": "TIP"}, "brokers": [{"key": "` + broker + `"}], "status": "DRAFT"}`
+	}
+
+	resp, out := call(t, app, fiber.MethodPost, "/products", product("Health Plus Kids", "BROKER_ONLNE"))
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(out), `"UNKNOWN_BROKER"`) {
+		t.Fatalf("unknown broker: %d %s, want 422 UNKNOWN_BROKER", resp.StatusCode, out)
+	}
+	var env struct {
+		Error struct {
+			Details []struct {
+				Key         string   `json:"key"`
+				Suggestions []string `json:"suggestions"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(out, &env); err != nil {
+		t.Fatal(err)
+	}
+	if d := env.Error.Details; len(d) != 1 || !reflect.DeepEqual(d[0].Suggestions, []string{"BROKER-ONLINE"}) {
+		t.Errorf("details = %+v, want BROKER-ONLINE suggested", d)
+	}
+
+	if resp, out := call(t, app, fiber.MethodPost, "/products", product("Health Plus Kids", "BROKER-AGENT")); resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(out), `"INACTIVE_BROKER"`) {
+		t.Errorf("inactive broker: %d %s, want 422 INACTIVE_BROKER", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodPost, "/products?force=true", product("Health Plus Kids", "BROKER-AGENT")); resp.StatusCode != http.StatusCreated {
+		t.Errorf("inactive broker forced: %d %s, want 201", resp.StatusCode, out)
+	}
+
+	// A registered broker without a channel name gets the master's.
+	resp, out = call(t, app, fiber.MethodPost, "/products", product("Health Plus Teens", "BROKER-ONLINE"))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("registered broker: %d %s", resp.StatusCode, out)
+	}
+	var created handlers.Product
+	if err := json.Unmarshal(out, &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Brokers) != 1 || created.Brokers[0].ChannelName != "Online" {
+		t.Errorf("brokers = %+v, want the master's channel name", created.Brokers)
+	}
+}
+
+// TestIntegrationBrokerConsistency compares the fixtures' embedded brokers
+// with a master data that lacks the telesales broker, names the branch
+// differently and has the agent inactive.
+func TestIntegrationBrokerConsistency(t *testing.T) {
+	app := integrationApp(t, nil)
+	brokerMaster(t, app)
+
+	resp, out := call(t, app, fiber.MethodGet, "/admin/brokers/consistency", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, out)
+	}
+	type entry struct {
+		Key               string `json:"key"`
+		ChannelName       string `json:"channelName"`
+		MasterChannelName string `json:"masterChannelName"`
+		Products          int64  `json:"products"`
+	}
+	var report struct {
+		Unknown         []entry `json:"unknown"`
+		ChannelMismatch []entry `json:"channelMismatch"`
+		Inactive        []entry `json:"inactive"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		t.Fatal(err)
+	}
+	want := struct {
+		Unknown, ChannelMismatch, Inactive []entry
+	}{
+		Unknown:         []entry{{Key: "BROKER-TELESALES", ChannelName: "Telesales", Products: 1}},
+		ChannelMismatch: []entry{{Key: "BROKER-BRANCH", ChannelName: "สาขา", MasterChannelName: "Branch", Products: 2}},
+		Inactive:        []entry{{Key: "BROKER-AGENT", ChannelName: "Agent", MasterChannelName: "Agent", Products: 2}},
+	}
+	if !reflect.DeepEqual(report.Unknown, want.Unknown) ||
+		!reflect.DeepEqual(report.ChannelMismatch, want.ChannelMismatch) ||
+		!reflect.DeepEqual(report.Inactive, want.Inactive) {
+		t.Errorf("report = %+v\nwant %+v", report, want)
+	}
+}
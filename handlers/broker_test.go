@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+func brokerRoutes(app *fiber.App, h *Handler) {
+	app.Get("/brokers", h.GetBrokers)
+	app.Post("/brokers", h.CreateBroker)
+	app.Put("/brokers/:key", h.UpdateBroker)
+}
+
+// TestBrokerRequestsRejected covers the broker requests turned away before
+// the master data is read: the test tenant has no brokers collection, so
+// any of them reaching it would panic.
+func TestBrokerRequestsRejected(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+		code                       string
+	}{
+		{"active not a bool", fiber.MethodGet, "/brokers?active=yes", "", http.StatusBadRequest, "INVALID_PARAMETER"},
+		{"create without key", fiber.MethodPost, "/brokers", `{"channelName": "Line"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create malformed key", fiber.MethodPost, "/brokers", `{"key": "LINE BK", "channelName": "Line"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create without channel name", fiber.MethodPost, "/brokers", `{"key": "LINE-BK"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create unknown field", fiber.MethodPost, "/brokers", `{"key": "LINE-BK", "channel": "Line"}`, http.StatusBadRequest, "INVALID_BODY"},
+		{"update other key", fiber.MethodPut, "/brokers/LINE-BK", `{"key": "LINE-BKK", "channelName": "Line"}`, http.StatusBadRequest, "KEY_MISMATCH"},
+		{"update without channel name", fiber.MethodPut, "/brokers/LINE-BK", `{"active": false}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, brokerRoutes)
+			resp, body := do(t, app, tt.method, tt.target, tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+// TestCheckBrokersDisabled checks that product writes do not read the
+// broker master data unless it is enforced and there are brokers.
+func TestCheckBrokersDisabled(t *testing.T) {
+	product := `{"productName": "Health Plus Senior", "productGroup": {"key": "HEALTH-PLUS"},
+		"insurer": {"insurerCode": "AXA"}, "status": "DRAFT"%s}`
+	tests := []struct {
+		name     string
+		enforced bool
+		brokers  string
+	}{
+		{"not enforced", false, `, "brokers": [{"key": "BROKER-GONE"}]`},
+		{"no brokers", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MasterData.EnforceBrokers = tt.enforced
+			app := newTestApp(cfg, &mocks.ProductRepository{}, writeRoutes)
+			resp, out := do(t, app, fiber.MethodPost, "/products?dryRun=true", fmt.Sprintf(product, tt.brokers))
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%d %s, want the dry run to pass", resp.StatusCode, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// cachedPage returns the cached response body for key in the request's
+// tenant. Callers can bypass the cache with Cache-Control: no-cache; the
+// fresh response still refills it.
+func (h *Handler) cachedPage(c *fiber.Ctx, key string) ([]byte, bool) {
+	if h.cache == nil || strings.Contains(strings.ToLower(c.Get(fiber.HeaderCacheControl)), "no-cache") {
+		return nil, false
+	}
+	return h.cache.Get(c.UserContext(), tenant(c).Name, key)
+}
+
+func sendCached(c *fiber.Ctx, body []byte) error {
+	c.Set("X-Cache", "HIT")
+	return sendPage(c, body)
+}
+
+func sendPage(c *fiber.Ctx, body []byte) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
+
+// loadPage produces the JSON body for a cache miss. Concurrent misses for
+// the same key in a tenant share a single load, so a cold hot key does not
+// stampede Mongo. The load runs on a context of its own, bounded by the
+// server's query timeout, so one caller hanging up or running out of its
+// budget does not fail the others: each waits only as long as its own ctx,
+// and the load is cancelled once nobody is waiting for it.
+//
+// The load reads the request that started it, so that request's handler
+// stays until the load has ended even after giving up on it.
+func (h *Handler) loadPage(c *fiber.Ctx, ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) ([]byte, error) {
+	t := tenant(c).Name
+	l, leader := h.pages.join(t+"|"+key, func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(database.WithEndpoint(context.Background(), c.Route().Path), h.cfg.HTTP.QueryTimeout)
+	})
+	if leader {
+		go func() {
+			defer h.pages.finish(t+"|"+key, l)
+			l.body, l.err = h.sharedLoad(c, l.ctx, t, key, load)
+		}()
+	}
+	select {
+	case <-l.done:
+		if l.err != nil {
+			return nil, l.err
+		}
+		if h.cache != nil {
+			c.Set("X-Cache", "MISS")
+		}
+		return l.body, nil
+	case <-ctx.Done():
+		h.pages.leave(t+"|"+key, l)
+		if leader {
+			<-l.done
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// sharedLoad runs load for loadPage and caches the encoded result. It uses
+// the server's query budget rather than the caller's.
+func (h *Handler) sharedLoad(c *fiber.Ctx, ctx context.Context, t, key string, load func(ctx context.Context) (interface{}, error)) ([]byte, error) {
+	headers := c.Locals(budgetHeadersKey)
+	c.Locals(budgetHeadersKey, budgetHeaders{})
+	defer c.Locals(budgetHeadersKey, headers)
+
+	response, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.App().Config().JSONEncoder(response)
+	if err != nil {
+		return nil, err
+	}
+	if h.cache != nil {
+		h.cache.Set(ctx, t, key, body)
+	}
+	return body, nil
+}
+
+// pageLoads tracks the shared loads in progress by key.
+type pageLoads struct {
+	mu    sync.Mutex
+	loads map[string]*pageLoad
+}
+
+// pageLoad is one shared load. body and err are set before done closes.
+type pageLoad struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	waiters int
+	body    []byte
+	err     error
+}
+
+// join waits on the load in progress for key, or starts one with a
+// context from start, in which case the caller is its leader and runs it.
+func (p *pageLoads) join(key string, start func() (context.Context, context.CancelFunc)) (*pageLoad, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.loads[key]; ok {
+		l.waiters++
+		return l, false
+	}
+	if p.loads == nil {
+		p.loads = map[string]*pageLoad{}
+	}
+	ctx, cancel := start()
+	l := &pageLoad{ctx: ctx, cancel: cancel, done: make(chan struct{}), waiters: 1}
+	p.loads[key] = l
+	return l, true
+}
+
+// leave stops waiting on l. The last waiter to leave cancels it, and later
+// callers start afresh rather than join a cancelled load.
+func (p *pageLoads) leave(key string, l *pageLoad) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l.waiters--
+	if l.waiters > 0 {
+		return
+	}
+	l.cancel()
+	if p.loads[key] == l {
+		delete(p.loads, key)
+	}
+}
+
+// finish publishes l's result to its waiters.
+func (p *pageLoads) finish(key string, l *pageLoad) {
+	p.mu.Lock()
+	if p.loads[key] == l {
+		delete(p.loads, key)
+	}
+	p.mu.Unlock()
+	l.cancel()
+	close(l.done)
+}
+
+// invalidateCache drops every cached page of the request's tenant after a
+// write.
+func (h *Handler) invalidateCache(c *fiber.Ctx) {
+	h.invalidateTenant(c.UserContext(), tenant(c).Name)
+}
+
+// invalidateTenant is invalidateCache outside a request.
+func (h *Handler) invalidateTenant(ctx context.Context, name string) {
+	h.counts.invalidate(name)
+	if h.cache != nil {
+		h.cache.Invalidate(ctx, name)
+	}
+}
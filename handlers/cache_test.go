@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+func productRoutes(app *fiber.App, h *Handler) {
+	app.Get("/products/:id", h.GetProductByID)
+}
+
+// sharedLookup starts GET /products/HP-001 with header and returns its
+// status once it is done.
+func sharedLookup(t *testing.T, app *fiber.App, header ...string) <-chan int {
+	t.Helper()
+	status := make(chan int, 1)
+	go func() {
+		resp, _ := do(t, app, fiber.MethodGet, "/products/HP-001", "", header...)
+		status <- resp.StatusCode
+	}()
+	return status
+}
+
+// waitForCalls waits until repo has seen n calls.
+func waitForCalls(t *testing.T, repo *mocks.ProductRepository, n int) {
+	t.Helper()
+	for start := time.Now(); len(repo.Calls()) < n; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d calls, want %d", len(repo.Calls()), n)
+		}
+	}
+}
+
+func wantStatus(t *testing.T, name string, status <-chan int, want int) {
+	t.Helper()
+	select {
+	case got := <-status:
+		if got != want {
+			t.Errorf("%s: status = %d, want %d", name, got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s never finished", name)
+	}
+}
+
+// TestLoadPageOutlivesLeader lets the request that started a shared load
+// run out of its budget: the load goes on for the request still waiting.
+func TestLoadPageOutlivesLeader(t *testing.T) {
+	release := make(chan struct{})
+	repo := &mocks.ProductRepository{FindOneDoc: healthGroup(), Block: release}
+	app := newTestApp(testConfig(), repo, productRoutes)
+
+	leader := sharedLookup(t, app, "X-Timeout-Ms", "50")
+	waitForCalls(t, repo, 1)
+	follower := sharedLookup(t, app)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	wantStatus(t, "follower", follower, http.StatusOK)
+	wantStatus(t, "leader", leader, http.StatusGatewayTimeout)
+	if n := len(callsOf(repo, "FindOne")); n != 1 {
+		t.Errorf("%d lookups, want one shared", n)
+	}
+}
+
+// TestLoadPageWaitsOnOwnContext has a request join a shared load and give
+// up on it before it is done.
+func TestLoadPageWaitsOnOwnContext(t *testing.T) {
+	release := make(chan struct{})
+	repo := &mocks.ProductRepository{FindOneDoc: healthGroup(), Block: release}
+	app := newTestApp(testConfig(), repo, productRoutes)
+
+	leader := sharedLookup(t, app)
+	waitForCalls(t, repo, 1)
+	wantStatus(t, "follower", sharedLookup(t, app, "X-Timeout-Ms", "50"), http.StatusGatewayTimeout)
+	close(release)
+
+	wantStatus(t, "leader", leader, http.StatusOK)
+	if n := len(callsOf(repo, "FindOne")); n != 1 {
+		t.Errorf("%d lookups, want one shared", n)
+	}
+}
+
+// TestLoadPageCancelledWithoutWaiters checks that a load nobody waits for
+// any more is cancelled, and that later requests start a new one.
+func TestLoadPageCancelledWithoutWaiters(t *testing.T) {
+	release := make(chan struct{})
+	repo := &mocks.ProductRepository{FindOneDoc: healthGroup(), Block: release}
+	app := newTestApp(testConfig(), repo, productRoutes)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, body := do(t, app, fiber.MethodGet, "/products/HP-001", "", "X-Timeout-Ms", "50")
+			if resp.StatusCode != http.StatusGatewayTimeout {
+				t.Errorf("%d %s, want 504", resp.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+	lookups := len(callsOf(repo, "FindOne"))
+
+	close(release)
+	if resp, body := do(t, app, fiber.MethodGet, "/products/HP-001", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("after the abandoned load: %d %s, want 200", resp.StatusCode, body)
+	}
+	if n := len(callsOf(repo, "FindOne")); n != lookups+1 {
+		t.Errorf("%d lookups after %d abandoned, want a fresh one", n, lookups)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	minCompare = 2
+	maxCompare = 5
+)
+
+// compareItem is one requested product; Product is nil when it does not
+// exist.
+type compareItem struct {
+	ID      string   `json:"id"`
+	Found   bool     `json:"found"`
+	Product *Product `json:"product,omitempty"`
+}
+
+// comparison is how one product differs from the first one found.
+type comparison struct {
+	ID      string `json:"id"`
+	Against string `json:"against"`
+	ProductDiff
+}
+
+// CompareProducts returns 2 to 5 products side by side, with how each one
+// differs from the first that exists. Missing ids are reported in place
+// instead of failing the call.
+func (h *Handler) CompareProducts(c *fiber.Ctx) error {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(query(c, "ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < minCompare || len(ids) > maxCompare {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_IDS",
+			fmt.Sprintf("ids must list %d to %d distinct product ids", minCompare, maxCompare))
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	or := make(bson.A, len(ids))
+	for i, id := range ids {
+		or[i] = database.ItemFilter(id)
+	}
+	var groups []database.GroupDocument
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Find(ctx, bson.M{"$or": or},
+			options.Find().
+				SetProjection(bson.M{"key": 1, "name": 1, "productType": 1, "productList": 1}).
+				SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &groups)
+	})
+	if err != nil {
+		return queryError(c, "finding products", err)
+	}
+
+	items := make([]compareItem, len(ids))
+	var base *Product
+	differences := []comparison{}
+	for i, id := range ids {
+		items[i] = compareItem{ID: id}
+		for _, group := range groups {
+			if item, ok := group.Item(id); ok {
+				p := mapProduct(group, item).withBrokers()
+				items[i].Found, items[i].Product = true, &p
+				break
+			}
+		}
+		if p := items[i].Product; p != nil {
+			if base == nil {
+				base = p
+				continue
+			}
+			differences = append(differences, comparison{ID: id, Against: base.ID, ProductDiff: diffProducts(*base, *p)})
+		}
+	}
+	return c.JSON(fiber.Map{"data": items, "differences": differences})
+}
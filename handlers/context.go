@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// queryContext returns the context Mongo operations for this request should
+// use. It derives from the request's user context rather than
+// context.Background so RequestContext's cancellation reaches the driver, labelled with the route for slow query reporting. fasthttp's own
+// RequestCtx is deliberately not used as the parent:
+// it is only cancelled on server shutdown, which would abort the queries
+// graceful shutdown is trying to drain.
+//
+// Callers may ask for a different budget via X-Request-Deadline (RFC3339)
+// or X-Timeout-Ms, capped by the configured maximum. A deadline that has
+// already passed yields an expired context, so the query fails at once with
+// the usual 504; CheckDeadline turns such requests away up front.
+func (h *Handler) queryContext(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	return h.boundedContext(c, c.UserContext())
+}
+
+// boundedContext derives the query context from parent. A budget the
+// caller chose is marked on it, so running out of it does not count
+// against the circuit breaker.
+func (h *Handler) boundedContext(c *fiber.Ctx, parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := database.WithEndpoint(parent, c.Route().Path)
+	timeout, fromCaller := h.requestTimeout(c)
+	if fromCaller {
+		ctx = database.WithCallerDeadline(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// RequestContext gives the request a user context that is cancelled when
+// the client hangs up and, when the caller sent X-Request-Deadline or
+// X-Timeout-Ms, ends at that deadline, so the queries it runs stop with it.
+// The headers are read here once: handlers compute query budgets from
+// concurrent goroutines, and fasthttp header lookups are not safe for that.
+func (h *Handler) RequestContext(c *fiber.Ctx) error {
+	c.Locals(budgetHeadersKey, readBudgetHeaders(c))
+	ctx, cancel := context.WithCancel(c.UserContext())
+	defer cancel()
+	if timeout, fromCaller := h.requestTimeout(c); fromCaller {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	stop := watchDisconnect(c.Context().Conn(), cancel)
+	defer stop()
+	c.SetUserContext(ctx)
+	return c.Next()
+}
+
+// CheckDeadline answers a request whose deadline has already passed with
+// a 504 before it runs any query.
+func (h *Handler) CheckDeadline(c *fiber.Ctx) error {
+	if h.queryTimeout(c) <= 0 {
+		return apierror.Send(c, fiber.StatusGatewayTimeout, "DEADLINE_EXCEEDED", "the request could not be completed within its deadline")
+	}
+	return c.Next()
+}
+
+func (h *Handler) queryTimeout(c *fiber.Ctx) time.Duration {
+	timeout, _ := h.requestTimeout(c)
+	return timeout
+}
+
+const budgetHeadersKey = "budgetHeaders"
+
+// budgetHeaders are the headers a caller sets its query budget with.
+type budgetHeaders struct {
+	deadline, timeoutMs string
+}
+
+func readBudgetHeaders(c *fiber.Ctx) budgetHeaders {
+	if headers, ok := c.Locals(budgetHeadersKey).(budgetHeaders); ok {
+		return headers
+	}
+	return budgetHeaders{deadline: c.Get("X-Request-Deadline"), timeoutMs: c.Get("X-Timeout-Ms")}
+}
+
+// requestTimeout is the request's query budget and whether it came from
+// the caller's X-Request-Deadline or X-Timeout-Ms.
+func (h *Handler) requestTimeout(c *fiber.Ctx) (time.Duration, bool) {
+	headers := readBudgetHeaders(c)
+	timeout, fromCaller := h.cfg.HTTP.QueryTimeout, false
+	if deadline, err := time.Parse(time.RFC3339, headers.deadline); err == nil {
+		timeout, fromCaller = time.Until(deadline), true
+	} else if ms, err := strconv.Atoi(headers.timeoutMs); err == nil && ms > 0 {
+		timeout, fromCaller = time.Duration(ms)*time.Millisecond, true
+	}
+	if timeout > h.cfg.HTTP.MaxQueryTimeout {
+		timeout = h.cfg.HTTP.MaxQueryTimeout
+	}
+	return timeout, fromCaller
+}
+
+// maxTime is the server-side time limit for this request's queries: the
+// configured limit, or a little less than the caller's remaining budget so
+// Mongo stops working once nobody is waiting for the answer.
+func (h *Handler) maxTime(c *fiber.Ctx) time.Duration {
+	budget := h.queryTimeout(c)
+	budget -= budget / 10
+	if budget < time.Millisecond {
+		budget = time.Millisecond
+	}
+	if budget < h.cfg.Mongo.MaxTime {
+		return budget
+	}
+	return h.cfg.Mongo.MaxTime
+}
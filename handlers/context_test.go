@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	in := func(d time.Duration) string { return time.Now().Add(d).UTC().Format(time.RFC3339) }
+	tests := []struct {
+		name    string
+		header  []string
+		timeout time.Duration
+		maxTime time.Duration
+	}{
+		{name: "configured default", timeout: 5 * time.Second, maxTime: 4500 * time.Millisecond},
+		{name: "X-Timeout-Ms", header: []string{"X-Timeout-Ms", "200"}, timeout: 200 * time.Millisecond, maxTime: 180 * time.Millisecond},
+		{name: "X-Timeout-Ms over the maximum", header: []string{"X-Timeout-Ms", "60000"}, timeout: 10 * time.Second, maxTime: 5 * time.Second},
+		{name: "X-Timeout-Ms not a number", header: []string{"X-Timeout-Ms", "soon"}, timeout: 5 * time.Second, maxTime: 4500 * time.Millisecond},
+		{name: "X-Timeout-Ms zero", header: []string{"X-Timeout-Ms", "0"}, timeout: 5 * time.Second, maxTime: 4500 * time.Millisecond},
+		{name: "X-Request-Deadline", header: []string{"X-Request-Deadline", in(3 * time.Second)}, timeout: 3 * time.Second, maxTime: 2700 * time.Millisecond},
+		{name: "X-Request-Deadline over the maximum", header: []string{"X-Request-Deadline", in(time.Hour)}, timeout: 10 * time.Second, maxTime: 5 * time.Second},
+		{
+			name:    "X-Request-Deadline before X-Timeout-Ms",
+			header:  []string{"X-Request-Deadline", in(3 * time.Second), "X-Timeout-Ms", "200"},
+			timeout: 3 * time.Second,
+			maxTime: 2700 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var timeout, maxTime time.Duration
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, func(app *fiber.App, h *Handler) {
+				app.Get("/budget", func(c *fiber.Ctx) error {
+					ctx, cancel := h.queryContext(c)
+					defer cancel()
+					deadline, _ := ctx.Deadline()
+					timeout, maxTime = time.Until(deadline), h.maxTime(c)
+					return nil
+				})
+			})
+			do(t, app, fiber.MethodGet, "/budget", "", tt.header...)
+			// RFC3339 deadlines are whole seconds, so allow for the one the
+			// test starts in.
+			const slack = time.Second
+			if timeout > tt.timeout || timeout < tt.timeout-slack {
+				t.Errorf("query timeout = %s, want %s", timeout, tt.timeout)
+			}
+			if maxTime > tt.maxTime || maxTime < tt.maxTime-slack {
+				t.Errorf("maxTime = %s, want %s", maxTime, tt.maxTime)
+			}
+		})
+	}
+}
+
+// TestQueryContextFollowsRequest checks that cancelling the request's
+// context, as a client going away does, cancels the Find it is running.
+func TestQueryContextFollowsRequest(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}}
+	app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+		app.Use(func(c *fiber.Ctx) error {
+			ctx, cancel := context.WithCancel(c.UserContext())
+			cancel()
+			c.SetUserContext(ctx)
+			return c.Next()
+		})
+		listingRoutes(app, h)
+	})
+	resp, body := do(t, app, fiber.MethodGet, "/products?status=ACTIVE", "")
+	if resp.StatusCode != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d: %s", resp.StatusCode, statusClientClosedRequest, body)
+	}
+	if len(callsOf(repo, "Find")) == 0 {
+		t.Error("the listing ran no Find")
+	}
+}
+
+func TestCheckDeadline(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}}
+	app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+		app.Use(h.CheckDeadline)
+		listingRoutes(app, h)
+	})
+
+	resp, body := do(t, app, fiber.MethodGet, "/products", "", "X-Request-Deadline", "2000-01-01T00:00:00Z")
+	if resp.StatusCode != http.StatusGatewayTimeout || errorCode(body) != "DEADLINE_EXCEEDED" {
+		t.Errorf("past deadline: %d %s, want 504 DEADLINE_EXCEEDED", resp.StatusCode, body)
+	}
+	if calls := repo.Calls(); len(calls) != 0 {
+		t.Errorf("a request past its deadline queried %+v", calls)
+	}
+
+	resp, body = do(t, app, fiber.MethodGet, "/products", "", "X-Timeout-Ms", "2000")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("within its budget: %d %s", resp.StatusCode, body)
+	}
+}
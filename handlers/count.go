@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// countCache holds the unfiltered product count of each tenant for a short
+// while, so first pages do not recount the whole collection. The write
+// handlers drop a tenant's entry; writes made elsewhere are picked up when
+// the entry expires.
+type countCache struct {
+	mu      sync.Mutex
+	entries map[string]countEntry
+}
+
+type countEntry struct {
+	n       int64
+	expires time.Time
+}
+
+func (cc *countCache) get(tenant string, now time.Time) (int64, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	e, ok := cc.entries[tenant]
+	if !ok || now.After(e.expires) {
+		return 0, false
+	}
+	return e.n, true
+}
+
+func (cc *countCache) set(tenant string, n int64, expires time.Time) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.entries == nil {
+		cc.entries = map[string]countEntry{}
+	}
+	cc.entries[tenant] = countEntry{n: n, expires: expires}
+}
+
+func (cc *countCache) invalidate(tenant string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.entries, tenant)
+}
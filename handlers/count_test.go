@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCountCache(t *testing.T) {
+	var cc countCache
+	if _, ok := cc.get("th", testNow); ok {
+		t.Fatal("an empty cache had a count")
+	}
+	cc.set("th", 7, testNow.Add(time.Minute))
+	cc.set("sg", 3, testNow.Add(time.Minute))
+	if n, ok := cc.get("th", testNow.Add(time.Minute)); !ok || n != 7 {
+		t.Errorf("get before expiry = %d, %t, want 7", n, ok)
+	}
+	if _, ok := cc.get("th", testNow.Add(time.Minute+time.Nanosecond)); ok {
+		t.Error("an expired count was served")
+	}
+	cc.invalidate("th")
+	if _, ok := cc.get("th", testNow); ok {
+		t.Error("an invalidated count was served")
+	}
+	if n, ok := cc.get("sg", testNow); !ok || n != 3 {
+		t.Errorf("invalidating th dropped sg: %d, %t", n, ok)
+	}
+}
+
+// countAggregations are the $count aggregations of an aggregation listing,
+// as opposed to its page query.
+func countAggregations(repo *mocks.ProductRepository) int {
+	n := 0
+	for _, call := range callsOf(repo, "Aggregate") {
+		pipeline := call.Filter.(mongo.Pipeline)
+		if last := pipeline[len(pipeline)-1]; last[0].Key == "$count" {
+			n++
+		}
+	}
+	return n
+}
+
+// TestGetProductsCountCacheInvalidation checks that the cached unfiltered
+// count of the aggregation path is reused until a write drops it, and that
+// filtered listings always count exactly.
+func TestGetProductsCountCacheInvalidation(t *testing.T) {
+	cfg := testConfig()
+	cfg.Mongo.ListAggregation = true
+	// Every aggregation answers with this document, which the count reads
+	// and the page decodes as an empty row.
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{"n": 3}}}
+	app := newTestApp(cfg, repo, func(app *fiber.App, h *Handler) {
+		listingRoutes(app, h)
+		app.Post("/groups", h.CreateGroup)
+		app.Post("/admin/cache/flush", h.FlushCache)
+	})
+	list := func(target string, n int64, exact bool, counts int) {
+		t.Helper()
+		resp, body := do(t, app, fiber.MethodGet, target, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, resp.StatusCode, body)
+		}
+		var page productPage
+		decode(t, body, &page)
+		if page.TotalCount != n || page.TotalCountExact != exact {
+			t.Errorf("GET %s: totalCount = %d exact %t, want %d exact %t", target, page.TotalCount, page.TotalCountExact, n, exact)
+		}
+		if got := countAggregations(repo); got != counts {
+			t.Errorf("GET %s: %d count aggregations so far, want %d", target, got, counts)
+		}
+	}
+
+	list("/products", 3, true, 1)
+	repo.AggregateDocs = []interface{}{bson.M{"n": 4}}
+	list("/products", 3, false, 1)
+	list("/products?status=ACTIVE", 4, true, 2)
+
+	resp, body := do(t, app, fiber.MethodPost, "/groups", `{"key":"NEW-GROUP","name":"New group"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a group: %d %s", resp.StatusCode, body)
+	}
+	list("/products", 4, true, 3)
+
+	repo.AggregateDocs = []interface{}{bson.M{"n": 5}}
+	list("/products", 4, false, 3)
+	if resp, body := do(t, app, fiber.MethodPost, "/admin/cache/flush", ""); resp.StatusCode >= 300 {
+		t.Fatalf("flushing the cache: %d %s", resp.StatusCode, body)
+	}
+	list("/products", 5, true, 4)
+}
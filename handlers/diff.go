@@ -0,0 +1,84 @@
+package handlers
+
+import "sort"
+
+// FieldChange is one field whose value differs between two products.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// BrokerChanges is the difference between two products' broker sets, by
+// broker key.
+type BrokerChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// ProductDiff is what changes going from one product to another.
+type ProductDiff struct {
+	Fields  []FieldChange  `json:"fields"`
+	Brokers *BrokerChanges `json:"brokers,omitempty"`
+}
+
+// Empty reports whether the two products were the same.
+func (d ProductDiff) Empty() bool {
+	return len(d.Fields) == 0 && d.Brokers == nil
+}
+
+// diffFields are the product fields compared by diffProducts, in output
+// order. Ids and the audit timestamps always differ and are left out.
+var diffFields = []struct {
+	name string
+	get  func(Product) string
+}{
+	{"code", func(p Product) string { return p.Code }},
+	{"productName", func(p Product) string { return p.ProductName }},
+	{"productGroup.key", func(p Product) string { return p.ProductGroup.Key }},
+	{"productGroup.name", func(p Product) string { return p.ProductGroup.Name }},
+	{"productType.key", func(p Product) string { return p.ProductType.Key }},
+	{"productType.name", func(p Product) string { return p.ProductType.Name }},
+	{"insurer._id", func(p Product) string { return p.Insurer.ID }},
+	{"insurer.insurerCode", func(p Product) string { return p.Insurer.InsurerCode }},
+	{"insurer.insurerName", func(p Product) string { return p.Insurer.InsurerName }},
+	{"status", func(p Product) string { return string(p.Status) }},
+}
+
+// diffProducts compares from with to. Brokers are compared as a set of
+// keys; a broker whose channel name alone changed is not a difference.
+func diffProducts(from, to Product) ProductDiff {
+	diff := ProductDiff{Fields: []FieldChange{}}
+	for _, f := range diffFields {
+		if a, b := f.get(from), f.get(to); a != b {
+			diff.Fields = append(diff.Fields, FieldChange{Field: f.name, From: a, To: b})
+		}
+	}
+
+	before, after := brokerKeys(from), brokerKeys(to)
+	changes := BrokerChanges{Added: []string{}, Removed: []string{}}
+	for key := range after {
+		if !before[key] {
+			changes.Added = append(changes.Added, key)
+		}
+	}
+	for key := range before {
+		if !after[key] {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+	if len(changes.Added) > 0 || len(changes.Removed) > 0 {
+		sort.Strings(changes.Added)
+		sort.Strings(changes.Removed)
+		diff.Brokers = &changes
+	}
+	return diff
+}
+
+func brokerKeys(p Product) map[string]bool {
+	keys := make(map[string]bool, len(p.Brokers))
+	for _, b := range p.Brokers {
+		keys[b.Key] = true
+	}
+	return keys
+}
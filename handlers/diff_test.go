@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiffProducts(t *testing.T) {
+	created := time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC)
+	base := Product{
+		ID:           "MT-001",
+		Code:         "MTR-VIR-0001",
+		ProductName:  "ประกันรถยนต์ ชั้น 1",
+		ProductGroup: ProductGroup{Key: "MTR", Name: "Motor"},
+		ProductType:  ProductType{Key: "MOTOR", Name: "ประกันรถยนต์"},
+		Insurer:      Insurer{ID: "INS-VIR", InsurerCode: "VIR", InsurerName: "Viriyah"},
+		Brokers:      []Broker{{Key: "B-ONLINE", ChannelName: "Online"}, {Key: "B-BRANCH", ChannelName: "Branch"}},
+		Status:       StatusActive,
+		CreatedAt:    &created,
+		CreatedBy:    "alice",
+	}
+	with := func(change func(*Product)) Product {
+		p := base
+		p.Brokers = append([]Broker(nil), base.Brokers...)
+		change(&p)
+		return p
+	}
+	later := created.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		to   Product
+		want ProductDiff
+	}{
+		{"the same product", base, ProductDiff{Fields: []FieldChange{}}},
+		{
+			name: "ids and audit fields",
+			to: with(func(p *Product) {
+				p.ID, p.CreatedAt, p.UpdatedAt, p.CreatedBy, p.UpdatedBy = "MT-002", &later, &later, "bob", "bob"
+			}),
+			want: ProductDiff{Fields: []FieldChange{}},
+		},
+		{
+			name: "fields in output order",
+			to: with(func(p *Product) {
+				p.Status, p.ProductName, p.Insurer.InsurerName = StatusRetired, "ประกันรถยนต์ ชั้น 2", ""
+			}),
+			want: ProductDiff{Fields: []FieldChange{
+				{Field: "productName", From: "ประกันรถยนต์ ชั้น 1", To: "ประกันรถยนต์ ชั้น 2"},
+				{Field: "insurer.insurerName", From: "Viriyah", To: ""},
+				{Field: "status", From: "ACTIVE", To: "RETIRED"},
+			}},
+		},
+		{
+			name: "every compared field",
+			to: Product{
+				Code:         "HP-AXA-0001",
+				ProductName:  "Health",
+				ProductGroup: ProductGroup{Key: "HP", Name: "Health Plus"},
+				ProductType:  ProductType{Key: "HEALTH", Name: "ประกันสุขภาพ"},
+				Insurer:      Insurer{ID: "INS-AXA", InsurerCode: "AXA", InsurerName: "AXA"},
+				Brokers:      base.Brokers,
+				Status:       StatusDraft,
+			},
+			want: ProductDiff{Fields: []FieldChange{
+				{Field: "code", From: "MTR-VIR-0001", To: "HP-AXA-0001"},
+				{Field: "productName", From: "ประกันรถยนต์ ชั้น 1", To: "Health"},
+				{Field: "productGroup.key", From: "MTR", To: "HP"},
+				{Field: "productGroup.name", From: "Motor", To: "Health Plus"},
+				{Field: "productType.key", From: "MOTOR", To: "HEALTH"},
+				{Field: "productType.name", From: "ประกันรถยนต์", To: "ประกันสุขภาพ"},
+				{Field: "insurer._id", From: "INS-VIR", To: "INS-AXA"},
+				{Field: "insurer.insurerCode", From: "VIR", To: "AXA"},
+				{Field: "insurer.insurerName", From: "Viriyah", To: "AXA"},
+				{Field: "status", From: "ACTIVE", To: "DRAFT"},
+			}},
+		},
+		{
+			name: "brokers added and removed, sorted",
+			to: with(func(p *Product) {
+				p.Brokers = []Broker{{Key: "B-ONLINE"}, {Key: "B-TELE"}, {Key: "B-AGENT"}}
+			}),
+			want: ProductDiff{Fields: []FieldChange{}, Brokers: &BrokerChanges{Added: []string{"B-AGENT", "B-TELE"}, Removed: []string{"B-BRANCH"}}},
+		},
+		{
+			name: "brokers only removed",
+			to:   with(func(p *Product) { p.Brokers = nil }),
+			want: ProductDiff{Fields: []FieldChange{}, Brokers: &BrokerChanges{Added: []string{}, Removed: []string{"B-BRANCH", "B-ONLINE"}}},
+		},
+		{
+			name: "brokers reordered, renamed and repeated",
+			to: with(func(p *Product) {
+				p.Brokers = []Broker{{Key: "B-BRANCH", ChannelName: "สาขา"}, {Key: "B-ONLINE"}, {Key: "B-ONLINE"}}
+			}),
+			want: ProductDiff{Fields: []FieldChange{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffProducts(base, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffProducts\n got %+v\nwant %+v", got, tt.want)
+			}
+			if got.Empty() != (len(tt.want.Fields) == 0 && tt.want.Brokers == nil) {
+				t.Errorf("Empty() = %t", got.Empty())
+			}
+			// Going back is the mirror image.
+			back := diffProducts(tt.to, base)
+			if len(back.Fields) != len(got.Fields) || (back.Brokers == nil) != (got.Brokers == nil) {
+				t.Errorf("diffProducts back = %+v", back)
+			}
+			for i, f := range back.Fields {
+				if f.From != got.Fields[i].To || f.To != got.Fields[i].From {
+					t.Errorf("back field %d = %+v, want the reverse of %+v", i, f, got.Fields[i])
+				}
+			}
+			if back.Brokers != nil && !reflect.DeepEqual(*back.Brokers, BrokerChanges{Added: got.Brokers.Removed, Removed: got.Brokers.Added}) {
+				t.Errorf("back brokers = %+v, want the reverse of %+v", *back.Brokers, *got.Brokers)
+			}
+		})
+	}
+
+	// The encoding lists no differences as [] and leaves out unchanged
+	// brokers.
+	out, err := json.Marshal(diffProducts(base, base))
+	if err != nil || string(out) != `{"fields":[]}` {
+		t.Errorf("encoded empty diff = %s, %v", out, err)
+	}
+}
+
+func TestCompareProducts(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}}
+	app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+		app.Get("/products/compare", h.CompareProducts)
+	})
+
+	resp, body := do(t, app, fiber.MethodGet, "/products/compare?ids=NOPE,HP-002,%20HP-002%20,HP-001", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	var got struct {
+		Data []struct {
+			ID      string   `json:"id"`
+			Found   bool     `json:"found"`
+			Product *Product `json:"product"`
+		} `json:"data"`
+		Differences []comparison `json:"differences"`
+	}
+	decode(t, body, &got)
+	if len(got.Data) != 3 {
+		t.Fatalf("data = %+v, want NOPE, HP-002 and HP-001 once each", got.Data)
+	}
+	for i, want := range []struct {
+		id    string
+		found bool
+	}{{"NOPE", false}, {"HP-002", true}, {"HP-001", true}} {
+		item := got.Data[i]
+		if item.ID != want.id || item.Found != want.found || (item.Product != nil) != want.found {
+			t.Errorf("data[%d] = %+v, want %s found %t", i, item, want.id, want.found)
+		}
+	}
+	want := []comparison{{
+		ID:      "HP-001",
+		Against: "HP-002",
+		ProductDiff: ProductDiff{
+			Fields: []FieldChange{
+				{Field: "productName", From: "Health Plus Family", To: "ประกันสุขภาพ เหมาจ่าย"},
+				{Field: "insurer._id", From: "INS-AXA", To: "INS-TIP"},
+				{Field: "insurer.insurerCode", From: "AXA", To: "TIP"},
+				{Field: "insurer.insurerName", From: "AXA Insurance", To: "ทิพยประกันภัย"},
+				{Field: "status", From: "DRAFT", To: "ACTIVE"},
+			},
+			Brokers: &BrokerChanges{Added: []string{}, Removed: []string{"BROKER-ONLINE"}},
+		},
+	}}
+	if !reflect.DeepEqual(got.Differences, want) {
+		t.Errorf("differences\n got %+v\nwant %+v", got.Differences, want)
+	}
+	if find := callsOf(repo, "Find"); len(find) != 1 || len(find[0].Filter.(bson.M)["$or"].(bson.A)) != 3 {
+		t.Errorf("Finds = %+v, want one for the three distinct ids", find)
+	}
+
+	for _, ids := range []string{"", "HP-001", "HP-001,HP-001", "A,B,C,D,E,F"} {
+		resp, body := do(t, app, fiber.MethodGet, "/products/compare?ids="+ids, "")
+		if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "INVALID_IDS" {
+			t.Errorf("ids=%s: %d %s, want 400 INVALID_IDS", ids, resp.StatusCode, body)
+		}
+	}
+}
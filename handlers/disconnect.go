@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// disconnectPoll is how often a running request checks whether its client
+// has hung up.
+const disconnectPoll = 100 * time.Millisecond
+
+// watchDisconnect calls cancel once the client closes conn, until the
+// returned stop is called. fasthttp does not read a connection while its
+// handler runs, so a hang-up is only noticed by peeking at the socket;
+// connections that cannot be peeked at, like app.Test's, are not watched.
+func watchDisconnect(conn net.Conn, cancel context.CancelFunc) (stop func()) {
+	if _, ok := peerClosed(conn); !ok {
+		return func() {}
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(disconnectPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if closed, _ := peerClosed(conn); closed {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		// The connection goes back to fasthttp for the next request.
+		<-exited
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package handlers
+
+import "net"
+
+// peerClosed cannot peek at sockets on this platform, so hang-ups go
+// unnoticed until the response is written.
+func peerClosed(net.Conn) (closed, ok bool) {
+	return false, false
+}
@@ -0,0 +1,66 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestClientDisconnectCancelsQuery hangs up on a listing whose query is
+// stuck in the database, over a real connection.
+func TestClientDisconnectCancelsQuery(t *testing.T) {
+	repo := &mocks.ProductRepository{Block: make(chan struct{})}
+	statuses := make(chan int, 1)
+	app := newApp(t, repo, func(c *fiber.Ctx) error {
+		err := c.Next()
+		statuses <- c.Response().StatusCode()
+		return err
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /products?status=ACTIVE HTTP/1.1\r\nHost: test\r\nX-API-Key: %s\r\n\r\n", apiKey)
+	for start := time.Now(); len(repo.Calls()) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the listing never queried")
+		}
+	}
+	conn.Close()
+
+	// The query timeout is 5s; anything sooner is the hang-up.
+	select {
+	case status := <-statuses:
+		if status != 499 {
+			t.Errorf("status = %d, want 499", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("hanging up did not cancel the query")
+	}
+}
+
+// TestRequestDeadlineEndsQuery runs a listing against a hung database with
+// a caller deadline through the route stack.
+func TestRequestDeadlineEndsQuery(t *testing.T) {
+	repo := &mocks.ProductRepository{Block: make(chan struct{})}
+	start := time.Now()
+	resp, body := request(t, newApp(t, repo), fiber.MethodGet, "/products?status=ACTIVE", "X-Timeout-Ms", "200")
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("%d %s, want 504", resp.StatusCode, body)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("the request took %s with a 200ms deadline", took)
+	}
+}
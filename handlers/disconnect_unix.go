@@ -0,0 +1,33 @@
+//go:build linux || darwin
+
+package handlers
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// peerClosed peeks at conn's socket without consuming anything. Pending
+// data, such as a pipelined request, reads as still open. ok is false when
+// conn has no socket to peek at.
+func peerClosed(conn net.Conn) (closed, ok bool) {
+	if tlsConn, isTLS := conn.(interface{ NetConn() net.Conn }); isTLS {
+		conn = tlsConn.NetConn()
+	}
+	sc, isSocket := conn.(syscall.Conn)
+	if !isSocket {
+		return false, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	err = raw.Read(func(fd uintptr) bool {
+		var buf [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = n == 0 && err == nil || errors.Is(err, syscall.ECONNRESET)
+		return true
+	})
+	return closed || err != nil, true
+}
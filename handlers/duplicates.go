@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateOf matches a productList entry with in's insurer and name, other
+// than the product id when it is set.
+func duplicateOf(in *ProductInput, id string) bson.M {
+	dup := bson.M{
+		"insurer.insurerCodeLower": strings.ToLower(in.Insurer.InsurerCode),
+		"productNameKey":           database.NameKey(in.ProductName),
+	}
+	if id != "" {
+		dup["$nor"] = bson.A{database.ItemMatch(id, "")}
+	}
+	return dup
+}
+
+// uniqueProduct is target restricted to groups without a duplicate of in.
+// Being part of the write's own filter, the check cannot race with another
+// write: a duplicate makes the write match nothing.
+func uniqueProduct(target bson.M, in *ProductInput, id string) bson.M {
+	return bson.M{"$and": bson.A{target, bson.M{"productList": bson.M{"$not": bson.M{"$elemMatch": duplicateOf(in, id)}}}}}
+}
+
+// duplicateProduct answers a write restricted by uniqueProduct that matched
+// nothing. When target holds a duplicate it writes the 409 and ok is true;
+// otherwise the write failed for another reason and nothing is written.
+func (h *Handler) duplicateProduct(c *fiber.Ctx, ctx context.Context, target bson.M, in *ProductInput, id string) (ok bool, err error) {
+	var group struct {
+		ProductList database.ProductList `bson:"productList"`
+	}
+	err = database.Breaker.Do(ctx, func() error {
+		return h.repo(c).FindOne(ctx,
+			bson.M{"$and": bson.A{target, bson.M{"productList": bson.M{"$elemMatch": duplicateOf(in, id)}}}},
+			options.FindOne().SetProjection(bson.M{"productList": bson.M{"$elemMatch": duplicateOf(in, id)}}),
+		).Decode(&group)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return true, queryError(c, "looking up the duplicate product", err)
+	}
+	details := fiber.Map{}
+	if len(group.ProductList) > 0 {
+		details["id"] = group.ProductList[0].ProductID()
+	}
+	return true, apierror.SendDetails(c, fiber.StatusConflict, "DUPLICATE_PRODUCT",
+		"group "+in.ProductGroup.Key+" already has a product named "+in.ProductName+" from insurer "+in.Insurer.InsurerCode, details)
+}
+
+type duplicateSet struct {
+	Key struct {
+		GroupKey    string `json:"groupKey" bson:"groupKey"`
+		InsurerCode string `json:"insurerCode" bson:"insurerCode"`
+		NameKey     string `json:"nameKey" bson:"nameKey"`
+	} `json:"key" bson:"_id"`
+	Count    int64 `json:"count" bson:"count"`
+	Products []struct {
+		ID          string `json:"id" bson:"id"`
+		Code        string `json:"code,omitempty" bson:"code"`
+		ProductName string `json:"productName" bson:"productName"`
+		InsurerCode string `json:"insurerCode" bson:"insurerCode"`
+	} `json:"products" bson:"products"`
+}
+
+// GetDuplicates lists the sets of products that share group, insurer and
+// name, for cleaning up data written before uniqueness was enforced.
+func (h *Handler) GetDuplicates(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	opts := options.Aggregate().
+		SetAllowDiskUse(h.cfg.Mongo.AnalyticsAllowDiskUse).
+		SetMaxTime(h.maxTime(c))
+	sets := []duplicateSet{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, database.DuplicatesPipeline(), opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &sets)
+	})
+	if err != nil {
+		return queryError(c, "listing duplicate products", err)
+	}
+	return c.JSON(fiber.Map{"totalCount": len(sets), "data": sets})
+}
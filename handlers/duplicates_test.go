@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateStore is a repository holding healthGroup whose writes match
+// nothing, as when their uniqueness guard fails. Its duplicate lookups
+// find HP-002, AXA's "Health Plus Family".
+type duplicateStore struct {
+	*mocks.ProductRepository
+}
+
+func (r duplicateStore) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	r.ProductRepository.FindOne(ctx, filter, opts...)
+	for _, id := range []string{"HP-001", "HP-002"} {
+		if reflect.DeepEqual(filter, database.ItemFilter(id)) {
+			return mongo.NewSingleResultFromDocument(healthGroup(), nil, nil)
+		}
+	}
+	if f := fmt.Sprint(filter); strings.Contains(f, "productNameKey:health plus family") && strings.Contains(f, "insurerCodeLower:axa") {
+		dup := healthGroup()["productList"].(bson.A)[0]
+		return mongo.NewSingleResultFromDocument(bson.M{"productList": bson.A{dup}}, nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
+func writeRoutes(app *fiber.App, h *Handler) {
+	app.Post("/products", h.CreateProduct)
+	app.Put("/products/:id", h.UpdateProduct)
+}
+
+func TestProductWriteDuplicates(t *testing.T) {
+	body := func(name, insurer string) string {
+		return `{"productName": "` + name + `", "productGroup": {"key": "HEALTH-PLUS"},
+			"insurer": {"insurerCode": "` + insurer + `"}, "status": "ACTIVE"}`
+	}
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+		code                       string
+	}{
+		{"create", fiber.MethodPost, "/products", body("Health Plus Family", "AXA"), http.StatusConflict, "DUPLICATE_PRODUCT"},
+		{"create case and spaces", fiber.MethodPost, "/products", body("  health   PLUS family ", "AXA"), http.StatusConflict, "DUPLICATE_PRODUCT"},
+		{"create other insurer", fiber.MethodPost, "/products", body("Health Plus Family", "TIP"), http.StatusNotFound, "PRODUCT_GROUP_NOT_FOUND"},
+		{"update onto another", fiber.MethodPut, "/products/HP-001", body("HEALTH PLUS FAMILY", "AXA"), http.StatusConflict, "DUPLICATE_PRODUCT"},
+		{"update other name", fiber.MethodPut, "/products/HP-001", body("Health Plus Senior", "AXA"), http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mocks.ProductRepository{}
+			app := newTestApp(testConfig(), duplicateStore{repo}, writeRoutes)
+			resp, out := do(t, app, tt.method, tt.target, tt.body)
+			if resp.StatusCode != tt.want || errorCode(out) != tt.code {
+				t.Fatalf("%d %s, want %d %s", resp.StatusCode, out, tt.want, tt.code)
+			}
+			if tt.code == "DUPLICATE_PRODUCT" {
+				var env struct {
+					Error struct {
+						Details struct {
+							ID string `json:"id"`
+						} `json:"details"`
+					} `json:"error"`
+				}
+				if err := json.Unmarshal(out, &env); err != nil {
+					t.Fatal(err)
+				}
+				if env.Error.Details.ID != "HP-002" {
+					t.Errorf("details = %+v, want the duplicate HP-002", env.Error.Details)
+				}
+			}
+
+			// The uniqueness check is in the write's own filter, not a read
+			// before it.
+			var write mocks.Call
+			for _, call := range repo.Calls() {
+				if call.Method == "PushProduct" || call.Method == "SetProduct" {
+					write = call
+				}
+			}
+			if write.Method == "" {
+				t.Fatalf("no write in %+v", repo.Calls())
+			}
+			if f := fmt.Sprint(write.Filter); !strings.Contains(f, "$not:map[$elemMatch:") || !strings.Contains(f, "productNameKey:") {
+				t.Errorf("write filter %s lacks the uniqueness guard", f)
+			}
+		})
+	}
+}
+
+func TestDuplicateOf(t *testing.T) {
+	in := &ProductInput{ProductName: " Health\tPlus  FAMILY", Insurer: InsurerInput{InsurerCode: "AXA"}}
+	want := bson.M{"insurer.insurerCodeLower": "axa", "productNameKey": "health plus family"}
+	if got := duplicateOf(in, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("duplicateOf = %v, want %v", got, want)
+	}
+	// An update does not conflict with the product itself.
+	want["$nor"] = bson.A{database.ItemMatch("HP-002", "")}
+	if got := duplicateOf(in, "HP-002"); !reflect.DeepEqual(got, want) {
+		t.Errorf("duplicateOf HP-002 = %v, want %v", got, want)
+	}
+}
+
+func TestGetDuplicates(t *testing.T) {
+	set := bson.M{
+		"_id":   bson.M{"groupKey": "HEALTH-PLUS", "insurerCode": "axa", "nameKey": "health plus family"},
+		"count": 2,
+		"products": bson.A{
+			bson.M{"id": "HP-002", "productName": "Health Plus Family", "insurerCode": "AXA"},
+			bson.M{"id": "HP-009", "code": "HEALTH-PLUS-AXA-0009", "productName": "health plus  family", "insurerCode": "AXA"},
+		},
+	}
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{set}}
+	app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+		app.Get("/admin/products/duplicates", h.GetDuplicates)
+	})
+	resp, out := do(t, app, fiber.MethodGet, "/admin/products/duplicates", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, out)
+	}
+	want := `{"data":[{"key":{"groupKey":"HEALTH-PLUS","insurerCode":"axa","nameKey":"health plus family"},"count":2,` +
+		`"products":[{"id":"HP-002","productName":"Health Plus Family","insurerCode":"AXA"},` +
+		`{"id":"HP-009","code":"HEALTH-PLUS-AXA-0009","productName":"health plus  family","insurerCode":"AXA"}]}],"totalCount":1}`
+	if string(out) != want {
+		t.Errorf("body = %s\nwant %s", out, want)
+	}
+	calls := callsOf(repo, "Aggregate")
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0].Filter, database.DuplicatesPipeline()) {
+		t.Errorf("aggregations = %+v, want the duplicates pipeline", calls)
+	}
+
+	// No duplicates is an empty list, not null.
+	repo = &mocks.ProductRepository{}
+	app = newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+		app.Get("/admin/products/duplicates", h.GetDuplicates)
+	})
+	if _, out := do(t, app, fiber.MethodGet, "/admin/products/duplicates", ""); string(out) != `{"data":[],"totalCount":0}` {
+		t.Errorf("empty report = %s", out)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/breaker"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unavailable answers a request rejected by the open database circuit
+// breaker.
+func unavailable(c *fiber.Ctx) error {
+	retry := int(math.Ceil(database.Breaker.RetryAfter().Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retry))
+	return apierror.Send(c, fiber.StatusServiceUnavailable, "DATABASE_UNAVAILABLE", "the database is unavailable; retry later")
+}
+
+var (
+	maxTimeExpired = metrics.NewCounter("mongo_max_time_expired")
+	mongoErrors    = metrics.NewCounter("mongo_errors")
+)
+
+// statusClientClosedRequest is nginx's status for a request the client gave
+// up on. Nobody reads it; it keeps such requests out of the 5xx figures.
+const statusClientClosedRequest = 499
+
+// queryError answers a failed database operation.
+func queryError(c *fiber.Ctx, action string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		slog.Debug("client went away", "action", action, "path", c.Path(), "error", err)
+		return c.SendStatus(statusClientClosedRequest)
+	}
+	if errors.Is(err, breaker.ErrOpen) {
+		return unavailable(c)
+	}
+	if database.IsMaxTimeExpired(err) {
+		maxTimeExpired.Inc(c.Route().Path)
+		return apierror.Send(c, fiber.StatusGatewayTimeout, "QUERY_TIMEOUT", "the query took too long; narrow the search")
+	}
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+		return apierror.Send(c, fiber.StatusGatewayTimeout, "DEADLINE_EXCEEDED", "the request could not be completed within its deadline")
+	}
+	mongoErrors.Inc(c.Route().Path)
+	return apierror.Internal(c, action, err)
+}
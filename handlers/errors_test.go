@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mongoErrorCount is the mongo_errors counter of the listing route.
+func mongoErrorCount() int64 {
+	v, _ := expvar.Get("mongo_errors").(*expvar.Map).Get("/products").(*expvar.Int)
+	if v == nil {
+		return 0
+	}
+	return v.Value()
+}
+
+// TestQueryErrorTimeoutsAndCancellation fails the listing's queries the way
+// an expired context, a driver timeout and a client that went away do.
+func TestQueryErrorTimeoutsAndCancellation(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		// logged is whether the failure reaches the server log, and counts
+		// as a Mongo error.
+		logged bool
+	}{
+		{"context deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, "DEADLINE_EXCEEDED", false},
+		{"wrapped context deadline", fmt.Errorf("finding products: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "DEADLINE_EXCEEDED", false},
+		{"driver network timeout", mongo.CommandError{Message: "socket timed out at 10.0.0.7:27017", Labels: []string{"NetworkTimeoutError"}}, http.StatusGatewayTimeout, "DEADLINE_EXCEEDED", false},
+		{"server time limit", mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}, http.StatusGatewayTimeout, "QUERY_TIMEOUT", false},
+		{"client went away", context.Canceled, statusClientClosedRequest, "", false},
+		{"wrapped client went away", fmt.Errorf("finding products: %w", context.Canceled), statusClientClosedRequest, "", false},
+		{"other failure", errors.New("dial tcp 10.0.0.7:27017: connection refused"), http.StatusInternalServerError, "INTERNAL_ERROR", true},
+	}
+	defer log.SetOutput(io.Discard)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			before := mongoErrorCount()
+
+			app := newTestApp(testConfig(), &mocks.ProductRepository{Err: tt.err}, listingRoutes)
+			resp, body := do(t, app, fiber.MethodGet, "/products?status=ACTIVE", "")
+			if resp.StatusCode != tt.status || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %q", resp.StatusCode, body, tt.status, tt.code)
+			}
+			if strings.Contains(string(body), "10.0.0.7") || strings.Contains(string(body), "context") {
+				t.Errorf("the response leaks the error: %s", body)
+			}
+			if logged := logs.Len() > 0; logged != tt.logged {
+				t.Errorf("logged %t, want %t: %s", logged, tt.logged, logs.String())
+			}
+			if counted := mongoErrorCount() > before; counted != tt.logged {
+				t.Errorf("counted as a Mongo error: %t, want %t", counted, tt.logged)
+			}
+		})
+	}
+}
+
+// TestBreakerCountsServerTimeouts lists against a database that never
+// answers, within a caller's budget and then the server's own query
+// timeout: only the latter opens the breaker.
+func TestBreakerCountsServerTimeouts(t *testing.T) {
+	database.ConfigureBreaker(config.BreakerConfig{FailureThreshold: 3, Cooldown: time.Minute, SuccessThreshold: 1})
+	t.Cleanup(func() {
+		database.ConfigureBreaker(config.BreakerConfig{FailureThreshold: math.MaxInt32, Cooldown: time.Second, SuccessThreshold: 1})
+	})
+	cfg := testConfig()
+	cfg.HTTP.QueryTimeout = 50 * time.Millisecond
+	app := newTestApp(cfg, &mocks.ProductRepository{Block: make(chan struct{})}, listingRoutes)
+
+	for i := 0; i < 5; i++ {
+		resp, body := do(t, app, fiber.MethodGet, "/products", "", "X-Timeout-Ms", "20")
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("past the caller's budget: %d %s, want 504", resp.StatusCode, body)
+		}
+	}
+	// A listing runs more than one query, so it may open before the third.
+	for i := 0; i < 3; i++ {
+		do(t, app, fiber.MethodGet, "/products", "")
+	}
+	resp, body := do(t, app, fiber.MethodGet, "/products", "")
+	if resp.StatusCode != http.StatusServiceUnavailable || errorCode(body) != "DATABASE_UNAVAILABLE" {
+		t.Errorf("after repeated server timeouts: %d %s, want 503 DATABASE_UNAVAILABLE", resp.StatusCode, body)
+	}
+}
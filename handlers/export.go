@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var exportColumns = []string{
+	"id", "productName", "groupKey", "groupName", "typeKey", "typeName",
+	"insurerId", "insurerCode", "insurerName", "brokers", "status",
+}
+
+// ExportProducts streams every product matching param and status as NDJSON
+// (the default) or CSV, selected by ?format=.
+//
+// The status line is sent before the first product, so a failure midway
+// ends the body with a marker instead: an NDJSON line holding only an
+// "error" object, or a CSV record of three fields starting with "#error",
+// which CSV readers expecting the header's field count reject.
+func (h *Handler) ExportProducts(c *fiber.Ctx) error {
+	format := query(c, "format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_FORMAT", "format must be ndjson or csv")
+	}
+
+	params, ok, err := h.listParams(c)
+	if !ok {
+		return err
+	}
+	filter, err := buildProductFilter(params)
+	if err != nil {
+		return filterFailed(c, err)
+	}
+	pipeline := append(database.FlattenStages(filter),
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "key", Value: 1}, {Key: "productList.id", Value: 1}}}},
+		bson.D{{Key: "$project", Value: database.ProductProjection}},
+	)
+
+	// The body is written after this handler returns, when c and its user
+	// context are no longer valid, so the export gets its own deadline.
+	ctx, cancel := context.WithTimeout(database.WithEndpoint(context.Background(), c.Route().Path), h.cfg.HTTP.ExportTimeout)
+	var cursor *mongo.Cursor
+	err = database.Breaker.Do(ctx, func() error {
+		var err error
+		cursor, err = h.repo(c).Aggregate(ctx, pipeline,
+			options.Aggregate().
+				SetBatchSize(int32(h.cfg.Mongo.ExportBatchSize)).
+				SetMaxTime(h.cfg.Mongo.ExportMaxTime))
+		return err
+	})
+	if err != nil {
+		cancel()
+		return queryError(c, "exporting products", err)
+	}
+
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="products.`+format+`"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer cursor.Close(ctx)
+		if err := writeExport(ctx, cursor, w, format); err != nil {
+			h.logger.Error("exporting products", "error", err)
+		}
+	})
+	return nil
+}
+
+func writeExport(ctx context.Context, cursor *mongo.Cursor, w *bufio.Writer, format string) error {
+	var (
+		enc  = json.NewEncoder(w)
+		rows = csv.NewWriter(w)
+	)
+	err := exportRows(ctx, cursor, w, enc, rows, format)
+	if err != nil {
+		const code, message = "INCOMPLETE_RESPONSE", "the export was truncated by an error"
+		if format == "csv" {
+			rows.Write([]string{"#error", code, message})
+			rows.Flush()
+		} else {
+			enc.Encode(fiber.Map{"error": fiber.Map{"code": code, "message": message}})
+		}
+	}
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func exportRows(ctx context.Context, cursor *mongo.Cursor, w *bufio.Writer, enc *json.Encoder, rows *csv.Writer, format string) error {
+	if format == "csv" {
+		if err := rows.Write(exportColumns); err != nil {
+			return err
+		}
+	}
+	for cursor.Next(ctx) {
+		var p Product
+		if err := cursor.Decode(&p); err != nil {
+			return err
+		}
+		var err error
+		if format == "csv" {
+			err = rows.Write(exportRow(p))
+		} else {
+			err = enc.Encode(p.withBrokers())
+		}
+		if err != nil {
+			return err
+		}
+		// Flush per batch so the client sees progress without a syscall
+		// per product.
+		if cursor.RemainingBatchLength() == 0 {
+			rows.Flush()
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	rows.Flush()
+	if err := rows.Error(); err != nil {
+		return err
+	}
+	return cursor.Err()
+}
+
+func exportRow(p Product) []string {
+	brokers := make([]string, len(p.Brokers))
+	for i, b := range p.Brokers {
+		brokers[i] = b.Key
+	}
+	return []string{
+		p.ID, p.ProductName, p.ProductGroup.Key, p.ProductGroup.Name,
+		p.ProductType.Key, p.ProductType.Name,
+		p.Insurer.ID, p.Insurer.InsurerCode, p.Insurer.InsurerName,
+		strings.Join(brokers, "|"), p.Status.String(),
+	}
+}
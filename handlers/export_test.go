@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func exportRoutes(app *fiber.App, h *Handler) {
+	app.Get("/products/export", h.ExportProducts)
+}
+
+// exportDocs are two products with an entry between them the cursor
+// cannot decode, failing the export midway as a lost connection would.
+func exportDocs(fail bool) []interface{} {
+	docs := []interface{}{
+		bson.M{"id": "HP-001", "productName": "Health Plus", "productGroup": bson.M{"key": "HEALTH-PLUS"}},
+		bson.M{"id": "HP-002", "productName": "Health Max", "productGroup": bson.M{"key": "HEALTH-PLUS"}},
+	}
+	if fail {
+		docs = []interface{}{docs[0], bson.M{"id": bson.A{"HP-X"}}, docs[1]}
+	}
+	return docs
+}
+
+func TestExportProductsNDJSON(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		repo := &mocks.ProductRepository{AggregateDocs: exportDocs(fail)}
+		app := newTestApp(testConfig(), repo, exportRoutes)
+		resp, body := do(t, app, fiber.MethodGet, "/products/export", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d: %s", resp.StatusCode, body)
+		}
+		var ids []string
+		var marker fiber.Map
+		for _, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+			var row map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("line %q is not JSON: %v", line, err)
+			}
+			if e, ok := row["error"].(map[string]interface{}); ok {
+				marker = e
+				continue
+			}
+			if marker != nil {
+				t.Errorf("line %q after the error marker", line)
+			}
+			ids = append(ids, row["id"].(string))
+		}
+
+		want := []string{"HP-001", "HP-002"}
+		if fail {
+			want = want[:1]
+			if marker == nil || marker["code"] != "INCOMPLETE_RESPONSE" {
+				t.Errorf("truncated export ends with %v, want an INCOMPLETE_RESPONSE marker: %s", marker, body)
+			}
+		} else if marker != nil {
+			t.Errorf("complete export has a marker: %s", body)
+		}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("fail %t: exported %v, want %v", fail, ids, want)
+		}
+	}
+}
+
+func TestExportProductsCSV(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: exportDocs(false)}
+	app := newTestApp(testConfig(), repo, exportRoutes)
+	_, body := do(t, app, fiber.MethodGet, "/products/export?format=csv", "")
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("complete export: %v", err)
+	}
+	if len(records) != 3 || !reflect.DeepEqual(records[0], exportColumns) || records[2][0] != "HP-002" {
+		t.Errorf("complete export = %q", records)
+	}
+
+	// A reader expecting the header's field count fails on the marker
+	// rather than taking the truncated rows for the whole export.
+	repo = &mocks.ProductRepository{AggregateDocs: exportDocs(true)}
+	app = newTestApp(testConfig(), repo, exportRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products/export?format=csv", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	records, err = csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if !errors.Is(err, csv.ErrFieldCount) {
+		t.Errorf("reading the truncated export = %v, want ErrFieldCount", err)
+	}
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	records, _ = r.ReadAll()
+	last := records[len(records)-1]
+	if len(records) != 3 || records[1][0] != "HP-001" || last[0] != "#error" || last[1] != "INCOMPLETE_RESPONSE" {
+		t.Errorf("truncated export = %q, want HP-001 and the marker", records)
+	}
+}
+
+func TestExportProductsFormat(t *testing.T) {
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(testConfig(), repo, exportRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products/export?format=xml", "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "INVALID_FORMAT" {
+		t.Errorf("%d %s, want 400 INVALID_FORMAT", resp.StatusCode, body)
+	}
+	if calls := repo.Calls(); len(calls) != 0 {
+		t.Errorf("an invalid format queried %+v", calls)
+	}
+}
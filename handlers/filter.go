@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// searchField is one path matched by the free-text param. Key-like fields
+// have a lowercase Shadow and are matched by an anchored prefix on it, which
+// an index can serve; the others keep case-insensitive contains semantics.
+// Name is how ?searchFields= refers to it and Values reads it off a mapped
+// product.
+type searchField struct {
+	Name   string
+	Path   string
+	Shadow string
+	Values func(p Product) []string
+}
+
+var searchFields = []searchField{
+	{Name: "productType", Path: "productType.key", Shadow: "productType.keyLower",
+		Values: func(p Product) []string { return []string{p.ProductType.Key} }},
+	{Name: "productGroup", Path: "key", Shadow: "keyLower",
+		Values: func(p Product) []string { return []string{p.ProductGroup.Key} }},
+	{Name: "productName", Path: "productList.productName",
+		Values: func(p Product) []string { return []string{p.ProductName} }},
+	{Name: "insurerCode", Path: "productList.insurer.insurerCode", Shadow: "productList.insurer.insurerCodeLower",
+		Values: func(p Product) []string { return []string{p.Insurer.InsurerCode} }},
+	{Name: "brokers", Path: "productList.brokers.key", Shadow: "productList.brokers.keyLower",
+		Values: func(p Product) []string {
+			keys := make([]string, len(p.Brokers))
+			for i, br := range p.Brokers {
+				keys[i] = br.Key
+			}
+			return keys
+		}},
+}
+
+// searchFieldNames are the names ?searchFields= accepts.
+func searchFieldNames() []string {
+	names := make([]string, len(searchFields))
+	for i, f := range searchFields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// parseSearchFields parses the comma-separated ?searchFields=. An empty
+// value selects all fields; ok is false when a name is unknown.
+func parseSearchFields(raw string) (fields []searchField, ok bool) {
+	if raw == "" {
+		return searchFields, true
+	}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		i := -1
+		for j, f := range searchFields {
+			if f.Name == name {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil, false
+		}
+		fields = append(fields, searchFields[i])
+	}
+	return fields, true
+}
+
+// FilterBuilder assembles the listing filter from request parameters. Next
+// to the Mongo filter it keeps an equivalent matcher for mapped products,
+// for code that flattens group documents in Go.
+type FilterBuilder struct {
+	filter   bson.M
+	matchers []itemMatcher
+}
+
+type itemMatcher func(p Product) bool
+
+func NewFilterBuilder() *FilterBuilder {
+	return &FilterBuilder{filter: bson.M{}}
+}
+
+// Search matches groups where any search field matches term. Words of
+// term prefixed with - are exclusions instead: a product any of whose
+// search fields matches one is left out, whatever the rest of term
+// matches. A term or exclusion with * or ? wildcards is a pattern that
+// must match a whole field. Only fields are searched. An empty term
+// matches everything.
+func (b *FilterBuilder) Search(term string, fields []searchField) *FilterBuilder {
+	positive, negative := searchTerms(database.Normalize(term))
+	if positive != "" {
+		contains, prefix := searchPatterns(positive)
+		var or []bson.M
+		for _, f := range fields {
+			if f.Shadow != "" {
+				or = append(or, bson.M{f.Shadow: bson.M{"$regex": prefix}})
+				continue
+			}
+			or = append(or, bson.M{f.Path: bson.M{"$regex": contains, "$options": "i"}})
+		}
+		b.filter["$or"] = or
+
+		b.matchers = append(b.matchers, searchMatcher(positive, fields))
+	}
+	for _, term := range negative {
+		contains, prefix := searchPatterns(term)
+		and, _ := b.filter["$and"].(bson.A)
+		var patterns []database.SearchPattern
+		for _, f := range fields {
+			path, ok := strings.CutPrefix(f.Shadow, "productList.")
+			switch {
+			case f.Shadow == "":
+				patterns = append(patterns, database.SearchPattern{
+					Path: strings.TrimPrefix(f.Path, "productList."), Regex: contains, Options: "i",
+				})
+			case ok:
+				patterns = append(patterns, database.SearchPattern{Path: path, Regex: prefix})
+			default:
+				// The group's fields are the same for all its products.
+				and = append(and, bson.M{f.Shadow: bson.M{"$not": bson.M{"$regex": prefix}}})
+			}
+		}
+		b.filter["$and"] = append(and, bson.M{"$expr": database.SearchExclusionExpr(patterns)})
+
+		matches := searchMatcher(term, fields)
+		b.matchers = append(b.matchers, func(p Product) bool { return !matches(p) })
+	}
+	return b
+}
+
+// searchTerms splits a search term into the text to match and the
+// exclusions. A word prefixed with - is an exclusion, as is a quoted
+// phrase prefixed with -; quotes elsewhere only group words, which are
+// matched as a phrase anyway. A lone - is ignored.
+func searchTerms(term string) (positive string, negative []string) {
+	var words []string
+	for rest := strings.TrimSpace(term); rest != ""; rest = strings.TrimSpace(rest) {
+		negated := rest[0] == '-'
+		if negated {
+			rest = rest[1:]
+		}
+		var word string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				word, rest = rest[1:], ""
+			} else {
+				word, rest = rest[1:end+1], rest[end+2:]
+			}
+			word = strings.Join(strings.Fields(word), " ")
+		} else if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+			word, rest = rest[:i], rest[i:]
+		} else {
+			word, rest = rest, ""
+		}
+		switch {
+		case word == "":
+		case negated:
+			negative = append(negative, word)
+		default:
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " "), negative
+}
+
+// noMark ends a literal search pattern so that it does not match the base
+// letters of a decomposed character, "cafe" in "cafe\u0301", which the Go
+// matchers compare composed. Thai vowels and tone marks are not combining
+// diacritics, so Thai prefixes still match.
+const noMark = `(?:$|[^\x{0300}-\x{036F}])`
+
+// searchPatterns are the regexes term is matched with: contains for
+// fields without a shadow, case-insensitively, and prefix for the
+// lowercase shadows. For a wildcard pattern both match whole values.
+func searchPatterns(term string) (contains, prefix string) {
+	literal, anchor := SanitizeString, noMark
+	if hasWildcards(term) {
+		literal, anchor = wildcardBody, "$"
+		contains = "^"
+	}
+	// Stored names may be in either normalization form; matching both
+	// spellings of the term finds them without rewriting the data.
+	if nfd := database.Decomposed(term); nfd != term {
+		contains += "(?:" + literal(term) + "|" + literal(nfd) + ")" + anchor
+	} else {
+		contains += literal(term) + anchor
+	}
+	return contains, "^" + literal(strings.ToLower(term)) + anchor
+}
+
+// searchMatcher matches mapped products any of whose search fields
+// among fields matches term, as the Mongo filter Search builds from it
+// does.
+func searchMatcher(term string, fields []searchField) itemMatcher {
+	folded := database.Fold(term)
+	prefix := func(s string) bool { return hasFoldedPrefix(s, folded) }
+	contains := func(s string) bool { return strings.Contains(database.Fold(s), folded) }
+	if hasWildcards(term) {
+		re := regexp.MustCompile(wildcardRegex(folded))
+		prefix = func(s string) bool { return re.MatchString(database.Fold(s)) }
+		contains = prefix
+	}
+	return func(p Product) bool {
+		for _, f := range fields {
+			match := prefix
+			if f.Shadow == "" {
+				match = contains
+			}
+			for _, v := range f.Values(p) {
+				if match(v) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func hasFoldedPrefix(s, prefix string) bool {
+	return strings.HasPrefix(database.Fold(s), prefix)
+}
+
+// Status matches groups with a product in one of the given statuses,
+// which must be valid.
+func (b *FilterBuilder) Status(statuses []ProductStatus) *FilterBuilder {
+	if len(statuses) == 0 {
+		return b
+	}
+	b.filter["productList.productStatus"] = bson.M{"$in": statuses}
+
+	b.matchers = append(b.matchers, func(p Product) bool {
+		for _, s := range statuses {
+			if p.Status == s {
+				return true
+			}
+		}
+		return false
+	})
+	return b
+}
+
+// MissingInsurer, when on, matches products without an insurer code: the
+// insurer is absent, not a document, or has an empty code. On group
+// documents it selects groups with at least one such product.
+func (b *FilterBuilder) MissingInsurer(on bool) *FilterBuilder {
+	if on {
+		b.filter["productList.insurer.insurerCode"] = bson.M{"$in": bson.A{nil, ""}}
+		b.matchers = append(b.matchers, func(p Product) bool {
+			return p.Insurer.InsurerCode == ""
+		})
+	}
+	return b
+}
+
+// Code matches the product with the given code. Codes are generated in
+// upper case, so a lower-case one is accepted too. A code with * or ?
+// wildcards is a pattern for the whole code.
+func (b *FilterBuilder) Code(code string) *FilterBuilder {
+	if code == "" {
+		return b
+	}
+	code = strings.ToUpper(code)
+	if hasWildcards(code) {
+		pattern := wildcardRegex(code)
+		b.filter["productList.productCode"] = bson.M{"$regex": pattern}
+		re := regexp.MustCompile(pattern)
+		b.matchers = append(b.matchers, func(p Product) bool {
+			return re.MatchString(p.Code)
+		})
+		return b
+	}
+	b.filter["productList.productCode"] = code
+	b.matchers = append(b.matchers, func(p Product) bool {
+		return p.Code == code
+	})
+	return b
+}
+
+// Group matches the group with the given key, or with a key matching it
+// when it has * or ? wildcards.
+func (b *FilterBuilder) Group(key string) *FilterBuilder {
+	if key == "" {
+		return b
+	}
+	if hasWildcards(key) {
+		pattern := wildcardRegex(key)
+		b.filter["key"] = bson.M{"$regex": pattern}
+		re := regexp.MustCompile(pattern)
+		b.matchers = append(b.matchers, func(p Product) bool {
+			return re.MatchString(p.ProductGroup.Key)
+		})
+		return b
+	}
+	b.filter["key"] = key
+	b.matchers = append(b.matchers, func(p Product) bool {
+		return p.ProductGroup.Key == key
+	})
+	return b
+}
+
+// BrokerCount matches products with between min and max brokers
+// inclusive; a negative bound is open. The count is taken in Mongo, with
+// a $expr.
+func (b *FilterBuilder) BrokerCount(min, max int) *FilterBuilder {
+	if min < 0 && max < 0 {
+		return b
+	}
+	b.filter["$expr"] = database.BrokerCountExpr(min, max)
+	b.matchers = append(b.matchers, func(p Product) bool {
+		n := len(p.Brokers)
+		return (min < 0 || n >= min) && (max < 0 || n <= max)
+	})
+	return b
+}
+
+// Expression adds a parsed ?filter= or ?q= expression, which must hold
+// next to the other conditions. A nil filter adds nothing.
+func (b *FilterBuilder) Expression(filter bson.M, matcher itemMatcher) *FilterBuilder {
+	if filter == nil {
+		return b
+	}
+	and, _ := b.filter["$and"].(bson.A)
+	b.filter["$and"] = append(and, filter)
+	b.matchers = append(b.matchers, matcher)
+	return b
+}
+
+// Matches reports whether a product mapped from a matching group satisfies
+// the filter itself; a group matches when any of its products does.
+func (b *FilterBuilder) Matches(p Product) bool {
+	for _, m := range b.matchers {
+		if !m(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *FilterBuilder) Build() bson.M {
+	return b.filter
+}
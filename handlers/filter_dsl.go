@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Limits of a ?filter= expression, which bound the query it can produce.
+const (
+	maxFilterDepth  = 5
+	maxFilterNodes  = 50
+	maxFilterValues = 100
+)
+
+// dslField is a field ?filter= may name. Group fields are stored once per
+// group; the others per product, and for those ne must hold of a single
+// product, which a productList query cannot say, so it becomes a $expr.
+type dslField struct {
+	path string
+	// item is the path within a productList entry, for product fields.
+	item string
+	// values returns the field's values on a mapped product; brokers have
+	// several.
+	values func(Product) []string
+	// canonical checks a value and returns its stored form.
+	canonical func(string) (string, bool)
+}
+
+var dslFields = map[string]dslField{
+	"group": {path: "key", values: func(p Product) []string { return []string{p.ProductGroup.Key} }},
+	"type":  {path: "productType.key", values: func(p Product) []string { return []string{p.ProductType.Key} }},
+	"name": {path: "productList.productName", item: "productName",
+		values: func(p Product) []string { return []string{p.ProductName} }},
+	"insurer": {path: "productList.insurer.insurerCode", item: "insurer.insurerCode",
+		values: func(p Product) []string { return []string{p.Insurer.InsurerCode} }},
+	"code": {path: "productList.productCode", item: "productCode",
+		values:    func(p Product) []string { return []string{p.Code} },
+		canonical: func(v string) (string, bool) { return strings.ToUpper(v), true }},
+	"status": {path: "productList.productStatus", item: "productStatus",
+		values: func(p Product) []string { return []string{string(p.Status)} },
+		canonical: func(v string) (string, bool) {
+			s, ok := ParseProductStatus(v)
+			return string(s), ok
+		}},
+	"broker": {path: "productList.brokers.key", item: "brokers.key",
+		values: func(p Product) []string {
+			keys := make([]string, len(p.Brokers))
+			for i, b := range p.Brokers {
+				keys[i] = b.Key
+			}
+			return keys
+		}},
+}
+
+// filterSelectors are the other names of dslFields: the paths of the
+// fields in the Product JSON, which RSQL tools emit.
+var filterSelectors = map[string]string{
+	"productGroup.key":    "group",
+	"productType.key":     "type",
+	"productName":         "name",
+	"insurer.insurerCode": "insurer",
+	"brokers.key":         "broker",
+}
+
+// filterField looks up a field of ?filter= or ?q= by name or selector.
+func filterField(name string) (dslField, bool) {
+	if alias, ok := filterSelectors[name]; ok {
+		name = alias
+	}
+	f, ok := dslFields[name]
+	return f, ok
+}
+
+// dslError is an invalid ?filter= node, named by its path in the
+// expression, e.g. or[1].ne.status.
+type dslError struct {
+	Node   string `json:"node"`
+	Reason string `json:"reason"`
+}
+
+func (e *dslError) Error() string {
+	if e.Node == "" {
+		return "filter " + e.Reason
+	}
+	return "filter node " + e.Node + " " + e.Reason
+}
+
+// parseFilterDSL parses ?filter=, a JSON expression tree. Every node is an
+// object with exactly one operator:
+//
+//	{"and": [node, ...]}, {"or": [node, ...]}
+//	{"eq": {field: value, ...}}, {"ne": {field: value, ...}}
+//	{"in": {field: [value, ...], ...}}
+//
+// Several fields in one eq, ne or in must all hold. Fields and values are
+// checked against dslFields and only ever become values of the filter
+// built here; nothing of the expression reaches Mongo as an operator or a
+// path.
+func parseFilterDSL(raw string) (bson.M, itemMatcher, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, nil, &dslError{Reason: "is not valid JSON: " + err.Error()}
+	}
+	if dec.More() {
+		return nil, nil, &dslError{Reason: "has data after the expression"}
+	}
+	p := &dslParser{}
+	return p.node(tree, "", 1)
+}
+
+type dslParser struct {
+	nodes int
+}
+
+func (p *dslParser) node(v interface{}, at string, depth int) (bson.M, itemMatcher, error) {
+	p.nodes++
+	if p.nodes > maxFilterNodes {
+		return nil, nil, &dslError{Node: at, Reason: fmt.Sprintf("exceeds the limit of %d nodes", maxFilterNodes)}
+	}
+	if depth > maxFilterDepth {
+		return nil, nil, &dslError{Node: at, Reason: fmt.Sprintf("is nested deeper than %d levels", maxFilterDepth)}
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok || len(obj) != 1 {
+		return nil, nil, &dslError{Node: at, Reason: "must be an object with exactly one operator"}
+	}
+	for op, arg := range obj {
+		path := join(at, op)
+		switch op {
+		case "and", "or":
+			return p.logical(op, arg, path, depth)
+		case "eq", "ne", "in":
+			return p.comparison(op, arg, path)
+		}
+		return nil, nil, &dslError{Node: path, Reason: "is not an operator; use and, or, eq, ne or in"}
+	}
+	panic("unreachable")
+}
+
+func (p *dslParser) logical(op string, arg interface{}, at string, depth int) (bson.M, itemMatcher, error) {
+	list, ok := arg.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, nil, &dslError{Node: at, Reason: "must be a non-empty array of expressions"}
+	}
+	filters := bson.A{}
+	matchers := make([]itemMatcher, 0, len(list))
+	for i, child := range list {
+		f, m, err := p.node(child, fmt.Sprintf("%s[%d]", at, i), depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, f)
+		matchers = append(matchers, m)
+	}
+	if op == "and" {
+		return bson.M{"$and": filters}, allOf(matchers), nil
+	}
+	return bson.M{"$or": filters}, anyOf(matchers), nil
+}
+
+func allOf(matchers []itemMatcher) itemMatcher {
+	return func(p Product) bool {
+		for _, m := range matchers {
+			if !m(p) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func anyOf(matchers []itemMatcher) itemMatcher {
+	return func(p Product) bool {
+		for _, m := range matchers {
+			if m(p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (p *dslParser) comparison(op string, arg interface{}, at string) (bson.M, itemMatcher, error) {
+	fields, ok := arg.(map[string]interface{})
+	if !ok || len(fields) == 0 {
+		return nil, nil, &dslError{Node: at, Reason: "must be an object of fields"}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters := bson.A{}
+	var matchers []itemMatcher
+	for _, name := range names {
+		path := join(at, name)
+		field, ok := filterField(name)
+		if !ok {
+			return nil, nil, &dslError{Node: path, Reason: "is not a filterable field; use one of " + strings.Join(dslFieldNames(), ", ")}
+		}
+		var values []string
+		if op == "in" {
+			list, ok := fields[name].([]interface{})
+			if !ok || len(list) == 0 || len(list) > maxFilterValues {
+				return nil, nil, &dslError{Node: path, Reason: fmt.Sprintf("must be an array of 1 to %d strings", maxFilterValues)}
+			}
+			for i, item := range list {
+				v, err := dslValue(field, item, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return nil, nil, err
+				}
+				values = append(values, v)
+			}
+		} else {
+			v, err := dslValue(field, fields[name], path)
+			if err != nil {
+				return nil, nil, err
+			}
+			values = []string{v}
+		}
+		filter, matcher := dslCondition(op, field, values)
+		filters = append(filters, filter)
+		matchers = append(matchers, matcher)
+	}
+	return bson.M{"$and": filters}, allOf(matchers), nil
+}
+
+// dslValue checks a comparison value: a string, valid for the field.
+func dslValue(field dslField, v interface{}, at string) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", &dslError{Node: at, Reason: "must be a string"}
+	}
+	s = strings.TrimSpace(database.Normalize(s))
+	if field.canonical != nil {
+		c, ok := field.canonical(s)
+		if !ok {
+			return "", &dslError{Node: at, Reason: "is not a valid value: " + s}
+		}
+		s = c
+	}
+	return s, nil
+}
+
+// dslCondition is the filter and matcher of one field's comparison: eq,
+// ne, in, or out for not in.
+func dslCondition(op string, field dslField, values []string) (bson.M, itemMatcher) {
+	has := func(pr Product) bool {
+		for _, got := range field.values(pr) {
+			for _, want := range values {
+				if got == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	lacks := func(pr Product) bool { return !has(pr) }
+	switch {
+	case op == "eq":
+		return bson.M{field.path: values[0]}, has
+	case op == "in":
+		return bson.M{field.path: bson.M{"$in": values}}, has
+	case field.item == "" && op == "ne":
+		return bson.M{field.path: bson.M{"$ne": values[0]}}, lacks
+	case field.item == "":
+		return bson.M{field.path: bson.M{"$nin": values}}, lacks
+	}
+	// A product lacks the values when its field is none of them, or for
+	// brokers when none of its broker keys is. The values are a $literal:
+	// in an expression a string starting with $ would be a field path.
+	expr := database.ProductExpr(func(path func(string) string) bson.M {
+		value := path(field.item)
+		return bson.M{"$eq": bson.A{0, bson.M{"$size": bson.M{"$setIntersection": bson.A{
+			bson.M{"$literal": values},
+			bson.M{"$cond": bson.A{bson.M{"$isArray": value}, value, bson.A{value}}},
+		}}}}}
+	})
+	return bson.M{"$expr": expr}, lacks
+}
+
+func dslFieldNames() []string {
+	names := make([]string, 0, len(dslFields)+len(filterSelectors))
+	for name := range dslFields {
+		names = append(names, name)
+	}
+	for name := range filterSelectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func join(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
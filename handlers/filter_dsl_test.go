@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// filterOperators are the query operators a ?filter= or ?q= filter may
+// hold outside its $expr conditions.
+var filterOperators = map[string]bool{"$and": true, "$or": true, "$in": true, "$ne": true, "$nin": true}
+
+// checkFilter fails t unless every key of filter is one of
+// filterOperators, $expr, or the stored path of a dslField, so that
+// nothing of the expression reached the filter as an operator or a path.
+func checkFilter(t *testing.T, filter interface{}) {
+	t.Helper()
+	paths := map[string]bool{}
+	for _, f := range dslFields {
+		paths[f.path] = true
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case bson.M:
+			for k, val := range v {
+				switch {
+				case k == "$expr":
+				case filterOperators[k]:
+					walk(val)
+				case paths[k]:
+					if _, ok := val.(string); !ok {
+						walk(val)
+					}
+				default:
+					t.Fatalf("filter %v holds the key %q", filter, k)
+				}
+			}
+		case bson.A:
+			for _, item := range v {
+				walk(item)
+			}
+		case string, []string:
+		default:
+			t.Fatalf("filter %v holds %#v", filter, v)
+		}
+	}
+	walk(filter)
+	if _, err := bson.Marshal(filter); err != nil {
+		t.Fatalf("filter %v does not marshal: %v", filter, err)
+	}
+}
+
+func FuzzParseFilterDSL(f *testing.F) {
+	for _, s := range append(fuzzSeeds(),
+		`{"eq":{"status":"ACTIVE"}}`,
+		`{"and":[{"in":{"insurer":["AIA","BKI"]}},{"ne":{"brokers.key":"$where"}}]}`,
+		`{"or":[{"eq":{"name":"ประกัน"}},{"eq":{"code":"mt-001"}}]}`,
+		`{"$where":"sleep(1000)"}`,
+		`{"eq":{"$regex":".*"}}`,
+		`{"eq":{"name":{"$regex":".*"}}}`,
+		`{"and":[{"and":[{"and":[{"and":[{"and":[{"eq":{"type":"MOTOR"}}]}]}]}]}]}`,
+		`{"eq":{"status":"ACTIVE"}} {}`,
+	) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		filter, matcher, err := parseFilterDSL(raw)
+		if err != nil {
+			var dslErr *dslError
+			if !errors.As(err, &dslErr) {
+				t.Fatalf("parseFilterDSL(%q) failed with %T: %v", raw, err, err)
+			}
+			return
+		}
+		checkFilter(t, filter)
+		matcher(Product{Brokers: []Broker{{Key: "B"}}})
+	})
+}
+
+func TestParseFilterDSL(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want bson.M
+		// matches and misses are products the matcher must accept and
+		// reject.
+		matches, misses []Product
+	}{
+		{
+			name:    "eq on a group field",
+			raw:     `{"eq":{"group":"MOTOR-1"}}`,
+			want:    bson.M{"$and": bson.A{bson.M{"key": "MOTOR-1"}}},
+			matches: []Product{{ProductGroup: ProductGroup{Key: "MOTOR-1"}}},
+			misses:  []Product{{ProductGroup: ProductGroup{Key: "MOTOR-2"}}},
+		},
+		{
+			name: "several fields in one eq, by name and selector",
+			raw:  `{"eq":{"productName":"ประกัน","status":"active","code":"mt-1"}}`,
+			want: bson.M{"$and": bson.A{
+				bson.M{"productList.productCode": "MT-1"},
+				bson.M{"productList.productName": "ประกัน"},
+				bson.M{"productList.productStatus": "ACTIVE"},
+			}},
+			matches: []Product{{Code: "MT-1", ProductName: "ประกัน", Status: StatusActive}},
+			misses:  []Product{{Code: "MT-1", ProductName: "ประกัน", Status: StatusDraft}},
+		},
+		{
+			name:    "in",
+			raw:     `{"in":{"insurer":["AIA","BKI"]}}`,
+			want:    bson.M{"$and": bson.A{bson.M{"productList.insurer.insurerCode": bson.M{"$in": []string{"AIA", "BKI"}}}}},
+			matches: []Product{{Insurer: Insurer{InsurerCode: "BKI"}}},
+			misses:  []Product{{Insurer: Insurer{InsurerCode: "VIR"}}},
+		},
+		{
+			name:    "ne on a group field",
+			raw:     `{"ne":{"type":"MOTOR"}}`,
+			want:    bson.M{"$and": bson.A{bson.M{"productType.key": bson.M{"$ne": "MOTOR"}}}},
+			matches: []Product{{ProductType: ProductType{Key: "HEALTH"}}},
+			misses:  []Product{{ProductType: ProductType{Key: "MOTOR"}}},
+		},
+		{
+			name:    "ne on brokers holds when no broker is the value",
+			raw:     `{"ne":{"broker":"B1"}}`,
+			matches: []Product{{}, {Brokers: []Broker{{Key: "B2"}}}},
+			misses:  []Product{{Brokers: []Broker{{Key: "B2"}, {Key: "B1"}}}},
+		},
+		{
+			name: "and of or",
+			raw:  `{"and":[{"or":[{"eq":{"type":"MOTOR"}},{"eq":{"type":"HEALTH"}}]},{"eq":{"status":"ACTIVE"}}]}`,
+			want: bson.M{"$and": bson.A{
+				bson.M{"$or": bson.A{
+					bson.M{"$and": bson.A{bson.M{"productType.key": "MOTOR"}}},
+					bson.M{"$and": bson.A{bson.M{"productType.key": "HEALTH"}}},
+				}},
+				bson.M{"$and": bson.A{bson.M{"productList.productStatus": "ACTIVE"}}},
+			}},
+			matches: []Product{{ProductType: ProductType{Key: "HEALTH"}, Status: StatusActive}},
+			misses:  []Product{{ProductType: ProductType{Key: "HEALTH"}, Status: StatusDraft}, {ProductType: ProductType{Key: "TRAVEL"}, Status: StatusActive}},
+		},
+		{
+			name:    "values are normalized and trimmed",
+			raw:     `{"eq":{"name":"  café "}}`,
+			want:    bson.M{"$and": bson.A{bson.M{"productList.productName": "café"}}},
+			matches: []Product{{ProductName: "café"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, matcher, err := parseFilterDSL(tt.raw)
+			if err != nil {
+				t.Fatalf("parseFilterDSL(%s): %v", tt.raw, err)
+			}
+			checkFilter(t, filter)
+			if tt.want != nil && !reflect.DeepEqual(filter, tt.want) {
+				t.Errorf("parseFilterDSL(%s)\n got %#v\nwant %#v", tt.raw, filter, tt.want)
+			}
+			for _, p := range tt.matches {
+				if !matcher(p) {
+					t.Errorf("%s does not match %+v", tt.raw, p)
+				}
+			}
+			for _, p := range tt.misses {
+				if matcher(p) {
+					t.Errorf("%s matches %+v", tt.raw, p)
+				}
+			}
+		})
+	}
+}
+
+// TestParseFilterDSLInjection checks that operators and paths in the
+// expression never reach the filter as such.
+func TestParseFilterDSLInjection(t *testing.T) {
+	rejected := []struct {
+		raw, node string
+	}{
+		{`{"$where":"sleep(1000)"}`, "$where"},
+		{`{"$or":[{"eq":{"type":"A"}}]}`, "$or"},
+		{`{"eq":{"$where":"1"}}`, "eq.$where"},
+		{`{"eq":{"$regex":".*"}}`, "eq.$regex"},
+		{`{"eq":{"productList.$[]":"x"}}`, "eq.productList.$[]"},
+		{`{"eq":{"key":"MOTOR-1"}}`, "eq.key"},
+		{`{"eq":{"name":{"$regex":".*"}}}`, "eq.name"},
+		{`{"eq":{"name":{"$ne":null}}}`, "eq.name"},
+		{`{"ne":{"status":{"$gt":""}}}`, "ne.status"},
+		{`{"in":{"broker":[{"$exists":true}]}}`, "in.broker[0]"},
+		{`{"in":{"broker":{"$in":["B1"]}}}`, "in.broker"},
+		{`{"and":[{"eq":{"type":"A"}},{"$expr":{"$eq":[1,1]}}]}`, "and[1].$expr"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.raw, func(t *testing.T) {
+			_, _, err := parseFilterDSL(tt.raw)
+			var dslErr *dslError
+			if !errors.As(err, &dslErr) || dslErr.Node != tt.node {
+				t.Errorf("parseFilterDSL(%s) = %v, want an error at %s", tt.raw, err, tt.node)
+			}
+		})
+	}
+
+	// Values that look like operators or field paths stay strings.
+	filter, _, err := parseFilterDSL(`{"eq":{"name":"$where","group":"$$ROOT"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": bson.A{bson.M{"key": "$$ROOT"}, bson.M{"productList.productName": "$where"}}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("filter = %#v, want %#v", filter, want)
+	}
+	// ne on a product field is a $expr, in which a string starting with $
+	// would be a path; the values must be a $literal.
+	filter, _, err = parseFilterDSL(`{"ne":{"name":"$productList.productName"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := bson.MarshalExtJSON(filter, false, false); !strings.Contains(string(b), `{"$literal":["$productList.productName"]}`) {
+		t.Errorf("ne filter %s does not hold its value as a $literal", b)
+	}
+}
+
+func TestParseFilterDSLLimits(t *testing.T) {
+	leaf := `{"eq":{"type":"A"}}`
+	nested := func(levels int) string {
+		return strings.Repeat(`{"and":[`, levels) + leaf + strings.Repeat(`]}`, levels)
+	}
+	wide := func(n int) string {
+		return `{"or":[` + strings.TrimSuffix(strings.Repeat(leaf+",", n), ",") + `]}`
+	}
+	values := func(n int) string {
+		v := make([]string, n)
+		for i := range v {
+			v[i] = `"V` + strconv.Itoa(i) + `"`
+		}
+		return `{"in":{"broker":[` + strings.Join(v, ",") + `]}}`
+	}
+
+	tests := []struct {
+		name, raw, node string
+		ok              bool
+	}{
+		{name: "as deep as allowed", raw: nested(maxFilterDepth - 1), ok: true},
+		{name: "too deep", raw: nested(maxFilterDepth), node: strings.TrimPrefix(strings.Repeat(".and[0]", maxFilterDepth), ".")},
+		{name: "as many nodes as allowed", raw: wide(maxFilterNodes - 1), ok: true},
+		{name: "too many nodes", raw: wide(maxFilterNodes), node: "or[" + strconv.Itoa(maxFilterNodes-1) + "]"},
+		{name: "as many values as allowed", raw: values(maxFilterValues), ok: true},
+		{name: "too many values", raw: values(maxFilterValues + 1), node: "in.broker"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, _, err := parseFilterDSL(tt.raw)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("parseFilterDSL: %v", err)
+				}
+				checkFilter(t, filter)
+				return
+			}
+			var dslErr *dslError
+			if !errors.As(err, &dslErr) || dslErr.Node != tt.node {
+				t.Errorf("parseFilterDSL = %v, want an error at %s", err, tt.node)
+			}
+		})
+	}
+}
+
+func TestParseFilterDSLErrors(t *testing.T) {
+	tests := []struct {
+		raw    string
+		node   string
+		reason string
+	}{
+		{`{"eq":`, "", "is not valid JSON"},
+		{`{"eq":{"type":"A"}} {}`, "", "has data after the expression"},
+		{`[]`, "", "must be an object with exactly one operator"},
+		{`"eq"`, "", "must be an object with exactly one operator"},
+		{`{}`, "", "must be an object with exactly one operator"},
+		{`{"eq":{"type":"A"},"ne":{"type":"B"}}`, "", "must be an object with exactly one operator"},
+		{`{"not":{"type":"A"}}`, "not", "is not an operator"},
+		{`{"and":[]}`, "and", "must be a non-empty array of expressions"},
+		{`{"or":{"eq":{"type":"A"}}}`, "or", "must be a non-empty array of expressions"},
+		{`{"and":[{"eq":{"type":"A"}},"x"]}`, "and[1]", "must be an object with exactly one operator"},
+		{`{"eq":{}}`, "eq", "must be an object of fields"},
+		{`{"eq":["type","A"]}`, "eq", "must be an object of fields"},
+		{`{"eq":{"color":"red"}}`, "eq.color", "is not a filterable field"},
+		{`{"eq":{"type":1}}`, "eq.type", "must be a string"},
+		{`{"eq":{"type":null}}`, "eq.type", "must be a string"},
+		{`{"or":[{"eq":{"type":"A"}},{"eq":{"status":"PAUSED"}}]}`, "or[1].eq.status", "is not a valid value: PAUSED"},
+		{`{"in":{"insurer":"AIA"}}`, "in.insurer", "must be an array of 1 to"},
+		{`{"in":{"insurer":[]}}`, "in.insurer", "must be an array of 1 to"},
+		{`{"in":{"insurer":["AIA",2]}}`, "in.insurer[1]", "must be a string"},
+		{`{"and":[{"ne":{"status":"ACTIVE","code":true}}]}`, "and[0].ne.code", "must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			_, _, err := parseFilterDSL(tt.raw)
+			var dslErr *dslError
+			if !errors.As(err, &dslErr) {
+				t.Fatalf("parseFilterDSL(%s) = %v, want a *dslError", tt.raw, err)
+			}
+			if dslErr.Node != tt.node || !strings.HasPrefix(dslErr.Reason, tt.reason) {
+				t.Errorf("parseFilterDSL(%s) failed at %q: %q, want %q: %q", tt.raw, dslErr.Node, dslErr.Reason, tt.node, tt.reason)
+			}
+		})
+	}
+}
+
+func TestGetProductsInvalidFilter(t *testing.T) {
+	app := newTestApp(testConfig(), &mocks.ProductRepository{}, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?filter="+url.QueryEscape(`{"or":[{"eq":{"type":"A"}},{"eq":{"$where":"1"}}]}`), "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var env struct {
+		Error struct {
+			Code    string   `json:"code"`
+			Details dslError `json:"details"`
+		} `json:"error"`
+	}
+	decode(t, body, &env)
+	if env.Error.Code != "INVALID_FILTER" || env.Error.Details.Node != "or[1].eq.$where" {
+		t.Errorf("got %s, want INVALID_FILTER at or[1].eq.$where", body)
+	}
+}
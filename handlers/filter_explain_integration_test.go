@@ -0,0 +1,111 @@
+//go:build integration
+
+package handlers
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// planIndexes returns the indexes the stages of plan scan, and whether any
+// stage scans the whole collection.
+func planIndexes(plan bson.M) (indexes []string, collscan bool) {
+	var walk func(stage bson.M)
+	walk = func(stage bson.M) {
+		switch stage["stage"] {
+		case "IXSCAN":
+			name, _ := stage["indexName"].(string)
+			indexes = append(indexes, name)
+		case "COLLSCAN":
+			collscan = true
+		}
+		for _, key := range []string{"queryPlan", "inputStage"} {
+			if child, ok := stage[key].(bson.M); ok {
+				walk(child)
+			}
+		}
+		children, _ := stage["inputStages"].(bson.A)
+		for _, child := range children {
+			if child, ok := child.(bson.M); ok {
+				walk(child)
+			}
+		}
+	}
+	walk(plan)
+	sort.Strings(indexes)
+	return indexes, collscan
+}
+
+// TestFilterIndexUsage explains the filters of key-like searches and
+// filters on the fixtures: each is served by the index of its lowercase
+// shadow field, without a collection scan.
+func TestFilterIndexUsage(t *testing.T) {
+	groups, err := fixtures.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant, _ := schedulerTenant(t, groups...)
+	ctx := context.Background()
+	if _, err := database.EnsureIndexes(ctx, tenant.Products); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.RefreshSearchFields(ctx, tenant.Products, nil); err != nil {
+		t.Fatal(err)
+	}
+	field := func(name string) []searchField {
+		fields, ok := parseSearchFields(name)
+		if !ok {
+			t.Fatalf("no search field %s", name)
+		}
+		return fields
+	}
+
+	tests := []struct {
+		name    string
+		builder *FilterBuilder
+		want    []string
+	}{
+		{"group prefix", NewFilterBuilder().Search("health", field("productGroup")), []string{"keyLower_1"}},
+		{"product type prefix", NewFilterBuilder().Search("mot", field("productType")), []string{"productTypeKeyLower_1"}},
+		{"insurer code prefix", NewFilterBuilder().Search("TI", field("insurerCode")), []string{"insurerCodeLower_1"}},
+		{"broker key prefix", NewFilterBuilder().Search("broker-on", field("brokers")), []string{"brokerKeyLower_1"}},
+		{"every key-like field", NewFilterBuilder().Search("h", field("productGroup,insurerCode,brokers")),
+			[]string{"brokerKeyLower_1", "insurerCodeLower_1", "keyLower_1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := tt.builder.Build()
+			plan, err := database.WinningPlan(ctx, tenant.Products, database.QueryInfo{Filter: filter, Limit: 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			indexes, collscan := planIndexes(plan)
+			if collscan {
+				t.Errorf("filter %v scans the collection: %v", filter, plan)
+			}
+			for _, want := range tt.want {
+				found := false
+				for _, got := range indexes {
+					found = found || got == want
+				}
+				if !found {
+					t.Errorf("filter %v scans %v, want %s", filter, indexes, want)
+				}
+			}
+
+			// The indexed filter still finds what the matcher does.
+			n, err := tenant.Products.CountDocuments(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				t.Errorf("filter %v matches no fixture group", filter)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestSearchCaseAndNormalization checks that Thai, accented Latin and
+// mixed-case terms find the same products in Go as in Mongo, whose side is
+// evaluated here by running the filter's regex the way $options "i" does.
+func TestSearchCaseAndNormalization(t *testing.T) {
+	products := []Product{
+		{ID: "nfc", ProductName: "Café Europe Schengen"},
+		{ID: "nfd", ProductName: "Cafe\u0301 Asia"},
+		{ID: "plain", ProductName: "Cafe Basic"},
+		{ID: "upper", ProductName: "HEALTH PLUS Family"},
+		{ID: "thai", ProductName: "ประกันสุขภาพ เหมาจ่าย"},
+		{ID: "greek", ProductName: "ΣΟΦΙΑ Travel"},
+	}
+	var names []searchField
+	for _, f := range searchFields {
+		if f.Name == "productName" {
+			names = append(names, f)
+		}
+	}
+
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"café", []string{"nfc", "nfd"}},
+		{"CAFÉ", []string{"nfc", "nfd"}},
+		{"cafe\u0301", []string{"nfc", "nfd"}},
+		{"cafe", []string{"plain"}},
+		{"health plus", []string{"upper"}},
+		{"hEaLtH", []string{"upper"}},
+		{"สุขภาพ", []string{"thai"}},
+		{"ประกันสุขภาพ เหมา", []string{"thai"}},
+		{"σοφια", []string{"greek"}},
+		{"caf*", []string{"nfc", "nfd", "plain"}},
+		{"ประกันส", []string{"thai"}},
+		{"cafe\u0301 asia", []string{"nfd"}},
+		{"caf* basic", []string{"plain"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			b := NewFilterBuilder().Search(tt.term, names)
+			or := b.Build()["$or"].([]bson.M)
+			cond := or[0]["productList.productName"].(bson.M)
+			re := regexp.MustCompile("(?" + cond["$options"].(string) + ")" + cond["$regex"].(string))
+
+			inGo, inMongo := []string{}, []string{}
+			for _, p := range products {
+				if b.Matches(p) {
+					inGo = append(inGo, p.ID)
+				}
+				if re.MatchString(p.ProductName) {
+					inMongo = append(inMongo, p.ID)
+				}
+			}
+			if !reflect.DeepEqual(inGo, tt.want) || !reflect.DeepEqual(inMongo, tt.want) {
+				t.Errorf("matched %v in Go and %v in Mongo, want %v", inGo, inMongo, tt.want)
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"HEALTH Plus", "health plus"},
+		{"CAFÉ", "café"},
+		{"Cafe\u0301", "café"},
+		{"ΣΟΦΙΑ", "σοφια"},
+		{"Straße", "strasse"},
+		{"ประกันสุขภาพ", "ประกันสุขภาพ"},
+	}
+	for _, tt := range tests {
+		if got := database.Fold(tt.in); got != tt.want {
+			t.Errorf("Fold(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := database.NameKey("  Health \t PLUS\n"); got != "health plus" {
+		t.Errorf("NameKey = %q, want %q", got, "health plus")
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		term, positive string
+		negative       []string
+	}{
+		{"motor -commercial", "motor", []string{"commercial"}},
+		{"-commercial motor", "motor", []string{"commercial"}},
+		{"-commercial -fleet", "", []string{"commercial", "fleet"}},
+		{"motor - commercial", "motor commercial", nil},
+		{"-", "", nil},
+		{`health -"health  plus" family`, "health family", []string{"health plus"}},
+		{`"health plus" senior`, "health plus senior", nil},
+		{`-"health plus`, "", []string{"health plus"}},
+		{`-""`, "", nil},
+		{"ประกัน -รถยนต์", "ประกัน", []string{"รถยนต์"}},
+		{"MT-001", "MT-001", nil},
+	}
+	for _, tt := range tests {
+		positive, negative := searchTerms(tt.term)
+		if positive != tt.positive || !reflect.DeepEqual(negative, tt.negative) {
+			t.Errorf("searchTerms(%q) = %q, %q; want %q, %q", tt.term, positive, negative, tt.positive, tt.negative)
+		}
+	}
+}
+
+// TestSearchExclusionMatches checks that an exclusion leaves out a product
+// matching it in any search field, and only those.
+func TestSearchExclusionMatches(t *testing.T) {
+	products := []Product{
+		{ID: "commercial name", ProductName: "Motor Commercial", ProductGroup: ProductGroup{Key: "MOTOR-1"}},
+		{ID: "commercial group", ProductName: "Motor Fleet", ProductGroup: ProductGroup{Key: "COMMERCIAL-MOTOR"}},
+		{ID: "commercial broker", ProductName: "Motor Van", ProductGroup: ProductGroup{Key: "MOTOR-2"},
+			Brokers: []Broker{{Key: "commercial-desk"}}},
+		{ID: "private", ProductName: "Motor Private", ProductGroup: ProductGroup{Key: "MOTOR-1"}},
+		{ID: "travel", ProductName: "Travel Asia", ProductGroup: ProductGroup{Key: "TRAVEL"}},
+	}
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"motor -commercial", []string{"private"}},
+		{"-commercial", []string{"private", "travel"}},
+		{`-"motor private" -travel`, []string{"commercial name", "commercial group", "commercial broker"}},
+		{"motor -", []string{"commercial name", "commercial group", "commercial broker", "private"}},
+	}
+	for _, tt := range tests {
+		b := NewFilterBuilder().Search(tt.term, searchFields)
+		got := []string{}
+		for _, p := range products {
+			if b.Matches(p) {
+				got = append(got, p.ID)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q matched %v, want %v", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchFields(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+		ok   bool
+	}{
+		{"", searchFieldNames(), true},
+		{"productName", []string{"productName"}, true},
+		{" insurerCode , productName", []string{"insurerCode", "productName"}, true},
+		{"productName,color", nil, false},
+		{"ProductName", nil, false},
+		{",", nil, false},
+	}
+	for _, tt := range tests {
+		fields, ok := parseSearchFields(tt.raw)
+		var got []string
+		for _, f := range fields {
+			got = append(got, f.Name)
+		}
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchFields(%q) = %v, %t; want %v, %t", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestBrokerCountMatches checks the bounds inclusive, open when negative,
+// and a product without brokers counted as having none.
+func TestBrokerCountMatches(t *testing.T) {
+	brokers := func(n int) Product {
+		p := Product{ID: string(rune('0' + n)), Brokers: []Broker{}}
+		for i := 0; i < n; i++ {
+			p.Brokers = append(p.Brokers, Broker{Key: "BROKER"})
+		}
+		return p
+	}
+	products := []Product{{ID: "nil"}, brokers(0), brokers(1), brokers(2), brokers(3)}
+	tests := []struct {
+		min, max int
+		want     []string
+	}{
+		{0, 0, []string{"nil", "0"}},
+		{1, 1, []string{"1"}},
+		{3, -1, []string{"3"}},
+		{-1, 1, []string{"nil", "0", "1"}},
+		{1, 2, []string{"1", "2"}},
+		{-1, -1, []string{"nil", "0", "1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		b := NewFilterBuilder().BrokerCount(tt.min, tt.max)
+		got := []string{}
+		for _, p := range products {
+			if b.Matches(p) {
+				got = append(got, p.ID)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("BrokerCount(%d, %d) matched %v, want %v", tt.min, tt.max, got, tt.want)
+		}
+		if _, ok := b.Build()["$expr"]; ok != (tt.min >= 0 || tt.max >= 0) {
+			t.Errorf("BrokerCount(%d, %d) filter = %v", tt.min, tt.max, b.Build())
+		}
+	}
+}
+
+func FuzzSearchTerms(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, term string) {
+		positive, negative := searchTerms(term)
+		if positive != strings.TrimSpace(positive) {
+			t.Errorf("searchTerms(%q) matches %q, untrimmed", term, positive)
+		}
+		for _, word := range negative {
+			if word == "" || word != strings.TrimSpace(word) {
+				t.Errorf("searchTerms(%q) excludes %q", term, word)
+			}
+		}
+		if utf8.ValidString(term) && (!utf8.ValidString(positive) || !utf8.ValidString(strings.Join(negative, ""))) {
+			t.Errorf("searchTerms(%q) = %q, %q splits a character", term, positive, negative)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// flatBackfillTimeout bounds a manual products_flat rebuild.
+const flatBackfillTimeout = 30 * time.Minute
+
+// findFlatProducts is findProducts served from products_flat, where every
+// document already has the Product shape.
+func (h *Handler) findFlatProducts(c *fiber.Ctx, ctx context.Context, filter bson.M, opts *options.FindOptions) ([]Product, []Warning, error) {
+	flatFilter := database.FlatFilter(filter)
+	flatOpts := flatFindOptions(opts)
+
+	products := []Product{}
+	err := database.Breaker.Do(ctx, func() error {
+		// products_flat is not behind the repository, so the find is
+		// observed here.
+		start := time.Now()
+		cursor, err := tenant(c).FlatList.Find(ctx, flatFilter, flatOpts)
+		if err == nil {
+			defer cursor.Close(ctx)
+			err = cursor.All(ctx, &products)
+		}
+		database.ObserveQuery(ctx, tenant(c).FlatList, database.QueryInfo{
+			Operation: "find",
+			Tenant:    tenant(c).Name,
+			Filter:    flatFilter,
+			Sort:      database.FlatSort,
+			Skip:      *opts.Skip,
+			Limit:     *opts.Limit,
+		}, time.Since(start), len(products), err)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range products {
+		products[i] = products[i].withBrokers()
+	}
+	// Malformed entries never make it into products_flat; see syncFlat.
+	return products, nil, nil
+}
+
+// flatFindOptions translates the listing options to products_flat.
+func flatFindOptions(opts *options.FindOptions) *options.FindOptions {
+	flat := options.Find().
+		SetSort(database.FlatSort).
+		SetSkip(*opts.Skip).
+		SetLimit(*opts.Limit).
+		SetProjection(database.FlatProjection).
+		SetBatchSize(int32(*opts.Limit)).
+		SetCollation(opts.Collation).
+		SetMaxTime(*opts.MaxTime)
+	if opts.Hint != nil {
+		flat.SetHint(opts.Hint)
+	}
+	return flat
+}
+
+// syncFlat re-flattens a group after a write so this instance's own writes
+// show up in products_flat without waiting for the change stream. Failures
+// are only logged: the change stream and the periodic resync catch up.
+func (h *Handler) syncFlat(c *fiber.Ctx, group bson.M) {
+	if !h.cfg.Mongo.FlatSync || group == nil {
+		return
+	}
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+	start := time.Now()
+	if err := database.SyncFlatGroup(ctx, tenant(c), group["_id"]); err != nil {
+		h.logger.Error("syncing products_flat", "error", err)
+		return
+	}
+	database.ObserveFlatLag(tenant(c), start)
+}
+
+// BackfillFlat rebuilds the tenant's products_flat collection on demand.
+func (h *Handler) BackfillFlat(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), flatBackfillTimeout)
+	defer cancel()
+
+	if _, err := database.EnsureFlatIndexes(ctx, tenant(c).Flat); err != nil {
+		return apierror.Internal(c, "ensuring products_flat indexes", err)
+	}
+	if err := database.BackfillFlat(ctx, tenant(c)); err != nil {
+		return apierror.Internal(c, "backfilling products_flat", err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
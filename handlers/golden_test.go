@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the current responses")
+
+// checkGolden compares body, indented, with testdata/name.golden, or
+// rewrites the file with -update.
+func checkGolden(t *testing.T, name string, body []byte) {
+	t.Helper()
+	var got bytes.Buffer
+	if err := json.Indent(&got, body, "", "  "); err != nil {
+		t.Fatalf("indenting %s: %v", body, err)
+	}
+	got.WriteByte('\n')
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test ./handlers -update to create it", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("response differs from %s; if the change is intended, run go test ./handlers -update\n got:\n%s\nwant:\n%s", path, got.Bytes(), want)
+	}
+}
+
+func TestResponseShapes(t *testing.T) {
+	groups, err := fixtures.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		repo   *mocks.ProductRepository
+		target string
+		status int
+	}{
+		{"listing", &mocks.ProductRepository{FindDocs: groups, Total: int64(len(groups))}, "/products?limit=5", fiber.StatusOK},
+		{"grouped", &mocks.ProductRepository{FindDocs: groups, Total: int64(len(groups))}, "/products?flatten=false&limit=2", fiber.StatusOK},
+		{"product", &mocks.ProductRepository{FindOneDoc: groups[1]}, "/products/MT-001", fiber.StatusOK},
+		{"not_found", &mocks.ProductRepository{}, "/products/NOPE", fiber.StatusNotFound},
+		{"invalid_status", &mocks.ProductRepository{}, "/products?status=active,paused", fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, func(app *fiber.App, h *Handler) {
+				app.Get("/products", h.GetProducts)
+				app.Get("/products/:id", h.GetProductByID)
+			})
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			checkGolden(t, tt.name, body)
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+var groupKeyPattern = regexp.MustCompile(`^[A-Z0-9]+(?:[-_][A-Z0-9]+)*$`)
+
+func init() {
+	validation.RegisterPattern("groupKey", groupKeyPattern)
+}
+
+// GroupSummary is a product group without its products.
+type GroupSummary struct {
+	Key          string      `json:"key" bson:"key"`
+	Name         string      `json:"name" bson:"name"`
+	ProductType  ProductType `json:"productType" bson:"productType"`
+	ProductCount int         `json:"productCount" bson:"productCount"`
+}
+
+// GroupInput is the body of the group create endpoint.
+type GroupInput struct {
+	Key         string           `json:"key" validate:"required,pattern=groupKey"`
+	Name        string           `json:"name" validate:"required,max=200"`
+	ProductType ProductTypeInput `json:"productType"`
+}
+
+type ProductTypeInput struct {
+	Key  string `json:"key" validate:"max=100"`
+	Name string `json:"name" validate:"max=200"`
+}
+
+// GroupUpdateInput is the body of the group update endpoint. The key cannot
+// change; it may be repeated but must then match the path.
+type GroupUpdateInput struct {
+	Key  string `json:"key"`
+	Name string `json:"name" validate:"required,max=200"`
+}
+
+// parseBody is parseProductInput for any validated body.
+func parseBody(c *fiber.Ctx, v interface{}) (ok bool, err error) {
+	if err := validation.DecodeJSON(c.Body(), v); err != nil {
+		return false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if err := validation.Struct(v); err != nil {
+		var verrs validation.Errors
+		errors.As(err, &verrs)
+		return false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED",
+			"request body failed validation", verrs)
+	}
+	return true, nil
+}
+
+// GetGroups lists the product groups by key, a page at a time.
+func (h *Handler) GetGroups(c *fiber.Ctx) error {
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
+	}
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	groups := []GroupSummary{}
+	var total int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return database.Breaker.Do(ctx, func() error {
+			cursor, err := h.repo(c).Aggregate(gctx, database.GroupSummaryPipeline(paging.Skip(), int64(paging.Limit)),
+				options.Aggregate().SetMaxTime(h.maxTime(c)))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(gctx)
+			return cursor.All(gctx, &groups)
+		})
+	})
+	g.Go(func() error {
+		return database.Breaker.Do(ctx, func() error {
+			var err error
+			total, err = h.repo(c).Count(gctx, bson.M{}, options.Count().SetMaxTime(h.maxTime(c)))
+			return err
+		})
+	})
+	if err := g.Wait(); err != nil {
+		return queryError(c, "listing groups", err)
+	}
+	setPageLinks(c, paging, total, len(groups))
+	return c.JSON(fiber.Map{"totalCount": total, "data": groups})
+}
+
+func (h *Handler) GetGroup(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	group, found, err := h.groupSummary(c, ctx, c.Params("key"))
+	if err != nil {
+		return queryError(c, "finding group", err)
+	}
+	if !found {
+		return groupNotFound(c, c.Params("key"))
+	}
+	return c.JSON(group)
+}
+
+func (h *Handler) groupSummary(c *fiber.Ctx, ctx context.Context, key string) (GroupSummary, bool, error) {
+	var group GroupSummary
+	err := database.Breaker.Do(ctx, func() error {
+		pipeline := append(mongo.Pipeline{{{Key: "$match", Value: bson.M{"key": key}}}}, database.GroupSummaryPipeline(0, 1)...)
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		if !cursor.Next(ctx) {
+			return cursor.Err()
+		}
+		return cursor.Decode(&group)
+	})
+	return group, err == nil && group.Key != "", err
+}
+
+// groupExists reports whether a group with the key exists.
+func (h *Handler) groupExists(c *fiber.Ctx, ctx context.Context, key string) (bool, error) {
+	err := database.Breaker.Do(ctx, func() error {
+		return h.repo(c).FindOne(ctx, bson.M{"key": key}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// knownGroup checks a ?group= filter against the existing groups, sending
+// the 400 itself when ok is false. An empty key passes, as does a wildcard
+// pattern, which may match no group at all.
+func (h *Handler) knownGroup(c *fiber.Ctx, key string) (ok bool, err error) {
+	if key == "" || hasWildcards(key) {
+		return true, nil
+	}
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+	found, err := h.groupExists(c, ctx, key)
+	if err != nil {
+		return false, queryError(c, "finding group", err)
+	}
+	if !found {
+		return false, apierror.Send(c, fiber.StatusBadRequest, "UNKNOWN_GROUP", "product group "+key+" does not exist")
+	}
+	return true, nil
+}
+
+func (h *Handler) CreateGroup(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	var in GroupInput
+	if ok, err := parseBody(c, &in); !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	if ok, err := h.checkProductType(c, ctx, &in.ProductType); !ok {
+		return err
+	}
+	group := bson.M{
+		"key":      in.Key,
+		"keyLower": strings.ToLower(in.Key),
+		"name":     database.Normalize(in.Name),
+		"productType": bson.M{
+			"key":      in.ProductType.Key,
+			"keyLower": strings.ToLower(in.ProductType.Key),
+			"name":     database.Normalize(in.ProductType.Name),
+		},
+		"productList": bson.A{},
+	}
+	var created bool
+	err := database.Breaker.Do(ctx, func() error {
+		var err error
+		created, err = h.repo(c).CreateGroup(ctx, group)
+		return err
+	})
+	if err != nil {
+		return queryError(c, "creating group", err)
+	}
+	if !created {
+		return apierror.Send(c, fiber.StatusConflict, "GROUP_EXISTS", "product group "+in.Key+" already exists")
+	}
+	h.invalidateCache(c)
+	return c.Status(fiber.StatusCreated).JSON(GroupSummary{
+		Key:         in.Key,
+		Name:        getStringField(group, "name"),
+		ProductType: ProductType{Key: in.ProductType.Key, Name: getStringField(group["productType"], "name")},
+	})
+}
+
+// UpdateGroup renames a group. Its products carry the group name only in
+// products_flat, which is resynced.
+func (h *Handler) UpdateGroup(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	var in GroupUpdateInput
+	if ok, err := parseBody(c, &in); !ok {
+		return err
+	}
+	key := c.Params("key")
+	if in.Key != "" && in.Key != key {
+		return apierror.Send(c, fiber.StatusBadRequest, "KEY_IMMUTABLE", "a group's key cannot be changed")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var group bson.M
+	err := database.Breaker.Do(ctx, func() error {
+		return h.repo(c).FindOneAndUpdate(ctx,
+			bson.M{"key": key},
+			bson.M{"$set": bson.M{"name": database.Normalize(in.Name)}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&group)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return groupNotFound(c, key)
+	}
+	if err != nil {
+		return queryError(c, "updating group", err)
+	}
+	h.invalidateCache(c)
+	h.syncFlat(c, group)
+
+	summary, _, err := h.groupSummary(c, ctx, key)
+	if err != nil {
+		return queryError(c, "finding group", err)
+	}
+	return c.JSON(summary)
+}
+
+// DeleteGroup deletes an empty group. A group with products answers 409
+// unless ?moveTo= names another group, which then receives the products in
+// the same transaction. Product codes keep the key of the group they were
+// created in.
+func (h *Handler) DeleteGroup(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	key, moveTo := c.Params("key"), query(c, "moveTo")
+	if moveTo == key {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_PARAMETER", "moveTo must name another group")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	if moveTo != "" {
+		ok, err := h.groupExists(c, ctx, moveTo)
+		if err != nil {
+			return queryError(c, "finding group", err)
+		}
+		if !ok {
+			return apierror.Send(c, fiber.StatusNotFound, "TARGET_GROUP_NOT_FOUND", "product group "+moveTo+" does not exist")
+		}
+	}
+
+	var deleted, target bson.M
+	var products int
+	err := database.Breaker.Do(ctx, func() error {
+		return database.WithTransaction(ctx, func(ctx context.Context) error {
+			if moveTo == "" {
+				// An empty or missing productList is what makes a group empty.
+				return h.repo(c).DeleteGroup(ctx, bson.M{"key": key, "productList.0": bson.M{"$exists": false}}).Decode(&deleted)
+			}
+			// The products are copied before the source is deleted, and the
+			// delete only goes ahead if the source is unchanged, so without
+			// transactions a failure leaves extra copies rather than lost
+			// products.
+			var source bson.M
+			if err := h.repo(c).FindOne(ctx, bson.M{"key": key}).Decode(&source); err != nil {
+				return err
+			}
+			list, _ := source["productList"].(bson.A)
+			products = len(list)
+			if products > 0 {
+				err := h.repo(c).FindOneAndUpdate(ctx,
+					bson.M{"key": moveTo},
+					bson.M{"$push": bson.M{"productList": bson.M{"$each": list}}},
+					options.FindOneAndUpdate().SetReturnDocument(options.After),
+				).Decode(&target)
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return errTargetGone
+				}
+				if err != nil {
+					return err
+				}
+			}
+			err := h.repo(c).DeleteGroup(ctx, bson.M{"_id": source["_id"], "productList": bson.M{"$size": products}}).Decode(&deleted)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return errGroupChanged
+			}
+			return err
+		})
+	})
+	switch {
+	case errors.Is(err, errTargetGone):
+		return apierror.Send(c, fiber.StatusNotFound, "TARGET_GROUP_NOT_FOUND", "product group "+moveTo+" does not exist")
+	case errors.Is(err, errGroupChanged):
+		return apierror.Send(c, fiber.StatusConflict, "GROUP_CHANGED", "product group "+key+" changed during the move; retry")
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return h.groupNotDeleted(c, ctx, key)
+	case err != nil:
+		return queryError(c, "deleting group", err)
+	}
+
+	h.invalidateCache(c)
+	h.syncFlat(c, deleted)
+	h.syncFlat(c, target)
+	if moveTo != "" {
+		return c.JSON(fiber.Map{"moved": products, "moveTo": moveTo})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// groupNotDeleted explains a delete that matched nothing: the group does
+// not exist, or still has products.
+func (h *Handler) groupNotDeleted(c *fiber.Ctx, ctx context.Context, key string) error {
+	group, found, err := h.groupSummary(c, ctx, key)
+	if err != nil {
+		return queryError(c, "finding group", err)
+	}
+	if !found {
+		return groupNotFound(c, key)
+	}
+	return apierror.SendDetails(c, fiber.StatusConflict, "GROUP_NOT_EMPTY",
+		fmt.Sprintf("product group %s still has %d products; move them with moveTo", key, group.ProductCount),
+		fiber.Map{"productCount": group.ProductCount})
+}
+
+// The move's own failures wrap ErrNoDocuments, which keeps them off the
+// circuit breaker's failure count.
+var (
+	errTargetGone   = fmt.Errorf("target group no longer exists: %w", mongo.ErrNoDocuments)
+	errGroupChanged = fmt.Errorf("group changed during the move: %w", mongo.ErrNoDocuments)
+)
+
+func groupNotFound(c *fiber.Ctx, key string) error {
+	return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_GROUP_NOT_FOUND", "product group "+key+" does not exist")
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bundleSchemaVersion is the version of the group bundle format written by
+// ExportGroup. Imports of any other version are refused.
+const bundleSchemaVersion = 1
+
+// GroupBundle is a self-contained export of product group documents. Each
+// group is the stored document in canonical Extended JSON, so dates,
+// ObjectIDs and number types survive the trip to another environment.
+type GroupBundle struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	ExportedAt    time.Time         `json:"exportedAt"`
+	Tenant        string            `json:"tenant"`
+	Groups        []json.RawMessage `json:"groups"`
+}
+
+// importConflict is bundle data that already exists in the target.
+type importConflict struct {
+	Kind     string `json:"kind"`
+	GroupKey string `json:"groupKey"`
+	// ProductID and ExistingGroup are set for a product id already used,
+	// naming the group that has it.
+	ProductID     string `json:"productId,omitempty"`
+	ExistingGroup string `json:"existingGroup,omitempty"`
+	// Blocking conflicts prevent the import under either strategy.
+	Blocking bool `json:"blocking"`
+}
+
+// bundleGroup is one decoded group of a bundle.
+type bundleGroup struct {
+	doc bson.D
+	database.GroupDocument
+}
+
+// ExportGroup exports one group document, products included, as a bundle
+// that ImportGroups accepts.
+func (h *Handler) ExportGroup(c *fiber.Ctx) error {
+	key := c.Params("key")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var raw bson.Raw
+	err := database.Breaker.Do(ctx, func() error {
+		var err error
+		raw, err = h.repo(c).FindOne(ctx, bson.M{"key": key}).Raw()
+		return err
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return groupNotFound(c, key)
+	}
+	if err != nil {
+		return queryError(c, "finding group", err)
+	}
+	group, err := bson.MarshalExtJSON(raw, true, false)
+	if err != nil {
+		return apierror.Internal(c, "encoding group", err)
+	}
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="group-`+key+`.json"`)
+	return c.JSON(GroupBundle{
+		SchemaVersion: bundleSchemaVersion,
+		ExportedAt:    h.now().UTC(),
+		Tenant:        tenant(c).Name,
+		Groups:        []json.RawMessage{group},
+	})
+}
+
+// ImportGroups applies a group bundle. ?strategy=replace makes each group
+// exactly the bundled document; ?strategy=merge, the default, keeps the
+// products only the target has, replacing those with a bundled id and
+// adding the rest. A product id that lives in a different group of the
+// target blocks the import either way. With ?dryRun=true only the
+// conflicts are reported.
+func (h *Handler) ImportGroups(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	strategy := query(c, "strategy", "merge")
+	if strategy != "merge" && strategy != "replace" {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_STRATEGY", "strategy must be merge or replace")
+	}
+	groups, ok, err := parseBundle(c)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	conflicts, err := h.importConflicts(c, ctx, groups)
+	if err != nil {
+		return queryError(c, "checking import conflicts", err)
+	}
+	blocked := false
+	for _, cf := range conflicts {
+		blocked = blocked || cf.Blocking
+	}
+	if dryRun(c) || blocked {
+		status := fiber.StatusOK
+		if blocked {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{"strategy": strategy, "applied": false, "conflicts": conflicts})
+	}
+
+	requestID := middleware.RequestIDFrom(c)
+	var written []bson.M
+	err = database.Breaker.Do(ctx, func() error {
+		return database.WithTransaction(ctx, func(ctx context.Context) error {
+			t := tenant(c)
+			written = written[:0]
+			before := make([]bson.M, len(groups))
+			ids := bson.A{}
+			for i, g := range groups {
+				current, id, err := importGroup(ctx, t.Products, g, strategy)
+				if err != nil {
+					return err
+				}
+				before[i] = current
+				ids = append(ids, id)
+			}
+			// The bundle may carry stale or no search fields.
+			if err := database.RefreshSearchFields(ctx, t.Products, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return err
+			}
+			now := h.now().UTC()
+			for i, id := range ids {
+				var stored bson.M
+				if err := t.Products.FindOne(ctx, bson.M{"_id": id}).Decode(&stored); err != nil {
+					return err
+				}
+				written = append(written, stored)
+				for _, entry := range importEntries(before[i], stored) {
+					entry.Actor, entry.RequestID, entry.Timestamp = actor, requestID, now
+					if err := audit.Record(ctx, t.Audit, entry); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+	switch {
+	case errors.Is(err, errGroupChanged):
+		return apierror.Send(c, fiber.StatusConflict, "GROUP_CHANGED", "a product group changed during the import; retry")
+	case mongo.IsDuplicateKeyError(err):
+		return apierror.Send(c, fiber.StatusConflict, "IMPORT_CONFLICT", "the bundle clashes with existing product names or codes")
+	case err != nil:
+		return queryError(c, "importing groups", err)
+	}
+
+	h.invalidateCache(c)
+	for _, doc := range written {
+		h.syncFlat(c, doc)
+	}
+	return c.JSON(fiber.Map{"strategy": strategy, "applied": true, "groups": len(written), "conflicts": conflicts})
+}
+
+// parseBundle decodes and checks a bundle: the schema version, groups with
+// valid keys, and product ids unique across the bundle.
+func parseBundle(c *fiber.Ctx) ([]bundleGroup, bool, error) {
+	var bundle GroupBundle
+	if err := validation.DecodeJSON(c.Body(), &bundle); err != nil {
+		return nil, false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if bundle.SchemaVersion != bundleSchemaVersion {
+		return nil, false, apierror.Send(c, fiber.StatusBadRequest, "UNSUPPORTED_BUNDLE",
+			fmt.Sprintf("schemaVersion must be %d, got %d", bundleSchemaVersion, bundle.SchemaVersion))
+	}
+	if len(bundle.Groups) == 0 {
+		return nil, false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_BUNDLE", "the bundle has no groups")
+	}
+
+	var verrs validation.Errors
+	groups := make([]bundleGroup, 0, len(bundle.Groups))
+	keys, ids := map[string]bool{}, map[string]string{}
+	for i, raw := range bundle.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		var g bundleGroup
+		if err := bson.UnmarshalExtJSON(raw, true, &g.doc); err != nil {
+			verrs = append(verrs, validation.FieldError{Field: field, Rule: "format", Message: "is not a canonical Extended JSON document"})
+			continue
+		}
+		b, err := bson.Marshal(g.doc)
+		if err == nil {
+			err = bson.Unmarshal(b, &g.GroupDocument)
+		}
+		if err != nil {
+			verrs = append(verrs, validation.FieldError{Field: field, Rule: "format", Message: "is not a product group"})
+			continue
+		}
+		key := g.Key.String()
+		switch {
+		case !groupKeyPattern.MatchString(key):
+			verrs = append(verrs, validation.FieldError{Field: field + ".key", Rule: "pattern", Message: "must be a valid group key"})
+		case keys[key]:
+			verrs = append(verrs, validation.FieldError{Field: field + ".key", Rule: "unique", Message: "repeats group " + key})
+		}
+		keys[key] = true
+		for j, item := range g.ProductList {
+			id := item.ProductID()
+			itemField := fmt.Sprintf("%s.productList[%d]", field, j)
+			switch {
+			case item.Malformed || id == "":
+				verrs = append(verrs, validation.FieldError{Field: itemField, Rule: "required", Message: "must be a product with an id"})
+			case ids[id] != "":
+				verrs = append(verrs, validation.FieldError{Field: itemField, Rule: "unique", Message: "repeats product " + id + " of group " + ids[id]})
+			default:
+				ids[id] = key
+			}
+		}
+		groups = append(groups, g)
+	}
+	if len(verrs) > 0 {
+		return nil, false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "INVALID_BUNDLE", "the bundle failed validation", verrs)
+	}
+	return groups, true, nil
+}
+
+// importConflicts lists the bundled groups and product ids the target
+// already has.
+func (h *Handler) importConflicts(c *fiber.Ctx, ctx context.Context, groups []bundleGroup) ([]importConflict, error) {
+	keys := bson.A{}
+	or := bson.A{}
+	bundled := map[string]string{}
+	for _, g := range groups {
+		keys = append(keys, g.Key.String())
+		or = append(or, bson.M{"key": g.Key.String()})
+		for _, item := range g.ProductList {
+			bundled[item.ProductID()] = g.Key.String()
+			or = append(or, database.ItemFilter(item.ProductID()))
+		}
+	}
+
+	var existing []database.GroupDocument
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Find(ctx, bson.M{"$or": or},
+			options.Find().SetProjection(bson.M{"key": 1, "productList.id": 1, "productList._id": 1}).SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &existing)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []importConflict{}
+	for _, group := range existing {
+		key := group.Key.String()
+		for _, k := range keys {
+			if k == key {
+				conflicts = append(conflicts, importConflict{Kind: "group", GroupKey: key})
+			}
+		}
+		for _, item := range group.ProductList {
+			id := item.ProductID()
+			if into, ok := bundled[id]; ok {
+				conflicts = append(conflicts, importConflict{Kind: "product", GroupKey: into, ProductID: id, ExistingGroup: key, Blocking: into != key})
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// importGroup writes one bundled group with the given strategy and returns
+// the group as it was, nil for a new one, and the _id it is stored under.
+// A new group keeps its bundled _id; an existing one keeps its own.
+func importGroup(ctx context.Context, coll *mongo.Collection, g bundleGroup, strategy string) (bson.M, interface{}, error) {
+	var current bson.M
+	err := coll.FindOne(ctx, bson.M{"key": g.Key.String()}).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		res, err := coll.InsertOne(ctx, g.doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, res.InsertedID, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc := bson.D{}
+	for _, e := range g.doc {
+		if e.Key != "_id" {
+			doc = append(doc, e)
+		}
+	}
+	list, _ := current["productList"].(bson.A)
+	if strategy == "merge" {
+		doc = setElement(doc, "productList", mergeProductList(list, g))
+		// Group fields the bundle does not carry are kept.
+		for k, v := range current {
+			if _, ok := lookup(doc, k); !ok && k != "_id" {
+				doc = append(doc, bson.E{Key: k, Value: v})
+			}
+		}
+	}
+	res, err := coll.ReplaceOne(ctx, bson.M{"_id": current["_id"], "productList": bson.M{"$size": len(list)}}, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, nil, errGroupChanged
+	}
+	return current, current["_id"], nil
+}
+
+// importEntries are the audit entries of an imported group, before being
+// nil for a new group: a product.create or product.update per bundled
+// product the write changed, and a group.import entry with the changes to
+// the group as a whole, the products dropped by a replace included.
+func importEntries(before, after bson.M) []audit.Entry {
+	key := getStringField(after, "key")
+	entries := []audit.Entry{}
+	list, _ := after["productList"].(bson.A)
+	for _, item := range list {
+		m, ok := item.(bson.M)
+		if !ok {
+			continue
+		}
+		id := itemID(m)
+		old := findItem(before, id)
+		action := audit.ActionUpdate
+		if old == nil {
+			action = audit.ActionCreate
+		}
+		if changes := audit.Diff(old, m); len(changes) > 0 {
+			entries = append(entries, audit.Entry{Action: action, ProductID: id, GroupKey: key, Changes: changes})
+		}
+	}
+	return append(entries, audit.Entry{
+		Action:   audit.ActionGroupImport,
+		GroupKey: key,
+		Target:   "group:" + key,
+		Changes:  audit.Diff(withoutID(before), withoutID(after)),
+	})
+}
+
+// withoutID is group without its _id, which an import never changes.
+func withoutID(group bson.M) bson.M {
+	if group == nil {
+		return nil
+	}
+	m := make(bson.M, len(group))
+	for k, v := range group {
+		if k != "_id" {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// mergeProductList replaces the entries of list with a bundled id by the
+// bundled product, in place, and appends the other bundled products.
+func mergeProductList(list bson.A, g bundleGroup) bson.A {
+	bundled, _ := lookup(g.doc, "productList")
+	items, _ := bundled.(bson.A)
+	byID := map[string]interface{}{}
+	order := make([]string, 0, len(items))
+	for i, item := range items {
+		id := g.ProductList[i].ProductID()
+		byID[id] = item
+		order = append(order, id)
+	}
+
+	merged := bson.A{}
+	for _, item := range list {
+		id := ""
+		if m, ok := item.(bson.M); ok {
+			id = itemID(m)
+		}
+		if b, ok := byID[id]; ok && id != "" {
+			merged = append(merged, b)
+			delete(byID, id)
+			continue
+		}
+		merged = append(merged, item)
+	}
+	for _, id := range order {
+		if b, ok := byID[id]; ok {
+			merged = append(merged, b)
+		}
+	}
+	return merged
+}
+
+// itemID is ProductDocument.ProductID for a decoded productList entry.
+func itemID(item bson.M) string {
+	if id, ok := database.Stringify(item["id"]); ok && id != "" {
+		return id
+	}
+	if oid, ok := item["_id"].(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return ""
+}
+
+func lookup(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func setElement(doc bson.D, key string, v interface{}) bson.D {
+	for i, e := range doc {
+		if e.Key == key {
+			doc[i].Value = v
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: v})
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bundledGroup is a stored group with every BSON type a group holds.
+func bundledGroup(t *testing.T) bson.D {
+	t.Helper()
+	oid, _ := primitive.ObjectIDFromHex("65a000000000000000000002")
+	itemOID, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	premium, err := primitive.ParseDecimal128("1234.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.D{
+		{Key: "_id", Value: oid},
+		{Key: "key", Value: "MOTOR-1"},
+		{Key: "keyLower", Value: "motor-1"},
+		{Key: "name", Value: "ประกันรถยนต์ชั้น 1"},
+		{Key: "productType", Value: bson.D{{Key: "key", Value: "MOTOR"}, {Key: "name", Value: "Motor"}}},
+		{Key: "productList", Value: bson.A{
+			bson.D{
+				{Key: "id", Value: "MT-001"},
+				{Key: "productName", Value: "ประกันภัยรถยนต์ชั้น 1 พิเศษ"},
+				{Key: "insurer", Value: bson.D{{Key: "_id", Value: "INS-VIR"}, {Key: "insurerCode", Value: "VIR"}}},
+				{Key: "brokers", Value: bson.A{}},
+				{Key: "premium", Value: premium},
+				{Key: "seats", Value: int32(5)},
+				{Key: "sumInsured", Value: int64(1_000_000)},
+				{Key: "rate", Value: 0.5},
+				{Key: "updatedAt", Value: primitive.NewDateTimeFromTime(time.Date(2024, 4, 1, 8, 0, 0, 123e6, time.UTC))},
+				{Key: "note", Value: nil},
+			},
+			bson.D{
+				{Key: "_id", Value: itemOID},
+				{Key: "productName", Value: "Legacy"},
+			},
+		}},
+	}
+}
+
+func bundleRoutes(app *fiber.App, h *Handler) {
+	app.Get("/product-groups/:key/export", h.ExportGroup)
+	app.Post("/product-groups/import", h.ImportGroups)
+	// decoded echoes what parseBundle made of a bundle, as canonical
+	// Extended JSON.
+	app.Post("/decoded", func(c *fiber.Ctx) error {
+		groups, ok, err := parseBundle(c)
+		if !ok {
+			return err
+		}
+		b, err := bson.MarshalExtJSON(groups[0].doc, true, false)
+		if err != nil {
+			return err
+		}
+		return c.Send(b)
+	})
+}
+
+func TestGroupBundleRoundTrip(t *testing.T) {
+	stored := bundledGroup(t)
+	app := newTestApp(testConfig(), &mocks.ProductRepository{FindOneDoc: stored}, bundleRoutes)
+
+	resp, bundle := do(t, app, fiber.MethodGet, "/product-groups/MOTOR-1/export", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("export = %d: %s", resp.StatusCode, bundle)
+	}
+	var decoded GroupBundle
+	decode(t, bundle, &decoded)
+	if decoded.SchemaVersion != bundleSchemaVersion || decoded.Tenant != "test" || len(decoded.Groups) != 1 {
+		t.Fatalf("bundle = %s", bundle)
+	}
+
+	resp, body := do(t, app, fiber.MethodPost, "/decoded", string(bundle))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("decoding the bundle = %d: %s", resp.StatusCode, body)
+	}
+	var imported bson.D
+	if err := bson.UnmarshalExtJSON(body, true, &imported); err != nil {
+		t.Fatal(err)
+	}
+	// Byte equality of the BSON covers field order and every number, date
+	// and id type.
+	want, _ := bson.Marshal(stored)
+	got, _ := bson.Marshal(imported)
+	if !bytes.Equal(got, want) {
+		t.Errorf("import decoded\n%v\nwant the exported\n%v", imported, stored)
+	}
+}
+
+func TestImportGroupsDryRunConflicts(t *testing.T) {
+	stored := bundledGroup(t)
+	group, _ := bson.MarshalExtJSON(stored, true, false)
+	bundle, _ := json.Marshal(GroupBundle{SchemaVersion: bundleSchemaVersion, Groups: []json.RawMessage{group}})
+
+	tests := []struct {
+		name     string
+		existing []interface{}
+		status   int
+		kinds    []string
+		blocking bool
+	}{
+		{name: "new group", status: http.StatusOK, kinds: []string{}},
+		{
+			name:     "same group",
+			existing: []interface{}{bson.M{"key": "MOTOR-1", "productList": bson.A{bson.M{"id": "MT-001"}}}},
+			status:   http.StatusOK,
+			kinds:    []string{"group", "product"},
+		},
+		{
+			name:     "product in another group",
+			existing: []interface{}{bson.M{"key": "MOTOR-2", "productList": bson.A{bson.M{"id": "MT-001"}}}},
+			status:   http.StatusConflict,
+			kinds:    []string{"product"},
+			blocking: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), &mocks.ProductRepository{FindDocs: tt.existing}, bundleRoutes)
+			resp, body := do(t, app, fiber.MethodPost, "/product-groups/import?dryRun=true&strategy=replace", string(bundle))
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			var out struct {
+				Applied   bool             `json:"applied"`
+				Conflicts []importConflict `json:"conflicts"`
+			}
+			decode(t, body, &out)
+			kinds := []string{}
+			blocking := false
+			for _, cf := range out.Conflicts {
+				kinds = append(kinds, cf.Kind)
+				blocking = blocking || cf.Blocking
+			}
+			if out.Applied || !reflect.DeepEqual(kinds, tt.kinds) || blocking != tt.blocking {
+				t.Errorf("got %s, want conflicts %v blocking %t", body, tt.kinds, tt.blocking)
+			}
+		})
+	}
+}
+
+func TestImportGroupsInvalidBundle(t *testing.T) {
+	group := `{"key":"MOTOR-1","productList":[{"id":"A"},{"id":"A"}]}`
+	tests := []struct {
+		name, body, code string
+		status           int
+	}{
+		{"unsupported version", `{"schemaVersion":2,"groups":[` + group + `]}`, "UNSUPPORTED_BUNDLE", http.StatusBadRequest},
+		{"no groups", `{"schemaVersion":1,"groups":[]}`, "INVALID_BUNDLE", http.StatusBadRequest},
+		{"repeated product", `{"schemaVersion":1,"groups":[` + group + `]}`, "INVALID_BUNDLE", http.StatusUnprocessableEntity},
+		{"bad strategy", "", "INVALID_STRATEGY", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/product-groups/import"
+			if tt.body == "" {
+				target += "?strategy=overwrite"
+			}
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, bundleRoutes)
+			resp, body := do(t, app, fiber.MethodPost, target, tt.body)
+			if resp.StatusCode != tt.status || errorCode(body) != tt.code {
+				t.Errorf("got %d %s, want %d %s", resp.StatusCode, body, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestMergeProductList(t *testing.T) {
+	raw, _ := bson.MarshalExtJSON(bson.D{
+		{Key: "key", Value: "G"},
+		{Key: "productList", Value: bson.A{
+			bson.D{{Key: "id", Value: "B"}, {Key: "productName", Value: "B from the bundle"}},
+			bson.D{{Key: "id", Value: "C"}, {Key: "productName", Value: "C"}},
+		}},
+	}, true, false)
+	var g bundleGroup
+	if err := bson.UnmarshalExtJSON(raw, true, &g.doc); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := bson.Marshal(g.doc)
+	if err := bson.Unmarshal(b, &g.GroupDocument); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := mergeProductList(bson.A{
+		bson.M{"id": "A", "productName": "A"},
+		bson.M{"id": "B", "productName": "B in the target"},
+		"not a product",
+	}, g)
+
+	var names []interface{}
+	for _, item := range merged {
+		switch v := item.(type) {
+		case bson.M:
+			names = append(names, v["productName"])
+		case bson.D:
+			name, _ := lookup(v, "productName")
+			names = append(names, name)
+		default:
+			names = append(names, v)
+		}
+	}
+	want := []interface{}{"A", "B from the bundle", "not a product", "C"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("merged = %v, want %v", names, want)
+	}
+}
+
+func TestImportEntries(t *testing.T) {
+	before := bson.M{
+		"_id": "g1",
+		"key": "G",
+		"productList": bson.A{
+			bson.M{"id": "A", "productName": "A"},
+			bson.M{"id": "B", "productName": "B"},
+			bson.M{"id": "D", "productName": "Dropped"},
+		},
+	}
+	after := bson.M{
+		"_id": "g1",
+		"key": "G",
+		"productList": bson.A{
+			bson.M{"id": "A", "productName": "A"},
+			bson.M{"id": "B", "productName": "B renamed"},
+			bson.M{"id": "C", "productName": "C"},
+		},
+	}
+
+	entries := importEntries(before, after)
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.ProductID+"|"+e.Target] = e.Action
+		if e.GroupKey != "G" {
+			t.Errorf("entry %+v has group %q, want G", e, e.GroupKey)
+		}
+	}
+	want := map[string]string{
+		"B|":       audit.ActionUpdate,
+		"C|":       audit.ActionCreate,
+		"|group:G": audit.ActionGroupImport,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+	group := entries[len(entries)-1]
+	if len(group.Changes) != 1 || group.Changes[0].Field != "productList" {
+		t.Errorf("group changes = %+v, want the productList with D dropped", group.Changes)
+	}
+
+	// A new group reports every product as created.
+	for _, e := range importEntries(nil, after)[:3] {
+		if e.Action != audit.ActionCreate {
+			t.Errorf("new group entry %+v, want %s", e, audit.ActionCreate)
+		}
+	}
+}
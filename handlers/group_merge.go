@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mergedProduct is a product a group merge moves.
+type mergedProduct struct {
+	ID          string `json:"id"`
+	ProductName string `json:"productName"`
+	InsurerCode string `json:"insurerCode"`
+}
+
+// mergeCollision is a moving product whose insurer and name the target
+// group already has.
+type mergeCollision struct {
+	mergedProduct
+	TargetID string `json:"targetId"`
+}
+
+// storedGroup is a group document both as stored and decoded.
+type storedGroup struct {
+	raw bson.M
+	database.GroupDocument
+}
+
+func (h *Handler) findGroup(c *fiber.Ctx, ctx context.Context, key string) (storedGroup, error) {
+	var g storedGroup
+	err := database.Breaker.Do(ctx, func() error {
+		raw, err := h.repo(c).FindOne(ctx, bson.M{"key": key}).Raw()
+		if err != nil {
+			return err
+		}
+		if err := bson.Unmarshal(raw, &g.raw); err != nil {
+			return err
+		}
+		return bson.Unmarshal(raw, &g.GroupDocument)
+	})
+	return g, err
+}
+
+// MergeGroup moves every product of a group into the target group and
+// deletes the emptied source, with an audit entry per product, in one
+// transaction where the deployment supports them. Products whose insurer
+// and name the target already has collide, and refuse the merge. With
+// ?dryRun=true the moves and collisions are only reported.
+func (h *Handler) MergeGroup(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	key, targetKey := c.Params("key"), c.Params("targetKey")
+	if key == targetKey {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_PARAMETER", "a group cannot be merged into itself")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	source, err := h.findGroup(c, ctx, key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return groupNotFound(c, key)
+	}
+	if err != nil {
+		return queryError(c, "finding group", err)
+	}
+	target, err := h.findGroup(c, ctx, targetKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "TARGET_GROUP_NOT_FOUND", "product group "+targetKey+" does not exist")
+	}
+	if err != nil {
+		return queryError(c, "finding group", err)
+	}
+
+	held := map[string]string{}
+	for _, item := range target.ProductList {
+		if !item.Malformed {
+			held[collisionKey(item)] = item.ProductID()
+		}
+	}
+	moves, collisions := []mergedProduct{}, []mergeCollision{}
+	for _, item := range source.ProductList {
+		if item.Malformed {
+			continue
+		}
+		p := mergedProduct{ID: item.ProductID(), ProductName: item.ProductName.String()}
+		if item.Insurer != nil {
+			p.InsurerCode = item.Insurer.InsurerCode.String()
+		}
+		moves = append(moves, p)
+		if id, ok := held[collisionKey(item)]; ok {
+			collisions = append(collisions, mergeCollision{mergedProduct: p, TargetID: id})
+		}
+	}
+
+	result := fiber.Map{"source": key, "target": targetKey, "products": moves, "collisions": collisions}
+	if dryRun(c) {
+		result["applied"] = false
+		return c.JSON(result)
+	}
+	if len(collisions) > 0 {
+		return apierror.SendDetails(c, fiber.StatusConflict, "MERGE_COLLISION",
+			fmt.Sprintf("%d products of %s collide with products of %s", len(collisions), key, targetKey), collisions)
+	}
+
+	list, _ := source.raw["productList"].(bson.A)
+	targetList, _ := target.raw["productList"].(bson.A)
+	requestID := middleware.RequestIDFrom(c)
+	var deleted, merged bson.M
+	err = database.Breaker.Do(ctx, func() error {
+		return database.WithTransaction(ctx, func(ctx context.Context) error {
+			// As in DeleteGroup, both writes only go ahead if neither
+			// group changed since it was read.
+			err := h.repo(c).FindOneAndUpdate(ctx,
+				bson.M{"_id": target.raw["_id"], "productList": bson.M{"$size": len(targetList)}},
+				bson.M{"$push": bson.M{"productList": bson.M{"$each": list}}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After),
+			).Decode(&merged)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return errGroupChanged
+			}
+			if err != nil {
+				return err
+			}
+			err = h.repo(c).DeleteGroup(ctx, bson.M{"_id": source.raw["_id"], "productList": bson.M{"$size": len(list)}}).Decode(&deleted)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return errGroupChanged
+			}
+			if err != nil {
+				return err
+			}
+			now := h.now().UTC()
+			for _, p := range moves {
+				err := audit.Record(ctx, tenant(c).Audit, audit.Entry{
+					Actor:     actor,
+					Action:    audit.ActionMove,
+					ProductID: p.ID,
+					GroupKey:  targetKey,
+					Changes:   []audit.Change{{Field: "productGroup.key", Old: key, New: targetKey}},
+					RequestID: requestID,
+					Timestamp: now,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	switch {
+	case errors.Is(err, errGroupChanged):
+		return apierror.Send(c, fiber.StatusConflict, "GROUP_CHANGED", "a product group changed during the merge; retry")
+	case mongo.IsDuplicateKeyError(err):
+		return apierror.Send(c, fiber.StatusConflict, "MERGE_COLLISION", "a product of "+key+" collides with a product of "+targetKey)
+	case err != nil:
+		return queryError(c, "merging groups", err)
+	}
+
+	h.invalidateCache(c)
+	h.syncFlat(c, deleted)
+	h.syncFlat(c, merged)
+	result["applied"] = true
+	return c.JSON(result)
+}
+
+// collisionKey is what must be unique among a group's products: the
+// insurer and the product name, compared as the unique index does.
+func collisionKey(item database.ProductDocument) string {
+	code := ""
+	if item.Insurer != nil {
+		code = strings.ToLower(item.Insurer.InsurerCode.String())
+	}
+	return code + "\x00" + database.NameKey(item.ProductName.String())
+}
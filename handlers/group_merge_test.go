@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mergeRepo holds groups by key. Its writes match nothing once changed
+// says a group changed since it was read: "target" fails the push and
+// "source" the delete.
+type mergeRepo struct {
+	*mocks.ProductRepository
+	groups  map[string]bson.M
+	changed string
+}
+
+func (r *mergeRepo) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	r.ProductRepository.FindOne(ctx, filter, opts...)
+	if key, ok := filter.(bson.M)["key"].(string); ok && r.groups[key] != nil {
+		return mongo.NewSingleResultFromDocument(r.groups[key], nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
+func (r *mergeRepo) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	r.ProductRepository.FindOneAndUpdate(ctx, filter, update, opts...)
+	if r.changed == "target" {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(r.groups["MOTOR-2"], nil, nil)
+}
+
+func (r *mergeRepo) DeleteGroup(ctx context.Context, filter bson.M) *mongo.SingleResult {
+	r.ProductRepository.DeleteGroup(ctx, filter)
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
+var (
+	mergeSourceID = primitive.NewObjectID()
+	mergeTargetID = primitive.NewObjectID()
+)
+
+// mergeGroups are MOTOR-1, with two products, and MOTOR-2, which has one
+// product of its own; collide makes that one MOTOR-1's first product
+// under another spelling.
+func mergeGroups(collide bool) map[string]bson.M {
+	name := "Motor Class 2"
+	if collide {
+		name = "  MOTOR   class 1 "
+	}
+	return map[string]bson.M{
+		"MOTOR-1": {"_id": mergeSourceID, "key": "MOTOR-1", "productList": bson.A{
+			bson.M{"id": "MT-001", "productName": "Motor Class 1", "insurer": bson.M{"insurerCode": "TIP"}},
+			bson.M{"id": "MT-002", "productName": "Motor Class 3", "insurer": bson.M{"insurerCode": "TIP"}},
+			"not a product",
+		}},
+		"MOTOR-2": {"_id": mergeTargetID, "key": "MOTOR-2", "productList": bson.A{
+			bson.M{"id": "MT-101", "productName": name, "insurer": bson.M{"insurerCode": "tip"}},
+		}},
+	}
+}
+
+func mergeRoutes(app *fiber.App, h *Handler) {
+	app.Post("/product-groups/:key/merge-into/:targetKey", h.MergeGroup)
+}
+
+type mergeResult struct {
+	Applied    bool             `json:"applied"`
+	Products   []mergedProduct  `json:"products"`
+	Collisions []mergeCollision `json:"collisions"`
+}
+
+func TestMergeGroupDryRun(t *testing.T) {
+	for _, collide := range []bool{false, true} {
+		repo := &mergeRepo{ProductRepository: &mocks.ProductRepository{}, groups: mergeGroups(collide)}
+		app := newTestApp(testConfig(), repo, mergeRoutes)
+		resp, body := do(t, app, fiber.MethodPost, "/product-groups/MOTOR-1/merge-into/MOTOR-2?dryRun=true", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("collide=%t: %d %s", collide, resp.StatusCode, body)
+		}
+		var got mergeResult
+		decode(t, body, &got)
+		want := mergeResult{Products: []mergedProduct{
+			{ID: "MT-001", ProductName: "Motor Class 1", InsurerCode: "TIP"},
+			{ID: "MT-002", ProductName: "Motor Class 3", InsurerCode: "TIP"},
+		}, Collisions: []mergeCollision{}}
+		if collide {
+			want.Collisions = []mergeCollision{{mergedProduct: want.Products[0], TargetID: "MT-101"}}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("collide=%t: dry run = %+v, want %+v", collide, got, want)
+		}
+		for _, method := range []string{"FindOneAndUpdate", "DeleteGroup"} {
+			if n := len(callsOf(repo.ProductRepository, method)); n != 0 {
+				t.Errorf("collide=%t: dry run made %d %s calls", collide, n, method)
+			}
+		}
+	}
+}
+
+func TestMergeGroupCollision(t *testing.T) {
+	repo := &mergeRepo{ProductRepository: &mocks.ProductRepository{}, groups: mergeGroups(true)}
+	app := newTestApp(testConfig(), repo, mergeRoutes)
+	resp, body := do(t, app, fiber.MethodPost, "/product-groups/MOTOR-1/merge-into/MOTOR-2", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(body) != "MERGE_COLLISION" {
+		t.Fatalf("%d %s, want 409 MERGE_COLLISION", resp.StatusCode, body)
+	}
+	var env struct {
+		Error struct {
+			Details []mergeCollision `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		t.Fatal(err)
+	}
+	if d := env.Error.Details; len(d) != 1 || d[0].ID != "MT-001" || d[0].TargetID != "MT-101" {
+		t.Errorf("collisions = %+v, want MT-001 against MT-101", d)
+	}
+	if n := len(callsOf(repo.ProductRepository, "FindOneAndUpdate")); n != 0 {
+		t.Errorf("%d writes despite the collision", n)
+	}
+}
+
+// TestMergeGroupSizeGuard checks that both writes are guarded by the size
+// of the group as read, and that a group changing in between makes the
+// merge a 409 to retry.
+func TestMergeGroupSizeGuard(t *testing.T) {
+	for _, changed := range []string{"target", "source"} {
+		t.Run(changed, func(t *testing.T) {
+			repo := &mergeRepo{ProductRepository: &mocks.ProductRepository{}, groups: mergeGroups(false), changed: changed}
+			app := newTestApp(testConfig(), repo, mergeRoutes)
+			resp, body := do(t, app, fiber.MethodPost, "/product-groups/MOTOR-1/merge-into/MOTOR-2", "")
+			if resp.StatusCode != http.StatusConflict || errorCode(body) != "GROUP_CHANGED" {
+				t.Fatalf("%d %s, want 409 GROUP_CHANGED", resp.StatusCode, body)
+			}
+
+			pushes := callsOf(repo.ProductRepository, "FindOneAndUpdate")
+			if len(pushes) != 1 {
+				t.Fatalf("%d pushes, want 1", len(pushes))
+			}
+			if want := (bson.M{"_id": mergeTargetID, "productList": bson.M{"$size": 1}}); !reflect.DeepEqual(pushes[0].Filter, want) {
+				t.Errorf("push filter = %v, want %v", pushes[0].Filter, want)
+			}
+			// The source's malformed entry moves too, so it counts.
+			moved := pushes[0].Update.(bson.M)["$push"].(bson.M)["productList"].(bson.M)["$each"].(bson.A)
+			if len(moved) != 3 {
+				t.Errorf("pushed %d entries, want all 3 of the source", len(moved))
+			}
+			deletes := callsOf(repo.ProductRepository, "DeleteGroup")
+			if changed == "target" {
+				if len(deletes) != 0 {
+					t.Errorf("the source was deleted after the push failed")
+				}
+				return
+			}
+			if want := (bson.M{"_id": mergeSourceID, "productList": bson.M{"$size": 3}}); len(deletes) != 1 || !reflect.DeepEqual(deletes[0].Filter, want) {
+				t.Errorf("delete calls = %+v, want one with filter %v", deletes, want)
+			}
+		})
+	}
+}
+
+func TestMergeGroupNotFound(t *testing.T) {
+	tests := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/product-groups/MOTOR-1/merge-into/MOTOR-1", http.StatusBadRequest, "INVALID_PARAMETER"},
+		{"/product-groups/MOTOR-9/merge-into/MOTOR-2", http.StatusNotFound, "PRODUCT_GROUP_NOT_FOUND"},
+		{"/product-groups/MOTOR-1/merge-into/MOTOR-9", http.StatusNotFound, "TARGET_GROUP_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		repo := &mergeRepo{ProductRepository: &mocks.ProductRepository{}, groups: mergeGroups(false)}
+		app := newTestApp(testConfig(), repo, mergeRoutes)
+		if resp, body := do(t, app, fiber.MethodPost, tt.target, ""); resp.StatusCode != tt.status || errorCode(body) != tt.code {
+			t.Errorf("%s = %d %s, want %d %s", tt.target, resp.StatusCode, body, tt.status, tt.code)
+		}
+	}
+}
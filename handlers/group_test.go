@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func groupRoutes(app *fiber.App, h *Handler) {
+	app.Get("/groups/:key", h.GetGroup)
+	app.Post("/groups", h.CreateGroup)
+	app.Put("/groups/:key", h.UpdateGroup)
+	app.Delete("/groups/:key", h.DeleteGroup)
+}
+
+// healthSummary is healthGroup as GroupSummaryPipeline projects it.
+func healthSummary() bson.M {
+	return bson.M{"key": "HEALTH-PLUS", "name": "Health Plus", "productType": bson.M{"key": "HEALTH", "name": "ประกันสุขภาพ"}, "productCount": 2}
+}
+
+func TestCreateGroup(t *testing.T) {
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(testConfig(), repo, groupRoutes)
+	resp, body := do(t, app, fiber.MethodPost, "/groups", `{"key": "LIFE-1", "name": "Vie Se\u0301nior", "productType": {"key": "LIFE", "name": "Life"}}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var got GroupSummary
+	decode(t, body, &got)
+	want := GroupSummary{Key: "LIFE-1", Name: "Vie S\u00e9nior", ProductType: ProductType{Key: "LIFE", Name: "Life"}}
+	if got != want {
+		t.Errorf("created = %+v, want %+v", got, want)
+	}
+	creates := callsOf(repo, "CreateGroup")
+	if len(creates) != 1 {
+		t.Fatalf("%d creates, want 1", len(creates))
+	}
+	group := creates[0].Update.(bson.M)
+	if group["keyLower"] != "life-1" || group["productType"].(bson.M)["keyLower"] != "life" || !reflect.DeepEqual(group["productList"], bson.A{}) {
+		t.Errorf("created document = %v, want its shadows and an empty productList", group)
+	}
+
+	tests := []struct {
+		name, body string
+		repo       *mocks.ProductRepository
+		want       int
+		code       string
+	}{
+		{"exists", `{"key": "HEALTH-PLUS", "name": "Health Plus"}`, &mocks.ProductRepository{FindOneDoc: healthGroup()}, http.StatusConflict, "GROUP_EXISTS"},
+		{"malformed key", `{"key": "health plus", "name": "Health Plus"}`, &mocks.ProductRepository{}, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"no name", `{"key": "LIFE-1"}`, &mocks.ProductRepository{}, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, groupRoutes)
+			resp, body := do(t, app, fiber.MethodPost, "/groups", tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestUpdateGroup(t *testing.T) {
+	repo := &mocks.ProductRepository{FindOneDoc: healthGroup(), AggregateDocs: []interface{}{healthSummary()}}
+	app := newTestApp(testConfig(), repo, groupRoutes)
+	resp, body := do(t, app, fiber.MethodPut, "/groups/HEALTH-PLUS", `{"key": "HEALTH-PLUS", "name": "Sante\u0301 Plus"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var got GroupSummary
+	decode(t, body, &got)
+	if got.Key != "HEALTH-PLUS" || got.ProductCount != 2 {
+		t.Errorf("updated = %+v, want the group's summary", got)
+	}
+	updates := callsOf(repo, "FindOneAndUpdate")
+	want := bson.M{"$set": bson.M{"name": "Sant\u00e9 Plus"}}
+	if len(updates) != 1 || !reflect.DeepEqual(updates[0].Filter, bson.M{"key": "HEALTH-PLUS"}) || !reflect.DeepEqual(updates[0].Update, want) {
+		t.Errorf("updates = %+v, want only the name set", updates)
+	}
+
+	tests := []struct {
+		name, target, body string
+		repo               *mocks.ProductRepository
+		want               int
+		code               string
+	}{
+		{"other key", "/groups/HEALTH-PLUS", `{"key": "HEALTH-1", "name": "Health"}`, &mocks.ProductRepository{}, http.StatusBadRequest, "KEY_IMMUTABLE"},
+		{"no name", "/groups/HEALTH-PLUS", `{}`, &mocks.ProductRepository{}, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"unknown group", "/groups/LIFE-1", `{"name": "Life"}`, &mocks.ProductRepository{}, http.StatusNotFound, "PRODUCT_GROUP_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, groupRoutes)
+			resp, body := do(t, app, fiber.MethodPut, tt.target, tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		repo := &mocks.ProductRepository{FindOneDoc: bson.M{"key": "LIFE-1", "productList": bson.A{}}}
+		app := newTestApp(testConfig(), repo, groupRoutes)
+		if resp, body := do(t, app, fiber.MethodDelete, "/groups/LIFE-1", ""); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%d %s, want 204", resp.StatusCode, body)
+		}
+		// Emptiness is a condition of the delete itself.
+		deletes := callsOf(repo, "DeleteGroup")
+		want := bson.M{"key": "LIFE-1", "productList.0": bson.M{"$exists": false}}
+		if len(deletes) != 1 || !reflect.DeepEqual(deletes[0].Filter, want) {
+			t.Errorf("deletes = %+v, want %v", deletes, want)
+		}
+	})
+
+	t.Run("not empty", func(t *testing.T) {
+		repo := &mocks.ProductRepository{AggregateDocs: []interface{}{healthSummary()}}
+		app := newTestApp(testConfig(), repo, groupRoutes)
+		resp, body := do(t, app, fiber.MethodDelete, "/groups/HEALTH-PLUS", "")
+		if resp.StatusCode != http.StatusConflict || errorCode(body) != "GROUP_NOT_EMPTY" {
+			t.Fatalf("%d %s, want 409 GROUP_NOT_EMPTY", resp.StatusCode, body)
+		}
+		var env struct {
+			Error struct {
+				Details struct {
+					ProductCount int `json:"productCount"`
+				}
+			}
+		}
+		decode(t, body, &env)
+		if env.Error.Details.ProductCount != 2 {
+			t.Errorf("details = %+v, want productCount 2", env.Error.Details)
+		}
+	})
+
+	t.Run("move", func(t *testing.T) {
+		repo := &mocks.ProductRepository{FindOneDoc: healthGroup()}
+		app := newTestApp(testConfig(), repo, groupRoutes)
+		resp, body := do(t, app, fiber.MethodDelete, "/groups/HEALTH-PLUS?moveTo=HEALTH-1", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%d %s, want 200", resp.StatusCode, body)
+		}
+		var got struct {
+			Moved  int    `json:"moved"`
+			MoveTo string `json:"moveTo"`
+		}
+		decode(t, body, &got)
+		if got.Moved != 2 || got.MoveTo != "HEALTH-1" {
+			t.Errorf("moved = %+v, want 2 products to HEALTH-1", got)
+		}
+		pushes := callsOf(repo, "FindOneAndUpdate")
+		if len(pushes) != 1 || !reflect.DeepEqual(pushes[0].Filter, bson.M{"key": "HEALTH-1"}) {
+			t.Fatalf("pushes = %+v, want one into HEALTH-1", pushes)
+		}
+		var ids []string
+		for _, item := range pushes[0].Update.(bson.M)["$push"].(bson.M)["productList"].(bson.M)["$each"].(bson.A) {
+			ids = append(ids, item.(bson.M)["id"].(string))
+		}
+		if want := []string{"HP-002", "HP-001"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("pushed %v, want the source's products %v", ids, want)
+		}
+		// The source is deleted only if it still has the products moved.
+		deletes := callsOf(repo, "DeleteGroup")
+		if len(deletes) != 1 || !reflect.DeepEqual(deletes[0].Filter.(bson.M)["productList"], bson.M{"$size": 2}) {
+			t.Errorf("deletes = %+v, want one guarded by the moved count", deletes)
+		}
+	})
+
+	tests := []struct {
+		name, target string
+		repo         *mocks.ProductRepository
+		want         int
+		code         string
+	}{
+		{"unknown group", "/groups/LIFE-1", &mocks.ProductRepository{}, http.StatusNotFound, "PRODUCT_GROUP_NOT_FOUND"},
+		{"move into itself", "/groups/HEALTH-PLUS?moveTo=HEALTH-PLUS", &mocks.ProductRepository{}, http.StatusBadRequest, "INVALID_PARAMETER"},
+		{"move into unknown group", "/groups/HEALTH-PLUS?moveTo=LIFE-1", &mocks.ProductRepository{}, http.StatusNotFound, "TARGET_GROUP_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, groupRoutes)
+			resp, body := do(t, app, fiber.MethodDelete, tt.target, "")
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+			if calls := callsOf(tt.repo, "DeleteGroup"); tt.code != "PRODUCT_GROUP_NOT_FOUND" && len(calls) != 0 {
+				t.Errorf("deleted despite %s: %+v", tt.code, calls)
+			}
+		})
+	}
+}
+
+// TestGetProductsKnownGroup checks that the listing's group filter names an
+// existing group, unless it is a pattern.
+func TestGetProductsKnownGroup(t *testing.T) {
+	tests := []struct {
+		name, target string
+		repo         *mocks.ProductRepository
+		want         int
+	}{
+		{"unknown", "/products?group=LIFE-1", &mocks.ProductRepository{}, http.StatusBadRequest},
+		{"known", "/products?group=HEALTH-PLUS", &mocks.ProductRepository{FindOneDoc: healthGroup(), FindDocs: []interface{}{healthGroup()}}, http.StatusOK},
+		{"pattern", "/products?group=LIFE-*", &mocks.ProductRepository{}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, listingRoutes)
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if resp.StatusCode != tt.want {
+				t.Fatalf("%d %s, want %d", resp.StatusCode, body, tt.want)
+			}
+			if tt.want == http.StatusBadRequest {
+				if errorCode(body) != "UNKNOWN_GROUP" {
+					t.Errorf("code = %s, want UNKNOWN_GROUP", errorCode(body))
+				}
+				if finds := callsOf(tt.repo, "Find"); len(finds) != 0 {
+					t.Errorf("an unknown group was listed: %+v", finds)
+				}
+			}
+			if tt.name == "pattern" && len(callsOf(tt.repo, "FindOne")) != 0 {
+				t.Error("a pattern was looked up as a key")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/cache"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// Handler holds the dependencies shared by the HTTP handlers.
+type Handler struct {
+	cfg         config.Config
+	repos       func(tenant string) database.ProductRepository
+	logger      *slog.Logger
+	now         func() time.Time
+	maintenance *middleware.Maintenance
+	// cache is nil when response caching is disabled.
+	cache  cache.Cache
+	flight singleflight.Group
+	pages  pageLoads
+	counts countCache
+	terms  termCache
+	ready  atomic.Bool
+}
+
+// Deps are the collaborators a Handler is built from. main wires the real
+// ones; Logger and Clock default to slog.Default and time.Now.
+type Deps struct {
+	// Repos resolves a tenant name to its product repository; main passes
+	// database.Repository.
+	Repos       func(tenant string) database.ProductRepository
+	Logger      *slog.Logger
+	Clock       func() time.Time
+	Maintenance *middleware.Maintenance
+	// Cache is nil when response caching is disabled.
+	Cache cache.Cache
+}
+
+func New(cfg config.Config, deps Deps) *Handler {
+	h := &Handler{
+		cfg:         cfg,
+		repos:       deps.Repos,
+		logger:      deps.Logger,
+		now:         deps.Clock,
+		maintenance: deps.Maintenance,
+		cache:       deps.Cache,
+	}
+	if h.logger == nil {
+		h.logger = slog.Default()
+	}
+	if h.now == nil {
+		h.now = time.Now
+	}
+	return h
+}
+
+// repo returns the product repository of the request's tenant.
+func (h *Handler) repo(c *fiber.Ctx) database.ProductRepository {
+	return h.repos(tenant(c).Name)
+}
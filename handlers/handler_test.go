@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// testAPIKey authenticates test requests as the admin "tester".
+const testAPIKey = "test-key"
+
+// testNow is the handlers' clock in tests.
+var testNow = time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	// Scripted failures must not open the shared breaker for later tests.
+	database.ConfigureBreaker(config.BreakerConfig{FailureThreshold: math.MaxInt32, Cooldown: time.Second, SuccessThreshold: 1})
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func testConfig() config.Config {
+	return config.Config{
+		HTTP: config.HTTPConfig{
+			QueryTimeout:    5 * time.Second,
+			MaxQueryTimeout: 10 * time.Second,
+			CountCacheTTL:   time.Minute,
+			ExportTimeout:   time.Minute,
+		},
+		Pagination: config.PaginationConfig{DefaultLimit: 20, MaxLimit: 100},
+		Search:     config.SearchConfig{MaxLength: 100},
+		Mongo:      config.MongoConfig{MaxTime: 5 * time.Second, ExportMaxTime: time.Minute, Collation: "simple"},
+	}
+}
+
+// newTestApp serves the routes registered by setup on a Handler whose
+// every tenant is backed by repo. Requests run as the tenant "test" and,
+// with testAPIKey, as the admin "tester".
+func newTestApp(cfg config.Config, repo database.ProductRepository, setup func(app *fiber.App, h *Handler)) *fiber.App {
+	h := New(cfg, Deps{
+		Repos:  func(string) database.ProductRepository { return repo },
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:  func() time.Time { return testNow },
+	})
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.ErrorHandler})
+	app.Use(middleware.Auth(middleware.AuthConfig{APIKeys: []middleware.APIKey{
+		{Name: "tester", Key: testAPIKey, Roles: []string{middleware.RoleAdmin}},
+	}}))
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(tenantKey, &database.Tenant{Name: "test"})
+		return c.Next()
+	})
+	setup(app, h)
+	return app
+}
+
+// do runs an authenticated request and returns the response and its body.
+func do(t *testing.T, app *fiber.App, method, target, body string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set("X-API-Key", testAPIKey)
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s %s: %v", method, target, err)
+	}
+	return resp, b
+}
+
+// decode unmarshals a JSON response body into v.
+func decode(t *testing.T, body []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+}
+
+// errorCode is the code of an error envelope, or "" for other bodies.
+func errorCode(body []byte) string {
+	var env apierror.Body
+	if json.Unmarshal(body, &env) != nil {
+		return ""
+	}
+	return env.Error.Code
+}
+
+// callsOf returns the recorded calls of repo to method.
+func callsOf(repo *mocks.ProductRepository, method string) []mocks.Call {
+	var calls []mocks.Call
+	for _, call := range repo.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// fuzzSeeds are the seed corpus of the parser fuzz targets: regex
+// metacharacters, Thai text in both normalization forms, and long
+// strings.
+func fuzzSeeds() []string {
+	return []string{
+		"",
+		"MTR-001",
+		`.*+?^${}()|[]\`,
+		`(a+)+$`,
+		`\Q*\E`,
+		"ประกันสุขภาพ",
+		"ประกันภัยรถยนต์ชั้น ๑ ำ",
+		"ํา ำ",
+		"*ประกัน?*",
+		`-"ทิพย ประกัน" vir -`,
+		"\xff\xfe invalid",
+		strings.Repeat("a*", 40),
+		strings.Repeat("ก", 5000),
+		strings.Repeat("(", 200),
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+// HeadProducts answers HEAD /products with the headers GET sends for the
+// same listing: X-Total-Count, X-Total-Pages, Link, and an ETag and
+// Last-Modified taken from the count and the latest update. It takes the
+// listing's parameters but runs only the count and the latest-update
+// lookup, so no products are fetched or mapped; an unfiltered count is the
+// estimate GET uses too. Lookups by ?ids= and unflattened listings are
+// answered as by GET, without the body.
+func (h *Handler) HeadProducts(c *fiber.Ctx) error {
+	if query(c, "ids") != "" || query(c, "flatten") == "false" {
+		return h.GetProducts(c)
+	}
+	params, ok, err := h.listParams(c)
+	if !ok {
+		return err
+	}
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
+	}
+	if f := query(c, "flatten", "true"); f != "true" {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_FLATTEN", "flatten must be true or false")
+	}
+	builder, err := productFilterBuilder(params)
+	if err != nil {
+		return filterFailed(c, err)
+	}
+	filter := builder.Build()
+	if ok, err := h.knownGroup(c, params.Group); !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var (
+		count     totalCount
+		updatedAt time.Time
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		count, err = h.countProducts(c, gctx, filter)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		updatedAt, err = h.latestUpdate(c, gctx, filter)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return queryError(c, "counting products", err)
+	}
+
+	// The page GET would send holds what is left of the total after skip.
+	n := count.N - paging.Skip()
+	switch {
+	case n > int64(paging.Limit):
+		n = int64(paging.Limit)
+	case n < 0:
+		n = 0
+	}
+	setListingHeaders(c, paging, count, updatedAt, int(n))
+	if c.Fresh() {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// latestUpdate is the latest updatedAt of a product in the group
+// documents, or products_flat documents, matching filter; zero when there
+// is none. It reads the one document first in updatedAt order, so on
+// group documents it may be the update of a product the filter does not
+// match, which only makes the validators change more often than needed.
+func (h *Handler) latestUpdate(c *fiber.Ctx, ctx context.Context, filter bson.M) (time.Time, error) {
+	var latest time.Time
+	err := database.Breaker.Do(ctx, func() error {
+		if h.cfg.Mongo.ListSource == "flat" {
+			var docs []struct {
+				UpdatedAt database.LooseTime `bson:"updatedAt"`
+			}
+			cursor, err := tenant(c).FlatList.Find(ctx, database.FlatFilter(filter), options.Find().
+				SetSort(bson.D{{Key: "updatedAt", Value: -1}}).
+				SetLimit(1).
+				SetProjection(bson.M{"updatedAt": 1}).
+				SetMaxTime(h.maxTime(c)))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			if err := cursor.All(ctx, &docs); err != nil {
+				return err
+			}
+			if len(docs) > 0 {
+				latest = docs[0].UpdatedAt.Time
+			}
+			return nil
+		}
+
+		var groups []database.GroupDocument
+		cursor, err := h.repo(c).Find(ctx, filter, options.Find().
+			SetSort(bson.D{{Key: "productList.updatedAt", Value: -1}}).
+			SetLimit(1).
+			SetProjection(bson.M{"productList.updatedAt": 1}).
+			SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		if err := cursor.All(ctx, &groups); err != nil {
+			return err
+		}
+		for _, group := range groups {
+			for _, item := range group.ProductList {
+				if item.UpdatedAt.After(latest) {
+					latest = item.UpdatedAt.Time
+				}
+			}
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// setListingHeaders sets the headers GET and HEAD /products share for page
+// p of a listing holding n entries: the totals, the Link header, and the
+// validators, a weak ETag of the count and latest update and a
+// Last-Modified unless nothing has an update time.
+func setListingHeaders(c *fiber.Ctx, p Pagination, count totalCount, updatedAt time.Time, n int) {
+	setTotalHeaders(c, count.N, p.Limit)
+	c.Set(fiber.HeaderETag, `W/"`+strconv.FormatInt(count.N, 10)+"-"+strconv.FormatInt(updatedAt.UnixMilli(), 10)+`"`)
+	if !updatedAt.IsZero() {
+		c.Set(fiber.HeaderLastModified, updatedAt.UTC().Format(http.TimeFormat))
+	}
+	total := count.N
+	if !count.Exact {
+		total = -1
+	}
+	setPageLinks(c, p, total, n)
+}
+
+// setListingHeadersFromBody is setListingHeaders for a listing envelope
+// already encoded, as cached pages are.
+func setListingHeadersFromBody(c *fiber.Ctx, p Pagination, body []byte) {
+	var page struct {
+		TotalCount      int64             `json:"totalCount"`
+		TotalCountExact bool              `json:"totalCountExact"`
+		LastModified    time.Time         `json:"lastModified"`
+		Data            []json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(body, &page) != nil {
+		return
+	}
+	setListingHeaders(c, p, totalCount{N: page.TotalCount, Exact: page.TotalCountExact}, page.LastModified, len(page.Data))
+}
+
+// setTotalHeaders sets X-Total-Count and X-Total-Pages for a listing of
+// total entries in pages of limit.
+func setTotalHeaders(c *fiber.Ctx, total int64, limit int) {
+	pages := (total + int64(limit) - 1) / int64(limit)
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	c.Set("X-Total-Pages", strconv.FormatInt(pages, 10))
+}
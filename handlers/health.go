@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetReady flips the readiness reported by /readyz. The service starts not
+// ready and becomes ready once its dependencies are connected; it goes back
+// to not ready when shutdown begins so load balancers stop routing to it.
+func (h *Handler) SetReady(r bool) {
+	h.ready.Store(r)
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func (h *Handler) Liveness(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// Readiness reports whether the service should receive traffic. It is not
+// ready while in maintenance, even though liveness keeps reporting ok.
+func (h *Handler) Readiness(c *fiber.Ctx) error {
+	if !h.ready.Load() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "not ready"})
+	}
+	if h.maintenance.Active() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "maintenance"})
+	}
+	return c.JSON(fiber.Map{"status": "ready"})
+}
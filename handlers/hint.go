@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// listingHint picks the index to force for a listing: the admin-only ?hint=
+// parameter, else the configured hint for the filter's shape, else none.
+// It writes the error response itself when ok is false.
+func (h *Handler) listingHint(c *fiber.Ctx, filter bson.M) (hint string, ok bool, err error) {
+	requested := query(c, "hint")
+	if requested != "" {
+		if p := middleware.PrincipalFrom(c); p != nil && !middleware.HasPermission(p.Roles, middleware.PermAdmin) {
+			return "", false, apierror.Send(c, fiber.StatusForbidden, "FORBIDDEN", "hint requires the admin role")
+		}
+	}
+	hint = requested
+	if hint == "" {
+		hint = h.cfg.Mongo.IndexHints[database.FilterShape(filter)]
+	}
+	if hint == "" {
+		database.ObserveHint(tenant(c).Name, false)
+		return "", true, nil
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+	exists, err := database.HasIndex(ctx, h.listCollection(c), hint)
+	if err != nil {
+		return "", false, queryError(c, "listing indexes", err)
+	}
+	if !exists {
+		if requested != "" {
+			return "", false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_HINT", "index "+hint+" does not exist")
+		}
+		h.logger.Warn("configured index hint does not exist; querying without it", "hint", hint)
+		database.ObserveHint(tenant(c).Name, false)
+		return "", true, nil
+	}
+	database.ObserveHint(tenant(c).Name, true)
+	return hint, true, nil
+}
+
+// listCollection is the collection listings read from.
+func (h *Handler) listCollection(c *fiber.Ctx) *mongo.Collection {
+	if h.cfg.Mongo.ListSource == "flat" {
+		return tenant(c).FlatList
+	}
+	return tenant(c).List
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyStep is one change between two versions of a product.
+type historyStep struct {
+	Version   int       `json:"version"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Timestamp time.Time `json:"timestamp"`
+	GroupKey  string    `json:"groupKey"`
+	Fields    []string  `json:"fields"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// GetProductHistoryDiff diffs two versions of a product. Versions are
+// numbered from 1 in the order of the product's audit entries, the create
+// being version 1; each version is rebuilt from the entries' changes,
+// since the audit log is the history kept. Products written before the
+// audit log have no create entry: complete is false for them, and their
+// versions only show what the log recorded on top of the current product.
+func (h *Handler) GetProductHistoryDiff(c *fiber.Ctx) error {
+	id := c.Params("id")
+	from, ok, err := versionParam(c, "from")
+	if !ok {
+		return err
+	}
+	to, ok, err := versionParam(c, "to")
+	if !ok {
+		return err
+	}
+	if from >= to {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_VERSION_RANGE", "from must be an earlier version than to")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var entries []audit.Entry
+	err = database.Breaker.Do(ctx, func() error {
+		// Versions are rebuilt back from the current product, so every
+		// entry is read, not only those up to to.
+		cursor, err := tenant(c).Audit.Find(ctx, bson.M{"productId": id},
+			options.Find().
+				SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+				SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &entries)
+	})
+	if err != nil {
+		return queryError(c, "finding product history", err)
+	}
+	for _, v := range []int{from, to} {
+		if v > len(entries) {
+			return apierror.SendDetails(c, fiber.StatusNotFound, "VERSION_NOT_FOUND",
+				fmt.Sprintf("product %s has no version %d", id, v), fiber.Map{"version": v, "versions": len(entries)})
+		}
+	}
+
+	var current bson.M
+	err = database.Breaker.Do(ctx, func() error {
+		var group bson.M
+		err := h.repo(c).FindOne(ctx, database.ItemFilter(id),
+			options.FindOne().SetProjection(bson.M{"productList.$": 1}).SetMaxTime(h.maxTime(c)),
+		).Decode(&group)
+		current = findItem(group, id)
+		return err
+	})
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return queryError(c, "finding product", err)
+	}
+	before, after, err := replayVersions(entries, current, from, to)
+	if err != nil {
+		return apierror.Internal(c, "rebuilding product version", err)
+	}
+
+	steps := []historyStep{}
+	for i, entry := range entries[from:to] {
+		fields := make([]string, len(entry.Changes))
+		for j, ch := range entry.Changes {
+			fields[j] = ch.Field
+		}
+		steps = append(steps, historyStep{
+			Version:   from + 1 + i,
+			Action:    entry.Action,
+			Actor:     entry.Actor,
+			Timestamp: entry.Timestamp,
+			GroupKey:  entry.GroupKey,
+			Fields:    fields,
+			RequestID: entry.RequestID,
+		})
+	}
+
+	result := fiber.Map{
+		"id":       id,
+		"from":     from,
+		"to":       to,
+		"diff":     diffProducts(before, after),
+		"changes":  steps,
+		"complete": entries[0].Action == audit.ActionCreate,
+	}
+	if before.ProductGroup.Key != after.ProductGroup.Key {
+		result["groupChange"] = fiber.Map{"from": before.ProductGroup.Key, "to": after.ProductGroup.Key}
+	}
+	return c.JSON(result)
+}
+
+// replayVersions rebuilds versions from and to of a product from its audit
+// entries, oldest first. Given the current product it walks back from it,
+// undoing the entries newest first, so fields older than the log are kept;
+// a product that no longer exists is replayed onto an empty one instead.
+func replayVersions(entries []audit.Entry, current bson.M, from, to int) (before, after Product, err error) {
+	rebuild := func(version int, snapshot bson.M) error {
+		p, err := snapshotProduct(snapshot, entries[version-1].GroupKey)
+		if err != nil {
+			return err
+		}
+		switch version {
+		case from:
+			before = p
+		case to:
+			after = p
+		}
+		return nil
+	}
+
+	if current == nil {
+		snapshot := bson.M{}
+		for i := 0; i < to; i++ {
+			audit.Apply(snapshot, entries[i].Changes)
+			if v := i + 1; v == from || v == to {
+				if err := rebuild(v, snapshot); err != nil {
+					return before, after, err
+				}
+			}
+		}
+		return before, after, nil
+	}
+
+	// findItem's result belongs to the decoded group; undo on a copy.
+	raw, err := bson.Marshal(current)
+	if err != nil {
+		return before, after, err
+	}
+	snapshot := bson.M{}
+	if err := bson.Unmarshal(raw, &snapshot); err != nil {
+		return before, after, err
+	}
+	for v := len(entries); v >= from; v-- {
+		if v == from || v == to {
+			if err := rebuild(v, snapshot); err != nil {
+				return before, after, err
+			}
+		}
+		audit.Revert(snapshot, entries[v-1].Changes)
+	}
+	return before, after, nil
+}
+
+// versionParam reads a required, positive version number.
+func versionParam(c *fiber.Ctx, key string) (int, bool, error) {
+	v, err := strconv.Atoi(query(c, key))
+	if err != nil || v < 1 {
+		return 0, false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_VERSION", key+" must be a version number from 1")
+	}
+	return v, true, nil
+}
+
+// snapshotProduct maps a replayed productList item, in the group the
+// entry recorded, to a Product. Only the group key is known from the log.
+func snapshotProduct(snapshot bson.M, groupKey string) (Product, error) {
+	raw, err := bson.Marshal(snapshot)
+	if err != nil {
+		return Product{}, err
+	}
+	// As a whole document the item would decode as malformed: its decoder
+	// expects to be a productList entry.
+	var item database.ProductDocument
+	if err := item.UnmarshalBSONValue(bsontype.EmbeddedDocument, raw); err != nil {
+		return Product{}, err
+	}
+	return mapProduct(database.GroupDocument{Key: database.LooseString(groupKey)}, item).withBrokers(), nil
+}
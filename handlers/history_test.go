@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/audit"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// historyItem is a productList entry as the writes store it.
+func historyItem(name string, status ProductStatus) bson.M {
+	return bson.M{
+		"id":            "HP-001",
+		"productName":   name,
+		"insurer":       bson.M{"insurerCode": "TIP", "insurerName": "ทิพยประกันภัย"},
+		"brokers":       bson.A{bson.M{"key": "BROKER-ONLINE", "channelName": "Online"}},
+		"productStatus": string(status),
+	}
+}
+
+// loggedHistory is HP-001 created in HEALTH-PLUS, renamed and activated,
+// then moved into HEALTH-2; the current product is the end of it.
+func loggedHistory() ([]audit.Entry, bson.M) {
+	v1, v2 := historyItem("Health", StatusDraft), historyItem("Health Plus", StatusActive)
+	return []audit.Entry{
+		{Action: audit.ActionCreate, GroupKey: "HEALTH-PLUS", Changes: audit.Diff(nil, v1)},
+		{Action: audit.ActionUpdate, GroupKey: "HEALTH-PLUS", Changes: audit.Diff(v1, v2)},
+		{Action: audit.ActionMove, GroupKey: "HEALTH-2", Changes: []audit.Change{{Field: "productGroup.key", Old: "HEALTH-PLUS", New: "HEALTH-2"}}},
+	}, v2
+}
+
+func TestReplayVersions(t *testing.T) {
+	entries, current := loggedHistory()
+	type version struct {
+		name   string
+		status ProductStatus
+		group  string
+	}
+	want := map[int]version{
+		1: {"Health", StatusDraft, "HEALTH-PLUS"},
+		2: {"Health Plus", StatusActive, "HEALTH-PLUS"},
+		3: {"Health Plus", StatusActive, "HEALTH-2"},
+	}
+	for _, seed := range []struct {
+		name    string
+		current bson.M
+	}{{"from the current product", current}, {"from an empty product", nil}} {
+		for _, r := range [][2]int{{1, 2}, {1, 3}, {2, 3}} {
+			before, after, err := replayVersions(entries, seed.current, r[0], r[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for v, p := range map[int]Product{r[0]: before, r[1]: after} {
+				got := version{p.ProductName, p.Status, p.ProductGroup.Key}
+				if got != want[v] {
+					t.Errorf("%s, %d..%d: version %d = %+v, want %+v", seed.name, r[0], r[1], v, got, want[v])
+				}
+				if p.Insurer.InsurerCode != "TIP" || len(p.Brokers) != 1 {
+					t.Errorf("%s: version %d lost the insurer or brokers: %+v", seed.name, v, p)
+				}
+			}
+		}
+	}
+	// Replaying must not touch the stored product.
+	if current["productName"] != "Health Plus" || current["productStatus"] != "ACTIVE" {
+		t.Errorf("current product changed to %v", current)
+	}
+}
+
+// TestReplayVersionsPreAudit replays a product written before the audit
+// log: its insurer and brokers were never logged, only two later updates.
+func TestReplayVersionsPreAudit(t *testing.T) {
+	fields := historyItem("Legacy", StatusDraft)
+	v1, v2 := historyItem("Health Plus", StatusDraft), historyItem("Health Plus", StatusActive)
+	entries := []audit.Entry{
+		// The first update's before is the stored document, whose insurer
+		// and brokers it left alone, as audit.Diff reports only changes.
+		{Action: audit.ActionUpdate, GroupKey: "HEALTH-PLUS", Changes: audit.Diff(fields, v1)},
+		{Action: audit.ActionUpdate, GroupKey: "HEALTH-PLUS", Changes: audit.Diff(v1, v2)},
+	}
+
+	before, after, err := replayVersions(entries, v2, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.ProductName != "Health Plus" || before.Status != StatusDraft || after.Status != StatusActive {
+		t.Errorf("versions = %+v, %+v", before, after)
+	}
+	if before.Insurer.InsurerCode != "TIP" || len(before.Brokers) != 1 {
+		t.Errorf("version 1 = %+v, want the insurer and brokers the log never recorded", before)
+	}
+
+	// Replayed onto nothing, the same history loses them.
+	before, _, err = replayVersions(entries, nil, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Insurer.InsurerCode != "" || len(before.Brokers) != 0 {
+		t.Errorf("replayed from empty = %+v, want only the logged fields", before)
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxLookupIDs caps ?ids= on the product listing.
+const maxLookupIDs = 100
+
+// productsByID answers GET /products?ids=: exactly the listed products, in
+// the order asked for, found with a single aggregation. The other listing
+// parameters do not apply. Ids no product has are listed in notFound.
+func (h *Handler) productsByID(c *fiber.Ctx, raw string) error {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(raw, ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxLookupIDs {
+		return apierror.Send(c, fiber.StatusBadRequest, "TOO_MANY_IDS",
+			fmt.Sprintf("ids must list at most %d products, got %d", maxLookupIDs, len(ids)))
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	pipeline := append(database.FlattenStages(database.ItemsFilter(ids)),
+		bson.D{{Key: "$project", Value: database.ProductProjection}})
+	var found []Product
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &found)
+	})
+	if err != nil {
+		return queryError(c, "finding products", err)
+	}
+
+	byID := make(map[string]Product, len(found))
+	for _, p := range found {
+		if _, ok := byID[p.ID]; !ok {
+			byID[p.ID] = p.withBrokers()
+		}
+	}
+	products, notFound := make([]Product, 0, len(ids)), []string{}
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			products = append(products, p)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	// The lookup is a single page of the products found.
+	setTotalHeaders(c, int64(len(products)), max(len(products), 1))
+	return c.JSON(fiber.Map{"data": products, "notFound": notFound})
+}
@@ -0,0 +1,53 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestIntegrationProductsByID looks up fixtures across groups together
+// with a product known only by its ObjectID.
+func TestIntegrationProductsByID(t *testing.T) {
+	app := integrationApp(t, nil)
+	oid := primitive.NewObjectID()
+	_, err := database.DefaultTenant().Products.InsertOne(context.Background(), bson.M{
+		"key":         "LEGACY",
+		"productList": bson.A{bson.M{"_id": oid, "productName": "Legacy Plan", "productStatus": "ACTIVE"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, out := call(t, app, fiber.MethodGet, "/products?ids=TW-002,"+oid.Hex()+",NOPE,HP-001", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, out)
+	}
+	var lookup struct {
+		Data     []handlers.Product
+		NotFound []string
+	}
+	if err := json.Unmarshal(out, &lookup); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range lookup.Data {
+		got = append(got, p.ID+" "+p.ProductGroup.Key)
+	}
+	if want := []string{"TW-002 TRAVEL-WORLD", oid.Hex() + " LEGACY", "HP-001 HEALTH-PLUS"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("data = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(lookup.NotFound, []string{"NOPE"}) {
+		t.Errorf("notFound = %v, want [NOPE]", lookup.NotFound)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestProductsByID looks up products in an order of its own, one of them
+// asked for twice, one twice in the results and one that does not exist.
+func TestProductsByID(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{
+		bson.M{"id": "HP-001", "productName": "ประกันสุขภาพ เหมาจ่าย", "status": "ACTIVE"},
+		bson.M{"id": "HP-002", "productName": "Health Plus Family", "status": "DRAFT"},
+		bson.M{"id": "HP-001", "productName": "a second HP-001", "status": "ACTIVE"},
+	}}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?ids=HP-002,%20HP-404,HP-001,HP-002,&status=RETIRED&limit=1", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	var out struct {
+		Data     []Product
+		NotFound []string
+	}
+	decode(t, body, &out)
+	var ids []string
+	for _, p := range out.Data {
+		ids = append(ids, p.ID+" "+p.ProductName)
+		if p.Brokers == nil {
+			t.Errorf("%s brokers encoded as null", p.ID)
+		}
+	}
+	if want := []string{"HP-002 Health Plus Family", "HP-001 ประกันสุขภาพ เหมาจ่าย"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("data = %v, want %v", ids, want)
+	}
+	if want := []string{"HP-404"}; !reflect.DeepEqual(out.NotFound, want) {
+		t.Errorf("notFound = %v, want %v", out.NotFound, want)
+	}
+
+	// One aggregation over the listed ids, ignoring the other parameters.
+	calls := repo.Calls()
+	if len(calls) != 1 || calls[0].Method != "Aggregate" {
+		t.Fatalf("calls = %v, want a single aggregation", calls)
+	}
+	match := calls[0].Filter.(mongo.Pipeline)[0]
+	if want := (bson.D{{Key: "$match", Value: database.ItemsFilter([]string{"HP-002", "HP-404", "HP-001"})}}); !reflect.DeepEqual(match, want) {
+		t.Errorf("first stage = %v, want %v", match, want)
+	}
+}
+
+func TestProductsByIDNoneFound(t *testing.T) {
+	app := newTestApp(testConfig(), &mocks.ProductRepository{}, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?ids=HP-404", "")
+	if resp.StatusCode != http.StatusOK || string(body) != `{"data":[],"notFound":["HP-404"]}` {
+		t.Errorf("%d %s, want no data and HP-404 not found", resp.StatusCode, body)
+	}
+}
+
+func TestProductsByIDRejected(t *testing.T) {
+	ids := make([]string, maxLookupIDs+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("P-%03d", i)
+	}
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?ids="+strings.Join(ids, ","), "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "TOO_MANY_IDS" {
+		t.Errorf("%d %s, want 400 TOO_MANY_IDS", resp.StatusCode, body)
+	}
+	if len(repo.Calls()) != 0 {
+		t.Errorf("calls = %v, want none", repo.Calls())
+	}
+
+	// The cap counts distinct ids.
+	resp, body = do(t, app, fiber.MethodGet, "/products?ids="+strings.Join(ids[:maxLookupIDs], ",")+","+ids[0], "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("%d distinct ids: %d %s, want 200", maxLookupIDs, resp.StatusCode, body)
+	}
+
+	failing := &mocks.ProductRepository{Err: errors.New("connection reset")}
+	app = newTestApp(testConfig(), failing, listingRoutes)
+	if resp, body := do(t, app, fiber.MethodGet, "/products?ids=HP-001", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("database error: %d %s, want 500", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsurerRecord is an entry of the insurer master data. ID is the insurer's
+// own identifier, embedded in products as insurer._id; the code is the key.
+type InsurerRecord struct {
+	InsurerCode string    `json:"insurerCode" bson:"_id"`
+	ID          string    `json:"_id" bson:"insurerId"`
+	InsurerName string    `json:"insurerName" bson:"insurerName"`
+	Active      bool      `json:"active" bson:"active"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+	UpdatedBy   string    `json:"updatedBy,omitempty" bson:"updatedBy"`
+}
+
+// InsurerRecordInput is the body of the insurer create and update
+// endpoints. InsurerCode is only read on create; Active defaults to true.
+type InsurerRecordInput struct {
+	InsurerCode string `json:"insurerCode" validate:"pattern=insurerCode"`
+	ID          string `json:"_id" validate:"max=100"`
+	InsurerName string `json:"insurerName" validate:"required,max=200"`
+	Active      *bool  `json:"active"`
+}
+
+func parseInsurerInput(c *fiber.Ctx) (*InsurerRecordInput, error) {
+	var in InsurerRecordInput
+	if err := validation.DecodeJSON(c.Body(), &in); err != nil {
+		return nil, apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if err := validation.Struct(&in); err != nil {
+		var verrs validation.Errors
+		errors.As(err, &verrs)
+		return nil, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED",
+			"request body failed validation", verrs)
+	}
+	return &in, nil
+}
+
+func (in *InsurerRecordInput) active() bool {
+	return in.Active == nil || *in.Active
+}
+
+func (h *Handler) loadInsurers(c *fiber.Ctx, ctx context.Context, filter bson.M) ([]InsurerRecord, error) {
+	insurers := []InsurerRecord{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := tenant(c).Insurers.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &insurers)
+	})
+	return insurers, err
+}
+
+// insurerProducts counts the products referencing the insurer code.
+func (h *Handler) insurerProducts(c *fiber.Ctx, ctx context.Context, code string) (int64, error) {
+	var counts []struct {
+		Count int64 `bson:"count"`
+	}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, database.InsurerProductsPipeline(code), options.Aggregate().SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &counts)
+	})
+	if err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].Count, nil
+}
+
+// GetInsurers lists the insurers ordered by code, optionally only the
+// ?active=true or false ones.
+func (h *Handler) GetInsurers(c *fiber.Ctx) error {
+	filter := bson.M{}
+	switch query(c, "active") {
+	case "":
+	case "true":
+		filter["active"] = true
+	case "false":
+		filter["active"] = false
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_PARAMETER", "active must be true or false")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+	insurers, err := h.loadInsurers(c, ctx, filter)
+	if err != nil {
+		return queryError(c, "listing insurers", err)
+	}
+	return c.JSON(fiber.Map{"totalCount": len(insurers), "data": insurers})
+}
+
+// GetInsurer returns an insurer with the number of products referencing it.
+func (h *Handler) GetInsurer(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var insurer InsurerRecord
+	err := database.Breaker.Do(ctx, func() error {
+		return tenant(c).Insurers.FindOne(ctx, bson.M{"_id": c.Params("code")}).Decode(&insurer)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return insurerNotFound(c)
+	}
+	if err != nil {
+		return queryError(c, "finding insurer", err)
+	}
+	n, err := h.insurerProducts(c, ctx, insurer.InsurerCode)
+	if err != nil {
+		return queryError(c, "counting insurer products", err)
+	}
+	return c.JSON(fiber.Map{"insurer": insurer, "productCount": n})
+}
+
+func (h *Handler) CreateInsurer(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	in, err := parseInsurerInput(c)
+	if in == nil {
+		return err
+	}
+	if in.InsurerCode == "" {
+		return apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED", "request body failed validation",
+			validation.Errors{{Field: "insurerCode", Rule: "required", Message: "is required"}})
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	insurer := InsurerRecord{
+		InsurerCode: in.InsurerCode,
+		ID:          in.ID,
+		InsurerName: database.Normalize(in.InsurerName),
+		Active:      in.active(),
+		UpdatedAt:   database.Timestamp(h.now()),
+		UpdatedBy:   actor,
+	}
+	err = database.Breaker.Do(ctx, func() error {
+		_, err := tenant(c).Insurers.InsertOne(ctx, insurer)
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return apierror.Send(c, fiber.StatusConflict, "INSURER_EXISTS", "insurer "+in.InsurerCode+" already exists")
+	}
+	if err != nil {
+		return queryError(c, "creating insurer", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(insurer)
+}
+
+// UpdateInsurer replaces the name, _id and active flag of an insurer. A new
+// name is propagated into the products embedding the insurer by a
+// background job, whose id the response carries as propagationJob.
+func (h *Handler) UpdateInsurer(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	in, err := parseInsurerInput(c)
+	if in == nil {
+		return err
+	}
+	code := c.Params("code")
+	if in.InsurerCode != "" && in.InsurerCode != code {
+		return apierror.Send(c, fiber.StatusBadRequest, "KEY_MISMATCH", "the body's insurerCode must match the path or be omitted")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var before InsurerRecord
+	insurer := InsurerRecord{
+		InsurerCode: code,
+		ID:          in.ID,
+		InsurerName: database.Normalize(in.InsurerName),
+		Active:      in.active(),
+		UpdatedAt:   database.Timestamp(h.now()),
+		UpdatedBy:   actor,
+	}
+	set := bson.M{
+		"insurerId":   insurer.ID,
+		"insurerName": insurer.InsurerName,
+		"active":      insurer.Active,
+		"updatedAt":   insurer.UpdatedAt,
+		"updatedBy":   actor,
+	}
+	err = database.Breaker.Do(ctx, func() error {
+		return database.WithTransaction(ctx, func(ctx context.Context) error {
+			err := tenant(c).Insurers.FindOneAndUpdate(ctx, bson.M{"_id": code}, bson.M{"$set": set}).Decode(&before)
+			if err != nil {
+				return err
+			}
+			return audit.Record(ctx, tenant(c).Audit, audit.Entry{
+				Actor:  actor,
+				Action: audit.ActionInsurerUpdate,
+				Target: "insurer:" + code,
+				Changes: audit.Diff(
+					bson.M{"insurerId": before.ID, "insurerName": before.InsurerName, "active": before.Active},
+					bson.M{"insurerId": insurer.ID, "insurerName": insurer.InsurerName, "active": insurer.Active},
+				),
+				RequestID: middleware.RequestIDFrom(c),
+				Timestamp: h.now().UTC(),
+			})
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return insurerNotFound(c)
+	}
+	if err != nil {
+		return queryError(c, "updating insurer", err)
+	}
+
+	resp := struct {
+		InsurerRecord
+		PropagationJob string `json:"propagationJob,omitempty"`
+	}{InsurerRecord: insurer}
+	if before.InsurerName != insurer.InsurerName {
+		job, err := h.startInsurerRename(c, ctx, code, before.InsurerName, insurer.InsurerName)
+		if err != nil {
+			return queryError(c, "starting insurer name propagation", err)
+		}
+		resp.PropagationJob = job.ID.Hex()
+	}
+	return c.JSON(resp)
+}
+
+// DeleteInsurer removes an insurer no product references; otherwise it
+// answers 409 with the number of products that do. A product created
+// between the count and the delete is not caught, and shows up in the
+// integrity checks instead.
+func (h *Handler) DeleteInsurer(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	code := c.Params("code")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	n, err := h.insurerProducts(c, ctx, code)
+	if err != nil {
+		return queryError(c, "counting insurer products", err)
+	}
+	if n > 0 {
+		return apierror.SendDetails(c, fiber.StatusConflict, "INSURER_IN_USE",
+			fmt.Sprintf("insurer %s is referenced by %d products", code, n), fiber.Map{"productCount": n})
+	}
+
+	var res *mongo.DeleteResult
+	err = database.Breaker.Do(ctx, func() error {
+		var err error
+		res, err = tenant(c).Insurers.DeleteOne(ctx, bson.M{"_id": code})
+		return err
+	})
+	if err != nil {
+		return queryError(c, "deleting insurer", err)
+	}
+	if res.DeletedCount == 0 {
+		return insurerNotFound(c)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func insurerNotFound(c *fiber.Ctx) error {
+	return apierror.Send(c, fiber.StatusNotFound, "INSURER_NOT_FOUND", "insurer "+c.Params("code")+" does not exist")
+}
+
+// checkInsurer is checkBrokers for the product's insurer, under
+// MasterData.EnforceInsurers. An empty name or _id is filled in from the
+// master.
+func (h *Handler) checkInsurer(c *fiber.Ctx, ctx context.Context, in *ProductInput) (ok bool, err error) {
+	if !h.cfg.MasterData.EnforceInsurers {
+		return true, nil
+	}
+	insurers, err := h.loadInsurers(c, ctx, bson.M{})
+	if err != nil {
+		return false, queryError(c, "loading insurers", err)
+	}
+	code := in.Insurer.InsurerCode
+	codes := make([]string, 0, len(insurers))
+	for _, m := range insurers {
+		codes = append(codes, m.InsurerCode)
+		if m.InsurerCode != code {
+			continue
+		}
+		if !m.Active && query(c, "force") != "true" {
+			return false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "INACTIVE_INSURER",
+				"insurer "+code+" is inactive; retry with force=true to use it anyway", fiber.Map{"insurerCode": code})
+		}
+		if in.Insurer.InsurerName == "" {
+			in.Insurer.InsurerName = m.InsurerName
+		}
+		if in.Insurer.ID == "" {
+			in.Insurer.ID = m.ID
+		}
+		return true, nil
+	}
+	return false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "UNKNOWN_INSURER",
+		"insurer "+code+" is not registered in the insurer master data",
+		brokerProblem{Key: code, Suggestions: suggest(code, codes)})
+}
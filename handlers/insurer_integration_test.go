@@ -0,0 +1,141 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+// insurerMaster registers the fixtures' TIP and AXA, AXA inactive, and
+// BKI, which no product references.
+func insurerMaster(t *testing.T, app *fiber.App) {
+	t.Helper()
+	for _, body := range []string{
+		`{"insurerCode": "TIP", "_id": "INS-TIP", "insurerName": "ทิพยประกันภัย"}`,
+		`{"insurerCode": "AXA", "_id": "INS-AXA", "insurerName": "AXA Insurance", "active": false}`,
+		`{"insurerCode": "BKI", "insurerName": "Bangkok Insurance"}`,
+	} {
+		if resp, out := call(t, app, fiber.MethodPost, "/insurers", body); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", body, resp.StatusCode, out)
+		}
+	}
+}
+
+func TestIntegrationInsurers(t *testing.T) {
+	app := integrationApp(t, nil)
+	insurerMaster(t, app)
+
+	if resp, out := call(t, app, fiber.MethodPost, "/insurers", `{"insurerCode": "TIP", "insurerName": "Dhipaya"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second create: %d %s, want 409", resp.StatusCode, out)
+	}
+
+	resp, out := call(t, app, fiber.MethodGet, "/insurers?active=true", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("list: %d %s", resp.StatusCode, out)
+	}
+	var page struct{ Data []handlers.InsurerRecord }
+	if err := json.Unmarshal(out, &page); err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for _, in := range page.Data {
+		codes = append(codes, in.InsurerCode)
+	}
+	if want := []string{"BKI", "TIP"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("active insurers = %v, want %v", codes, want)
+	}
+
+	resp, out = call(t, app, fiber.MethodGet, "/insurers/TIP", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("lookup: %d %s", resp.StatusCode, out)
+	}
+	var lookup struct {
+		Insurer      handlers.InsurerRecord `json:"insurer"`
+		ProductCount int64                  `json:"productCount"`
+	}
+	if err := json.Unmarshal(out, &lookup); err != nil {
+		t.Fatal(err)
+	}
+	if lookup.Insurer.ID != "INS-TIP" || lookup.ProductCount != 2 {
+		t.Errorf("lookup = %+v, want INS-TIP with 2 products", lookup)
+	}
+
+	// Only a rename starts a propagation job.
+	for _, tt := range []struct {
+		body string
+		job  bool
+	}{
+		{`{"_id": "INS-BKI", "insurerName": "Bangkok Insurance"}`, false},
+		{`{"_id": "INS-BKI", "insurerName": "กรุงเทพประกันภัย"}`, true},
+	} {
+		resp, out := call(t, app, fiber.MethodPut, "/insurers/BKI", tt.body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("update %s: %d %s", tt.body, resp.StatusCode, out)
+		}
+		var updated struct {
+			handlers.InsurerRecord
+			PropagationJob string `json:"propagationJob"`
+		}
+		if err := json.Unmarshal(out, &updated); err != nil {
+			t.Fatal(err)
+		}
+		if updated.ID != "INS-BKI" || (updated.PropagationJob != "") != tt.job {
+			t.Errorf("update %s = %+v, want a job: %t", tt.body, updated, tt.job)
+		}
+	}
+
+	resp, out = call(t, app, fiber.MethodDelete, "/insurers/TIP", "")
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(string(out), `"productCount":2`) {
+		t.Errorf("delete in use: %d %s, want 409 with the product count", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodDelete, "/insurers/BKI", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete unused: %d %s", resp.StatusCode, out)
+	}
+	for _, method := range []string{fiber.MethodGet, fiber.MethodDelete} {
+		if resp, out := call(t, app, method, "/insurers/BKI", ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s after the delete: %d %s, want 404", method, resp.StatusCode, out)
+		}
+	}
+}
+
+// TestIntegrationInsurerEnforcement writes products naming unknown,
+// inactive and registered insurers with the master data enforced.
+func TestIntegrationInsurerEnforcement(t *testing.T) {
+	app := integrationApp(t, map[string]string{"MASTER_DATA_ENFORCE_INSURERS": "true"})
+	insurerMaster(t, app)
+	product := func(name, code string) string {
+		return `{"productName": "` + name + `", "productGroup": {"key": "HEALTH-PLUS"},
+			"insurer": {"insurerCode": "` + code + `"}, "status": "DRAFT"}`
+	}
+
+	resp, out := call(t, app, fiber.MethodPost, "/products", product("Health Plus Kids", "TIPP"))
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(out), `"UNKNOWN_INSURER"`) || !strings.Contains(string(out), `"suggestions":["TIP"]`) {
+		t.Errorf("unknown insurer: %d %s, want 422 UNKNOWN_INSURER suggesting TIP", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodPost, "/products", product("Health Plus Kids", "AXA")); resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(out), `"INACTIVE_INSURER"`) {
+		t.Errorf("inactive insurer: %d %s, want 422 INACTIVE_INSURER", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodPost, "/products?force=true", product("Health Plus Kids", "AXA")); resp.StatusCode != http.StatusCreated {
+		t.Errorf("inactive insurer forced: %d %s, want 201", resp.StatusCode, out)
+	}
+
+	// The master fills in the name and _id left out.
+	resp, out = call(t, app, fiber.MethodPost, "/products", product("Health Plus Teens", "TIP"))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("registered insurer: %d %s", resp.StatusCode, out)
+	}
+	var created handlers.Product
+	if err := json.Unmarshal(out, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Insurer.ID != "INS-TIP" || created.Insurer.InsurerName != "ทิพยประกันภัย" {
+		t.Errorf("insurer = %+v, want the master's name and _id", created.Insurer)
+	}
+}
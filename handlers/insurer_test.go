@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func insurerRoutes(app *fiber.App, h *Handler) {
+	app.Get("/insurers", h.GetInsurers)
+	app.Post("/insurers", h.CreateInsurer)
+	app.Put("/insurers/:code", h.UpdateInsurer)
+	app.Delete("/insurers/:code", h.DeleteInsurer)
+}
+
+// TestInsurerRequestsRejected is TestBrokerRequestsRejected for insurers.
+func TestInsurerRequestsRejected(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+		code                       string
+	}{
+		{"active not a bool", fiber.MethodGet, "/insurers?active=1", "", http.StatusBadRequest, "INVALID_PARAMETER"},
+		{"create without code", fiber.MethodPost, "/insurers", `{"insurerName": "Bangkok Insurance"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create lowercase code", fiber.MethodPost, "/insurers", `{"insurerCode": "bki", "insurerName": "Bangkok Insurance"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create without name", fiber.MethodPost, "/insurers", `{"insurerCode": "BKI"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"update other code", fiber.MethodPut, "/insurers/BKI", `{"insurerCode": "TIP", "insurerName": "Bangkok Insurance"}`, http.StatusBadRequest, "KEY_MISMATCH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, insurerRoutes)
+			resp, body := do(t, app, tt.method, tt.target, tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+// TestDeleteInsurerInUse checks that an insurer still referenced by
+// products is kept, with the number of them, and that a failed count does
+// not let the delete through.
+func TestDeleteInsurerInUse(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{"count": 3}}}
+	app := newTestApp(testConfig(), repo, insurerRoutes)
+	resp, body := do(t, app, fiber.MethodDelete, "/insurers/TIP", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(body) != "INSURER_IN_USE" {
+		t.Fatalf("%d %s, want 409 INSURER_IN_USE", resp.StatusCode, body)
+	}
+	var env struct {
+		Error struct {
+			Message string
+			Details struct {
+				ProductCount int64 `json:"productCount"`
+			}
+		}
+	}
+	decode(t, body, &env)
+	if env.Error.Details.ProductCount != 3 || env.Error.Message != "insurer TIP is referenced by 3 products" {
+		t.Errorf("error = %+v", env.Error)
+	}
+	calls := callsOf(repo, "Aggregate")
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0].Filter, database.InsurerProductsPipeline("TIP")) {
+		t.Errorf("aggregations = %+v, want the insurer's product count", calls)
+	}
+
+	repo = &mocks.ProductRepository{Err: errors.New("connection reset")}
+	app = newTestApp(testConfig(), repo, insurerRoutes)
+	if resp, body := do(t, app, fiber.MethodDelete, "/insurers/TIP", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failed count: %d %s, want 500", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,463 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/routes"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// These drive the whole app against the integration-test database named
+// by MONGO_TEST_URI:
+//
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test -tags integration ./handlers
+
+const integrationKey = "integration-admin-key"
+
+// integrationApp is the app as main wires it, over a fresh database
+// holding the fixtures and configured by env, dropped when the test ends.
+func integrationApp(t *testing.T, env map[string]string) *fiber.App {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	log.SetOutput(io.Discard)
+	name := fmt.Sprintf("handlers_test_%d", time.Now().UnixNano())
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	t.Setenv("MONGO_URI", uri)
+	t.Setenv("MONGO_DATABASE", name)
+	t.Setenv("TENANTS", "it="+name)
+	t.Setenv("TENANT_DEFAULT", "it")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.ConnectDB(cfg.Mongo); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tenant := database.DefaultTenant()
+	t.Cleanup(func() {
+		tenant.Products.Database().Drop(ctx)
+		database.Disconnect(ctx)
+	})
+	groups, err := fixtures.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant.Products.InsertMany(ctx, groups); err != nil {
+		t.Fatal(err)
+	}
+
+	maintenance := middleware.NewMaintenance(middleware.MaintenanceState{})
+	h := handlers.New(cfg, handlers.Deps{
+		Repos:       database.Repository,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Maintenance: maintenance,
+	})
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.ErrorHandler})
+	auth := middleware.Auth(middleware.AuthConfig{APIKeys: []middleware.APIKey{
+		{Name: "integration", Key: integrationKey, Roles: []string{middleware.RoleAdmin}},
+	}})
+	routes.SetupRoutes(app, cfg, h, auth, maintenance)
+	return app
+}
+
+// call runs an admin request with an optional JSON body.
+func call(t *testing.T, app *fiber.App, method, target, body string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set("X-API-Key", integrationKey)
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, out
+}
+
+// TestListingPathsAgree lists the fixtures through the aggregation and
+// through Go flattening. The pages must be the same JSON but for the
+// count: the Go path pages whole groups, so it counts groups.
+func TestListingPathsAgree(t *testing.T) {
+	targets := []string{
+		"/products?limit=100",
+		"/products?limit=100&status=ACTIVE,DRAFT",
+		"/products?limit=100&param=health",
+		"/products?limit=100&missing=insurer",
+		"/products?limit=100&code=MT-*",
+		"/products?limit=100&collation=en",
+		"/products?limit=100&minBrokers=2",
+		"/products?limit=100&maxBrokers=0",
+		"/products?limit=100&param=plus%20-family",
+		"/products?limit=100&param=-ซ่อม",
+	}
+	bodies := map[string][]string{}
+	for _, aggregation := range []string{"true", "false"} {
+		app := integrationApp(t, map[string]string{"MONGO_LIST_AGGREGATION": aggregation})
+		for _, target := range targets {
+			resp, body := call(t, app, fiber.MethodGet, target, "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("aggregation=%s %s: %d %s", aggregation, target, resp.StatusCode, body)
+			}
+			var page map[string]json.RawMessage
+			if err := json.Unmarshal(body, &page); err != nil {
+				t.Fatal(err)
+			}
+			delete(page, "totalCount")
+			delete(page, "totalCountExact")
+			normalized, err := json.Marshal(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies[target] = append(bodies[target], string(normalized))
+		}
+	}
+	for _, target := range targets {
+		if got := bodies[target]; got[0] != got[1] {
+			t.Errorf("%s:\naggregation: %s\nGo:          %s", target, got[0], got[1])
+		}
+	}
+}
+
+// listing is a decoded listing page.
+type listing struct {
+	TotalCount int64              `json:"totalCount"`
+	Data       []handlers.Product `json:"data"`
+}
+
+func list(t *testing.T, app *fiber.App, target string) listing {
+	t.Helper()
+	resp, body := call(t, app, fiber.MethodGet, target, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %d %s", target, resp.StatusCode, body)
+	}
+	var page listing
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func ids(products []handlers.Product) []string {
+	out := make([]string, len(products))
+	for i, p := range products {
+		out[i] = p.ID
+	}
+	return out
+}
+
+func TestIntegrationSearch(t *testing.T) {
+	app := integrationApp(t, nil)
+	for _, tt := range []struct {
+		target string
+		want   []string
+	}{
+		{"/products?param=Family", []string{"HP-002"}},
+		{"/products?param=family", []string{"HP-002"}},
+		{"/products?param=ซ่อม", []string{"MT-001", "MT-002"}},
+		{"/products?param=Family&status=ACTIVE", nil},
+		{"/products?status=RETIRED", []string{"MT-002"}},
+		{"/products?missing=insurer", []string{"HP-003"}},
+		{"/products?maxBrokers=0", []string{"MT-002"}},
+		{"/products?param=Travel&status=INACTIVE", []string{"TW-002"}},
+		{"/products?param=plus%20-family", []string{"HP-003"}},
+		{"/products?param=-ซ่อม", []string{"HP-001", "HP-002", "HP-003", "TW-001", "TW-002"}},
+		{"/products?param=-%22health%20plus%22", []string{"HP-001", "MT-001", "MT-002", "TW-001", "TW-002"}},
+		{"/products?param=-family%20-senior&status=DRAFT,INACTIVE", []string{"TW-002"}},
+		{"/products?param=-", []string{"HP-001", "HP-002", "HP-003", "MT-001", "MT-002", "TW-001", "TW-002"}},
+	} {
+		t.Run(tt.target, func(t *testing.T) {
+			page := list(t, app, tt.target)
+			got := ids(page.Data)
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+			if page.TotalCount != int64(len(tt.want)) {
+				t.Errorf("totalCount = %d, want %d", page.TotalCount, len(tt.want))
+			}
+		})
+	}
+}
+
+// TestIntegrationSearchFields narrows the search to single fields, with
+// the search shadows the fixtures lack filled in.
+func TestIntegrationSearchFields(t *testing.T) {
+	app := integrationApp(t, nil)
+	if err := database.RefreshSearchFields(context.Background(), database.DefaultTenant().Products, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		target string
+		want   []string
+	}{
+		{"/products?param=axa&searchFields=insurerCode", []string{"HP-002", "TW-001"}},
+		{"/products?param=axa&searchFields=productName,brokers", nil},
+		{"/products?param=health&searchFields=productGroup", []string{"HP-001", "HP-002", "HP-003"}},
+		{"/products?param=health&searchFields=productName", []string{"HP-002", "HP-003"}},
+		{"/products?param=motor&searchFields=productType", []string{"MT-001", "MT-002"}},
+		{"/products?param=broker-ag&searchFields=brokers", []string{"TW-001", "TW-002"}},
+		{"/products?param=-axa&searchFields=insurerCode&status=ACTIVE", []string{"HP-001", "MT-001"}},
+		{"/products?param=axa", []string{"HP-002", "TW-001"}},
+	} {
+		page := list(t, app, tt.target)
+		got := ids(page.Data)
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") || page.TotalCount != int64(len(tt.want)) {
+			t.Errorf("%s = %v (totalCount %d), want %v", tt.target, got, page.TotalCount, tt.want)
+		}
+	}
+	resp, out := call(t, app, fiber.MethodGet, "/products?param=axa&searchFields=insurer", "")
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(out), `"searchFields":["productType","productGroup","productName","insurerCode","brokers"]`) {
+		t.Errorf("unknown field: %d %s, want 400 listing the fields", resp.StatusCode, out)
+	}
+}
+
+// TestIntegrationBrokerCount filters the fixtures and a product without a
+// brokers field by broker count, reading the group documents with and
+// without the aggregation and reading products_flat.
+func TestIntegrationBrokerCount(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"groups":      nil,
+		"aggregation": {"MONGO_LIST_AGGREGATION": "true"},
+		"flat":        {"MONGO_FLAT_SYNC": "true", "MONGO_LIST_SOURCE": "flat"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := integrationApp(t, env)
+			_, err := database.DefaultTenant().Products.InsertOne(context.Background(), bson.M{
+				"key":         "NO-BROKERS",
+				"productList": bson.A{bson.M{"id": "NB-001", "productName": "No Brokers", "productStatus": "ACTIVE"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp, out := call(t, app, fiber.MethodPost, "/admin/flat/backfill", ""); resp.StatusCode >= 300 {
+				t.Fatalf("backfill: %d %s", resp.StatusCode, out)
+			}
+			for _, tt := range []struct {
+				target string
+				want   []string
+			}{
+				{"/products?maxBrokers=0", []string{"MT-002", "NB-001"}},
+				{"/products?minBrokers=0&maxBrokers=0&status=ACTIVE", []string{"NB-001"}},
+				{"/products?minBrokers=2", []string{"HP-001", "TW-001"}},
+				{"/products?minBrokers=1&maxBrokers=1", []string{"HP-002", "HP-003", "MT-001", "TW-002"}},
+				{"/products?minBrokers=1&maxBrokers=1&status=INACTIVE", []string{"HP-003", "TW-002"}},
+				{"/products?minBrokers=3", nil},
+			} {
+				page := list(t, app, tt.target)
+				got := ids(page.Data)
+				sort.Strings(got)
+				if strings.Join(got, ",") != strings.Join(tt.want, ",") || page.TotalCount != int64(len(tt.want)) {
+					t.Errorf("%s = %v (totalCount %d), want %v", tt.target, got, page.TotalCount, tt.want)
+				}
+			}
+			resp, out := call(t, app, fiber.MethodGet, "/products?minBrokers=2&maxBrokers=1", "")
+			if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(out), "INVALID_BROKER_COUNT") {
+				t.Errorf("inverted bounds: %d %s, want 400 INVALID_BROKER_COUNT", resp.StatusCode, out)
+			}
+		})
+	}
+}
+
+// TestIntegrationPagination walks the fixtures three at a time: the pages
+// must add up to the whole listing, in its order, without repeats.
+func TestIntegrationPagination(t *testing.T) {
+	app := integrationApp(t, nil)
+	all := list(t, app, "/products?limit=100")
+	if len(all.Data) != 7 || all.TotalCount != 7 {
+		t.Fatalf("%d products, totalCount %d; want the 7 fixtures", len(all.Data), all.TotalCount)
+	}
+
+	var walked []string
+	for page := 1; page <= 3; page++ {
+		p := list(t, app, fmt.Sprintf("/products?limit=3&page=%d", page))
+		if p.TotalCount != 7 {
+			t.Errorf("page %d: totalCount = %d, want 7", page, p.TotalCount)
+		}
+		walked = append(walked, ids(p.Data)...)
+	}
+	if got, want := strings.Join(walked, ","), strings.Join(ids(all.Data), ","); got != want {
+		t.Errorf("pages = %s, want %s", got, want)
+	}
+	if p := list(t, app, "/products?limit=3&page=4"); len(p.Data) != 0 {
+		t.Errorf("past the end: %v, want nothing", ids(p.Data))
+	}
+}
+
+// TestIntegrationSorting checks that listings are sorted by product name,
+// by code point with the simple collation, and the same on every request.
+func TestIntegrationSorting(t *testing.T) {
+	app := integrationApp(t, nil)
+	page := list(t, app, "/products?limit=100&collation=simple")
+	names := make([]string, len(page.Data))
+	for i, p := range page.Data {
+		names[i] = p.ProductName
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("names out of order: %q", names)
+	}
+
+	first := ids(list(t, app, "/products?limit=100&collation=th").Data)
+	again := ids(list(t, app, "/products?limit=100&collation=th&status=ACTIVE,DRAFT,INACTIVE,RETIRED").Data)
+	if strings.Join(first, ",") != strings.Join(again, ",") {
+		t.Errorf("th order changed between requests: %v, then %v", first, again)
+	}
+}
+
+// TestIntegrationWrites creates and updates a product and reads it back
+// through the lookup, the listing and the audit log.
+func TestIntegrationWrites(t *testing.T) {
+	app := integrationApp(t, nil)
+	body := `{"productName": "Health Plus Junior", "productGroup": {"key": "HEALTH-PLUS"},
+		"insurer": {"insurerCode": "TIP", "insurerName": "ทิพยประกันภัย"},
+		"brokers": [{"key": "BROKER-ONLINE", "channelName": "Online"}], "status": "DRAFT"}`
+	resp, out := call(t, app, fiber.MethodPost, "/products", body)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %d %s", resp.StatusCode, out)
+	}
+	var created handlers.Product
+	if err := json.Unmarshal(out, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Code == "" || created.ProductGroup.Name != "Health Plus" || created.ProductType.Key != "HEALTH" {
+		t.Errorf("created = %+v, want an id, a code and the group's name and type", created)
+	}
+	if resp, out := call(t, app, fiber.MethodPost, "/products", body); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second create: %d %s, want 409", resp.StatusCode, out)
+	}
+	renamed := strings.Replace(body, "Health Plus Junior", "  health PLUS   junior", 1)
+	if resp, out := call(t, app, fiber.MethodPost, "/products", renamed); resp.StatusCode != http.StatusConflict || !strings.Contains(string(out), created.ID) {
+		t.Errorf("create differing in case and spaces: %d %s, want 409 naming %s", resp.StatusCode, out, created.ID)
+	}
+
+	resp, out = call(t, app, fiber.MethodPut, "/products/"+created.ID, strings.Replace(body, `"DRAFT"`, `"ACTIVE"`, 1))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update: %d %s", resp.StatusCode, out)
+	}
+	resp, out = call(t, app, fiber.MethodGet, "/products/"+created.ID, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("lookup: %d %s", resp.StatusCode, out)
+	}
+	var got handlers.Product
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != handlers.StatusActive || got.Code != created.Code || got.CreatedBy != "integration" {
+		t.Errorf("after update = %+v, want ACTIVE with the created code and creator", got)
+	}
+
+	if resp, out := call(t, app, fiber.MethodPut, "/products/"+created.ID, body); resp.StatusCode != http.StatusConflict {
+		t.Errorf("back to DRAFT: %d %s, want 409", resp.StatusCode, out)
+	}
+
+	if page := list(t, app, "/products?param=Junior"); len(page.Data) != 1 || page.Data[0].ID != created.ID {
+		t.Errorf("listing after the writes = %v, want %s", ids(page.Data), created.ID)
+	}
+
+	resp, out = call(t, app, fiber.MethodGet, "/audit?productId="+created.ID, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("audit: %d %s", resp.StatusCode, out)
+	}
+	var entries struct {
+		Data []struct {
+			Action string `json:"action"`
+			Actor  string `json:"actor"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(out, &entries); err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range entries.Data {
+		actions = append(actions, e.Action+" by "+e.Actor)
+	}
+	if want := "product.update by integration,product.create by integration"; strings.Join(actions, ",") != want {
+		t.Errorf("audit = %v, want %s", actions, want)
+	}
+}
+
+// TestIntegrationMergeGroup merges TRAVEL-WORLD into HEALTH-PLUS and checks
+// the moved products, the deleted source and an audit entry per product.
+func TestIntegrationMergeGroup(t *testing.T) {
+	app := integrationApp(t, nil)
+	resp, out := call(t, app, fiber.MethodPost, "/product-groups/TRAVEL-WORLD/merge-into/HEALTH-PLUS", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("merge: %d %s", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodGet, "/groups/TRAVEL-WORLD", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("source after the merge: %d %s, want 404", resp.StatusCode, out)
+	}
+	page := list(t, app, "/products?limit=100&group=HEALTH-PLUS")
+	if got := strings.Join(ids(page.Data), ","); !strings.Contains(got, "TW-001") || !strings.Contains(got, "TW-002") {
+		t.Errorf("HEALTH-PLUS after the merge = %s, want the travel products", got)
+	}
+	for _, id := range []string{"TW-001", "TW-002"} {
+		resp, out := call(t, app, fiber.MethodGet, "/audit?productId="+id, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("audit: %d %s", resp.StatusCode, out)
+		}
+		var entries struct {
+			Data []struct {
+				Action   string `json:"action"`
+				GroupKey string `json:"groupKey"`
+				Changes  []struct {
+					Field string `json:"field"`
+					Old   string `json:"old"`
+					New   string `json:"new"`
+				} `json:"changes"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(out, &entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries.Data) != 1 {
+			t.Fatalf("%s: %d audit entries, want one: %s", id, len(entries.Data), out)
+		}
+		e := entries.Data[0]
+		if e.Action != "product.move" || e.GroupKey != "HEALTH-PLUS" || len(e.Changes) != 1 ||
+			e.Changes[0].Field != "productGroup.key" || e.Changes[0].Old != "TRAVEL-WORLD" || e.Changes[0].New != "HEALTH-PLUS" {
+			t.Errorf("%s: audit entry = %+v", id, e)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+// integrityFix is one automatic fix: every product holding From at Field
+// gets To instead.
+type integrityFix struct {
+	Issue    string `json:"issue"`
+	Field    string `json:"field"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Products int64  `json:"products"`
+	// Groups is the number of group documents changed, once applied.
+	Groups *int64 `json:"groups,omitempty"`
+}
+
+// GetIntegrity reports referential integrity problems. Without ?issue= it
+// counts every issue type; with one it lists the offending products a page
+// at a time. ?fix=dryrun lists the automatic fixes available, and
+// ?fix=apply applies them: statuses and insurer codes that are only
+// wrongly cased are the ones with a safe fix.
+func (h *Handler) GetIntegrity(c *fiber.Ctx) error {
+	switch query(c, "fix") {
+	case "":
+	case "dryrun":
+		return h.integrityFixes(c, false)
+	case "apply":
+		return h.integrityFixes(c, true)
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_FIX", "fix must be dryrun or apply")
+	}
+
+	issue := query(c, "issue")
+	if issue == "" {
+		return h.integrityCounts(c)
+	}
+	stages, ok := database.IntegrityStages(issue, statusNames())
+	if !ok {
+		return apierror.SendDetails(c, fiber.StatusBadRequest, "INVALID_ISSUE",
+			"issue must be one of "+strings.Join(database.IntegrityIssues, ", "), fiber.Map{"issues": database.IntegrityIssues})
+	}
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	pipeline := append(stages,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "groupKey", Value: 1}, {Key: "productId", Value: 1}}}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "n"}},
+			"items": bson.A{bson.M{"$skip": paging.Skip()}, bson.M{"$limit": paging.Limit}},
+		}}},
+	)
+	var out []struct {
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+		Items []database.IntegrityRow `bson:"items"`
+	}
+	if err := h.aggregateAll(c, ctx, pipeline, &out); err != nil {
+		return queryError(c, "scanning integrity", err)
+	}
+	total, rows := int64(0), []database.IntegrityRow{}
+	if len(out) > 0 {
+		if len(out[0].Total) > 0 {
+			total = out[0].Total[0].N
+		}
+		rows = append(rows, out[0].Items...)
+	}
+	setPageLinks(c, paging, total, len(rows))
+	return c.JSON(fiber.Map{"issue": issue, "totalCount": total, "data": rows})
+}
+
+// aggregateAll runs an analytics pipeline and decodes all of its output.
+func (h *Handler) aggregateAll(c *fiber.Ctx, ctx context.Context, pipeline mongo.Pipeline, out interface{}) error {
+	return database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline,
+			options.Aggregate().SetAllowDiskUse(h.cfg.Mongo.AnalyticsAllowDiskUse).SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, out)
+	})
+}
+
+func (h *Handler) integrityCounts(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	counts := make([]int64, len(database.IntegrityIssues))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, issue := range database.IntegrityIssues {
+		i, issue := i, issue
+		g.Go(func() error {
+			stages, _ := database.IntegrityStages(issue, statusNames())
+			var out []struct {
+				N int64 `bson:"n"`
+			}
+			if err := h.aggregateAll(c, gctx, append(stages, bson.D{{Key: "$count", Value: "n"}}), &out); err != nil {
+				return err
+			}
+			if len(out) > 0 {
+				counts[i] = out[0].N
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return queryError(c, "scanning integrity", err)
+	}
+	byIssue := fiber.Map{}
+	for i, issue := range database.IntegrityIssues {
+		byIssue[issue] = counts[i]
+	}
+	return c.JSON(fiber.Map{"counts": byIssue})
+}
+
+// integrityFixes lists, and when apply is set applies, the automatic fixes.
+func (h *Handler) integrityFixes(c *fiber.Ctx, apply bool) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	type valueCount struct {
+		Value string `bson:"_id"`
+		N     int64  `bson:"n"`
+	}
+	distinct := func(issue string) ([]valueCount, error) {
+		stages, _ := database.IntegrityStages(issue, statusNames())
+		var out []valueCount
+		err := h.aggregateAll(c, ctx, append(stages, bson.D{{Key: "$group", Value: bson.M{"_id": "$value", "n": bson.M{"$sum": 1}}}}), &out)
+		return out, err
+	}
+
+	fixes := []integrityFix{}
+	statuses, err := distinct(database.IssueInvalidStatus)
+	if err != nil {
+		return queryError(c, "scanning statuses", err)
+	}
+	for _, s := range statuses {
+		if status, ok := ParseProductStatus(s.Value); ok {
+			fixes = append(fixes, integrityFix{Issue: database.IssueInvalidStatus, Field: "productStatus", From: s.Value, To: string(status), Products: s.N})
+		}
+	}
+
+	codes, err := distinct(database.IssueUnknownInsurer)
+	if err != nil {
+		return queryError(c, "scanning insurers", err)
+	}
+	if len(codes) > 0 {
+		insurers, err := h.loadInsurers(c, ctx, bson.M{})
+		if err != nil {
+			return queryError(c, "loading insurers", err)
+		}
+		for _, code := range codes {
+			// Only a code matching exactly one master code is corrected.
+			var match []string
+			for _, m := range insurers {
+				if strings.EqualFold(m.InsurerCode, code.Value) {
+					match = append(match, m.InsurerCode)
+				}
+			}
+			if len(match) == 1 {
+				fixes = append(fixes, integrityFix{Issue: database.IssueUnknownInsurer, Field: "insurer.insurerCode", From: code.Value, To: match[0], Products: code.N})
+			}
+		}
+	}
+
+	if !apply || len(fixes) == 0 {
+		return c.JSON(fiber.Map{"fix": query(c, "fix"), "data": fixes})
+	}
+	for i := range fixes {
+		f := &fixes[i]
+		err := database.Breaker.Do(ctx, func() error {
+			n, err := database.FixItemField(ctx, tenant(c).Products, f.Field, f.From, f.To)
+			f.Groups = &n
+			return err
+		})
+		if err != nil {
+			return queryError(c, "applying integrity fixes", err)
+		}
+	}
+	h.invalidateCache(c)
+	if h.cfg.Mongo.FlatSync {
+		if err := database.BackfillFlat(ctx, tenant(c)); err != nil {
+			h.logger.Error("rebuilding products_flat after integrity fixes", "error", err)
+		}
+	}
+	return c.JSON(fiber.Map{"fix": "apply", "data": fixes})
+}
+
+// statusNames is ProductStatuses as strings.
+func statusNames() []string {
+	names := make([]string, len(ProductStatuses))
+	for i, s := range ProductStatuses {
+		names[i] = string(s)
+	}
+	return names
+}
@@ -0,0 +1,133 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// brokenGroup is a group with one of each issue fixtures lack: a wrongly
+// cased insurer code and status, an unknown broker, a duplicated id, an
+// unknown status and a missing name.
+var brokenGroup = bson.M{"key": "BROKEN", "productList": bson.A{
+	bson.M{"id": "BR-001", "productName": "Broken One", "insurer": bson.M{"insurerCode": "tip"},
+		"brokers": bson.A{bson.M{"key": "BROKER-GONE"}}, "productStatus": "active"},
+	bson.M{"id": "BR-001", "productName": "Broken Copy", "insurer": bson.M{"insurerCode": "TIP"}, "productStatus": "ARCHIVED"},
+	bson.M{"id": "BR-002", "insurer": bson.M{"insurerCode": "AXA"}, "productStatus": "DRAFT"},
+}}
+
+func integrityCounts(t *testing.T, app *fiber.App) map[string]int64 {
+	t.Helper()
+	resp, out := call(t, app, fiber.MethodGet, "/admin/integrity", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("counts: %d %s", resp.StatusCode, out)
+	}
+	var report struct{ Counts map[string]int64 }
+	if err := json.Unmarshal(out, &report); err != nil {
+		t.Fatal(err)
+	}
+	return report.Counts
+}
+
+// TestIntegrationIntegrity reports the fixtures and brokenGroup against
+// the broker and insurer masters, which lack MTI and the telesales broker,
+// then applies the safe fixes.
+func TestIntegrationIntegrity(t *testing.T) {
+	app := integrationApp(t, nil)
+	brokerMaster(t, app)
+	insurerMaster(t, app)
+	if _, err := database.DefaultTenant().Products.InsertOne(context.Background(), brokenGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{
+		database.IssueUnknownInsurer: 3,
+		database.IssueUnknownBroker:  2,
+		database.IssueDuplicateID:    1,
+		database.IssueMissingFields:  2,
+		database.IssueInvalidStatus:  2,
+	}
+	if got := integrityCounts(t, app); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts = %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		target string
+		total  int64
+		want   []string
+	}{
+		{"/admin/integrity?issue=unknown_insurer&limit=2", 3, []string{"BROKEN/BR-001=tip", "MOTOR-1/MT-001=MTI"}},
+		{"/admin/integrity?issue=unknown_insurer&limit=2&page=2", 3, []string{"TRAVEL-WORLD/TW-002=MTI"}},
+		{"/admin/integrity?issue=unknown_broker", 2, []string{"BROKEN/BR-001=BROKER-GONE", "HEALTH-PLUS/HP-003=BROKER-TELESALES"}},
+		{"/admin/integrity?issue=duplicate_id", 1, []string{"BROKEN/BR-001=2"}},
+		{"/admin/integrity?issue=missing_fields", 2, []string{"BROKEN/BR-002=[productName]", "HEALTH-PLUS/HP-003=[insurer.insurerCode]"}},
+	} {
+		resp, out := call(t, app, fiber.MethodGet, tt.target, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %d %s", tt.target, resp.StatusCode, out)
+		}
+		var page struct {
+			TotalCount int64
+			Data       []database.IntegrityRow
+		}
+		if err := json.Unmarshal(out, &page); err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for _, r := range page.Data {
+			rows = append(rows, r.GroupKey+"/"+r.ProductID+"="+fmt.Sprint(r.Value))
+		}
+		if page.TotalCount != tt.total || !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s = %d %v, want %d %v", tt.target, page.TotalCount, rows, tt.total, tt.want)
+		}
+	}
+
+	type fix struct {
+		Issue, Field, From, To string
+		Products               int64
+		Groups                 *int64
+	}
+	wantFixes := []fix{
+		{Issue: database.IssueInvalidStatus, Field: "productStatus", From: "active", To: "ACTIVE", Products: 1},
+		{Issue: database.IssueUnknownInsurer, Field: "insurer.insurerCode", From: "tip", To: "TIP", Products: 1},
+	}
+	for _, mode := range []string{"dryrun", "apply"} {
+		resp, out := call(t, app, fiber.MethodGet, "/admin/integrity?fix="+mode, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("fix=%s: %d %s", mode, resp.StatusCode, out)
+		}
+		var fixes struct{ Data []fix }
+		if err := json.Unmarshal(out, &fixes); err != nil {
+			t.Fatal(err)
+		}
+		if len(fixes.Data) != len(wantFixes) {
+			t.Fatalf("fix=%s = %s, want %+v", mode, out, wantFixes)
+		}
+		for i, f := range fixes.Data {
+			groups := f.Groups
+			f.Groups = nil
+			if f != wantFixes[i] || (mode == "apply") != (groups != nil) || (groups != nil && *groups != 1) {
+				t.Errorf("fix=%s %d = %+v (groups %v), want %+v", mode, i, f, groups, wantFixes[i])
+			}
+		}
+		if mode == "dryrun" {
+			if got := integrityCounts(t, app); !reflect.DeepEqual(got, want) {
+				t.Errorf("counts after the dry run = %v, want %v", got, want)
+			}
+		}
+	}
+
+	want[database.IssueUnknownInsurer], want[database.IssueInvalidStatus] = 2, 1
+	if got := integrityCounts(t, app); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts after the fixes = %v, want %v", got, want)
+	}
+}
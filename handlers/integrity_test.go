@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// statusIssues is a repository whose invalid_status scans find two
+// products with "active" and one with "GONE", and whose scans against the
+// master data, the ones with a $lookup, find nothing.
+type statusIssues struct {
+	*mocks.ProductRepository
+}
+
+func (r statusIssues) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	r.ProductRepository.Aggregate(ctx, pipeline, opts...)
+	for _, stage := range pipeline.(mongo.Pipeline) {
+		if stage[0].Key == "$lookup" {
+			return mongo.NewCursorFromDocuments(nil, nil, nil)
+		}
+	}
+	return mongo.NewCursorFromDocuments([]interface{}{bson.M{"_id": "active", "n": 2}, bson.M{"_id": "GONE", "n": 1}}, nil, nil)
+}
+
+func integrityRoutes(app *fiber.App, h *Handler) {
+	app.Get("/admin/integrity", h.GetIntegrity)
+}
+
+func TestIntegrityRequestsRejected(t *testing.T) {
+	for _, tt := range []struct{ target, code string }{
+		{"/admin/integrity?fix=yes", "INVALID_FIX"},
+		{"/admin/integrity?issue=orphans", "INVALID_ISSUE"},
+	} {
+		t.Run(tt.code, func(t *testing.T) {
+			repo := &mocks.ProductRepository{}
+			app := newTestApp(testConfig(), repo, integrityRoutes)
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if resp.StatusCode != http.StatusBadRequest || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want 400 %s", resp.StatusCode, body, tt.code)
+			}
+			if calls := callsOf(repo, "Aggregate"); len(calls) != 0 {
+				t.Errorf("%d scans run", len(calls))
+			}
+		})
+	}
+}
+
+// TestIntegrityCounts checks that the report without ?issue= counts every
+// issue type with a scan of its own.
+func TestIntegrityCounts(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{"n": 4}}}
+	app := newTestApp(testConfig(), repo, integrityRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/admin/integrity", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	var out struct{ Counts map[string]int64 }
+	decode(t, body, &out)
+	want := map[string]int64{}
+	for _, issue := range database.IntegrityIssues {
+		want[issue] = 4
+	}
+	if !reflect.DeepEqual(out.Counts, want) {
+		t.Errorf("counts = %v, want %v", out.Counts, want)
+	}
+	calls := callsOf(repo, "Aggregate")
+	if len(calls) != len(database.IntegrityIssues) {
+		t.Fatalf("%d scans, want one per issue", len(calls))
+	}
+	for _, call := range calls {
+		pipeline := call.Filter.(mongo.Pipeline)
+		if last := pipeline[len(pipeline)-1]; last[0].Key != "$count" {
+			t.Errorf("scan ends with %v, want a $count", last)
+		}
+	}
+}
+
+// TestIntegrityPage lists one issue's offending products a page at a time.
+func TestIntegrityPage(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{
+		"total": bson.A{bson.M{"n": 3}},
+		"items": bson.A{bson.M{"groupId": "g2", "groupKey": "MOTOR-1", "productId": "MT-001", "value": "MTI"}},
+	}}}
+	app := newTestApp(testConfig(), repo, integrityRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/admin/integrity?issue=unknown_insurer&page=2&limit=1", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	var out struct {
+		Issue      string
+		TotalCount int64
+		Data       []database.IntegrityRow
+	}
+	decode(t, body, &out)
+	want := []database.IntegrityRow{{GroupID: "g2", GroupKey: "MOTOR-1", ProductID: "MT-001", Value: "MTI"}}
+	if out.Issue != database.IssueUnknownInsurer || out.TotalCount != 3 || !reflect.DeepEqual(out.Data, want) {
+		t.Errorf("page = %+v", out)
+	}
+
+	calls := callsOf(repo, "Aggregate")
+	if len(calls) != 1 {
+		t.Fatalf("%d scans, want 1", len(calls))
+	}
+	pipeline := calls[0].Filter.(mongo.Pipeline)
+	facet := pipeline[len(pipeline)-1][0].Value.(bson.M)
+	if items := facet["items"].(bson.A); !reflect.DeepEqual(items, bson.A{bson.M{"$skip": int64(1)}, bson.M{"$limit": 1}}) {
+		t.Errorf("page stages = %v, want $skip 1 and $limit 1", items)
+	}
+}
+
+// TestIntegrityDryRun checks that only wrongly cased statuses are offered
+// a fix, and that a dry run changes nothing.
+func TestIntegrityDryRun(t *testing.T) {
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(testConfig(), statusIssues{repo}, integrityRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/admin/integrity?fix=dryrun", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	var out struct {
+		Fix  string
+		Data []integrityFix
+	}
+	decode(t, body, &out)
+	want := []integrityFix{{Issue: database.IssueInvalidStatus, Field: "productStatus", From: "active", To: "ACTIVE", Products: 2}}
+	if out.Fix != "dryrun" || !reflect.DeepEqual(out.Data, want) {
+		t.Errorf("dry run = %+v, want %+v", out, want)
+	}
+}
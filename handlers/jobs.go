@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// jobBookkeepingTimeout bounds recording the end of a job run, which
+	// gets a budget of its own: a run that timed out has none left.
+	jobBookkeepingTimeout = 30 * time.Second
+	// propagationBatch is the number of group documents a propagation job
+	// updates per batch.
+	propagationBatch = 500
+	// propagationTimeout bounds one run of a propagation job. A run cut
+	// short, by this or a restart, is continued with the resume endpoint.
+	propagationTimeout = time.Hour
+)
+
+// startInsurerRename records a propagation job for an insurer renamed from
+// oldName to newName and runs it in the background.
+func (h *Handler) startInsurerRename(c *fiber.Ctx, ctx context.Context, code, oldName, newName string) (*database.Job, error) {
+	actor := "anonymous"
+	if p := middleware.PrincipalFrom(c); p != nil {
+		actor = p.Subject
+	}
+	now := database.Timestamp(h.now())
+	job := &database.Job{
+		ID:        primitive.NewObjectID(),
+		Kind:      database.JobInsurerRename,
+		Target:    "insurer:" + code,
+		State:     database.JobRunning,
+		Params:    map[string]string{"from": oldName, "to": newName},
+		Actor:     actor,
+		StartedAt: now,
+	}
+	if err := database.SaveJob(ctx, tenant(c), job); err != nil {
+		return nil, err
+	}
+	go h.runInsurerRename(tenant(c), *job, code, middleware.RequestIDFrom(c))
+	return job, nil
+}
+
+// runInsurerRename runs a propagation job to its end and records it in the
+// audit log. It outlives the request that started it, so it uses its own
+// context.
+func (h *Handler) runInsurerRename(t *database.Tenant, job database.Job, code, requestID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), propagationTimeout)
+	defer cancel()
+
+	err := database.PropagateInsurerName(ctx, t, &job, code, propagationBatch, h.cfg.Mongo.FlatSync)
+	finished := database.Timestamp(h.now())
+	job.FinishedAt = &finished
+	switch {
+	case err != nil:
+		job.State, job.Error = database.JobFailed, err.Error()
+		h.logger.Error("propagating insurer name", "job", job.ID.Hex(), "insurer", code, "error", err)
+	case job.Stale > 0:
+		job.State = database.JobIncomplete
+	default:
+		job.State = database.JobSucceeded
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), jobBookkeepingTimeout)
+	defer cancel()
+	if err := database.SaveJob(ctx, t, &job); err != nil {
+		h.logger.Error("saving job", "job", job.ID.Hex(), "error", err)
+	}
+	h.invalidateTenant(ctx, t.Name)
+
+	err = audit.Record(ctx, t.Audit, audit.Entry{
+		Actor:     job.Actor,
+		Action:    audit.ActionInsurerRename,
+		Target:    job.Target,
+		Changes:   []audit.Change{{Field: "insurer.insurerName", Old: job.Params["from"], New: job.Params["to"]}},
+		RequestID: requestID,
+		Timestamp: finished,
+	})
+	if err != nil {
+		h.logger.Error("recording insurer rename", "job", job.ID.Hex(), "error", err)
+	}
+}
+
+// GetJobs lists the tenant's most recent jobs, newest first.
+func (h *Handler) GetJobs(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	jobs := []database.Job{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := tenant(c).Jobs.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"startedAt": -1}).SetLimit(50))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &jobs)
+	})
+	if err != nil {
+		return queryError(c, "listing jobs", err)
+	}
+	return c.JSON(fiber.Map{"data": jobs})
+}
+
+// GetJob reports a job's progress.
+func (h *Handler) GetJob(c *fiber.Ctx) error {
+	job, ok, err := h.job(c)
+	if !ok {
+		return err
+	}
+	return c.JSON(job)
+}
+
+// ResumeJob reruns a job that failed, was cut short or came out
+// incomplete. A job still shown as running is refused unless ?force=true,
+// which is for jobs whose process died.
+func (h *Handler) ResumeJob(c *fiber.Ctx) error {
+	job, ok, err := h.job(c)
+	if !ok {
+		return err
+	}
+	if job.State == database.JobRunning && query(c, "force") != "true" {
+		return apierror.Send(c, fiber.StatusConflict, "JOB_RUNNING", "the job is still running; pass force=true if its process died")
+	}
+	if job.Kind != database.JobInsurerRename {
+		return apierror.Send(c, fiber.StatusBadRequest, "NOT_RESUMABLE", "jobs of kind "+job.Kind+" cannot be resumed")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	code := strings.TrimPrefix(job.Target, "insurer:")
+	job.State, job.Error, job.FinishedAt = database.JobRunning, "", nil
+	if err := database.SaveJob(ctx, tenant(c), &job); err != nil {
+		return queryError(c, "saving job", err)
+	}
+	go h.runInsurerRename(tenant(c), job, code, middleware.RequestIDFrom(c))
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// job loads the job named by the :id parameter, writing the error response
+// itself when ok is false.
+func (h *Handler) job(c *fiber.Ctx) (job database.Job, ok bool, err error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return job, false, apierror.Send(c, fiber.StatusNotFound, "JOB_NOT_FOUND", "job "+c.Params("id")+" does not exist")
+	}
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	err = database.Breaker.Do(ctx, func() error {
+		var err error
+		job, ok, err = database.FindJob(ctx, tenant(c), id)
+		return err
+	})
+	if err != nil {
+		return job, false, queryError(c, "finding job", err)
+	}
+	if !ok {
+		return job, false, apierror.Send(c, fiber.StatusNotFound, "JOB_NOT_FOUND", "job "+c.Params("id")+" does not exist")
+	}
+	return job, true, nil
+}
@@ -0,0 +1,122 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// waitJob polls the job until it is no longer running.
+func waitJob(t *testing.T, app *fiber.App, id string) database.Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, out := call(t, app, fiber.MethodGet, "/admin/jobs/"+id, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("job %s: %d %s", id, resp.StatusCode, out)
+		}
+		var job database.Job
+		if err := json.Unmarshal(out, &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.State != database.JobRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still running", id)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestIntegrationInsurerRenameJob renames TIP through the insurer endpoint
+// and follows the propagation job it starts to the products and the audit
+// log.
+func TestIntegrationInsurerRenameJob(t *testing.T) {
+	app := integrationApp(t, nil)
+	insurerMaster(t, app)
+
+	resp, out := call(t, app, fiber.MethodPut, "/insurers/TIP", `{"_id": "INS-TIP", "insurerName": "Dhipaya Insurance"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename: %d %s", resp.StatusCode, out)
+	}
+	var updated struct {
+		PropagationJob string `json:"propagationJob"`
+	}
+	if err := json.Unmarshal(out, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.PropagationJob == "" {
+		t.Fatalf("rename started no job: %s", out)
+	}
+
+	job := waitJob(t, app, updated.PropagationJob)
+	if job.State != database.JobSucceeded || job.Kind != database.JobInsurerRename || job.Target != "insurer:TIP" ||
+		job.Groups == 0 || job.Stale != 0 || job.FinishedAt == nil {
+		t.Errorf("job = %+v, want a succeeded rename of TIP with nothing stale", job)
+	}
+	if job.Params["from"] != "ทิพยประกันภัย" || job.Params["to"] != "Dhipaya Insurance" || job.Actor != "integration" {
+		t.Errorf("job params = %v by %q", job.Params, job.Actor)
+	}
+
+	// The fixtures have no search shadows, so TIP's products are picked
+	// out of the whole listing.
+	tip := 0
+	for _, p := range list(t, app, "/products?limit=100").Data {
+		if p.Insurer.InsurerCode != "TIP" {
+			continue
+		}
+		tip++
+		if p.Insurer.InsurerName != "Dhipaya Insurance" {
+			t.Errorf("%s insurerName = %q, want the new name", p.ID, p.Insurer.InsurerName)
+		}
+	}
+	if tip != 2 {
+		t.Errorf("%d TIP products, want 2", tip)
+	}
+
+	resp, out = call(t, app, fiber.MethodGet, "/audit?actor=integration", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("audit: %d %s", resp.StatusCode, out)
+	}
+	var entries struct{ Data []audit.Entry }
+	if err := json.Unmarshal(out, &entries); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range entries.Data {
+		if e.Action == audit.ActionInsurerRename && e.Target == "insurer:TIP" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no %s entry in %s", audit.ActionInsurerRename, out)
+	}
+
+	resp, out = call(t, app, fiber.MethodGet, "/admin/jobs", "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(out), updated.PropagationJob) {
+		t.Errorf("jobs: %d %s, want the job listed", resp.StatusCode, out)
+	}
+
+	// A finished job can be resumed; it finds nothing left to do.
+	resp, out = call(t, app, fiber.MethodPost, "/admin/jobs/"+updated.PropagationJob+"/resume", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("resume: %d %s, want 202", resp.StatusCode, out)
+	}
+	if resumed := waitJob(t, app, updated.PropagationJob); resumed.State != database.JobSucceeded || resumed.Groups != job.Groups {
+		t.Errorf("resumed job = %+v, want it succeeded without more groups", resumed)
+	}
+
+	missing := "/admin/jobs/" + strings.Repeat("0", 24)
+	if resp, out := call(t, app, fiber.MethodGet, missing, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: %d %s, want 404", resp.StatusCode, out)
+	}
+}
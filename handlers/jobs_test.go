@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+func jobRoutes(app *fiber.App, h *Handler) {
+	app.Get("/admin/jobs/:id", h.GetJob)
+	app.Post("/admin/jobs/:id/resume", h.ResumeJob)
+}
+
+// TestJobMalformedID checks that an id that is not an ObjectID is a job
+// that does not exist, answered without a query.
+func TestJobMalformedID(t *testing.T) {
+	for _, tt := range []struct{ method, target string }{
+		{fiber.MethodGet, "/admin/jobs/not-a-job"},
+		{fiber.MethodPost, "/admin/jobs/not-a-job/resume?force=true"},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, jobRoutes)
+			resp, body := do(t, app, tt.method, tt.target, "")
+			if resp.StatusCode != http.StatusNotFound || errorCode(body) != "JOB_NOT_FOUND" {
+				t.Errorf("%d %s, want 404 JOB_NOT_FOUND", resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Pagination is a validated page/limit pair.
+type Pagination struct {
+	Page  int
+	Limit int
+}
+
+// Skip is the number of entries before the page.
+func (p Pagination) Skip() int64 {
+	return int64((p.Page - 1) * p.Limit)
+}
+
+// parsePagination reads page and limit from the query string. Absent
+// parameters take their defaults; anything else that is not an integer in
+// range is answered with a 400 naming the parameter, in which case ok is
+// false and the returned error is the response's.
+func parsePagination(c *fiber.Ctx, cfg config.PaginationConfig) (p Pagination, ok bool, err error) {
+	p = Pagination{Page: 1, Limit: cfg.DefaultLimit}
+	if v := query(c, "page"); v != "" {
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil || n < 1 {
+			return p, false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_PAGINATION", "page must be an integer of at least 1")
+		}
+		p.Page = n
+	}
+	if v := query(c, "limit"); v != "" {
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil || n < 1 || n > cfg.MaxLimit {
+			return p, false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_PAGINATION", fmt.Sprintf("limit must be an integer from 1 to %d", cfg.MaxLimit))
+		}
+		p.Limit = n
+	}
+	// A page this far out is empty anyway, but its skip would overflow.
+	if p.Page-1 > math.MaxInt32/p.Limit {
+		return p, false, apierror.Send(c, fiber.StatusBadRequest, "INVALID_PAGINATION", fmt.Sprintf("page must be at most %d for limit %d", math.MaxInt32/p.Limit+1, p.Limit))
+	}
+	return p, true, nil
+}
+
+// setPageLinks sets the RFC 5988 Link header of page p of a listing of
+// total entries: first, prev, next and last, leaving out the ones that
+// do not exist. A negative total is unknown; last is left out then and
+// next given while the page's n entries fill it.
+func setPageLinks(c *fiber.Ctx, p Pagination, total int64, n int) {
+	links := []string{pageURL(c, 1), "first"}
+	if p.Page > 1 {
+		links = append(links, pageURL(c, p.Page-1), "prev")
+	}
+	last := -1
+	if total >= 0 {
+		last = int((total + int64(p.Limit) - 1) / int64(p.Limit))
+		if last < 1 {
+			last = 1
+		}
+	}
+	if last >= 0 && p.Page < last || last < 0 && n >= p.Limit {
+		links = append(links, pageURL(c, p.Page+1), "next")
+	}
+	if last >= 0 {
+		links = append(links, pageURL(c, last), "last")
+	}
+	c.Links(links...)
+}
+
+// pageURL is the URL of page of the current listing: the request's path
+// and query, in their order, with page replaced.
+func pageURL(c *fiber.Ctx, page int) string {
+	var b strings.Builder
+	b.WriteString(c.Path())
+	sep := "?"
+	add := func(k, v string) {
+		b.WriteString(sep + url.QueryEscape(k) + "=" + url.QueryEscape(v))
+		sep = "&"
+	}
+	c.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		if string(k) != "page" {
+			add(string(k), string(v))
+		}
+	})
+	add("page", strconv.Itoa(page))
+	return b.String()
+}
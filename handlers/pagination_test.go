@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+func paginationRoutes(app *fiber.App, h *Handler) {
+	app.Get("/pages", func(c *fiber.Ctx) error {
+		p, ok, err := parsePagination(c, h.cfg.Pagination)
+		if !ok {
+			return err
+		}
+		return c.JSON(p)
+	})
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    Pagination
+		message string
+	}{
+		{query: "", want: Pagination{Page: 1, Limit: 20}},
+		{query: "page=&limit=", want: Pagination{Page: 1, Limit: 20}},
+		{query: "page=3&limit=5", want: Pagination{Page: 3, Limit: 5}},
+		{query: "limit=100", want: Pagination{Page: 1, Limit: 100}},
+		{query: "page=0", message: "page must be an integer of at least 1"},
+		{query: "page=-5", message: "page must be an integer of at least 1"},
+		{query: "page=abc", message: "page must be an integer of at least 1"},
+		{query: "page=1.5", message: "page must be an integer of at least 1"},
+		{query: "limit=0", message: "limit must be an integer from 1 to 100"},
+		{query: "limit=-1", message: "limit must be an integer from 1 to 100"},
+		{query: "limit=101", message: "limit must be an integer from 1 to 100"},
+		{query: "limit=abc", message: "limit must be an integer from 1 to 100"},
+		{query: "page=2147483647&limit=100", message: "page must be at most 21474837 for limit 100"},
+	}
+	app := newTestApp(testConfig(), &mocks.ProductRepository{}, paginationRoutes)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, body := do(t, app, fiber.MethodGet, "/pages?"+tt.query, "")
+			if tt.message == "" {
+				var p Pagination
+				decode(t, body, &p)
+				if resp.StatusCode != http.StatusOK || p != tt.want {
+					t.Errorf("%d %s, want %+v", resp.StatusCode, body, tt.want)
+				}
+				return
+			}
+			var e apierror.Body
+			decode(t, body, &e)
+			if resp.StatusCode != http.StatusBadRequest || e.Error.Code != "INVALID_PAGINATION" || e.Error.Message != tt.message {
+				t.Errorf("%d %s, want 400 INVALID_PAGINATION %q", resp.StatusCode, body, tt.message)
+			}
+		})
+	}
+}
+
+func TestPaginationSkip(t *testing.T) {
+	for _, tt := range []struct {
+		p    Pagination
+		want int64
+	}{
+		{Pagination{Page: 1, Limit: 20}, 0},
+		{Pagination{Page: 3, Limit: 5}, 10},
+		{Pagination{Page: 21474837, Limit: 100}, 2147483600},
+	} {
+		if got := tt.p.Skip(); got != tt.want {
+			t.Errorf("%+v.Skip() = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+}
+
+func FuzzParsePagination(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s, "20")
+	}
+	for _, pair := range [][2]string{
+		{"1", "1"}, {"0", "20"}, {"-1", "20"}, {"2", "101"}, {"1e3", "20"},
+		{"๒", "20"}, {"2147483647", "100"}, {"9223372036854775808", "20"}, {" 2", "20"},
+	} {
+		f.Add(pair[0], pair[1])
+	}
+	cfg := testConfig()
+	app := newTestApp(cfg, &mocks.ProductRepository{}, paginationRoutes)
+	f.Fuzz(func(t *testing.T, page, limit string) {
+		target := "/pages?" + url.Values{"page": {page}, "limit": {limit}}.Encode()
+		if len(target) > fiber.DefaultReadBufferSize/2 {
+			// The server turns the request away before any handler.
+			t.Skip("the request line exceeds the read buffer")
+		}
+		resp, body := do(t, app, fiber.MethodGet, target, "")
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			if code := errorCode(body); code != "INVALID_PAGINATION" {
+				t.Fatalf("page %q limit %q: code %q, want INVALID_PAGINATION", page, limit, code)
+			}
+		case http.StatusOK:
+			var p Pagination
+			decode(t, body, &p)
+			if p.Page < 1 || p.Limit < 1 || p.Limit > cfg.Pagination.MaxLimit || p.Skip() < 0 {
+				t.Fatalf("page %q limit %q parsed as %+v", page, limit, p)
+			}
+		default:
+			t.Fatalf("page %q limit %q: %d %s", page, limit, resp.StatusCode, body)
+		}
+	})
+}
+
+func linkRoutes(app *fiber.App, h *Handler) {
+	app.Get("/links", func(c *fiber.Ctx) error {
+		return c.SendString(pageURL(c, 2))
+	})
+	app.Get("/products/:id/history", func(c *fiber.Ctx) error {
+		return c.SendString(pageURL(c, 2))
+	})
+}
+
+func TestPageURL(t *testing.T) {
+	tests := []struct {
+		name, target, want string
+	}{
+		{"no query", "/links", "/links?page=2"},
+		{"page replaced and moved last", "/links?page=1&limit=5&status=ACTIVE", "/links?limit=5&status=ACTIVE&page=2"},
+		{"every page dropped", "/links?page=1&page=9", "/links?page=2"},
+		{"repeated keys keep their order", "/links?b=2&a=1&b=3", "/links?b=2&a=1&b=3&page=2"},
+		{
+			name:   "Thai",
+			target: "/links?param=" + url.QueryEscape("ประกัน ภัย"),
+			want:   "/links?param=%E0%B8%9B%E0%B8%A3%E0%B8%B0%E0%B8%81%E0%B8%B1%E0%B8%99+%E0%B8%A0%E0%B8%B1%E0%B8%A2&page=2",
+		},
+		{
+			name:   "decomposed Thai",
+			target: "/links?param=" + url.QueryEscape("ํา"),
+			want:   "/links?param=%E0%B9%8D%E0%B8%B2&page=2",
+		},
+		{
+			name:   "reserved characters",
+			target: "/links?q=" + url.QueryEscape(`name=="a;b",code!=MT-*&x`) + "&filter=" + url.QueryEscape(`{"eq":{"name":"<a>, #1 100%"}}`),
+			want:   "/links?q=name%3D%3D%22a%3Bb%22%2Ccode%21%3DMT-%2A%26x&filter=%7B%22eq%22%3A%7B%22name%22%3A%22%3Ca%3E%2C+%231+100%25%22%7D%7D&page=2",
+		},
+		{"reserved characters in the key", "/links?a%26b%3D=c", "/links?a%26b%3D=c&page=2"},
+		{"escaped path", "/products/MT%2F001%3F/history?limit=5", "/products/MT%2F001%3F/history?limit=5&page=2"},
+	}
+	app := newTestApp(testConfig(), &mocks.ProductRepository{}, linkRoutes)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if string(body) != tt.want {
+				t.Errorf("pageURL = %s\n    want %s", body, tt.want)
+			}
+			// The link leads back to the same listing, one page on.
+			got, err := url.Parse(string(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig, _ := url.Parse(tt.target)
+			want := orig.Query()
+			want.Set("page", "2")
+			if !reflect.DeepEqual(got.Query(), want) || got.EscapedPath() != orig.EscapedPath() {
+				t.Errorf("pageURL decodes to %s %v, want %s %v", got.EscapedPath(), got.Query(), orig.EscapedPath(), want)
+			}
+		})
+	}
+}
+
+func TestSetPageLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  int
+		total int64
+		n     int
+		want  string
+	}{
+		{"first of three", 1, 45, 20, `</l?page=1>; rel="first",</l?page=2>; rel="next",</l?page=3>; rel="last"`},
+		{"middle", 2, 45, 20, `</l?page=1>; rel="first",</l?page=1>; rel="prev",</l?page=3>; rel="next",</l?page=3>; rel="last"`},
+		{"final page has no next", 3, 45, 5, `</l?page=1>; rel="first",</l?page=2>; rel="prev",</l?page=3>; rel="last"`},
+		{"exactly full", 2, 40, 20, `</l?page=1>; rel="first",</l?page=1>; rel="prev",</l?page=2>; rel="last"`},
+		{"empty listing", 1, 0, 0, `</l?page=1>; rel="first",</l?page=1>; rel="last"`},
+		{"past the end", 7, 45, 0, `</l?page=1>; rel="first",</l?page=6>; rel="prev",</l?page=3>; rel="last"`},
+		{"unknown total, full page", 4, -1, 20, `</l?page=1>; rel="first",</l?page=3>; rel="prev",</l?page=5>; rel="next"`},
+		{"unknown total, short page", 4, -1, 7, `</l?page=1>; rel="first",</l?page=3>; rel="prev"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, func(app *fiber.App, h *Handler) {
+				app.Get("/l", func(c *fiber.Ctx) error {
+					setPageLinks(c, Pagination{Page: tt.page, Limit: 20}, tt.total, tt.n)
+					return nil
+				})
+			})
+			resp, _ := do(t, app, fiber.MethodGet, fmt.Sprintf("/l?page=%d", tt.page), "")
+			if got := resp.Header.Get(fiber.HeaderLink); got != tt.want {
+				t.Errorf("Link = %s\n want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetProductsLinks checks the Link header of the listing itself, for
+// a search with Thai text and a reserved character.
+func TestGetProductsLinks(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}, Total: 2}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?limit=1&status=ACTIVE,DRAFT&param="+url.QueryEscape("Plus ประกัน&"), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	base := "/products?limit=1&status=ACTIVE%2CDRAFT&param=Plus+%E0%B8%9B%E0%B8%A3%E0%B8%B0%E0%B8%81%E0%B8%B1%E0%B8%99%26"
+	want := "<" + base + `&page=1>; rel="first",<` + base + `&page=2>; rel="next",<` + base + `&page=2>; rel="last"`
+	if got := resp.Header.Get(fiber.HeaderLink); got != want {
+		t.Errorf("Link = %s\n want %s", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// query reads a string query parameter the way every listing endpoint
+// should: valid UTF-8, NFC-normalized, without zero-width characters and
+// surrounding whitespace. A value that is blank after that counts as absent and yields
+// def.
+func query(c *fiber.Ctx, key string, def ...string) string {
+	v := strings.TrimSpace(strings.Map(dropInvisible, database.Normalize(strings.ToValidUTF8(c.Query(key), ""))))
+	if v == "" && len(def) > 0 {
+		return def[0]
+	}
+	return v
+}
+
+var rejectedSearches = metrics.NewCounter("search_input_rejected")
+
+// searchQuery is query for the free-text ?param=, which additionally has
+// inner runs of whitespace collapsed to single spaces. Terms longer than
+// the configured maximum are answered with a 400; ok is false then.
+func (h *Handler) searchQuery(c *fiber.Ctx) (term string, ok bool, err error) {
+	term = strings.Join(strings.Fields(query(c, "param")), " ")
+	if n := utf8.RuneCountInString(term); n > h.cfg.Search.MaxLength {
+		rejectedSearches.Inc("too_long")
+		return "", false, apierror.Send(c, fiber.StatusBadRequest, "SEARCH_TOO_LONG",
+			fmt.Sprintf("param must be at most %d characters, got %d", h.cfg.Search.MaxLength, n))
+	}
+	return term, true, nil
+}
+
+// dropInvisible removes control, zero-width and other format characters
+// pasted along with search terms. Whitespace controls are kept for the
+// caller to trim or collapse.
+func dropInvisible(r rune) rune {
+	if unicode.Is(unicode.Cf, r) || unicode.IsControl(r) && !unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"expvar"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestListParamsNormalization(t *testing.T) {
+	var got ListParams
+	app := newTestApp(testConfig(), &mocks.ProductRepository{}, func(app *fiber.App, h *Handler) {
+		app.Get("/params", func(c *fiber.Ctx) error {
+			params, ok, err := h.listParams(c)
+			if !ok {
+				return err
+			}
+			got = params
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+	})
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		field func(ListParams) string
+		want  string
+	}{
+		{"spaces", "status", " active ", func(p ListParams) string { return p.Status }, "active"},
+		{"tabs", "status", "\tACTIVE\t", func(p ListParams) string { return p.Status }, "ACTIVE"},
+		{"newlines", "code", "MT-001\r\n", func(p ListParams) string { return p.Code }, "MT-001"},
+		{"zero-width characters", "group", "\u200bMOTOR\u200d-1\ufeff", func(p ListParams) string { return p.Group }, "MOTOR-1"},
+		{"control characters", "group", "MOTOR\x00-1\x7f", func(p ListParams) string { return p.Group }, "MOTOR-1"},
+		{"invalid UTF-8", "group", "MOTOR\xff-1", func(p ListParams) string { return p.Group }, "MOTOR-1"},
+		{"decomposed accents", "group", "cafe\u0301", func(p ListParams) string { return p.Group }, "caf\u00e9"},
+		{"whitespace only", "status", " \t\n\u200b", func(p ListParams) string { return p.Status }, ""},
+		{"search runs of whitespace", "param", "  motor \t\n  insurance ", func(p ListParams) string { return p.Search }, "motor insurance"},
+		{"search zero-width spaces between words", "param", "ประกัน\u200b \u200bรถยนต์", func(p ListParams) string { return p.Search }, "ประกัน รถยนต์"},
+		{"search whitespace only", "param", "\n\t ", func(p ListParams) string { return p.Search }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ListParams{}
+			resp, body := do(t, app, fiber.MethodGet, "/params?"+tt.key+"="+url.QueryEscape(tt.value), "")
+			if resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("%d %s", resp.StatusCode, body)
+			}
+			if v := tt.field(got); v != tt.want {
+				t.Errorf("%s=%q read as %q, want %q", tt.key, tt.value, v, tt.want)
+			}
+		})
+	}
+
+	// The length limit counts the term after normalization.
+	padded := "  " + strings.Repeat("ก", 100) + "\u200b\t"
+	if resp, body := do(t, app, fiber.MethodGet, "/params?param="+url.QueryEscape(padded), ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("a term at the limit once trimmed: %d %s", resp.StatusCode, body)
+	}
+	resp, body := do(t, app, fiber.MethodGet, "/params?param="+url.QueryEscape(strings.Repeat("ก", 101)), "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "SEARCH_TOO_LONG" {
+		t.Errorf("a term over the limit: %d %s, want 400 SEARCH_TOO_LONG", resp.StatusCode, body)
+	}
+}
+
+// TestQueryBlankIsAbsent checks that a blank value yields the default, as
+// an absent one does.
+func TestQueryBlankIsAbsent(t *testing.T) {
+	var got []string
+	app := newTestApp(testConfig(), &mocks.ProductRepository{}, func(app *fiber.App, h *Handler) {
+		app.Get("/format", func(c *fiber.Ctx) error {
+			got = append(got, query(c, "format", "ndjson"))
+			return nil
+		})
+	})
+	for _, target := range []string{"/format", "/format?format=", "/format?format=%20%0A%E2%80%8B", "/format?format=+csv+"} {
+		do(t, app, fiber.MethodGet, target, "")
+	}
+	want := []string{"ndjson", "ndjson", "ndjson", "csv"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+}
+
+// TestGetProductsTrimmedStatus is the reported case: a padded status
+// filters as if it were typed cleanly.
+func TestGetProductsTrimmedStatus(t *testing.T) {
+	clean := &mocks.ProductRepository{}
+	padded := &mocks.ProductRepository{}
+	for repo, target := range map[*mocks.ProductRepository]string{
+		clean:  "/products?status=ACTIVE&param=motor",
+		padded: "/products?status=%20active%20%0A&param=%09motor%E2%80%8B%20",
+	} {
+		app := newTestApp(testConfig(), repo, listingRoutes)
+		if resp, body := do(t, app, fiber.MethodGet, target, ""); resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, resp.StatusCode, body)
+		}
+	}
+	want, got := callsOf(clean, "Find"), callsOf(padded, "Find")
+	if len(want) == 0 || len(got) != len(want) {
+		t.Fatalf("Finds = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i].Filter, want[i].Filter) {
+			t.Errorf("Find %d filter = %#v, want %#v", i, got[i].Filter, want[i].Filter)
+		}
+	}
+}
+
+func rejectedSearchCount() int64 {
+	v, _ := expvar.Get("search_input_rejected").(*expvar.Map).Get("too_long").(*expvar.Int)
+	if v == nil {
+		return 0
+	}
+	return v.Value()
+}
+
+// TestSearchTooLong checks that every endpoint taking ?param= turns away an
+// oversized term before querying, and counts it.
+func TestSearchTooLong(t *testing.T) {
+	long := url.QueryEscape(strings.Repeat("ก", testConfig().Search.MaxLength+1))
+	for _, target := range []string{
+		"/products?param=" + long,
+		"/products/export?param=" + long,
+		"/products/stats?groupBy=status&param=" + long,
+	} {
+		t.Run(strings.SplitN(target, "?", 2)[0], func(t *testing.T) {
+			repo := &mocks.ProductRepository{}
+			app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+				app.Get("/products/export", h.ExportProducts)
+				app.Get("/products/stats", h.GetProductStats)
+				listingRoutes(app, h)
+			})
+			before := rejectedSearchCount()
+			resp, body := do(t, app, fiber.MethodGet, target, "")
+			if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "SEARCH_TOO_LONG" {
+				t.Errorf("%d %s, want 400 SEARCH_TOO_LONG", resp.StatusCode, body)
+			}
+			if !strings.Contains(string(body), "param must be at most 100 characters, got 101") {
+				t.Errorf("message does not give the limit and length: %s", body)
+			}
+			if calls := repo.Calls(); len(calls) != 0 {
+				t.Errorf("an oversized term queried %+v", calls)
+			}
+			if n := rejectedSearchCount() - before; n != 1 {
+				t.Errorf("search_input_rejected{too_long} rose by %d, want 1", n)
+			}
+		})
+	}
+}
+
+func FuzzSearchQuery(f *testing.F) {
+	for _, s := range append(fuzzSeeds(), "\x00\x1b[31mred", "a\u200b\u200cb", " \t\r\n ", strings.Repeat(" x", 100)) {
+		f.Add(s)
+	}
+	cfg := testConfig()
+	var term string
+	app := newTestApp(cfg, &mocks.ProductRepository{}, func(app *fiber.App, h *Handler) {
+		app.Get("/search", func(c *fiber.Ctx) error {
+			params, ok, err := h.listParams(c)
+			if !ok {
+				return err
+			}
+			term = params.Search
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+	})
+	f.Fuzz(func(t *testing.T, input string) {
+		target := "/search?" + url.Values{"param": {input}}.Encode()
+		if len(target) > fiber.DefaultReadBufferSize/2 {
+			t.Skip("the request line exceeds the read buffer")
+		}
+		resp, body := do(t, app, fiber.MethodGet, target, "")
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			if code := errorCode(body); code != "SEARCH_TOO_LONG" {
+				t.Fatalf("param %q: code %q, want SEARCH_TOO_LONG", input, code)
+			}
+		case http.StatusNoContent:
+			if !utf8.ValidString(term) || utf8.RuneCountInString(term) > cfg.Search.MaxLength {
+				t.Fatalf("param %q read as %q", input, term)
+			}
+			if term != strings.Join(strings.Fields(term), " ") {
+				t.Fatalf("param %q read as %q, with whitespace left in", input, term)
+			}
+			for _, r := range term {
+				if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+					t.Fatalf("param %q read as %q, with %U left in", input, term, r)
+				}
+			}
+		default:
+			t.Fatalf("param %q: %d %s", input, resp.StatusCode, body)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// aggregateProducts is findProducts done server-side: productList is
+// unwound, filtered per item, paged and projected into the Product shape,
+// so only the requested page of products crosses the wire.
+func (h *Handler) aggregateProducts(c *fiber.Ctx, ctx context.Context, filter bson.M, opts *options.FindOptions) ([]Product, []Warning, error) {
+	pipeline := productPipeline(filter, opts.Sort, *opts.Skip, *opts.Limit)
+
+	rows := []productRow{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline, aggregateOptions(opts))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &rows)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	products, warnings := splitRows(tenant(c).Name, rows)
+	return products, warnings, nil
+}
+
+// aggregateOptions carries the listing's batch size, collation, time limit
+// and hint over to the aggregation.
+func aggregateOptions(opts *options.FindOptions) *options.AggregateOptions {
+	agg := options.Aggregate().
+		SetBatchSize(int32(*opts.Limit)).
+		SetCollation(opts.Collation).
+		SetMaxTime(*opts.MaxTime)
+	if opts.Hint != nil {
+		agg.SetHint(opts.Hint)
+	}
+	return agg
+}
+
+// productPipeline builds the flattening pipeline for one page. Malformed
+// entries take their slot in the page so they can be reported.
+func productPipeline(filter bson.M, sort interface{}, skip, limit int64) mongo.Pipeline {
+	pipeline := database.FlattenStagesKeepingMalformed(filter)
+	if sort != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+	}
+	return append(pipeline,
+		bson.D{{Key: "$skip", Value: skip}},
+		bson.D{{Key: "$limit", Value: limit}},
+		bson.D{{Key: "$project", Value: database.ListingProjection}},
+	)
+}
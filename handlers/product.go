@@ -2,193 +2,481 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/MaMaTidarat/poc-app/apierror"
 	"github.com/MaMaTidarat/poc-app/database"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
 )
 
 type Product struct {
-	ID           string       `json:"id"`
-	ProductName  string       `json:"productName"`
-	ProductGroup ProductGroup `json:"productGroup"`
-	ProductType  ProductType  `json:"productType"`
-	Insurer      Insurer      `json:"insurer"`
-	Brokers      []Broker     `json:"brokers"`
-	Status       string       `json:"status"`
+	ID           string        `json:"id" bson:"id"`
+	Code         string        `json:"code,omitempty" bson:"code"`
+	ProductName  string        `json:"productName" bson:"productName"`
+	ProductGroup ProductGroup  `json:"productGroup" bson:"productGroup"`
+	ProductType  ProductType   `json:"productType" bson:"productType"`
+	Insurer      Insurer       `json:"insurer" bson:"insurer"`
+	Brokers      []Broker      `json:"brokers" bson:"brokers"`
+	Status       ProductStatus `json:"status" bson:"status"`
+	CreatedAt    *time.Time    `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	UpdatedAt    *time.Time    `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+	CreatedBy    string        `json:"createdBy,omitempty" bson:"createdBy"`
+	UpdatedBy    string        `json:"updatedBy,omitempty" bson:"updatedBy"`
+}
+
+// withBrokers returns p with a nil Brokers replaced by an empty slice, so it
+// encodes as [] rather than null.
+func (p Product) withBrokers() Product {
+	if p.Brokers == nil {
+		p.Brokers = []Broker{}
+	}
+	return p
 }
 
 type ProductGroup struct {
-	Name string `json:"name"`
-	Key  string `json:"key"`
+	Name string `json:"name" bson:"name"`
+	Key  string `json:"key" bson:"key"`
 }
 
 type ProductType struct {
-	Name string `json:"name"`
-	Key  string `json:"key"`
+	Name string `json:"name" bson:"name"`
+	Key  string `json:"key" bson:"key"`
 }
 
 type Insurer struct {
-	ID          string `json:"_id"`
-	InsurerCode string `json:"insurerCode"`
-	InsurerName string `json:"insurerName"`
+	ID          string `json:"_id" bson:"_id"`
+	InsurerCode string `json:"insurerCode" bson:"insurerCode"`
+	InsurerName string `json:"insurerName" bson:"insurerName"`
 }
 
 type Broker struct {
-	Key         string `json:"key"`
-	ChannelName string `json:"channelName"`
+	Key         string `json:"key" bson:"key"`
+	ChannelName string `json:"channelName" bson:"channelName"`
 }
 
+var regexMeta = regexp.MustCompile(`[.*+?^${}()|[\]\\]`)
+
+// SanitizeString escapes input for use as a literal in a regex. Invalid
+// UTF-8 is dropped, since neither Go nor Mongo accept it in a pattern.
 func SanitizeString(input string) string {
-	re := regexp.MustCompile(`[.*+?^${}()|[\]\\]`)
-	return re.ReplaceAllString(input, `\$0`)
+	return regexMeta.ReplaceAllString(strings.ToValidUTF8(input, ""), `\$0`)
 }
 
-func GetProducts(c *fiber.Ctx) error {
-	param := c.Query("param")
-	status := c.Query("status")
-	pageStr := c.Query("page", "1")
-	limitStr := c.Query("limit", "10")
-
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
+func (h *Handler) GetProducts(c *fiber.Ctx) error {
+	if ids := query(c, "ids"); ids != "" {
+		return h.productsByID(c, ids)
+	}
+	params, ok, err := h.listParams(c)
+	if !ok {
+		return err
 	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 {
-		limit = 10
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
 	}
+	page, limit := paging.Page, paging.Limit
 
-	log.Printf("Received param: %s", param)
-	log.Printf("Received status: %s", status)
-	log.Printf("Page: %d, Limit: %d", page, limit)
+	h.logger.Info("listing products", "param", params.Search, "status", params.Status, "page", page, "limit", limit)
 
-	filter := bson.M{}
-	if param != "" {
-		sanitizedParam := SanitizeString(param)
-		log.Printf("Sanitized param: %s", sanitizedParam)
-		filter["$or"] = []bson.M{
-			{"productType.key": bson.M{"$regex": sanitizedParam, "$options": "i"}},
-			{"key": bson.M{"$regex": sanitizedParam, "$options": "i"}},
-			{"productList.productName": bson.M{"$regex": sanitizedParam, "$options": "i"}},
-			{"productList.insurer.insurerCode": bson.M{"$regex": sanitizedParam, "$options": "i"}},
-			{"productList.brokers.key": bson.M{"$regex": sanitizedParam, "$options": "i"}},
-		}
-		log.Printf("Filter created: %+v", filter)
+	collationName := query(c, "collation", h.cfg.Mongo.Collation)
+	collation, ok := database.Collations[collationName]
+	if !ok && collationName != "simple" {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_COLLATION", "collation must be th, en or simple")
 	}
 
-	if status != "" {
-		sanitizedStatus := SanitizeString(strings.ToUpper(status))
-		log.Printf("Sanitized status: %s", sanitizedStatus)
-		filter["productList.productStatus"] = bson.M{"$regex": sanitizedStatus, "$options": "i"}
+	builder, err := productFilterBuilder(params)
+	if err != nil {
+		return filterFailed(c, err)
+	}
+	filter := builder.Build()
+	if ok, err := h.knownGroup(c, params.Group); !ok {
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Count total number of products with the specific filter
-	// count, err := database.ProductCollection.CountDocuments(ctx, filter)
-	// if err != nil {
-	// 	log.Printf("Error counting products: %v", err)
-	// 	return c.Status(500).SendString(err.Error())
-	// }
+	switch query(c, "flatten", "true") {
+	case "true":
+	case "false":
+		return h.listGroups(c, filter, paging)
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_FLATTEN", "flatten must be true or false")
+	}
 
 	// Fetch paginated and sorted results
 	opts := options.Find().
-		SetSort(bson.D{{"productList.productName", 1}}).
-		SetSkip(int64((page - 1) * limit)).
-		SetLimit(int64(limit))
+		// The id tie-break keeps paging stable across identical requests.
+		SetSort(bson.D{{Key: "productList.productName", Value: 1}, {Key: "productList.id", Value: 1}}).
+		SetSkip(paging.Skip()).
+		SetLimit(int64(limit)).
+		SetProjection(database.GroupProjection).
+		SetBatchSize(int32(limit)).
+		SetCollation(collation).
+		SetMaxTime(h.maxTime(c))
+	hint, ok, err := h.listingHint(c, filter)
+	if !ok {
+		return err
+	}
+	if hint != "" {
+		opts.SetHint(hint)
+	}
+
+	if h.streams(limit) {
+		return h.streamProducts(c, filter, opts, paging)
+	}
+
+	cacheKey := fmt.Sprintf("products|param=%s|searchFields=%s|status=%s|missing=%s|code=%s|group=%s|brokers=%s-%s|filter=%s|q=%s|page=%d|limit=%d|collation=%s|hint=%s|strict=%t", params.Search, params.SearchFields, strings.ToUpper(params.Status), params.Missing, strings.ToUpper(params.Code), params.Group, params.MinBrokers, params.MaxBrokers, params.Filter, params.Q, page, limit, collationName, hint, strict(c))
+	if body, ok := h.cachedPage(c, cacheKey); ok {
+		setListingHeadersFromBody(c, paging, body)
+		if c.Fresh() {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return sendCached(c, body)
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
 
-	cursor, err := database.ProductCollection.Find(ctx, filter, opts)
+	body, err := h.loadPage(c, ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		// Shared with other callers, so on the server's time limit.
+		opts.SetMaxTime(h.maxTime(c))
+		page, err := h.listProducts(c, ctx, builder, filter, opts)
+		if err == nil && len(page.Data) == 0 && params.Search != "" {
+			page.Suggestions = h.searchSuggestions(c, ctx, params.Search)
+		}
+		return page, err
+	})
+	var malformed *malformedError
+	if errors.As(err, &malformed) {
+		return apierror.SendDetails(c, fiber.StatusInternalServerError, "MALFORMED_DATA",
+			"some products could not be mapped; retry without strict to get the rest", malformed.warnings)
+	}
 	if err != nil {
-		log.Printf("Error finding products: %v", err)
-		return c.Status(500).SendString(err.Error())
+		return queryError(c, "finding products", err)
+	}
+	setListingHeadersFromBody(c, paging, body)
+	if c.Fresh() {
+		return c.SendStatus(fiber.StatusNotModified)
 	}
-	defer cursor.Close(ctx)
+	return sendPage(c, body)
+}
 
-	var results []bson.M
-	if err = cursor.All(ctx, &results); err != nil {
-		log.Printf("Error decoding products: %v", err)
-		return c.Status(500).SendString(err.Error())
+// listProducts runs the page query and the total count concurrently. The
+// first failure cancels the other query through the shared context.
+func (h *Handler) listProducts(c *fiber.Ctx, ctx context.Context, builder *FilterBuilder, filter bson.M, opts *options.FindOptions) (productPage, error) {
+	var (
+		products  []Product
+		warnings  []Warning
+		count     totalCount
+		updatedAt time.Time
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		switch {
+		case h.cfg.Mongo.ListSource == "flat":
+			products, warnings, err = h.findFlatProducts(c, ctx, filter, opts)
+		case h.cfg.Mongo.ListAggregation:
+			products, warnings, err = h.aggregateProducts(c, ctx, filter, opts)
+		default:
+			products, warnings, err = h.findProducts(c, ctx, builder, filter, opts)
+		}
+		if err == nil && len(warnings) > 0 && strict(c) {
+			return &malformedError{warnings: warnings}
+		}
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		count, err = h.countProducts(c, ctx, filter)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		updatedAt, err = h.latestUpdate(c, ctx, filter)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return productPage{}, err
 	}
+	mappedProducts.Add(int64(len(products)), tenant(c).Name)
 
-	var products []Product
-	for _, result := range results {
-		productList, ok := result["productList"].(bson.A)
-		if !ok {
-			log.Printf("Error casting productList: %v", result["productList"])
-			continue
+	return productPage{
+		TotalCount:      count.N,
+		TotalCountExact: count.Exact,
+		LastModified:    database.LooseTime{Time: updatedAt}.Ptr(),
+		Data:            products,
+		Warnings:        warnings,
+	}, nil
+}
+
+// productPage is the listing envelope.
+type productPage struct {
+	TotalCount      int64     `json:"totalCount"`
+	TotalCountExact bool      `json:"totalCountExact"`
+	Data            []Product `json:"data"`
+	Warnings        []Warning `json:"warnings,omitempty"`
+	// LastModified is the latest update of the listing's products, which
+	// the ETag and Last-Modified headers are derived from.
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	// Suggestions are spelling suggestions for a search that found nothing.
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// totalCount is a listing's total. Exact is false when it came from the
+// collection metadata or the count cache and may trail recent writes.
+type totalCount struct {
+	N     int64
+	Exact bool
+}
+
+// countProducts counts what the page query pages over: products when
+// flattening server-side or reading products_flat, groups on the legacy
+// Find path. Unfiltered listings get a cheap estimate instead.
+func (h *Handler) countProducts(c *fiber.Ctx, ctx context.Context, filter bson.M) (totalCount, error) {
+	if len(filter) == 0 {
+		return h.estimateProducts(c, ctx)
+	}
+	n, err := h.exactCount(c, ctx, filter)
+	return totalCount{N: n, Exact: true}, err
+}
+
+// estimateProducts counts every product of the tenant. Collections holding
+// one document per counted item answer from metadata; the flattened count
+// of group documents is computed exactly and cached.
+func (h *Handler) estimateProducts(c *fiber.Ctx, ctx context.Context) (totalCount, error) {
+	var estimate func(context.Context, ...*options.EstimatedDocumentCountOptions) (int64, error)
+	switch {
+	case h.cfg.Mongo.ListSource == "flat":
+		estimate = tenant(c).FlatList.EstimatedDocumentCount
+	case !h.cfg.Mongo.ListAggregation:
+		estimate = h.repo(c).EstimatedCount
+	}
+	if estimate != nil {
+		var n int64
+		err := database.Breaker.Do(ctx, func() error {
+			var err error
+			n, err = estimate(ctx, options.EstimatedDocumentCount().SetMaxTime(h.maxTime(c)))
+			return err
+		})
+		return totalCount{N: n}, err
+	}
+
+	name := tenant(c).Name
+	if n, ok := h.counts.get(name, h.now()); ok {
+		return totalCount{N: n}, nil
+	}
+	n, err := h.exactCount(c, ctx, bson.M{})
+	if err != nil {
+		return totalCount{}, err
+	}
+	h.counts.set(name, n, h.now().Add(h.cfg.HTTP.CountCacheTTL))
+	return totalCount{N: n, Exact: true}, nil
+}
+
+func (h *Handler) exactCount(c *fiber.Ctx, ctx context.Context, filter bson.M) (int64, error) {
+	var count int64
+	err := database.Breaker.Do(ctx, func() error {
+		if h.cfg.Mongo.ListSource == "flat" {
+			n, err := tenant(c).FlatList.CountDocuments(ctx, database.FlatFilter(filter), options.Count().SetMaxTime(h.maxTime(c)))
+			count = n
+			return err
+		}
+		if !h.cfg.Mongo.ListAggregation {
+			n, err := h.repo(c).Count(ctx, filter, options.Count().SetMaxTime(h.maxTime(c)))
+			count = n
+			return err
+		}
+		// Malformed entries are counted: the page reports them as warnings.
+		pipeline := append(database.FlattenStagesKeepingMalformed(filter), bson.D{{Key: "$count", Value: "n"}})
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		var out []struct {
+			N int64 `bson:"n"`
 		}
+		if err := cursor.All(ctx, &out); err != nil {
+			return err
+		}
+		if len(out) > 0 {
+			count = out[0].N
+		}
+		return nil
+	})
+	return count, err
+}
 
-		for _, item := range productList {
-			productMap, ok := item.(bson.M)
-			if !ok {
-				log.Printf("Error casting productMap: %v", item)
-				continue
+// findProducts fetches a page of groups and flattens it in Go, keeping only
+// the products that match the filter themselves.
+func (h *Handler) findProducts(c *fiber.Ctx, ctx context.Context, builder *FilterBuilder, filter bson.M, opts *options.FindOptions) ([]Product, []Warning, error) {
+	var results []database.GroupDocument
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &results)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	products := []Product{}
+	var warnings []Warning
+	for _, group := range results {
+		mapped, skipped := mapGroupToProducts(group)
+		for _, p := range mapped {
+			if builder.Matches(p) {
+				products = append(products, p)
 			}
-
-			brokers := []Broker{}
-			brokersData, ok := productMap["brokers"].(bson.A)
-			if ok {
-				for _, brokerItem := range brokersData {
-					brokerMap, ok := brokerItem.(bson.M)
-					if ok {
-						brokers = append(brokers, Broker{
-							Key:         getStringField(brokerMap, "key"),
-							ChannelName: getStringField(brokerMap, "channelName"),
-						})
-					}
-				}
+		}
+		skippedItems.Add(int64(len(skipped)), tenant(c).Name)
+		warnings = append(warnings, skipped...)
+	}
+	// The query can only order groups; order the flattened products too.
+	// Pages still hold whole groups, so ordering is global only on the
+	// aggregation and products_flat paths.
+	collator := database.Collator(opts.Collation)
+	sort.SliceStable(products, func(i, j int) bool {
+		a, b := products[i].ProductName, products[j].ProductName
+		if collator != nil {
+			if n := collator.CompareString(a, b); n != 0 {
+				return n < 0
 			}
+		} else if a != b {
+			return a < b
+		}
+		return products[i].ID < products[j].ID
+	})
 
-			product := Product{
-				ID:          getStringField(productMap, "id"),
-				ProductName: getStringField(productMap, "productName"),
-				ProductGroup: ProductGroup{
-					Name: getStringField(result, "name"),
-					Key:  getStringField(result, "key"),
-				},
-				ProductType: ProductType{
-					Name: getStringField(result["productType"].(bson.M), "name"),
-					Key:  getStringField(result["productType"].(bson.M), "key"),
-				},
-				Insurer: Insurer{
-					ID:          getStringField(productMap["insurer"].(bson.M), "_id"),
-					InsurerCode: getStringField(productMap["insurer"].(bson.M), "insurerCode"),
-					InsurerName: getStringField(productMap["insurer"].(bson.M), "insurerName"),
-				},
-				Brokers: brokers,
-				Status:  getStringField(productMap, "productStatus"),
-			}
-			products = append(products, product)
+	return products, warnings, nil
+}
+
+// GetProductByID returns a single embedded product by its id.
+func (h *Handler) GetProductByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+	cacheKey := "product|id=" + id
+	if body, ok := h.cachedPage(c, cacheKey); ok {
+		return sendCached(c, body)
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	body, err := h.loadPage(c, ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		group, item, err := h.findProduct(c, ctx, id)
+		if err != nil {
+			return nil, err
 		}
+		return mapProduct(group, item), nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist")
+	}
+	if err != nil {
+		return queryError(c, "finding product", err)
 	}
+	return sendPage(c, body)
+}
 
-	// Respond with paginated results and total count
-	response := struct {
-		// TotalCount int       `json:"totalCount"`
-		Data []Product `json:"data"`
-	}{
-		// TotalCount: int(count),
-		Data: products,
+// findProduct reads the product with the given id, and its group without
+// the other products. A missing product is mongo.ErrNoDocuments.
+func (h *Handler) findProduct(c *fiber.Ctx, ctx context.Context, id string) (database.GroupDocument, database.ProductDocument, error) {
+	var group database.GroupDocument
+	err := database.Breaker.Do(ctx, func() error {
+		return h.repo(c).FindOne(ctx,
+			database.ItemFilter(id),
+			options.FindOne().
+				SetProjection(bson.M{"key": 1, "name": 1, "productType": 1, "productList.$": 1}).
+				SetMaxTime(h.maxTime(c)),
+		).Decode(&group)
+	})
+	if err != nil {
+		return group, database.ProductDocument{}, err
 	}
+	item, ok := group.Item(id)
+	if !ok {
+		return group, item, mongo.ErrNoDocuments
+	}
+	return group, item, nil
+}
 
-	return c.JSON(response)
+// mapGroupToProducts flattens a group document into its products, in
+// productList order. Entries that are not documents are reported as
+// warnings instead.
+func mapGroupToProducts(group database.GroupDocument) ([]Product, []Warning) {
+	products := make([]Product, 0, len(group.ProductList))
+	var warnings []Warning
+	for i, item := range group.ProductList {
+		if item.Malformed {
+			warnings = append(warnings, Warning{GroupKey: group.Key.String(), Index: i, Reason: reasonNotDocument})
+			continue
+		}
+		products = append(products, mapProduct(group, item))
+	}
+	return products, warnings
+}
+
+// mapProduct flattens one productList item of a group document. A group
+// without a productType, or with one that is not a document, maps to an
+// empty ProductType rather than failing the listing; likewise a product
+// without an insurer gets a zero Insurer (see ?missing=insurer).
+func mapProduct(group database.GroupDocument, item database.ProductDocument) Product {
+	brokers := []Broker{}
+	for _, b := range item.Brokers {
+		if b.Malformed {
+			continue
+		}
+		brokers = append(brokers, Broker{Key: b.Key.String(), ChannelName: b.ChannelName.String()})
+	}
+
+	product := Product{
+		ID:          item.ProductID(),
+		Code:        item.Code.String(),
+		ProductName: item.ProductName.String(),
+		ProductGroup: ProductGroup{
+			Name: group.Name.String(),
+			Key:  group.Key.String(),
+		},
+		Brokers:   brokers,
+		Status:    normalizeStatus(item.Status.String()),
+		CreatedAt: item.CreatedAt.Ptr(),
+		UpdatedAt: item.UpdatedAt.Ptr(),
+		CreatedBy: item.CreatedBy.String(),
+		UpdatedBy: item.UpdatedBy.String(),
+	}
+	if t := group.ProductType; t != nil {
+		product.ProductType = ProductType{Name: t.Name.String(), Key: t.Key.String()}
+	}
+	if in := item.Insurer; in != nil {
+		product.Insurer = Insurer{
+			ID:          in.ID.String(),
+			InsurerCode: in.InsurerCode.String(),
+			InsurerName: in.InsurerName.String(),
+		}
+	}
+	return product
 }
 
-// Helper function to safely get string field from a map
+// getStringField returns a field of a bson.M document as a string, with
+// the same conversions as database.LooseString. Absent optional fields are
+// normal, so misses are only logged at debug level.
 func getStringField(data interface{}, key string) string {
 	if dataMap, ok := data.(bson.M); ok {
-		if value, ok := dataMap[key].(string); ok {
+		if value, ok := database.Stringify(dataMap[key]); ok {
 			return value
 		}
 	}
-	log.Printf("Field %s not found or not a string", key)
+	slog.Debug("field not found or not a scalar", "field", key)
 	return ""
 }
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Benchmarks of the listing's hot path; run them with make bench. Baseline
+// on a 1-CPU linux/amd64 Xeon, 20 groups of 25 products:
+//
+//	BenchmarkFilterBuilder/status        1397 ns/op       832 B/op      10 allocs/op
+//	BenchmarkFilterBuilder/search       72088 ns/op     51498 B/op     507 allocs/op
+//	BenchmarkFilterBuilder/combined     40417 ns/op     23553 B/op     266 allocs/op
+//	BenchmarkMapGroupToProducts        201160 ns/op    150880 B/op     780 allocs/op
+//	BenchmarkGetProducts             27775380 ns/op   6076075 B/op   62561 allocs/op
+//	BenchmarkDecodeGroups/typed       6897460 ns/op   2172802 B/op   30532 allocs/op
+//	BenchmarkDecodeGroups/bson.M      6286311 ns/op   1928156 B/op   45228 allocs/op
+//	BenchmarkListProducts/parallel    3142732 ns/op    165853 B/op    2515 allocs/op
+//	BenchmarkListProducts/sequential  7448996 ns/op    164636 B/op    2500 allocs/op
+//
+// Typed decoding is not faster than bson.M here; it allocates a third less
+// often, and its mapping cannot panic on a field of the wrong type.
+
+// benchPage returns groups product groups of perGroup products each, shaped
+// like healthGroup.
+func benchPage(groups, perGroup int) []interface{} {
+	page := make([]interface{}, groups)
+	for g := range page {
+		group := healthGroup()
+		group["key"] = fmt.Sprintf("GROUP-%03d", g)
+		template := group["productList"].(bson.A)
+		items := make(bson.A, perGroup)
+		for i := range items {
+			item := bson.M{}
+			for k, v := range template[i%len(template)].(bson.M) {
+				item[k] = v
+			}
+			item["id"] = fmt.Sprintf("P-%03d-%03d", g, i)
+			item["productName"] = fmt.Sprintf("%s %d", item["productName"], i)
+			items[i] = item
+		}
+		group["productList"] = items
+		page[g] = group
+	}
+	return page
+}
+
+// decodeGroups round-trips docs through BSON, as a cursor would.
+func decodeGroups(b *testing.B, docs []interface{}) []database.GroupDocument {
+	b.Helper()
+	groups := make([]database.GroupDocument, len(docs))
+	for i, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := bson.Unmarshal(raw, &groups[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return groups
+}
+
+func BenchmarkFilterBuilder(b *testing.B) {
+	for _, bm := range []struct {
+		name   string
+		params ListParams
+	}{
+		{"status", ListParams{Status: "ACTIVE,DRAFT"}},
+		{"search", ListParams{Search: "ประกัน สุขภาพ -รถยนต์"}},
+		{"combined", ListParams{Search: "health", Status: "ACTIVE", Code: "HP-*", Group: "HEALTH-*", MinBrokers: "1", Missing: "insurer"}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				builder, err := productFilterBuilder(bm.params)
+				if err != nil {
+					b.Fatal(err)
+				}
+				builder.Build()
+			}
+		})
+	}
+}
+
+func BenchmarkMapGroupToProducts(b *testing.B) {
+	groups := decodeGroups(b, benchPage(20, 25))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, group := range groups {
+			mapGroupToProducts(group)
+		}
+	}
+}
+
+// BenchmarkGetProducts serves a listing page end to end, from the fake
+// repository's cursor to the encoded response.
+func BenchmarkGetProducts(b *testing.B) {
+	repo := &mocks.ProductRepository{FindDocs: benchPage(20, 25), Total: 500}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(fiber.MethodGet, "/products?limit=20&status=ACTIVE,DRAFT", nil)
+		req.Header.Set("X-API-Key", testAPIKey)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			b.Fatalf("status = %d", resp.StatusCode)
+		}
+	}
+}
+
+// mapGroupMap is the listing's mapping before typed documents: the group
+// decoded as bson.M and walked with type assertions. It is kept here only
+// as the baseline of BenchmarkDecodeGroups.
+func mapGroupMap(group bson.M) []Product {
+	items, _ := group["productList"].(bson.A)
+	products := make([]Product, 0, len(items))
+	productType, _ := group["productType"].(bson.M)
+	for _, raw := range items {
+		item, ok := raw.(bson.M)
+		if !ok {
+			continue
+		}
+		brokers := []Broker{}
+		list, _ := item["brokers"].(bson.A)
+		for _, b := range list {
+			if broker, ok := b.(bson.M); ok {
+				brokers = append(brokers, Broker{Key: getStringField(broker, "key"), ChannelName: getStringField(broker, "channelName")})
+			}
+		}
+		insurer, _ := item["insurer"].(bson.M)
+		products = append(products, Product{
+			ID:           getStringField(item, "id"),
+			ProductName:  getStringField(item, "productName"),
+			ProductGroup: ProductGroup{Name: getStringField(group, "name"), Key: getStringField(group, "key")},
+			ProductType:  ProductType{Name: getStringField(productType, "name"), Key: getStringField(productType, "key")},
+			Insurer: Insurer{
+				ID:          getStringField(insurer, "_id"),
+				InsurerCode: getStringField(insurer, "insurerCode"),
+				InsurerName: getStringField(insurer, "insurerName"),
+			},
+			Brokers: brokers,
+			Status:  normalizeStatus(getStringField(item, "productStatus")),
+		})
+	}
+	return products
+}
+
+// BenchmarkDecodeGroups decodes a page from a cursor and maps it, into
+// typed documents and the way it was done with bson.M.
+func BenchmarkDecodeGroups(b *testing.B) {
+	docs := benchPage(20, 25)
+	b.Run("typed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var groups []database.GroupDocument
+			if err := cursor.All(context.Background(), &groups); err != nil {
+				b.Fatal(err)
+			}
+			for _, group := range groups {
+				mapGroupToProducts(group)
+			}
+		}
+	})
+	b.Run("bson.M", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var groups []bson.M
+			if err := cursor.All(context.Background(), &groups); err != nil {
+				b.Fatal(err)
+			}
+			for _, group := range groups {
+				mapGroupMap(group)
+			}
+		}
+	})
+}
+
+// BenchmarkListProducts runs the listing's page, count and latest-update
+// queries against a database answering each in 2ms, concurrently as
+// listProducts does and one after the other.
+func BenchmarkListProducts(b *testing.B) {
+	repo := &mocks.ProductRepository{FindDocs: benchPage(1, 20), Total: 20, Delay: 2 * time.Millisecond}
+	builder := NewFilterBuilder().Status([]ProductStatus{StatusActive})
+	filter := builder.Build()
+	opts := options.Find().SetSkip(0).SetLimit(20).SetMaxTime(time.Second)
+	app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+		app.Use(h.RequestContext)
+		app.Get("/parallel", func(c *fiber.Ctx) error {
+			_, err := h.listProducts(c, c.UserContext(), builder, filter, opts)
+			return err
+		})
+		app.Get("/sequential", func(c *fiber.Ctx) error {
+			ctx := c.UserContext()
+			if _, _, err := h.findProducts(c, ctx, builder, filter, opts); err != nil {
+				return err
+			}
+			if _, err := h.countProducts(c, ctx, filter); err != nil {
+				return err
+			}
+			_, err := h.latestUpdate(c, ctx, filter)
+			return err
+		})
+	})
+	for _, path := range []string{"/parallel", "/sequential"} {
+		b.Run(path[1:], func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(fiber.MethodGet, path, nil)
+				req.Header.Set("X-API-Key", testAPIKey)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if resp.StatusCode != fiber.StatusOK {
+					b.Fatalf("status = %d", resp.StatusCode)
+				}
+			}
+		})
+	}
+}
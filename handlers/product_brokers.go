@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductBroker is a broker of a product together with what the broker
+// master data says of its channel. The master fields are absent when the
+// master data has no entry for the key.
+type ProductBroker struct {
+	Key         string `json:"key"`
+	ChannelName string `json:"channelName"`
+	// MasterChannelName and Active are the master data's entry.
+	MasterChannelName string `json:"masterChannelName,omitempty"`
+	Active            *bool  `json:"active,omitempty"`
+}
+
+// GetProductBrokers lists the brokers of a product, in the product's
+// order.
+func (h *Handler) GetProductBrokers(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	group, item, err := h.findProduct(c, ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist")
+	}
+	if err != nil {
+		return queryError(c, "finding product", err)
+	}
+	return h.sendProductBrokers(c, ctx, fiber.StatusOK, mapProduct(group, item).Brokers)
+}
+
+// AddProductBroker adds a broker to a product. The broker is held to the
+// master data as on a product update, which also fills in an empty
+// channel name; a key the product already has is a 409.
+func (h *Handler) AddProductBroker(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	var in BrokerInput
+	if ok, err := parseBody(c, &in); !ok {
+		return err
+	}
+	id := c.Params("id")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	check := ProductInput{Brokers: []BrokerInput{in}}
+	if ok, err := h.checkBrokers(c, ctx, &check); !ok {
+		return err
+	}
+	in = check.Brokers[0]
+	if dryRun(c) {
+		return c.JSON(fiber.Map{"valid": true})
+	}
+
+	entry := bson.M{"key": in.Key, "keyLower": strings.ToLower(in.Key), "channelName": in.ChannelName}
+	filter := bson.M{"productList": bson.M{"$elemMatch": bson.M{"$and": bson.A{
+		database.ItemMatch(id, ""),
+		bson.M{"brokers.key": bson.M{"$ne": in.Key}},
+	}}}}
+	after, err := h.updateProductBrokers(c, ctx, id, filter, bson.M{"$push": bson.M{"productList.$[p].brokers": entry}}, actor,
+		func(brokers bson.A) bson.A { return append(brokers, entry) })
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if exists, err := h.productExists(c, ctx, id); !exists {
+			return err
+		}
+		return apierror.Send(c, fiber.StatusConflict, "BROKER_EXISTS", "product "+id+" already has broker "+in.Key)
+	}
+	if err != nil {
+		return queryError(c, "adding broker", err)
+	}
+
+	return h.sendProductBrokers(c, ctx, fiber.StatusCreated, brokersOf(after))
+}
+
+// RemoveProductBroker removes a broker from a product.
+func (h *Handler) RemoveProductBroker(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	id, key := c.Params("id"), c.Params("key")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	filter := bson.M{"productList": bson.M{"$elemMatch": bson.M{"$and": bson.A{
+		database.ItemMatch(id, ""),
+		bson.M{"brokers.key": key},
+	}}}}
+	_, err = h.updateProductBrokers(c, ctx, id, filter, bson.M{"$pull": bson.M{"productList.$[p].brokers": bson.M{"key": key}}}, actor,
+		func(brokers bson.A) bson.A {
+			kept := bson.A{}
+			for _, b := range brokers {
+				if m, ok := b.(bson.M); !ok || m["key"] != key {
+					kept = append(kept, b)
+				}
+			}
+			return kept
+		})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if exists, err := h.productExists(c, ctx, id); !exists {
+			return err
+		}
+		return apierror.Send(c, fiber.StatusNotFound, "BROKER_NOT_FOUND", "product "+id+" has no broker "+key)
+	}
+	if err != nil {
+		return queryError(c, "removing broker", err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// updateProductBrokers applies update, which changes the brokers of the
+// product with the given id as $[p], to the group matching filter, and
+// audits it. change does to the product's brokers what update does; the
+// result is the brokers after it. mongo.ErrNoDocuments means nothing
+// matched filter.
+func (h *Handler) updateProductBrokers(c *fiber.Ctx, ctx context.Context, id string, filter, update bson.M, actor string, change func(bson.A) bson.A) (bson.A, error) {
+	update["$set"] = bson.M{
+		"productList.$[p].updatedAt": database.Timestamp(h.now()),
+		"productList.$[p].updatedBy": actor,
+	}
+	var (
+		group   bson.M
+		brokers bson.A
+	)
+	err := h.audited(c, ctx, audit.ActionUpdate, func(ctx context.Context) (*auditedChange, error) {
+		// The pre-update document supplies the diff's old values.
+		err := h.repo(c).FindOneAndUpdate(ctx, filter, update,
+			options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{database.ItemMatch(id, "p.")}}),
+		).Decode(&group)
+		if err != nil {
+			return nil, err
+		}
+		before, _ := findItem(group, id)["brokers"].(bson.A)
+		brokers = change(append(bson.A{}, before...))
+		return &auditedChange{
+			productID: id,
+			groupKey:  getStringField(group, "key"),
+			before:    bson.M{"brokers": before},
+			after:     bson.M{"brokers": brokers},
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.invalidateCache(c)
+	h.syncFlat(c, group)
+	return brokers, nil
+}
+
+// brokersOf maps the brokers array of a productList entry, skipping
+// entries that are not documents as mapProduct does.
+func brokersOf(list bson.A) []Broker {
+	brokers := []Broker{}
+	for _, b := range list {
+		if m, ok := b.(bson.M); ok {
+			brokers = append(brokers, Broker{Key: getStringField(m, "key"), ChannelName: getStringField(m, "channelName")})
+		}
+	}
+	return brokers
+}
+
+// productExists tells a missing product from a failed condition on it,
+// writing the 404 or error response itself when exists is false.
+func (h *Handler) productExists(c *fiber.Ctx, ctx context.Context, id string) (exists bool, err error) {
+	_, _, err = h.findProduct(c, ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist")
+	}
+	if err != nil {
+		return false, queryError(c, "finding product", err)
+	}
+	return true, nil
+}
+
+// sendProductBrokers answers with brokers enriched from the broker master
+// data. A failure to read the master data fails the request, so a missing
+// entry always means the key is not registered.
+func (h *Handler) sendProductBrokers(c *fiber.Ctx, ctx context.Context, status int, brokers []Broker) error {
+	keys := make(bson.A, len(brokers))
+	for i, b := range brokers {
+		keys[i] = b.Key
+	}
+	master, err := h.loadBrokers(c, ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return queryError(c, "loading brokers", err)
+	}
+	byKey := make(map[string]BrokerChannel, len(master))
+	for _, m := range master {
+		byKey[m.Key] = m
+	}
+	data := make([]ProductBroker, len(brokers))
+	for i, b := range brokers {
+		data[i] = ProductBroker{Key: b.Key, ChannelName: b.ChannelName}
+		if m, ok := byKey[b.Key]; ok {
+			active := m.Active
+			data[i].MasterChannelName, data[i].Active = m.ChannelName, &active
+		}
+	}
+	return c.Status(status).JSON(fiber.Map{"totalCount": len(data), "data": data})
+}
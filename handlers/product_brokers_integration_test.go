@@ -0,0 +1,113 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+// productBrokers lists a product's brokers through the sub-resource.
+func productBrokers(t *testing.T, app *fiber.App, id string) []handlers.ProductBroker {
+	t.Helper()
+	resp, out := call(t, app, fiber.MethodGet, "/products/"+id+"/brokers", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("brokers of %s: %d %s", id, resp.StatusCode, out)
+	}
+	var page struct {
+		TotalCount int                      `json:"totalCount"`
+		Data       []handlers.ProductBroker `json:"data"`
+	}
+	if err := json.Unmarshal(out, &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.TotalCount != len(page.Data) {
+		t.Errorf("brokers of %s: totalCount %d for %d brokers", id, page.TotalCount, len(page.Data))
+	}
+	return page.Data
+}
+
+func TestIntegrationProductBrokers(t *testing.T) {
+	app := integrationApp(t, map[string]string{"MASTER_DATA_ENFORCE_BROKERS": "true"})
+	brokerMaster(t, app)
+	active := true
+
+	// The master's entry is shown next to the product's copy; telesales
+	// has none.
+	want := []handlers.ProductBroker{
+		{Key: "BROKER-ONLINE", ChannelName: "Online", MasterChannelName: "Online", Active: &active},
+		{Key: "BROKER-BRANCH", ChannelName: "สาขา", MasterChannelName: "Branch", Active: &active},
+	}
+	if got := productBrokers(t, app, "HP-001"); !reflect.DeepEqual(got, want) {
+		t.Errorf("HP-001 brokers = %+v, want %+v", got, want)
+	}
+	if got := productBrokers(t, app, "HP-003"); len(got) != 1 || got[0].Key != "BROKER-TELESALES" || got[0].Active != nil || got[0].MasterChannelName != "" {
+		t.Errorf("HP-003 brokers = %+v, want telesales without a master entry", got)
+	}
+	if got := productBrokers(t, app, "MT-002"); len(got) != 0 {
+		t.Errorf("MT-002 brokers = %+v, want none", got)
+	}
+
+	for _, tt := range []struct {
+		body string
+		want int
+		code string
+	}{
+		{`{"key": "BROKER-GONE"}`, http.StatusUnprocessableEntity, "UNKNOWN_BROKER"},
+		{`{"key": "BROKER-AGENT"}`, http.StatusUnprocessableEntity, "INACTIVE_BROKER"},
+	} {
+		if resp, out := call(t, app, fiber.MethodPost, "/products/MT-002/brokers", tt.body); resp.StatusCode != tt.want || !strings.Contains(string(out), `"`+tt.code+`"`) {
+			t.Errorf("add %s: %d %s, want %d %s", tt.body, resp.StatusCode, out, tt.want, tt.code)
+		}
+	}
+
+	// The master fills in the channel name left out.
+	resp, out := call(t, app, fiber.MethodPost, "/products/MT-002/brokers", `{"key": "BROKER-ONLINE"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add: %d %s", resp.StatusCode, out)
+	}
+	added := []handlers.ProductBroker{{Key: "BROKER-ONLINE", ChannelName: "Online", MasterChannelName: "Online", Active: &active}}
+	if got := productBrokers(t, app, "MT-002"); !reflect.DeepEqual(got, added) {
+		t.Errorf("MT-002 brokers after the add = %+v, want %+v", got, added)
+	}
+	if resp, out := call(t, app, fiber.MethodPost, "/products/MT-002/brokers", `{"key": "BROKER-ONLINE"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second add: %d %s, want 409", resp.StatusCode, out)
+	}
+	for _, p := range list(t, app, "/products?limit=100").Data {
+		if p.ID == "MT-002" && (len(p.Brokers) != 1 || p.Brokers[0].Key != "BROKER-ONLINE") {
+			t.Errorf("listed MT-002 brokers = %+v, want the added one", p.Brokers)
+		}
+	}
+
+	if resp, out := call(t, app, fiber.MethodDelete, "/products/MT-002/brokers/BROKER-ONLINE", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("remove: %d %s", resp.StatusCode, out)
+	}
+	if got := productBrokers(t, app, "MT-002"); len(got) != 0 {
+		t.Errorf("MT-002 brokers after the remove = %+v, want none", got)
+	}
+	if resp, out := call(t, app, fiber.MethodDelete, "/products/MT-002/brokers/BROKER-ONLINE", ""); resp.StatusCode != http.StatusNotFound || !strings.Contains(string(out), `"BROKER_NOT_FOUND"`) {
+		t.Errorf("second remove: %d %s, want 404 BROKER_NOT_FOUND", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodGet, "/products/MT-404/brokers", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing product: %d %s, want 404", resp.StatusCode, out)
+	}
+
+	resp, out = call(t, app, fiber.MethodGet, "/audit?productId=MT-002", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("audit: %d %s", resp.StatusCode, out)
+	}
+	var entries struct{ Data []audit.Entry }
+	if err := json.Unmarshal(out, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries.Data) != 2 || entries.Data[0].Action != audit.ActionUpdate || entries.Data[1].Action != audit.ActionUpdate {
+		t.Errorf("audit = %s, want the add and the remove as updates", out)
+	}
+}
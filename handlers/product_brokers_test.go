@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func productBrokerRoutes(app *fiber.App, h *Handler) {
+	app.Get("/products/:id/brokers", h.GetProductBrokers)
+	app.Post("/products/:id/brokers", h.AddProductBroker)
+	app.Delete("/products/:id/brokers/:key", h.RemoveProductBroker)
+}
+
+// TestProductBrokersNotFound runs every broker sub-resource request on a
+// product that does not exist.
+func TestProductBrokersNotFound(t *testing.T) {
+	for _, tt := range []struct{ method, target, body string }{
+		{fiber.MethodGet, "/products/HP-404/brokers", ""},
+		{fiber.MethodPost, "/products/HP-404/brokers", `{"key": "BROKER-ONLINE", "channelName": "Online"}`},
+		{fiber.MethodDelete, "/products/HP-404/brokers/BROKER-ONLINE", ""},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			app := newTestApp(testConfig(), storedProduct{&mocks.ProductRepository{}}, productBrokerRoutes)
+			resp, body := do(t, app, tt.method, tt.target, tt.body)
+			if resp.StatusCode != http.StatusNotFound || errorCode(body) != "PRODUCT_NOT_FOUND" {
+				t.Errorf("%d %s, want 404 PRODUCT_NOT_FOUND", resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+// TestProductBrokerConflicts adds a broker HP-002 already has and removes
+// one it lacks: the guarded updates match nothing, and the product is
+// there.
+func TestProductBrokerConflicts(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+		code                       string
+		guard                      bson.M
+	}{
+		{"add existing", fiber.MethodPost, "/products/HP-002/brokers", `{"key": "BROKER-ONLINE"}`,
+			http.StatusConflict, "BROKER_EXISTS", bson.M{"brokers.key": bson.M{"$ne": "BROKER-ONLINE"}}},
+		{"remove missing", fiber.MethodDelete, "/products/HP-002/brokers/BROKER-AGENT", "",
+			http.StatusNotFound, "BROKER_NOT_FOUND", bson.M{"brokers.key": "BROKER-AGENT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mocks.ProductRepository{}
+			app := newTestApp(testConfig(), storedProduct{repo}, productBrokerRoutes)
+			resp, body := do(t, app, tt.method, tt.target, tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Fatalf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+			updates := callsOf(repo, "FindOneAndUpdate")
+			if len(updates) != 1 {
+				t.Fatalf("%d updates, want 1", len(updates))
+			}
+			want := bson.M{"productList": bson.M{"$elemMatch": bson.M{"$and": bson.A{database.ItemMatch("HP-002", ""), tt.guard}}}}
+			if !reflect.DeepEqual(updates[0].Filter, want) {
+				t.Errorf("update filter = %v, want %v", updates[0].Filter, want)
+			}
+		})
+	}
+}
+
+func TestAddProductBrokerRejected(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       int
+		code       string
+	}{
+		{"malformed key", `{"key": "online broker"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"no key", `{"channelName": "Online"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"unknown field", `{"key": "BROKER-ONLINE", "name": "Online"}`, http.StatusBadRequest, "INVALID_BODY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mocks.ProductRepository{}
+			app := newTestApp(testConfig(), repo, productBrokerRoutes)
+			resp, body := do(t, app, fiber.MethodPost, "/products/HP-002/brokers", tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+			if len(repo.Calls()) != 0 {
+				t.Errorf("calls = %v, want none", repo.Calls())
+			}
+		})
+	}
+
+	// A dry run checks the broker and writes nothing.
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(testConfig(), repo, productBrokerRoutes)
+	resp, body := do(t, app, fiber.MethodPost, "/products/HP-002/brokers?dryRun=true", `{"key": "BROKER-AGENT"}`)
+	if resp.StatusCode != http.StatusOK || string(body) != `{"valid":true}` {
+		t.Errorf("dry run: %d %s, want 200 valid", resp.StatusCode, body)
+	}
+	if updates := callsOf(repo, "FindOneAndUpdate"); len(updates) != 0 {
+		t.Errorf("dry run wrote %v", updates)
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ListParams are the filter parameters shared by the listing endpoints.
+type ListParams struct {
+	// Search is the free-text ?param=.
+	Search string
+	// SearchFields is the comma-separated ?searchFields=, the fields
+	// Search is matched against; all of them when empty.
+	SearchFields string
+	// Status is the comma-separated ?status=, in any case.
+	Status string
+	// Missing is ?missing=; only "insurer" is defined.
+	Missing string
+	// Code is ?code=, a product code or a wildcard pattern of codes.
+	Code string
+	// Group is ?group=, a group key or a wildcard pattern of keys.
+	Group string
+	// MinBrokers and MaxBrokers are ?minBrokers= and ?maxBrokers=, bounds
+	// on the number of brokers.
+	MinBrokers string
+	MaxBrokers string
+	// Filter is ?filter=, an expression of the JSON filter DSL.
+	Filter string
+	// Q is ?q=, an RSQL expression.
+	Q string
+}
+
+// filterError is an invalid ListParams value, answered with a 400.
+type filterError struct {
+	code    string
+	message string
+	details interface{}
+}
+
+func (e *filterError) Error() string { return e.message }
+
+// listParams reads the ListParams of a request. It writes the error
+// response itself when ok is false.
+func (h *Handler) listParams(c *fiber.Ctx) (params ListParams, ok bool, err error) {
+	search, ok, err := h.searchQuery(c)
+	if !ok {
+		return params, false, err
+	}
+	return ListParams{
+		Search:       search,
+		SearchFields: query(c, "searchFields"),
+		Status:       query(c, "status"),
+		Missing:      query(c, "missing"),
+		Code:         query(c, "code"),
+		Group:        query(c, "group"),
+		MinBrokers:   query(c, "minBrokers"),
+		MaxBrokers:   query(c, "maxBrokers"),
+		Filter:       query(c, "filter"),
+		Q:            query(c, "q"),
+	}, true, nil
+}
+
+// buildProductFilter is the group document filter for params. Invalid
+// values are reported as a *filterError.
+func buildProductFilter(params ListParams) (bson.M, error) {
+	b, err := productFilterBuilder(params)
+	if err != nil {
+		return nil, err
+	}
+	return b.Build(), nil
+}
+
+// productFilterBuilder is buildProductFilter for callers that also match
+// single products in Go.
+func productFilterBuilder(params ListParams) (*FilterBuilder, error) {
+	statuses, ok := parseStatuses(params.Status)
+	if !ok {
+		return nil, &filterError{
+			code:    "INVALID_STATUS",
+			message: "status must be one or more of " + statusList(", "),
+			details: fiber.Map{"statuses": ProductStatuses},
+		}
+	}
+	fields, ok := parseSearchFields(params.SearchFields)
+	if !ok {
+		return nil, &filterError{
+			code:    "INVALID_SEARCH_FIELDS",
+			message: "searchFields must be one or more of " + strings.Join(searchFieldNames(), ", "),
+			details: fiber.Map{"searchFields": searchFieldNames()},
+		}
+	}
+	if params.Missing != "" && params.Missing != "insurer" {
+		return nil, &filterError{code: "INVALID_MISSING", message: "missing must be insurer"}
+	}
+	min, ok := brokerBound(params.MinBrokers)
+	if !ok {
+		return nil, &filterError{code: "INVALID_BROKER_COUNT", message: "minBrokers must be a non-negative integer"}
+	}
+	max, ok := brokerBound(params.MaxBrokers)
+	if !ok {
+		return nil, &filterError{code: "INVALID_BROKER_COUNT", message: "maxBrokers must be a non-negative integer"}
+	}
+	if min >= 0 && max >= 0 && min > max {
+		return nil, &filterError{code: "INVALID_BROKER_COUNT", message: "minBrokers must not be greater than maxBrokers"}
+	}
+	for _, p := range [][2]string{{"param", params.Search}, {"code", params.Code}, {"group", params.Group}} {
+		if err := checkWildcards(p[0], p[1]); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		expr    bson.M
+		matcher itemMatcher
+	)
+	if params.Filter != "" {
+		var err error
+		if expr, matcher, err = parseFilterDSL(params.Filter); err != nil {
+			var invalid *dslError
+			errors.As(err, &invalid)
+			return nil, &filterError{code: "INVALID_FILTER", message: err.Error(), details: invalid}
+		}
+	}
+	rsql, rsqlMatcher, err := rsqlFilter(params)
+	if err != nil {
+		return nil, err
+	}
+	return NewFilterBuilder().
+		Search(params.Search, fields).
+		Status(statuses).
+		MissingInsurer(params.Missing == "insurer").
+		Code(params.Code).
+		Group(params.Group).
+		BrokerCount(min, max).
+		Expression(expr, matcher).
+		Expression(rsql, rsqlMatcher), nil
+}
+
+// rsqlFilter parses ?q=. It may not name a field that a simple filter
+// parameter of the same request also sets, nor come with ?filter=.
+func rsqlFilter(params ListParams) (bson.M, itemMatcher, error) {
+	if params.Q == "" {
+		return nil, nil, nil
+	}
+	if params.Filter != "" {
+		return nil, nil, &filterError{code: "CONFLICTING_FILTERS", message: "q and filter cannot be combined"}
+	}
+	filter, matcher, fields, err := parseRSQL(params.Q)
+	if err != nil {
+		var invalid *rsqlError
+		errors.As(err, &invalid)
+		return nil, nil, &filterError{code: "INVALID_QUERY", message: err.Error(), details: invalid}
+	}
+	simple := map[string]string{"status": params.Status, "code": params.Code, "group": params.Group}
+	if params.Missing == "insurer" {
+		simple["insurer"] = params.Missing
+	}
+	for field, value := range simple {
+		if value != "" && fields[field] {
+			return nil, nil, &filterError{
+				code:    "CONFLICTING_FILTERS",
+				message: "q filters on " + field + ", which its own parameter also sets",
+				details: fiber.Map{"field": field},
+			}
+		}
+	}
+	return filter, matcher, nil
+}
+
+// brokerBound parses a broker count bound; an absent one is -1.
+func brokerBound(raw string) (int, bool) {
+	if raw == "" {
+		return -1, true
+	}
+	n, err := strconv.Atoi(raw)
+	return n, err == nil && n >= 0
+}
+
+// filterFailed answers a request whose ListParams did not build a filter.
+func filterFailed(c *fiber.Ctx, err error) error {
+	var invalid *filterError
+	if errors.As(err, &invalid) {
+		return apierror.SendDetails(c, fiber.StatusBadRequest, invalid.code, invalid.message, invalid.details)
+	}
+	return apierror.Internal(c, "building the product filter", err)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuildProductFilter(t *testing.T) {
+	regex := func(pattern string) bson.M { return bson.M{"$regex": pattern} }
+	contains := func(pattern string) bson.M { return bson.M{"$regex": pattern, "$options": "i"} }
+
+	tests := []struct {
+		name   string
+		params ListParams
+		want   bson.M
+	}{
+		{name: "no parameters", want: bson.M{}},
+		{
+			name:   "search over every field",
+			params: ListParams{Search: "Vir"},
+			want: bson.M{"$or": []bson.M{
+				{"productType.keyLower": regex("^vir" + noMark)},
+				{"keyLower": regex("^vir" + noMark)},
+				{"productList.productName": contains("Vir" + noMark)},
+				{"productList.insurer.insurerCodeLower": regex("^vir" + noMark)},
+				{"productList.brokers.keyLower": regex("^vir" + noMark)},
+			}},
+		},
+		{
+			name:   "search with regex metacharacters",
+			params: ListParams{Search: "a.b(c)", SearchFields: "productName"},
+			want:   bson.M{"$or": []bson.M{{"productList.productName": contains(`a\.b\(c\)` + noMark)}}},
+		},
+		{
+			name:   "search in both normalization forms",
+			params: ListParams{Search: "café", SearchFields: "productName"},
+			want:   bson.M{"$or": []bson.M{{"productList.productName": contains("(?:caf\u00e9|cafe\u0301)" + noMark)}}},
+		},
+		{
+			name:   "Thai search",
+			params: ListParams{Search: "ประกัน", SearchFields: "productName, insurerCode"},
+			want: bson.M{"$or": []bson.M{
+				{"productList.productName": contains("ประกัน" + noMark)},
+				{"productList.insurer.insurerCodeLower": regex("^ประกัน" + noMark)},
+			}},
+		},
+		{
+			name:   "wildcard search",
+			params: ListParams{Search: "MT-*", SearchFields: "productGroup,productName"},
+			want: bson.M{"$or": []bson.M{
+				{"keyLower": regex("^mt-.*$")},
+				{"productList.productName": contains("^MT-.*$")},
+			}},
+		},
+		{
+			name:   "exclusion",
+			params: ListParams{Search: "-vir", SearchFields: "productGroup,insurerCode"},
+			want: bson.M{"$and": bson.A{
+				bson.M{"keyLower": bson.M{"$not": regex("^vir" + noMark)}},
+				bson.M{"$expr": database.SearchExclusionExpr([]database.SearchPattern{
+					{Path: "insurer.insurerCodeLower", Regex: "^vir" + noMark},
+				})},
+			}},
+		},
+		{
+			name:   "statuses in any case",
+			params: ListParams{Status: "active, Draft"},
+			want:   bson.M{"productList.productStatus": bson.M{"$in": []ProductStatus{StatusActive, StatusDraft}}},
+		},
+		{
+			name:   "missing insurer",
+			params: ListParams{Missing: "insurer"},
+			want:   bson.M{"productList.insurer.insurerCode": bson.M{"$in": bson.A{nil, ""}}},
+		},
+		{
+			name:   "code in lower case",
+			params: ListParams{Code: "mt-001"},
+			want:   bson.M{"productList.productCode": "MT-001"},
+		},
+		{
+			name:   "code pattern",
+			params: ListParams{Code: "mt-??1"},
+			want:   bson.M{"productList.productCode": regex("^MT-..1$")},
+		},
+		{
+			name:   "group",
+			params: ListParams{Group: "MOTOR-1"},
+			want:   bson.M{"key": "MOTOR-1"},
+		},
+		{
+			name:   "group pattern",
+			params: ListParams{Group: "MOTOR.*"},
+			want:   bson.M{"key": regex(`^MOTOR\..*$`)},
+		},
+		{
+			name:   "broker count range",
+			params: ListParams{MinBrokers: "1", MaxBrokers: "3"},
+			want:   bson.M{"$expr": database.BrokerCountExpr(1, 3)},
+		},
+		{
+			name:   "broker count minimum",
+			params: ListParams{MinBrokers: "0"},
+			want:   bson.M{"$expr": database.BrokerCountExpr(0, -1)},
+		},
+		{
+			name:   "filter expression",
+			params: ListParams{Filter: `{"eq":{"status":"active"}}`},
+			want:   bson.M{"$and": bson.A{bson.M{"$and": bson.A{bson.M{"productList.productStatus": "ACTIVE"}}}}},
+		},
+		{
+			name:   "rsql expression",
+			params: ListParams{Q: "insurer.insurerCode=in=(AIA,BKI),group==G"},
+			want: bson.M{"$and": bson.A{bson.M{"$or": bson.A{
+				bson.M{"productList.insurer.insurerCode": bson.M{"$in": []string{"AIA", "BKI"}}},
+				bson.M{"key": "G"},
+			}}}},
+		},
+		{
+			name:   "everything at once",
+			params: ListParams{Search: "vir", SearchFields: "insurerCode", Status: "ACTIVE", Group: "G", Q: "type==MOTOR"},
+			want: bson.M{
+				"$or":                       []bson.M{{"productList.insurer.insurerCodeLower": regex("^vir" + noMark)}},
+				"productList.productStatus": bson.M{"$in": []ProductStatus{StatusActive}},
+				"key":                       "G",
+				"$and":                      bson.A{bson.M{"productType.key": "MOTOR"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildProductFilter(tt.params)
+			if err != nil {
+				t.Fatalf("buildProductFilter(%+v): %v", tt.params, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildProductFilter(%+v)\n got %#v\nwant %#v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildProductFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		params ListParams
+		code   string
+	}{
+		{"unknown status", ListParams{Status: "ACTIVE,PAUSED"}, "INVALID_STATUS"},
+		{"empty status in a list", ListParams{Status: "ACTIVE,"}, "INVALID_STATUS"},
+		{"unknown search field", ListParams{Search: "x", SearchFields: "color"}, "INVALID_SEARCH_FIELDS"},
+		{"unknown missing field", ListParams{Missing: "broker"}, "INVALID_MISSING"},
+		{"negative broker count", ListParams{MinBrokers: "-1"}, "INVALID_BROKER_COUNT"},
+		{"broker count not a number", ListParams{MaxBrokers: "two"}, "INVALID_BROKER_COUNT"},
+		{"broker bounds reversed", ListParams{MinBrokers: "3", MaxBrokers: "1"}, "INVALID_BROKER_COUNT"},
+		{"too many search wildcards", ListParams{Search: strings.Repeat("a*", maxWildcards+1)}, "TOO_MANY_WILDCARDS"},
+		{"too many code wildcards", ListParams{Code: strings.Repeat("?", maxWildcards+1)}, "TOO_MANY_WILDCARDS"},
+		{"too many group wildcards", ListParams{Group: strings.Repeat("*x", maxWildcards+1)}, "TOO_MANY_WILDCARDS"},
+		{"invalid filter", ListParams{Filter: `{"eq":{"color":"red"}}`}, "INVALID_FILTER"},
+		{"invalid rsql", ListParams{Q: "status=="}, "INVALID_QUERY"},
+		{"rsql with filter", ListParams{Q: "status==ACTIVE", Filter: `{"eq":{"type":"A"}}`}, "CONFLICTING_FILTERS"},
+		{"rsql repeating a parameter", ListParams{Q: "productGroup.key==G", Group: "H"}, "CONFLICTING_FILTERS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := buildProductFilter(tt.params)
+			var invalid *filterError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("buildProductFilter(%+v) = %v, %v, want a *filterError", tt.params, filter, err)
+			}
+			if invalid.code != tt.code || filter != nil {
+				t.Errorf("buildProductFilter(%+v) = %v, %s, want %s", tt.params, filter, invalid.code, tt.code)
+			}
+		})
+	}
+}
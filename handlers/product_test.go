@@ -0,0 +1,949 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/fixtures"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func healthGroup() bson.M {
+	return bson.M{
+		"key":         "HEALTH-PLUS",
+		"name":        "Health Plus",
+		"productType": bson.M{"key": "HEALTH", "name": "ประกันสุขภาพ"},
+		"productList": bson.A{
+			bson.M{
+				"id":            "HP-002",
+				"productName":   "Health Plus Family",
+				"insurer":       bson.M{"_id": "INS-AXA", "insurerCode": "AXA", "insurerName": "AXA Insurance"},
+				"brokers":       bson.A{bson.M{"key": "BROKER-ONLINE", "channelName": "Online"}},
+				"productStatus": "DRAFT",
+				"updatedAt":     time.Date(2024, 4, 2, 8, 0, 0, 0, time.UTC),
+			},
+			bson.M{
+				"id":            "HP-001",
+				"productName":   "ประกันสุขภาพ เหมาจ่าย",
+				"insurer":       bson.M{"_id": "INS-TIP", "insurerCode": "TIP", "insurerName": "ทิพยประกันภัย"},
+				"productStatus": "ACTIVE",
+				"updatedAt":     time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+}
+
+func listingRoutes(app *fiber.App, h *Handler) {
+	app.Get("/products", h.GetProducts)
+	app.Head("/products", h.HeadProducts)
+}
+
+// listingFind is the options of the page query GetProducts sent, as
+// opposed to its latest-update lookup.
+func listingFind(t *testing.T, repo *mocks.ProductRepository) *options.FindOptions {
+	t.Helper()
+	for _, call := range callsOf(repo, "Find") {
+		if opts := call.Options.(*options.FindOptions); opts.Limit == nil || *opts.Limit != 1 {
+			return opts
+		}
+	}
+	t.Fatalf("no listing Find among %+v", repo.Calls())
+	return nil
+}
+
+func TestGetProducts(t *testing.T) {
+	malformed := healthGroup()
+	malformed["productList"] = append(malformed["productList"].(bson.A), "not a product")
+
+	tests := []struct {
+		name   string
+		repo   *mocks.ProductRepository
+		target string
+		header []string
+		status int
+		code   string
+		ids    []string
+		total  int64
+		// warnings is the number of warnings the page reports.
+		warnings int
+	}{
+		{
+			name:   "happy path",
+			repo:   &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}, Total: 2},
+			target: "/products",
+			status: http.StatusOK,
+			ids:    []string{"HP-002", "HP-001"},
+			total:  2,
+		},
+		{
+			name:   "empty result",
+			repo:   &mocks.ProductRepository{},
+			target: "/products",
+			status: http.StatusOK,
+			ids:    []string{},
+		},
+		{
+			name:     "malformed documents are skipped with a warning",
+			repo:     &mocks.ProductRepository{FindDocs: []interface{}{malformed}, Total: 3},
+			target:   "/products",
+			status:   http.StatusOK,
+			ids:      []string{"HP-002", "HP-001"},
+			total:    3,
+			warnings: 1,
+		},
+		{
+			name:   "malformed documents fail a strict listing",
+			repo:   &mocks.ProductRepository{FindDocs: []interface{}{malformed}, Total: 3},
+			target: "/products?strict=true",
+			status: http.StatusInternalServerError,
+			code:   "MALFORMED_DATA",
+		},
+		{
+			name:   "mongo error",
+			repo:   &mocks.ProductRepository{Err: errors.New("connection reset by peer")},
+			target: "/products",
+			status: http.StatusInternalServerError,
+			code:   "INTERNAL_ERROR",
+		},
+		{
+			name:   "server-side time limit",
+			repo:   &mocks.ProductRepository{Err: mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}},
+			target: "/products",
+			status: http.StatusGatewayTimeout,
+			code:   "QUERY_TIMEOUT",
+		},
+		{
+			name:   "request deadline passed",
+			repo:   &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}},
+			target: "/products",
+			header: []string{"X-Request-Deadline", "2000-01-01T00:00:00Z"},
+			status: http.StatusGatewayTimeout,
+			code:   "DEADLINE_EXCEEDED",
+		},
+		{
+			name:   "invalid pagination",
+			repo:   &mocks.ProductRepository{},
+			target: "/products?limit=0",
+			status: http.StatusBadRequest,
+			code:   "INVALID_PAGINATION",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, listingRoutes)
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "", tt.header...)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if tt.code != "" {
+				if got := errorCode(body); got != tt.code {
+					t.Fatalf("code = %q, want %q: %s", got, tt.code, body)
+				}
+				return
+			}
+			var page productPage
+			decode(t, body, &page)
+			if page.Data == nil {
+				t.Fatalf("data is null, want an array: %s", body)
+			}
+			ids := []string{}
+			for _, p := range page.Data {
+				ids = append(ids, p.ID)
+			}
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("ids = %v, want %v", ids, tt.ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Fatalf("ids = %v, want %v", ids, tt.ids)
+				}
+			}
+			if page.TotalCount != tt.total {
+				t.Errorf("totalCount = %d, want %d", page.TotalCount, tt.total)
+			}
+			if len(page.Warnings) != tt.warnings {
+				t.Errorf("warnings = %+v, want %d", page.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestGetProductsQuery(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}, Total: 2}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?status=active&page=3&limit=5", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+
+	opts := listingFind(t, repo)
+	if *opts.Skip != 10 || *opts.Limit != 5 {
+		t.Errorf("skip, limit = %d, %d, want 10, 5", *opts.Skip, *opts.Limit)
+	}
+	if opts.MaxTime == nil || *opts.MaxTime <= 0 {
+		t.Errorf("maxTime = %v, want a limit", opts.MaxTime)
+	}
+	want := bson.D{{Key: "productList.productName", Value: 1}, {Key: "productList.id", Value: 1}}
+	if sort, ok := opts.Sort.(bson.D); !ok || len(sort) != len(want) || sort[0] != want[0] || sort[1] != want[1] {
+		t.Errorf("sort = %v, want %v", opts.Sort, want)
+	}
+
+	// A filtered listing is counted exactly, with the page's filter.
+	counts := callsOf(repo, "Count")
+	if len(counts) != 1 {
+		t.Fatalf("Count calls = %+v, want one", counts)
+	}
+	find := callsOf(repo, "Find")[0]
+	if got := counts[0].Filter; len(got.(bson.M)) == 0 || !reflect.DeepEqual(got, find.Filter) {
+		t.Errorf("count filter = %v, want the listing filter %v", got, find.Filter)
+	}
+	if len(callsOf(repo, "EstimatedCount")) != 0 {
+		t.Error("a filtered listing was estimated")
+	}
+}
+
+func TestGetProductsUnfilteredEstimate(t *testing.T) {
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}, Total: 40}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	_, body := do(t, app, fiber.MethodGet, "/products", "")
+
+	var page productPage
+	decode(t, body, &page)
+	if page.TotalCount != 40 || page.TotalCountExact {
+		t.Errorf("totalCount = %d exact %t, want an estimate of 40", page.TotalCount, page.TotalCountExact)
+	}
+	if len(callsOf(repo, "EstimatedCount")) != 1 || len(callsOf(repo, "Count")) != 0 {
+		t.Errorf("calls = %+v, want one EstimatedCount and no Count", repo.Calls())
+	}
+}
+
+// fixtureGroups returns the fixture group documents, which any test may
+// change: they are decoded afresh on every call.
+func fixtureGroups(t *testing.T) []interface{} {
+	t.Helper()
+	groups, err := fixtures.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groups
+}
+
+// TestGetProductsWithoutProductType is a regression test for groups from
+// an old import that have no productType, which used to panic the listing.
+func TestGetProductsWithoutProductType(t *testing.T) {
+	groups := fixtureGroups(t)
+	delete(groups[0].(bson.M), "productType")
+	groups[1].(bson.M)["productType"] = "MOTOR"
+	repo := &mocks.ProductRepository{FindDocs: groups, Total: int64(len(groups))}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?limit=50", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+
+	var page productPage
+	decode(t, body, &page)
+	if len(page.Data) != 7 {
+		t.Fatalf("listed %d products, want all 7: %s", len(page.Data), body)
+	}
+	for _, p := range page.Data {
+		switch p.ProductGroup.Key {
+		case "HEALTH-PLUS", "MOTOR-1":
+			if p.ProductType != (ProductType{}) {
+				t.Errorf("%s: productType = %+v, want it empty", p.ID, p.ProductType)
+			}
+		default:
+			if p.ProductType.Key == "" {
+				t.Errorf("%s: lost the productType of an intact group", p.ID)
+			}
+		}
+	}
+}
+
+// listedIDs returns the ids of a listing page's products, in order.
+func listedIDs(t *testing.T, body []byte) []string {
+	t.Helper()
+	var page productPage
+	decode(t, body, &page)
+	ids := []string{}
+	for _, p := range page.Data {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// TestGetProductsWithoutInsurer lists the fixtures, whose HP-003 never had
+// an insurer, with MT-002's insurer broken too: the healthy products are
+// all still listed and the broken ones are what ?missing=insurer finds.
+func TestGetProductsWithoutInsurer(t *testing.T) {
+	groups := fixtureGroups(t)
+	motor := groups[1].(bson.M)["productList"].(bson.A)
+	motor[1].(bson.M)["insurer"] = "VIRIYAH"
+	repo := &mocks.ProductRepository{FindDocs: groups, Total: int64(len(groups))}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+
+	resp, body := do(t, app, fiber.MethodGet, "/products?limit=50", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var page productPage
+	decode(t, body, &page)
+	insurers := map[string]string{}
+	for _, p := range page.Data {
+		insurers[p.ID] = p.Insurer.InsurerCode
+	}
+	for _, id := range []string{"HP-001", "HP-002", "MT-001", "TW-001", "TW-002"} {
+		if insurers[id] == "" {
+			t.Errorf("healthy product %s missing or without its insurer: %s", id, body)
+		}
+	}
+	for _, id := range []string{"HP-003", "MT-002"} {
+		if code, ok := insurers[id]; !ok || code != "" {
+			t.Errorf("broken product %s: listed %t with insurer %q, want it listed with none", id, ok, code)
+		}
+	}
+
+	resp, body = do(t, app, fiber.MethodGet, "/products?limit=50&missing=insurer", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("missing=insurer: status = %d: %s", resp.StatusCode, body)
+	}
+	if ids := listedIDs(t, body); !reflect.DeepEqual(ids, []string{"HP-003", "MT-002"}) {
+		t.Errorf("missing=insurer listed %v, want HP-003 and MT-002", ids)
+	}
+}
+
+// TestGetProductsGlobalOrder checks that the products of multi-product
+// groups come back ordered by name across groups, ties broken by id,
+// whatever order the groups and their lists are stored in.
+func TestGetProductsGlobalOrder(t *testing.T) {
+	want := []string{"TW-001", "HP-002", "TW-002", "HP-003", "MT-001", "MT-002", "HP-001"}
+	for _, reversed := range []bool{false, true} {
+		groups := fixtureGroups(t)
+		travel := groups[2].(bson.M)["productList"].(bson.A)
+		travel[1].(bson.M)["productName"] = "Health Plus Family"
+		if reversed {
+			for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+				groups[i], groups[j] = groups[j], groups[i]
+			}
+			for _, g := range groups {
+				list := g.(bson.M)["productList"].(bson.A)
+				for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+					list[i], list[j] = list[j], list[i]
+				}
+			}
+		}
+		repo := &mocks.ProductRepository{FindDocs: groups, Total: int64(len(groups))}
+		app := newTestApp(testConfig(), repo, listingRoutes)
+		_, body := do(t, app, fiber.MethodGet, "/products?limit=50", "")
+		if ids := listedIDs(t, body); !reflect.DeepEqual(ids, want) {
+			t.Errorf("reversed %t: order = %v, want %v", reversed, ids, want)
+		}
+	}
+
+	// The aggregation sorts after unwinding, before paging.
+	cfg := testConfig()
+	cfg.Mongo.ListAggregation = true
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(cfg, repo, listingRoutes)
+	do(t, app, fiber.MethodGet, "/products?page=2&limit=5", "")
+	var stages []string
+	for _, stage := range listingPipeline(t, repo) {
+		stages = append(stages, stage[0].Key)
+		if stage[0].Key == "$sort" {
+			sort := bson.D{{Key: "productList.productName", Value: 1}, {Key: "productList.id", Value: 1}}
+			if !reflect.DeepEqual(stage[0].Value, sort) {
+				t.Errorf("$sort = %v, want %v", stage[0].Value, sort)
+			}
+		}
+	}
+	if got := strings.Join(stages, " "); !strings.Contains(got, "$unwind $sort $skip $limit") {
+		t.Errorf("stages = %s, want $sort between $unwind and the paging", got)
+	}
+}
+
+// listingPipeline is the pipeline of the page aggregation an aggregation
+// listing ran, as opposed to its count.
+func listingPipeline(t *testing.T, repo *mocks.ProductRepository) mongo.Pipeline {
+	t.Helper()
+	for _, call := range callsOf(repo, "Aggregate") {
+		pipeline := call.Filter.(mongo.Pipeline)
+		if pipeline[len(pipeline)-1][0].Key != "$count" {
+			return pipeline
+		}
+	}
+	t.Fatalf("no page aggregation in %+v", repo.Calls())
+	return nil
+}
+
+// TestGetProductsItemFilter checks that status and keyword filters keep
+// only the matching products of the fixtures' mixed-status groups, not
+// every product of a group with one match.
+func TestGetProductsItemFilter(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/products?status=ACTIVE", []string{"TW-001", "MT-001", "HP-001"}},
+		{"/products?status=INACTIVE,DRAFT", []string{"HP-002", "HP-003", "TW-002"}},
+		{"/products?param=senior", []string{"HP-003"}},
+		{"/products?param=ซ่อม", []string{"MT-001", "MT-002"}},
+		{"/products?param=ซ่อม&status=RETIRED", []string{"MT-002"}},
+		{"/products?param=health%20-family", []string{"HP-003", "HP-001"}},
+		{"/products?param=-ซ่อม", []string{"TW-001", "HP-002", "HP-003", "TW-002", "HP-001"}},
+		{"/products?param=-%22health%20plus%22", []string{"TW-001", "TW-002", "MT-001", "MT-002", "HP-001"}},
+		{"/products?param=-", []string{"TW-001", "HP-002", "HP-003", "TW-002", "MT-001", "MT-002", "HP-001"}},
+		{"/products?param=axa&searchFields=insurerCode", []string{"TW-001", "HP-002"}},
+		{"/products?param=axa&searchFields=productName,brokers", []string{}},
+		{"/products?param=health&searchFields=productGroup", []string{"HP-002", "HP-003", "HP-001"}},
+		{"/products?param=health&searchFields=productName", []string{"HP-002", "HP-003"}},
+		{"/products?param=broker-ag&searchFields=brokers", []string{"TW-001", "TW-002"}},
+		{"/products?param=health%20-family&searchFields=productGroup", []string{"HP-002", "HP-003", "HP-001"}},
+		{"/products?minBrokers=2", []string{"TW-001", "HP-001"}},
+		{"/products?maxBrokers=0", []string{"MT-002"}},
+		{"/products?minBrokers=1&maxBrokers=1&status=ACTIVE", []string{"MT-001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			groups := fixtureGroups(t)
+			repo := &mocks.ProductRepository{FindDocs: groups, Total: int64(len(groups))}
+			app := newTestApp(testConfig(), repo, listingRoutes)
+			resp, body := do(t, app, fiber.MethodGet, tt.target+"&limit=50", "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, body)
+			}
+			if ids := listedIDs(t, body); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("listed %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	// The aggregation matches the filter again on the unwound items.
+	cfg := testConfig()
+	cfg.Mongo.ListAggregation = true
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(cfg, repo, listingRoutes)
+	do(t, app, fiber.MethodGet, "/products?status=ACTIVE&param=senior", "")
+	pipeline := listingPipeline(t, repo)
+	if pipeline[0][0].Key != "$match" {
+		t.Fatalf("pipeline starts with %s, want the group $match", pipeline[0][0].Key)
+	}
+	var matches []interface{}
+	unwound := false
+	for _, stage := range pipeline {
+		switch stage[0].Key {
+		case "$unwind":
+			unwound = true
+		case "$match":
+			if unwound {
+				matches = append(matches, stage[0].Value)
+			}
+		}
+	}
+	if len(matches) != 1 || !reflect.DeepEqual(matches[0], pipeline[0][0].Value) {
+		t.Errorf("item $match stages = %v, want one with the group filter", matches)
+	}
+}
+
+// TestGetProductsInvalidSearchFields checks that an unknown search field
+// is refused with the valid ones, before any query.
+func TestGetProductsInvalidSearchFields(t *testing.T) {
+	repo := &mocks.ProductRepository{}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products?param=x&searchFields=productName,color", "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "INVALID_SEARCH_FIELDS" {
+		t.Fatalf("%d %s, want 400 INVALID_SEARCH_FIELDS", resp.StatusCode, body)
+	}
+	var env struct {
+		Error struct {
+			Details struct {
+				SearchFields []string `json:"searchFields"`
+			}
+		}
+	}
+	decode(t, body, &env)
+	if want := []string{"productType", "productGroup", "productName", "insurerCode", "brokers"}; !reflect.DeepEqual(env.Error.Details.SearchFields, want) {
+		t.Errorf("details = %v, want %v", env.Error.Details.SearchFields, want)
+	}
+	if len(repo.Calls()) != 0 {
+		t.Errorf("calls = %v, want none", repo.Calls())
+	}
+}
+
+// TestProductIdentifierStyles lists, reads and looks up the products of a
+// group where one has a string id and the other only an ObjectID _id.
+func TestProductIdentifierStyles(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	group := func() bson.M {
+		return bson.M{"key": "MOTOR-1", "name": "Motor", "productList": bson.A{
+			bson.M{"id": "MT-001", "productName": "ชั้น 1", "productCode": "MOT-1", "productStatus": "ACTIVE"},
+			bson.M{"_id": oid, "productName": "ชั้น 2", "productCode": "MOT-2", "productStatus": "ACTIVE"},
+		}}
+	}
+	codes := map[string]string{"MT-001": "MOT-1", oid.Hex(): "MOT-2"}
+
+	repo := &mocks.ProductRepository{FindDocs: []interface{}{group()}, Total: 1}
+	app := newTestApp(testConfig(), repo, listingRoutes)
+	_, body := do(t, app, fiber.MethodGet, "/products", "")
+	if ids := listedIDs(t, body); !reflect.DeepEqual(ids, []string{"MT-001", oid.Hex()}) {
+		t.Errorf("listed %v, want MT-001 and the hex _id", ids)
+	}
+
+	for id, code := range codes {
+		repo := &mocks.ProductRepository{FindOneDoc: group()}
+		app := newTestApp(testConfig(), repo, func(app *fiber.App, h *Handler) {
+			app.Get("/products/:id", h.GetProductByID)
+		})
+
+		resp, body := do(t, app, fiber.MethodGet, "/products/"+id, "")
+		var p Product
+		decode(t, body, &p)
+		if resp.StatusCode != http.StatusOK || p.ID != id || p.Code != code {
+			t.Errorf("GET %s: %d %s, want the product coded %s", id, resp.StatusCode, body, code)
+		}
+		if filter := callsOf(repo, "FindOne")[0].Filter; !reflect.DeepEqual(filter, database.ItemFilter(id)) {
+			t.Errorf("GET %s looked up %v", id, filter)
+		}
+
+		// Updates read the product's old values from the group with findItem.
+		if item := findItem(group(), id); item["productCode"] != code {
+			t.Errorf("findItem(%s) = %v, want the product coded %s", id, item, code)
+		}
+	}
+	if item := findItem(group(), "MT-002"); item != nil {
+		t.Errorf("findItem(MT-002) = %v, want nil", item)
+	}
+}
+
+// TestGetProductsEmptyArrays is a regression test for "data": null on a
+// listing matching nothing, and "brokers": null on products without any.
+func TestGetProductsEmptyArrays(t *testing.T) {
+	aggregation := testConfig()
+	aggregation.Mongo.ListAggregation = true
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		repo   *mocks.ProductRepository
+		target string
+		want   string
+	}{
+		{"no group matches", testConfig(), &mocks.ProductRepository{}, "/products?status=RETIRED", `"data":[]`},
+		// The group matches on a product the page then leaves out.
+		{"no product matches", testConfig(), &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}, Total: 1}, "/products?status=RETIRED", `"data":[]`},
+		{"no row matches", aggregation, &mocks.ProductRepository{}, "/products?status=RETIRED", `"data":[]`},
+		{"product without brokers", testConfig(), &mocks.ProductRepository{FindDocs: []interface{}{healthGroup()}, Total: 1}, "/products?status=ACTIVE", `"brokers":[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(tt.cfg, tt.repo, listingRoutes)
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, body)
+			}
+			if !strings.Contains(string(body), tt.want) || strings.Contains(string(body), "null") {
+				t.Errorf("body = %s, want %s and no nulls", body, tt.want)
+			}
+		})
+	}
+}
+
+// mixedGroup holds products named in Thai and English, in neither binary
+// nor collated order.
+func mixedGroup() bson.M {
+	var list bson.A
+	for i, name := range []string{"zebra Care", "ไก่ชน", "Apple Shield", "เกมส์ประกัน", "apple Care", "Banana Plan", "กรุงเทพ"} {
+		list = append(list, bson.M{"id": fmt.Sprintf("MX-%d", i), "productName": name, "productStatus": "ACTIVE"})
+	}
+	return bson.M{"key": "MIXED", "name": "Mixed", "productList": list}
+}
+
+func TestGetProductsCollation(t *testing.T) {
+	collated := []string{"apple Care", "Apple Shield", "Banana Plan", "zebra Care", "กรุงเทพ", "เกมส์ประกัน", "ไก่ชน"}
+	tests := []struct {
+		name      string
+		config    string
+		target    string
+		collation *options.Collation
+		want      []string
+	}{
+		{"configured default", "th", "/products", database.Collations["th"], collated},
+		{"th", "simple", "/products?collation=th", database.Collations["th"], collated},
+		{"en", "th", "/products?collation=en", database.Collations["en"], collated},
+		{"simple", "th", "/products?collation=simple", nil, []string{"Apple Shield", "Banana Plan", "apple Care", "zebra Care", "กรุงเทพ", "เกมส์ประกัน", "ไก่ชน"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Mongo.Collation = tt.config
+			repo := &mocks.ProductRepository{FindDocs: []interface{}{mixedGroup()}, Total: 1}
+			app := newTestApp(cfg, repo, listingRoutes)
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, body)
+			}
+			if got := listingFind(t, repo).Collation; !reflect.DeepEqual(got, tt.collation) {
+				t.Errorf("Find collation = %+v, want %+v", got, tt.collation)
+			}
+			var page productPage
+			decode(t, body, &page)
+			var names []string
+			for _, p := range page.Data {
+				names = append(names, p.ProductName)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("order = %q, want %q", names, tt.want)
+			}
+		})
+	}
+
+	t.Run("aggregation", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Mongo.ListAggregation = true
+		repo := &mocks.ProductRepository{}
+		app := newTestApp(cfg, repo, listingRoutes)
+		do(t, app, fiber.MethodGet, "/products?collation=th&status=ACTIVE", "")
+		// The count aggregation runs concurrently; the page is the one
+		// that skips.
+		var page *options.AggregateOptions
+		for _, call := range callsOf(repo, "Aggregate") {
+			if strings.Contains(fmt.Sprint(call.Filter), "$skip") {
+				page = call.Options.(*options.AggregateOptions)
+			}
+		}
+		if page == nil {
+			t.Fatal("the listing ran no page aggregation")
+		}
+		if got := page.Collation; !reflect.DeepEqual(got, database.Collations["th"]) {
+			t.Errorf("Aggregate collation = %+v, want th", got)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		app := newTestApp(testConfig(), &mocks.ProductRepository{}, listingRoutes)
+		resp, body := do(t, app, fiber.MethodGet, "/products?collation=fr", "")
+		if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "INVALID_COLLATION" {
+			t.Errorf("collation=fr: %d %s, want 400 INVALID_COLLATION", resp.StatusCode, body)
+		}
+	})
+}
+
+func FuzzSanitizeString(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		re, err := regexp.Compile("^" + SanitizeString(input) + "$")
+		if err != nil {
+			t.Fatalf("SanitizeString(%q) = %q does not compile: %v", input, SanitizeString(input), err)
+		}
+		if literal := strings.ToValidUTF8(input, ""); !re.MatchString(literal) {
+			t.Errorf("SanitizeString(%q) = %q does not match %q literally", input, re, literal)
+		}
+	})
+}
+
+func TestGetStringField(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	doc := bson.M{
+		"name": "Motor", "oid": oid, "int32": int32(3), "int64": int64(4),
+		"double": 2.5, "bool": true, "null": nil, "nested": bson.M{"key": "x"},
+	}
+	tests := []struct {
+		data interface{}
+		key  string
+		want string
+	}{
+		{doc, "name", "Motor"},
+		{doc, "oid", "65a0000000000000000000aa"},
+		{doc, "int32", "3"},
+		{doc, "int64", "4"},
+		{doc, "double", "2.5"},
+		{doc, "bool", "true"},
+		{doc, "null", ""},
+		{doc, "nested", ""},
+		{doc, "absent", ""},
+		{nil, "name", ""},
+		{"Motor", "name", ""},
+		{bson.D{{Key: "name", Value: "Motor"}}, "name", ""},
+	}
+	for _, tt := range tests {
+		if got := getStringField(tt.data, tt.key); got != tt.want {
+			t.Errorf("getStringField(%v, %q) = %q, want %q", tt.data, tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestGetStringFieldLogsAtDebug checks that absent optional fields, which
+// every product has, do not reach the log at the default level.
+func TestGetStringFieldLogsAtDebug(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		var buf bytes.Buffer
+		slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level})))
+		getStringField(bson.M{}, "productCode")
+		if logged := buf.Len() > 0; logged != (level == slog.LevelDebug) {
+			t.Errorf("at level %s a missing field logged %q", level, buf.String())
+		}
+	}
+}
+
+// groupDocument decodes doc the way a listing query does.
+func groupDocument(t *testing.T, doc bson.M) database.GroupDocument {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var group database.GroupDocument
+	if err := bson.Unmarshal(raw, &group); err != nil {
+		t.Fatalf("decoding %v: %v", doc, err)
+	}
+	return group
+}
+
+func TestMapGroupToProducts(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a0000000000000000000aa")
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	group := func(items ...interface{}) bson.M {
+		return bson.M{
+			"key":         "MOTOR-1",
+			"name":        "Motor",
+			"productType": bson.M{"key": "MOTOR", "name": "ประกันรถยนต์"},
+			"productList": bson.A(items),
+		}
+	}
+	// product is the Product of an entry of group with the given fields.
+	product := func(p Product) Product {
+		p.ProductGroup = ProductGroup{Key: "MOTOR-1", Name: "Motor"}
+		p.ProductType = ProductType{Key: "MOTOR", Name: "ประกันรถยนต์"}
+		if p.Brokers == nil {
+			p.Brokers = []Broker{}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		doc      bson.M
+		want     []Product
+		warnings []Warning
+	}{
+		{
+			name: "every field",
+			doc: group(bson.M{
+				"id":            "MT-001",
+				"productCode":   "MT-001",
+				"productName":   "ชั้น 1",
+				"insurer":       bson.M{"_id": "INS-VIR", "insurerCode": "VIR", "insurerName": "Viriyah"},
+				"brokers":       bson.A{bson.M{"key": "B1", "channelName": "Online"}},
+				"productStatus": "active",
+				"createdAt":     created,
+				"createdBy":     "alice",
+			}),
+			want: []Product{product(Product{
+				ID:          "MT-001",
+				Code:        "MT-001",
+				ProductName: "ชั้น 1",
+				Insurer:     Insurer{ID: "INS-VIR", InsurerCode: "VIR", InsurerName: "Viriyah"},
+				Brokers:     []Broker{{Key: "B1", ChannelName: "Online"}},
+				Status:      StatusActive,
+				CreatedAt:   &created,
+				CreatedBy:   "alice",
+			})},
+		},
+		{
+			name: "missing productType",
+			doc:  bson.M{"key": "G", "productList": bson.A{bson.M{"id": "A"}}},
+			want: []Product{{ID: "A", ProductGroup: ProductGroup{Key: "G"}, Brokers: []Broker{}}},
+		},
+		{
+			name: "productType not a document",
+			doc:  bson.M{"key": "G", "productType": "MOTOR", "productList": bson.A{bson.M{"id": "A"}}},
+			want: []Product{{ID: "A", ProductGroup: ProductGroup{Key: "G"}, Brokers: []Broker{}}},
+		},
+		{
+			name: "missing, null and malformed insurers",
+			doc:  group(bson.M{"id": "A"}, bson.M{"id": "B", "insurer": nil}, bson.M{"id": "C", "insurer": "VIR"}),
+			want: []Product{product(Product{ID: "A"}), product(Product{ID: "B"}), product(Product{ID: "C"})},
+		},
+		{
+			name: "brokers absent, null, empty and with malformed entries",
+			doc: group(
+				bson.M{"id": "A"},
+				bson.M{"id": "B", "brokers": nil},
+				bson.M{"id": "C", "brokers": bson.A{}},
+				bson.M{"id": "D", "brokers": bson.A{"B1", bson.M{"key": "B2"}, nil}},
+			),
+			want: []Product{
+				product(Product{ID: "A"}),
+				product(Product{ID: "B"}),
+				product(Product{ID: "C"}),
+				product(Product{ID: "D", Brokers: []Broker{{Key: "B2"}}}),
+			},
+		},
+		{
+			name: "non-string fields",
+			doc: bson.M{
+				"key":  int32(7),
+				"name": true,
+				"productList": bson.A{bson.M{
+					"id":            int64(42),
+					"productName":   3.5,
+					"productCode":   bson.A{"MT"},
+					"productStatus": int32(1),
+					"insurer":       bson.M{"insurerCode": false, "insurerName": bson.M{"th": "วิริยะ"}},
+					"brokers":       bson.A{bson.M{"key": int32(9), "channelName": nil}},
+					"createdAt":     "2024-03-01",
+					"updatedBy":     oid,
+				}},
+			},
+			want: []Product{{
+				ID:           "42",
+				ProductName:  "3.5",
+				ProductGroup: ProductGroup{Key: "7", Name: "true"},
+				Insurer:      Insurer{InsurerCode: "false"},
+				Brokers:      []Broker{{Key: "9"}},
+				Status:       "1",
+				UpdatedBy:    oid.Hex(),
+			}},
+		},
+		{
+			name: "ObjectID ids",
+			doc:  group(bson.M{"_id": oid}, bson.M{"id": oid}, bson.M{"id": "MT-001", "_id": oid}),
+			want: []Product{product(Product{ID: oid.Hex()}), product(Product{ID: oid.Hex()}), product(Product{ID: "MT-001"})},
+		},
+		{
+			name: "duplicate ids are kept in productList order",
+			doc:  group(bson.M{"id": "A", "productName": "first"}, bson.M{"id": "A", "productName": "second"}),
+			want: []Product{product(Product{ID: "A", ProductName: "first"}), product(Product{ID: "A", ProductName: "second"})},
+		},
+		{
+			name: "entries that are not documents",
+			doc:  group("MT-001", bson.M{"id": "A"}, nil, int32(3)),
+			want: []Product{product(Product{ID: "A"})},
+			warnings: []Warning{
+				{GroupKey: "MOTOR-1", Index: 0, Reason: reasonNotDocument},
+				{GroupKey: "MOTOR-1", Index: 2, Reason: reasonNotDocument},
+				{GroupKey: "MOTOR-1", Index: 3, Reason: reasonNotDocument},
+			},
+		},
+		{
+			name: "productList not an array",
+			doc:  bson.M{"key": "G", "productList": bson.M{"id": "A"}},
+			want: []Product{},
+		},
+		{
+			name: "no productList",
+			doc:  bson.M{"key": "G"},
+			want: []Product{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, warnings := mapGroupToProducts(groupDocument(t, tt.doc))
+			if !reflect.DeepEqual(products, tt.want) {
+				t.Errorf("products\n got %+v\nwant %+v", products, tt.want)
+			}
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("warnings = %+v, want %+v", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+// branchRepo answers the listing's three concurrent queries, failing the
+// one named fail at once and holding the others until they are cancelled.
+type branchRepo struct {
+	*mocks.ProductRepository
+	fail string
+
+	mu    sync.Mutex
+	ended map[string]error
+}
+
+func (r *branchRepo) run(ctx context.Context, branch string) error {
+	if branch == r.fail {
+		return errors.New(branch + " failed")
+	}
+	<-ctx.Done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ended[branch] = ctx.Err()
+	return ctx.Err()
+}
+
+func (r *branchRepo) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	branch := "page"
+	if o := options.MergeFindOptions(opts...); o.Limit != nil && *o.Limit == 1 {
+		branch = "latest update"
+	}
+	return nil, r.run(ctx, branch)
+}
+
+func (r *branchRepo) Count(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return 0, r.run(ctx, "count")
+}
+
+// TestListProductsFailureCancelsOthers fails each of the listing's
+// concurrent queries in turn: the listing returns that query's error, and
+// only once the other two have been cancelled.
+func TestListProductsFailureCancelsOthers(t *testing.T) {
+	branches := []string{"page", "count", "latest update"}
+	for _, fail := range branches {
+		t.Run(fail, func(t *testing.T) {
+			repo := &branchRepo{ProductRepository: &mocks.ProductRepository{}, fail: fail, ended: map[string]error{}}
+			h := New(testConfig(), Deps{
+				Repos:  func(string) database.ProductRepository { return repo },
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			var err error
+			app := fiber.New()
+			// As in production; the branches read the request concurrently.
+			app.Use(h.RequestContext)
+			app.Get("/products", func(c *fiber.Ctx) error {
+				c.Locals(tenantKey, &database.Tenant{Name: "test"})
+				builder := NewFilterBuilder().Status([]ProductStatus{StatusActive})
+				opts := options.Find().SetSkip(0).SetLimit(20).SetMaxTime(time.Second)
+				_, err = h.listProducts(c, context.Background(), builder, builder.Build(), opts)
+				return nil
+			})
+
+			start := time.Now()
+			if _, e := app.Test(httptest.NewRequest(fiber.MethodGet, "/products", nil), -1); e != nil {
+				t.Fatal(e)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("the listing took %s to fail", took)
+			}
+			if err == nil || err.Error() != fail+" failed" {
+				t.Errorf("listProducts = %v, want the %s failure", err, fail)
+			}
+			for _, branch := range branches {
+				if branch == fail {
+					continue
+				}
+				if ended := repo.ended[branch]; !errors.Is(ended, context.Canceled) {
+					t.Errorf("%s ended with %v, want context.Canceled", branch, ended)
+				}
+			}
+		})
+	}
+}
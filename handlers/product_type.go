@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductTypeRecord is an entry of the product type master data.
+type ProductTypeRecord struct {
+	Key       string    `json:"key" bson:"_id"`
+	Name      string    `json:"name" bson:"name"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty" bson:"updatedBy"`
+}
+
+// ProductTypeRecordInput is the body of the product type create and update
+// endpoints. Key is only read on create.
+type ProductTypeRecordInput struct {
+	Key  string `json:"key" validate:"pattern=groupKey"`
+	Name string `json:"name" validate:"required,max=200"`
+}
+
+func (h *Handler) loadProductTypes(c *fiber.Ctx, ctx context.Context) ([]ProductTypeRecord, error) {
+	types := []ProductTypeRecord{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := tenant(c).ProductTypes.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &types)
+	})
+	return types, err
+}
+
+type productTypeUsage struct {
+	Key struct {
+		Key  string `bson:"key"`
+		Name string `bson:"name"`
+	} `bson:"_id"`
+	Groups   int64         `bson:"groups"`
+	GroupIDs []interface{} `bson:"groupIds"`
+}
+
+func (h *Handler) productTypeUsage(c *fiber.Ctx, ctx context.Context) ([]productTypeUsage, error) {
+	usage := []productTypeUsage{}
+	err := database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, database.ProductTypeUsagePipeline(),
+			options.Aggregate().SetAllowDiskUse(h.cfg.Mongo.AnalyticsAllowDiskUse).SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &usage)
+	})
+	return usage, err
+}
+
+// GetProductTypes lists the product types of the master data, plus as
+// orphans the type keys groups use that the master does not have.
+func (h *Handler) GetProductTypes(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	types, err := h.loadProductTypes(c, ctx)
+	if err != nil {
+		return queryError(c, "listing product types", err)
+	}
+	usage, err := h.productTypeUsage(c, ctx)
+	if err != nil {
+		return queryError(c, "aggregating product type usage", err)
+	}
+	known := map[string]bool{}
+	for _, t := range types {
+		known[t.Key] = true
+	}
+	orphans, seen := []string{}, map[string]bool{}
+	for _, u := range usage {
+		if k := u.Key.Key; k != "" && !known[k] && !seen[k] {
+			seen[k] = true
+			orphans = append(orphans, k)
+		}
+	}
+	return c.JSON(fiber.Map{"totalCount": len(types), "data": types, "orphans": orphans})
+}
+
+func (h *Handler) GetProductType(c *fiber.Ctx) error {
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var t ProductTypeRecord
+	err := database.Breaker.Do(ctx, func() error {
+		return tenant(c).ProductTypes.FindOne(ctx, bson.M{"_id": c.Params("key")}).Decode(&t)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return productTypeNotFound(c)
+	}
+	if err != nil {
+		return queryError(c, "finding product type", err)
+	}
+	return c.JSON(t)
+}
+
+func (h *Handler) CreateProductType(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	var in ProductTypeRecordInput
+	if ok, err := parseBody(c, &in); !ok {
+		return err
+	}
+	if in.Key == "" {
+		return apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED", "request body failed validation",
+			validation.Errors{{Field: "key", Rule: "required", Message: "is required"}})
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	t := ProductTypeRecord{Key: in.Key, Name: database.Normalize(in.Name), UpdatedAt: database.Timestamp(h.now()), UpdatedBy: actor}
+	err = database.Breaker.Do(ctx, func() error {
+		_, err := tenant(c).ProductTypes.InsertOne(ctx, t)
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return apierror.Send(c, fiber.StatusConflict, "PRODUCT_TYPE_EXISTS", "product type "+in.Key+" already exists")
+	}
+	if err != nil {
+		return queryError(c, "creating product type", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(t)
+}
+
+// UpdateProductType renames a product type in the master data. Groups keep
+// their copy until reconciled.
+func (h *Handler) UpdateProductType(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	var in ProductTypeRecordInput
+	if ok, err := parseBody(c, &in); !ok {
+		return err
+	}
+	key := c.Params("key")
+	if in.Key != "" && in.Key != key {
+		return apierror.Send(c, fiber.StatusBadRequest, "KEY_MISMATCH", "the body's key must match the path or be omitted")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var t ProductTypeRecord
+	err = database.Breaker.Do(ctx, func() error {
+		return tenant(c).ProductTypes.FindOneAndUpdate(ctx,
+			bson.M{"_id": key},
+			bson.M{"$set": bson.M{"name": database.Normalize(in.Name), "updatedAt": database.Timestamp(h.now()), "updatedBy": actor}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&t)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return productTypeNotFound(c)
+	}
+	if err != nil {
+		return queryError(c, "updating product type", err)
+	}
+	return c.JSON(t)
+}
+
+// DeleteProductType removes a product type no group uses; otherwise it
+// answers 409 with the number of groups that do.
+func (h *Handler) DeleteProductType(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	key := c.Params("key")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var n int64
+	err := database.Breaker.Do(ctx, func() error {
+		var err error
+		n, err = h.repo(c).Count(ctx, bson.M{"productType.key": key})
+		return err
+	})
+	if err != nil {
+		return queryError(c, "counting product type groups", err)
+	}
+	if n > 0 {
+		return apierror.SendDetails(c, fiber.StatusConflict, "PRODUCT_TYPE_IN_USE",
+			fmt.Sprintf("product type %s is used by %d groups", key, n), fiber.Map{"groupCount": n})
+	}
+
+	var res *mongo.DeleteResult
+	err = database.Breaker.Do(ctx, func() error {
+		var err error
+		res, err = tenant(c).ProductTypes.DeleteOne(ctx, bson.M{"_id": key})
+		return err
+	})
+	if err != nil {
+		return queryError(c, "deleting product type", err)
+	}
+	if res.DeletedCount == 0 {
+		return productTypeNotFound(c)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func productTypeNotFound(c *fiber.Ctx) error {
+	return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_TYPE_NOT_FOUND", "product type "+c.Params("key")+" does not exist")
+}
+
+// checkProductType holds a group write to the product type master data
+// under MasterData.EnforceProductTypes. The master's name replaces the one
+// given, so groups cannot drift from it. It writes the 422 itself when ok
+// is false.
+func (h *Handler) checkProductType(c *fiber.Ctx, ctx context.Context, in *ProductTypeInput) (ok bool, err error) {
+	if !h.cfg.MasterData.EnforceProductTypes || in.Key == "" {
+		return true, nil
+	}
+	types, err := h.loadProductTypes(c, ctx)
+	if err != nil {
+		return false, queryError(c, "loading product types", err)
+	}
+	keys := make([]string, 0, len(types))
+	for _, t := range types {
+		if t.Key == in.Key {
+			in.Name = t.Name
+			return true, nil
+		}
+		keys = append(keys, t.Key)
+	}
+	return false, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "UNKNOWN_PRODUCT_TYPE",
+		"product type "+in.Key+" is not registered in the product type master data",
+		brokerProblem{Key: in.Key, Suggestions: suggest(in.Key, keys)})
+}
+
+type productTypeDrift struct {
+	Key        string `json:"key"`
+	Name       string `json:"name"`
+	MasterName string `json:"masterName"`
+	Groups     int64  `json:"groups"`
+}
+
+// ReconcileProductTypes reports the groups whose embedded productType.name
+// differs from the master's, by key and name. POST with ?fix=true also
+// rewrites those names to the master's and resyncs products_flat.
+func (h *Handler) ReconcileProductTypes(c *fiber.Ctx) error {
+	fix := c.Method() == fiber.MethodPost && query(c, "fix") == "true"
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	types, err := h.loadProductTypes(c, ctx)
+	if err != nil {
+		return queryError(c, "loading product types", err)
+	}
+	usage, err := h.productTypeUsage(c, ctx)
+	if err != nil {
+		return queryError(c, "aggregating product type usage", err)
+	}
+	master := map[string]string{}
+	for _, t := range types {
+		master[t.Key] = t.Name
+	}
+
+	drift := []productTypeDrift{}
+	var groupIDs []interface{}
+	for _, u := range usage {
+		name, ok := master[u.Key.Key]
+		if !ok || u.Key.Name == name {
+			continue
+		}
+		drift = append(drift, productTypeDrift{Key: u.Key.Key, Name: u.Key.Name, MasterName: name, Groups: u.Groups})
+		groupIDs = append(groupIDs, u.GroupIDs...)
+	}
+	if !fix || len(drift) == 0 {
+		return c.JSON(fiber.Map{"fixed": false, "data": drift})
+	}
+
+	seen := map[string]bool{}
+	for _, d := range drift {
+		if seen[d.Key] {
+			continue
+		}
+		seen[d.Key] = true
+		err := database.Breaker.Do(ctx, func() error {
+			_, err := tenant(c).Products.UpdateMany(ctx,
+				bson.M{"productType.key": d.Key, "productType.name": bson.M{"$ne": d.MasterName}},
+				bson.M{"$set": bson.M{"productType.name": d.MasterName}})
+			return err
+		})
+		if err != nil {
+			return queryError(c, "fixing product type names", err)
+		}
+	}
+	h.invalidateCache(c)
+	for _, id := range groupIDs {
+		h.syncFlat(c, bson.M{"_id": id})
+	}
+	return c.JSON(fiber.Map{"fixed": true, "data": drift})
+}
@@ -0,0 +1,148 @@
+//go:build integration
+
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+// typeMaster registers the fixtures' HEALTH and MOTOR types, MOTOR under
+// another name, and LIFE, which no group uses. TRAVEL is left an orphan.
+func typeMaster(t *testing.T, app *fiber.App) {
+	t.Helper()
+	for _, body := range []string{
+		`{"key": "HEALTH", "name": "ประกันสุขภาพ"}`,
+		`{"key": "MOTOR", "name": "ประกันรถยนต์"}`,
+		`{"key": "LIFE", "name": "Life"}`,
+	} {
+		if resp, out := call(t, app, fiber.MethodPost, "/product-types", body); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", body, resp.StatusCode, out)
+		}
+	}
+}
+
+func TestIntegrationProductTypes(t *testing.T) {
+	app := integrationApp(t, nil)
+	typeMaster(t, app)
+
+	if resp, out := call(t, app, fiber.MethodPost, "/product-types", `{"key": "LIFE", "name": "Life"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second create: %d %s, want 409", resp.StatusCode, out)
+	}
+
+	resp, out := call(t, app, fiber.MethodGet, "/product-types", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("list: %d %s", resp.StatusCode, out)
+	}
+	var page struct {
+		Data    []handlers.ProductTypeRecord
+		Orphans []string
+	}
+	if err := json.Unmarshal(out, &page); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, pt := range page.Data {
+		keys = append(keys, pt.Key)
+	}
+	if want := []string{"HEALTH", "LIFE", "MOTOR"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("types = %v, want %v", keys, want)
+	}
+	if want := []string{"TRAVEL"}; !reflect.DeepEqual(page.Orphans, want) {
+		t.Errorf("orphans = %v, want %v", page.Orphans, want)
+	}
+
+	if resp, out := call(t, app, fiber.MethodPut, "/product-types/LIFE", `{"name": "ประกันชีวิต"}`); resp.StatusCode != http.StatusOK || !strings.Contains(string(out), "ประกันชีวิต") {
+		t.Errorf("update: %d %s", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodDelete, "/product-types/HEALTH", ""); resp.StatusCode != http.StatusConflict || !strings.Contains(string(out), `"groupCount":1`) {
+		t.Errorf("delete in use: %d %s, want 409 with the group count", resp.StatusCode, out)
+	}
+	if resp, out := call(t, app, fiber.MethodDelete, "/product-types/LIFE", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete unused: %d %s", resp.StatusCode, out)
+	}
+	for _, missing := range []struct{ method, body string }{
+		{fiber.MethodGet, ""},
+		{fiber.MethodPut, `{"name": "Life"}`},
+		{fiber.MethodDelete, ""},
+	} {
+		if resp, out := call(t, app, missing.method, "/product-types/LIFE", missing.body); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s of a missing type: %d %s, want 404", missing.method, resp.StatusCode, out)
+		}
+	}
+}
+
+// TestIntegrationReconcileProductTypes reports MOTOR-1's drifted type name,
+// fixes it, and then has nothing left to report.
+func TestIntegrationReconcileProductTypes(t *testing.T) {
+	app := integrationApp(t, nil)
+	typeMaster(t, app)
+
+	type drift struct {
+		Key        string `json:"key"`
+		Name       string `json:"name"`
+		MasterName string `json:"masterName"`
+		Groups     int64  `json:"groups"`
+	}
+	reconcile := func(method, target string) (fixed bool, data []drift) {
+		t.Helper()
+		resp, out := call(t, app, method, target, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", method, target, resp.StatusCode, out)
+		}
+		var report struct {
+			Fixed bool
+			Data  []drift
+		}
+		if err := json.Unmarshal(out, &report); err != nil {
+			t.Fatal(err)
+		}
+		return report.Fixed, report.Data
+	}
+	want := []drift{{Key: "MOTOR", Name: "Motor", MasterName: "ประกันรถยนต์", Groups: 1}}
+
+	// A GET never fixes, even when asked to.
+	if fixed, data := reconcile(fiber.MethodGet, "/admin/product-types/reconcile?fix=true"); fixed || !reflect.DeepEqual(data, want) {
+		t.Errorf("report = %t %+v, want %+v unfixed", fixed, data, want)
+	}
+	if fixed, data := reconcile(fiber.MethodPost, "/admin/product-types/reconcile?fix=true"); !fixed || !reflect.DeepEqual(data, want) {
+		t.Errorf("fix = %t %+v, want %+v fixed", fixed, data, want)
+	}
+	if fixed, data := reconcile(fiber.MethodGet, "/admin/product-types/reconcile"); fixed || len(data) != 0 {
+		t.Errorf("after the fix = %t %+v, want nothing", fixed, data)
+	}
+	if resp, out := call(t, app, fiber.MethodGet, "/groups/MOTOR-1", ""); !strings.Contains(string(out), "ประกันรถยนต์") {
+		t.Errorf("MOTOR-1 after the fix: %d %s", resp.StatusCode, out)
+	}
+}
+
+// TestIntegrationProductTypeEnforcement creates groups with the product
+// type master data enforced.
+func TestIntegrationProductTypeEnforcement(t *testing.T) {
+	app := integrationApp(t, map[string]string{"MASTER_DATA_ENFORCE_PRODUCT_TYPES": "true"})
+	typeMaster(t, app)
+
+	resp, out := call(t, app, fiber.MethodPost, "/groups", `{"key": "LIFE-1", "name": "Life", "productType": {"key": "LIFF"}}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(out), `"UNKNOWN_PRODUCT_TYPE"`) || !strings.Contains(string(out), `"suggestions":["LIFE"]`) {
+		t.Errorf("unknown type: %d %s, want 422 UNKNOWN_PRODUCT_TYPE suggesting LIFE", resp.StatusCode, out)
+	}
+
+	// The master's name replaces the one given.
+	resp, out = call(t, app, fiber.MethodPost, "/groups", `{"key": "LIFE-1", "name": "Life", "productType": {"key": "LIFE", "name": "Lyfe"}}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("registered type: %d %s", resp.StatusCode, out)
+	}
+	var created handlers.GroupSummary
+	if err := json.Unmarshal(out, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ProductType.Name != "Life" {
+		t.Errorf("productType = %+v, want the master's name", created.ProductType)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func productTypeRoutes(app *fiber.App, h *Handler) {
+	app.Post("/product-types", h.CreateProductType)
+	app.Put("/product-types/:key", h.UpdateProductType)
+	app.Delete("/product-types/:key", h.DeleteProductType)
+}
+
+// TestProductTypeRequestsRejected is TestBrokerRequestsRejected for
+// product types.
+func TestProductTypeRequestsRejected(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+		code                       string
+	}{
+		{"create without key", fiber.MethodPost, "/product-types", `{"name": "Life"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create malformed key", fiber.MethodPost, "/product-types", `{"key": "life", "name": "Life"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"create without name", fiber.MethodPost, "/product-types", `{"key": "LIFE"}`, http.StatusUnprocessableEntity, "VALIDATION_FAILED"},
+		{"update other key", fiber.MethodPut, "/product-types/LIFE", `{"key": "HEALTH", "name": "Life"}`, http.StatusBadRequest, "KEY_MISMATCH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), &mocks.ProductRepository{}, productTypeRoutes)
+			resp, body := do(t, app, tt.method, tt.target, tt.body)
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Errorf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+// TestDeleteProductTypeInUse is TestDeleteInsurerInUse for product types,
+// which count the groups using them.
+func TestDeleteProductTypeInUse(t *testing.T) {
+	repo := &mocks.ProductRepository{Total: 2}
+	app := newTestApp(testConfig(), repo, productTypeRoutes)
+	resp, body := do(t, app, fiber.MethodDelete, "/product-types/HEALTH", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(body) != "PRODUCT_TYPE_IN_USE" {
+		t.Fatalf("%d %s, want 409 PRODUCT_TYPE_IN_USE", resp.StatusCode, body)
+	}
+	var env struct {
+		Error struct {
+			Details struct {
+				GroupCount int64 `json:"groupCount"`
+			}
+		}
+	}
+	decode(t, body, &env)
+	if env.Error.Details.GroupCount != 2 {
+		t.Errorf("details = %+v, want groupCount 2", env.Error.Details)
+	}
+	counts := callsOf(repo, "Count")
+	if len(counts) != 1 || !reflect.DeepEqual(counts[0].Filter, bson.M{"productType.key": "HEALTH"}) {
+		t.Errorf("counts = %+v, want the groups of HEALTH", counts)
+	}
+
+	repo = &mocks.ProductRepository{Err: errors.New("connection reset")}
+	app = newTestApp(testConfig(), repo, productTypeRoutes)
+	if resp, body := do(t, app, fiber.MethodDelete, "/product-types/HEALTH", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failed count: %d %s, want 500", resp.StatusCode, body)
+	}
+}
+
+// TestCheckProductTypeDisabled checks that group creation reads no product
+// type master data unless it is enforced and the group names a type.
+func TestCheckProductTypeDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		enforced bool
+		body     string
+	}{
+		{"not enforced", false, `{"key": "LIFE-1", "name": "Life", "productType": {"key": "LIFE", "name": "Life"}}`},
+		{"no type", true, `{"key": "LIFE-1", "name": "Life"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.MasterData.EnforceProductTypes = tt.enforced
+			app := newTestApp(cfg, &mocks.ProductRepository{}, groupRoutes)
+			if resp, body := do(t, app, fiber.MethodPost, "/groups", tt.body); resp.StatusCode != http.StatusCreated {
+				t.Errorf("%d %s, want 201", resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/breaker"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	validation.RegisterPattern("insurerCode", regexp.MustCompile(`^[A-Z0-9]{2,10}$`))
+	validation.RegisterPattern("brokerKey", regexp.MustCompile(`^[A-Z0-9]+(?:[-_][A-Z0-9]+)*$`))
+}
+
+// ProductInput is the body accepted by the create and update endpoints.
+type ProductInput struct {
+	ProductName  string            `json:"productName" validate:"required,max=200"`
+	ProductGroup ProductGroupInput `json:"productGroup"`
+	Insurer      InsurerInput      `json:"insurer"`
+	Brokers      []BrokerInput     `json:"brokers" validate:"max=50,dive"`
+	Status       ProductStatus     `json:"status" validate:"required,productStatus"`
+}
+
+type ProductGroupInput struct {
+	Key string `json:"key" validate:"required"`
+}
+
+type InsurerInput struct {
+	ID          string `json:"_id"`
+	InsurerCode string `json:"insurerCode" validate:"required,pattern=insurerCode"`
+	InsurerName string `json:"insurerName" validate:"max=200"`
+}
+
+type BrokerInput struct {
+	Key         string `json:"key" validate:"required,pattern=brokerKey"`
+	ChannelName string `json:"channelName" validate:"max=200"`
+}
+
+func (in ProductInput) item(id string) bson.M {
+	brokers := bson.A{}
+	for _, b := range in.Brokers {
+		brokers = append(brokers, bson.M{"key": b.Key, "keyLower": strings.ToLower(b.Key), "channelName": b.ChannelName})
+	}
+	return bson.M{
+		"id":             id,
+		"productName":    database.Normalize(in.ProductName),
+		"productNameKey": database.NameKey(in.ProductName),
+		"insurer": bson.M{
+			"_id":              in.Insurer.ID,
+			"insurerCode":      in.Insurer.InsurerCode,
+			"insurerCodeLower": strings.ToLower(in.Insurer.InsurerCode),
+			"insurerName":      in.Insurer.InsurerName,
+		},
+		"brokers":       brokers,
+		"productStatus": in.Status,
+	}
+}
+
+func (in ProductInput) product(id string, group bson.M) Product {
+	brokers := make([]Broker, 0, len(in.Brokers))
+	for _, b := range in.Brokers {
+		brokers = append(brokers, Broker{Key: b.Key, ChannelName: b.ChannelName})
+	}
+	return Product{
+		ID:          id,
+		ProductName: in.ProductName,
+		ProductGroup: ProductGroup{
+			Name: getStringField(group, "name"),
+			Key:  getStringField(group, "key"),
+		},
+		ProductType: ProductType{
+			Name: getStringField(group["productType"], "name"),
+			Key:  getStringField(group["productType"], "key"),
+		},
+		Insurer: Insurer{
+			ID:          in.Insurer.ID,
+			InsurerCode: in.Insurer.InsurerCode,
+			InsurerName: in.Insurer.InsurerName,
+		},
+		Brokers: brokers,
+		Status:  in.Status,
+	}
+}
+
+// parseProductInput decodes and validates the request body, writing the
+// error response itself when the body is unacceptable.
+func parseProductInput(c *fiber.Ctx) (*ProductInput, error) {
+	var in ProductInput
+	if err := validation.DecodeJSON(c.Body(), &in); err != nil {
+		return nil, apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if verrs := in.validate(); verrs != nil {
+		return nil, apierror.SendDetails(c, fiber.StatusUnprocessableEntity, "VALIDATION_FAILED",
+			"request body failed validation", verrs)
+	}
+	return &in, nil
+}
+
+// validate is the validation every write path applies to a ProductInput.
+// It returns nil when in is acceptable.
+func (in *ProductInput) validate() validation.Errors {
+	var verrs validation.Errors
+	errors.As(validation.Struct(in), &verrs)
+	return verrs
+}
+
+// dryRun reports whether the write was asked, with ?dryRun=true, to stop
+// after validation.
+func dryRun(c *fiber.Ctx) bool {
+	return query(c, "dryRun") == "true"
+}
+
+// writer returns the identity recorded on a write: the principal's subject,
+// which is the key name for API keys. Writes need one; without it the 401
+// is sent and ok is false.
+func writer(c *fiber.Ctx) (subject string, ok bool, err error) {
+	p := middleware.PrincipalFrom(c)
+	if p == nil || p.Subject == "" {
+		return "", false, apierror.Send(c, fiber.StatusUnauthorized, "UNAUTHORIZED", "writes require an authenticated principal")
+	}
+	return p.Subject, true, nil
+}
+
+func (h *Handler) CreateProduct(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	in, err := parseProductInput(c)
+	if in == nil {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	if ok, err := h.checkInsurer(c, ctx, in); !ok {
+		return err
+	}
+	if ok, err := h.checkBrokers(c, ctx, in); !ok {
+		return err
+	}
+	if dryRun(c) {
+		return c.JSON(fiber.Map{"valid": true})
+	}
+
+	id := primitive.NewObjectID().Hex()
+	item := in.item(id)
+	item["createdBy"], item["updatedBy"] = actor, actor
+	target := bson.M{"key": in.ProductGroup.Key}
+	var group bson.M
+	err = h.audited(c, ctx, audit.ActionCreate, func(ctx context.Context) (*auditedChange, error) {
+		// A create that then fails leaves a gap in the sequence, never a
+		// reused code.
+		seq, err := h.repo(c).NextSequence(ctx, database.ProductSequence(in.ProductGroup.Key, in.Insurer.InsurerCode))
+		if err != nil {
+			return nil, err
+		}
+		item["productCode"] = database.ProductCode(in.ProductGroup.Key, in.Insurer.InsurerCode, seq)
+		err = h.repo(c).PushProduct(ctx, uniqueProduct(target, in, ""), item).Decode(&group)
+		if err != nil {
+			return nil, err
+		}
+		return &auditedChange{productID: id, groupKey: in.ProductGroup.Key, after: item}, nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if ok, err := h.duplicateProduct(c, ctx, target, in, ""); ok {
+			return err
+		}
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_GROUP_NOT_FOUND", "product group "+in.ProductGroup.Key+" does not exist")
+	}
+	if errors.Is(err, breaker.ErrOpen) {
+		return unavailable(c)
+	}
+	if err != nil {
+		return apierror.Internal(c, "creating product", err)
+	}
+
+	h.invalidateCache(c)
+	h.syncFlat(c, group)
+	product := in.product(id, group)
+	product.Code = getStringField(item, "productCode")
+	product.CreatedAt, product.UpdatedAt = timeField(item, "createdAt"), timeField(item, "updatedAt")
+	product.CreatedBy, product.UpdatedBy = actor, actor
+	return c.Status(fiber.StatusCreated).JSON(product)
+}
+
+func (h *Handler) UpdateProduct(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	in, err := parseProductInput(c)
+	if in == nil {
+		return err
+	}
+	id := c.Params("id")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	if ok, err := h.checkInsurer(c, ctx, in); !ok {
+		return err
+	}
+	if ok, err := h.checkBrokers(c, ctx, in); !ok {
+		return err
+	}
+	if dryRun(c) {
+		return c.JSON(fiber.Map{"valid": true})
+	}
+
+	// The product stays in its group; moving between groups is not an update.
+	item := in.item(id)
+	item["updatedBy"] = actor
+	target := bson.M{"key": in.ProductGroup.Key}
+	var group bson.M
+	err = h.audited(c, ctx, audit.ActionUpdate, func(ctx context.Context) (*auditedChange, error) {
+		// The pre-update document supplies the diff's old values. The status
+		// transition is checked in the filter, like uniqueness, so a
+		// concurrent status change cannot slip past it.
+		filter := bson.M{"$and": bson.A{uniqueProduct(target, in, id), allowsStatus(id, in.Status)}}
+		err := h.repo(c).SetProduct(ctx, filter, id, item).Decode(&group)
+		if err != nil {
+			return nil, err
+		}
+		return &auditedChange{productID: id, groupKey: in.ProductGroup.Key, before: findItem(group, id), after: item}, nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if ok, err := h.statusConflict(c, ctx, in.ProductGroup.Key, id, in.Status); ok {
+			return err
+		}
+		exists := bson.M{"$and": bson.A{target, database.ItemFilter(id)}}
+		if ok, err := h.duplicateProduct(c, ctx, exists, in, id); ok {
+			return err
+		}
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist in group "+in.ProductGroup.Key)
+	}
+	if errors.Is(err, breaker.ErrOpen) {
+		return unavailable(c)
+	}
+	if err != nil {
+		return apierror.Internal(c, "updating product", err)
+	}
+
+	h.invalidateCache(c)
+	h.syncFlat(c, group)
+	product := in.product(id, group)
+	before := findItem(group, id)
+	product.Code = getStringField(before, "productCode")
+	product.CreatedAt, product.UpdatedAt = timeField(before, "createdAt"), timeField(item, "updatedAt")
+	product.CreatedBy, product.UpdatedBy = getStringField(before, "createdBy"), actor
+	return c.JSON(product)
+}
+
+// statusConflict answers an update that matched nothing. When the product
+// is in groupKey with a status that may not change to to, it writes the
+// 409 and ok is true; otherwise the update failed for another reason and
+// nothing is written.
+func (h *Handler) statusConflict(c *fiber.Ctx, ctx context.Context, groupKey, id string, to ProductStatus) (ok bool, err error) {
+	group, item, err := h.findProduct(c, ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return true, queryError(c, "looking up the product", err)
+	}
+	from := normalizeStatus(item.Status.String())
+	if group.Key.String() != groupKey || CanTransition(from, to) {
+		return false, nil
+	}
+	return true, transitionRejected(c, from, to)
+}
+
+// timeField returns a date field of a bson.M document, or nil.
+func timeField(doc bson.M, key string) *time.Time {
+	switch t := doc[key].(type) {
+	case time.Time:
+		return &t
+	case primitive.DateTime:
+		v := t.Time().UTC()
+		return &v
+	}
+	return nil
+}
+
+// findItem returns the productList entry of group with the given id, in
+// either form.
+func findItem(group bson.M, id string) bson.M {
+	list, _ := group["productList"].(bson.A)
+	for _, item := range list {
+		m, ok := item.(bson.M)
+		if !ok {
+			continue
+		}
+		if m["id"] == id {
+			return m
+		}
+		if oid, ok := m["_id"].(primitive.ObjectID); ok && m["id"] == nil && oid.Hex() == id {
+			return m
+		}
+	}
+	return nil
+}
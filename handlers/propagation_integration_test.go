@@ -0,0 +1,110 @@
+//go:build integration
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// renameGroup is a group with a TIP product under the name tip and an
+// AXA product.
+func renameGroup(key, tip string) bson.M {
+	return bson.M{"key": key, "productList": bson.A{
+		bson.M{"id": key + "-1", "insurer": bson.M{"insurerCode": "TIP", "insurerName": tip}},
+		bson.M{"id": key + "-2", "insurer": bson.M{"insurerCode": "AXA", "insurerName": "AXA Insurance"}},
+	}}
+}
+
+// insurerNames returns the embedded insurer names by product id.
+func insurerNames(t *testing.T, tenant *database.Tenant) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	cursor, err := tenant.Products.Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []database.GroupDocument
+	if err := cursor.All(ctx, &groups); err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]string{}
+	for _, g := range groups {
+		for _, p := range g.ProductList {
+			names[string(p.ID)] = string(p.Insurer.InsurerName)
+		}
+	}
+	return names
+}
+
+// TestPropagateInsurerName renames TIP across five stale groups, two at a
+// time, then resumes the job after more groups went stale.
+func TestPropagateInsurerName(t *testing.T) {
+	var groups []interface{}
+	for i := 0; i < 5; i++ {
+		groups = append(groups, renameGroup(fmt.Sprintf("G%d", i), "Dhipaya"))
+	}
+	groups = append(groups, renameGroup("CURRENT", "ทิพยประกันภัย"))
+	tenant, _ := schedulerTenant(t, groups...)
+	ctx := context.Background()
+	if _, err := tenant.Insurers.InsertOne(ctx, bson.M{"_id": "TIP", "insurerName": "ทิพยประกันภัย"}); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &database.Job{ID: primitive.NewObjectID(), Kind: database.JobInsurerRename, Target: "insurer:TIP", State: database.JobRunning}
+	if err := database.PropagateInsurerName(ctx, tenant, job, "TIP", 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if job.Batches != 3 || job.Groups != 5 || job.Stale != 0 {
+		t.Errorf("job = %d batches, %d groups, %d stale; want 3, 5, 0", job.Batches, job.Groups, job.Stale)
+	}
+	for id, name := range insurerNames(t, tenant) {
+		want := "ทิพยประกันภัย"
+		if id[len(id)-1] == '2' {
+			want = "AXA Insurance"
+		}
+		if name != want {
+			t.Errorf("%s insurerName = %q, want %q", id, name, want)
+		}
+	}
+	saved, ok, err := database.FindJob(ctx, tenant, job.ID)
+	if err != nil || !ok {
+		t.Fatalf("FindJob = %v, %v", ok, err)
+	}
+	if saved.Batches != job.Batches || saved.Groups != job.Groups {
+		t.Errorf("saved progress = %d batches, %d groups, want the job's", saved.Batches, saved.Groups)
+	}
+
+	// The job picks up where it is: a rerun only updates what went stale
+	// since, and a second rename meanwhile is what it converges on.
+	if _, err := tenant.Products.InsertOne(ctx, renameGroup("LATE", "Dhipaya")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant.Insurers.UpdateOne(ctx, bson.M{"_id": "TIP"}, bson.M{"$set": bson.M{"insurerName": "Dhipaya Insurance"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PropagateInsurerName(ctx, tenant, job, "TIP", 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if job.Batches != 7 || job.Groups != 12 || job.Stale != 0 {
+		t.Errorf("resumed job = %d batches, %d groups, %d stale; want 7, 12, 0", job.Batches, job.Groups, job.Stale)
+	}
+	for id, name := range insurerNames(t, tenant) {
+		if id[len(id)-1] == '1' && name != "Dhipaya Insurance" {
+			t.Errorf("%s insurerName = %q after the second rename", id, name)
+		}
+	}
+
+	before := *job
+	if err := database.PropagateInsurerName(ctx, tenant, job, "TIP", 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if job.Batches != before.Batches || job.Groups != before.Groups {
+		t.Errorf("a rerun with nothing stale ran %d batches", job.Batches-before.Batches)
+	}
+}
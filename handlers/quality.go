@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"math"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type qualityRuleCount struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Count       int64  `json:"count"`
+}
+
+type groupCompleteness struct {
+	GroupKey string `json:"groupKey" bson:"groupKey"`
+	Products int64  `json:"products" bson:"products"`
+	Complete int64  `json:"complete" bson:"complete"`
+	// Completeness is the percentage of products no rule flags.
+	Completeness float64 `json:"completeness" bson:"-"`
+}
+
+// GetDataQuality reports how complete the product data is: how many
+// products each rule of the data quality registry flags, and per group the
+// share of products none does. ?rule= lists one rule's products a page at
+// a time.
+func (h *Handler) GetDataQuality(c *fiber.Ctx) error {
+	rules := database.QualityRules()
+	if name := query(c, "rule"); name != "" {
+		rule, ok := database.QualityRuleNamed(name)
+		if !ok {
+			names := make([]string, len(rules))
+			for i, r := range rules {
+				names[i] = r.Name
+			}
+			return apierror.SendDetails(c, fiber.StatusBadRequest, "INVALID_RULE",
+				"rule must be one of "+strings.Join(names, ", "), fiber.Map{"rules": names})
+		}
+		return h.qualityRuleProducts(c, rule)
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var out []struct {
+		Rules  []map[string]int64  `bson:"rules"`
+		Groups []groupCompleteness `bson:"groups"`
+	}
+	if err := h.aggregateAll(c, ctx, database.QualityReportPipeline(rules), &out); err != nil {
+		return queryError(c, "computing data quality", err)
+	}
+	counts := map[string]int64{}
+	groups := []groupCompleteness{}
+	if len(out) > 0 {
+		if len(out[0].Rules) > 0 {
+			counts = out[0].Rules[0]
+		}
+		groups = append(groups, out[0].Groups...)
+	}
+	report := make([]qualityRuleCount, len(rules))
+	for i, r := range rules {
+		report[i] = qualityRuleCount{Name: r.Name, Description: r.Description, Count: counts[r.Name]}
+	}
+	for i := range groups {
+		if g := &groups[i]; g.Products > 0 {
+			g.Completeness = math.Round(float64(g.Complete)/float64(g.Products)*1000) / 10
+		}
+	}
+	return c.JSON(fiber.Map{"rules": report, "groups": groups})
+}
+
+func (h *Handler) qualityRuleProducts(c *fiber.Ctx, rule database.QualityRule) error {
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	pipeline := append(database.QualityRuleStages(rule),
+		bson.D{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "n"}},
+			"items": bson.A{bson.M{"$skip": paging.Skip()}, bson.M{"$limit": paging.Limit}},
+		}}},
+	)
+	var out []struct {
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+		Items []database.QualityRow `bson:"items"`
+	}
+	if err := h.aggregateAll(c, ctx, pipeline, &out); err != nil {
+		return queryError(c, "listing data quality issues", err)
+	}
+	total, rows := int64(0), []database.QualityRow{}
+	if len(out) > 0 {
+		if len(out[0].Total) > 0 {
+			total = out[0].Total[0].N
+		}
+		rows = append(rows, out[0].Items...)
+	}
+	setPageLinks(c, paging, total, len(rows))
+	return c.JSON(fiber.Map{"rule": rule.Name, "totalCount": total, "data": rows})
+}
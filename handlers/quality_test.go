@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func qualityRoutes(app *fiber.App, h *Handler) {
+	app.Get("/admin/data-quality", h.GetDataQuality)
+}
+
+func TestGetDataQuality(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{
+		"rules": bson.A{bson.M{"missing_brokers": int64(2), "missing_code": int64(1)}},
+		"groups": bson.A{
+			bson.M{"groupKey": "G-A", "products": int64(3), "complete": int64(1)},
+			bson.M{"groupKey": "G-B", "products": int64(1), "complete": int64(0)},
+		},
+	}}}
+	resp, body := do(t, newTestApp(testConfig(), repo, qualityRoutes), fiber.MethodGet, "/admin/data-quality", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var report struct {
+		Rules  []qualityRuleCount  `json:"rules"`
+		Groups []groupCompleteness `json:"groups"`
+	}
+	decode(t, body, &report)
+
+	// Every registered rule is reported, the ones flagging nothing at 0.
+	rules := database.QualityRules()
+	if len(report.Rules) != len(rules) {
+		t.Fatalf("rules = %+v, want all %d", report.Rules, len(rules))
+	}
+	want := map[string]int64{"missing_brokers": 2, "missing_code": 1}
+	for i, r := range report.Rules {
+		if r.Name != rules[i].Name || r.Description == "" || r.Count != want[r.Name] {
+			t.Errorf("rule %d = %+v, want %s counting %d", i, r, rules[i].Name, want[r.Name])
+		}
+	}
+	wantGroups := []groupCompleteness{
+		{GroupKey: "G-A", Products: 3, Complete: 1, Completeness: 33.3},
+		{GroupKey: "G-B", Products: 1, Complete: 0, Completeness: 0},
+	}
+	if !reflect.DeepEqual(report.Groups, wantGroups) {
+		t.Errorf("groups = %+v, want %+v", report.Groups, wantGroups)
+	}
+}
+
+func TestGetDataQualityEmpty(t *testing.T) {
+	resp, body := do(t, newTestApp(testConfig(), &mocks.ProductRepository{}, qualityRoutes), fiber.MethodGet, "/admin/data-quality", "")
+	var report struct {
+		Rules  []qualityRuleCount  `json:"rules"`
+		Groups []groupCompleteness `json:"groups"`
+	}
+	decode(t, body, &report)
+	if resp.StatusCode != http.StatusOK || report.Groups == nil || len(report.Rules) != len(database.QualityRules()) {
+		t.Errorf("%d %s, want every rule at 0 and no groups", resp.StatusCode, body)
+	}
+}
+
+func TestGetDataQualityRule(t *testing.T) {
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{
+		"total": bson.A{bson.M{"n": int64(3)}},
+		"items": bson.A{bson.M{"groupKey": "G-A", "productId": "A-2", "productName": "Product A-2"}},
+	}}}
+	app := newTestApp(testConfig(), repo, qualityRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/admin/data-quality?rule=missing_brokers&page=2&limit=1", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var page struct {
+		Rule       string                `json:"rule"`
+		TotalCount int64                 `json:"totalCount"`
+		Data       []database.QualityRow `json:"data"`
+	}
+	decode(t, body, &page)
+	want := []database.QualityRow{{GroupKey: "G-A", ProductID: "A-2", ProductName: "Product A-2"}}
+	if page.Rule != "missing_brokers" || page.TotalCount != 3 || !reflect.DeepEqual(page.Data, want) {
+		t.Errorf("page = %s", body)
+	}
+	if resp.Header.Get(fiber.HeaderLink) == "" {
+		t.Error("no Link header")
+	}
+
+	// The page is cut after the rule's stages.
+	pipeline := callsOf(repo, "Aggregate")[0].Filter.(mongo.Pipeline)
+	facet := pipeline[len(pipeline)-1][0].Value.(bson.M)
+	items := facet["items"].(bson.A)
+	if items[0].(bson.M)["$skip"] != int64(1) || items[1].(bson.M)["$limit"] != 1 {
+		t.Errorf("items facet = %v, want to skip 1 and keep 1", items)
+	}
+
+	resp, body = do(t, app, fiber.MethodGet, "/admin/data-quality?rule=missing_pricing", "")
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "INVALID_RULE" {
+		t.Errorf("unknown rule: %d %s, want 400 INVALID_RULE", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+// listGroups answers ?flatten=false: the page of group documents matching
+// filter, exactly as stored. No projection or item-level filtering is
+// applied, so a group is returned with its whole productList even when only
+// one of its products matched.
+func (h *Handler) listGroups(c *fiber.Ctx, filter bson.M, paging Pagination) error {
+	if p := middleware.PrincipalFrom(c); p != nil && !middleware.HasPermission(p.Roles, middleware.PermAdmin) {
+		return apierror.Send(c, fiber.StatusForbidden, "FORBIDDEN", "flatten=false requires the admin role")
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "key", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(paging.Skip()).
+		SetLimit(int64(paging.Limit)).
+		SetMaxTime(h.maxTime(c))
+
+	groups := []bson.M{}
+	var total int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return database.Breaker.Do(ctx, func() error {
+			cursor, err := h.repo(c).Find(gctx, filter, opts)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(gctx)
+			return cursor.All(gctx, &groups)
+		})
+	})
+	g.Go(func() error {
+		return database.Breaker.Do(ctx, func() error {
+			var err error
+			total, err = h.repo(c).Count(gctx, filter)
+			return err
+		})
+	})
+	if err := g.Wait(); err != nil {
+		return queryError(c, "finding groups", err)
+	}
+
+	for _, group := range groups {
+		if oid, ok := group["_id"].(primitive.ObjectID); ok {
+			group["_id"] = oid.Hex()
+		}
+	}
+	setTotalHeaders(c, total, paging.Limit)
+	setPageLinks(c, paging, total, len(groups))
+	return c.JSON(fiber.Map{"totalCount": total, "data": groups})
+}
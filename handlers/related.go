@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultRelated = 6
+	maxRelated     = 50
+)
+
+// relatedProduct is a related product and what it shares with the one
+// asked about.
+type relatedProduct struct {
+	Product     `bson:",inline"`
+	SameInsurer bool `json:"sameInsurer" bson:"sameInsurer"`
+	SameType    bool `json:"sameType" bson:"sameType"`
+}
+
+// GetRelatedProducts returns active products from the same insurer or of
+// the same product type, best matches first.
+func (h *Handler) GetRelatedProducts(c *fiber.Ctx) error {
+	id := c.Params("id")
+	limit := defaultRelated
+	if raw := query(c, "limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRelated {
+			return apierror.Send(c, fiber.StatusBadRequest, "INVALID_LIMIT", fmt.Sprintf("limit must be an integer from 1 to %d", maxRelated))
+		}
+		limit = n
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	group, item, err := h.findProduct(c, ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist")
+	}
+	if err != nil {
+		return queryError(c, "finding product", err)
+	}
+	product := mapProduct(group, item)
+
+	related := []relatedProduct{}
+	if product.Insurer.InsurerCode == "" && product.ProductType.Key == "" {
+		return c.JSON(fiber.Map{"data": related})
+	}
+	pipeline := database.RelatedPipeline(product.Insurer.InsurerCode, product.ProductType.Key, id, string(StatusActive), limit)
+	err = database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.maxTime(c)))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &related)
+	})
+	if err != nil {
+		return queryError(c, "finding related products", err)
+	}
+	for i := range related {
+		related[i].Product = related[i].Product.withBrokers()
+	}
+	return c.JSON(fiber.Map{"data": related})
+}
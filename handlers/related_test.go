@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func relatedRoutes(app *fiber.App, h *Handler) {
+	app.Get("/products/:id/related", h.GetRelatedProducts)
+}
+
+func TestGetRelatedProducts(t *testing.T) {
+	groups := fixtureGroups(t)
+	repo := &mocks.ProductRepository{
+		// MT-001 of the fixtures' motor group: insurer MTI, type MOTOR.
+		FindOneDoc: groups[1],
+		AggregateDocs: []interface{}{
+			bson.M{"id": "TW-002", "productName": "Travel Asia", "insurer": bson.M{"insurerCode": "MTI"}, "sameInsurer": true, "sameType": false},
+		},
+	}
+	app := newTestApp(testConfig(), repo, relatedRoutes)
+
+	resp, body := do(t, app, fiber.MethodGet, "/products/MT-001/related", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	var got struct {
+		Data []relatedProduct `json:"data"`
+	}
+	decode(t, body, &got)
+	if len(got.Data) != 1 || got.Data[0].ID != "TW-002" || !got.Data[0].SameInsurer || got.Data[0].SameType || got.Data[0].Brokers == nil {
+		t.Errorf("data = %+v, want TW-002 sharing the insurer, with brokers []", got.Data)
+	}
+	aggregates := callsOf(repo, "Aggregate")
+	if len(aggregates) != 1 {
+		t.Fatalf("Aggregates = %+v, want one", aggregates)
+	}
+	if want := database.RelatedPipeline("MTI", "MOTOR", "MT-001", "ACTIVE", defaultRelated); !reflect.DeepEqual(aggregates[0].Filter, want) {
+		t.Errorf("pipeline = %v\nwant %v", aggregates[0].Filter, want)
+	}
+
+	do(t, app, fiber.MethodGet, "/products/MT-001/related?limit=2", "")
+	pipeline := callsOf(repo, "Aggregate")[1].Filter.(mongo.Pipeline)
+	if last := pipeline[len(pipeline)-2]; last[0].Key != "$limit" || last[0].Value != 2 {
+		t.Errorf("stage before the projection = %v, want $limit 2", last)
+	}
+
+	for _, limit := range []string{"0", "51", "six"} {
+		resp, body := do(t, app, fiber.MethodGet, "/products/MT-001/related?limit="+limit, "")
+		if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "INVALID_LIMIT" {
+			t.Errorf("limit=%s: %d %s, want 400 INVALID_LIMIT", limit, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestGetRelatedProductsNone(t *testing.T) {
+	// A product with neither insurer nor type relates to nothing and needs
+	// no aggregation.
+	repo := &mocks.ProductRepository{FindOneDoc: bson.M{"key": "LOOSE", "productList": bson.A{bson.M{"id": "LOOSE-1"}}}}
+	app := newTestApp(testConfig(), repo, relatedRoutes)
+	resp, body := do(t, app, fiber.MethodGet, "/products/LOOSE-1/related", "")
+	if resp.StatusCode != http.StatusOK || string(body) != `{"data":[]}` {
+		t.Errorf("%d %s, want an empty data array", resp.StatusCode, body)
+	}
+	if n := len(callsOf(repo, "Aggregate")); n != 0 {
+		t.Errorf("ran %d aggregations", n)
+	}
+
+	// The lookup found the group but not the product.
+	resp, body = do(t, app, fiber.MethodGet, "/products/LOOSE-2/related", "")
+	if resp.StatusCode != http.StatusNotFound || errorCode(body) != "PRODUCT_NOT_FOUND" {
+		t.Errorf("an unknown product: %d %s, want 404 PRODUCT_NOT_FOUND", resp.StatusCode, body)
+	}
+
+	// Relations that match nothing are an empty array too, not null.
+	repo = &mocks.ProductRepository{FindOneDoc: fixtureGroups(t)[0]}
+	app = newTestApp(testConfig(), repo, relatedRoutes)
+	resp, body = do(t, app, fiber.MethodGet, "/products/HP-001/related", "")
+	if resp.StatusCode != http.StatusOK || string(body) != `{"data":[]}` {
+		t.Errorf("no related products: %d %s, want an empty data array", resp.StatusCode, body)
+	}
+}
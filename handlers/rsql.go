@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// rsqlError is an invalid ?q=, at a character position counted from 0.
+type rsqlError struct {
+	Position int    `json:"position"`
+	Reason   string `json:"reason"`
+}
+
+func (e *rsqlError) Error() string {
+	return fmt.Sprintf("q is invalid at position %d: %s", e.Position, e.Reason)
+}
+
+// rsqlOperators maps the RSQL comparison operators to dslCondition's.
+var rsqlOperators = map[string]string{
+	"==":    "eq",
+	"!=":    "ne",
+	"=in=":  "in",
+	"=out=": "out",
+}
+
+// parseRSQL parses ?q=, an RSQL expression such as
+// status==ACTIVE;insurer.insurerCode=in=(AIA,BKI). ';' is and, ',' is or
+// and binds looser, parentheses group. Selectors are the fields of
+// ?filter=, by name or Product JSON path, and the operators ==, !=, =in=
+// and =out=. Values are bare or quoted with ' or ". It also returns the
+// fields named, to check against the simple filter parameters.
+func parseRSQL(raw string) (bson.M, itemMatcher, map[string]bool, error) {
+	p := &rsqlParser{in: []rune(raw), fields: map[string]bool{}}
+	filter, matcher, err := p.or(1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if p.pos < len(p.in) {
+		return nil, nil, nil, p.fail("unexpected %q", p.in[p.pos])
+	}
+	return filter, matcher, p.fields, nil
+}
+
+type rsqlParser struct {
+	in     []rune
+	pos    int
+	nodes  int
+	fields map[string]bool
+}
+
+func (p *rsqlParser) fail(format string, args ...interface{}) error {
+	return &rsqlError{Position: p.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *rsqlParser) peek(r rune) bool {
+	return p.pos < len(p.in) && p.in[p.pos] == r
+}
+
+func (p *rsqlParser) or(depth int) (bson.M, itemMatcher, error) {
+	return p.list(',', "$or", anyOf, depth, p.and)
+}
+
+func (p *rsqlParser) and(depth int) (bson.M, itemMatcher, error) {
+	return p.list(';', "$and", allOf, depth, p.constraint)
+}
+
+// list parses operands separated by sep and combines them with op.
+func (p *rsqlParser) list(sep rune, op string, combine func([]itemMatcher) itemMatcher, depth int, operand func(int) (bson.M, itemMatcher, error)) (bson.M, itemMatcher, error) {
+	filters := bson.A{}
+	var matchers []itemMatcher
+	for {
+		f, m, err := operand(depth)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, f)
+		matchers = append(matchers, m)
+		if !p.peek(sep) {
+			break
+		}
+		p.pos++
+	}
+	if len(filters) == 1 {
+		return filters[0].(bson.M), matchers[0], nil
+	}
+	return bson.M{op: filters}, combine(matchers), nil
+}
+
+func (p *rsqlParser) constraint(depth int) (bson.M, itemMatcher, error) {
+	p.nodes++
+	if p.nodes > maxFilterNodes {
+		return nil, nil, p.fail("more than %d comparisons", maxFilterNodes)
+	}
+	if p.peek('(') {
+		if depth >= maxFilterDepth {
+			return nil, nil, p.fail("nested deeper than %d levels", maxFilterDepth)
+		}
+		p.pos++
+		filter, matcher, err := p.or(depth + 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !p.peek(')') {
+			return nil, nil, p.fail("expected )")
+		}
+		p.pos++
+		return filter, matcher, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.in) && isSelectorRune(p.in[p.pos]) {
+		p.pos++
+	}
+	selector := string(p.in[start:p.pos])
+	if selector == "" {
+		return nil, nil, p.fail("expected a selector")
+	}
+	field, ok := filterField(selector)
+	if !ok {
+		p.pos = start
+		return nil, nil, p.fail("%s is not a filterable field; use one of %s", selector, strings.Join(dslFieldNames(), ", "))
+	}
+	p.fields[filterFieldName(selector)] = true
+
+	opStart := p.pos
+	op, ok := p.operator()
+	if !ok {
+		p.pos = opStart
+		return nil, nil, p.fail("expected ==, !=, =in= or =out=")
+	}
+
+	var values []string
+	if op == "in" || op == "out" {
+		if !p.peek('(') {
+			return nil, nil, p.fail("expected ( after =%s=", op)
+		}
+		p.pos++
+		for {
+			v, err := p.value(field)
+			if err != nil {
+				return nil, nil, err
+			}
+			values = append(values, v)
+			if len(values) > maxFilterValues {
+				return nil, nil, p.fail("more than %d values", maxFilterValues)
+			}
+			if !p.peek(',') {
+				break
+			}
+			p.pos++
+		}
+		if !p.peek(')') {
+			return nil, nil, p.fail("expected , or )")
+		}
+		p.pos++
+	} else {
+		v, err := p.value(field)
+		if err != nil {
+			return nil, nil, err
+		}
+		values = []string{v}
+	}
+	filter, matcher := dslCondition(op, field, values)
+	return filter, matcher, nil
+}
+
+// operator reads a comparison operator: == or != or =name=.
+func (p *rsqlParser) operator() (string, bool) {
+	rest := string(p.in[p.pos:])
+	for _, sym := range []string{"==", "!="} {
+		if strings.HasPrefix(rest, sym) {
+			p.pos += 2
+			return rsqlOperators[sym], true
+		}
+	}
+	if !p.peek('=') {
+		return "", false
+	}
+	end := strings.IndexRune(rest[1:], '=')
+	if end < 0 {
+		return "", false
+	}
+	sym := rest[:end+2]
+	op, ok := rsqlOperators[sym]
+	if ok {
+		p.pos += len([]rune(sym))
+	}
+	return op, ok
+}
+
+// value reads a bare or quoted value and checks it for field.
+func (p *rsqlParser) value(field dslField) (string, error) {
+	start := p.pos
+	var b strings.Builder
+	if p.peek('\'') || p.peek('"') {
+		quote := p.in[p.pos]
+		p.pos++
+		for {
+			if p.pos >= len(p.in) {
+				p.pos = start
+				return "", p.fail("unterminated quoted value")
+			}
+			r := p.in[p.pos]
+			p.pos++
+			if r == quote {
+				break
+			}
+			if r == '\\' && p.pos < len(p.in) {
+				r = p.in[p.pos]
+				p.pos++
+			}
+			b.WriteRune(r)
+		}
+	} else {
+		for p.pos < len(p.in) && !isReservedRune(p.in[p.pos]) {
+			b.WriteRune(p.in[p.pos])
+			p.pos++
+		}
+		if b.Len() == 0 {
+			return "", p.fail("expected a value")
+		}
+	}
+	v := strings.TrimSpace(database.Normalize(b.String()))
+	if field.canonical != nil {
+		c, ok := field.canonical(v)
+		if !ok {
+			p.pos = start
+			return "", p.fail("%s is not a valid value", v)
+		}
+		v = c
+	}
+	return v, nil
+}
+
+// filterFieldName is the dslFields name of a selector.
+func filterFieldName(selector string) string {
+	if alias, ok := filterSelectors[selector]; ok {
+		return alias
+	}
+	return selector
+}
+
+func isSelectorRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-'
+}
+
+func isReservedRune(r rune) bool {
+	return strings.ContainsRune(`"'()";,=!~<>`, r) || unicode.IsSpace(r)
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func FuzzParseRSQL(f *testing.F) {
+	for _, s := range append(fuzzSeeds(),
+		"status==ACTIVE;insurer.insurerCode=in=(AIA,BKI)",
+		`name=="ประกัน ภัย",code!=mt-001`,
+		`(type==MOTOR;(broker=out=('$where',"a\"b")))`,
+		"$where==1",
+		"status=in=(",
+		"name=~x",
+		"((((((type==A))))))",
+	) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		filter, matcher, fields, err := parseRSQL(raw)
+		if err != nil {
+			var rsqlErr *rsqlError
+			if !errors.As(err, &rsqlErr) {
+				t.Fatalf("parseRSQL(%q) failed with %T: %v", raw, err, err)
+			}
+			if rsqlErr.Position < 0 || rsqlErr.Position > len([]rune(raw)) {
+				t.Fatalf("parseRSQL(%q) failed at position %d, outside the input", raw, rsqlErr.Position)
+			}
+			return
+		}
+		checkFilter(t, filter)
+		for name := range fields {
+			if _, ok := dslFields[name]; !ok {
+				t.Errorf("parseRSQL(%q) names the field %q", raw, name)
+			}
+		}
+		matcher(Product{Brokers: []Broker{{Key: "B"}}})
+	})
+}
+
+func TestParseRSQL(t *testing.T) {
+	motor := bson.M{"productType.key": "MOTOR"}
+	health := bson.M{"productType.key": "HEALTH"}
+	active := bson.M{"productList.productStatus": "ACTIVE"}
+	tests := []struct {
+		name string
+		raw  string
+		want bson.M
+		// fields are the dslFields named; matches and misses are products
+		// the matcher must accept and reject.
+		fields          []string
+		matches, misses []Product
+	}{
+		{
+			name:    "comparison",
+			raw:     "status==active",
+			want:    active,
+			fields:  []string{"status"},
+			matches: []Product{{Status: StatusActive}},
+			misses:  []Product{{Status: StatusDraft}},
+		},
+		{
+			name:   "selector",
+			raw:    "productGroup.key==MOTOR-1",
+			want:   bson.M{"key": "MOTOR-1"},
+			fields: []string{"group"},
+		},
+		{
+			name:    "and",
+			raw:     "type==MOTOR;status==ACTIVE",
+			want:    bson.M{"$and": bson.A{motor, active}},
+			fields:  []string{"type", "status"},
+			matches: []Product{{ProductType: ProductType{Key: "MOTOR"}, Status: StatusActive}},
+			misses:  []Product{{ProductType: ProductType{Key: "MOTOR"}, Status: StatusDraft}},
+		},
+		{
+			name:   "and binds tighter than or",
+			raw:    "type==MOTOR,type==HEALTH;status==ACTIVE",
+			want:   bson.M{"$or": bson.A{motor, bson.M{"$and": bson.A{health, active}}}},
+			fields: []string{"type", "status"},
+			matches: []Product{
+				{ProductType: ProductType{Key: "MOTOR"}, Status: StatusDraft},
+				{ProductType: ProductType{Key: "HEALTH"}, Status: StatusActive},
+			},
+			misses: []Product{{ProductType: ProductType{Key: "HEALTH"}, Status: StatusDraft}},
+		},
+		{
+			name:    "parentheses group",
+			raw:     "(type==MOTOR,type==HEALTH);status==ACTIVE",
+			want:    bson.M{"$and": bson.A{bson.M{"$or": bson.A{motor, health}}, active}},
+			fields:  []string{"type", "status"},
+			matches: []Product{{ProductType: ProductType{Key: "HEALTH"}, Status: StatusActive}},
+			misses:  []Product{{ProductType: ProductType{Key: "MOTOR"}, Status: StatusDraft}},
+		},
+		{
+			name:    "double quotes",
+			raw:     `name=="ประกัน ภัย;1,2"`,
+			want:    bson.M{"productList.productName": "ประกัน ภัย;1,2"},
+			fields:  []string{"name"},
+			matches: []Product{{ProductName: "ประกัน ภัย;1,2"}},
+		},
+		{
+			name:   "single quotes and escapes",
+			raw:    `name=='it\'s "x"'`,
+			want:   bson.M{"productList.productName": `it's "x"`},
+			fields: []string{"name"},
+		},
+		{
+			name:   "quoted values are normalized and trimmed",
+			raw:    `name==" café "`,
+			want:   bson.M{"productList.productName": "café"},
+			fields: []string{"name"},
+		},
+		{
+			name:    "in",
+			raw:     "insurer.insurerCode=in=(AIA,'BKI')",
+			want:    bson.M{"productList.insurer.insurerCode": bson.M{"$in": []string{"AIA", "BKI"}}},
+			fields:  []string{"insurer"},
+			matches: []Product{{Insurer: Insurer{InsurerCode: "BKI"}}},
+			misses:  []Product{{Insurer: Insurer{InsurerCode: "VIR"}}},
+		},
+		{
+			name:    "in canonicalizes each value",
+			raw:     "status=in=(active,draft)",
+			want:    bson.M{"productList.productStatus": bson.M{"$in": []string{"ACTIVE", "DRAFT"}}},
+			fields:  []string{"status"},
+			matches: []Product{{Status: StatusDraft}},
+		},
+		{
+			name:    "out on a group field",
+			raw:     "type=out=(MOTOR,HEALTH)",
+			want:    bson.M{"productType.key": bson.M{"$nin": []string{"MOTOR", "HEALTH"}}},
+			fields:  []string{"type"},
+			matches: []Product{{ProductType: ProductType{Key: "TRAVEL"}}},
+			misses:  []Product{{ProductType: ProductType{Key: "HEALTH"}}},
+		},
+		{
+			name:    "ne on a group field",
+			raw:     "type!=MOTOR",
+			want:    bson.M{"productType.key": bson.M{"$ne": "MOTOR"}},
+			fields:  []string{"type"},
+			matches: []Product{{ProductType: ProductType{Key: "HEALTH"}}},
+			misses:  []Product{{ProductType: ProductType{Key: "MOTOR"}}},
+		},
+		{
+			name:    "out on brokers holds when no broker is a value",
+			raw:     "broker=out=(B1,B2)",
+			fields:  []string{"broker"},
+			matches: []Product{{}, {Brokers: []Broker{{Key: "B3"}}}},
+			misses:  []Product{{Brokers: []Broker{{Key: "B3"}, {Key: "B2"}}}},
+		},
+		{
+			name:    "ne on a product field",
+			raw:     "code!=mt-001",
+			fields:  []string{"code"},
+			matches: []Product{{Code: "MT-002"}},
+			misses:  []Product{{Code: "MT-001"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, matcher, fields, err := parseRSQL(tt.raw)
+			if err != nil {
+				t.Fatalf("parseRSQL(%s): %v", tt.raw, err)
+			}
+			checkFilter(t, filter)
+			if tt.want != nil && !reflect.DeepEqual(filter, tt.want) {
+				t.Errorf("parseRSQL(%s)\n got %#v\nwant %#v", tt.raw, filter, tt.want)
+			}
+			want := map[string]bool{}
+			for _, f := range tt.fields {
+				want[f] = true
+			}
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("parseRSQL(%s) names %v, want %v", tt.raw, fields, want)
+			}
+			for _, p := range tt.matches {
+				if !matcher(p) {
+					t.Errorf("%s does not match %+v", tt.raw, p)
+				}
+			}
+			for _, p := range tt.misses {
+				if matcher(p) {
+					t.Errorf("%s matches %+v", tt.raw, p)
+				}
+			}
+		})
+	}
+}
+
+// TestParseRSQLLiteralValues checks that values shaped like operators or
+// paths stay strings, and are a $literal where an expression holds them.
+func TestParseRSQLLiteralValues(t *testing.T) {
+	filter, _, _, err := parseRSQL(`name=='$where';group==$$ROOT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": bson.A{bson.M{"productList.productName": "$where"}, bson.M{"key": "$$ROOT"}}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("filter = %#v, want %#v", filter, want)
+	}
+	filter, _, _, err = parseRSQL(`name!='$productList.productName'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := bson.MarshalExtJSON(filter, false, false); !strings.Contains(string(b), `{"$literal":["$productList.productName"]}`) {
+		t.Errorf("ne filter %s does not hold its value as a $literal", b)
+	}
+}
+
+func TestParseRSQLErrors(t *testing.T) {
+	leaf := "type==A"
+	values := func(n int) string {
+		v := make([]string, n)
+		for i := range v {
+			v[i] = "V" + strconv.Itoa(i)
+		}
+		return "broker=in=(" + strings.Join(v, ",")
+	}
+	tests := []struct {
+		name     string
+		raw      string
+		position int
+		reason   string
+	}{
+		{"empty", "", 0, "expected a selector"},
+		{"no selector", "==ACTIVE", 0, "expected a selector"},
+		{"unknown field", "color==red", 0, "color is not a filterable field; use one of "},
+		{"operator injection", "$where==1", 0, "expected a selector"},
+		{"unknown field after others", "type==A;status==ACTIVE;color==red", 23, "color is not a filterable field"},
+		{"positions count characters", "name==ประกัน;x==1", 13, "x is not a filterable field"},
+		{"no operator", "status", 6, "expected ==, !=, =in= or =out="},
+		{"unknown operator", "status=like=ACTIVE", 6, "expected ==, !=, =in= or =out="},
+		{"regex operator", "name=~x", 4, "expected ==, !=, =in= or =out="},
+		{"no value", "status==", 8, "expected a value"},
+		{"reserved value", "status==(ACTIVE)", 8, "expected a value"},
+		{"invalid value", "status==PAUSED", 8, "PAUSED is not a valid value"},
+		{"invalid value in a list", "status=in=(ACTIVE,PAUSED)", 18, "PAUSED is not a valid value"},
+		{"unterminated quote", `name=="ประกัน`, 6, "unterminated quoted value"},
+		{"list without (", "status=in=ACTIVE", 10, "expected ( after =in="},
+		{"unclosed list", "status=out=(ACTIVE", 18, "expected , or )"},
+		{"empty list", "status=in=()", 11, "expected a value"},
+		{"dangling and", "type==A;", 8, "expected a selector"},
+		{"dangling or", "type==A,,type==B", 8, "expected a selector"},
+		{"unclosed group", "(type==A", 8, "expected )"},
+		{"unopened group", "type==A)", 7, `unexpected ')'`},
+		{"trailing space", "type==A ", 7, `unexpected ' '`},
+		{"as deep as allowed", strings.Repeat("(", maxFilterDepth-1) + leaf + strings.Repeat(")", maxFilterDepth-1), -1, ""},
+		{"too deep", strings.Repeat("(", maxFilterDepth) + leaf + strings.Repeat(")", maxFilterDepth), maxFilterDepth - 1, "nested deeper than 5 levels"},
+		{"as many comparisons as allowed", strings.TrimSuffix(strings.Repeat(leaf+",", maxFilterNodes), ","), -1, ""},
+		{"too many comparisons", strings.TrimSuffix(strings.Repeat(leaf+",", maxFilterNodes+1), ","), maxFilterNodes * (len(leaf) + 1), "more than 50 comparisons"},
+		{"as many values as allowed", values(maxFilterValues) + ")", -1, ""},
+		{"too many values", values(maxFilterValues+1) + ")", len(values(maxFilterValues + 1)), "more than 100 values"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, _, _, err := parseRSQL(tt.raw)
+			if tt.position < 0 {
+				if err != nil {
+					t.Fatalf("parseRSQL: %v", err)
+				}
+				checkFilter(t, filter)
+				return
+			}
+			var rsqlErr *rsqlError
+			if !errors.As(err, &rsqlErr) {
+				t.Fatalf("parseRSQL(%s) = %v, want an *rsqlError", tt.raw, err)
+			}
+			if rsqlErr.Position != tt.position || !strings.HasPrefix(rsqlErr.Reason, tt.reason) {
+				t.Errorf("parseRSQL(%s) failed at %d: %q, want %d: %q", tt.raw, rsqlErr.Position, rsqlErr.Reason, tt.position, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRSQLFilterConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		params ListParams
+		field  string
+	}{
+		{"with filter", ListParams{Q: "type==A", Filter: `{"eq":{"type":"A"}}`}, ""},
+		{"status", ListParams{Q: "status==ACTIVE", Status: "DRAFT"}, "status"},
+		{"code", ListParams{Q: "type==A;code!=MT-1", Code: "MT-*"}, "code"},
+		{"group by selector", ListParams{Q: "productGroup.key==G", Group: "H"}, "group"},
+		{"group nested", ListParams{Q: "type==A,(status==ACTIVE;group==G)", Group: "G"}, "group"},
+		{"missing insurer", ListParams{Q: "insurer.insurerCode=in=(AIA)", Missing: "insurer"}, "insurer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, _, err := rsqlFilter(tt.params)
+			var invalid *filterError
+			if !errors.As(err, &invalid) || invalid.code != "CONFLICTING_FILTERS" || filter != nil {
+				t.Fatalf("rsqlFilter(%+v) = %v, %v, want CONFLICTING_FILTERS", tt.params, filter, err)
+			}
+			if tt.field == "" {
+				if invalid.details != nil {
+					t.Errorf("details = %v, want none", invalid.details)
+				}
+				return
+			}
+			if !reflect.DeepEqual(invalid.details, fiber.Map{"field": tt.field}) {
+				t.Errorf("details = %v, want the field %s", invalid.details, tt.field)
+			}
+		})
+	}
+
+	// Parameters on fields q leaves alone do not conflict.
+	for _, params := range []ListParams{
+		{Q: "type==A", Status: "ACTIVE", Code: "MT-1", Group: "G", Missing: "insurer"},
+		{Q: "insurer==AIA", Status: "ACTIVE"},
+		{},
+	} {
+		if _, _, err := rsqlFilter(params); err != nil {
+			t.Errorf("rsqlFilter(%+v) = %v", params, err)
+		}
+	}
+
+	// Invalid expressions are reported with their position.
+	_, _, err := rsqlFilter(ListParams{Q: "status=="})
+	var invalid *filterError
+	if !errors.As(err, &invalid) || invalid.code != "INVALID_QUERY" || !reflect.DeepEqual(invalid.details, &rsqlError{Position: 8, Reason: "expected a value"}) {
+		t.Errorf("rsqlFilter(status==) = %v, want INVALID_QUERY at 8", err)
+	}
+}
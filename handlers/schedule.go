@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// schedulerActor is the actor recorded for changes the scheduler applies.
+const schedulerActor = "scheduler"
+
+// ScheduleInput is the body of the schedule create endpoint.
+type ScheduleInput struct {
+	Status ProductStatus `json:"status" validate:"required,productStatus"`
+	At     time.Time     `json:"at"`
+}
+
+// CreateSchedule schedules a status change of a product. The scheduler
+// applies it once At has passed.
+func (h *Handler) CreateSchedule(c *fiber.Ctx) error {
+	actor, ok, err := writer(c)
+	if !ok {
+		return err
+	}
+	var in ScheduleInput
+	if ok, err := parseBody(c, &in); !ok {
+		return err
+	}
+	now := h.now()
+	if !in.At.After(now) {
+		return apierror.Send(c, fiber.StatusUnprocessableEntity, "INVALID_SCHEDULE", "at must be in the future")
+	}
+
+	change := database.ScheduledStatusChange{
+		ID:        primitive.NewObjectID().Hex(),
+		Status:    string(in.Status),
+		At:        database.Timestamp(in.At),
+		CreatedBy: actor,
+		CreatedAt: database.Timestamp(now),
+	}
+	id := c.Params("id")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	var group bson.M
+	err = database.Breaker.Do(ctx, func() error {
+		return h.repo(c).FindOne(ctx, database.ItemFilter(id),
+			options.FindOne().SetProjection(bson.M{"productList.$": 1}).SetMaxTime(h.maxTime(c)),
+		).Decode(&group)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist")
+	}
+	if err != nil {
+		return queryError(c, "finding product", err)
+	}
+	// The scheduler checks again when the change is due.
+	if from := statusAt(findItem(group, id), in.At); !CanTransition(from, in.Status) {
+		return transitionRejected(c, from, in.Status)
+	}
+
+	err = h.updateSchedules(c, ctx, id, database.ItemFilter(id),
+		bson.M{"$push": bson.M{"productList.$.scheduledStatusChanges": change}},
+		func(changes bson.A) bson.A { return append(changes, change) })
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "PRODUCT_NOT_FOUND", "product "+id+" does not exist")
+	}
+	if err != nil {
+		return queryError(c, "scheduling status change", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(change)
+}
+
+// statusAt is the status a productList item will have at at: the status
+// of the last of its pending changes due before then, or its own.
+func statusAt(item bson.M, at time.Time) ProductStatus {
+	status := normalizeStatus(getStringField(item, "productStatus"))
+	var latest time.Time
+	changes, _ := item["scheduledStatusChanges"].(bson.A)
+	for _, raw := range changes {
+		change, ok := raw.(bson.M)
+		if !ok {
+			continue
+		}
+		when := timeField(change, "at")
+		if when == nil || !when.Before(at) || when.Before(latest) {
+			continue
+		}
+		latest, status = *when, normalizeStatus(getStringField(change, "status"))
+	}
+	return status
+}
+
+// DeleteSchedule cancels a pending status change.
+func (h *Handler) DeleteSchedule(c *fiber.Ctx) error {
+	if _, ok, err := writer(c); !ok {
+		return err
+	}
+	id, scheduleID := c.Params("id"), c.Params("scheduleId")
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	filter := bson.M{"productList": bson.M{"$elemMatch": bson.M{"$and": bson.A{
+		database.ItemMatch(id, ""),
+		bson.M{"scheduledStatusChanges.id": scheduleID},
+	}}}}
+	err := h.updateSchedules(c, ctx, id, filter,
+		bson.M{"$pull": bson.M{"productList.$.scheduledStatusChanges": bson.M{"id": scheduleID}}},
+		func(changes bson.A) bson.A {
+			kept := bson.A{}
+			for _, ch := range changes {
+				if m, ok := ch.(bson.M); !ok || m["id"] != scheduleID {
+					kept = append(kept, ch)
+				}
+			}
+			return kept
+		})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apierror.Send(c, fiber.StatusNotFound, "SCHEDULE_NOT_FOUND", "product "+id+" has no pending change "+scheduleID)
+	}
+	if err != nil {
+		return queryError(c, "cancelling status change", err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// updateSchedules applies update, which changes the scheduled status
+// changes of the product with the given id, to the group matching filter,
+// and audits it as an update of the product. change does to the entries
+// what update does. mongo.ErrNoDocuments means nothing matched filter.
+func (h *Handler) updateSchedules(c *fiber.Ctx, ctx context.Context, id string, filter, update bson.M, change func(bson.A) bson.A) error {
+	var group bson.M
+	err := h.audited(c, ctx, audit.ActionUpdate, func(ctx context.Context) (*auditedChange, error) {
+		// The pre-update document supplies the diff's old values.
+		if err := h.repo(c).FindOneAndUpdate(ctx, filter, update).Decode(&group); err != nil {
+			return nil, err
+		}
+		before, _ := findItem(group, id)["scheduledStatusChanges"].(bson.A)
+		return &auditedChange{
+			productID: id,
+			groupKey:  getStringField(group, "key"),
+			before:    bson.M{"scheduledStatusChanges": before},
+			after:     bson.M{"scheduledStatusChanges": change(append(bson.A{}, before...))},
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+	h.invalidateCache(c)
+	h.syncFlat(c, group)
+	return nil
+}
+
+// GetSchedules lists the pending status changes, soonest first, a page at
+// a time. ?before= (RFC3339) keeps those due by then.
+func (h *Handler) GetSchedules(c *fiber.Ctx) error {
+	var before time.Time
+	if v := query(c, "before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "INVALID_PARAMETER", "before must be an RFC3339 timestamp")
+		}
+		before = t
+	}
+	paging, ok, err := parsePagination(c, h.cfg.Pagination)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	pipeline := append(database.PendingStatusChangesPipeline(before),
+		bson.D{{Key: "$skip", Value: paging.Skip()}},
+		bson.D{{Key: "$limit", Value: paging.Limit}},
+	)
+	pending := []database.PendingStatusChange{}
+	if err := h.aggregateAll(c, ctx, pipeline, &pending); err != nil {
+		return queryError(c, "listing scheduled status changes", err)
+	}
+	setPageLinks(c, paging, -1, len(pending))
+	return c.JSON(fiber.Map{"data": pending})
+}
+
+// RunScheduler applies due status changes of every tenant each interval
+// until ctx is cancelled. Every replica may run it: ClaimStatusChange lets
+// only one of them apply any given change.
+func (h *Handler) RunScheduler(ctx context.Context, tenants []*database.Tenant, interval time.Duration) {
+	for {
+		for _, t := range tenants {
+			if err := h.applyDueChanges(ctx, t); err != nil && ctx.Err() == nil {
+				h.logger.Error("applying scheduled status changes", "tenant", t.Name, "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (h *Handler) applyDueChanges(ctx context.Context, t *database.Tenant) error {
+	now := h.now()
+	cursor, err := t.Products.Aggregate(ctx, database.PendingStatusChangesPipeline(now))
+	if err != nil {
+		return err
+	}
+	var due []database.PendingStatusChange
+	if err := cursor.All(ctx, &due); err != nil {
+		return err
+	}
+
+	synced := map[interface{}]bool{}
+	for _, change := range due {
+		err := h.applyStatusChange(ctx, t, change, now)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Another replica claimed it, or it was cancelled.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		synced[change.GroupID] = true
+	}
+	if len(synced) == 0 {
+		return nil
+	}
+	h.invalidateTenant(ctx, t.Name)
+	if h.cfg.Mongo.FlatSync {
+		for groupID := range synced {
+			if err := database.SyncFlatGroup(ctx, t, groupID); err != nil {
+				h.logger.Error("syncing products_flat", "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyStatusChange claims a due change and audits it. A change the
+// transition table no longer allows, the product's status having changed
+// since it was scheduled, is dropped instead and audited as rejected.
+// mongo.ErrNoDocuments means the change was gone.
+func (h *Handler) applyStatusChange(ctx context.Context, t *database.Tenant, change database.PendingStatusChange, now time.Time) error {
+	to := normalizeStatus(change.Status)
+	var blocked []string
+	for _, s := range statusesBlocking(to) {
+		blocked = append(blocked, string(s))
+	}
+	var before database.GroupDocument
+	err := database.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := database.ClaimStatusChange(ctx, t.Products, change, blocked, schedulerActor, now).Decode(&before); err != nil {
+			return err
+		}
+		var old string
+		if item, ok := before.Item(change.ProductID); ok {
+			old = item.Status.String()
+		}
+		return audit.Record(ctx, t.Audit, audit.Entry{
+			Actor:     schedulerActor,
+			Action:    audit.ActionScheduledStatus,
+			ProductID: change.ProductID,
+			GroupKey:  change.GroupKey,
+			Changes:   []audit.Change{{Field: "productStatus", Old: old, New: change.Status}},
+			Timestamp: database.Timestamp(now),
+		})
+	})
+	if err == nil {
+		h.logger.Info("applied scheduled status change", "tenant", t.Name, "product", change.ProductID, "status", change.Status, "schedule", change.ID)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	var group bson.M
+	err = database.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := database.RejectStatusChange(ctx, t.Products, change).Decode(&group); err != nil {
+			return err
+		}
+		item := findItem(group, change.ProductID)
+		pending, _ := item["scheduledStatusChanges"].(bson.A)
+		kept := bson.A{}
+		for _, ch := range pending {
+			if m, ok := ch.(bson.M); !ok || m["id"] != change.ID {
+				kept = append(kept, ch)
+			}
+		}
+		return audit.Record(ctx, t.Audit, audit.Entry{
+			Actor:     schedulerActor,
+			Action:    audit.ActionScheduledStatusRejected,
+			ProductID: change.ProductID,
+			GroupKey:  change.GroupKey,
+			Changes:   audit.Diff(bson.M{"scheduledStatusChanges": pending}, bson.M{"scheduledStatusChanges": kept}),
+			Timestamp: database.Timestamp(now),
+		})
+	})
+	if err == nil {
+		h.logger.Warn("rejected scheduled status change", "tenant", t.Name, "product", change.ProductID,
+			"from", getStringField(findItem(group, change.ProductID), "productStatus"), "status", change.Status, "schedule", change.ID)
+	}
+	return err
+}
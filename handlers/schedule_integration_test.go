@@ -0,0 +1,214 @@
+//go:build integration
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/audit"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// schedulerTenant connects to the database named by MONGO_TEST_URI, as
+// the server would, and returns its tenant over a fresh database holding
+// groups, dropped when the test ends.
+func schedulerTenant(t *testing.T, groups ...interface{}) (*database.Tenant, config.Config) {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	name := fmt.Sprintf("scheduler_test_%d", time.Now().UnixNano())
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	t.Setenv("MONGO_URI", uri)
+	t.Setenv("MONGO_DATABASE", name)
+	t.Setenv("TENANTS", "it="+name)
+	t.Setenv("TENANT_DEFAULT", "it")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.ConnectDB(cfg.Mongo); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tenant := database.DefaultTenant()
+	t.Cleanup(func() {
+		tenant.Products.Database().Drop(ctx)
+		database.Disconnect(ctx)
+	})
+	if _, err := tenant.Products.InsertMany(ctx, groups); err != nil {
+		t.Fatal(err)
+	}
+	return tenant, cfg
+}
+
+func schedulerHandler(cfg config.Config, now time.Time) *Handler {
+	return New(cfg, Deps{
+		Repos:  database.Repository,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:  func() time.Time { return now },
+	})
+}
+
+// scheduledGroups has three due changes, one of which the transition
+// table forbids by now, and one change that is not due yet.
+func scheduledGroups() []interface{} {
+	due, later := testNow.Add(-time.Minute), testNow.Add(time.Hour)
+	return []interface{}{
+		bson.M{"key": "HEALTH-PLUS", "productList": bson.A{
+			bson.M{"id": "HP-001", "productName": "Health Plus", "productStatus": "DRAFT", "scheduledStatusChanges": bson.A{
+				bson.M{"id": "s1", "status": "ACTIVE", "at": due},
+				bson.M{"id": "s2", "status": "RETIRED", "at": later},
+			}},
+			bson.M{"id": "HP-002", "productName": "Health Plus Family", "productStatus": "ACTIVE", "scheduledStatusChanges": bson.A{
+				bson.M{"id": "s3", "status": "INACTIVE", "at": due},
+			}},
+		}},
+		bson.M{"key": "MOTOR-1", "productList": bson.A{
+			// Scheduled while DRAFT, then retired by hand.
+			bson.M{"id": "MT-001", "productName": "Motor", "productStatus": "RETIRED", "scheduledStatusChanges": bson.A{
+				bson.M{"id": "s4", "status": "ACTIVE", "at": due},
+			}},
+		}},
+	}
+}
+
+func statuses(t *testing.T, tenant *database.Tenant) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	cursor, err := tenant.Products.Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []database.GroupDocument
+	if err := cursor.All(ctx, &groups); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]string{}
+	for _, g := range groups {
+		for _, item := range g.ProductList {
+			out[item.ProductID()] = item.Status.String()
+		}
+	}
+	return out
+}
+
+func auditActions(t *testing.T, tenant *database.Tenant) map[string][]string {
+	t.Helper()
+	ctx := context.Background()
+	cursor, err := tenant.Audit.Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []audit.Entry
+	if err := cursor.All(ctx, &entries); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string][]string{}
+	for _, e := range entries {
+		if e.Actor != schedulerActor {
+			t.Errorf("entry %+v is not the scheduler's", e)
+		}
+		out[e.ProductID] = append(out[e.ProductID], e.Action)
+	}
+	return out
+}
+
+// TestApplyDueChanges runs the worker's pass from several replicas at once
+// and checks that each due change was applied, or rejected, exactly once.
+func TestApplyDueChanges(t *testing.T) {
+	tenant, cfg := schedulerTenant(t, scheduledGroups()...)
+	const replicas = 8
+	var wg sync.WaitGroup
+	for i := 0; i < replicas; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := schedulerHandler(cfg, testNow).applyDueChanges(context.Background(), tenant); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := map[string]string{"HP-001": "ACTIVE", "HP-002": "INACTIVE", "MT-001": "RETIRED"}
+	if got := statuses(t, tenant); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	wantAudit := map[string][]string{
+		"HP-001": {audit.ActionScheduledStatus},
+		"HP-002": {audit.ActionScheduledStatus},
+		"MT-001": {audit.ActionScheduledStatusRejected},
+	}
+	if got := auditActions(t, tenant); fmt.Sprint(got) != fmt.Sprint(wantAudit) {
+		t.Errorf("audit = %v, want one entry per change: %v", got, wantAudit)
+	}
+
+	ctx := context.Background()
+	cursor, err := tenant.Products.Aggregate(ctx, database.PendingStatusChangesPipeline(time.Time{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []database.PendingStatusChange
+	if err := cursor.All(ctx, &left); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].ID != "s2" {
+		t.Errorf("pending = %+v, want only the change not due yet", left)
+	}
+}
+
+// TestRunScheduler runs the worker loop until the change not yet due at
+// the start has been applied too, then stops it.
+func TestRunScheduler(t *testing.T) {
+	tenant, cfg := schedulerTenant(t, scheduledGroups()...)
+	h := schedulerHandler(cfg, testNow)
+	var mu sync.Mutex
+	now := testNow
+	h.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.RunScheduler(ctx, []*database.Tenant{tenant}, 10*time.Millisecond)
+	}()
+	waitFor := func(id, status string) {
+		t.Helper()
+		for start := time.Now(); statuses(t, tenant)[id] != status; time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("%s never became %s", id, status)
+			}
+		}
+	}
+	waitFor("HP-001", "ACTIVE")
+	mu.Lock()
+	now = testNow.Add(2 * time.Hour)
+	mu.Unlock()
+	waitFor("HP-001", "RETIRED")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunScheduler did not return after its context was cancelled")
+	}
+	if got := auditActions(t, tenant)["HP-001"]; len(got) != 2 {
+		t.Errorf("HP-001 audit = %v, want its two changes", got)
+	}
+}
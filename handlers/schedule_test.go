@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStatusAt(t *testing.T) {
+	at := func(h int) time.Time { return testNow.Add(time.Duration(h) * time.Hour) }
+	item := bson.M{
+		"productStatus": "draft",
+		"scheduledStatusChanges": bson.A{
+			bson.M{"id": "s2", "status": "INACTIVE", "at": at(3)},
+			bson.M{"id": "s1", "status": "ACTIVE", "at": at(1)},
+			"not a change",
+			bson.M{"id": "s3", "status": "RETIRED"},
+		},
+	}
+	tests := []struct {
+		at   time.Time
+		want ProductStatus
+	}{
+		{at(0), StatusDraft},
+		{at(1), StatusDraft},
+		{at(2), StatusActive},
+		{at(4), StatusInactive},
+	}
+	for _, tt := range tests {
+		if got := statusAt(item, tt.at); got != tt.want {
+			t.Errorf("statusAt(%s) = %s, want %s", tt.at.Format(time.Kitchen), got, tt.want)
+		}
+	}
+}
+
+// TestCreateScheduleTransition schedules changes the transition table
+// forbids, from the product's status or from the one an earlier pending
+// change gives it.
+func TestCreateScheduleTransition(t *testing.T) {
+	at := func(h int) string { return testNow.Add(time.Duration(h) * time.Hour).Format(time.RFC3339) }
+	group := healthGroup()
+	// HP-002 is DRAFT and goes ACTIVE in two hours.
+	group["productList"].(bson.A)[0].(bson.M)["scheduledStatusChanges"] = bson.A{
+		bson.M{"id": "s1", "status": "ACTIVE", "at": testNow.Add(2 * time.Hour)},
+	}
+	tests := []struct {
+		name   string
+		repo   *mocks.ProductRepository
+		id     string
+		body   string
+		status int
+		code   string
+	}{
+		{"from the status", &mocks.ProductRepository{FindOneDoc: group}, "HP-002", `{"status": "INACTIVE", "at": "` + at(1) + `"}`, http.StatusConflict, "INVALID_STATUS_TRANSITION"},
+		{"from a pending change", &mocks.ProductRepository{FindOneDoc: group}, "HP-002", `{"status": "DRAFT", "at": "` + at(3) + `"}`, http.StatusConflict, "INVALID_STATUS_TRANSITION"},
+		{"from an active product", &mocks.ProductRepository{FindOneDoc: group}, "HP-001", `{"status": "DRAFT", "at": "` + at(1) + `"}`, http.StatusConflict, "INVALID_STATUS_TRANSITION"},
+		{"unknown product", &mocks.ProductRepository{}, "HP-404", `{"status": "ACTIVE", "at": "` + at(1) + `"}`, http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+		{"in the past", &mocks.ProductRepository{FindOneDoc: group}, "HP-002", `{"status": "ACTIVE", "at": "` + at(-1) + `"}`, http.StatusUnprocessableEntity, "INVALID_SCHEDULE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(testConfig(), tt.repo, func(app *fiber.App, h *Handler) {
+				app.Post("/products/:id/schedules", h.CreateSchedule)
+			})
+			resp, body := do(t, app, fiber.MethodPost, "/products/"+tt.id+"/schedules", tt.body)
+			if resp.StatusCode != tt.status || errorCode(body) != tt.code {
+				t.Fatalf("%d %s, want %d %s", resp.StatusCode, body, tt.status, tt.code)
+			}
+			if n := len(callsOf(tt.repo, "FindOneAndUpdate")); n != 0 {
+				t.Errorf("%d updates of a rejected schedule", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// termCache holds each tenant's spelling candidates: its product and
+// insurer names and their words, reloaded once they expire.
+type termCache struct {
+	mu      sync.Mutex
+	entries map[string]termEntry
+}
+
+type termEntry struct {
+	terms   []string
+	expires time.Time
+}
+
+func (tc *termCache) get(tenant string, now time.Time) ([]string, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	e, ok := tc.entries[tenant]
+	if !ok || now.After(e.expires) {
+		return nil, false
+	}
+	return e.terms, true
+}
+
+func (tc *termCache) set(tenant string, terms []string, expires time.Time) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.entries == nil {
+		tc.entries = map[string]termEntry{}
+	}
+	tc.entries[tenant] = termEntry{terms: terms, expires: expires}
+}
+
+// searchSuggestions returns "did you mean" candidates for a search term
+// that found nothing. They are only a hint: a failure to load the
+// candidates is logged and yields none. Exclusions in term are not
+// spelled out.
+func (h *Handler) searchSuggestions(c *fiber.Ctx, ctx context.Context, term string) []string {
+	term, _ = searchTerms(term)
+	if h.cfg.Search.SuggestionsRefresh <= 0 || term == "" {
+		return nil
+	}
+	terms, err := h.spellingTerms(c, ctx)
+	if err != nil {
+		h.logger.Warn("loading spelling candidates", "error", err)
+		return nil
+	}
+	return spellingSuggestions(term, terms)
+}
+
+func (h *Handler) spellingTerms(c *fiber.Ctx, ctx context.Context) ([]string, error) {
+	name := tenant(c).Name
+	if terms, ok := h.terms.get(name, h.now()); ok {
+		return terms, nil
+	}
+	v, err, _ := h.flight.Do("spelling|"+name, func() (interface{}, error) {
+		var out []struct {
+			Names []string `bson:"names"`
+		}
+		err := database.Breaker.Do(ctx, func() error {
+			cursor, err := h.repo(c).Aggregate(ctx, database.SpellingTermsPipeline(),
+				options.Aggregate().SetAllowDiskUse(h.cfg.Mongo.AnalyticsAllowDiskUse).SetMaxTime(h.maxTime(c)))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			return cursor.All(ctx, &out)
+		})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		if len(out) > 0 {
+			names = out[0].Names
+		}
+		terms := spellingCandidates(names)
+		h.terms.set(name, terms, h.now().Add(h.cfg.Search.SuggestionsRefresh))
+		return terms, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]string), nil
+}
+
+// spellingCandidates is names plus the words of the multi-word ones, each
+// once. Thai is written without spaces, so Thai names stay whole.
+func spellingCandidates(names []string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	add := func(s string) {
+		if key := database.NameKey(s); key != "" && !seen[key] {
+			seen[key] = true
+			terms = append(terms, strings.Join(strings.Fields(s), " "))
+		}
+	}
+	for _, name := range names {
+		add(name)
+		if words := strings.Fields(name); len(words) > 1 {
+			for _, w := range words {
+				if utf8.RuneCountInString(w) >= 3 {
+					add(w)
+				}
+			}
+		}
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// spellingThreshold is the most edits a suggestion may be away from a term
+// of n characters: none for very short terms, where any edit is a
+// different word, and more the longer the term.
+func spellingThreshold(n int) int {
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	case n <= 9:
+		return 2
+	}
+	return 3
+}
+
+// spellingSuggestions returns the candidates closest to term, best first,
+// within the threshold for its length. Comparison is on NameKey forms, so
+// case and spacing do not count as edits.
+func spellingSuggestions(term string, candidates []string) []string {
+	target := database.NameKey(term)
+	limit := spellingThreshold(utf8.RuneCountInString(target))
+	if limit == 0 {
+		return nil
+	}
+	type candidate struct {
+		value    string
+		distance int
+	}
+	var matches []candidate
+	for _, k := range candidates {
+		key := database.NameKey(k)
+		// A length gap alone can rule a candidate out cheaply.
+		if gap := utf8.RuneCountInString(key) - utf8.RuneCountInString(target); gap > limit || -gap > limit {
+			continue
+		}
+		if d := editDistance(target, key); d > 0 && d <= limit {
+			matches = append(matches, candidate{k, d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].value < matches[j].value
+	})
+	var out []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		out = append(out, matches[i].value)
+	}
+	return out
+}
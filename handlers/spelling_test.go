@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSpellingThreshold(t *testing.T) {
+	want := []int{0, 0, 0, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3}
+	for n, limit := range want {
+		if got := spellingThreshold(n); got != limit {
+			t.Errorf("spellingThreshold(%d) = %d, want %d", n, got, limit)
+		}
+	}
+	if got := spellingThreshold(200); got != 3 {
+		t.Errorf("spellingThreshold(200) = %d, want 3", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"health", "helth", 1},
+		{"flaw", "lawn", 2},
+		// Runes, not bytes: each Thai character is three bytes.
+		{"ประกัน", "ประกน", 1},
+		{"ประกัน", "ประกับ", 1},
+		{"รถยนต์", "รถยนต", 1},
+		{"ประกัน", "insure", 6},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSpellingCandidates(t *testing.T) {
+	got := spellingCandidates([]string{
+		"Health Plus Family",
+		"health  plus family",
+		"ประกันสุขภาพ เหมาจ่าย",
+		"ทิพยประกันภัย",
+		"AXA Insurance",
+		"Go to Asia",
+		"",
+	})
+	want := []string{
+		"AXA",
+		"AXA Insurance",
+		"Asia",
+		"Family",
+		"Go to Asia",
+		"Health",
+		"Health Plus Family",
+		"Insurance",
+		"Plus",
+		"ทิพยประกันภัย",
+		"ประกันสุขภาพ",
+		"ประกันสุขภาพ เหมาจ่าย",
+		"เหมาจ่าย",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spellingCandidates\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestSpellingSuggestions(t *testing.T) {
+	candidates := spellingCandidates([]string{
+		"Health Plus Family",
+		"Wealth Plan",
+		"Travel Asia",
+		"Café Europe Schengen",
+		"ประกันสุขภาพ เหมาจ่าย",
+		"ประกันภัยรถยนต์",
+		"ทิพยประกันภัย",
+		"AXA Insurance",
+	})
+	tests := []struct {
+		name, term string
+		want       []string
+	}{
+		{"one edit", "Helth", []string{"Health"}},
+		{"ties by name", "Xealth", []string{"Health", "Wealth"}},
+		{"case is not an edit", "HEALTH PLUS FAMLY", []string{"Health Plus Family"}},
+		{"an exact match is not suggested", "health", []string{"Wealth"}},
+		{"too short to correct", "AX", nil},
+		{"beyond the threshold", "Hxxxth", nil},
+		{"longer terms allow more edits", "Travl Asya", []string{"Travel Asia"}},
+		{"accents", "Cafe", []string{"Café"}},
+		{"Thai missing vowel", "ประกนสุขภาพ", []string{"ประกันสุขภาพ"}},
+		{"Thai wrong consonant", "ประกันสุขภาบ", []string{"ประกันสุขภาพ"}},
+		{"Thai missing tone mark", "ประกันภัยรถยนต", []string{"ประกันภัยรถยนต์"}},
+		{"Thai too short", "รถ", nil},
+		{"Thai and English are far apart", "Insurance ภัย", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spellingSuggestions(tt.term, candidates); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spellingSuggestions(%q) = %q, want %q", tt.term, got, tt.want)
+			}
+		})
+	}
+
+	many := []string{"abcdf", "abcdg", "abcdh", "abcdi", "abcxe"}
+	if got := spellingSuggestions("abcde", many); !reflect.DeepEqual(got, []string{"abcdf", "abcdg", "abcdh"}) {
+		t.Errorf("spellingSuggestions(abcde) = %q, want the first three in order", got)
+	}
+}
+
+func TestTermCache(t *testing.T) {
+	var tc termCache
+	if _, ok := tc.get("th", testNow); ok {
+		t.Fatal("an empty cache had terms")
+	}
+	tc.set("th", []string{"ประกัน"}, testNow.Add(time.Minute))
+	if terms, ok := tc.get("th", testNow.Add(time.Minute)); !ok || !reflect.DeepEqual(terms, []string{"ประกัน"}) {
+		t.Errorf("get before expiry = %q, %t", terms, ok)
+	}
+	if _, ok := tc.get("th", testNow.Add(time.Minute+time.Nanosecond)); ok {
+		t.Error("expired terms were served")
+	}
+	if _, ok := tc.get("sg", testNow); ok {
+		t.Error("another tenant's terms were served")
+	}
+}
+
+func TestGetProductsSuggestions(t *testing.T) {
+	cfg := testConfig()
+	cfg.Search.SuggestionsRefresh = time.Minute
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{
+		bson.M{"names": bson.A{"Health Plus Family", "ประกันสุขภาพ เหมาจ่าย", "AXA Insurance"}},
+	}}
+	app := newTestApp(cfg, repo, listingRoutes)
+	suggestions := func(target string) []string {
+		t.Helper()
+		resp, body := do(t, app, fiber.MethodGet, target, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, resp.StatusCode, body)
+		}
+		var page productPage
+		decode(t, body, &page)
+		return page.Suggestions
+	}
+
+	if got := suggestions("/products?param=Helth"); !reflect.DeepEqual(got, []string{"Health"}) {
+		t.Errorf("suggestions for Helth = %q", got)
+	}
+	if got := suggestions("/products?param=" + url.QueryEscape("ประกนสุขภาพ")); !reflect.DeepEqual(got, []string{"ประกันสุขภาพ"}) {
+		t.Errorf("suggestions for ประกนสุขภาพ = %q", got)
+	}
+	// The exclusion is not spelled out.
+	if got := suggestions("/products?param=Helth%20-Insurnce"); !reflect.DeepEqual(got, []string{"Health"}) {
+		t.Errorf("suggestions for Helth -Insurnce = %q", got)
+	}
+	if n := len(callsOf(repo, "Aggregate")); n != 1 {
+		t.Errorf("loaded the candidates %d times, want once until they expire", n)
+	}
+
+	// Products found and searches without a term get none.
+	repo.FindDocs = []interface{}{healthGroup()}
+	if got := suggestions("/products?param=Health"); got != nil {
+		t.Errorf("suggestions with results = %q", got)
+	}
+	repo.FindDocs = nil
+	if got := suggestions("/products?status=RETIRED"); got != nil {
+		t.Errorf("suggestions without a term = %q", got)
+	}
+
+}
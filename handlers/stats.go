@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// statsDimensions are the fields products can be grouped by, as paths
+// within an unwound group document. Brokers are an array and are unwound
+// again, so a product counts once per broker.
+var statsDimensions = map[string]string{
+	"status":      "$productList.productStatus",
+	"insurer":     "$productList.insurer.insurerCode",
+	"productType": "$productType.key",
+	"group":       "$key",
+	"broker":      "$productList.brokers.key",
+}
+
+type statsBucket struct {
+	Value database.LooseString `json:"value" bson:"_id"`
+	Count int64                `json:"count" bson:"count"`
+}
+
+// GetProductStats counts the products matching param and status grouped by
+// ?groupBy=.
+func (h *Handler) GetProductStats(c *fiber.Ctx) error {
+	dimension := query(c, "groupBy")
+	path, ok := statsDimensions[dimension]
+	if !ok {
+		names := make([]string, 0, len(statsDimensions))
+		for name := range statsDimensions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_GROUP_BY", "groupBy must be one of "+strings.Join(names, ", "))
+	}
+	params, ok, err := h.listParams(c)
+	if !ok {
+		return err
+	}
+	filter, err := buildProductFilter(params)
+	if err != nil {
+		return filterFailed(c, err)
+	}
+
+	ctx, cancel := h.queryContext(c)
+	defer cancel()
+
+	if len(filter) == 0 && h.cfg.Mongo.AnalyticsMaxUnfiltered > 0 {
+		var n int64
+		err := database.Breaker.Do(ctx, func() error {
+			var err error
+			n, err = h.repo(c).EstimatedCount(ctx)
+			return err
+		})
+		if err != nil {
+			return queryError(c, "counting products", err)
+		}
+		if n > int64(h.cfg.Mongo.AnalyticsMaxUnfiltered) {
+			return apierror.Send(c, fiber.StatusUnprocessableEntity, "UNBOUNDED_AGGREGATION",
+				fmt.Sprintf("the catalogue has %d product groups; narrow the statistics with param or status", n))
+		}
+	}
+
+	unwind := ""
+	if dimension == "broker" {
+		unwind = "$productList.brokers"
+	}
+	pipeline := database.StatsPipeline(filter, path, unwind)
+	opts := options.Aggregate().
+		SetAllowDiskUse(h.cfg.Mongo.AnalyticsAllowDiskUse).
+		SetMaxTime(h.maxTime(c))
+
+	buckets := []statsBucket{}
+	err = database.Breaker.Do(ctx, func() error {
+		cursor, err := h.repo(c).Aggregate(ctx, pipeline, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &buckets)
+	})
+	if err != nil {
+		return queryError(c, "aggregating product stats", err)
+	}
+	return c.JSON(fiber.Map{"groupBy": dimension, "data": buckets})
+}
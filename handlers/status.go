@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// ProductStatus is the lifecycle state of a product. Values are upper case;
+// decoding and encoding normalize the case, so "active" reads as ACTIVE.
+type ProductStatus string
+
+const (
+	StatusActive   ProductStatus = "ACTIVE"
+	StatusInactive ProductStatus = "INACTIVE"
+	StatusDraft    ProductStatus = "DRAFT"
+	StatusRetired  ProductStatus = "RETIRED"
+)
+
+// ProductStatuses are all the values productStatus may hold. Filters and
+// write validation both derive from this list; a new status is added here
+// and nowhere else.
+var ProductStatuses = []ProductStatus{StatusActive, StatusInactive, StatusDraft, StatusRetired}
+
+// statusTransitions are the statuses each status may change to. Keeping a
+// status is always allowed and RETIRED is final; a status added to
+// ProductStatuses needs an entry here.
+var statusTransitions = map[ProductStatus][]ProductStatus{
+	StatusDraft:    {StatusActive, StatusRetired},
+	StatusActive:   {StatusInactive, StatusRetired},
+	StatusInactive: {StatusActive, StatusRetired},
+	StatusRetired:  {},
+}
+
+// CanTransition reports whether a product may change from one status to
+// another. A stored value that is not a status may change to anything, so
+// that bad data can be repaired.
+func CanTransition(from, to ProductStatus) bool {
+	if from == to || !from.IsValid() {
+		return true
+	}
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// statusesBlocking are the statuses that may not change to s, for a write
+// filter that only matches products allowed to take s.
+func statusesBlocking(s ProductStatus) []ProductStatus {
+	blocking := []ProductStatus{}
+	for _, from := range ProductStatuses {
+		if !CanTransition(from, s) {
+			blocking = append(blocking, from)
+		}
+	}
+	return blocking
+}
+
+// allowsStatus matches the groups whose product id may change to s.
+func allowsStatus(id string, s ProductStatus) bson.M {
+	return bson.M{"productList": bson.M{"$elemMatch": bson.M{"$and": bson.A{
+		database.ItemMatch(id, ""),
+		bson.M{"productStatus": bson.M{"$nin": statusesBlocking(s)}},
+	}}}}
+}
+
+// transitionRejected writes the 409 of a status change the table forbids.
+func transitionRejected(c *fiber.Ctx, from, to ProductStatus) error {
+	return apierror.SendDetails(c, fiber.StatusConflict, "INVALID_STATUS_TRANSITION",
+		fmt.Sprintf("a %s product cannot become %s", from, to),
+		fiber.Map{"from": from, "to": to, "allowed": allowedTransitions(from)})
+}
+
+// allowedTransitions lists the statuses from may change to, itself first.
+func allowedTransitions(from ProductStatus) []ProductStatus {
+	return append([]ProductStatus{from}, statusTransitions[from]...)
+}
+
+func init() {
+	validation.RegisterRule("productStatus", func(v reflect.Value) (string, bool) {
+		return "must be one of " + statusList(", "), v.Kind() == reflect.String && ProductStatus(v.String()).IsValid()
+	})
+}
+
+// ParseProductStatus reads a status in any case, reporting whether it is
+// one of ProductStatuses.
+func ParseProductStatus(s string) (ProductStatus, bool) {
+	status := normalizeStatus(s)
+	return status, status.IsValid()
+}
+
+func normalizeStatus(s string) ProductStatus {
+	return ProductStatus(strings.ToUpper(strings.TrimSpace(s)))
+}
+
+func (s ProductStatus) IsValid() bool {
+	for _, status := range ProductStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ProductStatus) String() string { return string(s) }
+
+// UnmarshalJSON keeps values that are not statuses, normalized, so that
+// validation can report them.
+func (s *ProductStatus) UnmarshalJSON(b []byte) error {
+	var raw string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*s = normalizeStatus(raw)
+	return nil
+}
+
+func (s ProductStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(normalizeStatus(string(s))))
+}
+
+// UnmarshalBSONValue is lenient like database.LooseString: anything that
+// is not a string decodes as the empty status.
+func (s *ProductStatus) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	*s = ""
+	if t == bsontype.String {
+		if raw, ok := (bson.RawValue{Type: t, Value: data}).StringValueOK(); ok {
+			*s = normalizeStatus(raw)
+		}
+	}
+	return nil
+}
+
+func (s ProductStatus) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(string(normalizeStatus(string(s))))
+}
+
+// statusList joins ProductStatuses with sep.
+func statusList(sep string) string {
+	names := make([]string, len(ProductStatuses))
+	for i, s := range ProductStatuses {
+		names[i] = string(s)
+	}
+	return strings.Join(names, sep)
+}
+
+// parseStatuses reads the comma-separated status filter, case-insensitively.
+// It returns the canonical values, or ok false when one is not a status.
+func parseStatuses(raw string) (statuses []ProductStatus, ok bool) {
+	if raw == "" {
+		return nil, true
+	}
+	for _, s := range strings.Split(raw, ",") {
+		status, ok := ParseProductStatus(s)
+		if !ok {
+			return nil, false
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, true
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestProductStatuses enumerates the statuses and checks that everything
+// deriving from the list agrees with it.
+func TestProductStatuses(t *testing.T) {
+	seen := map[ProductStatus]bool{}
+	for _, s := range ProductStatuses {
+		if seen[s] {
+			t.Errorf("%s is listed twice", s)
+		}
+		seen[s] = true
+		if !s.IsValid() || string(s) != strings.ToUpper(string(s)) {
+			t.Errorf("%q is not a valid upper-case status", s)
+		}
+		for _, raw := range []string{string(s), strings.ToLower(string(s)), " " + string(s[:1]) + strings.ToLower(string(s[1:])) + " "} {
+			if got, ok := ParseProductStatus(raw); !ok || got != s {
+				t.Errorf("ParseProductStatus(%q) = %q, %t, want %s", raw, got, ok, s)
+			}
+		}
+		if err := validation.Struct(ProductInput{
+			ProductName:  "Motor",
+			ProductGroup: ProductGroupInput{Key: "MTR"},
+			Insurer:      InsurerInput{InsurerCode: "AIA"},
+			Status:       s,
+		}); err != nil {
+			t.Errorf("a product with status %s failed validation: %v", s, err)
+		}
+	}
+	for _, s := range []ProductStatus{StatusActive, StatusInactive, StatusDraft, StatusRetired} {
+		if !seen[s] {
+			t.Errorf("%s is not in ProductStatuses", s)
+		}
+	}
+
+	names := statusNames()
+	if got := strings.Join(names, ", "); got != statusList(", ") {
+		t.Errorf("statusNames() = %q, statusList = %q", got, statusList(", "))
+	}
+	statuses, ok := parseStatuses(strings.ToLower(statusList(",")))
+	if !ok || !reflect.DeepEqual(statuses, ProductStatuses) {
+		t.Errorf("parseStatuses(every status) = %v, %t", statuses, ok)
+	}
+
+	// The messages clients see name every status.
+	_, err := buildProductFilter(ListParams{Status: "PAUSED"})
+	var invalid *filterError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("an unknown status filter = %v", err)
+	}
+	err = validation.Struct(ProductInput{Status: "PAUSED"})
+	for _, s := range ProductStatuses {
+		if !strings.Contains(invalid.message, string(s)) {
+			t.Errorf("filter message %q does not list %s", invalid.message, s)
+		}
+		if err == nil || !strings.Contains(err.Error(), string(s)) {
+			t.Errorf("validation error %v does not list %s", err, s)
+		}
+	}
+}
+
+func TestParseProductStatusInvalid(t *testing.T) {
+	for _, raw := range []string{"", "PAUSED", "ACTIVE,DRAFT", "ACTIVEX", "ACTİVE"} {
+		if s, ok := ParseProductStatus(raw); ok {
+			t.Errorf("ParseProductStatus(%q) = %s", raw, s)
+		}
+	}
+	if _, ok := parseStatuses("ACTIVE,,DRAFT"); ok {
+		t.Error("parseStatuses accepted an empty entry")
+	}
+}
+
+func TestProductStatusJSON(t *testing.T) {
+	var in struct {
+		Status ProductStatus `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(`{"status":" draft "}`), &in); err != nil || in.Status != StatusDraft {
+		t.Errorf("decoding \" draft \" = %q, %v", in.Status, err)
+	}
+	// Values that are not statuses are kept for validation to report.
+	if err := json.Unmarshal([]byte(`{"status":"paused"}`), &in); err != nil || in.Status != "PAUSED" {
+		t.Errorf("decoding paused = %q, %v", in.Status, err)
+	}
+	if err := json.Unmarshal([]byte(`{"status":1}`), &in); err == nil {
+		t.Error("a number decoded as a status")
+	}
+	out, err := json.Marshal(struct {
+		Status ProductStatus `json:"status"`
+	}{"retired"})
+	if err != nil || string(out) != `{"status":"RETIRED"}` {
+		t.Errorf("encoding retired = %s, %v", out, err)
+	}
+}
+
+func TestProductStatusBSON(t *testing.T) {
+	type doc struct {
+		Status ProductStatus `bson:"productStatus"`
+	}
+	raw, err := bson.Marshal(doc{"inactive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored bson.M
+	if err := bson.Unmarshal(raw, &stored); err != nil || stored["productStatus"] != "INACTIVE" {
+		t.Errorf("stored %v, %v, want INACTIVE", stored, err)
+	}
+
+	tests := []struct {
+		stored interface{}
+		want   ProductStatus
+	}{
+		{"active", StatusActive},
+		{"Retired", StatusRetired},
+		{"UNKNOWN", "UNKNOWN"},
+		{int32(1), ""},
+		{nil, ""},
+		{bson.M{"code": "ACTIVE"}, ""},
+	}
+	for _, tt := range tests {
+		raw, err := bson.Marshal(bson.M{"productStatus": tt.stored})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got doc
+		if err := bson.Unmarshal(raw, &got); err != nil || got.Status != tt.want {
+			t.Errorf("decoding %#v = %q, %v, want %q", tt.stored, got.Status, err, tt.want)
+		}
+	}
+}
+
+// TestStatusTransitions keeps the table in step with ProductStatuses and
+// pins the lifecycle it allows.
+func TestStatusTransitions(t *testing.T) {
+	for _, s := range ProductStatuses {
+		targets, ok := statusTransitions[s]
+		if !ok {
+			t.Errorf("%s has no entry in statusTransitions", s)
+		}
+		for _, to := range targets {
+			if !to.IsValid() || to == s {
+				t.Errorf("%s may change to %q", s, to)
+			}
+		}
+		if !CanTransition(s, s) {
+			t.Errorf("%s may not be kept", s)
+		}
+	}
+	if len(statusTransitions) != len(ProductStatuses) {
+		t.Errorf("statusTransitions has %d entries for %d statuses", len(statusTransitions), len(ProductStatuses))
+	}
+
+	allowed := map[[2]ProductStatus]bool{
+		{StatusDraft, StatusActive}:     true,
+		{StatusDraft, StatusRetired}:    true,
+		{StatusActive, StatusInactive}:  true,
+		{StatusActive, StatusRetired}:   true,
+		{StatusInactive, StatusActive}:  true,
+		{StatusInactive, StatusRetired}: true,
+		{StatusActive, StatusDraft}:     false,
+		{StatusDraft, StatusInactive}:   false,
+		{StatusInactive, StatusDraft}:   false,
+		{StatusRetired, StatusActive}:   false,
+		{StatusRetired, StatusDraft}:    false,
+		{StatusRetired, StatusInactive}: false,
+		{"PAUSED", StatusRetired}:       true,
+		{"", StatusDraft}:               true,
+	}
+	for change, want := range allowed {
+		if got := CanTransition(change[0], change[1]); got != want {
+			t.Errorf("CanTransition(%q, %s) = %t, want %t", change[0], change[1], got, want)
+		}
+	}
+
+	// The write guard blocks exactly what the table forbids.
+	for _, to := range ProductStatuses {
+		blocking := statusesBlocking(to)
+		for _, from := range ProductStatuses {
+			blocked := false
+			for _, b := range blocking {
+				blocked = blocked || b == from
+			}
+			if blocked == CanTransition(from, to) {
+				t.Errorf("statusesBlocking(%s) blocks %s: %t", to, from, blocked)
+			}
+		}
+	}
+}
+
+// storedProduct is a repository holding healthGroup whose updates match
+// nothing, as when their guard fails.
+type storedProduct struct {
+	*mocks.ProductRepository
+}
+
+func (r storedProduct) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	r.ProductRepository.FindOne(ctx, filter, opts...)
+	for _, id := range []string{"HP-001", "HP-002"} {
+		if reflect.DeepEqual(filter, database.ItemFilter(id)) {
+			return mongo.NewSingleResultFromDocument(healthGroup(), nil, nil)
+		}
+	}
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
+func TestUpdateProductStatusTransition(t *testing.T) {
+	update := func(id, group string, status ProductStatus) string {
+		return `{"productName": "Health Plus Family", "productGroup": {"key": "` + group + `"},
+			"insurer": {"insurerCode": "AXA"}, "status": "` + string(status) + `"}`
+	}
+	tests := []struct {
+		name   string
+		id     string
+		group  string
+		status ProductStatus
+		want   int
+		code   string
+	}{
+		// HP-001 is ACTIVE and HP-002 DRAFT.
+		{"active to draft", "HP-001", "HEALTH-PLUS", StatusDraft, http.StatusConflict, "INVALID_STATUS_TRANSITION"},
+		{"draft to inactive", "HP-002", "HEALTH-PLUS", StatusInactive, http.StatusConflict, "INVALID_STATUS_TRANSITION"},
+		{"allowed, failed otherwise", "HP-001", "HEALTH-PLUS", StatusRetired, http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+		{"other group", "HP-001", "MOTOR-1", StatusDraft, http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+		{"unknown product", "HP-404", "HEALTH-PLUS", StatusDraft, http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mocks.ProductRepository{}
+			app := newTestApp(testConfig(), storedProduct{repo}, func(app *fiber.App, h *Handler) {
+				app.Put("/products/:id", h.UpdateProduct)
+			})
+			resp, body := do(t, app, fiber.MethodPut, "/products/"+tt.id, update(tt.id, tt.group, tt.status))
+			if resp.StatusCode != tt.want || errorCode(body) != tt.code {
+				t.Fatalf("%d %s, want %d %s", resp.StatusCode, body, tt.want, tt.code)
+			}
+			if tt.code == "INVALID_STATUS_TRANSITION" {
+				var env struct {
+					Error struct {
+						Details struct {
+							From    ProductStatus   `json:"from"`
+							To      ProductStatus   `json:"to"`
+							Allowed []ProductStatus `json:"allowed"`
+						} `json:"details"`
+					} `json:"error"`
+				}
+				if err := json.Unmarshal(body, &env); err != nil {
+					t.Fatal(err)
+				}
+				d := env.Error.Details
+				if d.To != tt.status || len(d.Allowed) == 0 || d.Allowed[0] != d.From {
+					t.Errorf("details = %+v", d)
+				}
+			}
+
+			// The guard is in the write's own filter.
+			sets := callsOf(repo, "SetProduct")
+			if len(sets) != 1 {
+				t.Fatalf("%d updates, want 1", len(sets))
+			}
+			guard := allowsStatus(tt.id, tt.status)
+			if !strings.Contains(fmt.Sprint(sets[0].Filter), fmt.Sprint(guard)) {
+				t.Errorf("update filter %v lacks the status guard %v", sets[0].Filter, guard)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
+)
+
+// streams reports whether a page of limit products is streamed. Only the
+// paths that read Products straight off the cursor can stream; the legacy
+// Find path flattens whole groups in memory anyway.
+func (h *Handler) streams(limit int) bool {
+	min := h.cfg.Pagination.StreamMinLimit
+	return min > 0 && limit >= min &&
+		(h.cfg.Mongo.ListSource == "flat" || h.cfg.Mongo.ListAggregation)
+}
+
+// streamProducts writes the listing envelope while iterating the cursor, so
+// memory stays bounded by the cursor batch rather than the page size.
+//
+// The status line is sent before the first product, so a cursor failure
+// midway cannot become an error status. The response is then truncated:
+// the data array is closed and an "error" member takes the place of
+// "totalCount", which clients must check for. Strict requests that hit a
+// malformed entry end the same way.
+//
+// The listing headers go out before the page is read, so unless the count
+// is exact the Link header assumes the page is full and gives a next page.
+func (h *Handler) streamProducts(c *fiber.Ctx, filter bson.M, opts *options.FindOptions, paging Pagination) error {
+	// The body is written after this handler returns, when c and its user
+	// context are no longer valid.
+	ctx, cancel := h.boundedContext(c, context.Background())
+
+	var (
+		cursor    *mongo.Cursor
+		count     totalCount
+		updatedAt time.Time
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return database.Breaker.Do(ctx, func() error {
+			var err error
+			if h.cfg.Mongo.ListSource == "flat" {
+				cursor, err = tenant(c).FlatList.Find(ctx, database.FlatFilter(filter), flatFindOptions(opts))
+			} else {
+				cursor, err = h.repo(c).Aggregate(ctx, productPipeline(filter, opts.Sort, *opts.Skip, *opts.Limit), aggregateOptions(opts))
+			}
+			return err
+		})
+	})
+	g.Go(func() error {
+		var err error
+		count, err = h.countProducts(c, gctx, filter)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		updatedAt, err = h.latestUpdate(c, gctx, filter)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		if cursor != nil {
+			cursor.Close(ctx)
+		}
+		cancel()
+		return queryError(c, "finding products", err)
+	}
+
+	setListingHeaders(c, paging, count, updatedAt, paging.Limit)
+	if c.Fresh() {
+		cursor.Close(ctx)
+		cancel()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	tenantName, strictMode := tenant(c).Name, strict(c)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer cursor.Close(ctx)
+		if err := writeStream(ctx, cursor, w, count, tenantName, strictMode); err != nil {
+			h.logger.Error("streaming products", "error", err)
+		}
+	})
+	return nil
+}
+
+func writeStream(ctx context.Context, cursor *mongo.Cursor, w *bufio.Writer, count totalCount, tenant string, strict bool) error {
+	var warnings []Warning
+	w.WriteString(`{"data":[`)
+	err := func() error {
+		n := 0
+		for cursor.Next(ctx) {
+			var row productRow
+			if err := cursor.Decode(&row); err != nil {
+				return err
+			}
+			if row.Malformed {
+				skippedItems.Inc(tenant)
+				warnings = append(warnings, row.warning())
+				if strict {
+					return &malformedError{warnings: warnings}
+				}
+				continue
+			}
+			b, err := json.Marshal(row.Product.withBrokers())
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				w.WriteByte(',')
+			}
+			n++
+			w.Write(b)
+			if cursor.RemainingBatchLength() == 0 {
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return cursor.Err()
+	}()
+	w.WriteString(`],`)
+	if len(warnings) > 0 {
+		b, _ := json.Marshal(warnings)
+		w.WriteString(`"warnings":`)
+		w.Write(b)
+		w.WriteByte(',')
+	}
+	var malformed *malformedError
+	switch {
+	case errors.As(err, &malformed):
+		trailer, _ := json.Marshal(fiber.Map{"code": "MALFORMED_DATA", "message": "some products could not be mapped; retry without strict to get the rest"})
+		w.WriteString(`"error":`)
+		w.Write(trailer)
+	case err != nil:
+		trailer, _ := json.Marshal(fiber.Map{"code": "INCOMPLETE_RESPONSE", "message": "the listing was truncated by a database error"})
+		w.WriteString(`"error":`)
+		w.Write(trailer)
+	default:
+		w.WriteString(`"totalCount":` + strconv.FormatInt(count.N, 10) + `,"totalCountExact":` + strconv.FormatBool(count.Exact))
+	}
+	w.WriteString(`}`)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
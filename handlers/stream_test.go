@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/mocks"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// streamedPage is a streamed listing body.
+type streamedPage struct {
+	Data       []Product  `json:"data"`
+	Warnings   []Warning  `json:"warnings"`
+	TotalCount *int64     `json:"totalCount"`
+	Error      *fiber.Map `json:"error"`
+}
+
+// TestStreamProducts streams pages whose cursor fails or meets malformed
+// entries after the first product was written: the status is already 200,
+// so the body must still be valid JSON that ends in an error member instead
+// of the totalCount of a complete page.
+func TestStreamProducts(t *testing.T) {
+	product := func(id string) bson.M {
+		return bson.M{"id": id, "productName": "Health " + id, "productGroup": bson.M{"key": "HEALTH-PLUS"}}
+	}
+	malformed := bson.M{"malformed": true, "itemIndex": 2, "productGroup": bson.M{"key": "HEALTH-PLUS"}}
+	// An id the cursor cannot decode into a Product fails it midway, as a
+	// lost connection would.
+	undecodable := bson.M{"id": bson.A{"HP-002"}}
+
+	tests := []struct {
+		name      string
+		target    string
+		docs      []interface{}
+		wantIDs   []string
+		wantCode  string
+		wantWarns int
+	}{
+		{"complete", "/products?limit=5", []interface{}{product("HP-001"), product("HP-002")}, []string{"HP-001", "HP-002"}, "", 0},
+		{"database error", "/products?limit=5", []interface{}{product("HP-001"), undecodable, product("HP-003")}, []string{"HP-001"}, "INCOMPLETE_RESPONSE", 0},
+		{"malformed entry", "/products?limit=5", []interface{}{product("HP-001"), malformed, product("HP-003")}, []string{"HP-001", "HP-003"}, "", 1},
+		{"malformed entry strict", "/products?limit=5&strict=true", []interface{}{product("HP-001"), malformed, product("HP-003")}, []string{"HP-001"}, "MALFORMED_DATA", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Mongo.ListAggregation = true
+			cfg.Pagination.StreamMinLimit = 5
+			repo := &mocks.ProductRepository{AggregateDocs: tt.docs}
+			app := newTestApp(cfg, repo, listingRoutes)
+
+			resp, body := do(t, app, fiber.MethodGet, tt.target, "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, body)
+			}
+			var page streamedPage
+			if err := json.Unmarshal(body, &page); err != nil {
+				t.Fatalf("body is not JSON: %v: %s", err, body)
+			}
+			var ids []string
+			for _, p := range page.Data {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("streamed %v, want %v", ids, tt.wantIDs)
+			}
+			if len(page.Warnings) != tt.wantWarns {
+				t.Errorf("warnings = %+v, want %d", page.Warnings, tt.wantWarns)
+			}
+			if tt.wantCode == "" {
+				if page.Error != nil || page.TotalCount == nil {
+					t.Errorf("body = %s, want a totalCount and no error", body)
+				}
+				return
+			}
+			if page.Error == nil || (*page.Error)["code"] != tt.wantCode {
+				t.Errorf("error = %v, want %s", page.Error, tt.wantCode)
+			}
+			if page.TotalCount != nil {
+				t.Errorf("truncated body has totalCount %d", *page.TotalCount)
+			}
+		})
+	}
+}
+
+// TestStreamProductsThreshold checks that only pages of at least
+// StreamMinLimit products are streamed: a smaller page that fails midway
+// is still answered with an error status.
+func TestStreamProductsThreshold(t *testing.T) {
+	cfg := testConfig()
+	cfg.Mongo.ListAggregation = true
+	cfg.Pagination.StreamMinLimit = 5
+	repo := &mocks.ProductRepository{AggregateDocs: []interface{}{bson.M{"id": bson.A{"HP-001"}}}}
+	app := newTestApp(cfg, repo, listingRoutes)
+
+	if resp, body := do(t, app, fiber.MethodGet, "/products?limit=4", ""); resp.StatusCode == http.StatusOK {
+		t.Errorf("unstreamed page = %d: %s, want an error status", resp.StatusCode, body)
+	}
+	if resp, body := do(t, app, fiber.MethodGet, "/products?limit=5", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("streamed page = %d: %s, want 200 and a trailer", resp.StatusCode, body)
+	}
+}
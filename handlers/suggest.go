@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxSuggestions bounds the candidates suggest returns.
+const maxSuggestions = 3
+
+// suggest returns the known values closest to value, best first: those
+// equal to it once case and punctuation are ignored, then those within two
+// edits of it. "LINE BK", "LINE-BK" and "LineBK" all suggest LINE-BK.
+func suggest(value string, known []string) []string {
+	type candidate struct {
+		value    string
+		distance int
+	}
+	target := squash(value)
+	var candidates []candidate
+	for _, k := range known {
+		if d := editDistance(target, squash(k)); d <= 2 {
+			candidates = append(candidates, candidate{k, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].value < candidates[j].value
+	})
+	out := []string{}
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		out = append(out, candidates[i].value)
+	}
+	return out
+}
+
+// squash upper-cases s and drops everything but letters and digits.
+func squash(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
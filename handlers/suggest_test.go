@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	known := []string{"LINE-BK", "LINE-BKK", "BROKER-ONLINE", "BROKER-AGENT", "BROKER-BRANCH"}
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"LINE BK", []string{"LINE-BK", "LINE-BKK"}},
+		{"LineBK", []string{"LINE-BK", "LINE-BKK"}},
+		{"line_bkk", []string{"LINE-BKK", "LINE-BK"}},
+		{"BROKER-ONLNE", []string{"BROKER-ONLINE"}},
+		{"BROKER-AGNT", []string{"BROKER-AGENT"}},
+		{"BROKER-BRANC", []string{"BROKER-BRANCH"}},
+		// Beyond two edits of every key.
+		{"TELESALES", []string{}},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.value, known); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("suggest(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	many := []string{"AB1", "AB2", "AB3", "AB4"}
+	if got := suggest("AB", many); !reflect.DeepEqual(got, many[:maxSuggestions]) {
+		t.Errorf("suggest(AB) = %q, want the first %d", got, maxSuggestions)
+	}
+}
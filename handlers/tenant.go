@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+const tenantKey = "tenant"
+
+var tenantRequests = metrics.NewCounter("tenant_requests")
+
+// ResolveTenant selects the catalogue named by the X-Tenant header, falling
+// back to the default tenant when the header is absent. Every handler that
+// touches product data must run behind it.
+func (h *Handler) ResolveTenant(c *fiber.Ctx) error {
+	name := strings.TrimSpace(c.Get("X-Tenant"))
+	var t *database.Tenant
+	if name == "" {
+		t = database.DefaultTenant()
+	} else {
+		t, _ = database.LookupTenant(name)
+	}
+	if t == nil {
+		return apierror.SendDetails(c, fiber.StatusBadRequest, "INVALID_TENANT",
+			"X-Tenant must name one of the configured tenants", fiber.Map{"tenants": database.TenantNames()})
+	}
+	c.Locals(tenantKey, t)
+	middleware.SetTenantName(c, t.Name)
+	tenantRequests.Inc(t.Name)
+	return c.Next()
+}
+
+// tenant returns the tenant selected by ResolveTenant.
+func tenant(c *fiber.Ctx) *database.Tenant {
+	return c.Locals(tenantKey).(*database.Tenant)
+}
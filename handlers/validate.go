@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/validation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxValidateItems bounds a ValidateProducts request.
+const maxValidateItems = 1000
+
+type itemErrors struct {
+	Index  int               `json:"index"`
+	Errors validation.Errors `json:"errors"`
+}
+
+// ValidateProducts checks a JSON array of product bodies against the write
+// validation without writing anything, reporting the problems of each
+// invalid item by its index.
+func (h *Handler) ValidateProducts(c *fiber.Ctx) error {
+	var inputs []ProductInput
+	if err := validation.DecodeJSON(c.Body(), &inputs); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "INVALID_BODY", err.Error())
+	}
+	if len(inputs) > maxValidateItems {
+		return apierror.Send(c, fiber.StatusBadRequest, "TOO_MANY_ITEMS",
+			fmt.Sprintf("at most %d products can be validated at once", maxValidateItems))
+	}
+	invalid := []itemErrors{}
+	for i := range inputs {
+		if verrs := inputs[i].validate(); verrs != nil {
+			invalid = append(invalid, itemErrors{Index: i, Errors: verrs})
+		}
+	}
+	return c.JSON(fiber.Map{
+		"valid":        len(invalid) == 0,
+		"totalCount":   len(inputs),
+		"invalidCount": len(invalid),
+		"errors":       invalid,
+	})
+}
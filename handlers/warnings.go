@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	skippedItems   = metrics.NewCounter("products_skipped_items")
+	mappedProducts = metrics.NewCounter("products_mapped")
+)
+
+// Warning describes a productList entry left out of a response because it
+// could not be mapped to a Product.
+type Warning struct {
+	GroupKey string `json:"groupKey"`
+	Index    int    `json:"index"`
+	Reason   string `json:"reason"`
+}
+
+const reasonNotDocument = "productList entry is not a document"
+
+// malformedError fails a strict request that would have skipped items.
+type malformedError struct {
+	warnings []Warning
+}
+
+func (e *malformedError) Error() string {
+	return fmt.Sprintf("%d productList entries could not be mapped", len(e.warnings))
+}
+
+// strict reports whether the caller asked for ?strict=true: skipped items
+// fail the request instead of producing warnings.
+func strict(c *fiber.Ctx) bool {
+	return c.QueryBool("strict")
+}
+
+// productRow is one document of the listing pipeline.
+type productRow struct {
+	Product   `bson:",inline"`
+	Malformed bool `bson:"malformed"`
+	ItemIndex int  `bson:"itemIndex"`
+}
+
+// warning returns the warning for a malformed row.
+func (r productRow) warning() Warning {
+	return Warning{GroupKey: r.ProductGroup.Key, Index: r.ItemIndex, Reason: reasonNotDocument}
+}
+
+// splitRows separates the mapped products from the skipped entries.
+func splitRows(tenant string, rows []productRow) ([]Product, []Warning) {
+	products := make([]Product, 0, len(rows))
+	var warnings []Warning
+	for _, r := range rows {
+		if r.Malformed {
+			skippedItems.Inc(tenant)
+			warnings = append(warnings, r.warning())
+			continue
+		}
+		products = append(products, r.Product.withBrokers())
+	}
+	return products, warnings
+}
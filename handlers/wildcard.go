@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxWildcards caps the wildcards of one pattern. Every * is a point the
+// regex engine may backtrack to, so a pattern of many can take long to
+// fail on a long value.
+const maxWildcards = 8
+
+// hasWildcards reports whether s is a pattern rather than a literal.
+func hasWildcards(s string) bool {
+	return strings.ContainsAny(s, "*?")
+}
+
+// wildcardCount is the number of wildcards in s, a run of * counting as
+// one.
+func wildcardCount(s string) int {
+	n := strings.Count(s, "?")
+	for _, run := range strings.FieldsFunc(s, func(r rune) bool { return r != '*' }) {
+		if run != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// wildcardBody translates the pattern s into an unanchored regex: * matches
+// any run of characters, ? any single one, and everything else is escaped
+// to stand for itself.
+func wildcardBody(s string) string {
+	var b strings.Builder
+	for s != "" {
+		i := strings.IndexAny(s, "*?")
+		if i < 0 {
+			b.WriteString(SanitizeString(s))
+			break
+		}
+		b.WriteString(SanitizeString(s[:i]))
+		if s[i] == '?' {
+			b.WriteByte('.')
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(".*")
+		s = strings.TrimLeft(s[i:], "*")
+	}
+	return b.String()
+}
+
+// wildcardRegex is the regex for the pattern s, anchored at both ends so
+// MTR-* matches keys starting with MTR- and nothing else.
+func wildcardRegex(s string) string {
+	return "^" + wildcardBody(s) + "$"
+}
+
+// checkWildcards rejects a pattern in the given parameter with more than
+// maxWildcards wildcards.
+func checkWildcards(param, value string) error {
+	if n := wildcardCount(value); n > maxWildcards {
+		return &filterError{
+			code:    "TOO_MANY_WILDCARDS",
+			message: fmt.Sprintf("%s may contain at most %d wildcards, got %d", param, maxWildcards, n),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWildcardRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		regex   string
+		match   []string
+		noMatch []string
+	}{
+		{
+			pattern: "MTR-*-2024",
+			regex:   `^MTR-.*-2024$`,
+			match:   []string{"MTR--2024", "MTR-AIA-2024", "MTR-A-B-2024"},
+			noMatch: []string{"XMTR-AIA-2024", "MTR-AIA-2024X", "MTR-2024"},
+		},
+		{
+			pattern: "MTR-*",
+			regex:   `^MTR-.*$`,
+			match:   []string{"MTR-", "MTR-001"},
+			noMatch: []string{"OLD-MTR-001", "MTR"},
+		},
+		{
+			pattern: "MT-??1",
+			regex:   `^MT-..1$`,
+			match:   []string{"MT-001", "MT-กข1"},
+			noMatch: []string{"MT-01", "MT-0001"},
+		},
+		{
+			pattern: "a.b*",
+			regex:   `^a\.b.*$`,
+			match:   []string{"a.b", "a.bcd"},
+			noMatch: []string{"axb", "aab"},
+		},
+		{
+			pattern: "(x)?|[y]+",
+			regex:   `^\(x\).\|\[y\]\+$`,
+			match:   []string{"(x)!|[y]+"},
+			noMatch: []string{"x", "y", "(x)|[y]+"},
+		},
+		{
+			pattern: `^$\{2}*`,
+			regex:   `^\^\$\\\{2\}.*$`,
+			match:   []string{`^$\{2}`, `^$\{2}tail`},
+			noMatch: []string{"", "{2}", `\\`},
+		},
+		{
+			pattern: "a***b",
+			regex:   `^a.*b$`,
+			match:   []string{"ab", "a***b", "axyzb"},
+			noMatch: []string{"a", "b", "ba"},
+		},
+		{
+			pattern: "ประกัน*",
+			regex:   `^ประกัน.*$`,
+			match:   []string{"ประกัน", "ประกันภัยรถยนต์"},
+			noMatch: []string{"ทิพยประกันภัย"},
+		},
+		{
+			pattern: "?",
+			regex:   `^.$`,
+			match:   []string{"a", "ก"},
+			noMatch: []string{"", "ab"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := wildcardRegex(tt.pattern)
+			if got != tt.regex {
+				t.Errorf("wildcardRegex(%q) = %q, want %q", tt.pattern, got, tt.regex)
+			}
+			re := regexp.MustCompile(got)
+			for _, s := range tt.match {
+				if !re.MatchString(s) {
+					t.Errorf("%s does not match %q", got, s)
+				}
+			}
+			for _, s := range tt.noMatch {
+				if re.MatchString(s) {
+					t.Errorf("%s matches %q", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestWildcardCount(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"MTR-001", 0},
+		{"MTR-*", 1},
+		{"MTR-***", 1},
+		{"*a*b*", 3},
+		{"??", 2},
+		{"*?*", 3},
+		{"ประกัน*ภัย?", 2},
+	}
+	for _, tt := range tests {
+		if got := wildcardCount(tt.s); got != tt.want {
+			t.Errorf("wildcardCount(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+		if hasWildcards(tt.s) != (tt.want > 0) {
+			t.Errorf("hasWildcards(%q) = %t", tt.s, hasWildcards(tt.s))
+		}
+	}
+}
+
+func TestCheckWildcards(t *testing.T) {
+	if err := checkWildcards("group", strings.Repeat("a*", maxWildcards)); err != nil {
+		t.Errorf("%d wildcards were rejected: %v", maxWildcards, err)
+	}
+	// Runs of * count once, so they do not use up the allowance.
+	if err := checkWildcards("group", "a"+strings.Repeat("*", 100)); err != nil {
+		t.Errorf("a run of * was rejected: %v", err)
+	}
+	err := checkWildcards("code", strings.Repeat("?", maxWildcards+1))
+	var invalid *filterError
+	if !errors.As(err, &invalid) || invalid.code != "TOO_MANY_WILDCARDS" || invalid.message != "code may contain at most 8 wildcards, got 9" {
+		t.Errorf("checkWildcards(9 ?) = %v", err)
+	}
+}
+
+func FuzzWildcardRegex(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		re, err := regexp.Compile(wildcardRegex(s))
+		if err != nil {
+			t.Fatalf("wildcardRegex(%q) = %q does not compile: %v", s, wildcardRegex(s), err)
+		}
+		// Every wildcard matches itself, so a pattern matches its own
+		// text; . stops at a newline, in Mongo as here.
+		if literal := strings.ToValidUTF8(s, ""); !strings.Contains(literal, "\n") && !re.MatchString(literal) {
+			t.Errorf("wildcardRegex(%q) = %q does not match the pattern itself", s, re)
+		}
+		n := wildcardCount(s)
+		if (n > 0) != hasWildcards(s) || n > strings.Count(s, "*")+strings.Count(s, "?") {
+			t.Errorf("wildcardCount(%q) = %d", s, n)
+		}
+	})
+}
@@ -1,20 +1,300 @@
 package main
 
 import (
-    "log"
-    "github.com/MaMaTidarat/poc-app/database"
-    "github.com/MaMaTidarat/poc-app/routes"
-    "github.com/gofiber/fiber/v2"
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/MaMaTidarat/poc-app/cache"
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/MaMaTidarat/poc-app/migrations"
+	"github.com/MaMaTidarat/poc-app/routes"
+	"github.com/MaMaTidarat/poc-app/tlsconfig"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
-    app := fiber.New()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger := newLogger(cfg.Log)
+	slog.SetDefault(logger)
+
+	sampling, err := middleware.ParseSamplingRules(cfg.Log.AccessLogSampling)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apierror.ShowDetails = cfg.DebugErrors
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.ErrorHandler})
+
+	corsHandler, err := middleware.CORS(middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Use(corsHandler)
+	app.Use(middleware.RequestID())
+	app.Use(middleware.RequestMetrics())
+	app.Use(middleware.AccessLog(logger, middleware.NewSampler(sampling, rand.NewSource(time.Now().UnixNano()))))
+
+	if cfg.DebugAddr != "" {
+		go serveDebug(cfg.DebugAddr)
+	}
+
+	// Initialize MongoDB
+	database.ConfigureBreaker(cfg.Breaker)
+	database.ConfigureSlowQueries(cfg.SlowQuery)
+	if err := database.ConnectDB(cfg.Mongo); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Mongo.EnsureIndexes {
+		ensureIndexes(cfg.Mongo.FlatSync)
+	}
+	if cfg.Mongo.MigrateOnStartup {
+		runMigrations()
+	}
+
+	// Setup routes
+	maintenance := middleware.NewMaintenance(middleware.MaintenanceState{
+		Scope:   cfg.Maintenance,
+		Message: cfg.MaintenanceMessage,
+	})
+	responses := newCache(context.Background(), cfg.Cache)
+	if responses != nil || cfg.Mongo.FlatSync {
+		watchProducts(responses, cfg.Mongo.FlatSync)
+	}
+	if cfg.Mongo.FlatSync {
+		for _, t := range database.Tenants() {
+			go database.RunFlatResync(context.Background(), t, cfg.Mongo.FlatResyncInterval)
+		}
+	}
+	h := handlers.New(cfg, handlers.Deps{
+		Repos:       database.Repository,
+		Logger:      logger,
+		Clock:       time.Now,
+		Maintenance: maintenance,
+		Cache:       responses,
+	})
+	if cfg.Scheduler.Interval > 0 {
+		go h.RunScheduler(context.Background(), database.Tenants(), cfg.Scheduler.Interval)
+	}
+	routes.SetupRoutes(app, cfg, h, middleware.Auth(authConfig(cfg.Auth)), maintenance)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := listen(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// A second signal during the drain kills the process as usual.
+	context.AfterFunc(ctx, stop)
+	deadline, err := serve(ctx, app, ln, h, cfg.HTTP.ShutdownGrace)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Whatever is left of the grace period bounds the wait for Mongo
+	// operations still holding connections.
+	dbCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := database.Disconnect(dbCtx); err != nil {
+		log.Printf("Error disconnecting from MongoDB: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// serve runs app on ln, ready, until ctx is done. It then turns h not
+// ready and drains in-flight requests for up to grace, returning the end
+// of the grace period. A listener failure is returned at once.
+func serve(ctx context.Context, app *fiber.App, ln net.Listener, h *handlers.Handler, grace time.Duration) (time.Time, error) {
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listener(ln)
+	}()
+	h.SetReady(true)
+
+	select {
+	case err := <-listenErr:
+		return time.Time{}, err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining in-flight requests for up to %s", grace)
+	h.SetReady(false)
 
-    // Initialize MongoDB
-    database.ConnectDB()
+	deadline := time.Now().Add(grace)
+	if err := app.ShutdownWithTimeout(grace); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+	return deadline, nil
+}
+
+// listen opens the HTTP listener, wrapped in TLS when a certificate is
+// configured. SIGHUP reloads the certificate from disk.
+func listen(cfg config.Config) (net.Listener, error) {
+	tlsCfg, reloader, err := tlsconfig.Load(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg == nil {
+		return ln, nil
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("Error reloading TLS certificate, keeping the current one: %v", err)
+				continue
+			}
+			log.Println("Reloaded TLS certificate")
+		}
+	}()
+	return tls.NewListener(ln, tlsCfg), nil
+}
+
+// serveDebug serves the profiling endpoints on their own listener, outside
+// the public app and its middleware.
+func serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// Runtime memstats plus every counter recorded through package metrics.
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Printf("Serving debug endpoints on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Debug server stopped: %v", err)
+	}
+}
+
+// watchProducts reacts to every change of a tenant's products collection,
+// whoever made it: cached pages are dropped and, with flat sync enabled,
+// the changed group is re-flattened.
+func watchProducts(responses cache.Cache, flatSync bool) {
+	for _, t := range database.Tenants() {
+		t := t
+		go database.WatchProducts(context.Background(), t, func(change database.Change) {
+			ctx := context.Background()
+			if responses != nil {
+				responses.Invalidate(ctx, t.Name)
+			}
+			if !flatSync || change.GroupID == nil {
+				// Untracked changes are picked up by the next resync.
+				return
+			}
+			if err := database.SyncFlatGroup(ctx, t, change.GroupID); err != nil {
+				log.Printf("Error syncing products_flat for tenant %s: %v", t.Name, err)
+				return
+			}
+			database.ObserveFlatLag(t, change.ClusterTime)
+		})
+	}
+}
 
-    // Setup routes
-    routes.SetupRoutes(app)
+// newCache returns nil when caching is disabled.
+func newCache(ctx context.Context, cfg config.CacheConfig) cache.Cache {
+	if cfg.TTL == 0 {
+		return nil
+	}
+	switch cfg.Backend {
+	case "memory":
+		return cache.NewLRU(cfg.Size, cfg.TTL)
+	case "redis":
+		opts := &redis.Options{Addr: cfg.RedisAddr, Password: cfg.RedisPassword}
+		if cfg.RedisTLS {
+			opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		return cache.NewRedis(ctx, redis.NewClient(opts), cfg.RedisPrefix, cfg.TTL)
+	}
+	return nil
+}
+
+func newLogger(cfg config.LogConfig) *slog.Logger {
+	if cfg.Format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stderr, nil))
+}
+
+// ensureIndexes runs at startup; a failure is logged rather than fatal since
+// the service still works, only slower, without the indexes.
+func ensureIndexes(flat bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	for _, t := range database.Tenants() {
+		if _, err := database.EnsureIndexes(ctx, t.Products); err != nil {
+			log.Printf("Error ensuring indexes for tenant %s: %v", t.Name, err)
+		}
+		if !flat {
+			continue
+		}
+		if _, err := database.EnsureFlatIndexes(ctx, t.Flat); err != nil {
+			log.Printf("Error ensuring products_flat indexes for tenant %s: %v", t.Name, err)
+		}
+	}
+}
+
+// runMigrations applies pending migrations at startup. Unlike index
+// creation a failure is fatal: handlers may depend on the migrated shape.
+func runMigrations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	for _, t := range database.Tenants() {
+		_, err := migrations.Run(ctx, t.Products.Database(), t.Products)
+		if errors.Is(err, migrations.ErrLocked) {
+			log.Printf("Skipping migrations for tenant %s: another instance is applying them", t.Name)
+			continue
+		}
+		if err != nil {
+			log.Fatalf("tenant %s: %v", t.Name, err)
+		}
+	}
+}
 
-    log.Fatal(app.Listen(":3000"))
+func authConfig(cfg config.AuthConfig) middleware.AuthConfig {
+	auth := middleware.AuthConfig{
+		APIKeys: middleware.ParseAPIKeys(cfg.APIKeys),
+	}
+	if cfg.JWTJWKSURL != "" {
+		auth.JWT = &middleware.JWTConfig{
+			JWKSURL:    cfg.JWTJWKSURL,
+			Audience:   cfg.JWTAudience,
+			Issuer:     cfg.JWTIssuer,
+			RolesClaim: cfg.JWTRolesClaim,
+			Leeway:     cfg.JWTLeeway,
+		}
+	}
+	return auth
 }
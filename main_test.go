@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestServeDrainsOnSIGTERM starts the server, sends the process SIGTERM
+// while a slow request is in flight, and checks that the request
+// completes, readiness turns 503 and new connections are refused.
+func TestServeDrainsOnSIGTERM(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	h := handlers.New(config.Config{}, handlers.Deps{
+		Maintenance: middleware.NewMaintenance(middleware.MaintenanceState{}),
+		Clock:       time.Now,
+	})
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/readyz", h.Readiness)
+	started := make(chan struct{})
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		return c.SendString("done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+	// Each request dials, so none rides on a connection opened before the
+	// drain.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	type result struct {
+		deadline time.Time
+		err      error
+	}
+	served := make(chan result, 1)
+	go func() {
+		deadline, err := serve(ctx, app, ln, h, 5*time.Second)
+		served <- result{deadline, err}
+	}()
+	start := time.Now()
+	waitReady(t, client, base)
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(base + "/slow")
+		if err != nil {
+			slow <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-started
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case body := <-slow:
+		if body != "done" {
+			t.Errorf("in-flight request got %q, want it to complete", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request did not complete")
+	}
+	select {
+	case r := <-served:
+		if r.err != nil || r.deadline.IsZero() {
+			t.Errorf("serve = %v, %v", r.deadline, r.err)
+		}
+		if took := time.Since(start); took > 3*time.Second {
+			t.Errorf("serve took %s, want it to return once the drain is done rather than at the grace", took)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the drain")
+	}
+
+	req, _ := http.NewRequest(fiber.MethodGet, "/readyz", nil)
+	if resp, err := app.Test(req, -1); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readiness after SIGTERM = %v, %v, want 503", resp, err)
+	}
+	if _, err := client.Get(base + "/readyz"); err == nil {
+		t.Error("a new connection was accepted after the drain")
+	}
+}
+
+func waitReady(t *testing.T, client *http.Client, base string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		resp, err := client.Get(base + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the server never became ready")
+}
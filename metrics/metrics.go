@@ -0,0 +1,99 @@
+// Package metrics is a small façade over expvar. Handlers and the database
+// layer record through these types only, so the backing exporter can change
+// without touching call sites.
+package metrics
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// key joins label values into the map key a sample is stored under.
+func key(labels []string) string {
+	if len(labels) == 0 {
+		return "total"
+	}
+	return strings.Join(labels, ",")
+}
+
+// Counter is a monotonically increasing count, optionally split by labels.
+type Counter struct {
+	m *expvar.Map
+}
+
+func NewCounter(name string) *Counter {
+	return &Counter{m: expvar.NewMap(name)}
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.m.Add(key(labels), 1)
+}
+
+func (c *Counter) Add(n int64, labels ...string) {
+	c.m.Add(key(labels), n)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	m *expvar.Map
+}
+
+func NewGauge(name string) *Gauge {
+	return &Gauge{m: expvar.NewMap(name)}
+}
+
+func (g *Gauge) Add(delta int64, labels ...string) {
+	g.m.Add(key(labels), delta)
+}
+
+func (g *Gauge) Set(value int64, labels ...string) {
+	v := new(expvar.Int)
+	v.Set(value)
+	g.m.Set(key(labels), v)
+}
+
+// Timer summarises observed durations as count, total and max milliseconds.
+type Timer struct {
+	mu    sync.Mutex
+	byKey map[string]*timing
+}
+
+type timing struct {
+	Count   int64   `json:"count"`
+	TotalMs float64 `json:"totalMs"`
+	MaxMs   float64 `json:"maxMs"`
+}
+
+func NewTimer(name string) *Timer {
+	t := &Timer{byKey: map[string]*timing{}}
+	expvar.Publish(name, expvar.Func(t.snapshot))
+	return t
+}
+
+func (t *Timer) Observe(d time.Duration, labels ...string) {
+	ms := float64(d) / float64(time.Millisecond)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.byKey[key(labels)]
+	if !ok {
+		s = &timing{}
+		t.byKey[key(labels)] = s
+	}
+	s.Count++
+	s.TotalMs += ms
+	if ms > s.MaxMs {
+		s.MaxMs = ms
+	}
+}
+
+func (t *Timer) snapshot() interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]timing, len(t.byKey))
+	for k, s := range t.byKey {
+		out[k] = *s
+	}
+	return out
+}
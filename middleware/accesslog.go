@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SamplingRules decide which fraction of requests is access-logged. The
+// most specific matching rule wins: path, then exact status, then status
+// class, then Default.
+type SamplingRules struct {
+	Paths    map[string]float64
+	Statuses map[int]float64
+	Classes  map[int]float64 // keyed by status/100
+	Default  float64
+}
+
+// ParseSamplingRules reads the comma-separated "selector=rate" format, where
+// a selector is a path ("/healthz"), a status ("404"), a status class
+// ("2xx") or "*" for the default. Rates are between 0 and 1.
+func ParseSamplingRules(raw string) (SamplingRules, error) {
+	rules := SamplingRules{Paths: map[string]float64{}, Statuses: map[int]float64{}, Classes: map[int]float64{}, Default: 1}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		selector, rateStr, ok := strings.Cut(entry, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+		if !ok || err != nil || rate < 0 || rate > 1 {
+			return rules, fmt.Errorf("invalid sampling rule %q: want selector=rate with 0 <= rate <= 1", entry)
+		}
+		selector = strings.TrimSpace(selector)
+		switch {
+		case selector == "*":
+			rules.Default = rate
+		case strings.HasPrefix(selector, "/"):
+			rules.Paths[selector] = rate
+		case len(selector) == 3 && strings.HasSuffix(selector, "xx") && selector[0] >= '1' && selector[0] <= '5':
+			rules.Classes[int(selector[0]-'0')] = rate
+		default:
+			status, err := strconv.Atoi(selector)
+			if err != nil || status < 100 || status > 599 {
+				return rules, fmt.Errorf("invalid sampling selector %q", selector)
+			}
+			rules.Statuses[status] = rate
+		}
+	}
+	return rules, nil
+}
+
+func (r SamplingRules) rate(path string, status int) float64 {
+	if rate, ok := r.Paths[path]; ok {
+		return rate
+	}
+	if rate, ok := r.Statuses[status]; ok {
+		return rate
+	}
+	if rate, ok := r.Classes[status/100]; ok {
+		return rate
+	}
+	return r.Default
+}
+
+// Sampler makes sampling decisions from a random source, which tests can
+// seed to make them deterministic.
+type Sampler struct {
+	rules SamplingRules
+	mu    sync.Mutex
+	rnd   *rand.Rand
+}
+
+func NewSampler(rules SamplingRules, src rand.Source) *Sampler {
+	return &Sampler{rules: rules, rnd: rand.New(src)}
+}
+
+// Sample reports whether a request to path answered with status is logged.
+func (s *Sampler) Sample(path string, status int) bool {
+	rate := s.rules.rate(path, status)
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < rate
+}
+
+// AccessLog writes one structured log line per sampled request.
+func AccessLog(logger *slog.Logger, sampler *Sampler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		if err != nil {
+			// Let the app's error handler set the final status first.
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		status := c.Response().StatusCode()
+		if !sampler.Sample(c.Path(), status) {
+			return nil
+		}
+		client := ""
+		if p := PrincipalFrom(c); p != nil {
+			client = p.Subject
+		}
+		logger.Info("request",
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", status,
+			"durationMs", time.Since(start).Milliseconds(),
+			"bytes", len(c.Response().Body()),
+			"requestId", RequestIDFrom(c),
+			"client", client,
+			"tenant", TenantNameFrom(c),
+		)
+		return nil
+	}
+}
+
+const tenantNameKey = "tenantName"
+
+// SetTenantName records the tenant serving the request for log fields.
+func SetTenantName(c *fiber.Ctx, name string) {
+	c.Locals(tenantNameKey, name)
+}
+
+func TenantNameFrom(c *fiber.Ctx) string {
+	name, _ := c.Locals(tenantNameKey).(string)
+	return name
+}
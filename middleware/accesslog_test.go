@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseSamplingRules(t *testing.T) {
+	rules, err := ParseSamplingRules(" /healthz=0, 404=1 ,2xx=0.1,*=0.5,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SamplingRules{
+		Paths:    map[string]float64{"/healthz": 0},
+		Statuses: map[int]float64{404: 1},
+		Classes:  map[int]float64{2: 0.1},
+		Default:  0.5,
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %+v, want %+v", rules, want)
+	}
+
+	for _, raw := range []string{"2xx", "2xx=", "2xx=1.5", "2xx=-0.1", "6xx=1", "99=1", "abc=1", "/x=half"} {
+		if _, err := ParseSamplingRules(raw); err == nil {
+			t.Errorf("ParseSamplingRules(%q) accepted", raw)
+		}
+	}
+}
+
+func TestSamplingRulePrecedence(t *testing.T) {
+	rules, err := ParseSamplingRules("/healthz=0,404=0.3,4xx=0.2,2xx=0.1,*=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		status int
+		want   float64
+	}{
+		{"/healthz", 500, 0},
+		{"/products", 404, 0.3},
+		{"/products", 400, 0.2},
+		{"/products", 200, 0.1},
+		{"/products", 503, 1},
+	}
+	for _, tt := range tests {
+		if got := rules.rate(tt.path, tt.status); got != tt.want {
+			t.Errorf("rate(%s, %d) = %v, want %v", tt.path, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestSamplerDeterministic(t *testing.T) {
+	rules, err := ParseSamplingRules("/healthz=0,2xx=0.1,*=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisions := func(seed int64) []bool {
+		s := NewSampler(rules, rand.NewSource(seed))
+		var out []bool
+		for i := 0; i < 1000; i++ {
+			out = append(out, s.Sample("/products", 200))
+			// Certain decisions draw nothing from the source, so they do
+			// not shift the ones around them.
+			if !s.Sample("/products", 500) || s.Sample("/healthz", 200) {
+				t.Fatal("a rate of 1 or 0 was sampled randomly")
+			}
+		}
+		return out
+	}
+
+	first := decisions(42)
+	if again := decisions(42); !reflect.DeepEqual(first, again) {
+		t.Error("the same seed gave different decisions")
+	}
+	if other := decisions(7); reflect.DeepEqual(first, other) {
+		t.Error("different seeds gave the same decisions")
+	}
+
+	// The decisions for a seed are those of its source.
+	rnd := rand.New(rand.NewSource(42))
+	logged := 0
+	for i, got := range first {
+		if want := rnd.Float64() < 0.1; got != want {
+			t.Fatalf("decision %d = %v, want %v", i, got, want)
+		}
+		if got {
+			logged++
+		}
+	}
+	if logged < 70 || logged > 130 {
+		t.Errorf("logged %d of 1000 at a rate of 0.1", logged)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	rules, err := ParseSamplingRules("/healthz=0,*=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	app := fiber.New()
+	app.Use(RequestID(), AccessLog(logger, NewSampler(rules, rand.NewSource(1))))
+	app.Get("/healthz", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/products", func(c *fiber.Ctx) error {
+		SetTenantName(c, "th")
+		return fiber.NewError(fiber.StatusTeapot, "short and stout")
+	})
+
+	for _, target := range []string{"/healthz", "/products"} {
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		req.Header.Set(fiber.HeaderXRequestID, "req-1")
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var line map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	if err := dec.Decode(&line); err != nil {
+		t.Fatalf("no access log line: %v", err)
+	}
+	if dec.More() {
+		t.Error("the sampled-out health check was logged")
+	}
+	want := map[string]interface{}{
+		"msg":       "request",
+		"method":    "GET",
+		"path":      "/products",
+		"status":    float64(fiber.StatusTeapot),
+		"bytes":     float64(len("short and stout")),
+		"requestId": "req-1",
+		"client":    "",
+		"tenant":    "th",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, line[k], v)
+		}
+	}
+	if _, ok := line["durationMs"].(float64); !ok {
+		t.Errorf("durationMs = %#v", line["durationMs"])
+	}
+}
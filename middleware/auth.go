@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	AuthMethodAPIKey = "api-key"
+	AuthMethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles"`
+	Method  string   `json:"method"`
+}
+
+const principalKey = "principal"
+
+// PrincipalFrom returns the principal attached by Auth, or nil.
+func PrincipalFrom(c *fiber.Ctx) *Principal {
+	p, _ := c.Locals(principalKey).(*Principal)
+	return p
+}
+
+// APIKey is a service-account credential accepted in the X-API-Key header.
+type APIKey struct {
+	Name  string
+	Key   string
+	Roles []string
+}
+
+// ParseAPIKeys reads the "name:key[:role|role]" comma-separated format used
+// by the API_KEYS environment variable.
+func ParseAPIKeys(raw string) []APIKey {
+	var keys []APIKey
+	for _, entry := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		key := APIKey{Name: parts[0], Key: parts[1]}
+		if len(parts) == 3 {
+			key.Roles = strings.Split(parts[2], "|")
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+type AuthConfig struct {
+	APIKeys []APIKey
+	JWT     *JWTConfig
+}
+
+// Auth authenticates requests carrying either an X-API-Key header or an
+// Authorization: Bearer JWT. Either credential is sufficient. When neither
+// mechanism is configured the middleware is a no-op.
+func Auth(cfg AuthConfig) fiber.Handler {
+	var verifier *jwtVerifier
+	if cfg.JWT != nil && cfg.JWT.JWKSURL != "" {
+		verifier = newJWTVerifier(*cfg.JWT, NewJWKS(cfg.JWT.JWKSURL, defaultJWKSTTL))
+	}
+	if verifier == nil && len(cfg.APIKeys) == 0 {
+		log.Println("Authentication is not configured; all requests are allowed")
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	return func(c *fiber.Ctx) error {
+		if key := c.Get("X-API-Key"); key != "" && len(cfg.APIKeys) > 0 {
+			for _, k := range cfg.APIKeys {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+					c.Locals(principalKey, &Principal{Subject: k.Name, Roles: k.Roles, Method: AuthMethodAPIKey})
+					return c.Next()
+				}
+			}
+			return unauthorized(c, "invalid API key")
+		}
+
+		if auth := c.Get(fiber.HeaderAuthorization); verifier != nil && auth != "" {
+			token, ok := strings.CutPrefix(auth, "Bearer ")
+			if !ok {
+				return unauthorized(c, "unsupported authorization scheme")
+			}
+			p, err := verifier.Verify(strings.TrimSpace(token))
+			if err != nil {
+				return unauthorized(c, tokenErrorMessage(err))
+			}
+			c.Locals(principalKey, p)
+			return c.Next()
+		}
+
+		return unauthorized(c, "missing credentials")
+	}
+}
+
+// tokenErrorMessage is what a client is told of a rejected token. Faults of
+// the token itself are named; anything else, such as a failure to fetch
+// the signing keys, is logged and reported generically so the identity
+// provider's address and errors stay internal.
+func tokenErrorMessage(err error) string {
+	for _, tokenErr := range []error{errMalformedToken, errBadSignature, errTokenExpired, errTokenNotYet, errBadAudience, errBadIssuer, errUnknownKey} {
+		if errors.Is(err, tokenErr) {
+			return err.Error()
+		}
+	}
+	log.Printf("Error verifying token: %v", err)
+	return "the token could not be verified"
+}
+
+func unauthorized(c *fiber.Ctx, message string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="poc-app"`)
+	return apierror.Send(c, fiber.StatusUnauthorized, "UNAUTHORIZED", message)
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// authApp serves the principal of the request at /.
+func authApp(cfg AuthConfig) *fiber.App {
+	app := fiber.New()
+	app.Use(Auth(cfg))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(PrincipalFrom(c))
+	})
+	return app
+}
+
+// authRequest runs GET / with the given headers and returns the status,
+// the principal or error message, and the response.
+func authRequest(t *testing.T, app *fiber.App, header ...string) (int, *Principal, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		var env apierror.Body
+		json.Unmarshal(body, &env)
+		if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+			t.Errorf("401 without WWW-Authenticate")
+		}
+		return resp.StatusCode, nil, env.Error.Message
+	}
+	var p *Principal
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	return resp.StatusCode, p, ""
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	got := ParseAPIKeys(" ci:s3cret:editor|viewer , broken, :nokey, svc:k2:, ops:k3")
+	want := []APIKey{
+		{Name: "ci", Key: "s3cret", Roles: []string{"editor", "viewer"}},
+		{Name: "svc", Key: "k2", Roles: []string{""}},
+		{Name: "ops", Key: "k3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAPIKeys = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuthAPIKey(t *testing.T) {
+	app := authApp(AuthConfig{APIKeys: []APIKey{{Name: "ci", Key: "s3cret", Roles: []string{RoleEditor}}}})
+
+	status, p, _ := authRequest(t, app, "X-API-Key", "s3cret")
+	want := &Principal{Subject: "ci", Roles: []string{RoleEditor}, Method: AuthMethodAPIKey}
+	if status != http.StatusOK || !reflect.DeepEqual(p, want) {
+		t.Errorf("valid key: %d %+v, want %+v", status, p, want)
+	}
+	for _, tt := range []struct {
+		name    string
+		header  []string
+		message string
+	}{
+		{"wrong key", []string{"X-API-Key", "s3cret2"}, "invalid API key"},
+		{"missing credentials", nil, "missing credentials"},
+		// Without a JWT verifier a bearer token is no credential.
+		{"bearer without JWT configured", []string{fiber.HeaderAuthorization, "Bearer x.y.z"}, "missing credentials"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, message := authRequest(t, app, tt.header...)
+			if status != http.StatusUnauthorized || message != tt.message {
+				t.Errorf("got %d %q, want 401 %q", status, message, tt.message)
+			}
+		})
+	}
+}
+
+func TestAuthUnconfigured(t *testing.T) {
+	status, p, _ := authRequest(t, authApp(AuthConfig{}))
+	if status != http.StatusOK || p != nil {
+		t.Errorf("got %d %+v, want every request let through without a principal", status, p)
+	}
+}
+
+func TestAuthJWT(t *testing.T) {
+	key := testKey(t, 1)
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+	app := authApp(AuthConfig{
+		APIKeys: []APIKey{{Name: "ci", Key: "s3cret", Roles: []string{RoleViewer}}},
+		JWT:     &JWTConfig{JWKSURL: srv.URL, Audience: "product-api"},
+	})
+	token := sign(t, key, "k1", map[string]interface{}{
+		"sub":   "alice",
+		"aud":   "product-api",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{RoleAdmin},
+	})
+
+	status, p, _ := authRequest(t, app, fiber.HeaderAuthorization, "Bearer "+token)
+	want := &Principal{Subject: "alice", Roles: []string{RoleAdmin}, Method: AuthMethodJWT}
+	if status != http.StatusOK || !reflect.DeepEqual(p, want) {
+		t.Errorf("bearer token: %d %+v, want %+v", status, p, want)
+	}
+
+	// A request with both credentials is authenticated by its API key.
+	status, p, _ = authRequest(t, app, fiber.HeaderAuthorization, "Bearer "+token, "X-API-Key", "s3cret")
+	if status != http.StatusOK || p.Method != AuthMethodAPIKey {
+		t.Errorf("both credentials: %d %+v, want the API key's principal", status, p)
+	}
+
+	expired := sign(t, key, "k1", map[string]interface{}{"aud": "product-api", "exp": time.Now().Add(-time.Hour).Unix()})
+	for _, tt := range []struct {
+		name, authorization, message string
+	}{
+		{"expired", "Bearer " + expired, errTokenExpired.Error()},
+		{"malformed", "Bearer nope", errMalformedToken.Error()},
+		{"other scheme", "Basic YWxpY2U6cHc=", "unsupported authorization scheme"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, message := authRequest(t, app, fiber.HeaderAuthorization, tt.authorization)
+			if status != http.StatusUnauthorized || message != tt.message {
+				t.Errorf("got %d %q, want 401 %q", status, message, tt.message)
+			}
+		})
+	}
+}
+
+// TestAuthJWKSFailureStaysInternal checks that a 401 caused by the JWKS
+// fetch says nothing of the identity provider.
+func TestAuthJWKSFailureStaysInternal(t *testing.T) {
+	srv := newJWKSServer(t, nil)
+	srv.set(func(s *jwksServer) { s.status = http.StatusBadGateway })
+	app := authApp(AuthConfig{JWT: &JWTConfig{JWKSURL: srv.URL + "/internal/jwks"}})
+	token := sign(t, testKey(t, 1), "k1", map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+
+	status, _, message := authRequest(t, app, fiber.HeaderAuthorization, "Bearer "+token)
+	if status != http.StatusUnauthorized || message != "the token could not be verified" {
+		t.Errorf("got %d %q, want a generic 401", status, message)
+	}
+	if strings.Contains(message, "internal/jwks") || strings.Contains(message, "502") {
+		t.Errorf("message %q reveals the JWKS fetch", message)
+	}
+}
+
+func TestTokenErrorMessage(t *testing.T) {
+	for _, err := range []error{errMalformedToken, errBadSignature, errTokenExpired, errTokenNotYet, errBadAudience, errBadIssuer, errUnknownKey} {
+		if got := tokenErrorMessage(err); got != err.Error() {
+			t.Errorf("tokenErrorMessage(%v) = %q, want the error", err, got)
+		}
+	}
+	if got := tokenErrorMessage(io.ErrUnexpectedEOF); got != "the token could not be verified" {
+		t.Errorf("tokenErrorMessage of a fetch error = %q", got)
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit rejects requests whose body exceeds max bytes with 413.
+func BodyLimit(max int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Request().Header.ContentLength() > max || len(c.Body()) > max {
+			return apierror.Send(c, fiber.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE",
+				fmt.Sprintf("request body must not exceed %d bytes", max))
+		}
+		return c.Next()
+	}
+}
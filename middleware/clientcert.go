@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireClientCert rejects requests that did not present a client
+// certificate verified against the configured CA. When enabled is false it
+// is a no-op, so route setup does not depend on whether mTLS is on.
+func RequireClientCert(enabled bool) fiber.Handler {
+	if !enabled {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	return func(c *fiber.Ctx) error {
+		state := c.Context().TLSConnectionState()
+		if state == nil || len(state.VerifiedChains) == 0 {
+			return apierror.Send(c, fiber.StatusUnauthorized, "CLIENT_CERT_REQUIRED", "a verified client certificate is required")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// CORSConfig lists what cross-origin browsers may do. An empty
+// AllowedOrigins disables CORS entirely, so browsers block every
+// cross-origin call.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+}
+
+var (
+	defaultCORSMethods = []string{fiber.MethodGet, fiber.MethodHead, fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete}
+	defaultCORSHeaders = []string{fiber.HeaderContentType, fiber.HeaderAuthorization, "X-API-Key", fiber.HeaderIfMatch, "X-Timeout-Ms", "X-Request-Deadline", "X-Tenant"}
+	// exposedHeaders are the response headers browser clients page and
+	// revalidate with.
+	exposedHeaders = []string{fiber.HeaderETag, fiber.HeaderLastModified, fiber.HeaderLink, "X-Total-Count", "X-Total-Pages"}
+)
+
+// CORS answers preflight requests and decorates responses for the configured
+// origins. It must be registered ahead of Auth so preflights, which never
+// carry credentials, are not rejected.
+func CORS(cfg CORSConfig) (fiber.Handler, error) {
+	if len(cfg.AllowedOrigins) == 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }, nil
+	}
+	if cfg.AllowCredentials && contains(cfg.AllowedOrigins, "*") {
+		return nil, errors.New("CORS credentials cannot be allowed for the wildcard origin")
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = defaultCORSMethods
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = defaultCORSHeaders
+	}
+
+	return cors.New(cors.Config{
+		AllowOrigins:     strings.Join(cfg.AllowedOrigins, ","),
+		AllowMethods:     strings.Join(cfg.AllowedMethods, ","),
+		AllowHeaders:     strings.Join(cfg.AllowedHeaders, ","),
+		AllowCredentials: cfg.AllowCredentials,
+		ExposeHeaders:    strings.Join(exposedHeaders, ","),
+	}), nil
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func corsApp(t *testing.T, cfg CORSConfig) *fiber.App {
+	t.Helper()
+	handler, err := CORS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Use(handler)
+	app.Get("/products", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	return app
+}
+
+func corsRequest(t *testing.T, app *fiber.App, method, origin string, header ...string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, "/products", nil)
+	if origin != "" {
+		req.Header.Set(fiber.HeaderOrigin, origin)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCORSPreflight(t *testing.T) {
+	app := corsApp(t, CORSConfig{AllowedOrigins: []string{"https://admin.example.com"}})
+	resp := corsRequest(t, app, fiber.MethodOptions, "https://admin.example.com",
+		fiber.HeaderAccessControlRequestMethod, fiber.MethodPut,
+		fiber.HeaderAccessControlRequestHeaders, "X-API-Key, If-Match")
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", resp.StatusCode)
+	}
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); got != "https://admin.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowMethods); !strings.Contains(got, fiber.MethodPut) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want PUT among them", got)
+	}
+	allowed := resp.Header.Get(fiber.HeaderAccessControlAllowHeaders)
+	for _, h := range []string{"X-API-Key", fiber.HeaderIfMatch, "X-Tenant"} {
+		if !strings.Contains(allowed, h) {
+			t.Errorf("Access-Control-Allow-Headers = %q, want %s", allowed, h)
+		}
+	}
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowCredentials); got != "" {
+		t.Errorf("credentials allowed without the opt-in: %q", got)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	app := corsApp(t, CORSConfig{
+		AllowedOrigins:   []string{"https://admin.example.com"},
+		AllowCredentials: true,
+	})
+	resp := corsRequest(t, app, fiber.MethodGet, "https://admin.example.com")
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); got != "https://admin.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowCredentials); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+	exposed := resp.Header.Get(fiber.HeaderAccessControlExposeHeaders)
+	for _, h := range exposedHeaders {
+		if !strings.Contains(exposed, h) {
+			t.Errorf("Access-Control-Expose-Headers = %q, want %s", exposed, h)
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  CORSConfig
+	}{
+		{"other origin", CORSConfig{AllowedOrigins: []string{"https://admin.example.com"}}},
+		{"unconfigured", CORSConfig{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := corsApp(t, tt.cfg)
+			for _, method := range []string{fiber.MethodOptions, fiber.MethodGet} {
+				resp := corsRequest(t, app, method, "https://evil.example.com",
+					fiber.HeaderAccessControlRequestMethod, fiber.MethodGet)
+				if got := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); got != "" {
+					t.Errorf("%s: Access-Control-Allow-Origin = %q for a disallowed origin", method, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSCredentialsWithWildcard(t *testing.T) {
+	if _, err := CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("credentials were allowed for the wildcard origin")
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const idempotencyOpTimeout = 5 * time.Second
+
+type idempotencyRecord struct {
+	ID          string    `bson:"_id"`
+	RequestHash string    `bson:"requestHash"`
+	Completed   bool      `bson:"completed"`
+	Status      int       `bson:"status,omitempty"`
+	ContentType string    `bson:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt"`
+}
+
+// Idempotency honours the Idempotency-Key header. The first request with a
+// key claims it by inserting a pending record, so concurrent duplicates
+// cannot both run the handler; its response is then stored and replayed for
+// repeats with the same body. Reusing a key with a different body is a 409.
+// Records expire through a TTL index on createdAt. Keys are scoped to the
+// tenant and the authenticated principal, so neither clients nor one
+// client's writes to different tenants can collide. It must run behind
+// tenant resolution.
+func Idempotency(coll *mongo.Collection) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("Idempotency-Key")
+		if key == "" {
+			return c.Next()
+		}
+		if p := PrincipalFrom(c); p != nil {
+			key = p.Subject + ":" + key
+		}
+		key = TenantNameFrom(c) + ":" + key
+		sum := sha256.Sum256(append([]byte(c.Method()+" "+c.Path()+"\n"), c.Body()...))
+		hash := hex.EncodeToString(sum[:])
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), idempotencyOpTimeout)
+		defer cancel()
+
+		_, err := coll.InsertOne(ctx, idempotencyRecord{ID: key, RequestHash: hash, CreatedAt: time.Now().UTC()})
+		if mongo.IsDuplicateKeyError(err) {
+			return replay(c, ctx, coll, key, hash)
+		}
+		if err != nil {
+			return apierror.Internal(c, "claiming idempotency key", err)
+		}
+
+		if err := c.Next(); err != nil {
+			forget(coll, key)
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status >= 500 {
+			// Server failures are not final; let the client retry for real.
+			forget(coll, key)
+			return nil
+		}
+		// The handler may have used up ctx; the store gets a budget of its
+		// own, as an unstored response would hold the key until it expires.
+		storeCtx, cancelStore := context.WithTimeout(context.Background(), idempotencyOpTimeout)
+		defer cancelStore()
+		_, err = coll.UpdateOne(storeCtx, bson.M{"_id": key}, bson.M{"$set": bson.M{
+			"completed":   true,
+			"status":      status,
+			"contentType": string(c.Response().Header.ContentType()),
+			"body":        c.Response().Body(),
+		}})
+		if err != nil {
+			log.Printf("Error storing idempotent response: %v", err)
+		}
+		return nil
+	}
+}
+
+func replay(c *fiber.Ctx, ctx context.Context, coll *mongo.Collection, key, hash string) error {
+	var rec idempotencyRecord
+	err := coll.FindOne(ctx, bson.M{"_id": key}).Decode(&rec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The original attempt failed and released the key in between.
+		return apierror.Send(c, fiber.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "a request with this Idempotency-Key is being processed; retry shortly")
+	}
+	if err != nil {
+		return apierror.Internal(c, "loading idempotency key", err)
+	}
+	if rec.RequestHash != hash {
+		return apierror.Send(c, fiber.StatusConflict, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used with a different request body")
+	}
+	if !rec.Completed {
+		return apierror.Send(c, fiber.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "a request with this Idempotency-Key is being processed; retry shortly")
+	}
+	c.Set("Idempotent-Replayed", "true")
+	c.Set(fiber.HeaderContentType, rec.ContentType)
+	return c.Status(rec.Status).Send(rec.Body)
+}
+
+func forget(coll *mongo.Collection, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyOpTimeout)
+	defer cancel()
+	if _, err := coll.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		log.Printf("Error releasing idempotency key: %v", err)
+	}
+}
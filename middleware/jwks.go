@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JWKS fetches and caches the RSA signing keys published by the identity
+// provider. Keys are refreshed after ttl, or early when a token references a
+// kid we have not seen yet (key rotation), at most once per minRefresh.
+type JWKS struct {
+	url        string
+	ttl        time.Duration
+	minRefresh time.Duration
+	client     *http.Client
+	// flight makes the requests missing a key share one fetch.
+	flight singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func NewJWKS(url string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		url:        url,
+		ttl:        ttl,
+		minRefresh: 30 * time.Second,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		keys:       map[string]*rsa.PublicKey{},
+	}
+}
+
+const defaultJWKSTTL = 10 * time.Minute
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// Key returns the public key for kid, refreshing the cache if needed. The
+// fetch runs without holding mu, so requests with a cached key are not held
+// up by it.
+func (j *JWKS) Key(kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	stale := time.Since(j.fetchedAt) > j.ttl
+	due := stale || time.Since(j.fetchedAt) > j.minRefresh
+	key, ok := j.keys[kid]
+	j.mu.Unlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+	if !due {
+		return nil, errUnknownKey
+	}
+	if _, err, _ := j.flight.Do("refresh", func() (interface{}, error) { return nil, j.refresh() }); err != nil {
+		// Serve from the old set rather than failing every request while
+		// the identity provider is unreachable.
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	j.mu.Lock()
+	key, ok = j.keys[kid]
+	j.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (j *JWKS) refresh() error {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := parseRSAKey(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
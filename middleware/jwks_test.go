@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKey returns an RSA key generated once per package run.
+var testKey = func() func(t *testing.T, i int) *rsa.PrivateKey {
+	var (
+		mu   sync.Mutex
+		keys = map[int]*rsa.PrivateKey{}
+	)
+	return func(t *testing.T, i int) *rsa.PrivateKey {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := keys[i]; ok {
+			return k
+		}
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+		return k
+	}
+}()
+
+// jwksServer publishes a JWKS that tests may change, counting fetches.
+// While gate is set, requests wait for it to be closed.
+type jwksServer struct {
+	*httptest.Server
+	fetches atomic.Int32
+
+	mu     sync.Mutex
+	keys   map[string]*rsa.PublicKey
+	status int
+	gate   chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys map[string]*rsa.PublicKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		gate, status := s.gate, s.status
+		set := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		for kid, k := range s.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA", Kid: kid, Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		s.mu.Unlock()
+		if gate != nil {
+			<-gate
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(f func(s *jwksServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+// age makes the cache of j look fetched d ago.
+func age(j *JWKS, d time.Duration) {
+	j.mu.Lock()
+	j.fetchedAt = j.fetchedAt.Add(-d)
+	j.mu.Unlock()
+}
+
+func TestJWKSCachesKeys(t *testing.T) {
+	k1 := &testKey(t, 1).PublicKey
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": k1})
+	j := NewJWKS(srv.URL, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		key, err := j.Key("k1")
+		if err != nil || !key.Equal(k1) {
+			t.Fatalf("Key(k1) = %v, %v", key, err)
+		}
+	}
+	if n := srv.fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want once", n)
+	}
+
+	// An unknown kid right after a fetch does not refetch.
+	if _, err := j.Key("k2"); !errors.Is(err, errUnknownKey) {
+		t.Errorf("Key(k2) = %v, want errUnknownKey", err)
+	}
+	if n := srv.fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times after an unknown kid, want once", n)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	k1, k2 := &testKey(t, 1).PublicKey, &testKey(t, 2).PublicKey
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": k1})
+	j := NewJWKS(srv.URL, time.Hour)
+	if _, err := j.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.set(func(s *jwksServer) { s.keys = map[string]*rsa.PublicKey{"k2": k2} })
+	age(j, j.minRefresh+time.Second)
+	key, err := j.Key("k2")
+	if err != nil || !key.Equal(k2) {
+		t.Fatalf("Key(k2) after rotation = %v, %v", key, err)
+	}
+	// The rotated-out key is gone with the refresh.
+	if _, err := j.Key("k1"); !errors.Is(err, errUnknownKey) {
+		t.Errorf("Key(k1) after rotation = %v, want errUnknownKey", err)
+	}
+
+	// Once stale, even a cached kid is refetched.
+	age(j, time.Hour+time.Second)
+	if _, err := j.Key("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.fetches.Load(); n != 3 {
+		t.Errorf("fetched %d times, want 3", n)
+	}
+}
+
+func TestJWKSServesStaleKeysWhenUnreachable(t *testing.T) {
+	k1 := &testKey(t, 1).PublicKey
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": k1})
+	j := NewJWKS(srv.URL, time.Hour)
+	if _, err := j.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.set(func(s *jwksServer) { s.status = http.StatusServiceUnavailable })
+	age(j, time.Hour+time.Second)
+	if key, err := j.Key("k1"); err != nil || !key.Equal(k1) {
+		t.Errorf("Key(k1) with the JWKS down = %v, %v, want the cached key", key, err)
+	}
+	if _, err := j.Key("k2"); err == nil || errors.Is(err, errUnknownKey) {
+		t.Errorf("Key(k2) with the JWKS down = %v, want the fetch error", err)
+	}
+}
+
+// TestJWKSFetchDoesNotBlockCachedKeys checks that a slow refresh holds up
+// neither requests with a cached key nor more than one fetch.
+func TestJWKSFetchDoesNotBlockCachedKeys(t *testing.T) {
+	k1 := &testKey(t, 1).PublicKey
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": k1})
+	j := NewJWKS(srv.URL, time.Hour)
+	if _, err := j.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	srv.set(func(s *jwksServer) { s.gate = gate })
+	age(j, j.minRefresh+time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.Key("k2")
+		}()
+	}
+	// Wait for the refresh to reach the server.
+	for srv.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := j.Key("k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Key(k1) during a refresh: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Key(k1) waited for the refresh of another kid")
+	}
+
+	// Let the other lookups join the refresh before it ends.
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetched %d times, want the missing kids to share one refresh", n)
+	}
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// JWTConfig describes how bearer tokens issued by the company SSO are
+// validated. Audience must be among a token's aud; it is always checked, so
+// without one no token is accepted.
+type JWTConfig struct {
+	JWKSURL    string
+	Audience   string
+	Issuer     string
+	RolesClaim string
+	Leeway     time.Duration
+}
+
+type jwtVerifier struct {
+	cfg  JWTConfig
+	jwks *JWKS
+	now  func() time.Time
+}
+
+func newJWTVerifier(cfg JWTConfig, jwks *JWKS) *jwtVerifier {
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	return &jwtVerifier{cfg: cfg, jwks: jwks, now: time.Now}
+}
+
+var (
+	errMalformedToken = errors.New("malformed token")
+	errBadSignature   = errors.New("invalid token signature")
+	errTokenExpired   = errors.New("token expired")
+	errTokenNotYet    = errors.New("token not yet valid")
+	errBadAudience    = errors.New("token audience mismatch")
+	errBadIssuer      = errors.New("token issuer mismatch")
+)
+
+// Verify checks an RS256 compact JWT and returns the principal it describes.
+func (v *jwtVerifier) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errMalformedToken
+	}
+	if header.Alg != "RS256" {
+		return nil, errMalformedToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	key, err := v.jwks.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errBadSignature
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errMalformedToken
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return &Principal{
+		Subject: sub,
+		Roles:   stringList(claims[v.cfg.RolesClaim]),
+		Method:  AuthMethodJWT,
+	}, nil
+}
+
+func (v *jwtVerifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(v.cfg.Leeway)) {
+		return errTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errTokenNotYet
+	}
+	if v.cfg.Audience == "" || !contains(stringList(claims["aud"]), v.cfg.Audience) {
+		return errBadAudience
+	}
+	if v.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+			return errBadIssuer
+		}
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// stringList accepts a claim stored either as a JSON array or as a single
+// space-separated string.
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
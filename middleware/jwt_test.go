@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sign returns an RS256 token of claims signed by key, with kid in the
+// header.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+var jwtNow = time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+// claims are valid claims for testVerifier, with the given changes; a nil
+// value removes a claim.
+func claims(changes map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"sub":   "alice",
+		"aud":   "product-api",
+		"iss":   "https://sso.example.com",
+		"exp":   jwtNow.Add(time.Hour).Unix(),
+		"nbf":   jwtNow.Add(-time.Minute).Unix(),
+		"roles": []string{"editor"},
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func testVerifier(t *testing.T, cfg JWTConfig) *jwtVerifier {
+	t.Helper()
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": &testKey(t, 1).PublicKey})
+	cfg.JWKSURL = srv.URL
+	if cfg.Audience == "" {
+		cfg.Audience = "product-api"
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = "https://sso.example.com"
+	}
+	v := newJWTVerifier(cfg, NewJWKS(srv.URL, time.Hour))
+	v.now = func() time.Time { return jwtNow }
+	return v
+}
+
+func TestJWTVerify(t *testing.T) {
+	key := testKey(t, 1)
+	valid := sign(t, key, "k1", claims(nil))
+	tests := []struct {
+		name  string
+		cfg   JWTConfig
+		token string
+		want  *Principal
+		err   error
+	}{
+		{
+			name:  "valid",
+			token: valid,
+			want:  &Principal{Subject: "alice", Roles: []string{"editor"}, Method: AuthMethodJWT},
+		},
+		{
+			name:  "roles as a space-separated string",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"roles": "viewer editor"})),
+			want:  &Principal{Subject: "alice", Roles: []string{"viewer", "editor"}, Method: AuthMethodJWT},
+		},
+		{
+			name:  "configured roles claim",
+			cfg:   JWTConfig{RolesClaim: "groups"},
+			token: sign(t, key, "k1", claims(map[string]interface{}{"groups": []string{"admin"}})),
+			want:  &Principal{Subject: "alice", Roles: []string{"admin"}, Method: AuthMethodJWT},
+		},
+		{
+			name:  "no roles",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"roles": nil})),
+			want:  &Principal{Subject: "alice", Method: AuthMethodJWT},
+		},
+		{
+			name:  "audience in a list",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"aud": []string{"other", "product-api"}})),
+			want:  &Principal{Subject: "alice", Roles: []string{"editor"}, Method: AuthMethodJWT},
+		},
+		{
+			name:  "expired within the leeway",
+			cfg:   JWTConfig{Leeway: time.Minute},
+			token: sign(t, key, "k1", claims(map[string]interface{}{"exp": jwtNow.Add(-30 * time.Second).Unix()})),
+			want:  &Principal{Subject: "alice", Roles: []string{"editor"}, Method: AuthMethodJWT},
+		},
+		{
+			name:  "expired",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"exp": jwtNow.Add(-time.Second).Unix()})),
+			err:   errTokenExpired,
+		},
+		{
+			name:  "no exp",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"exp": nil})),
+			err:   errTokenExpired,
+		},
+		{
+			name:  "not yet valid",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"nbf": jwtNow.Add(time.Minute).Unix()})),
+			err:   errTokenNotYet,
+		},
+		{
+			name:  "other audience",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"aud": "billing-api"})),
+			err:   errBadAudience,
+		},
+		{
+			name:  "no audience",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"aud": nil})),
+			err:   errBadAudience,
+		},
+		{
+			name:  "other issuer",
+			token: sign(t, key, "k1", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+			err:   errBadIssuer,
+		},
+		{
+			name:  "signed by another key",
+			token: sign(t, testKey(t, 2), "k1", claims(nil)),
+			err:   errBadSignature,
+		},
+		{
+			name:  "tampered claims",
+			token: strings.Replace(valid, strings.Split(valid, ".")[1], base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory","exp":9999999999}`)), 1),
+			err:   errBadSignature,
+		},
+		{
+			name:  "unknown kid",
+			token: sign(t, key, "k9", claims(nil)),
+			err:   errUnknownKey,
+		},
+		{
+			name:  "alg none",
+			token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + strings.Split(valid, ".")[1] + ".",
+			err:   errMalformedToken,
+		},
+		{name: "two segments", token: "a.b", err: errMalformedToken},
+		{name: "not base64", token: "%%%.b.c", err: errMalformedToken},
+		{name: "empty", token: "", err: errMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := testVerifier(t, tt.cfg).Verify(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("Verify = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+// TestJWTVerifyWithoutAudience checks that a verifier with no audience
+// configured accepts no token, with or without an aud claim.
+func TestJWTVerifyWithoutAudience(t *testing.T) {
+	key := testKey(t, 1)
+	srv := newJWKSServer(t, map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+	v := newJWTVerifier(JWTConfig{JWKSURL: srv.URL, Issuer: "https://sso.example.com"}, NewJWKS(srv.URL, time.Hour))
+	v.now = func() time.Time { return jwtNow }
+	for _, aud := range []interface{}{"product-api", "", nil} {
+		if _, err := v.Verify(sign(t, key, "k1", claims(map[string]interface{}{"aud": aud}))); !errors.Is(err, errBadAudience) {
+			t.Errorf("aud %#v: Verify = %v, want %v", aud, err, errBadAudience)
+		}
+	}
+}
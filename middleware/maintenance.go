@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	MaintenanceOff    = "off"
+	MaintenanceWrites = "writes"
+	MaintenanceAll    = "all"
+)
+
+// MaintenanceState is what the maintenance switch is set to.
+type MaintenanceState struct {
+	// Scope is MaintenanceOff, MaintenanceWrites (reads keep working) or
+	// MaintenanceAll.
+	Scope        string     `json:"scope"`
+	Message      string     `json:"message,omitempty"`
+	EstimatedEnd *time.Time `json:"estimatedEnd,omitempty"`
+}
+
+func (s MaintenanceState) Validate() error {
+	switch s.Scope {
+	case MaintenanceOff, MaintenanceWrites, MaintenanceAll:
+		return nil
+	}
+	return fmt.Errorf("scope must be one of %s, %s, %s", MaintenanceOff, MaintenanceWrites, MaintenanceAll)
+}
+
+// Maintenance is a concurrency-safe maintenance switch.
+type Maintenance struct {
+	mu    sync.RWMutex
+	state MaintenanceState
+}
+
+func NewMaintenance(initial MaintenanceState) *Maintenance {
+	if initial.Scope == "" {
+		initial.Scope = MaintenanceOff
+	}
+	return &Maintenance{state: initial}
+}
+
+func (m *Maintenance) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *Maintenance) Set(s MaintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = s
+}
+
+// Active reports whether any maintenance is in effect.
+func (m *Maintenance) Active() bool {
+	return m.State().Scope != MaintenanceOff
+}
+
+// Guard answers 503 for requests the current maintenance scope takes
+// offline. Attach it to route groups that maintenance may close; admin
+// routes must stay reachable so the switch can be turned off.
+func (m *Maintenance) Guard() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		s := m.State()
+		blocked := s.Scope == MaintenanceAll ||
+			(s.Scope == MaintenanceWrites && c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead)
+		if !blocked {
+			return c.Next()
+		}
+
+		msg := s.Message
+		if msg == "" {
+			msg = "the service is undergoing maintenance"
+		}
+		details := fiber.Map{"scope": s.Scope}
+		if s.EstimatedEnd != nil {
+			details["estimatedEnd"] = s.EstimatedEnd
+			if wait := time.Until(*s.EstimatedEnd); wait > 0 {
+				c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+			}
+		}
+		return apierror.SendDetails(c, fiber.StatusServiceUnavailable, "MAINTENANCE", msg, details)
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/MaMaTidarat/poc-app/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+var requestsServed = metrics.NewCounter("http_requests")
+
+// RequestMetrics counts requests by method, route pattern and status class.
+// It must run outside AccessLog, which settles the final status of failed
+// requests.
+func RequestMetrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		class := strconv.Itoa(c.Response().StatusCode()/100) + "xx"
+		requestsServed.Inc(c.Method(), c.Route().Path, class)
+		return err
+	}
+}
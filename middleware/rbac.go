@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Permission string
+
+const (
+	PermProductsRead   Permission = "products:read"
+	PermProductsWrite  Permission = "products:write"
+	PermProductsBulk   Permission = "products:bulk"
+	PermWebhooksManage Permission = "webhooks:manage"
+	PermAdmin          Permission = "admin"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// RolePermissions grants permissions to roles. Roles not listed here grant
+// nothing.
+var RolePermissions = map[string][]Permission{
+	RoleViewer: {PermProductsRead},
+	RoleEditor: {PermProductsRead, PermProductsWrite},
+	RoleAdmin:  {PermProductsRead, PermProductsWrite, PermProductsBulk, PermWebhooksManage, PermAdmin},
+}
+
+var (
+	readMethods  = []string{fiber.MethodGet, fiber.MethodHead}
+	writeMethods = []string{fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete}
+)
+
+// AccessRule requires Permission for requests to Prefix (and everything
+// below it) using one of Methods. An empty Methods matches any method. A
+// "*" segment of Prefix stands for any one path segment, such as a key.
+type AccessRule struct {
+	Prefix     string
+	Methods    []string
+	Permission Permission
+}
+
+// AccessRules maps route groups to the permission they require. The rule
+// with the longest matching prefix wins.
+var AccessRules = []AccessRule{
+	{Prefix: "/products", Methods: readMethods, Permission: PermProductsRead},
+	{Prefix: "/products", Methods: writeMethods, Permission: PermProductsWrite},
+	{Prefix: "/products/bulk", Permission: PermProductsBulk},
+	{Prefix: "/products/import", Permission: PermProductsBulk},
+	{Prefix: "/groups", Methods: readMethods, Permission: PermProductsRead},
+	{Prefix: "/groups", Methods: writeMethods, Permission: PermProductsWrite},
+	{Prefix: "/product-groups", Methods: readMethods, Permission: PermProductsRead},
+	{Prefix: "/product-groups", Methods: writeMethods, Permission: PermProductsWrite},
+	{Prefix: "/product-groups/import", Permission: PermProductsBulk},
+	{Prefix: "/product-groups/*/merge-into", Permission: PermProductsBulk},
+	{Prefix: "/product-types", Methods: readMethods, Permission: PermProductsRead},
+	{Prefix: "/product-types", Methods: writeMethods, Permission: PermAdmin},
+	{Prefix: "/brokers", Methods: readMethods, Permission: PermProductsRead},
+	{Prefix: "/brokers", Methods: writeMethods, Permission: PermAdmin},
+	{Prefix: "/insurers", Methods: readMethods, Permission: PermProductsRead},
+	{Prefix: "/insurers", Methods: writeMethods, Permission: PermAdmin},
+	{Prefix: "/webhooks", Permission: PermWebhooksManage},
+	{Prefix: "/admin", Permission: PermAdmin},
+	{Prefix: "/audit", Permission: PermAdmin},
+}
+
+// HasPermission reports whether any of roles grants perm.
+func HasPermission(roles []string, perm Permission) bool {
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequiredPermission returns the permission needed for method and path, and
+// false when no rule covers the route.
+func RequiredPermission(rules []AccessRule, method, path string) (Permission, bool) {
+	var best *AccessRule
+	for i := range rules {
+		r := &rules[i]
+		if !matchesPrefix(path, r.Prefix) {
+			continue
+		}
+		if len(r.Methods) > 0 && !contains(r.Methods, method) {
+			continue
+		}
+		if best == nil || len(r.Prefix) > len(best.Prefix) {
+			best = r
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.Permission, true
+}
+
+func matchesPrefix(path, prefix string) bool {
+	if strings.Contains(prefix, "*") {
+		want, got := strings.Split(prefix, "/"), strings.Split(path, "/")
+		if len(got) < len(want) {
+			return false
+		}
+		for i, segment := range want {
+			if segment != "*" && segment != got[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// Authorize rejects requests whose principal lacks the permission the
+// matching access rule requires. It must run after Auth; requests without a
+// principal only reach it when authentication is disabled and are allowed.
+func Authorize(rules []AccessRule) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := PrincipalFrom(c)
+		if p == nil {
+			return c.Next()
+		}
+		perm, ok := RequiredPermission(rules, c.Method(), c.Path())
+		if !ok || !HasPermission(p.Roles, perm) {
+			return apierror.Send(c, fiber.StatusForbidden, "FORBIDDEN", "insufficient permissions for this operation")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MaMaTidarat/poc-app/apierror"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestRequiredPermissionWildcard checks the "*" segment of the group merge
+// rule: it stands for exactly one segment, and the longer rule still wins
+// over /product-groups.
+func TestRequiredPermissionWildcard(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         Permission
+	}{
+		{fiber.MethodPost, "/product-groups/MOTOR-1/merge-into/MOTOR-2", PermProductsBulk},
+		{fiber.MethodPost, "/product-groups/MOTOR-1/merge-into", PermProductsBulk},
+		{fiber.MethodPost, "/product-groups/merge-into/MOTOR-2", PermProductsWrite},
+		{fiber.MethodPost, "/product-groups/MOTOR-1/merge-intox/MOTOR-2", PermProductsWrite},
+		{fiber.MethodGet, "/product-groups/MOTOR-1/export", PermProductsRead},
+	}
+	for _, tt := range tests {
+		if got, ok := RequiredPermission(AccessRules, tt.method, tt.path); !ok || got != tt.want {
+			t.Errorf("RequiredPermission(%s %s) = %q, %t, want %q", tt.method, tt.path, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRequiredPermission(t *testing.T) {
+	tests := []struct {
+		name, method, path string
+		want               Permission
+		ok                 bool
+	}{
+		{"read", fiber.MethodGet, "/products", PermProductsRead, true},
+		{"head is a read", fiber.MethodHead, "/products/HP-001", PermProductsRead, true},
+		{"post is a write", fiber.MethodPost, "/products", PermProductsWrite, true},
+		{"put is a write", fiber.MethodPut, "/products/HP-001", PermProductsWrite, true},
+		{"patch is a write", fiber.MethodPatch, "/products/HP-001", PermProductsWrite, true},
+		{"delete is a write", fiber.MethodDelete, "/products/HP-001", PermProductsWrite, true},
+		{"unmapped method", fiber.MethodOptions, "/products", "", false},
+		{"longest prefix", fiber.MethodPost, "/products/bulk", PermProductsBulk, true},
+		{"longest prefix, any method", fiber.MethodGet, "/products/import/status", PermProductsBulk, true},
+		{"prefix ends at a segment", fiber.MethodPost, "/products/bulkier", PermProductsWrite, true},
+		{"not a segment prefix", fiber.MethodGet, "/productsx", "", false},
+		{"admin writes", fiber.MethodPost, "/brokers", PermAdmin, true},
+		{"any method", fiber.MethodOptions, "/webhooks/42", PermWebhooksManage, true},
+		{"unknown route", fiber.MethodGet, "/unknown", "", false},
+		{"root", fiber.MethodGet, "/", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := RequiredPermission(AccessRules, tt.method, tt.path)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("RequiredPermission(%s %s) = %q, %t, want %q, %t", tt.method, tt.path, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []string
+		perm  Permission
+		want  bool
+	}{
+		{"viewer reads", []string{RoleViewer}, PermProductsRead, true},
+		{"viewer cannot write", []string{RoleViewer}, PermProductsWrite, false},
+		{"editor writes", []string{RoleEditor}, PermProductsWrite, true},
+		{"editor cannot bulk", []string{RoleEditor}, PermProductsBulk, false},
+		{"admin bulk", []string{RoleAdmin}, PermProductsBulk, true},
+		{"any role grants", []string{RoleViewer, RoleEditor}, PermProductsWrite, true},
+		{"unknown role", []string{"owner"}, PermProductsRead, false},
+		{"empty role", []string{""}, PermProductsRead, false},
+		{"no roles", nil, PermProductsRead, false},
+		{"no permission", []string{RoleAdmin}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasPermission(tt.roles, tt.perm); got != tt.want {
+				t.Errorf("HasPermission(%v, %q) = %t, want %t", tt.roles, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	keys := []APIKey{
+		{Name: "viewer", Key: "v", Roles: []string{RoleViewer}},
+		{Name: "editor", Key: "e", Roles: []string{RoleEditor}},
+		{Name: "admin", Key: "a", Roles: []string{RoleAdmin}},
+		{Name: "nobody", Key: "n"},
+	}
+	newApp := func(auth bool) *fiber.App {
+		app := fiber.New()
+		if auth {
+			app.Use(Auth(AuthConfig{APIKeys: keys}))
+		}
+		app.Use(Authorize(AccessRules))
+		app.Use(func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+		return app
+	}
+	app := newApp(true)
+
+	tests := []struct {
+		key, method, path string
+		want              int
+	}{
+		{"v", fiber.MethodGet, "/products", http.StatusNoContent},
+		{"v", fiber.MethodPost, "/products", http.StatusForbidden},
+		{"e", fiber.MethodPost, "/products", http.StatusNoContent},
+		{"e", fiber.MethodPost, "/products/bulk", http.StatusForbidden},
+		{"a", fiber.MethodPost, "/products/bulk", http.StatusNoContent},
+		{"e", fiber.MethodPost, "/product-groups/MOTOR-1/merge-into/MOTOR-2", http.StatusForbidden},
+		{"n", fiber.MethodGet, "/products", http.StatusForbidden},
+		// Routes no rule covers are denied, even to admins.
+		{"a", fiber.MethodGet, "/unknown", http.StatusForbidden},
+		{"a", fiber.MethodOptions, "/products", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("X-API-Key", tt.key)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s as %s = %d, want %d", tt.method, tt.path, tt.key, resp.StatusCode, tt.want)
+		}
+		if resp.StatusCode == http.StatusForbidden {
+			var env apierror.Body
+			json.NewDecoder(resp.Body).Decode(&env)
+			if env.Error.Code != "FORBIDDEN" {
+				t.Errorf("%s %s as %s: code %q, want FORBIDDEN", tt.method, tt.path, tt.key, env.Error.Code)
+			}
+		}
+	}
+
+	// Without authentication there is no principal to check.
+	resp, err := newApp(false).Test(httptest.NewRequest(fiber.MethodPost, "/admin/reindex", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("without a principal: %d, want 204", resp.StatusCode)
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+const requestIDKey = "requestid"
+
+// RequestID assigns every request an id, reusing a caller-supplied
+// X-Request-ID, and echoes it in the response.
+func RequestID() fiber.Handler {
+	return requestid.New(requestid.Config{ContextKey: requestIDKey})
+}
+
+// RequestIDFrom returns the id assigned by RequestID.
+func RequestIDFrom(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,223 @@
+// Package migrations applies ordered, recorded data migrations to the
+// products database. Each migration runs at most once; applied ids are
+// stored in the migrations collection.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	historyCollection = "migrations"
+	lockCollection    = "migrations_lock"
+	lockTTL           = 10 * time.Minute
+	// lockHeartbeat is how often a running migration renews its lock, well
+	// within lockTTL.
+	lockHeartbeat = lockTTL / 5
+)
+
+// Migration is a single step. ID must be unique and never change once the
+// migration has been applied anywhere. Down, when set, undoes Up; a
+// migration without it cannot be rolled back.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database, coll *mongo.Collection) error
+	Down        func(ctx context.Context, db *mongo.Database, coll *mongo.Collection) error
+}
+
+var registry []Migration
+
+// Register adds m to the end of the run order. It is meant to be called
+// from init functions in this package.
+func Register(m Migration) {
+	for _, existing := range registry {
+		if existing.ID == m.ID {
+			panic("migrations: duplicate id " + m.ID)
+		}
+	}
+	registry = append(registry, m)
+}
+
+// Status describes one registered migration.
+type Status struct {
+	ID          string     `json:"id"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+}
+
+type record struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+var (
+	// ErrLocked is returned by Run and Rollback when another process is
+	// applying migrations.
+	ErrLocked = errors.New("migrations are already running elsewhere")
+	// ErrIrreversible is returned by Rollback when the latest applied
+	// migration has no Down.
+	ErrIrreversible = errors.New("migration cannot be rolled back")
+)
+
+// List returns every registered migration with its applied time, if any.
+func List(ctx context.Context, db *mongo.Database) ([]Status, error) {
+	applied, err := appliedIDs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(registry))
+	for _, m := range registry {
+		s := Status{ID: m.ID, Description: m.Description}
+		if at, ok := applied[m.ID]; ok {
+			s.AppliedAt = &at
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Run applies every pending migration in registration order against the
+// products collection coll, returning the ids it applied. It stops at the
+// first failure; migrations already applied in this run stay recorded.
+func Run(ctx context.Context, db *mongo.Database, coll *mongo.Collection) ([]string, error) {
+	release, err := acquireLock(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := appliedIDs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	ran := []string{}
+	for _, m := range registry {
+		if _, ok := applied[m.ID]; ok {
+			continue
+		}
+		log.Printf("Applying migration %s: %s", m.ID, m.Description)
+		start := time.Now()
+		if err := m.Up(ctx, db, coll); err != nil {
+			return ran, fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+		if _, err := db.Collection(historyCollection).InsertOne(ctx, record{ID: m.ID, AppliedAt: time.Now().UTC()}); err != nil {
+			return ran, fmt.Errorf("recording migration %s: %w", m.ID, err)
+		}
+		log.Printf("Applied migration %s in %s", m.ID, time.Since(start).Round(time.Millisecond))
+		ran = append(ran, m.ID)
+	}
+	return ran, nil
+}
+
+// Rollback undoes the latest applied migration, in registration order, and
+// removes its record so the next Run applies it again. It returns the id it
+// rolled back, or "" when none is applied.
+func Rollback(ctx context.Context, db *mongo.Database, coll *mongo.Collection) (string, error) {
+	release, err := acquireLock(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	applied, err := appliedIDs(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	for i := len(registry) - 1; i >= 0; i-- {
+		m := registry[i]
+		if _, ok := applied[m.ID]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return "", fmt.Errorf("%w: %s", ErrIrreversible, m.ID)
+		}
+		log.Printf("Rolling back migration %s: %s", m.ID, m.Description)
+		if err := m.Down(ctx, db, coll); err != nil {
+			return "", fmt.Errorf("rolling back migration %s: %w", m.ID, err)
+		}
+		if _, err := db.Collection(historyCollection).DeleteOne(ctx, bson.M{"_id": m.ID}); err != nil {
+			return "", fmt.Errorf("forgetting migration %s: %w", m.ID, err)
+		}
+		return m.ID, nil
+	}
+	return "", nil
+}
+
+func appliedIDs(ctx context.Context, db *mongo.Database) (map[string]time.Time, error) {
+	cursor, err := db.Collection(historyCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[string]time.Time, len(records))
+	for _, r := range records {
+		applied[r.ID] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// acquireLock keeps replicas starting together from running the same
+// migration twice. A TTL index clears locks left behind by a crashed run;
+// the holder renews lockedAt every lockHeartbeat until it releases the
+// lock, so a run longer than lockTTL keeps it.
+func acquireLock(ctx context.Context, db *mongo.Database) (func(), error) {
+	locks := db.Collection(lockCollection)
+	_, err := locks.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "lockedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(lockTTL.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = locks.InsertOne(ctx, bson.M{"_id": "migrations", "lockedAt": time.Now().UTC()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				_, err := locks.UpdateOne(ctx, bson.M{"_id": "migrations"}, bson.M{"$set": bson.M{"lockedAt": time.Now().UTC()}})
+				cancel()
+				if err != nil {
+					log.Printf("Error renewing migration lock: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := locks.DeleteOne(ctx, bson.M{"_id": "migrations"}); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}, nil
+}
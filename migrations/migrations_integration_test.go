@@ -0,0 +1,251 @@
+//go:build integration
+
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// These run against the integration-test database named by
+// MONGO_TEST_URI:
+//
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test -tags integration ./migrations
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+var stamped = time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// testDatabase is a fresh database holding a products collection with a
+// group of three products, dropped when the test ends. HP-002 already has
+// its timestamps and version; the last productList entry is not a document.
+func testDatabase(t *testing.T) (*mongo.Database, *mongo.Collection) {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("migrations_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	coll := db.Collection("productV4")
+	_, err = coll.InsertOne(ctx, bson.M{
+		"key":         "HEALTH-PLUS",
+		"productType": bson.M{"key": "HEALTH", "name": "Health"},
+		"productList": bson.A{
+			bson.M{"id": "HP-001", "productName": "Health Plus", "insurer": bson.M{"insurerCode": "TIP"}},
+			bson.M{"id": "HP-002", "productName": "Health Max", "createdAt": stamped, "updatedAt": stamped, "version": 4},
+			"not a product",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, coll
+}
+
+// products returns the productList of the test group.
+func products(t *testing.T, coll *mongo.Collection) bson.A {
+	t.Helper()
+	var group bson.M
+	if err := coll.FindOne(context.Background(), bson.M{"key": "HEALTH-PLUS"}).Decode(&group); err != nil {
+		t.Fatal(err)
+	}
+	return group["productList"].(bson.A)
+}
+
+// withRegistry runs the test with only the registered migrations of ids.
+func withRegistry(t *testing.T, ids ...string) {
+	t.Helper()
+	saved := registry
+	t.Cleanup(func() { registry = saved })
+	var ms []Migration
+	for _, id := range ids {
+		for _, m := range saved {
+			if m.ID == id {
+				ms = append(ms, m)
+			}
+		}
+	}
+	registry = ms
+}
+
+func registeredIDs() []string {
+	ids := make([]string, len(registry))
+	for i, m := range registry {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestRunUp(t *testing.T) {
+	db, coll := testDatabase(t)
+	ctx := context.Background()
+
+	ran, err := Run(ctx, db, coll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ran, registeredIDs()) {
+		t.Errorf("applied %v, want %v", ran, registeredIDs())
+	}
+
+	list := products(t, coll)
+	first, second := list[0].(bson.M), list[1].(bson.M)
+	legacy := primitive.NewDateTimeFromTime(LegacyTimestamp)
+	if first["createdAt"] != legacy || first["updatedAt"] != legacy || first["version"] != int32(1) {
+		t.Errorf("HP-001 = %v, want the legacy timestamps and version 1", first)
+	}
+	if first["productNameKey"] == nil {
+		t.Errorf("HP-001 has no productNameKey: %v", first)
+	}
+	if insurer := first["insurer"].(bson.M); insurer["insurerCodeLower"] != "tip" {
+		t.Errorf("HP-001 insurer = %v, want its code shadow", insurer)
+	}
+	if second["createdAt"] != primitive.NewDateTimeFromTime(stamped) || second["version"] != int32(4) {
+		t.Errorf("HP-002 = %v, want its own timestamps and version kept", second)
+	}
+	if list[2] != "not a product" {
+		t.Errorf("the non-document entry became %v", list[2])
+	}
+
+	statuses, err := List(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("%s not recorded as applied", s.ID)
+		}
+	}
+}
+
+// TestRunIdempotent runs the migrations twice: the second run applies and
+// changes nothing.
+func TestRunIdempotent(t *testing.T) {
+	db, coll := testDatabase(t)
+	ctx := context.Background()
+	if _, err := Run(ctx, db, coll); err != nil {
+		t.Fatal(err)
+	}
+	before := products(t, coll)
+
+	ran, err := Run(ctx, db, coll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("second run applied %v, want nothing", ran)
+	}
+	if after := products(t, coll); !reflect.DeepEqual(after, before) {
+		t.Errorf("second run changed the products:\n%v\n%v", before, after)
+	}
+	n, err := db.Collection(historyCollection).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != len(registry) {
+		t.Errorf("%d records, want %d", n, len(registry))
+	}
+
+	// An Up run again by hand, as after a crash before it was recorded,
+	// finds nothing left to do.
+	if err := registry[0].Up(ctx, db, coll); err != nil {
+		t.Fatal(err)
+	}
+	if after := products(t, coll); !reflect.DeepEqual(after, before) {
+		t.Errorf("re-running %s changed the products", registry[0].ID)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	db, coll := testDatabase(t)
+	ctx := context.Background()
+
+	t.Run("irreversible", func(t *testing.T) {
+		if _, err := Run(ctx, db, coll); err != nil {
+			t.Fatal(err)
+		}
+		last := registry[len(registry)-1]
+		if _, err := Rollback(ctx, db, coll); !errors.Is(err, ErrIrreversible) {
+			t.Errorf("Rollback of %s = %v, want ErrIrreversible", last.ID, err)
+		}
+	})
+
+	db, coll = testDatabase(t)
+	withRegistry(t, "0001_backfill_created_at", "0004_backfill_updated_at")
+	if _, err := Run(ctx, db, coll); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		id, field string
+	}{{"0004_backfill_updated_at", "updatedAt"}, {"0001_backfill_created_at", "createdAt"}} {
+		id, err := Rollback(ctx, db, coll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != step.id {
+			t.Errorf("rolled back %q, want %q", id, step.id)
+		}
+		list := products(t, coll)
+		if v, ok := list[0].(bson.M)[step.field]; ok {
+			t.Errorf("%s: HP-001 %s = %v, want it removed", id, step.field, v)
+		}
+		if list[1].(bson.M)[step.field] != primitive.NewDateTimeFromTime(stamped) {
+			t.Errorf("%s: HP-002 lost its own %s", id, step.field)
+		}
+	}
+	if id, err := Rollback(ctx, db, coll); err != nil || id != "" {
+		t.Errorf("Rollback with nothing applied = %q, %v", id, err)
+	}
+
+	// Rolled back migrations are pending again.
+	ran, err := Run(ctx, db, coll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ran, registeredIDs()) {
+		t.Errorf("re-applied %v, want %v", ran, registeredIDs())
+	}
+}
+
+func TestRunLocked(t *testing.T) {
+	db, coll := testDatabase(t)
+	ctx := context.Background()
+	release, err := acquireLock(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(ctx, db, coll); !errors.Is(err, ErrLocked) {
+		t.Errorf("Run while locked = %v, want ErrLocked", err)
+	}
+	if _, err := Rollback(ctx, db, coll); !errors.Is(err, ErrLocked) {
+		t.Errorf("Rollback while locked = %v, want ErrLocked", err)
+	}
+	release()
+	if _, err := Run(ctx, db, coll); err != nil {
+		t.Errorf("Run after release = %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package migrations
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LegacyTimestamp marks timestamps backfilled onto products that predate
+// timestamp tracking; the real value is unknown.
+var LegacyTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func init() {
+	Register(Migration{
+		ID:          "0001_backfill_created_at",
+		Description: "set createdAt on embedded products that lack it",
+		Up: func(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+			return setMissing(ctx, coll, "createdAt", LegacyTimestamp)
+		},
+		Down: func(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+			return unsetValue(ctx, coll, "createdAt", LegacyTimestamp)
+		},
+	})
+	Register(Migration{
+		ID:          "0002_initialize_version",
+		Description: "set version=1 on embedded products that lack it",
+		Up: func(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+			return setMissing(ctx, coll, "version", 1)
+		},
+	})
+	Register(Migration{
+		ID:          "0003_search_shadow_fields",
+		Description: "backfill lowercase shadows of key, productType.key, insurerCode and broker keys",
+		Up: func(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+			return database.RefreshSearchFields(ctx, coll, nil)
+		},
+	})
+	Register(Migration{
+		ID:          "0004_backfill_updated_at",
+		Description: "set updatedAt on embedded products that lack it",
+		Up: func(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+			return setMissing(ctx, coll, "updatedAt", LegacyTimestamp)
+		},
+		Down: func(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+			return unsetValue(ctx, coll, "updatedAt", LegacyTimestamp)
+		},
+	})
+	Register(Migration{
+		ID:          "0005_product_name_key",
+		Description: "backfill productNameKey, the normalized name products are kept unique by",
+		Up:          backfillNameKeys,
+	})
+}
+
+// backfillNameKeys sets productNameKey on the products lacking it. The key
+// is computed in Go, as database.NameKey folds more than $toLower does. Each
+// item is addressed by position and guarded by its current name, so a
+// product renamed or moved meanwhile is skipped rather than mislabelled;
+// it already has the key from the write that changed it.
+func backfillNameKeys(ctx context.Context, _ *mongo.Database, coll *mongo.Collection) error {
+	cursor, err := coll.Find(ctx,
+		bson.M{"productList": bson.M{"$elemMatch": bson.M{"productNameKey": bson.M{"$exists": false}}}},
+		options.Find().SetProjection(bson.M{"productList.productName": 1, "productList.productNameKey": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var group struct {
+			ID          interface{}     `bson:"_id"`
+			ProductList []bson.RawValue `bson:"productList"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		var writes []mongo.WriteModel
+		for i, value := range group.ProductList {
+			item, ok := value.DocumentOK()
+			if !ok {
+				continue
+			}
+			name, ok := item.Lookup("productName").StringValueOK()
+			if !ok {
+				continue
+			}
+			if _, err := item.LookupErr("productNameKey"); err == nil {
+				continue
+			}
+			path := "productList." + strconv.Itoa(i)
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": group.ID, path + ".productName": name}).
+				SetUpdate(bson.M{"$set": bson.M{path + ".productNameKey": database.NameKey(name)}}))
+		}
+		if len(writes) == 0 {
+			continue
+		}
+		if _, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// setMissing sets field to value on every productList item where it is
+// absent, leaving items that already carry it untouched. Entries that are
+// not documents are skipped: a $set into one would fail the whole update.
+// The $elemMatch, a query on fields, only matches documents already.
+func setMissing(ctx context.Context, coll *mongo.Collection, field string, value interface{}) error {
+	_, err := coll.UpdateMany(ctx,
+		bson.M{"productList": bson.M{"$elemMatch": bson.M{field: bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"productList.$[p]." + field: value}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"p": bson.M{"$type": "object"}, "p." + field: bson.M{"$exists": false}}},
+		}),
+	)
+	return err
+}
+
+// unsetValue removes field from the productList items where it equals
+// value, the marker setMissing backfilled. Only backfills with such a
+// marker can be undone: version=1, the search shadows and name keys are
+// also written by the handlers, so their migrations have no Down.
+func unsetValue(ctx context.Context, coll *mongo.Collection, field string, value interface{}) error {
+	_, err := coll.UpdateMany(ctx,
+		bson.M{"productList": bson.M{"$elemMatch": bson.M{field: value}}},
+		bson.M{"$unset": bson.M{"productList.$[p]." + field: ""}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"p": bson.M{"$type": "object"}, "p." + field: value}},
+		}),
+	)
+	return err
+}
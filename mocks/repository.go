@@ -0,0 +1,195 @@
+// Package mocks has hand-written fakes of the storage interfaces, for
+// exercising handlers without a database.
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/MaMaTidarat/poc-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ database.ProductRepository = (*ProductRepository)(nil)
+
+// Call is one recorded repository call. Filter holds the filter, or the
+// pipeline for Aggregate; Update is only set for FindOneAndUpdate.
+type Call struct {
+	Method  string
+	Filter  interface{}
+	Update  interface{}
+	Options interface{}
+}
+
+// ProductRepository is a scripted database.ProductRepository. Each method
+// returns the documents scripted for it, or Err when set, and records the
+// call. Documents are anything the bson encoder accepts, typically bson.M.
+type ProductRepository struct {
+	FindDocs      []interface{}
+	AggregateDocs []interface{}
+	// FindOneDoc answers FindOne, FindOneAndUpdate and DeleteGroup; nil
+	// means no match.
+	FindOneDoc interface{}
+	Total      int64
+	Err        error
+	// Block, when set, holds every call until it is closed or the call's
+	// context ends, failing it with the context's error. One that is never
+	// closed is a database that never answers.
+	Block chan struct{}
+	// Delay holds every call for that long first, like a round trip to
+	// the database.
+	Delay time.Duration
+	// Now stamps the timestamps of PushProduct and SetProduct.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	calls     []Call
+	sequences map[string]int64
+}
+
+// Calls returns the calls made so far, in order.
+func (r *ProductRepository) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+func (r *ProductRepository) record(call Call) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *ProductRepository) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	r.record(Call{Method: "Find", Filter: filter, Options: options.MergeFindOptions(opts...)})
+	return r.cursor(ctx, r.FindDocs)
+}
+
+func (r *ProductRepository) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	r.record(Call{Method: "Aggregate", Filter: pipeline, Options: options.MergeAggregateOptions(opts...)})
+	return r.cursor(ctx, r.AggregateDocs)
+}
+
+func (r *ProductRepository) Count(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	r.record(Call{Method: "Count", Filter: filter, Options: options.MergeCountOptions(opts...)})
+	if err := r.err(ctx); err != nil {
+		return 0, err
+	}
+	return r.Total, nil
+}
+
+func (r *ProductRepository) EstimatedCount(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	r.record(Call{Method: "EstimatedCount", Options: opts})
+	if err := r.err(ctx); err != nil {
+		return 0, err
+	}
+	return r.Total, nil
+}
+
+func (r *ProductRepository) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	r.record(Call{Method: "FindOne", Filter: filter, Options: options.MergeFindOneOptions(opts...)})
+	return r.single(ctx)
+}
+
+func (r *ProductRepository) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	r.record(Call{Method: "FindOneAndUpdate", Filter: filter, Update: update, Options: options.MergeFindOneAndUpdateOptions(opts...)})
+	return r.single(ctx)
+}
+
+// PushProduct stamps item with Now, or the zero time when Now is nil.
+func (r *ProductRepository) PushProduct(ctx context.Context, filter bson.M, item bson.M) *mongo.SingleResult {
+	now := r.now()
+	item["createdAt"], item["updatedAt"] = now, now
+	r.record(Call{Method: "PushProduct", Filter: filter, Update: item})
+	return r.single(ctx)
+}
+
+func (r *ProductRepository) SetProduct(ctx context.Context, filter bson.M, id string, fields bson.M) *mongo.SingleResult {
+	fields["updatedAt"] = r.now()
+	r.record(Call{Method: "SetProduct", Filter: bson.M{"$and": bson.A{filter, database.ItemFilter(id)}}, Update: fields})
+	return r.single(ctx)
+}
+
+// CreateGroup reports a creation unless FindOneDoc is set, which stands
+// for an existing group with the key.
+func (r *ProductRepository) CreateGroup(ctx context.Context, group bson.M) (bool, error) {
+	r.record(Call{Method: "CreateGroup", Filter: bson.M{"key": group["key"]}, Update: group})
+	if err := r.err(ctx); err != nil {
+		return false, err
+	}
+	return r.FindOneDoc == nil, nil
+}
+
+func (r *ProductRepository) DeleteGroup(ctx context.Context, filter bson.M) *mongo.SingleResult {
+	r.record(Call{Method: "DeleteGroup", Filter: filter})
+	return r.single(ctx)
+}
+
+// NextSequence counts in memory, per name, under the same lock as Calls.
+func (r *ProductRepository) NextSequence(ctx context.Context, name string) (int64, error) {
+	r.record(Call{Method: "NextSequence", Filter: bson.M{"_id": name}})
+	if err := r.err(ctx); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sequences == nil {
+		r.sequences = map[string]int64{}
+	}
+	r.sequences[name]++
+	return r.sequences[name], nil
+}
+
+func (r *ProductRepository) now() time.Time {
+	if r.Now == nil {
+		return time.Time{}
+	}
+	return database.Timestamp(r.Now())
+}
+
+// err is Err, or the context's error so timeouts can be simulated with an
+// expired context.
+func (r *ProductRepository) err(ctx context.Context) error {
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if r.Block != nil {
+		select {
+		case <-r.Block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if r.Err != nil {
+		return r.Err
+	}
+	return ctx.Err()
+}
+
+func (r *ProductRepository) cursor(ctx context.Context, docs []interface{}) (*mongo.Cursor, error) {
+	if err := r.err(ctx); err != nil {
+		return nil, err
+	}
+	if docs == nil {
+		docs = []interface{}{}
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (r *ProductRepository) single(ctx context.Context) *mongo.SingleResult {
+	// The driver wants a document even for failed results.
+	if err := r.err(ctx); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if r.FindOneDoc == nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(r.FindOneDoc, nil, nil)
+}
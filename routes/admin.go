@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupAdminRoutes(app *fiber.App, h *handlers.Handler, auth, clientCert fiber.Handler) {
+	admin := app.Group("/admin", clientCert, auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	admin.Post("/indexes", h.EnsureIndexes)
+	admin.Post("/flat/backfill", h.BackfillFlat)
+	admin.Post("/cache/flush", h.FlushCache)
+	admin.Get("/products/duplicates", h.GetDuplicates)
+	admin.Get("/brokers/consistency", h.GetBrokerConsistency)
+	admin.Get("/product-types/reconcile", h.ReconcileProductTypes)
+	admin.Post("/product-types/reconcile", h.ReconcileProductTypes)
+	admin.Get("/integrity", h.GetIntegrity)
+	admin.Get("/data-quality", h.GetDataQuality)
+	admin.Get("/jobs", h.GetJobs)
+	admin.Get("/jobs/:id", h.GetJob)
+	admin.Post("/jobs/:id/resume", h.ResumeJob)
+	admin.Get("/migrations", h.ListMigrations)
+	admin.Post("/migrations", h.RunMigrations)
+	admin.Get("/maintenance", h.GetMaintenance)
+	admin.Put("/maintenance", h.SetMaintenance)
+}
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupAuditRoutes(app *fiber.App, h *handlers.Handler, auth fiber.Handler) {
+	app.Get("/audit", auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant, h.GetAudit)
+}
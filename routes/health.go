@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupHealthRoutes(app *fiber.App, h *handlers.Handler) {
+	app.Get("/healthz", h.Liveness)
+	app.Get("/readyz", h.Readiness)
+}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupMasterDataRoutes(app *fiber.App, cfg config.Config, h *handlers.Handler, auth fiber.Handler, maintenance *middleware.Maintenance, idempotency fiber.Handler) {
+	clientCert := middleware.RequireClientCert(cfg.TLS.ClientCAFile != "")
+	bodyLimit := middleware.BodyLimit(cfg.HTTP.MaxBodyBytes)
+
+	groups := app.Group("/groups", maintenance.Guard(), auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	groups.Get("/", h.GetGroups)
+	groups.Get("/:key", h.GetGroup)
+	groups.Post("/", clientCert, bodyLimit, h.CreateGroup)
+	groups.Put("/:key", clientCert, bodyLimit, h.UpdateGroup)
+	groups.Delete("/:key", clientCert, h.DeleteGroup)
+
+	// Whole-group operations: bundles for promoting groups between
+	// environments, and merges.
+	bundles := app.Group("/product-groups", maintenance.Guard(), auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	bundles.Get("/:key/export", h.ExportGroup)
+	bundles.Post("/import", clientCert, bodyLimit, idempotency, h.ImportGroups)
+	bundles.Post("/:key/merge-into/:targetKey", clientCert, idempotency, h.MergeGroup)
+
+	types := app.Group("/product-types", maintenance.Guard(), auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	types.Get("/", h.GetProductTypes)
+	types.Get("/:key", h.GetProductType)
+	types.Post("/", clientCert, bodyLimit, h.CreateProductType)
+	types.Put("/:key", clientCert, bodyLimit, h.UpdateProductType)
+	types.Delete("/:key", clientCert, h.DeleteProductType)
+
+	brokers := app.Group("/brokers", maintenance.Guard(), auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	brokers.Get("/", h.GetBrokers)
+	brokers.Get("/:key", h.GetBroker)
+	brokers.Post("/", clientCert, bodyLimit, h.CreateBroker)
+	brokers.Put("/:key", clientCert, bodyLimit, h.UpdateBroker)
+	brokers.Delete("/:key", clientCert, h.DeleteBroker)
+
+	insurers := app.Group("/insurers", maintenance.Guard(), auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	insurers.Get("/", h.GetInsurers)
+	insurers.Get("/:code", h.GetInsurer)
+	insurers.Post("/", clientCert, bodyLimit, h.CreateInsurer)
+	insurers.Put("/:code", clientCert, bodyLimit, h.UpdateInsurer)
+	insurers.Delete("/:code", clientCert, h.DeleteInsurer)
+}
@@ -1,10 +1,46 @@
 package routes
 
 import (
+	"github.com/MaMaTidarat/poc-app/config"
+	"github.com/MaMaTidarat/poc-app/database"
 	"github.com/MaMaTidarat/poc-app/handlers"
+	"github.com/MaMaTidarat/poc-app/middleware"
 	"github.com/gofiber/fiber/v2"
 )
 
-func SetupRoutes(app *fiber.App) {
-	app.Get("/products", handlers.GetProducts)
+func SetupRoutes(app *fiber.App, cfg config.Config, h *handlers.Handler, auth fiber.Handler, maintenance *middleware.Maintenance) {
+	clientCert := middleware.RequireClientCert(cfg.TLS.ClientCAFile != "")
+	// The probes come before the request budget middleware: a deadline
+	// header must not fail a liveness check.
+	setupHealthRoutes(app, h)
+	app.Use(h.CheckDeadline, h.RequestContext)
+
+	setupAdminRoutes(app, h, auth, clientCert)
+	setupAuditRoutes(app, h, auth)
+	// Applied to the POSTs that write product data, the bulk group import
+	// included.
+	idempotency := middleware.Idempotency(database.IdempotencyCollection)
+	setupMasterDataRoutes(app, cfg, h, auth, maintenance, idempotency)
+
+	bodyLimit := middleware.BodyLimit(cfg.HTTP.MaxBodyBytes)
+
+	products := app.Group("/products", maintenance.Guard(), auth, middleware.Authorize(middleware.AccessRules), h.ResolveTenant)
+	// Get routes HEAD too; this must come first to take it.
+	products.Head("/", h.HeadProducts)
+	products.Get("/", h.GetProducts)
+	products.Get("/export", h.ExportProducts)
+	products.Get("/stats", h.GetProductStats)
+	products.Get("/compare", h.CompareProducts)
+	products.Get("/schedules", h.GetSchedules)
+	products.Get("/:id", h.GetProductByID)
+	products.Get("/:id/history/diff", h.GetProductHistoryDiff)
+	products.Get("/:id/related", h.GetRelatedProducts)
+	products.Get("/:id/brokers", h.GetProductBrokers)
+	products.Post("/", clientCert, bodyLimit, idempotency, h.CreateProduct)
+	products.Post("/validate", clientCert, bodyLimit, h.ValidateProducts)
+	products.Put("/:id", clientCert, bodyLimit, h.UpdateProduct)
+	products.Post("/:id/schedules", clientCert, bodyLimit, idempotency, h.CreateSchedule)
+	products.Delete("/:id/schedules/:scheduleId", clientCert, h.DeleteSchedule)
+	products.Post("/:id/brokers", clientCert, bodyLimit, idempotency, h.AddProductBroker)
+	products.Delete("/:id/brokers/:key", clientCert, h.RemoveProductBroker)
 }
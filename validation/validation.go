@@ -0,0 +1,234 @@
+// Package validation checks request DTOs against rules declared in
+// `validate` struct tags, collecting every violation instead of stopping at
+// the first.
+//
+// Supported rules, comma-separated:
+//
+//	required        value must not be the zero value (empty string, nil slice)
+//	max=N           string length or slice size must not exceed N
+//	oneof=A B C     string must equal one of the space-separated values
+//	pattern=name    string must match the pattern registered under name
+//	dive            validate each element of a slice of structs
+//	name            a rule registered with RegisterRule
+//
+// Empty optional strings skip oneof, pattern and registered rules; combine
+// with required to forbid them.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a single violated rule. Field is the JSON path of the
+// offending value, e.g. "brokers[1].key".
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors is every violation found in a value.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	patternsMu sync.RWMutex
+	patterns   = map[string]*regexp.Regexp{}
+	rules      = map[string]Rule{}
+)
+
+// Rule checks one value against a registered rule, returning the message
+// of the violation and false when it fails.
+type Rule func(v reflect.Value) (message string, ok bool)
+
+// RegisterRule makes rule available under name. Names of the built-in rules
+// cannot be reused.
+func RegisterRule(name string, rule Rule) {
+	switch name {
+	case "required", "max", "oneof", "pattern", "dive":
+		panic("validation: " + name + " is a built-in rule")
+	}
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	rules[name] = rule
+}
+
+// RegisterPattern makes re available to the pattern=name rule.
+func RegisterPattern(name string, re *regexp.Regexp) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	patterns[name] = re
+}
+
+// Struct validates v, which must be a struct or a pointer to one, and
+// returns nil when it satisfies every rule.
+func Struct(v interface{}) error {
+	var errs Errors
+	walk(reflect.Indirect(reflect.ValueOf(v)), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func walk(v reflect.Value, prefix string, errs *Errors) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		field := v.Field(i)
+		path := joinPath(prefix, jsonName(sf))
+		rules := sf.Tag.Get("validate")
+
+		for _, rule := range splitRules(rules) {
+			if rule == "dive" {
+				continue
+			}
+			if fe, ok := check(field, path, rule); !ok {
+				*errs = append(*errs, fe)
+			}
+		}
+
+		switch field.Kind() {
+		case reflect.Struct:
+			walk(field, path, errs)
+		case reflect.Ptr:
+			if !field.IsNil() {
+				walk(field.Elem(), path, errs)
+			}
+		case reflect.Slice:
+			if strings.Contains(rules, "dive") {
+				for j := 0; j < field.Len(); j++ {
+					walk(reflect.Indirect(field.Index(j)), fmt.Sprintf("%s[%d]", path, j), errs)
+				}
+			}
+		}
+	}
+}
+
+func check(v reflect.Value, path, rule string) (FieldError, bool) {
+	name, arg, _ := strings.Cut(rule, "=")
+	fail := func(msg string) (FieldError, bool) {
+		return FieldError{Field: path, Rule: name, Message: msg}, false
+	}
+
+	switch name {
+	case "required":
+		if v.IsZero() || (v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "") {
+			return fail("is required")
+		}
+	case "max":
+		n, _ := strconv.Atoi(arg)
+		switch v.Kind() {
+		case reflect.String:
+			if utf8.RuneCountInString(v.String()) > n {
+				return fail(fmt.Sprintf("must be at most %d characters", n))
+			}
+		case reflect.Slice:
+			if v.Len() > n {
+				return fail(fmt.Sprintf("must contain at most %d items", n))
+			}
+		}
+	case "oneof":
+		if v.Kind() == reflect.String && v.String() != "" {
+			allowed := strings.Fields(arg)
+			for _, a := range allowed {
+				if v.String() == a {
+					return FieldError{}, true
+				}
+			}
+			return fail("must be one of " + strings.Join(allowed, ", "))
+		}
+	case "pattern":
+		if v.Kind() == reflect.String && v.String() != "" {
+			patternsMu.RLock()
+			re := patterns[arg]
+			patternsMu.RUnlock()
+			if re == nil {
+				panic("validation: unknown pattern " + arg)
+			}
+			if !re.MatchString(v.String()) {
+				return fail("must match " + re.String())
+			}
+		}
+	default:
+		patternsMu.RLock()
+		rule := rules[name]
+		patternsMu.RUnlock()
+		if rule == nil {
+			panic("validation: unknown rule " + name)
+		}
+		if v.Kind() == reflect.String && v.String() == "" {
+			break
+		}
+		if msg, ok := rule(v); !ok {
+			return fail(msg)
+		}
+	}
+	return FieldError{}, true
+}
+
+func splitRules(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// ErrUnknownField is wrapped by DecodeJSON when the body contains a field
+// the target struct does not declare.
+var ErrUnknownField = errors.New("unknown field")
+
+// DecodeJSON strictly decodes body into v: unknown fields and trailing data
+// are rejected.
+func DecodeJSON(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			return fmt.Errorf("%w %s", ErrUnknownField, strings.TrimPrefix(err.Error(), "json: unknown field "))
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON body")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterPattern("testCode", regexp.MustCompile(`^[A-Z]{3}$`))
+	RegisterRule("testEven", func(v reflect.Value) (string, bool) {
+		return "must have an even length", len(v.String())%2 == 0
+	})
+}
+
+type testBroker struct {
+	Key  string `json:"key" validate:"required,pattern=testCode"`
+	Name string `json:"name" validate:"max=3"`
+}
+
+type testInsurer struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type testProduct struct {
+	Name     string       `json:"name" validate:"required,max=5"`
+	Status   string       `json:"status" validate:"oneof=ACTIVE DRAFT"`
+	Code     string       `json:"code" validate:"pattern=testCode"`
+	Even     string       `json:"even" validate:"testEven"`
+	Tags     []string     `json:"tags" validate:"max=2"`
+	Insurer  testInsurer  `json:"insurer"`
+	Previous *testInsurer `json:"previous"`
+	Brokers  []testBroker `json:"brokers" validate:"dive"`
+	Untagged []testBroker `json:"untagged"`
+	Internal string       `validate:"required"`
+	hidden   string       `validate:"required"`
+}
+
+// valid returns a testProduct satisfying every rule.
+func valid() testProduct {
+	return testProduct{Name: "Plus", Internal: "x", Insurer: testInsurer{Code: "TIP"}}
+}
+
+// fieldErrors validates p and returns its violations as field: rule pairs.
+func fieldErrors(t *testing.T, p testProduct) []string {
+	t.Helper()
+	err := Struct(&p)
+	if err == nil {
+		return nil
+	}
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Struct returned %T, want Errors", err)
+	}
+	got := make([]string, len(errs))
+	for i, fe := range errs {
+		got[i] = fe.Field + ": " + fe.Rule
+	}
+	return got
+}
+
+func TestStructRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*testProduct)
+		want   []string
+	}{
+		{"valid", func(*testProduct) {}, nil},
+		{"required", func(p *testProduct) { p.Name = "" }, []string{"name: required"}},
+		{"required blank", func(p *testProduct) { p.Name = "   " }, []string{"name: required"}},
+		{"max runes", func(p *testProduct) { p.Name = "ประกัน" }, []string{"name: max"}},
+		{"max within runes", func(p *testProduct) { p.Name = "ประกั" }, nil},
+		{"max items", func(p *testProduct) { p.Tags = []string{"a", "b", "c"} }, []string{"tags: max"}},
+		{"oneof", func(p *testProduct) { p.Status = "active" }, []string{"status: oneof"}},
+		{"oneof allowed", func(p *testProduct) { p.Status = "DRAFT" }, nil},
+		{"pattern", func(p *testProduct) { p.Code = "TI" }, []string{"code: pattern"}},
+		{"pattern allowed", func(p *testProduct) { p.Code = "TIP" }, nil},
+		{"registered rule", func(p *testProduct) { p.Even = "abc" }, []string{"even: testEven"}},
+		{"registered rule allowed", func(p *testProduct) { p.Even = "ab" }, nil},
+		{"field without json name", func(p *testProduct) { p.Internal = "" }, []string{"Internal: required"}},
+		{"unexported field", func(p *testProduct) { p.hidden = "" }, nil},
+		{"nested struct", func(p *testProduct) { p.Insurer.Code = "" }, []string{"insurer.code: required"}},
+		{"nested pointer", func(p *testProduct) { p.Previous = &testInsurer{} }, []string{"previous.code: required"}},
+		{"nil pointer", func(p *testProduct) { p.Previous = nil }, nil},
+		{"dive", func(p *testProduct) {
+			p.Brokers = []testBroker{{Key: "ABC"}, {Key: "abc", Name: "Online"}}
+		}, []string{"brokers[1].key: pattern", "brokers[1].name: max"}},
+		{"no dive", func(p *testProduct) { p.Untagged = []testBroker{{}} }, nil},
+		{"every violation", func(p *testProduct) {
+			p.Name, p.Status, p.Brokers = "", "GONE", []testBroker{{}}
+		}, []string{"name: required", "status: oneof", "brokers[0].key: required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.modify(&p)
+			if got := fieldErrors(t, p); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOptionalStrings checks that empty strings skip oneof, pattern and
+// registered rules unless required.
+func TestOptionalStrings(t *testing.T) {
+	p := valid()
+	p.Status, p.Code, p.Even = "", "", ""
+	if got := fieldErrors(t, p); got != nil {
+		t.Errorf("violations = %q, want none", got)
+	}
+}
+
+func TestErrorsShape(t *testing.T) {
+	p := valid()
+	p.Name, p.Status, p.Code = "", "GONE", "x"
+	err := Struct(p)
+
+	want := "name: is required; status: must be one of ACTIVE, DRAFT; code: must match ^[A-Z]{3}$"
+	if err == nil || err.Error() != want {
+		t.Errorf("Error() = %v, want %q", err, want)
+	}
+	body, _ := json.Marshal(err)
+	wantJSON := `[{"field":"name","rule":"required","message":"is required"},` +
+		`{"field":"status","rule":"oneof","message":"must be one of ACTIVE, DRAFT"},` +
+		`{"field":"code","rule":"pattern","message":"must match ^[A-Z]{3}$"}]`
+	if string(body) != wantJSON {
+		t.Errorf("JSON = %s, want %s", body, wantJSON)
+	}
+
+	p = valid()
+	p.Name, p.Tags = "Health Plus", []string{"a", "b", "c"}
+	want = "name: must be at most 5 characters; tags: must contain at most 2 items"
+	if err := Struct(p); err == nil || err.Error() != want {
+		t.Errorf("Error() = %v, want %q", err, want)
+	}
+}
+
+func TestUnknownRulesPanic(t *testing.T) {
+	for name, v := range map[string]interface{}{
+		"pattern": struct {
+			A string `validate:"pattern=missing"`
+		}{"x"},
+		"rule": struct {
+			A string `validate:"missing"`
+		}{"x"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			Struct(v)
+		})
+	}
+	t.Run("built-in name", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("no panic")
+			}
+		}()
+		RegisterRule("required", func(reflect.Value) (string, bool) { return "", true })
+	})
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name, body string
+		wantErr    string
+		unknown    bool
+	}{
+		{"valid", `{"name":"Plus","brokers":[{"key":"ABC"}]}`, "", false},
+		{"unknown field", `{"name":"Plus","nmae":"x"}`, `unknown field "nmae"`, true},
+		{"nested unknown field", `{"brokers":[{"kee":"ABC"}]}`, `unknown field "kee"`, true},
+		{"trailing data", `{"name":"Plus"} {}`, "unexpected data after JSON body", false},
+		{"malformed", `{"name":`, "unexpected EOF", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p testProduct
+			err := DecodeJSON([]byte(tt.body), &p)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("DecodeJSON = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DecodeJSON = %v, want %q", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrUnknownField) != tt.unknown {
+				t.Errorf("errors.Is(%v, ErrUnknownField) = %t, want %t", err, !tt.unknown, tt.unknown)
+			}
+		})
+	}
+}